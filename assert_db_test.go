@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dbStateOptions はAssertDBStateで比較しない項目です
+type dbStateOptions struct {
+	ignoreIDs bool
+}
+
+// DBStateOption はAssertDBStateの比較方法を変更するオプションです
+type DBStateOption func(*dbStateOptions)
+
+// IgnoreIDs はidを比較しないオプションです。並行して追加した商品のようにidが決まらない場合に使います。
+// Stockは作成日時などの時刻を持たないため、時刻は常に比較されません
+func IgnoreIDs() DBStateOption {
+	return func(o *dbStateOptions) { o.ignoreIDs = true }
+}
+
+// AssertDBState はstocksテーブルの全行が期待した状態と一致することを確認します。
+// expectedの順序は問いません。一致しない場合は行ごとの不足・余分・不一致をテストの失敗として報告します
+func AssertDBState(t testing.TB, db *sql.DB, expected []Stock, opts ...DBStateOption) bool {
+	t.Helper()
+	var o dbStateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	actual, err := readStocksOrderedByName(db)
+	if err != nil {
+		t.Errorf("stocksテーブルの読み込みエラー: %v", err)
+		return false
+	}
+	want := slices.Clone(expected)
+	sort.Slice(want, func(i, j int) bool { return want[i].Name < want[j].Name })
+
+	diff := DiffStocks(want, actual)
+	if o.ignoreIDs {
+		diff.IDDrift = nil
+	}
+	if diff.Empty() {
+		return true
+	}
+	t.Error(renderDBStateDiff(diff, len(want), len(actual)))
+	return false
+}
+
+// readStocksOrderedByName はstocksテーブルの全行を名前順に読み込みます
+func readStocksOrderedByName(db *sql.DB) ([]Stock, error) {
+	rows, err := db.Query(queryStocksOrderedByName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stocks []Stock
+	for rows.Next() {
+		var s Stock
+		if err := rows.Scan(&s.ID, &s.Name, &s.Amount); err != nil {
+			return nil, err
+		}
+		stocks = append(stocks, s)
+	}
+	return stocks, rows.Err()
+}
+
+// renderDBStateDiff はAssertDBStateの失敗メッセージを組み立てます。期待値を比較元、DBを比較先とした差分を受け取ります
+func renderDBStateDiff(diff SnapshotDiff, expectedRows, actualRows int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "stocksテーブルが期待した状態と一致しません (期待 %d行, 実際 %d行)\n", expectedRows, actualRows)
+	for _, s := range diff.Missing {
+		fmt.Fprintf(&b, "  不足: %s (id=%d, amount=%d)\n", s.Name, s.ID, s.Amount)
+	}
+	for _, s := range diff.Extra {
+		fmt.Fprintf(&b, "  余分: %s (id=%d, amount=%d)\n", s.Name, s.ID, s.Amount)
+	}
+	for _, m := range diff.AmountMismatches {
+		fmt.Fprintf(&b, "  不一致: %s amount 期待=%d 実際=%d\n", m.Name, m.Source.Amount, m.Target.Amount)
+	}
+	for _, m := range diff.IDDrift {
+		fmt.Fprintf(&b, "  不一致: %s id 期待=%d 実際=%d\n", m.Name, m.Source.ID, m.Target.ID)
+	}
+	return b.String()
+}
+
+// AssertAuditTrail は指定商品の変更履歴（stock_log）の操作が記録順にexpectedOpsと一致することを確認します。
+// 変更履歴はauditLogEnabledが有効な間の書き込みだけが記録されます
+func AssertAuditTrail(t testing.TB, db *sql.DB, name string, expectedOps []string) bool {
+	t.Helper()
+	rows, err := db.Query("SELECT operation FROM stock_log WHERE name = ? ORDER BY id;", name)
+	if err != nil {
+		t.Errorf("変更履歴の読み込みエラー: %v", err)
+		return false
+	}
+	defer rows.Close()
+
+	ops := []string{}
+	for rows.Next() {
+		var op string
+		if err := rows.Scan(&op); err != nil {
+			t.Errorf("変更履歴の読み込みエラー: %v", err)
+			return false
+		}
+		ops = append(ops, op)
+	}
+	if err := rows.Err(); err != nil {
+		t.Errorf("変更履歴の読み込みエラー: %v", err)
+		return false
+	}
+	if expectedOps == nil {
+		expectedOps = []string{}
+	}
+	return assert.Equal(t, expectedOps, ops, "%sの変更履歴の操作が一致するべき", name)
+}
+
+// recordingTB は失敗メッセージを記録するtesting.TBです。ヘルパーが失敗を報告することのテストに使います
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Error(args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprint(args...))
+}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// expectStocksByName はAssertDBStateが読み込む名前順の在庫データを期待値として設定します
+func expectStocksByName(mock sqlmock.Sqlmock, stocks ...Stock) {
+	mock.ExpectQuery(orderedStocksRegex).WillReturnRows(newStockRows(stocks...))
+}
+
+// TestRenderDBStateDiff はAssertDBStateの失敗メッセージがgoldenファイルと一致することをテストします
+func TestRenderDBStateDiff(t *testing.T) {
+	diff := DiffStocks(
+		[]Stock{{ID: 1, Name: "apple", Amount: 100}, {ID: 2, Name: "banana", Amount: 50}, {ID: 4, Name: "grape", Amount: 5}},
+		[]Stock{{ID: 1, Name: "apple", Amount: 90}, {ID: 3, Name: "cherry", Amount: 75}, {ID: 9, Name: "grape", Amount: 5}},
+	)
+
+	golden, err := os.ReadFile("testdata/db_state_diff.golden")
+	require.NoError(t, err)
+	assert.Equal(t, string(golden), renderDBStateDiff(diff, 3, 3))
+}
+
+// TestAssertDBState は一致する場合は成功し、一致しない場合は差分を報告することをテストします
+func TestAssertDBState(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStocksByName(mock, Stock{ID: 1, Name: "apple", Amount: 100}, Stock{ID: 2, Name: "banana", Amount: 50})
+	assert.True(t, AssertDBState(t, db, []Stock{
+		{ID: 2, Name: "banana", Amount: 50},
+		{ID: 1, Name: "apple", Amount: 100},
+	}), "順序によらず一致するべき")
+
+	expectStocksByName(mock, Stock{ID: 7, Name: "apple", Amount: 100})
+	assert.True(t, AssertDBState(t, db, []Stock{{Name: "apple", Amount: 100}}, IgnoreIDs()), "IgnoreIDsではidを比較しないべき")
+
+	rec := &recordingTB{TB: t}
+	expectStocksByName(mock, Stock{ID: 7, Name: "apple", Amount: 90})
+	assert.False(t, AssertDBState(rec, db, []Stock{{ID: 1, Name: "apple", Amount: 100}}, IgnoreIDs()))
+	assert.Equal(t, []string{
+		"stocksテーブルが期待した状態と一致しません (期待 1行, 実際 1行)\n  不一致: apple amount 期待=100 実際=90\n",
+	}, rec.errors, "在庫数の不一致はIgnoreIDsでも報告するべき")
+	verifyExpectations(t, mock)
+}
+
+// TestAssertAuditTrail は変更履歴の操作を記録順に比較することをテストします
+func TestAssertAuditTrail(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	query := `SELECT operation FROM stock_log WHERE name = \? ORDER BY id;`
+	mock.ExpectQuery(query).WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"operation"}).AddRow(operationInsert).AddRow(operationUpdate))
+	assert.True(t, AssertAuditTrail(t, db, "apple", []string{operationInsert, operationUpdate}))
+
+	rec := &recordingTB{TB: t}
+	mock.ExpectQuery(query).WithArgs("banana").WillReturnRows(sqlmock.NewRows([]string{"operation"}))
+	assert.False(t, AssertAuditTrail(rec, db, "banana", []string{operationInsert}), "記録がない場合は失敗するべき")
+	assert.Len(t, rec.errors, 1)
+	verifyExpectations(t, mock)
+}
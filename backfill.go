@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// defaultBackfillBatchSize はBackfillHistoryで1回に処理する既定の商品数です。
+const defaultBackfillBatchSize = 500
+
+// backfillCandidates は、stocksのid範囲のうち変更履歴が1件もない商品を絞り込む条件です。
+// 変更履歴の有無で判定するため、再実行や中断後の再開で起点の行が重複しません。
+const backfillCandidates = "FROM stocks s WHERE s.id > ? AND s.id <= ? AND NOT EXISTS (SELECT 1 FROM stock_log l WHERE l.name = s.name)"
+
+// BackfillOptions はBackfillHistoryWithOptionsのオプションです。
+type BackfillOptions struct {
+	// BatchSize は1回に処理する商品数です。0以下の場合はdefaultBackfillBatchSizeを使います。
+	BatchSize int
+	// DryRun がtrueの場合、記録する行数を数えるだけでstock_logには書き込みません。
+	DryRun bool
+	// Progress はバッチごとに呼ばれます。
+	Progress func(BackfillProgress)
+}
+
+// BackfillProgress はBackfillHistoryの進捗です。件数はいずれも開始からの累計です。
+type BackfillProgress struct {
+	Batch int
+	// LastID は処理済みの最後のstocksのidです。
+	LastID int64
+	// Scanned は確認した商品数です。
+	Scanned int
+	// Recorded は起点の行を記録した（DryRunでは記録する）商品数です。
+	Recorded int64
+}
+
+// BackfillHistory は変更履歴が1件もない商品ごとに、現在の在庫数を起点とするinitialの行をstock_logへ記録し、
+// 記録した行数を返します。変更量は0、記録日時はstocksにcreated_at列があればその値、なければ現在時刻です。
+// 変更履歴を有効にする前から存在する商品も、期間の集計などで在庫数の推移を追えるようにするために使います。
+// stocksはidの順にbatchSize件ずつ処理し、中断しても再実行すれば未処理の商品から記録されます。
+func BackfillHistory(ctx context.Context, db *sql.DB, batchSize int) (int64, error) {
+	return BackfillHistoryWithOptions(ctx, db, BackfillOptions{BatchSize: batchSize})
+}
+
+// BackfillHistoryWithOptions はオプションを指定してBackfillHistoryを実行します。
+func BackfillHistoryWithOptions(ctx context.Context, db *sql.DB, opts BackfillOptions) (int64, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBackfillBatchSize
+	}
+	if !opts.DryRun {
+		if err := checkWritable(); err != nil {
+			return 0, err
+		}
+	}
+
+	live, err := liveStockColumns(ctx, db)
+	if err != nil {
+		return 0, fmt.Errorf("列定義の確認エラー: %w", err)
+	}
+	recordedAt := "CURRENT_TIMESTAMP(6)"
+	if live["created_at"] {
+		recordedAt = "s.created_at"
+	}
+	insert := "INSERT INTO stock_log (name, operation, delta, amount, created_at) SELECT s.name, '" + operationInitial + "', 0, s.amount, " +
+		recordedAt + " " + backfillCandidates + ";"
+
+	var progress BackfillProgress
+	for {
+		if err := ctx.Err(); err != nil {
+			return progress.Recorded, err
+		}
+		firstID := progress.LastID
+		lastID, scanned, err := nextBackfillBatch(ctx, db, firstID, batchSize)
+		if err != nil {
+			return progress.Recorded, err
+		}
+		if scanned == 0 {
+			return progress.Recorded, nil
+		}
+
+		var recorded int64
+		if opts.DryRun {
+			err = db.QueryRowContext(ctx, "SELECT COUNT(*) "+backfillCandidates+";", firstID, lastID).Scan(&recorded)
+		} else {
+			var result sql.Result
+			if result, err = db.ExecContext(ctx, insert, firstID, lastID); err == nil {
+				recorded, err = result.RowsAffected()
+			}
+		}
+		if err != nil {
+			return progress.Recorded, fmt.Errorf("id %d以降の変更履歴の記録に失敗しました: %w", firstID, err)
+		}
+
+		progress.Batch++
+		progress.LastID = lastID
+		progress.Scanned += scanned
+		progress.Recorded += recorded
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+}
+
+// nextBackfillBatch はafterIDより後のstocksをidの順に最大limit件確認し、最後のidと件数を返します。
+func nextBackfillBatch(ctx context.Context, db *sql.DB, afterID int64, limit int) (int64, int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id FROM stocks WHERE id > ? ORDER BY id LIMIT ?;", afterID, limit)
+	if err != nil {
+		return 0, 0, fmt.Errorf("在庫データの取得エラー: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	lastID, count := afterID, 0
+	for rows.Next() {
+		if err := rows.Scan(&lastID); err != nil {
+			return 0, 0, err
+		}
+		count++
+	}
+	return lastID, count, rows.Err()
+}
+
+// runBackfillHistoryCommand はbackfill-historyサブコマンドを実行します。
+// 使い方: backfill-history [--batch-size N] [--dry-run]
+func runBackfillHistoryCommand(ctx context.Context, w io.Writer, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("backfill-history", flag.ContinueOnError)
+	fs.SetOutput(w)
+	batchSize := fs.Int("batch-size", defaultBackfillBatchSize, "1回に処理する商品数")
+	dryRun := fs.Bool("dry-run", false, "記録する行数を数えるだけで書き込まない")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := BackfillOptions{
+		BatchSize: *batchSize,
+		DryRun:    *dryRun,
+		Progress: func(p BackfillProgress) {
+			fmt.Fprintf(w, "バッチ %d: id %dまで確認 (確認 %d件, 記録 %d件)\n", p.Batch, p.LastID, p.Scanned, p.Recorded)
+		},
+	}
+	recorded, err := BackfillHistoryWithOptions(ctx, db, opts)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(w, "dry-run: %d件の商品に起点の変更履歴を記録します\n", recorded)
+		return nil
+	}
+	fmt.Fprintf(w, "%d件の商品に起点の変更履歴を記録しました\n", recorded)
+	return nil
+}
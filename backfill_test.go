@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const backfillInsertPattern = `INSERT INTO stock_log \(name, operation, delta, amount, created_at\) SELECT s.name, 'initial', 0, s.amount, `
+
+// expectBackfillIDs はafterIDより後のstocksのidを確認するクエリを期待値として設定します
+func expectBackfillIDs(mock sqlmock.Sqlmock, afterID int64, limit int, ids ...int64) {
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range ids {
+		rows.AddRow(id)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM stocks WHERE id > ? ORDER BY id LIMIT ?;")).
+		WithArgs(afterID, limit).
+		WillReturnRows(rows)
+}
+
+// expectBackfillInsert はid範囲の商品に起点の行を記録するINSERTを期待値として設定します
+func expectBackfillInsert(mock sqlmock.Sqlmock, firstID, lastID int64, recorded int64) *sqlmock.ExpectedExec {
+	return mock.ExpectExec(backfillInsertPattern+`CURRENT_TIMESTAMP\(6\) FROM stocks s WHERE s.id > \? AND s.id <= \? AND NOT EXISTS \(SELECT 1 FROM stock_log l WHERE l.name = s.name\);`).
+		WithArgs(firstID, lastID).
+		WillReturnResult(sqlmock.NewResult(0, recorded))
+}
+
+// TestBackfillHistory_Batches はidの順にバッチごとに記録し、進捗を通知することをテストします
+func TestBackfillHistory_Batches(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLiveColumns(mock, "id", "name", "amount", "category")
+	expectBackfillIDs(mock, 0, 2, 1, 2)
+	expectBackfillInsert(mock, 0, 2, 2)
+	expectBackfillIDs(mock, 2, 2, 5, 7)
+	expectBackfillInsert(mock, 2, 7, 1)
+	expectBackfillIDs(mock, 7, 2, 9)
+	expectBackfillInsert(mock, 7, 9, 1)
+	expectBackfillIDs(mock, 9, 2)
+
+	var progress []BackfillProgress
+	recorded, err := BackfillHistoryWithOptions(context.Background(), db, BackfillOptions{
+		BatchSize: 2,
+		Progress:  func(p BackfillProgress) { progress = append(progress, p) },
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), recorded)
+	assert.Equal(t, []BackfillProgress{
+		{Batch: 1, LastID: 2, Scanned: 2, Recorded: 2},
+		{Batch: 2, LastID: 7, Scanned: 4, Recorded: 3},
+		{Batch: 3, LastID: 9, Scanned: 5, Recorded: 4},
+	}, progress)
+	verifyExpectations(t, mock)
+}
+
+// TestBackfillHistory_CreatedAt はstocksにcreated_at列があればその値を記録日時にすることをテストします
+func TestBackfillHistory_CreatedAt(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLiveColumns(mock, "id", "name", "amount", "category", "created_at")
+	expectBackfillIDs(mock, 0, defaultBackfillBatchSize, 1)
+	mock.ExpectExec(backfillInsertPattern+`s.created_at FROM stocks s`).
+		WithArgs(0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectBackfillIDs(mock, 1, defaultBackfillBatchSize)
+
+	recorded, err := BackfillHistory(context.Background(), db, 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), recorded)
+	verifyExpectations(t, mock)
+}
+
+// TestBackfillHistory_Idempotent は記録済みの商品しかない場合の再実行で何も記録しないことをテストします
+func TestBackfillHistory_Idempotent(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// 変更履歴のある商品はNOT EXISTSで除外されるため、INSERTは0行になる
+	expectLiveColumns(mock, "id", "name", "amount", "category")
+	expectBackfillIDs(mock, 0, 10, 1, 2, 3)
+	expectBackfillInsert(mock, 0, 3, 0)
+	expectBackfillIDs(mock, 3, 10)
+
+	recorded, err := BackfillHistory(context.Background(), db, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), recorded)
+	verifyExpectations(t, mock)
+}
+
+// TestBackfillHistory_DryRun はdry-runでは記録する行数を数えるだけで書き込まないことをテストします
+func TestBackfillHistory_DryRun(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLiveColumns(mock, "id", "name", "amount", "category")
+	expectBackfillIDs(mock, 0, 2, 1, 2)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) "+backfillCandidates+";")).
+		WithArgs(0, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	expectBackfillIDs(mock, 2, 2)
+
+	recorded, err := BackfillHistoryWithOptions(context.Background(), db, BackfillOptions{BatchSize: 2, DryRun: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), recorded)
+	verifyExpectations(t, mock)
+}
+
+// TestBackfillHistory_ResumeAfterFailure は途中のバッチで失敗しても、再実行で未処理の商品から記録されることをテストします
+func TestBackfillHistory_ResumeAfterFailure(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// 1回目: 2バッチ目の記録に失敗する
+	expectLiveColumns(mock, "id", "name", "amount", "category")
+	expectBackfillIDs(mock, 0, 2, 1, 2)
+	expectBackfillInsert(mock, 0, 2, 2)
+	expectBackfillIDs(mock, 2, 2, 3, 4)
+	expectBackfillInsert(mock, 2, 4, 0).WillReturnError(errors.New("connection lost"))
+
+	recorded, err := BackfillHistory(context.Background(), db, 2)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "id 2以降")
+	assert.Equal(t, int64(2), recorded, "失敗するまでに記録した行数を返すべき")
+
+	// 2回目: 記録済みの1バッチ目は0行になり、2バッチ目から記録される
+	expectLiveColumns(mock, "id", "name", "amount", "category")
+	expectBackfillIDs(mock, 0, 2, 1, 2)
+	expectBackfillInsert(mock, 0, 2, 0)
+	expectBackfillIDs(mock, 2, 2, 3, 4)
+	expectBackfillInsert(mock, 2, 4, 2)
+	expectBackfillIDs(mock, 4, 2)
+
+	recorded, err = BackfillHistory(context.Background(), db, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), recorded)
+	verifyExpectations(t, mock)
+}
+
+// TestBackfillHistory_MaintenanceMode はメンテナンスモード中はdry-runを除いて記録しないことをテストします
+func TestBackfillHistory_MaintenanceMode(t *testing.T) {
+	withMaintenanceMode(t, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	_, err := BackfillHistory(context.Background(), db, 10)
+
+	assert.True(t, errors.Is(err, ErrMaintenanceMode))
+	verifyExpectations(t, mock)
+}
+
+// TestRunBackfillHistoryCommand はbackfill-historyサブコマンドの出力をテストします
+func TestRunBackfillHistoryCommand(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLiveColumns(mock, "id", "name", "amount", "category")
+	expectBackfillIDs(mock, 0, 2, 1, 2)
+	expectBackfillInsert(mock, 0, 2, 2)
+	expectBackfillIDs(mock, 2, 2)
+
+	var buf bytes.Buffer
+	err := runBackfillHistoryCommand(context.Background(), &buf, db, []string{"--batch-size", "2"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "バッチ 1: id 2まで確認 (確認 2件, 記録 2件)\n2件の商品に起点の変更履歴を記録しました\n", buf.String())
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBatcherClosed はClose後のBatchingRepositoryに在庫の更新を依頼した場合に返されます。
+var ErrBatcherClosed = errors.New("バッチ処理は終了しています")
+
+// BulkStockRepository は一括更新に対応したStockRepositoryです。
+type BulkStockRepository interface {
+	StockRepository
+	BulkUpsertStocks(ctx context.Context, items []StockUpdate, opts ...UpsertOption) (BulkResult, error)
+}
+
+// batchClock はバッチの待ち時間を計るタイマーを作成します。テストでは時刻を進められる実装に差し替えます。
+type batchClock interface {
+	AfterFunc(d time.Duration, f func()) batchTimer
+}
+
+// batchTimer は開始したタイマーを止めるためのインターフェースです。*time.Timerが満たします。
+type batchTimer interface {
+	Stop() bool
+}
+
+// realBatchClock はtime.AfterFuncを使うbatchClockです。
+type realBatchClock struct{}
+
+func (realBatchClock) AfterFunc(d time.Duration, f func()) batchTimer {
+	return time.AfterFunc(d, f)
+}
+
+// pendingStock はバッチに溜まっている1商品分の変更量と、結果を待っている呼び出し元です。
+type pendingStock struct {
+	amount  int
+	waiters []chan error
+}
+
+// stockBatch はまとめてBulkUpsertStocksに渡す在庫変更です。
+type stockBatch struct {
+	names   []string
+	pending map[string]*pendingStock
+}
+
+// BatchingRepository は短時間に集中するUpsertStockをまとめて1回のBulkUpsertStocksで適用するStockRepositoryです。
+// 最初の変更からwindowが経過するか、溜まった変更がmaxSize件に達した時点で商品名ごとに変更量を合算して適用し、
+// 各呼び出し元にはその商品の適用結果を返します。呼び出し元は結果が確定するまで待ちます。
+// UpsertOptionを指定した呼び出しはまとめずにそのまま委譲します。
+type BatchingRepository struct {
+	repo    BulkStockRepository
+	window  time.Duration
+	maxSize int
+	clock   batchClock
+
+	mu    sync.Mutex
+	batch *stockBatch
+	calls int
+	timer batchTimer
+	// gen はバッチを取り出すたびに進め、古いタイマーが次のバッチを適用しないようにします
+	gen    int
+	closed bool
+}
+
+// NewBatchingRepository はrepoへのUpsertStockを最大window待ってまとめるBatchingRepositoryを返します。
+// maxSizeが1未満の場合は件数による適用を行いません。
+func NewBatchingRepository(repo BulkStockRepository, window time.Duration, maxSize int) *BatchingRepository {
+	return &BatchingRepository{repo: repo, window: window, maxSize: maxSize, clock: realBatchClock{}}
+}
+
+// Ping はDBへの接続を確認します。
+func (r *BatchingRepository) Ping(ctx context.Context) error {
+	return r.repo.Ping(ctx)
+}
+
+// QueryStocks は在庫データを取得します。まだ適用されていない変更は含まれません。
+func (r *BatchingRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	return r.repo.QueryStocks(ctx, name)
+}
+
+// EnsureSchema はテーブルを作成します。
+func (r *BatchingRepository) EnsureSchema(ctx context.Context) error {
+	return r.repo.EnsureSchema(ctx)
+}
+
+// UpsertStock は在庫の変更をバッチに加え、バッチが適用されるまで待ってその商品の結果を返します。
+// 待機中にctxがキャンセルされた場合は、受け付けた変更を失わないようにその時点のバッチを直ちに適用し、
+// その結果を返します。
+func (r *BatchingRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	if len(opts) > 0 {
+		return r.repo.UpsertStock(ctx, name, amount, opts...)
+	}
+
+	done, full, err := r.enqueue(name, amount)
+	if err != nil {
+		return err
+	}
+	if full != nil {
+		// バッチには他の呼び出し元の変更も含まれるため、このctxのキャンセルでは中断しない
+		r.apply(context.WithoutCancel(ctx), full)
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	// 呼び出し元はキャンセルされても、受け付けた変更は適用してから結果を返す
+	r.Flush(context.WithoutCancel(ctx))
+	return <-done
+}
+
+// enqueue は変更をバッチに加え、結果を受け取るチャネルを返します。
+// 件数が上限に達した場合は、呼び出し元が適用すべきバッチも返します。
+func (r *BatchingRepository) enqueue(name string, amount int) (chan error, *stockBatch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, nil, ErrBatcherClosed
+	}
+	if r.batch == nil {
+		r.batch = &stockBatch{pending: make(map[string]*pendingStock)}
+		gen := r.gen
+		r.timer = r.clock.AfterFunc(r.window, func() {
+			if batch := r.take(gen); batch != nil {
+				r.apply(context.Background(), batch)
+			}
+		})
+	}
+
+	p, ok := r.batch.pending[name]
+	if !ok {
+		p = &pendingStock{}
+		r.batch.pending[name] = p
+		r.batch.names = append(r.batch.names, name)
+	}
+	p.amount += amount
+	done := make(chan error, 1)
+	p.waiters = append(p.waiters, done)
+	r.calls++
+
+	if r.maxSize > 0 && r.calls >= r.maxSize {
+		return done, r.takeLocked(), nil
+	}
+	return done, nil, nil
+}
+
+// take は溜まっているバッチを取り出します。genが0以上の場合は、そのタイマーが開始したバッチのときだけ取り出します。
+func (r *BatchingRepository) take(gen int) *stockBatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if gen >= 0 && gen != r.gen {
+		return nil
+	}
+	return r.takeLocked()
+}
+
+// takeLocked はr.muを保持した状態でバッチを取り出し、タイマーを止めます。
+func (r *BatchingRepository) takeLocked() *stockBatch {
+	batch := r.batch
+	if batch == nil {
+		return nil
+	}
+	r.timer.Stop()
+	r.batch, r.timer, r.calls = nil, nil, 0
+	r.gen++
+	return batch
+}
+
+// apply はバッチをBulkUpsertStocksで適用し、各呼び出し元に結果を返します。
+// 一括更新全体が失敗した場合はすべての呼び出し元に、拒否された商品はその商品の呼び出し元にだけエラーを返します。
+func (r *BatchingRepository) apply(ctx context.Context, batch *stockBatch) {
+	items := make([]StockUpdate, 0, len(batch.names))
+	for _, name := range batch.names {
+		items = append(items, StockUpdate{Name: name, Amount: batch.pending[name].amount})
+	}
+
+	result, err := r.repo.BulkUpsertStocks(ctx, items)
+	failures := make(map[string]error, len(result.Failures))
+	for _, f := range result.Failures {
+		failures[f.Name] = f.Err
+	}
+	for _, name := range batch.names {
+		itemErr := err
+		if itemErr == nil {
+			itemErr = failures[name]
+		}
+		for _, done := range batch.pending[name].waiters {
+			done <- itemErr
+		}
+	}
+}
+
+// Flush は溜まっている変更を直ちに適用します。個々の変更の結果はそれぞれの呼び出し元に返されます。
+func (r *BatchingRepository) Flush(ctx context.Context) {
+	if batch := r.take(-1); batch != nil {
+		r.apply(ctx, batch)
+	}
+}
+
+// Close は以降の変更の受け付けを止め、溜まっている変更を適用します。委譲先のリポジトリは閉じません。
+func (r *BatchingRepository) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	r.Flush(context.Background())
+	return nil
+}
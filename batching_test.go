@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBatchClock は明示的に時刻を進めるまでタイマーを発火しないbatchClockです
+type fakeBatchClock struct {
+	mu     sync.Mutex
+	now    time.Duration
+	timers []*fakeBatchTimer
+}
+
+type fakeBatchTimer struct {
+	clock   *fakeBatchClock
+	at      time.Duration
+	f       func()
+	stopped bool
+}
+
+func (c *fakeBatchClock) AfterFunc(d time.Duration, f func()) batchTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeBatchTimer{clock: c, at: c.now + d, f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance は時刻をd進め、期限を迎えたタイマーの関数を呼び出します
+func (c *fakeBatchClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now += d
+	var due []func()
+	remaining := c.timers[:0]
+	for _, timer := range c.timers {
+		switch {
+		case timer.stopped:
+		case timer.at <= c.now:
+			due = append(due, timer.f)
+		default:
+			remaining = append(remaining, timer)
+		}
+	}
+	c.timers = remaining
+	c.mu.Unlock()
+
+	for _, f := range due {
+		f()
+	}
+}
+
+func (t *fakeBatchTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+// fakeBulkRepository はBulkUpsertStocksの呼び出しを記録するBulkStockRepositoryです
+type fakeBulkRepository struct {
+	*fakeStockRepository
+	mu       sync.Mutex
+	batches  [][]StockUpdate
+	rejected map[string]error
+	bulkErr  error
+}
+
+func newFakeBulkRepository() *fakeBulkRepository {
+	return &fakeBulkRepository{fakeStockRepository: &fakeStockRepository{stocks: map[string]int{}}}
+}
+
+func (f *fakeBulkRepository) BulkUpsertStocks(ctx context.Context, items []StockUpdate, opts ...UpsertOption) (BulkResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batches = append(f.batches, append([]StockUpdate(nil), items...))
+	if f.bulkErr != nil {
+		return BulkResult{}, f.bulkErr
+	}
+	var result BulkResult
+	for _, item := range items {
+		if err, ok := f.rejected[item.Name]; ok {
+			result.Rejected++
+			result.Failures = append(result.Failures, ItemFailure{Name: item.Name, Err: err})
+			continue
+		}
+		f.stocks[item.Name] += item.Amount
+		result.Updated++
+	}
+	return result, nil
+}
+
+func (f *fakeBulkRepository) recordedBatches() [][]StockUpdate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]StockUpdate(nil), f.batches...)
+}
+
+// newTestBatchingRepository はfakeBatchClockを使うBatchingRepositoryを返します
+func newTestBatchingRepository(repo BulkStockRepository, window time.Duration, maxSize int) (*BatchingRepository, *fakeBatchClock) {
+	clock := &fakeBatchClock{}
+	r := NewBatchingRepository(repo, window, maxSize)
+	r.clock = clock
+	return r, clock
+}
+
+// pendingCalls はバッチに溜まっている呼び出しの数を返します
+func (r *BatchingRepository) pendingCalls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+// upsertAsync はUpsertStockを別のgoroutineで呼び出し、バッチに加わるまで待ってから結果のチャネルを返します
+func upsertAsync(t *testing.T, ctx context.Context, r *BatchingRepository, name string, amount int) <-chan error {
+	t.Helper()
+	before := r.pendingCalls()
+	result := make(chan error, 1)
+	go func() {
+		result <- r.UpsertStock(ctx, name, amount)
+	}()
+	assert.Eventually(t, func() bool { return r.pendingCalls() > before }, time.Second, time.Millisecond, "変更がバッチに加わるべき")
+	return result
+}
+
+// receive は結果のチャネルから値を受け取ります。一定時間内に受け取れない場合はテストを失敗させます
+func receive(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("UpsertStockが結果を返すべき")
+		return nil
+	}
+}
+
+// TestBatchingRepository_WindowFlush は最初の変更からwindowが経過した時点でまとめて適用することをテストします
+func TestBatchingRepository_WindowFlush(t *testing.T) {
+	fake := newFakeBulkRepository()
+	repo, clock := newTestBatchingRepository(fake, 20*time.Millisecond, 100)
+
+	ctx := context.Background()
+	first := upsertAsync(t, ctx, repo, "apple", 1)
+	clock.Advance(10 * time.Millisecond)
+	second := upsertAsync(t, ctx, repo, "banana", 2)
+
+	clock.Advance(9 * time.Millisecond)
+	assert.Empty(t, fake.recordedBatches(), "windowが経過するまでは適用しないべき")
+
+	clock.Advance(time.Millisecond)
+	assert.NoError(t, receive(t, first))
+	assert.NoError(t, receive(t, second))
+	assert.Equal(t, [][]StockUpdate{{{Name: "apple", Amount: 1}, {Name: "banana", Amount: 2}}}, fake.recordedBatches())
+}
+
+// TestBatchingRepository_SizeFlush は溜まった変更が上限に達した時点でwindowを待たずに適用することをテストします
+func TestBatchingRepository_SizeFlush(t *testing.T) {
+	fake := newFakeBulkRepository()
+	repo, clock := newTestBatchingRepository(fake, time.Hour, 3)
+
+	ctx := context.Background()
+	first := upsertAsync(t, ctx, repo, "apple", 1)
+	second := upsertAsync(t, ctx, repo, "banana", 1)
+	assert.NoError(t, repo.UpsertStock(ctx, "cherry", 1), "上限に達した呼び出しはすぐに結果を返すべき")
+	assert.NoError(t, receive(t, first))
+	assert.NoError(t, receive(t, second))
+	assert.Len(t, fake.recordedBatches(), 1)
+
+	// 適用済みのバッチのタイマーは次のバッチを適用しない
+	next := upsertAsync(t, ctx, repo, "apple", 1)
+	clock.Advance(time.Hour - time.Millisecond)
+	assert.Len(t, fake.recordedBatches(), 1, "次のバッチはそのwindowが経過するまで適用しないべき")
+	clock.Advance(time.Millisecond)
+	assert.NoError(t, receive(t, next))
+	assert.Len(t, fake.recordedBatches(), 2)
+}
+
+// TestBatchingRepository_MergesPerName は同じ商品への変更量を合算して1件として適用することをテストします
+func TestBatchingRepository_MergesPerName(t *testing.T) {
+	fake := newFakeBulkRepository()
+	fake.stocks["apple"] = 10
+	repo, clock := newTestBatchingRepository(fake, 20*time.Millisecond, 100)
+
+	ctx := context.Background()
+	results := []<-chan error{
+		upsertAsync(t, ctx, repo, "apple", 5),
+		upsertAsync(t, ctx, repo, "banana", 3),
+		upsertAsync(t, ctx, repo, "apple", -8),
+		upsertAsync(t, ctx, repo, "apple", 2),
+	}
+	clock.Advance(20 * time.Millisecond)
+	for _, result := range results {
+		assert.NoError(t, receive(t, result))
+	}
+
+	assert.Equal(t, [][]StockUpdate{{{Name: "apple", Amount: -1}, {Name: "banana", Amount: 3}}}, fake.recordedBatches(),
+		"商品名ごとに最初に受け付けた順で合算するべき")
+	assert.Equal(t, 9, fake.stocks["apple"])
+	assert.Equal(t, 3, fake.stocks["banana"])
+}
+
+// TestBatchingRepository_ErrorFanOut は拒否された商品の呼び出し元にだけエラーを返すことをテストします
+func TestBatchingRepository_ErrorFanOut(t *testing.T) {
+	fake := newFakeBulkRepository()
+	fake.rejected = map[string]error{"bad": ErrInvalidName}
+	repo, clock := newTestBatchingRepository(fake, 20*time.Millisecond, 100)
+
+	ctx := context.Background()
+	good := upsertAsync(t, ctx, repo, "apple", 1)
+	bad1 := upsertAsync(t, ctx, repo, "bad", 1)
+	bad2 := upsertAsync(t, ctx, repo, "bad", 2)
+	clock.Advance(20 * time.Millisecond)
+
+	assert.NoError(t, receive(t, good), "拒否されていない商品の呼び出し元は成功するべき")
+	assert.True(t, errors.Is(receive(t, bad1), ErrInvalidName), "拒否された商品の呼び出し元にエラーを返すべき")
+	assert.True(t, errors.Is(receive(t, bad2), ErrInvalidName), "同じ商品のすべての呼び出し元にエラーを返すべき")
+}
+
+// TestBatchingRepository_BulkError は一括更新全体が失敗した場合にすべての呼び出し元へエラーを返すことをテストします
+func TestBatchingRepository_BulkError(t *testing.T) {
+	fake := newFakeBulkRepository()
+	fake.bulkErr = errors.New("connection lost")
+	repo, clock := newTestBatchingRepository(fake, 20*time.Millisecond, 100)
+
+	ctx := context.Background()
+	first := upsertAsync(t, ctx, repo, "apple", 1)
+	second := upsertAsync(t, ctx, repo, "banana", 1)
+	clock.Advance(20 * time.Millisecond)
+
+	assert.Equal(t, fake.bulkErr, receive(t, first))
+	assert.Equal(t, fake.bulkErr, receive(t, second))
+}
+
+// TestBatchingRepository_CloseFlushes は終了時に溜まっている変更を適用し、以降の変更を受け付けないことをテストします
+func TestBatchingRepository_CloseFlushes(t *testing.T) {
+	fake := newFakeBulkRepository()
+	repo, _ := newTestBatchingRepository(fake, time.Hour, 100)
+
+	ctx := context.Background()
+	pending := upsertAsync(t, ctx, repo, "apple", 4)
+	assert.NoError(t, repo.Close())
+
+	assert.NoError(t, receive(t, pending), "終了時に溜まっている変更を適用するべき")
+	assert.Equal(t, 4, fake.stocks["apple"])
+	assert.True(t, errors.Is(repo.UpsertStock(ctx, "apple", 1), ErrBatcherClosed), "終了後の変更は受け付けないべき")
+}
+
+// TestBatchingRepository_ContextCanceled は待機中にcontextがキャンセルされても受け付けた変更を適用してから返すことをテストします
+func TestBatchingRepository_ContextCanceled(t *testing.T) {
+	fake := newFakeBulkRepository()
+	repo, _ := newTestBatchingRepository(fake, time.Hour, 100)
+
+	other := upsertAsync(t, context.Background(), repo, "banana", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := upsertAsync(t, ctx, repo, "apple", 2)
+	cancel()
+
+	assert.NoError(t, receive(t, canceled), "受け付けた変更は適用してから結果を返すべき")
+	assert.NoError(t, receive(t, other), "同じバッチの他の呼び出し元にも結果を返すべき")
+	assert.Equal(t, map[string]int{"apple": 2, "banana": 1}, fake.stocks)
+}
+
+// TestBatchingRepository_OptionsBypassBatch はUpsertOptionを指定した呼び出しをまとめずに委譲することをテストします
+func TestBatchingRepository_OptionsBypassBatch(t *testing.T) {
+	fake := newFakeBulkRepository()
+	repo, _ := newTestBatchingRepository(fake, time.Hour, 100)
+
+	assert.NoError(t, repo.UpsertStock(context.Background(), "apple", 1, ForceLargeChange()))
+
+	assert.Empty(t, fake.recordedBatches())
+	assert.Equal(t, 1, fake.stocks["apple"])
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded はmainProcess全体の処理時間の上限（--max-duration）を超えた場合に返されます。
+var ErrBudgetExceeded = errors.New("処理時間の上限を超えました")
+
+// BudgetExceededError は上限を超えた時点で実行していた段階と上限です。
+// errors.Is(err, ErrBudgetExceeded)で判定でき、元のエラー（context.DeadlineExceededなど）も保持します。
+type BudgetExceededError struct {
+	Phase  string
+	Budget time.Duration
+	Err    error
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%v: %sの途中で上限 %s に達しました: %v", ErrBudgetExceeded, e.Phase, e.Budget, e.Err)
+}
+
+func (e *BudgetExceededError) Unwrap() []error {
+	return []error{ErrBudgetExceeded, e.Err}
+}
+
+// mainProcessの段階。上限を超えた場合にどの段階で時間を使い切ったかを示します
+const (
+	phasePing   = "ping"
+	phaseQuery  = "query"
+	phaseUpsert = "upsert"
+	phaseSchema = "schema"
+)
+
+// phaseKey は段階の記録先をcontextに保持するためのキーです。
+type phaseKey struct{}
+
+// phaseMarker はmainProcessが現在実行している段階を記録します。
+type phaseMarker struct {
+	mu    sync.Mutex
+	phase string
+}
+
+// withPhaseMarker は段階の記録先を設定したctxを返します。
+func withPhaseMarker(ctx context.Context) (context.Context, *phaseMarker) {
+	marker := &phaseMarker{}
+	return context.WithValue(ctx, phaseKey{}, marker), marker
+}
+
+// markPhase はctxの記録先に現在の段階を記録します。記録先がない場合は何もしません。
+func markPhase(ctx context.Context, phase string) {
+	if marker, ok := ctx.Value(phaseKey{}).(*phaseMarker); ok {
+		marker.mu.Lock()
+		marker.phase = phase
+		marker.mu.Unlock()
+	}
+}
+
+// current は最後に記録された段階を返します。
+func (m *phaseMarker) current() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.phase
+}
+
+// WithMaxDuration はmainProcess全体の処理時間の上限を設定します。0以下の場合は上限なしです。
+// 上限はcontextの期限として全操作に渡り、超えた場合はBudgetExceededErrorを返します。
+// ロールバックやロックの解放などの後始末は上限とは独立したcleanupContextで行うため、上限を超えてもロックやトランザクションは残りません。
+func WithMaxDuration(d time.Duration) ProcessOption {
+	return func(o *processOptions) {
+		o.maxDuration = d
+	}
+}
+
+// cleanupContext はロックの解放などの後始末に使う、呼び出し元のキャンセルや期限から独立したcontextを返します。
+// 後始末が応答しないDBで止まり続けないよう、cleanupGracePeriodで打ち切ります。
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupGracePeriod)
+}
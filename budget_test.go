@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowStockRepository は指定した段階の操作に遅延を入れるテスト用のStockRepositoryです。
+// 遅延の途中でctxが終了した場合はctx.Err()を返します
+type slowStockRepository struct {
+	*fakeStockRepository
+	delays map[string]time.Duration
+}
+
+// wait は段階phaseの遅延だけ待ちます
+func (r *slowStockRepository) wait(ctx context.Context, phase string) error {
+	select {
+	case <-time.After(r.delays[phase]):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *slowStockRepository) Ping(ctx context.Context) error {
+	if err := r.wait(ctx, phasePing); err != nil {
+		return err
+	}
+	return r.fakeStockRepository.Ping(ctx)
+}
+
+func (r *slowStockRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	if err := r.wait(ctx, phaseQuery); err != nil {
+		return nil, err
+	}
+	return r.fakeStockRepository.QueryStocks(ctx, name)
+}
+
+func (r *slowStockRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	if err := r.wait(ctx, phaseUpsert); err != nil {
+		return err
+	}
+	return r.fakeStockRepository.UpsertStock(ctx, name, amount, opts...)
+}
+
+// TestMainProcess_MaxDuration は上限を超えた段階がBudgetExceededErrorに記録され、それ以降の操作が行われないことをテストします
+func TestMainProcess_MaxDuration(t *testing.T) {
+	for _, phase := range []string{phasePing, phaseQuery, phaseUpsert} {
+		t.Run(phase, func(t *testing.T) {
+			fake := &fakeStockRepository{stocks: map[string]int{"apple": 100}}
+			repo := &slowStockRepository{fakeStockRepository: fake, delays: map[string]time.Duration{phase: time.Second}}
+
+			err := mainProcess(context.Background(), io.Discard, repo, "apple", 50, WithMaxDuration(20*time.Millisecond))
+
+			var budgetErr *BudgetExceededError
+			if assert.ErrorAs(t, err, &budgetErr, "BudgetExceededErrorが返るべき") {
+				assert.Equal(t, phase, budgetErr.Phase, "上限を使い切った段階が記録されるべき")
+				assert.Equal(t, 20*time.Millisecond, budgetErr.Budget)
+			}
+			assert.ErrorIs(t, err, ErrBudgetExceeded)
+			assert.ErrorIs(t, err, context.DeadlineExceeded, "元のエラーも保持するべき")
+			assert.Equal(t, 100, fake.stocks["apple"], "在庫は変更されないべき")
+		})
+	}
+}
+
+// TestMainProcess_MaxDurationNotExceeded は上限内に終わった場合や上限なしの場合は通常どおり処理することをテストします
+func TestMainProcess_MaxDurationNotExceeded(t *testing.T) {
+	for _, budget := range []time.Duration{0, time.Second} {
+		fake := &fakeStockRepository{stocks: map[string]int{"apple": 100}}
+
+		err := mainProcess(context.Background(), io.Discard, fake, "apple", 50, WithMaxDuration(budget))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 150, fake.stocks["apple"])
+	}
+}
+
+// TestMainProcess_MaxDurationParentCancelled は呼び出し元のキャンセルは上限超過として扱わないことをテストします
+func TestMainProcess_MaxDurationParentCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	repo := &slowStockRepository{fakeStockRepository: &fakeStockRepository{stocks: map[string]int{}}, delays: map[string]time.Duration{phasePing: time.Second}}
+
+	err := mainProcess(ctx, io.Discard, repo, "apple", 50, WithMaxDuration(time.Second))
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrBudgetExceeded, "呼び出し元のキャンセルは上限超過ではないべき")
+}
+
+// TestMainProcess_MaxDurationUpsertRollback は更新の途中で上限を超えた場合もトランザクションがロールバックされることをテストします
+func TestMainProcess_MaxDurationUpsertRollback(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "apple", 100, defaultCategory))
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 150).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	err := mainProcess(context.Background(), io.Discard, NewSQLStockRepository(db), "apple", 50, WithMaxDuration(50*time.Millisecond))
+
+	var budgetErr *BudgetExceededError
+	if assert.ErrorAs(t, err, &budgetErr) {
+		assert.Equal(t, phaseUpsert, budgetErr.Phase)
+	}
+	// database/sqlはctxの終了後に非同期でロールバックするため、完了を待って確認する
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond,
+		"上限を超えてもロールバックされるべき")
+}
+
+// TestMainProcess_MaxDurationSchemaReleasesLock はスキーマの作成中に上限を超えた場合もマイグレーションのロックを解放することをテストします
+func TestMainProcess_MaxDurationSchemaReleasesLock(t *testing.T) {
+	original := autoMigrate
+	autoMigrate = true
+	t.Cleanup(func() { autoMigrate = original })
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnError(noSuchTableError())
+	expectMigrationLock(mock)
+	mock.ExpectExec(regexp.QuoteMeta(schemaStatements[0])).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectMigrationUnlock(mock)
+
+	err := mainProcess(context.Background(), io.Discard, NewSQLStockRepository(db), "apple", 50, WithMaxDuration(50*time.Millisecond))
+
+	var budgetErr *BudgetExceededError
+	if assert.ErrorAs(t, err, &budgetErr) {
+		assert.Equal(t, phaseSchema, budgetErr.Phase)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet(), "上限を超えてもRELEASE_LOCKは実行されるべき")
+}
+
+// TestReleaseLock_CleanupGracePeriod はロックの解放がcleanupGracePeriodで打ち切られることをテストします
+func TestReleaseLock_CleanupGracePeriod(t *testing.T) {
+	original := cleanupGracePeriod
+	cleanupGracePeriod = 20 * time.Millisecond
+	t.Cleanup(func() { cleanupGracePeriod = original })
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationLock(mock)
+	mock.ExpectQuery(`SELECT RELEASE_LOCK\(\?\);`).
+		WithArgs(migrationLockName).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(1))
+
+	start := time.Now()
+	err := withMigrationLock(context.Background(), db, func() error { return nil })
+
+	assert.Error(t, err, "解放が打ち切られた場合はエラーを返すべき")
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "応答しないDBで止まり続けないべき")
+}
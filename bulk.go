@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"db_moc/internal/stmt"
+)
+
+// 一括更新で行ロックを取りながら既存の在庫数を確認するSQL文
+var stmtStockAmountForUpdate = stmt.SelectAmountByNameForUpdate(stocksTable, sqlDialect)
+
+// StockUpdate は一括更新で適用する1件分の在庫変更です。
+type StockUpdate struct {
+	Name   string
+	Amount int
+}
+
+// ItemFailure は一括更新で適用されなかった1件分の商品名と理由です。
+type ItemFailure struct {
+	Name string
+	Err  error
+}
+
+// BulkResult は一括更新の結果です。
+type BulkResult struct {
+	Inserted int
+	Updated  int
+	// Rejected は商品名の検証などで適用しなかった行数です。
+	Rejected int
+	Failures []ItemFailure
+}
+
+// merge は別のバッチの結果を加算します。
+func (r *BulkResult) merge(other BulkResult) {
+	r.Inserted += other.Inserted
+	r.Updated += other.Updated
+	r.Rejected += other.Rejected
+	r.Failures = append(r.Failures, other.Failures...)
+}
+
+// BulkUpsertStocks は複数の在庫変更を1つのトランザクションで適用します。
+// 商品名の検証に失敗した行は適用せずRejectedとして数え、残りの行の適用を続けます。
+// DBエラーが発生した場合は全体をロールバックします。
+func BulkUpsertStocks(db *sql.DB, items []StockUpdate, opts ...UpsertOption) (BulkResult, error) {
+	return BulkUpsertStocksContext(context.Background(), db, items, opts...)
+}
+
+// BulkUpsertStocksContext はBulkUpsertStocksのcontext対応版です。
+func BulkUpsertStocksContext(ctx context.Context, db *sql.DB, items []StockUpdate, opts ...UpsertOption) (BulkResult, error) {
+	if err := checkWritable(); err != nil {
+		return BulkResult{}, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return BulkResult{}, fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+	defer tx.Rollback() // エラー発生時にロールバック
+
+	result, err := applyStockUpdates(ctx, tx, items, activeNameRules.NewBudget(), newUpsertOptions(opts))
+	if err != nil {
+		return BulkResult{}, err
+	}
+	if err := bumpGeneration(ctx, tx); err != nil {
+		return BulkResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return BulkResult{}, fmt.Errorf("トランザクションコミットエラー: %w", err)
+	}
+	return result, nil
+}
+
+// applyStockUpdates はトランザクション内で在庫変更を順に適用します。
+// 検証エラーは行ごとにFailuresへ記録し、DBエラーの場合のみエラーを返します。
+func applyStockUpdates(ctx context.Context, tx *sql.Tx, items []StockUpdate, budget *NameBudget, opts upsertOptions) (BulkResult, error) {
+	var result BulkResult
+	for _, item := range items {
+		inserted, err := upsertStockTx(ctx, tx, item.Name, item.Amount, budget, opts)
+		if err != nil {
+			if isRejection(err) {
+				result.Rejected++
+				result.Failures = append(result.Failures, ItemFailure{Name: item.Name, Err: err})
+				continue
+			}
+			return BulkResult{}, err
+		}
+		if inserted {
+			result.Inserted++
+		} else {
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// isRejection は行単位で拒否すべきエラー（DBエラーではないもの）かどうかを判定します。
+func isRejection(err error) bool {
+	return errors.Is(err, ErrInvalidName) || errors.Is(err, ErrNameRejected) || errors.Is(err, ErrSuspiciousChange) ||
+		errors.Is(err, ErrQuotaExceeded)
+}
+
+// upsertStockTx はトランザクション内で1件の在庫を加算または挿入します。
+// 挿入した場合はtrueを返します。新規の商品名はbudgetに計上されます。
+func upsertStockTx(ctx context.Context, tx *sql.Tx, name string, amount int, budget *NameBudget, opts upsertOptions) (bool, error) {
+	if err := ValidateName(name); err != nil {
+		return false, err
+	}
+
+	var existingAmount int
+	err := queryRowTx(ctx, tx, taggedSQL(ctx, "BulkUpsertStocks", stmtStockAmountForUpdate.SQL), name).Scan(&existingAmount)
+	switch {
+	case err == sql.ErrNoRows:
+		if err := checkStockChange(name, 0, amount, false, opts); err != nil {
+			return false, err
+		}
+		if err := checkItemQuota(ctx, tx, name); err != nil {
+			return false, err
+		}
+		if err := budget.AdmitNew(name); err != nil {
+			return false, err
+		}
+		if _, err := execTx(ctx, tx, taggedSQL(ctx, "BulkUpsertStocks", stmtInsertStock.SQL), name, amount); err != nil {
+			return false, fmt.Errorf("データ挿入エラー: %w", err)
+		}
+		if err := recordStockLog(ctx, tx, name, operationInsert, amount, amount); err != nil {
+			return false, err
+		}
+		if err := updateRowChecksum(ctx, tx, name); err != nil {
+			return false, err
+		}
+		return true, recordStockTotal(ctx, tx, amount)
+	case err != nil:
+		return false, fmt.Errorf("データ確認中にエラーが発生: %w", err)
+	}
+
+	newAmount := existingAmount + amount
+	if err := checkStockChange(name, existingAmount, newAmount, true, opts); err != nil {
+		return false, err
+	}
+	if _, err := execTx(ctx, tx, taggedSQL(ctx, "BulkUpsertStocks", stmtUpdateAmount.SQL), newAmount, name); err != nil {
+		return false, fmt.Errorf("データ更新エラー: %w", err)
+	}
+	if err := recordStockLog(ctx, tx, name, operationUpdate, amount, newAmount); err != nil {
+		return false, err
+	}
+	if err := updateRowChecksum(ctx, tx, name); err != nil {
+		return false, err
+	}
+	return false, recordStockTotal(ctx, tx, amount)
+}
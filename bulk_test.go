@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectBulkInsert は一括更新での新規挿入1件分の期待値を設定します
+func expectBulkInsert(mock sqlmock.Sqlmock, name string, amount int) {
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE;`).
+		WithArgs(name).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+		WithArgs(name, amount).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectBulkUpdate は一括更新での既存商品の更新1件分の期待値を設定します
+func expectBulkUpdate(mock sqlmock.Sqlmock, name string, existing, amount int) {
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE;`).
+		WithArgs(name).
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(existing))
+	mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+		WithArgs(existing+amount, name).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestBulkUpsertStocks(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectBulkUpdate(mock, "apple", 100, 50)
+	expectBulkInsert(mock, "banana", 30)
+	mock.ExpectCommit()
+
+	result, err := BulkUpsertStocks(db, []StockUpdate{
+		{Name: "apple", Amount: 50},
+		{Name: "banana", Amount: 30},
+	})
+
+	assert.NoError(t, err, "一括更新は成功するべき")
+	assert.Equal(t, 1, result.Updated, "更新件数が正しいべき")
+	assert.Equal(t, 1, result.Inserted, "追加件数が正しいべき")
+	assert.Equal(t, 0, result.Rejected, "拒否件数は0であるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestBulkUpsertStocks_Rejected は検証に失敗した行が拒否件数として数えられることをテストします
+func TestBulkUpsertStocks_Rejected(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	rules, err := NewNameRules("", `(?i)^error`, 0)
+	assert.NoError(t, err, "ルールの生成は成功するべき")
+	withNameRules(t, rules)
+
+	mock.ExpectBegin()
+	expectBulkInsert(mock, "apple", 10)
+	mock.ExpectCommit()
+
+	result, err := BulkUpsertStocks(db, []StockUpdate{
+		{Name: "ERROR: connection refused", Amount: 1},
+		{Name: "apple", Amount: 10},
+		{Name: "", Amount: 1},
+	})
+
+	assert.NoError(t, err, "拒否された行があっても一括更新は成功するべき")
+	assert.Equal(t, 1, result.Inserted, "有効な行は適用されるべき")
+	assert.Equal(t, 2, result.Rejected, "拒否件数が計上されるべき")
+	if assert.Len(t, result.Failures, 2, "拒否された行が記録されるべき") {
+		assert.ErrorIs(t, result.Failures[0].Err, ErrNameRejected, "ルールによる拒否であるべき")
+		assert.ErrorIs(t, result.Failures[1].Err, ErrInvalidName, "不正な名前による拒否であるべき")
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestBulkUpsertStocks_NewNameBudget は新規商品名の上限を超えた行が拒否されることをテストします
+func TestBulkUpsertStocks_NewNameBudget(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	rules, err := NewNameRules("", "", 1)
+	assert.NoError(t, err, "ルールの生成は成功するべき")
+	withNameRules(t, rules)
+
+	mock.ExpectBegin()
+	expectBulkInsert(mock, "apple", 10)
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE;`).
+		WithArgs("banana").
+		WillReturnError(sql.ErrNoRows)
+	expectBulkUpdate(mock, "cherry", 5, 1)
+	mock.ExpectCommit()
+
+	result, err := BulkUpsertStocks(db, []StockUpdate{
+		{Name: "apple", Amount: 10},
+		{Name: "banana", Amount: 10},
+		{Name: "cherry", Amount: 1},
+	})
+
+	assert.NoError(t, err, "一括更新は成功するべき")
+	assert.Equal(t, 1, result.Inserted, "上限内の新規商品は追加されるべき")
+	assert.Equal(t, 1, result.Updated, "既存商品の更新は上限の影響を受けないべき")
+	assert.Equal(t, 1, result.Rejected, "上限を超えた新規商品は拒否されるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestBulkUpsertStocks_DBError はDBエラー時に全体がロールバックされることをテストします
+func TestBulkUpsertStocks_DBError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectBulkInsert(mock, "apple", 10)
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE;`).
+		WithArgs("banana").
+		WillReturnError(errors.New("lock wait timeout"))
+	mock.ExpectRollback()
+
+	_, err := BulkUpsertStocks(db, []StockUpdate{
+		{Name: "apple", Amount: 10},
+		{Name: "banana", Amount: 10},
+	})
+
+	assert.ErrorContains(t, err, "データ確認中にエラーが発生", "DBエラーが返るべき")
+	verifyExpectations(t, mock)
+}
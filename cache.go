@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// CachedTotal は在庫合計をバックグラウンドで定期的に更新し、最後に取得した値を返します。
+// ダッシュボードのようにリクエストごとにTotalStockAmountを呼ぶと無駄が大きい用途向けです。
+type CachedTotal struct {
+	db       *sql.DB
+	interval time.Duration
+
+	mu        sync.RWMutex
+	value     int
+	lastErr   error
+	updatedAt time.Time
+}
+
+// NewCachedTotal は初回の合計値を取得したうえで、intervalごとに値を更新するCachedTotalを返します。
+// ctxがキャンセルされるとバックグラウンドの更新は停止します。
+func NewCachedTotal(ctx context.Context, db *sql.DB, interval time.Duration) (*CachedTotal, error) {
+	c := &CachedTotal{db: db, interval: interval}
+	if err := c.Invalidate(); err != nil {
+		return nil, err
+	}
+	go c.run(ctx)
+	return c, nil
+}
+
+// run はctxがキャンセルされるまでintervalごとに合計値を更新します。
+func (c *CachedTotal) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// 更新に失敗した場合は直前の値を保持し、エラーはErrで参照できるようにする
+			_ = c.Invalidate()
+		}
+	}
+}
+
+// Value はキャッシュされている在庫合計を返します。
+func (c *CachedTotal) Value() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.value
+}
+
+// UpdatedAt は最後に合計値の取得に成功した時刻を返します。
+func (c *CachedTotal) UpdatedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.updatedAt
+}
+
+// Err は直近の更新で発生したエラーを返します。成功していればnilです。
+func (c *CachedTotal) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastErr
+}
+
+// Invalidate はインターバルを待たずに合計値を即座に再取得します。
+// 取得に失敗した場合はキャッシュ済みの値を変更せずにエラーを返します。
+func (c *CachedTotal) Invalidate() error {
+	total, err := TotalStockAmount(c.db)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastErr = err
+	if err != nil {
+		return err
+	}
+	c.value = total
+	c.updatedAt = time.Now()
+	return nil
+}
+
+// memoEntry はMemoizedQueryがキャッシュしている1商品分の検索結果です。
+type memoEntry struct {
+	results   []map[string]interface{}
+	expiresAt time.Time
+}
+
+// MemoizedQuery はQueryStocksの結果を商品名ごとにttlの間キャッシュするStockRepositoryです。
+// UpsertStockで書き込んだ商品名のキャッシュは破棄されるため、同じインスタンス経由の書き込みは次の検索に反映されます。
+// キャッシュは商品名ごとのシャードに分けてロックするため、異なる商品の検索と書き込みは互いを待ちません。
+// 検索中にInvalidateされた場合は、書き込み前の値の可能性があるため結果をキャッシュしません。
+// キャッシュにない商品名を同時に検索した呼び出しは、GetStockAmountと同じく実行中の1回の検索の結果を共有します。
+// キャッシュした結果は呼び出し元の間で共有されるため、変更しないでください。
+type MemoizedQuery struct {
+	repo   StockRepository
+	ttl    time.Duration
+	now    func() time.Time
+	shards []memoShard
+	// loads はキャッシュにない商品名の同時の検索を1回にまとめます。
+	loads *queryFlight
+}
+
+// memoShard は商品名で振り分けたキャッシュの1区画です。
+type memoShard struct {
+	mu      sync.Mutex
+	entries map[string]memoEntry
+	// generations は商品名ごとにInvalidateした回数です。検索の前後で変わっていれば結果をキャッシュしません。
+	generations map[string]uint64
+}
+
+// NewMemoizedQuery はrepoの検索結果をttlの間キャッシュするMemoizedQueryを返します。
+func NewMemoizedQuery(repo StockRepository, ttl time.Duration) *MemoizedQuery {
+	return newMemoizedQuery(repo, ttl, stockShardCount)
+}
+
+// newMemoizedQuery はshards個のシャードに振り分けるMemoizedQueryを返します。
+func newMemoizedQuery(repo StockRepository, ttl time.Duration, shards int) *MemoizedQuery {
+	m := &MemoizedQuery{repo: repo, ttl: ttl, now: time.Now, shards: make([]memoShard, shards), loads: newQueryFlight()}
+	for i := range m.shards {
+		m.shards[i].entries = make(map[string]memoEntry)
+		m.shards[i].generations = make(map[string]uint64)
+	}
+	return m
+}
+
+// shard はnameが属するシャードを返します。
+func (m *MemoizedQuery) shard(name string) *memoShard {
+	return &m.shards[shardIndex(name, len(m.shards))]
+}
+
+// Ping はDBへの接続を確認します。
+func (m *MemoizedQuery) Ping(ctx context.Context) error {
+	return m.repo.Ping(ctx)
+}
+
+// QueryStocks は有効期限内のキャッシュがあればそれを返し、なければ検索して結果をキャッシュします。
+// 同じ商品名の検索が実行中であればその結果を待ちます。Invalidateの後に始めた呼び出しは、Invalidateの前に始まった検索を待たずに検索し直します。
+// 検索に失敗した場合と、検索中にその商品名がInvalidateされた場合はキャッシュしません。
+func (m *MemoizedQuery) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	s := m.shard(name)
+	s.mu.Lock()
+	entry, ok := s.entries[name]
+	generation := s.generations[name]
+	s.mu.Unlock()
+	if ok && m.now().Before(entry.expiresAt) {
+		return entry.results, nil
+	}
+
+	// 世代をキーに含め、書き込み前に始まった検索の結果を書き込み後の呼び出しに返さない
+	value, err := m.loads.do(ctx, flightKey{name: name, generation: generation}, func(ctx context.Context) (interface{}, error) {
+		return m.repo.QueryStocks(ctx, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := value.([]map[string]interface{})
+	s.mu.Lock()
+	// 検索中に書き込まれていれば、読んだ結果は書き込み前の値の可能性がある
+	if s.generations[name] == generation {
+		s.entries[name] = memoEntry{results: results, expiresAt: m.now().Add(m.ttl)}
+	}
+	s.mu.Unlock()
+	return results, nil
+}
+
+// UpsertStock は在庫データを更新または挿入し、その商品名のキャッシュを破棄します。
+// 書き込みに失敗した場合も、途中まで反映された可能性があるためキャッシュを破棄します。
+func (m *MemoizedQuery) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	err := m.repo.UpsertStock(ctx, name, amount, opts...)
+	m.Invalidate(name)
+	return err
+}
+
+// EnsureSchema はテーブルを作成します。
+func (m *MemoizedQuery) EnsureSchema(ctx context.Context) error {
+	return m.repo.EnsureSchema(ctx)
+}
+
+// Invalidate は指定した商品名のキャッシュを破棄し、実行中の検索の結果もキャッシュされないようにします。
+func (m *MemoizedQuery) Invalidate(name string) {
+	s := m.shard(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, name)
+	s.generations[name]++
+}
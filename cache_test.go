@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCachedTotal_RefreshAfterInterval はインターバル経過後にキャッシュ値が更新されることをテストします
+func TestCachedTotal_RefreshAfterInterval(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(100))
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(150))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cached, err := NewCachedTotal(ctx, db, 20*time.Millisecond)
+	assert.NoError(t, err, "初回取得は成功するべき")
+	assert.Equal(t, 100, cached.Value(), "初回の合計値が返るべき")
+
+	assert.Eventually(t, func() bool {
+		return cached.Value() == 150
+	}, time.Second, 5*time.Millisecond, "インターバル経過後に値が更新されるべき")
+
+	cancel()
+	verifyExpectations(t, mock)
+}
+
+// TestCachedTotal_Invalidate は手動で再取得できることをテストします
+func TestCachedTotal_Invalidate(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(100))
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(300))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// インターバルを十分長くして自動更新が走らないようにする
+	cached, err := NewCachedTotal(ctx, db, time.Hour)
+	assert.NoError(t, err, "初回取得は成功するべき")
+	assert.Equal(t, 100, cached.Value(), "初回の合計値が返るべき")
+
+	assert.NoError(t, cached.Invalidate(), "再取得は成功するべき")
+	assert.Equal(t, 300, cached.Value(), "Invalidate後は最新の値が返るべき")
+	verifyExpectations(t, mock)
+}
+
+// TestCachedTotal_InvalidateError は再取得に失敗しても直前の値を保持することをテストします
+func TestCachedTotal_InvalidateError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(100))
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnError(errors.New("query error"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cached, err := NewCachedTotal(ctx, db, time.Hour)
+	assert.NoError(t, err, "初回取得は成功するべき")
+
+	assert.Error(t, cached.Invalidate(), "再取得エラーが返るべき")
+	assert.Error(t, cached.Err(), "直近のエラーが参照できるべき")
+	assert.Equal(t, 100, cached.Value(), "エラー時は直前の値を保持するべき")
+	verifyExpectations(t, mock)
+}
+
+// TestNewCachedTotal_InitialError は初回取得の失敗がエラーとして返ることをテストします
+func TestNewCachedTotal_InitialError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnError(errors.New("query error"))
+
+	cached, err := NewCachedTotal(context.Background(), db, time.Hour)
+	assert.Error(t, err, "初回取得エラーが返るべき")
+	assert.Nil(t, cached, "エラー時はnilが返るべき")
+	verifyExpectations(t, mock)
+}
+
+// countingStockRepository はQueryStocksの呼び出し回数を数えるStockRepositoryです
+type countingStockRepository struct {
+	*fakeStockRepository
+	queries int
+}
+
+func (c *countingStockRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	c.queries++
+	return c.fakeStockRepository.QueryStocks(ctx, name)
+}
+
+// TestMemoizedQuery_CacheHit は同じ商品名の2回目の検索でキャッシュが使われることをテストします
+func TestMemoizedQuery_CacheHit(t *testing.T) {
+	repo := &countingStockRepository{fakeStockRepository: &fakeStockRepository{stocks: map[string]int{"apple": 100}}}
+	memo := NewMemoizedQuery(repo, time.Minute)
+	ctx := context.Background()
+
+	first, err := memo.QueryStocks(ctx, "apple")
+	assert.NoError(t, err)
+	second, err := memo.QueryStocks(ctx, "apple")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, repo.queries, "2回目はキャッシュが使われるべき")
+	assert.Equal(t, first, second)
+
+	_, err = memo.QueryStocks(ctx, "banana")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, repo.queries, "別の商品名はキャッシュを使わないべき")
+}
+
+// TestMemoizedQuery_WriteInvalidates は書き込んだ商品名のキャッシュが破棄されることをテストします
+func TestMemoizedQuery_WriteInvalidates(t *testing.T) {
+	repo := &countingStockRepository{fakeStockRepository: &fakeStockRepository{stocks: map[string]int{"apple": 100, "banana": 5}}}
+	memo := NewMemoizedQuery(repo, time.Minute)
+	ctx := context.Background()
+
+	_, err := memo.QueryStocks(ctx, "apple")
+	assert.NoError(t, err)
+	_, err = memo.QueryStocks(ctx, "banana")
+	assert.NoError(t, err)
+	assert.NoError(t, memo.UpsertStock(ctx, "apple", 50))
+
+	results, err := memo.QueryStocks(ctx, "apple")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), results[0]["amount"], "書き込み後は最新の値を返すべき")
+	assert.Equal(t, 3, repo.queries)
+
+	_, err = memo.QueryStocks(ctx, "banana")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, repo.queries, "書き込んでいない商品名のキャッシュは残るべき")
+}
+
+// TestMemoizedQuery_TTL は有効期限を過ぎたキャッシュを使わないことをテストします
+func TestMemoizedQuery_TTL(t *testing.T) {
+	repo := &countingStockRepository{fakeStockRepository: &fakeStockRepository{stocks: map[string]int{"apple": 100}}}
+	memo := NewMemoizedQuery(repo, time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	memo.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := memo.QueryStocks(ctx, "apple")
+	assert.NoError(t, err)
+	now = now.Add(59 * time.Second)
+	_, err = memo.QueryStocks(ctx, "apple")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, repo.queries, "有効期限内はキャッシュが使われるべき")
+
+	now = now.Add(time.Second)
+	_, err = memo.QueryStocks(ctx, "apple")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, repo.queries, "有効期限を過ぎたら再検索するべき")
+}
+
+// TestMemoizedQuery_Concurrent は並行して検索と書き込みを行っても競合しないことをテストします
+func TestMemoizedQuery_Concurrent(t *testing.T) {
+	memo := NewMemoizedQuery(&fakeStockRepository{stocks: map[string]int{"apple": 1}}, time.Minute)
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := 0; j < 100; j++ {
+				_, _ = memo.QueryStocks(ctx, "apple")
+				memo.Invalidate("apple")
+			}
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+}
+
+// pausingStockRepository はQueryStocksを呼ぶとqueriedに通知し、releaseが閉じられるまで結果を返さないStockRepositoryです
+type pausingStockRepository struct {
+	*countingStockRepository
+	queried chan struct{}
+	release chan struct{}
+}
+
+func (b *pausingStockRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	results, err := b.countingStockRepository.QueryStocks(ctx, name)
+	b.queried <- struct{}{}
+	<-b.release
+	return results, err
+}
+
+// TestMemoizedQuery_InvalidateDuringQuery は検索中にInvalidateされた場合、書き込み前に読んだ結果をキャッシュしないことをテストします
+func TestMemoizedQuery_InvalidateDuringQuery(t *testing.T) {
+	fake := &fakeStockRepository{stocks: map[string]int{"apple": 100}}
+	repo := &pausingStockRepository{
+		countingStockRepository: &countingStockRepository{fakeStockRepository: fake},
+		queried:                 make(chan struct{}, 1),
+		release:                 make(chan struct{}),
+	}
+	memo := NewMemoizedQuery(repo, time.Minute)
+	ctx := context.Background()
+
+	stale := make(chan []map[string]interface{})
+	go func() {
+		results, _ := memo.QueryStocks(ctx, "apple")
+		stale <- results
+	}()
+	<-repo.queried
+
+	// 検索が古い値を読んだ後、キャッシュに書き込む前に別の経路で書き込まれる
+	fake.stocks["apple"] = 150
+	memo.Invalidate("apple")
+	close(repo.release)
+	assert.Equal(t, int64(100), (<-stale)[0]["amount"])
+
+	results, err := memo.QueryStocks(ctx, "apple")
+	<-repo.queried
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), results[0]["amount"], "Invalidate前に読んだ結果をキャッシュするべきではない")
+	assert.Equal(t, 2, repo.queries)
+}
+
+// TestMemoizedQuery_CoalesceMisses はキャッシュにない商品名の同時の検索が1回にまとめられることをテストします
+func TestMemoizedQuery_CoalesceMisses(t *testing.T) {
+	fake := &fakeStockRepository{stocks: map[string]int{"apple": 100}}
+	repo := &pausingStockRepository{
+		countingStockRepository: &countingStockRepository{fakeStockRepository: fake},
+		queried:                 make(chan struct{}, 1),
+		release:                 make(chan struct{}),
+	}
+	memo := NewMemoizedQuery(repo, time.Minute)
+	const callers = 10
+
+	var wg sync.WaitGroup
+	results := make([][]map[string]interface{}, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = memo.QueryStocks(context.Background(), "apple")
+		}(i)
+	}
+	<-repo.queried
+	waitForDups(t, memo.loads, flightKey{name: "apple"}, callers-1)
+	close(repo.release)
+	wg.Wait()
+
+	assert.Equal(t, 1, repo.queries, "同時の検索は1回にまとめるべき")
+	for _, r := range results {
+		assert.Equal(t, int64(100), r[0]["amount"])
+	}
+}
+
+// TestMemoizedQuery_CoalesceAfterInvalidate はInvalidateの後に始めた検索が、Invalidate前の検索の結果を共有しないことをテストします
+func TestMemoizedQuery_CoalesceAfterInvalidate(t *testing.T) {
+	fake := &fakeStockRepository{stocks: map[string]int{"apple": 100}}
+	repo := &pausingStockRepository{
+		countingStockRepository: &countingStockRepository{fakeStockRepository: fake},
+		queried:                 make(chan struct{}, 1),
+		release:                 make(chan struct{}),
+	}
+	memo := NewMemoizedQuery(repo, time.Minute)
+
+	stale := make(chan []map[string]interface{})
+	go func() {
+		results, _ := memo.QueryStocks(context.Background(), "apple")
+		stale <- results
+	}()
+	<-repo.queried
+
+	fake.stocks["apple"] = 150
+	memo.Invalidate("apple")
+	fresh := make(chan []map[string]interface{})
+	go func() {
+		results, _ := memo.QueryStocks(context.Background(), "apple")
+		fresh <- results
+	}()
+	<-repo.queried
+	close(repo.release)
+
+	assert.Equal(t, int64(100), (<-stale)[0]["amount"])
+	assert.Equal(t, int64(150), (<-fresh)[0]["amount"], "Invalidate後の検索は書き込み後の値を読むべき")
+	assert.Equal(t, 2, repo.queries)
+}
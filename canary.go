@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReplicationLagTimeout はカナリアの行がreplicationLagTimeoutの間にレプリカから見えなかった場合に返されます。
+var ErrReplicationLagTimeout = errors.New("レプリカでカナリアの行を確認できませんでした")
+
+// canaryTableDDL はレプリケーション遅延の計測に使う_canaryテーブルを作成するDDLです。
+const canaryTableDDL = `
+CREATE TABLE IF NOT EXISTS _canary (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    token CHAR(32) NOT NULL,
+    written_at TIMESTAMP(6) NOT NULL,
+    UNIQUE(token),
+    INDEX idx_canary_written_at (written_at)
+);`
+
+// lastReplicationLag は最後に計測したレプリケーション遅延です。未計測の場合はnilです。
+var lastReplicationLag atomic.Pointer[time.Duration]
+
+// ReplicationLag は最後にCheckReplicationLagで計測したレプリケーション遅延を返します。
+// 計測が打ち切られた場合はreplicationLagTimeoutを遅延の下限として記録します。未計測の場合はfalseを返します。
+func ReplicationLag() (time.Duration, bool) {
+	lag := lastReplicationLag.Load()
+	if lag == nil {
+		return 0, false
+	}
+	return *lag, true
+}
+
+// CheckReplicationLag はプライマリの_canaryテーブルに行を書き込み、レプリカから見えるまでの時間をレプリケーション遅延として返します。
+// レプリカはreplicationLagPollIntervalごとに確認し、replicationLagTimeoutを過ぎても見えない場合はErrReplicationLagTimeoutを返します。
+// 計測した遅延はReplicationLagで参照できます。書き込みのたびにcanaryRetentionより古いカナリアの行を削除します。
+func CheckReplicationLag(ctx context.Context, primary, replica *sql.DB) (time.Duration, error) {
+	token, err := newCanaryToken()
+	if err != nil {
+		return 0, err
+	}
+
+	writtenAt := time.Now()
+	if _, err := primary.ExecContext(ctx, "INSERT INTO _canary (token, written_at) VALUES (?, ?);", token, writtenAt.UTC()); err != nil {
+		return 0, fmt.Errorf("カナリアの書き込みエラー: %w", err)
+	}
+	if _, err := primary.ExecContext(ctx, "DELETE FROM _canary WHERE written_at < ?;", writtenAt.Add(-canaryRetention).UTC()); err != nil {
+		return 0, fmt.Errorf("古いカナリアの削除エラー: %w", err)
+	}
+
+	lag, err := waitForCanary(ctx, replica, token, writtenAt)
+	if errors.Is(err, ErrReplicationLagTimeout) {
+		recordReplicationLag(replicationLagTimeout)
+	}
+	if err != nil {
+		return 0, err
+	}
+	recordReplicationLag(lag)
+	return lag, nil
+}
+
+// waitForCanary はtokenの行がレプリカから見えるまで待ち、writtenAtからの経過時間を返します。
+func waitForCanary(ctx context.Context, replica *sql.DB, token string, writtenAt time.Time) (time.Duration, error) {
+	deadline := time.NewTimer(replicationLagTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(replicationLagPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var found int
+		err := replica.QueryRowContext(ctx, "SELECT 1 FROM _canary WHERE token = ?;", token).Scan(&found)
+		if err == nil {
+			return time.Since(writtenAt), nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("レプリカのカナリアの確認エラー: %w", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return 0, fmt.Errorf("%w: %s以上遅延しています", ErrReplicationLagTimeout, replicationLagTimeout)
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// recordReplicationLag はReplicationLagが返す値を更新します。
+func recordReplicationLag(lag time.Duration) {
+	lastReplicationLag.Store(&lag)
+}
+
+// newCanaryToken はカナリアの行を識別するランダムな文字列を返します。
+func newCanaryToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("カナリアの識別子の生成エラー: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withReplicationLagPolling はテスト中だけカナリアを待つ時間と問い合わせの間隔を変更します
+func withReplicationLagPolling(t *testing.T, timeout, interval time.Duration) {
+	originalTimeout, originalInterval := replicationLagTimeout, replicationLagPollInterval
+	replicationLagTimeout, replicationLagPollInterval = timeout, interval
+	t.Cleanup(func() { replicationLagTimeout, replicationLagPollInterval = originalTimeout, originalInterval })
+}
+
+// aroundTime はwantの前後1秒以内のtime.Timeに一致するsqlmockの引数です
+type aroundTime struct{ want time.Time }
+
+func (a aroundTime) Match(v driver.Value) bool {
+	got, ok := v.(time.Time)
+	return ok && got.Sub(a.want).Abs() < time.Second
+}
+
+// newReplicaMocks はプライマリとレプリカのモックDBを作成します
+func newReplicaMocks(t *testing.T) (primary *sql.DB, primaryMock sqlmock.Sqlmock, replica *sql.DB, replicaMock sqlmock.Sqlmock) {
+	t.Helper()
+	primary, primaryMock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { primary.Close() })
+	replica, replicaMock, err = sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { replica.Close() })
+	return primary, primaryMock, replica, replicaMock
+}
+
+// expectCanaryWrite はプライマリへのカナリアの書き込みと、canaryRetentionより古い行の削除を期待値として設定します
+func expectCanaryWrite(mock sqlmock.Sqlmock) {
+	now := time.Now().UTC()
+	mock.ExpectExec(`INSERT INTO _canary \(token, written_at\) VALUES \(\?, \?\);`).
+		WithArgs(sqlmock.AnyArg(), aroundTime{now}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`DELETE FROM _canary WHERE written_at < \?;`).
+		WithArgs(aroundTime{now.Add(-canaryRetention)}).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+}
+
+// expectCanaryPoll はレプリカへのカナリアの問い合わせを期待値として設定します。visibleがfalseの場合はまだ見えない状態です
+func expectCanaryPoll(mock sqlmock.Sqlmock, visible bool) {
+	rows := sqlmock.NewRows([]string{"1"})
+	if visible {
+		rows.AddRow(1)
+	}
+	mock.ExpectQuery(`SELECT 1 FROM _canary WHERE token = \?;`).WillReturnRows(rows)
+}
+
+// TestCheckReplicationLag はレプリカから見えるまで問い合わせを繰り返し、遅延を返して記録することをテストします
+func TestCheckReplicationLag(t *testing.T) {
+	withReplicationLagPolling(t, time.Second, 5*time.Millisecond)
+	primary, primaryMock, replica, replicaMock := newReplicaMocks(t)
+
+	expectCanaryWrite(primaryMock)
+	// 2回目の問い合わせまでは反映が遅れている
+	expectCanaryPoll(replicaMock, false)
+	expectCanaryPoll(replicaMock, false)
+	expectCanaryPoll(replicaMock, true)
+
+	lag, err := CheckReplicationLag(context.Background(), primary, replica)
+
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, lag, 10*time.Millisecond, "2回分の問い合わせの間隔以上の遅延になるべき")
+	recorded, ok := ReplicationLag()
+	assert.True(t, ok)
+	assert.Equal(t, lag, recorded, "計測した遅延が記録されるべき")
+	verifyExpectations(t, primaryMock)
+	verifyExpectations(t, replicaMock)
+}
+
+// TestCheckReplicationLag_Timeout はreplicationLagTimeoutまでに見えない場合にErrReplicationLagTimeoutを返し、
+// 遅延の下限として上限の時間を記録することをテストします
+func TestCheckReplicationLag_Timeout(t *testing.T) {
+	withReplicationLagPolling(t, 20*time.Millisecond, time.Hour)
+	primary, primaryMock, replica, replicaMock := newReplicaMocks(t)
+
+	expectCanaryWrite(primaryMock)
+	expectCanaryPoll(replicaMock, false)
+
+	_, err := CheckReplicationLag(context.Background(), primary, replica)
+
+	assert.ErrorIs(t, err, ErrReplicationLagTimeout)
+	recorded, ok := ReplicationLag()
+	assert.True(t, ok)
+	assert.Equal(t, 20*time.Millisecond, recorded)
+	verifyExpectations(t, primaryMock)
+	verifyExpectations(t, replicaMock)
+}
+
+// TestCheckReplicationLag_Errors は書き込みやレプリカへの問い合わせに失敗した場合にエラーを返すことをテストします
+func TestCheckReplicationLag_Errors(t *testing.T) {
+	withReplicationLagPolling(t, time.Second, time.Millisecond)
+
+	t.Run("書き込みの失敗", func(t *testing.T) {
+		primary, primaryMock, replica, replicaMock := newReplicaMocks(t)
+		primaryMock.ExpectExec(`INSERT INTO _canary`).WillReturnError(errors.New("read only"))
+
+		_, err := CheckReplicationLag(context.Background(), primary, replica)
+
+		assert.ErrorContains(t, err, "カナリアの書き込みエラー")
+		verifyExpectations(t, primaryMock)
+		verifyExpectations(t, replicaMock)
+	})
+
+	t.Run("レプリカの問い合わせの失敗", func(t *testing.T) {
+		primary, primaryMock, replica, replicaMock := newReplicaMocks(t)
+		expectCanaryWrite(primaryMock)
+		replicaMock.ExpectQuery(`SELECT 1 FROM _canary`).WillReturnError(errors.New("replica down"))
+
+		_, err := CheckReplicationLag(context.Background(), primary, replica)
+
+		assert.ErrorContains(t, err, "replica down")
+		assert.NotErrorIs(t, err, ErrReplicationLagTimeout)
+		verifyExpectations(t, replicaMock)
+	})
+}
+
+// TestHealthCheckReadWrite はレプリカが設定されている場合だけレプリケーション遅延を計測し、書き出すことをテストします
+func TestHealthCheckReadWrite(t *testing.T) {
+	withReplicationLagPolling(t, time.Second, time.Millisecond)
+
+	t.Run("レプリカなし", func(t *testing.T) {
+		primary, primaryMock, _, _ := newReplicaMocks(t)
+
+		status := HealthCheckReadWrite(NewReadWriteRepo(primary, nil))
+
+		assert.True(t, status.Healthy())
+		assert.False(t, status.ReplicaConfigured, "レプリカがなければ計測しないべき")
+		verifyExpectations(t, primaryMock)
+	})
+
+	t.Run("レプリカあり", func(t *testing.T) {
+		primary, primaryMock, replica, replicaMock := newReplicaMocks(t)
+		expectCanaryWrite(primaryMock)
+		expectCanaryPoll(replicaMock, true)
+
+		status := HealthCheckReadWrite(NewReadWriteRepo(primary, replica))
+
+		assert.True(t, status.Healthy())
+		assert.True(t, status.ReplicaConfigured)
+		var buf bytes.Buffer
+		writeHealth(&buf, status)
+		assert.Contains(t, buf.String(), "replication: lag ")
+		verifyExpectations(t, primaryMock)
+		verifyExpectations(t, replicaMock)
+	})
+
+	t.Run("遅延を計測できない", func(t *testing.T) {
+		withReplicationLagPolling(t, 10*time.Millisecond, time.Hour)
+		primary, primaryMock, replica, replicaMock := newReplicaMocks(t)
+		expectCanaryWrite(primaryMock)
+		expectCanaryPoll(replicaMock, false)
+
+		status := HealthCheckReadWrite(NewReadWriteRepo(primary, replica))
+
+		assert.False(t, status.Healthy(), "レプリカの遅延が上限を超えた場合は正常ではないべき")
+		var buf bytes.Buffer
+		writeHealth(&buf, status)
+		assert.Contains(t, buf.String(), "replication: error (")
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"db_moc/internal/stmt"
+)
+
+// defaultChecksumBatchSize はVerifyChecksumsとRecomputeChecksumsのbatchSizeを省略した場合に1回で読み取る行数です。
+const defaultChecksumBatchSize = 500
+
+var (
+	// ErrRowCorrupted は行のチェックサムが内容から計算した値と一致しない（またはチェックサムがない）場合に返されます。
+	// アプリケーションを経由せずに行が変更された可能性があります。
+	ErrRowCorrupted = errors.New("行のチェックサムが一致しません")
+	// ErrRowChecksumKey は行のチェックサムの鍵が設定されていない場合に返されます。
+	ErrRowChecksumKey = errors.New("行のチェックサムの鍵が設定されていません")
+)
+
+// rowChecksumMigration はstocksに行のバージョンとチェックサムの列を追加するマイグレーションです。
+// 追加前からある行のチェックサムはNULLのため、有効にする前にRecomputeChecksumsで計算してください。
+const rowChecksumMigration = `
+ALTER TABLE stocks
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN row_checksum CHAR(64) NULL;`
+
+// queryStockByNameWithChecksum はチェックサムを検証するGetStockが使う、チェックサムの列を含むSQL文を返します。
+func queryStockByNameWithChecksum() string {
+	return stmt.SelectByName(stocksTable, sqlDialect, append(stockColumns(), "version", "row_checksum")).SQL
+}
+
+// CorruptedRow はチェックサムが一致しなかった行です。
+type CorruptedRow struct {
+	ID   int64
+	Name string
+	// Missing はチェックサムが記録されていなかった場合にtrueです。
+	Missing bool
+}
+
+// CorruptionReport はチェックサムの検証結果です。
+type CorruptionReport struct {
+	// Scanned は検証した行数です。
+	Scanned int
+	// Corrupted はチェックサムが一致しなかった行です。
+	Corrupted []CorruptedRow
+}
+
+// OK はチェックサムが一致しなかった行がない場合にtrueを返します。
+func (r CorruptionReport) OK() bool {
+	return len(r.Corrupted) == 0
+}
+
+// computeRowChecksum は名前、在庫数、行のバージョンからkeyでHMAC-SHA256を計算し、16進数で返します。
+// 在庫数とバージョンは数値のため、名前に"|"が含まれていても区切りは一意に決まります。
+func computeRowChecksum(key []byte, name string, amount, version int64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + strconv.FormatInt(amount, 10) + "|" + strconv.FormatInt(version, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// rowChecksumMatches はstoredがkeyで計算したチェックサムと一致する場合にtrueを返します。
+func rowChecksumMatches(key []byte, name string, amount, version int64, stored sql.NullString) bool {
+	if !stored.Valid {
+		return false
+	}
+	return hmac.Equal([]byte(stored.String), []byte(computeRowChecksum(key, name, amount, version)))
+}
+
+// resolveRowChecksumKey はrowChecksumKeyの秘密情報の参照を解決して鍵を返します。
+func resolveRowChecksumKey(ctx context.Context) ([]byte, error) {
+	key, err := ResolveSecret(ctx, rowChecksumKey, secretProviders)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRowChecksumKey, err)
+	}
+	if key == "" {
+		return nil, ErrRowChecksumKey
+	}
+	return []byte(key), nil
+}
+
+// updateRowChecksum はrowChecksumEnabledが有効な場合に、トランザクション内でnameの行のバージョンを1つ進め、
+// 書き込み後の在庫数でチェックサムを計算し直します。stocksの行を書き込むすべての処理が、書き込みの後に呼び出します。
+func updateRowChecksum(ctx context.Context, tx *sql.Tx, name string) error {
+	if !rowChecksumEnabled {
+		return nil
+	}
+	key, err := resolveRowChecksumKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	var amount, version int64
+	if err := tx.QueryRowContext(ctx, "SELECT amount, version FROM stocks WHERE name = ? FOR UPDATE;", name).Scan(&amount, &version); err != nil {
+		return fmt.Errorf("チェックサムの対象行の取得エラー: %w", err)
+	}
+	version++
+	// updated_atのON UPDATEで最終更新日時が進まないよう、同じ値を明示する
+	query := "UPDATE stocks SET version = ?, row_checksum = ?, updated_at = updated_at WHERE name = ?;"
+	if _, err := tx.ExecContext(ctx, query, version, computeRowChecksum(key, name, amount, version), name); err != nil {
+		return fmt.Errorf("チェックサムの更新エラー: %w", err)
+	}
+	return nil
+}
+
+// getStockVerified はnameの行をチェックサムの列とともに読み取り、チェックサムが一致しなければErrRowCorruptedを返します。
+func getStockVerified(ctx context.Context, q Queryer, name string) (Stock, error) {
+	key, err := resolveRowChecksumKey(ctx)
+	if err != nil {
+		return Stock{}, err
+	}
+
+	query := queryStockByNameWithChecksum()
+	obs := observeQuery(query)
+	rows, err := q.QueryContext(ctx, taggedSQL(ctx, "GetStock", query), name)
+	if err != nil {
+		obs.done(0, err)
+		return Stock{}, classifyError(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		obs.done(0, err)
+		return Stock{}, err
+	}
+	var s Stock
+	var version int64
+	var stored sql.NullString
+	found, n := rows.Next(), 0
+	if found {
+		n = 1
+		targets := map[string]interface{}{"id": &s.ID, "name": &s.Name, "amount": &s.Amount, "category": &s.Category,
+			"version": &version, "row_checksum": &stored}
+		err = rows.Scan(scanDestinations(columns, targets)...)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	obs.done(n, err)
+	if err != nil {
+		return Stock{}, err
+	}
+	if !found {
+		return Stock{}, sql.ErrNoRows
+	}
+	if !rowChecksumMatches(key, s.Name, s.Amount, version, stored) {
+		return Stock{}, fmt.Errorf("%w: %s", ErrRowCorrupted, s.Name)
+	}
+	return s, nil
+}
+
+// VerifyChecksums はstocksの全行をid順にbatchSize件ずつ読み取り、チェックサムを計算し直して記録と照合します。
+// 一致しない行があってもエラーにはせず、CorruptionReportに含めて最後まで検証します。
+func VerifyChecksums(ctx context.Context, db *sql.DB, batchSize int) (CorruptionReport, error) {
+	if batchSize <= 0 {
+		batchSize = defaultChecksumBatchSize
+	}
+	key, err := resolveRowChecksumKey(ctx)
+	if err != nil {
+		return CorruptionReport{}, err
+	}
+
+	var report CorruptionReport
+	var lastID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		batch, err := nextChecksumBatch(ctx, db, lastID, batchSize, "")
+		if err != nil {
+			return report, err
+		}
+		for _, row := range batch {
+			report.Scanned++
+			if !rowChecksumMatches(key, row.name, row.amount, row.version, row.stored) {
+				report.Corrupted = append(report.Corrupted, row.corrupted())
+			}
+		}
+		if len(batch) < batchSize {
+			return report, nil
+		}
+		lastID = batch[len(batch)-1].id
+	}
+}
+
+// RecomputeChecksums は鍵のローテーション（または機能を有効にする前の初回の計算）のために、
+// stocksの全行のチェックサムをnewKeyで計算し直します。行はid順にbatchSize件ずつ、バッチごとのトランザクションで行ロックを取得して更新します。
+//
+// oldKeyで計算したチェックサムと一致しない行は書き換えず、CorruptionReportに含めます。
+// 改ざんされた行が新しい鍵で正しい行として扱われることを防ぐためです。
+// oldKeyがnilの場合は、チェックサムが記録されていない行だけを計算し、記録されている行は照合せずに書き換えません。
+func RecomputeChecksums(ctx context.Context, db *sql.DB, oldKey, newKey []byte, batchSize int) (CorruptionReport, error) {
+	if len(newKey) == 0 {
+		return CorruptionReport{}, ErrRowChecksumKey
+	}
+	if err := checkWritable(); err != nil {
+		return CorruptionReport{}, err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultChecksumBatchSize
+	}
+
+	var report CorruptionReport
+	var lastID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		var batch []checksumRow
+		var corrupted []CorruptedRow
+		err := WithTransaction(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+			var err error
+			corrupted = nil
+			batch, err = nextChecksumBatch(ctx, tx, lastID, batchSize, " FOR UPDATE")
+			if err != nil {
+				return err
+			}
+			for _, row := range batch {
+				if oldKey == nil && row.stored.Valid {
+					continue
+				}
+				if oldKey != nil && !rowChecksumMatches(oldKey, row.name, row.amount, row.version, row.stored) {
+					corrupted = append(corrupted, row.corrupted())
+					continue
+				}
+				checksum := computeRowChecksum(newKey, row.name, row.amount, row.version)
+				// 鍵のローテーションで全行が更新されたことにならないよう、updated_atは変えない
+				if _, err := tx.ExecContext(ctx, "UPDATE stocks SET row_checksum = ?, updated_at = updated_at WHERE id = ?;", checksum, row.id); err != nil {
+					return fmt.Errorf("チェックサムの更新エラー: %w", err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+		report.Scanned += len(batch)
+		report.Corrupted = append(report.Corrupted, corrupted...)
+		if len(batch) < batchSize {
+			return report, nil
+		}
+		lastID = batch[len(batch)-1].id
+	}
+}
+
+// checksumRow はチェックサムの検証に使う1行分の値です。
+type checksumRow struct {
+	id      int64
+	name    string
+	amount  int64
+	version int64
+	stored  sql.NullString
+}
+
+func (r checksumRow) corrupted() CorruptedRow {
+	return CorruptedRow{ID: r.id, Name: r.name, Missing: !r.stored.Valid}
+}
+
+// nextChecksumBatch はidがafterIDより大きい行をid順に最大limit件読み取ります。lockは末尾に付けるロックの指定です。
+func nextChecksumBatch(ctx context.Context, q Queryer, afterID int64, limit int, lock string) ([]checksumRow, error) {
+	query := "SELECT id, name, amount, version, row_checksum FROM stocks WHERE id > ? ORDER BY id LIMIT ?" + lock + ";"
+	rows, err := q.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("チェックサムの検証対象の取得エラー: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []checksumRow
+	for rows.Next() {
+		var row checksumRow
+		if err := rows.Scan(&row.id, &row.name, &row.amount, &row.version, &row.stored); err != nil {
+			return nil, err
+		}
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
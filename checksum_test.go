@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	checksumTargetRegex = `SELECT amount, version FROM stocks WHERE name = \? FOR UPDATE;`
+	checksumUpdateRegex = `UPDATE stocks SET version = \?, row_checksum = \?, updated_at = updated_at WHERE name = \?;`
+	checksumBatchRegex  = `SELECT id, name, amount, version, row_checksum FROM stocks WHERE id > \? ORDER BY id LIMIT \?`
+)
+
+// withRowChecksum はテスト中だけ行のチェックサムを有効にし、鍵をkeyにします
+func withRowChecksum(t *testing.T, key string) {
+	originalEnabled, originalKey := rowChecksumEnabled, rowChecksumKey
+	rowChecksumEnabled, rowChecksumKey = true, key
+	t.Cleanup(func() { rowChecksumEnabled, rowChecksumKey = originalEnabled, originalKey })
+}
+
+// expectRowChecksum は書き込み後のバージョンの更新とチェックサムの再計算を期待値として設定します。
+// amountとversionは書き込み後の在庫数と、書き込み前のバージョンです
+func expectRowChecksum(mock sqlmock.Sqlmock, key, name string, amount, version int64) {
+	mock.ExpectQuery(checksumTargetRegex).WithArgs(name).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "version"}).AddRow(amount, version))
+	mock.ExpectExec(checksumUpdateRegex).
+		WithArgs(version+1, computeRowChecksum([]byte(key), name, amount, version+1), name).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// checksumBatchRows はチェックサムの検証対象の行を返します
+func checksumBatchRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "name", "amount", "version", "row_checksum"})
+}
+
+func TestComputeRowChecksum(t *testing.T) {
+	key := []byte("secret")
+	sum := computeRowChecksum(key, "apple", 10, 1)
+
+	assert.Len(t, sum, 64, "CHAR(64)に収まる16進数であるべき")
+	assert.Equal(t, sum, computeRowChecksum(key, "apple", 10, 1))
+	assert.NotEqual(t, sum, computeRowChecksum(key, "apple", 11, 1), "在庫数が変われば一致しないべき")
+	assert.NotEqual(t, sum, computeRowChecksum(key, "apple", 10, 2), "バージョンが変われば一致しないべき")
+	assert.NotEqual(t, sum, computeRowChecksum([]byte("other"), "apple", 10, 1), "鍵が変われば一致しないべき")
+	assert.NotEqual(t, computeRowChecksum(key, "a|1", 2, 3), computeRowChecksum(key, "a", 12, 3))
+}
+
+// TestRowChecksum_WritePaths は在庫を書き込むすべての処理が同じトランザクションでチェックサムを更新することをテストします
+func TestRowChecksum_WritePaths(t *testing.T) {
+	const key = "secret"
+
+	t.Run("UpsertStockの更新", func(t *testing.T) {
+		withRowChecksum(t, key)
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+		mock.ExpectBegin()
+		expectUpdateAmount(mock, "apple", 150).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRowChecksum(mock, key, "apple", 150, 4)
+		mock.ExpectCommit()
+
+		assert.NoError(t, UpsertStock(db, "apple", 50))
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("UpsertStockの挿入", func(t *testing.T) {
+		withRowChecksum(t, key)
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		expectStockAmount(mock, "apple").WillReturnError(sql.ErrNoRows)
+		mock.ExpectBegin()
+		expectInsertStock(mock, "apple", 50).WillReturnResult(sqlmock.NewResult(1, 1))
+		expectRowChecksum(mock, key, "apple", 50, 0)
+		mock.ExpectCommit()
+
+		assert.NoError(t, UpsertStock(db, "apple", 50))
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("ApplyDeltasの出庫", func(t *testing.T) {
+		withRowChecksum(t, key)
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectStockAmountForUpdate(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+		expectUpdateAmount(mock, "apple", 70).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRowChecksum(mock, key, "apple", 70, 9)
+		mock.ExpectCommit()
+
+		assert.NoError(t, ApplyDeltas(db, map[string]int{"apple": -30}))
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("BulkUpsertStocks", func(t *testing.T) {
+		withRowChecksum(t, key)
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectStockAmountForUpdate(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+		expectUpdateAmount(mock, "apple", 110).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectRowChecksum(mock, key, "apple", 110, 2)
+		expectStockAmountForUpdate(mock, "banana").WillReturnError(sql.ErrNoRows)
+		expectInsertStock(mock, "banana", 5).WillReturnResult(sqlmock.NewResult(2, 1))
+		expectRowChecksum(mock, key, "banana", 5, 0)
+		mock.ExpectCommit()
+
+		_, err := BulkUpsertStocks(db, []StockUpdate{{"apple", 10}, {"banana", 5}})
+		assert.NoError(t, err)
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("鍵がない場合はロールバック", func(t *testing.T) {
+		withRowChecksum(t, "")
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		expectStockAmountForUpdate(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+		expectUpdateAmount(mock, "apple", 70).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		err := ApplyDeltas(db, map[string]int{"apple": -30})
+		assert.ErrorIs(t, err, ErrRowChecksumKey, "チェックサムを更新できない書き込みはコミットしないべき")
+		verifyExpectations(t, mock)
+	})
+}
+
+// TestGetStock_RowChecksum はGetStockが読み取った行のチェックサムを検証し、手で書き換えられた行をErrRowCorruptedにすることをテストします
+func TestGetStock_RowChecksum(t *testing.T) {
+	const key = "secret"
+	withRowChecksum(t, key)
+	columns := []string{"id", "name", "amount", "category", "version", "row_checksum"}
+	query := regexp.QuoteMeta(queryStockByNameWithChecksum())
+
+	t.Run("一致", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectQuery(query).WithArgs("apple").WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "apple", 70, defaultCategory, 3, computeRowChecksum([]byte(key), "apple", 70, 3)))
+
+		stock, err := GetStock(db, "apple")
+
+		assert.NoError(t, err)
+		assert.Equal(t, Stock{ID: 1, Name: "apple", Amount: 70, Category: defaultCategory}, stock)
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("在庫数を直接書き換えた行", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectQuery(query).WithArgs("apple").WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "apple", 9999, defaultCategory, 3, computeRowChecksum([]byte(key), "apple", 70, 3)))
+
+		_, err := GetStock(db, "apple")
+
+		assert.ErrorIs(t, err, ErrRowCorrupted)
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("チェックサムのない行", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectQuery(query).WithArgs("apple").WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "apple", 70, defaultCategory, 0, nil))
+
+		_, err := GetStock(db, "apple")
+
+		assert.ErrorIs(t, err, ErrRowCorrupted)
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("該当なし", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectQuery(query).WithArgs("apple").WillReturnRows(sqlmock.NewRows(columns))
+
+		_, err := GetStock(db, "apple")
+
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		verifyExpectations(t, mock)
+	})
+}
+
+// TestVerifyChecksums は全行をbatchSize件ずつid順に検証し、一致しない行をすべて報告することをテストします
+func TestVerifyChecksums(t *testing.T) {
+	const key = "secret"
+	withRowChecksum(t, key)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	sum := func(name string, amount, version int64) string {
+		return computeRowChecksum([]byte(key), name, amount, version)
+	}
+
+	mock.ExpectQuery(checksumBatchRegex+`;`).WithArgs(0, 2).WillReturnRows(checksumBatchRows().
+		AddRow(1, "apple", 70, 3, sum("apple", 70, 3)).
+		AddRow(2, "banana", 500, 1, sum("banana", 5, 1)))
+	mock.ExpectQuery(checksumBatchRegex+`;`).WithArgs(2, 2).WillReturnRows(checksumBatchRows().
+		AddRow(5, "cherry", 20, 0, nil).
+		AddRow(7, "durian", 1, 2, sum("durian", 1, 2)))
+	mock.ExpectQuery(checksumBatchRegex+`;`).WithArgs(7, 2).WillReturnRows(checksumBatchRows())
+
+	report, err := VerifyChecksums(context.Background(), db, 2)
+
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Scanned)
+	assert.False(t, report.OK())
+	assert.Equal(t, []CorruptedRow{{ID: 2, Name: "banana"}, {ID: 5, Name: "cherry", Missing: true}}, report.Corrupted)
+	verifyExpectations(t, mock)
+}
+
+// TestRecomputeChecksums は古い鍵で一致する行だけを新しい鍵で計算し直し、一致しない行は書き換えずに報告することをテストします
+func TestRecomputeChecksums(t *testing.T) {
+	oldKey, newKey := []byte("old"), []byte("new")
+
+	t.Run("鍵のローテーション", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(checksumBatchRegex+` FOR UPDATE;`).WithArgs(0, 2).WillReturnRows(checksumBatchRows().
+			AddRow(1, "apple", 70, 3, computeRowChecksum(oldKey, "apple", 70, 3)).
+			AddRow(2, "banana", 500, 1, computeRowChecksum(oldKey, "banana", 5, 1)))
+		mock.ExpectExec(`UPDATE stocks SET row_checksum = \?, updated_at = updated_at WHERE id = \?;`).
+			WithArgs(computeRowChecksum(newKey, "apple", 70, 3), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery(checksumBatchRegex+` FOR UPDATE;`).WithArgs(2, 2).WillReturnRows(checksumBatchRows().
+			AddRow(3, "cherry", 20, 0, computeRowChecksum(oldKey, "cherry", 20, 0)))
+		mock.ExpectExec(`UPDATE stocks SET row_checksum = \?, updated_at = updated_at WHERE id = \?;`).
+			WithArgs(computeRowChecksum(newKey, "cherry", 20, 0), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		report, err := RecomputeChecksums(context.Background(), db, oldKey, newKey, 2)
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Scanned)
+		assert.Equal(t, []CorruptedRow{{ID: 2, Name: "banana"}}, report.Corrupted, "改ざんされた行は新しい鍵で計算し直さないべき")
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("初回の計算", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(checksumBatchRegex+` FOR UPDATE;`).WithArgs(0, 10).WillReturnRows(checksumBatchRows().
+			AddRow(1, "apple", 70, 0, nil).
+			AddRow(2, "banana", 5, 1, computeRowChecksum(newKey, "banana", 5, 1)))
+		mock.ExpectExec(`UPDATE stocks SET row_checksum = \?, updated_at = updated_at WHERE id = \?;`).
+			WithArgs(computeRowChecksum(newKey, "apple", 70, 0), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		report, err := RecomputeChecksums(context.Background(), db, nil, newKey, 10)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Scanned)
+		assert.True(t, report.OK())
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("メンテナンスモード", func(t *testing.T) {
+		withMaintenanceMode(t, true)
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		_, err := RecomputeChecksums(context.Background(), db, oldKey, newKey, 10)
+
+		assert.ErrorIs(t, err, ErrMaintenanceMode)
+		verifyExpectations(t, mock)
+	})
+}
+
+// TestChecksumUpdates_KeepUpdatedAt はチェックサムだけを書き換えるUPDATEがupdated_atのON UPDATEで最終更新日時を進めないことをテストします。
+// 鍵のローテーションで全行が更新されたことになると、滞留在庫の一覧が空になり、UpsertStockLWWが正しい書き込みを古いとみなします
+func TestChecksumUpdates_KeepUpdatedAt(t *testing.T) {
+	withRowChecksum(t, "k")
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT amount, version FROM stocks WHERE name = ? FOR UPDATE;").WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "version"}).AddRow(10, 1))
+	mock.ExpectExec("UPDATE stocks SET version = ?, row_checksum = ?, updated_at = updated_at WHERE name = ?;").
+		WithArgs(2, computeRowChecksum([]byte("k"), "apple", 10, 2), "apple").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, amount, version, row_checksum FROM stocks WHERE id > ? ORDER BY id LIMIT ? FOR UPDATE;").WithArgs(0, 10).
+		WillReturnRows(checksumBatchRows().AddRow(1, "apple", 10, 2, nil))
+	mock.ExpectExec("UPDATE stocks SET row_checksum = ?, updated_at = updated_at WHERE id = ?;").
+		WithArgs(computeRowChecksum([]byte("k"), "apple", 10, 2), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, updateRowChecksum(context.Background(), tx, "apple"))
+	require.NoError(t, tx.Commit())
+	_, err = RecomputeChecksums(context.Background(), db, nil, []byte("k"), 10)
+	require.NoError(t, err)
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidClaimSize はClaimLowStockに1未満の件数を指定した場合に返されます。
+var ErrInvalidClaimSize = errors.New("確保する件数は1以上である必要があります")
+
+// ClaimLowStock は在庫数の少ない順に最大n件の商品を行ロックして確保し、開いたままのトランザクションと一緒に返します。
+// 他のワーカーがロックしている行はSKIP LOCKEDで読み飛ばすため、複数のワーカーが同時に実行しても互いを待たず、
+// 同じ商品を重複して確保しません。在庫数が同じ場合は名前順です。
+// 呼び出し元は返したトランザクションで補充などの更新を行い、必ずCommitかRollbackで終了してください。
+// エラーを返す場合、トランザクションはロールバック済みでnilです。
+func ClaimLowStock(ctx context.Context, db *sql.DB, n int) (*sql.Tx, []Stock, error) {
+	if n < 1 {
+		return nil, nil, fmt.Errorf("%w: %d", ErrInvalidClaimSize, n)
+	}
+	if err := checkWritable(); err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+
+	query := "SELECT " + stockSelectList() + " FROM stocks ORDER BY amount ASC, name LIMIT ? FOR UPDATE SKIP LOCKED;"
+	obs := observeQuery(query)
+	rows, err := tx.QueryContext(ctx, query, n)
+	if err != nil {
+		obs.done(0, err)
+		tx.Rollback()
+		return nil, nil, fmt.Errorf("在庫の少ない商品の確保エラー: %w", classifyError(err))
+	}
+	stocks, err := scanStocks(rows)
+	rows.Close()
+	obs.done(len(stocks), err)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, fmt.Errorf("在庫の少ない商品の確保エラー: %w", err)
+	}
+	return tx, stocks, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const claimLowStockRegex = `SELECT id, name, amount, category FROM stocks ORDER BY amount ASC, name LIMIT \? FOR UPDATE SKIP LOCKED;`
+
+// TestClaimLowStock は在庫の少ない商品をSKIP LOCKED付きのFOR UPDATEで確保し、開いたトランザクションを返すことをテストします
+func TestClaimLowStock(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimLowStockRegex).WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+			AddRow(2, "banana", 0, "fruit").
+			AddRow(3, "cherry", 5, "fruit"))
+
+	tx, stocks, err := ClaimLowStock(context.Background(), db, 2)
+
+	require.NoError(t, err)
+	require.NotNil(t, tx, "トランザクションは開いたまま返るべき")
+	assert.Equal(t, []Stock{
+		{ID: 2, Name: "banana", Amount: 0, Category: "fruit"},
+		{ID: 3, Name: "cherry", Amount: 5, Category: "fruit"},
+	}, stocks)
+
+	// 確保した行の更新は呼び出し元が同じトランザクションで行う
+	mock.ExpectCommit()
+	assert.NoError(t, tx.Commit())
+	verifyExpectations(t, mock)
+}
+
+// TestClaimLowStock_QueryError は確保に失敗した場合にロールバックしてnilのトランザクションを返すことをテストします
+func TestClaimLowStock_QueryError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(claimLowStockRegex).WithArgs(5).WillReturnError(errors.New("lock wait timeout"))
+	mock.ExpectRollback()
+
+	tx, stocks, err := ClaimLowStock(context.Background(), db, 5)
+
+	assert.ErrorContains(t, err, "在庫の少ない商品の確保エラー")
+	assert.Nil(t, tx)
+	assert.Nil(t, stocks)
+	verifyExpectations(t, mock)
+}
+
+// TestClaimLowStock_Rejected は不正な件数とメンテナンスモードではDBに問い合わせないことをテストします
+func TestClaimLowStock_Rejected(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	_, _, err := ClaimLowStock(context.Background(), db, 0)
+	assert.True(t, errors.Is(err, ErrInvalidClaimSize), "ErrInvalidClaimSizeが返るべき")
+
+	withMaintenanceMode(t, true)
+	_, _, err = ClaimLowStock(context.Background(), db, 1)
+	assert.True(t, errors.Is(err, ErrMaintenanceMode), "ErrMaintenanceModeが返るべき")
+	verifyExpectations(t, mock)
+}
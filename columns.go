@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"db_moc/internal/stmt"
+)
+
+// baseStockColumns はstocksテーブルから常に取得する列です。
+// SELECT * を使わないことで、無関係な列の追加による転送量の増加や型付きスキャンの崩れを防ぎます。
+var baseStockColumns = []string{"id", "name", "amount"}
+
+// categoryColumn はcategoryMigrationで追加するカテゴリの列です。既定で取得しますが、
+// マイグレーションを適用する前のテーブルにはないため、CheckStockColumnsで存在しないとわかった場合は取得しません。
+const categoryColumn = "category"
+
+// categoryColumnMissing はCheckStockColumnsがstocksテーブルにcategory列がないと確認した場合にtrueになります。
+var categoryColumnMissing atomic.Bool
+
+// ErrColumnDrift は列定義と実際のテーブル定義が一致しない場合に返されます。
+var ErrColumnDrift = errors.New("stocksテーブルの列定義が一致しません")
+
+// stockColumns はstocksテーブルから取得する列の一覧を返します。
+// マイグレーションで追加した列はoptionalStockColumnsで有効にします。
+func stockColumns() []string {
+	columns := make([]string, 0, len(baseStockColumns)+1+len(optionalStockColumns))
+	columns = append(columns, baseStockColumns...)
+	if !categoryColumnMissing.Load() {
+		columns = append(columns, categoryColumn)
+	}
+	return append(columns, optionalStockColumns...)
+}
+
+// stockSelectList はSELECT句に使う列のリストを返します。
+func stockSelectList() string {
+	return strings.Join(stockColumns(), ", ")
+}
+
+// queryAllStocks は全件を取得するSQL文を返します。
+func queryAllStocks() string {
+	return stmt.SelectAll(stocksTable, sqlDialect, stockColumns()).SQL
+}
+
+// queryStocksByName は名前に一致する行を取得するSQL文を返します。
+func queryStocksByName() string {
+	return stmt.SelectByName(stocksTable, sqlDialect, stockColumns()).SQL
+}
+
+// CheckStockColumns は列定義とinformation_schemaのstocksテーブルを比較し、
+// 定義した列が存在しない場合はErrColumnDriftを返します。
+// テーブル自体が存在しない場合はスキーマ未作成として扱い、エラーにしません。
+// category列はマイグレーションの適用前のテーブルにはないため、存在しなくてもエラーにせず、以降は取得する列から外します。
+func CheckStockColumns(db *sql.DB) error {
+	return CheckStockColumnsContext(context.Background(), db)
+}
+
+// CheckStockColumnsContext はCheckStockColumnsのcontext対応版です。
+func CheckStockColumnsContext(ctx context.Context, db *sql.DB) error {
+	actual, err := liveStockColumns(ctx, db)
+	if err != nil {
+		return fmt.Errorf("列定義の確認エラー: %w", err)
+	}
+	if len(actual) == 0 {
+		return nil
+	}
+	categoryColumnMissing.Store(!actual[categoryColumn])
+
+	var missing []string
+	for _, col := range stockColumns() {
+		if !actual[col] {
+			missing = append(missing, col)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: 存在しない列 %s", ErrColumnDrift, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// requireCategoryColumn はCheckStockColumnsがcategory列がないと確認している場合にErrColumnDriftを返します。
+func requireCategoryColumn() error {
+	if categoryColumnMissing.Load() {
+		return fmt.Errorf("%w: 存在しない列 %s（init-dbでマイグレーションを適用してください）", ErrColumnDrift, categoryColumn)
+	}
+	return nil
+}
+
+// liveStockColumns はinformation_schemaから実際のstocksテーブルの列名を小文字で返します。
+// テーブルが存在しない場合は空のmapを返します。
+func liveStockColumns(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	query := "SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'stocks';"
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actual := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		actual[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return actual, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const queryInformationSchemaColumns = `SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE\(\) AND TABLE_NAME = 'stocks';`
+
+// withOptionalStockColumns はテスト中だけ追加取得する列を差し替えます。
+func withOptionalStockColumns(t *testing.T, columns ...string) {
+	t.Helper()
+	prev := optionalStockColumns
+	optionalStockColumns = columns
+	t.Cleanup(func() { optionalStockColumns = prev })
+}
+
+func TestStockSelectQueries(t *testing.T) {
+	assert.Equal(t, "SELECT id, name, amount, category FROM stocks;", queryAllStocks())
+	assert.Equal(t, "SELECT id, name, amount, category FROM stocks WHERE name = ?;", queryStocksByName())
+
+	withOptionalStockColumns(t, "price", "updated_at")
+	assert.Equal(t, "SELECT id, name, amount, category, price, updated_at FROM stocks;", queryAllStocks())
+	assert.Equal(t, []string{"id", "name", "amount"}, baseStockColumns, "基本の列定義は変更されない")
+}
+
+func TestQueryStocksTyped_SurplusColumnIgnored(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// マイグレーションで追加されたが列定義にない列が結果に含まれても、型付きの構造体には渡らない
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category", "blob_data"}).
+			AddRow(1, "apple", 100, "fruit", []byte("large payload")))
+
+	results, err := QueryStocksTyped(db, "apple")
+	assert.NoError(t, err)
+	assert.Equal(t, []Stock{{ID: 1, Name: "apple", Amount: 100, Category: "fruit"}}, results)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckStockColumns(t *testing.T) {
+	tests := []struct {
+		name        string
+		columns     []string
+		expectDrift bool
+	}{
+		{name: "定義と一致", columns: []string{"id", "name", "amount", "category"}},
+		{name: "テーブル側に余分な列がある", columns: []string{"id", "name", "amount", "category", "note"}},
+		{name: "大文字の列名", columns: []string{"ID", "NAME", "AMOUNT", "CATEGORY"}},
+		{name: "テーブルが存在しない", columns: nil},
+		{name: "マイグレーション前でcategory列がない", columns: []string{"id", "name", "amount"}},
+		{name: "列が不足している", columns: []string{"id", "name", "category"}, expectDrift: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Cleanup(func() { categoryColumnMissing.Store(false) })
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			rows := sqlmock.NewRows([]string{"COLUMN_NAME"})
+			for _, c := range tc.columns {
+				rows.AddRow(c)
+			}
+			mock.ExpectQuery(queryInformationSchemaColumns).WillReturnRows(rows)
+
+			err := CheckStockColumns(db)
+			if tc.expectDrift {
+				assert.ErrorIs(t, err, ErrColumnDrift)
+				assert.Contains(t, err.Error(), "amount")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// TestCheckStockColumns_CategoryMissing はcategory列がないテーブルでは、起動を止めずにカテゴリを扱わないことをテストします
+func TestCheckStockColumns_CategoryMissing(t *testing.T) {
+	t.Cleanup(func() { categoryColumnMissing.Store(false) })
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(queryInformationSchemaColumns).
+		WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}).AddRow("id").AddRow("name").AddRow("amount"))
+	assert.NoError(t, CheckStockColumns(db))
+
+	// 在庫の検索はcategory列を取得しない
+	assert.Equal(t, "SELECT id, name, amount FROM stocks WHERE name = ?;", queryStocksByName())
+	mock.ExpectQuery(`SELECT id, name, amount FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).AddRow(1, "apple", 100))
+	results, err := QueryStocksTyped(db, "apple")
+	assert.NoError(t, err)
+	assert.Equal(t, []Stock{{ID: 1, Name: "apple", Amount: 100}}, results)
+
+	// カテゴリを扱う操作はDBに問い合わせずにErrColumnDriftを返す
+	_, err = QueryStocksByCategory(db, "fruit")
+	assert.ErrorIs(t, err, ErrColumnDrift)
+	assert.ErrorIs(t, UpsertStockWithCategory(db, "apple", 1, "fruit"), ErrColumnDrift)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// マイグレーションの適用後に確認し直すと、再びcategory列を取得する
+	mock.ExpectQuery(queryInformationSchemaColumns).
+		WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}).AddRow("id").AddRow("name").AddRow("amount").AddRow("category"))
+	assert.NoError(t, CheckStockColumns(db))
+	assert.Equal(t, "SELECT id, name, amount, category FROM stocks WHERE name = ?;", queryStocksByName())
+}
+
+func TestCheckStockColumns_OptionalColumnMissing(t *testing.T) {
+	withOptionalStockColumns(t, "price")
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(queryInformationSchemaColumns).
+		WillReturnRows(sqlmock.NewRows([]string{"COLUMN_NAME"}).
+			AddRow("id").AddRow("name").AddRow("amount").AddRow("category"))
+
+	err := CheckStockColumns(db)
+	assert.ErrorIs(t, err, ErrColumnDrift)
+	assert.Contains(t, err.Error(), "price")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheckStockColumns_QueryError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(queryInformationSchemaColumns).WillReturnError(errors.New("access denied"))
+
+	err := CheckStockColumns(db)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrColumnDrift)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// compactCandidate はCompactLogでまとめる1商品分の古い変更履歴です。
+type compactCandidate struct {
+	name  string
+	delta int
+	count int
+}
+
+// CompactLog はbeforeより前のstock_logの行を商品ごとに1行のcompactedの行にまとめ、削除した行数を返します。
+// まとめた行の変更量は削除した行から求めた在庫数（initialの行があればその在庫数と以降の変更量の合計）、
+// 在庫数と記録日時は削除した最後の行の値です。
+// 処理は1つのトランザクションで行い、最初にすべての商品についてstocksの在庫数が変更履歴から求めた在庫数と一致することを確認します。
+// 一致しない商品がある場合は何も削除せずにLedgerMismatchErrorを返します。
+func CompactLog(db *sql.DB, before time.Time) (int64, error) {
+	return CompactLogContext(context.Background(), db, before)
+}
+
+// CompactLogContext はCompactLogのcontext対応版です。
+func CompactLogContext(ctx context.Context, db *sql.DB, before time.Time) (int64, error) {
+	if err := checkWritable(); err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+	defer tx.Rollback() // エラー発生時にロールバック
+
+	if err := verifyLedgerTotals(ctx, tx); err != nil {
+		return 0, err
+	}
+	candidates, err := compactCandidates(ctx, tx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, c := range candidates {
+		n, err := compactStockLog(ctx, tx, c, before)
+		if err != nil {
+			return 0, err
+		}
+		deleted += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("トランザクションコミットエラー: %w", err)
+	}
+	return deleted, nil
+}
+
+// verifyLedgerTotals はstocksの在庫数が変更履歴から求めた在庫数と一致することを確認します。
+// stocksの行はトランザクションの終了までロックし、確認後の更新で合計がずれないようにします。
+// stocksにない商品は在庫数0として扱います。一致しない商品があれば名前順で最初の商品のLedgerMismatchErrorを返します。
+func verifyLedgerTotals(ctx context.Context, tx *sql.Tx) error {
+	amounts, err := queryNameTotals(ctx, tx, "SELECT name, amount FROM stocks FOR UPDATE;")
+	if err != nil {
+		return fmt.Errorf("在庫数の取得エラー: %w", err)
+	}
+	ledger, err := queryLedgerTotals(ctx, tx, "SELECT name, operation, delta, amount FROM stock_log ORDER BY name, created_at, id;")
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(amounts)+len(ledger))
+	for name := range amounts {
+		names = append(names, name)
+	}
+	for name := range ledger {
+		if _, ok := amounts[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if amounts[name] != ledger[name].amount {
+			return &LedgerMismatchError{Name: name, Amount: amounts[name], LedgerSum: ledger[name].amount}
+		}
+	}
+	return nil
+}
+
+// queryNameTotals は商品名と数値の2列を返すクエリを実行し、商品名ごとの値を返します。
+func queryNameTotals(ctx context.Context, tx *sql.Tx, query string) (map[string]int, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer rows.Close()
+
+	totals := make(map[string]int)
+	for rows.Next() {
+		var (
+			name  string
+			total int
+		)
+		if err := rows.Scan(&name, &total); err != nil {
+			return nil, err
+		}
+		totals[name] = total
+	}
+	return totals, rows.Err()
+}
+
+// compactCandidates はbeforeより前の変更履歴が2行以上ある商品を名前順に返します。1行しかない商品はまとめる必要がありません。
+// まとめた行の変更量は、まとめる行から求めた在庫数です。initialの行を含む場合も、まとめた後の変更量の合計が在庫数と一致します。
+func compactCandidates(ctx context.Context, tx *sql.Tx, before time.Time) ([]compactCandidate, error) {
+	query := "SELECT name, operation, delta, amount FROM stock_log WHERE created_at < ? ORDER BY name, created_at, id;"
+	totals, err := queryLedgerTotals(ctx, tx, query, before)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []compactCandidate
+	for name, t := range totals {
+		if t.rows > 1 {
+			candidates = append(candidates, compactCandidate{name: name, delta: t.amount, count: t.rows})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].name < candidates[j].name })
+	return candidates, nil
+}
+
+// compactStockLog は1商品のbeforeより前の変更履歴を削除し、それらをまとめた1行を記録します。削除した行数を返します。
+func compactStockLog(ctx context.Context, tx *sql.Tx, c compactCandidate, before time.Time) (int64, error) {
+	var (
+		amount    int
+		createdAt time.Time
+	)
+	last := "SELECT amount, created_at FROM stock_log WHERE name = ? AND created_at < ? ORDER BY created_at DESC, id DESC LIMIT 1;"
+	if err := tx.QueryRowContext(ctx, last, c.name, before).Scan(&amount, &createdAt); err != nil {
+		return 0, fmt.Errorf("変更履歴の取得エラー: %s: %w", c.name, err)
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM stock_log WHERE name = ? AND created_at < ?;", c.name, before)
+	if err != nil {
+		return 0, fmt.Errorf("変更履歴の削除エラー: %s: %w", c.name, err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("変更履歴の削除エラー: %s: %w", c.name, err)
+	}
+
+	insert := "INSERT INTO stock_log (name, operation, delta, amount, created_at) VALUES (?, ?, ?, ?, ?);"
+	if _, err := tx.ExecContext(ctx, insert, c.name, operationCompacted, c.delta, amount, createdAt); err != nil {
+		return 0, fmt.Errorf("変更履歴の記録エラー: %s: %w", c.name, err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ledgerRowsRegex        = `SELECT name, operation, delta, amount FROM stock_log ORDER BY name, created_at, id;`
+	compactCandidatesRegex = `SELECT name, operation, delta, amount FROM stock_log WHERE created_at < \? ORDER BY name, created_at, id;`
+)
+
+// expectLedgerTotals はCompactLogが確認する在庫数と変更履歴の合計を期待値として設定します
+func expectLedgerTotals(mock sqlmock.Sqlmock, amounts, sums map[string]int) {
+	stockRows := sqlmock.NewRows([]string{"name", "amount"})
+	for name, amount := range amounts {
+		stockRows.AddRow(name, amount)
+	}
+	mock.ExpectQuery(`SELECT name, amount FROM stocks FOR UPDATE;`).WillReturnRows(stockRows)
+
+	// 商品ごとに変更量がsumの1行として返す
+	logRows := sqlmock.NewRows([]string{"name", "operation", "delta", "amount"})
+	for name, sum := range sums {
+		logRows.AddRow(name, operationUpdate, sum, sum)
+	}
+	mock.ExpectQuery(ledgerRowsRegex).WillReturnRows(logRows)
+}
+
+// TestCompactLog は古い変更履歴が商品ごとに合計を保った1行にまとめられることをテストします
+func TestCompactLog(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	before := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	lastAt := before.Add(-time.Hour)
+
+	mock.ExpectBegin()
+	expectLedgerTotals(mock,
+		map[string]int{"apple": 120, "banana": 30},
+		map[string]int{"apple": 120, "banana": 30, "cherry": 0})
+	mock.ExpectQuery(compactCandidatesRegex).
+		WithArgs(before).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "operation", "delta", "amount"}).
+			AddRow("apple", operationInsert, 50, 50).
+			AddRow("apple", operationUpdate, 30, 80).
+			AddRow("apple", operationUpdate, 20, 100).
+			AddRow("banana", operationInsert, 30, 30).
+			AddRow("cherry", operationInsert, 5, 5).
+			AddRow("cherry", operationUpdate, -5, 0))
+
+	mock.ExpectQuery(`SELECT amount, created_at FROM stock_log WHERE name = \? AND created_at < \? ORDER BY created_at DESC, id DESC LIMIT 1;`).
+		WithArgs("apple", before).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "created_at"}).AddRow(100, lastAt))
+	mock.ExpectExec(`DELETE FROM stock_log WHERE name = \? AND created_at < \?;`).
+		WithArgs("apple", before).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO stock_log \(name, operation, delta, amount, created_at\) VALUES \(\?, \?, \?, \?, \?\);`).
+		WithArgs("apple", operationCompacted, 100, 100, lastAt).
+		WillReturnResult(sqlmock.NewResult(10, 1))
+
+	mock.ExpectQuery(`SELECT amount, created_at FROM stock_log WHERE name = \? AND created_at < \?`).
+		WithArgs("cherry", before).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "created_at"}).AddRow(0, lastAt))
+	mock.ExpectExec(`DELETE FROM stock_log WHERE name = \? AND created_at < \?;`).
+		WithArgs("cherry", before).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO stock_log`).
+		WithArgs("cherry", operationCompacted, 0, 0, lastAt).
+		WillReturnResult(sqlmock.NewResult(11, 1))
+	mock.ExpectCommit()
+
+	deleted, err := CompactLog(db, before)
+
+	assert.NoError(t, err, "エラーが発生すべきでない")
+	assert.Equal(t, int64(5), deleted, "削除した行数が返るべき")
+	verifyExpectations(t, mock)
+}
+
+// TestCompactLog_AfterBackfill はBackfillHistoryでinitialの行を記録した後も、在庫数を起点にした変更履歴と照合してまとめられることをテストします。
+// まとめた行の変更量はinitialの在庫数を含むため、まとめた後も変更量の合計が在庫数と一致します
+func TestCompactLog_AfterBackfill(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	before := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	lastAt := before.Add(-time.Hour)
+
+	// 在庫数100の商品をバックフィルした後に20加え、まとめる期間の後に5減らした
+	logRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"name", "operation", "delta", "amount"}).
+			AddRow("apple", operationInitial, 0, 100).
+			AddRow("apple", operationUpdate, 20, 120)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name, amount FROM stocks FOR UPDATE;`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "amount"}).AddRow("apple", 115))
+	mock.ExpectQuery(ledgerRowsRegex).
+		WillReturnRows(logRows().AddRow("apple", operationUpdate, -5, 115))
+	mock.ExpectQuery(compactCandidatesRegex).WithArgs(before).WillReturnRows(logRows())
+	mock.ExpectQuery(`SELECT amount, created_at FROM stock_log WHERE name = \? AND created_at < \?`).
+		WithArgs("apple", before).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "created_at"}).AddRow(120, lastAt))
+	mock.ExpectExec(`DELETE FROM stock_log WHERE name = \? AND created_at < \?;`).
+		WithArgs("apple", before).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO stock_log`).
+		WithArgs("apple", operationCompacted, 120, 120, lastAt).
+		WillReturnResult(sqlmock.NewResult(10, 1))
+	mock.ExpectCommit()
+
+	deleted, err := CompactLog(db, before)
+
+	assert.NoError(t, err, "バックフィル後も一致していればまとめられるべき")
+	assert.Equal(t, int64(2), deleted)
+	verifyExpectations(t, mock)
+}
+
+// TestCompactLog_Mismatch は在庫数と変更履歴の合計が一致しない場合に何も削除しないことをテストします
+func TestCompactLog_Mismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		amounts map[string]int
+		sums    map[string]int
+		want    LedgerMismatchError
+	}{
+		{
+			name:    "在庫数が異なる",
+			amounts: map[string]int{"apple": 120, "banana": 30},
+			sums:    map[string]int{"apple": 100, "banana": 30},
+			want:    LedgerMismatchError{Name: "apple", Amount: 120, LedgerSum: 100},
+		},
+		{
+			name:    "変更履歴のない在庫",
+			amounts: map[string]int{"apple": 120},
+			sums:    map[string]int{},
+			want:    LedgerMismatchError{Name: "apple", Amount: 120, LedgerSum: 0},
+		},
+		{
+			name:    "在庫のない変更履歴",
+			amounts: map[string]int{},
+			sums:    map[string]int{"banana": 5},
+			want:    LedgerMismatchError{Name: "banana", Amount: 0, LedgerSum: 5},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectBegin()
+			expectLedgerTotals(mock, tc.amounts, tc.sums)
+			mock.ExpectRollback()
+
+			deleted, err := CompactLog(db, time.Now())
+
+			assert.True(t, errors.Is(err, ErrLedgerMismatch), "ErrLedgerMismatchが返るべき")
+			var mismatch *LedgerMismatchError
+			if assert.True(t, errors.As(err, &mismatch)) {
+				assert.Equal(t, tc.want, *mismatch, "一致しなかった商品が分かるべき")
+			}
+			assert.Zero(t, deleted)
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestCompactLog_MaintenanceMode はメンテナンスモード中にDBへ問い合わせずに拒否することをテストします
+func TestCompactLog_MaintenanceMode(t *testing.T) {
+	withMaintenanceMode(t, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	_, err := CompactLog(db, time.Now())
+
+	assert.True(t, errors.Is(err, ErrMaintenanceMode), "ErrMaintenanceModeが返るべき")
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrSnapshotDiffers はcompareサブコマンドで比較した2つの在庫データに差分がある場合に返されます。
+var ErrSnapshotDiffers = errors.New("在庫データに差分があります")
+
+// 名前のバイト順で並べるクエリ。Goの文字列比較と順序を一致させるためutf8mb4_binで並べる。
+const queryStocksOrderedByName = "SELECT id, name, amount FROM stocks ORDER BY name COLLATE utf8mb4_bin;"
+
+// StockMismatch は両方に存在するが内容が異なる行の組です。
+type StockMismatch struct {
+	Name   string
+	Source Stock
+	Target Stock
+}
+
+// SnapshotDiff は2つの在庫データの差分です。
+type SnapshotDiff struct {
+	// Missing は比較元にのみ存在する行です。
+	Missing []Stock
+	// Extra は比較先にのみ存在する行です。
+	Extra []Stock
+	// AmountMismatches は在庫数が異なる行です。
+	AmountMismatches []StockMismatch
+	// IDDrift は在庫数は一致しているがidのみ異なる行です。
+	IDDrift []StockMismatch
+}
+
+// Empty は差分がない場合にtrueを返します。
+func (d SnapshotDiff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 &&
+		len(d.AmountMismatches) == 0 && len(d.IDDrift) == 0
+}
+
+// addPair は両方に存在する同じ名前の行を比べ、異なる場合は差分に加えます。
+func (d *SnapshotDiff) addPair(source, target Stock) {
+	m := StockMismatch{Name: source.Name, Source: source, Target: target}
+	if source.Amount != target.Amount {
+		d.AmountMismatches = append(d.AmountMismatches, m)
+	} else if source.ID != target.ID {
+		d.IDDrift = append(d.IDDrift, m)
+	}
+}
+
+// DiffStocks は名前順に並んだ2つの在庫データを突き合わせ、差分を返します。
+// CompareDatabasesと同じ規則で、sourceを比較元、targetを比較先として扱います。
+func DiffStocks(source, target []Stock) SnapshotDiff {
+	var diff SnapshotDiff
+	i, j := 0, 0
+	for i < len(source) || j < len(target) {
+		switch {
+		case j == len(target) || (i < len(source) && source[i].Name < target[j].Name):
+			diff.Missing = append(diff.Missing, source[i])
+			i++
+		case i == len(source) || target[j].Name < source[i].Name:
+			diff.Extra = append(diff.Extra, target[j])
+			j++
+		default:
+			diff.addPair(source[i], target[j])
+			i, j = i+1, j+1
+		}
+	}
+	return diff
+}
+
+// stockCursor は名前順の行セットを1行ずつ読み進めるカーソルです。
+type stockCursor struct {
+	rows    *sql.Rows
+	current Stock
+	valid   bool
+}
+
+// next は次の行を読み込みます。行がなくなるとvalidがfalseになります。
+func (c *stockCursor) next() error {
+	c.valid = c.rows.Next()
+	if !c.valid {
+		return c.rows.Err()
+	}
+	return c.rows.Scan(&c.current.ID, &c.current.Name, &c.current.Amount)
+}
+
+// CompareDatabases は比較元と比較先のstocksテーブルを名前順に読み進めながら突き合わせ、差分を返します。
+// 両方の行セットを同時に1行ずつ処理するため、メモリ使用量は差分の件数にのみ比例します。
+func CompareDatabases(ctx context.Context, source, target *sql.DB) (SnapshotDiff, error) {
+	var diff SnapshotDiff
+
+	srcRows, err := source.QueryContext(ctx, queryStocksOrderedByName)
+	if err != nil {
+		return diff, fmt.Errorf("比較元の読み込みエラー: %w", err)
+	}
+	defer srcRows.Close()
+
+	dstRows, err := target.QueryContext(ctx, queryStocksOrderedByName)
+	if err != nil {
+		return diff, fmt.Errorf("比較先の読み込みエラー: %w", err)
+	}
+	defer dstRows.Close()
+
+	src := &stockCursor{rows: srcRows}
+	dst := &stockCursor{rows: dstRows}
+	if err := src.next(); err != nil {
+		return diff, fmt.Errorf("比較元の読み込みエラー: %w", err)
+	}
+	if err := dst.next(); err != nil {
+		return diff, fmt.Errorf("比較先の読み込みエラー: %w", err)
+	}
+
+	for src.valid || dst.valid {
+		var advanceSrc, advanceDst bool
+		switch {
+		case !dst.valid || (src.valid && src.current.Name < dst.current.Name):
+			diff.Missing = append(diff.Missing, src.current)
+			advanceSrc = true
+		case !src.valid || dst.current.Name < src.current.Name:
+			diff.Extra = append(diff.Extra, dst.current)
+			advanceDst = true
+		default:
+			diff.addPair(src.current, dst.current)
+			advanceSrc, advanceDst = true, true
+		}
+
+		if advanceSrc {
+			if err := src.next(); err != nil {
+				return diff, fmt.Errorf("比較元の読み込みエラー: %w", err)
+			}
+		}
+		if advanceDst {
+			if err := dst.next(); err != nil {
+				return diff, fmt.Errorf("比較先の読み込みエラー: %w", err)
+			}
+		}
+	}
+	return diff, nil
+}
+
+// RenderSnapshotDiff は差分を人が読める形式でwに書き出します。
+func RenderSnapshotDiff(w io.Writer, diff SnapshotDiff) error {
+	if diff.Empty() {
+		_, err := fmt.Fprintln(w, "差分はありません")
+		return err
+	}
+	for _, s := range diff.Missing {
+		if _, err := fmt.Fprintf(w, "比較先にない: %s (id=%d, amount=%d)\n", s.Name, s.ID, s.Amount); err != nil {
+			return err
+		}
+	}
+	for _, s := range diff.Extra {
+		if _, err := fmt.Fprintf(w, "比較元にない: %s (id=%d, amount=%d)\n", s.Name, s.ID, s.Amount); err != nil {
+			return err
+		}
+	}
+	for _, m := range diff.AmountMismatches {
+		if _, err := fmt.Fprintf(w, "在庫数の不一致: %s (比較元=%d, 比較先=%d)\n", m.Name, m.Source.Amount, m.Target.Amount); err != nil {
+			return err
+		}
+	}
+	for _, m := range diff.IDDrift {
+		if _, err := fmt.Fprintf(w, "idのずれ: %s (比較元=%d, 比較先=%d)\n", m.Name, m.Source.ID, m.Target.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profileNames は--profileを複数回指定できるフラグです。
+type profileNames []string
+
+func (p *profileNames) String() string { return strings.Join(*p, ",") }
+
+func (p *profileNames) Set(name string) error {
+	*p = append(*p, name)
+	return nil
+}
+
+// parseCompareArgs はcompareサブコマンドの引数から比較元と比較先のプロファイル名を返します。
+// --profileはちょうど2回、比較元、比較先の順に指定します。
+func parseCompareArgs(w io.Writer, args []string) (source, target string, err error) {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	fs.SetOutput(w)
+	var profiles profileNames
+	fs.Var(&profiles, "profile", "比較する接続先のプロファイル名（比較元、比較先の順に2回指定する）")
+	if err := fs.Parse(args); err != nil {
+		return "", "", err
+	}
+	if fs.NArg() > 0 {
+		return "", "", fmt.Errorf("不明な引数です: %s", strings.Join(fs.Args(), " "))
+	}
+	if len(profiles) != 2 {
+		return "", "", errors.New("--profileは比較元と比較先の2回指定してください")
+	}
+	if profiles[0] == profiles[1] {
+		return "", "", errors.New("比較元と比較先に同じプロファイルは指定できません")
+	}
+	return profiles[0], profiles[1], nil
+}
+
+// runCompareCommand はcompareサブコマンドを実行します。2つのプロファイルの接続先のstocksテーブルを
+// CompareDatabasesで比較し、差分を書き出します。差分がある場合はErrSnapshotDiffersを返します。
+// 使い方: compare --profile old --profile new
+func runCompareCommand(ctx context.Context, w io.Writer, args []string) error {
+	sourceName, targetName, err := parseCompareArgs(w, args)
+	if err != nil {
+		return err
+	}
+
+	source, err := ConnectProfile(sourceName)
+	if err != nil {
+		return fmt.Errorf("比較元（%s）の接続エラー: %w", sourceName, err)
+	}
+	defer source.Close()
+	target, err := ConnectProfile(targetName)
+	if err != nil {
+		return fmt.Errorf("比較先（%s）の接続エラー: %w", targetName, err)
+	}
+	defer target.Close()
+
+	diff, err := CompareDatabases(ctx, source, target)
+	if err != nil {
+		return err
+	}
+	if err := RenderSnapshotDiff(w, diff); err != nil {
+		return err
+	}
+	if !diff.Empty() {
+		return ErrSnapshotDiffers
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const orderedStocksRegex = `SELECT id, name, amount FROM stocks ORDER BY name COLLATE utf8mb4_bin;`
+
+// newStockRows はStockのスライスからsqlmockの行セットを作成します
+func newStockRows(stocks ...Stock) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "name", "amount"})
+	for _, s := range stocks {
+		rows.AddRow(s.ID, s.Name, s.Amount)
+	}
+	return rows
+}
+
+func TestCompareDatabases(t *testing.T) {
+	apple := Stock{ID: 1, Name: "apple", Amount: 100}
+	banana := Stock{ID: 2, Name: "banana", Amount: 50}
+	cherry := Stock{ID: 3, Name: "cherry", Amount: 75}
+
+	tests := []struct {
+		name     string
+		source   []Stock
+		target   []Stock
+		expected SnapshotDiff
+	}{
+		{
+			name:   "一致",
+			source: []Stock{apple, banana, cherry},
+			target: []Stock{apple, banana, cherry},
+		},
+		{
+			name:     "比較先に不足",
+			source:   []Stock{apple, banana, cherry},
+			target:   []Stock{apple, cherry},
+			expected: SnapshotDiff{Missing: []Stock{banana}},
+		},
+		{
+			name:     "比較先に余分",
+			source:   []Stock{banana},
+			target:   []Stock{apple, banana, cherry},
+			expected: SnapshotDiff{Extra: []Stock{apple, cherry}},
+		},
+		{
+			name:   "在庫数の不一致",
+			source: []Stock{apple, banana},
+			target: []Stock{apple, {ID: 2, Name: "banana", Amount: 49}},
+			expected: SnapshotDiff{AmountMismatches: []StockMismatch{
+				{Name: "banana", Source: banana, Target: Stock{ID: 2, Name: "banana", Amount: 49}},
+			}},
+		},
+		{
+			name:   "idのずれ",
+			source: []Stock{apple, banana},
+			target: []Stock{apple, {ID: 7, Name: "banana", Amount: 50}},
+			expected: SnapshotDiff{IDDrift: []StockMismatch{
+				{Name: "banana", Source: banana, Target: Stock{ID: 7, Name: "banana", Amount: 50}},
+			}},
+		},
+		{
+			name:     "比較元が空",
+			source:   nil,
+			target:   []Stock{apple},
+			expected: SnapshotDiff{Extra: []Stock{apple}},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			source, srcMock, err := sqlmock.New()
+			assert.NoError(t, err, "sqlmockの初期化に成功するべき")
+			defer source.Close()
+			target, dstMock, err := sqlmock.New()
+			assert.NoError(t, err, "sqlmockの初期化に成功するべき")
+			defer target.Close()
+
+			srcMock.ExpectQuery(orderedStocksRegex).WillReturnRows(newStockRows(tc.source...))
+			dstMock.ExpectQuery(orderedStocksRegex).WillReturnRows(newStockRows(tc.target...))
+
+			diff, err := CompareDatabases(context.Background(), source, target)
+
+			assert.NoError(t, err, "エラーが発生すべきでない")
+			assert.Equal(t, tc.expected, diff, "差分が期待通りであるべき")
+			assert.Equal(t, tc.expected.Empty(), diff.Empty(), "差分の有無が期待通りであるべき")
+			assert.Equal(t, tc.expected, DiffStocks(tc.source, tc.target), "DiffStocksも同じ差分を返すべき")
+			verifyExpectations(t, srcMock)
+			verifyExpectations(t, dstMock)
+		})
+	}
+}
+
+// TestCompareDatabases_QueryError は比較先の読み込みエラーが返ることをテストします
+func TestCompareDatabases_QueryError(t *testing.T) {
+	source, srcMock, _ := sqlmock.New()
+	defer source.Close()
+	target, dstMock, _ := sqlmock.New()
+	defer target.Close()
+
+	srcMock.ExpectQuery(orderedStocksRegex).WillReturnRows(newStockRows())
+	dstMock.ExpectQuery(orderedStocksRegex).WillReturnError(errors.New("query error"))
+
+	_, err := CompareDatabases(context.Background(), source, target)
+
+	assert.ErrorContains(t, err, "比較先の読み込みエラー", "どちらのDBで失敗したか分かるべき")
+}
+
+func TestRenderSnapshotDiff(t *testing.T) {
+	t.Run("差分なし", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, RenderSnapshotDiff(&buf, SnapshotDiff{}), "出力は成功するべき")
+		assert.Equal(t, "差分はありません\n", buf.String(), "差分なしのメッセージが出力されるべき")
+	})
+
+	t.Run("差分あり", func(t *testing.T) {
+		diff := SnapshotDiff{
+			Missing: []Stock{{ID: 2, Name: "banana", Amount: 50}},
+			Extra:   []Stock{{ID: 3, Name: "cherry", Amount: 75}},
+			AmountMismatches: []StockMismatch{
+				{Name: "apple", Source: Stock{ID: 1, Name: "apple", Amount: 100}, Target: Stock{ID: 1, Name: "apple", Amount: 90}},
+			},
+			IDDrift: []StockMismatch{
+				{Name: "grape", Source: Stock{ID: 4, Name: "grape", Amount: 5}, Target: Stock{ID: 9, Name: "grape", Amount: 5}},
+			},
+		}
+
+		var buf bytes.Buffer
+		assert.NoError(t, RenderSnapshotDiff(&buf, diff), "出力は成功するべき")
+		expected := "比較先にない: banana (id=2, amount=50)\n" +
+			"比較元にない: cherry (id=3, amount=75)\n" +
+			"在庫数の不一致: apple (比較元=100, 比較先=90)\n" +
+			"idのずれ: grape (比較元=4, 比較先=9)\n"
+		assert.Equal(t, expected, buf.String(), "差分が出力されるべき")
+	})
+}
+
+// withDBProfiles はテスト中だけdbProfilesを置き換えます
+func withDBProfiles(t *testing.T, profiles map[string]DBProfile) {
+	original := dbProfiles
+	dbProfiles = profiles
+	t.Cleanup(func() { dbProfiles = original })
+}
+
+func TestParseCompareArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantSource string
+		wantTarget string
+		wantErr    bool
+	}{
+		{name: "比較元と比較先", args: []string{"--profile", "old", "--profile", "new"}, wantSource: "old", wantTarget: "new"},
+		{name: "=での指定", args: []string{"--profile=old", "--profile=new"}, wantSource: "old", wantTarget: "new"},
+		{name: "指定なし", args: nil, wantErr: true},
+		{name: "1つだけ", args: []string{"--profile", "old"}, wantErr: true},
+		{name: "3つ", args: []string{"--profile", "a", "--profile", "b", "--profile", "c"}, wantErr: true},
+		{name: "同じプロファイル", args: []string{"--profile", "old", "--profile", "old"}, wantErr: true},
+		{name: "余分な引数", args: []string{"--profile", "old", "--profile", "new", "extra"}, wantErr: true},
+		{name: "不明なフラグ", args: []string{"--source", "old"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, target, err := parseCompareArgs(io.Discard, tt.args)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSource, source)
+			assert.Equal(t, tt.wantTarget, target)
+		})
+	}
+}
+
+// TestRunCompareCommand は2つのプロファイルの接続先を比較し、差分を書き出すことをテストします
+func TestRunCompareCommand(t *testing.T) {
+	withDBProfiles(t, map[string]DBProfile{
+		"old": {Host: "10.0.0.1"},
+		"new": {Host: "10.0.0.2", Name: "stocks_new"},
+	})
+	source, srcMock, _ := sqlmock.New()
+	target, dstMock, _ := sqlmock.New()
+	srcMock.ExpectQuery(orderedStocksRegex).WillReturnRows(newStockRows(Stock{ID: 1, Name: "apple", Amount: 100}))
+	dstMock.ExpectQuery(orderedStocksRegex).WillReturnRows(newStockRows(Stock{ID: 1, Name: "apple", Amount: 90}))
+
+	var dsns []string
+	withMockOpenDBFunc(t, func(driverName, dataSourceName string) (*sql.DB, error) {
+		dsns = append(dsns, dataSourceName)
+		if strings.Contains(dataSourceName, "10.0.0.1") {
+			return source, nil
+		}
+		return target, nil
+	}, func() {
+		var buf bytes.Buffer
+		err := runCompareCommand(context.Background(), &buf, []string{"--profile", "old", "--profile", "new"})
+
+		assert.True(t, errors.Is(err, ErrSnapshotDiffers), "差分がある場合はErrSnapshotDiffersを返すべき")
+		assert.Equal(t, "在庫数の不一致: apple (比較元=100, 比較先=90)\n", buf.String())
+	})
+
+	if assert.Len(t, dsns, 2) {
+		assert.Contains(t, dsns[0], "@tcp(10.0.0.1:3306)/"+dbName+"?", "指定していない項目は既定の接続先の値を使うべき")
+		assert.Contains(t, dsns[1], "@tcp(10.0.0.2:3306)/stocks_new?")
+	}
+	assert.NoError(t, srcMock.ExpectationsWereMet())
+	assert.NoError(t, dstMock.ExpectationsWereMet())
+}
+
+func TestRunCompareCommand_UnknownProfile(t *testing.T) {
+	withDBProfiles(t, map[string]DBProfile{"old": {Host: "10.0.0.1"}})
+
+	withMockOpenDBFunc(t, func(driverName, dataSourceName string) (*sql.DB, error) {
+		db, _, err := sqlmock.New()
+		return db, err
+	}, func() {
+		err := runCompareCommand(context.Background(), io.Discard, []string{"--profile", "old", "--profile", "missing"})
+
+		assert.True(t, errors.Is(err, ErrUnknownProfile))
+		assert.ErrorContains(t, err, "比較先（missing）")
+	})
+}
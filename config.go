@@ -1,5 +1,10 @@
 package main
 
+import (
+	"regexp"
+	"time"
+)
+
 // 本番要件に合わせて変更してください
 var (
 	dbHost     = "192.168.1.49"
@@ -8,3 +13,180 @@ var (
 	dbPassword = "your_db_password"
 	dbName     = "your_db_name"
 )
+
+// compareサブコマンドの--profileで指定する名前付きの接続先。指定していない項目はdbHostなどの既定の接続先の値を使う。
+// UserとPasswordには"provider://ref"形式で秘密情報の参照を指定できる
+// 例: "new": {Host: "10.0.0.12", Password: "env://DB_NEW_PASSWORD"}
+var dbProfiles = map[string]DBProfile{}
+
+// ソケットの読み書き1回あたりのタイムアウト（0の場合は設定しない）。
+// contextの期限とは別に、応答しなくなった接続をドライバが打ち切るために使う
+var (
+	dbReadTimeout  = 30 * time.Second
+	dbWriteTimeout = 30 * time.Second
+)
+
+// 書き込みのトランザクション全体の期限（0の場合は設定しない）。WithTransactionとUpsertStockContextがBeginTxのcontextに設定し、
+// 期限を超えると実行中の文を打ち切ってロールバックする
+var dbTxTimeout time.Duration
+
+// dbUserとdbPasswordに"provider://ref"形式で指定した秘密情報の参照を解決するプロバイダ
+// （例: "env://DB_PASSWORD", "file:///run/secrets/db_password"）
+var secretProviders = map[string]SecretProvider{
+	"env":  EnvSecretProvider{},
+	"file": FileSecretProvider{},
+}
+
+// RedactDSNが値を伏せるDSNのパラメータ（TLSの鍵や証明書のパスなど）
+var sensitiveDSNParams = []string{"sslkey", "sslcert", "sslrootcert", "serverPubKey"}
+
+// 接続のたびにユーザー名とパスワードを返すプロバイダ（nilの場合はdbUserとdbPasswordを起動時に解決した値で接続する）
+var credentialProvider CredentialProvider
+
+// SQL文の先頭にアプリケーション名、操作名と操作IDのコメント（/* app:db_mock op:UpsertStock id:... */）を付けるかどうか（--query-tags）。
+// コメントを扱えないドライバやプロキシを経由する場合はfalseにする
+var queryTaggingEnabled = true
+
+// プリペアドステートメントの設定
+var (
+	// 起動時に既知のSQL文を事前にPrepareし、リポジトリの読み取りをプリペアドステートメントで実行するかどうか
+	prepareStatementsOnStartup = false
+	// サーバー側プリペアを利用するかどうか（interpolateParams等で無効にする場合はfalse）
+	serverSidePrepare = true
+)
+
+// 商品名の書き込みルール（空文字列の場合は適用しない）
+var (
+	nameAllowPattern     = ""
+	nameDenyPattern      = ""
+	maxNewNamesPerImport = 0
+)
+
+// 商品名（商品コード）が一致しなければならない正規表現（nilの場合は適用しない）。例: regexp.MustCompile(`^[A-Z]{3}-\d{4}$`)
+// ValidateNameで確認するため、在庫を書き込むすべての操作で一致しない名前はErrNameRejectedになる
+var NamePattern *regexp.Regexp
+
+// stocksテーブルが存在しない場合に自動で作成して再実行するかどうか（--auto-migrate）
+var autoMigrate = false
+
+// 在庫の変更をstock_logテーブルに記録するかどうか
+var auditLogEnabled = false
+
+// 在庫の更新後に在庫数が変更履歴の合計と一致するか確認するかどうか（テスト・デバッグ用。auditLogEnabledと併用する）
+var verifyLedgerAfterWrite = false
+
+// 在庫数の合計をstock_totalsテーブルにキャッシュするかどうか
+var cachedTotalEnabled = false
+
+// 在庫を変更するトランザクションごとにstock_generationの世代番号を進めるかどうか。
+// serveサブコマンドの一覧のキャッシュは世代番号で無効化するため、在庫を書き込むすべてのプロセスで有効にする
+var tableGenerationEnabled = false
+
+// stocksの行を書き込むたびにrow_checksumを更新し、GetStockで読み取った行を検証するかどうか
+var rowChecksumEnabled = false
+
+// 行のチェックサムのHMACの鍵。"provider://ref"形式の秘密情報の参照を指定できる
+var rowChecksumKey = "env://DB_MOCK_ROW_CHECKSUM_KEY"
+
+// マイグレーションで追加した、stocksテーブルから追加で取得する列
+var optionalStockColumns = []string{}
+
+// 対応していない型の列を読み取った場合にエラーにするかどうか（falseの場合はドライバの値をそのまま返す）
+var strictScan = false
+
+// 起動時にメンテナンスモード（読み取りのみ許可）にするかどうか（--maintenance）。実行中はSIGUSR2で切り替えられる
+var maintenanceModeOnStart = false
+
+// ResetAutoIncrementのような破壊的なメンテナンス操作を許可するかどうか（テスト環境でのみtrueにする）
+var allowDestructiveMaintenance = false
+
+// クエリの実行結果（実行時間や読み取った行数）の通知先（nilの場合は通知しない）
+var queryObserver QueryObserver
+
+// 操作IDごとに同じ形の1行取得クエリの繰り返し（N+1）を検出する検出器（nilの場合は検出しない）
+var nPlusOneDetector *NPlusOneDetector
+
+// WithTransactionで開いたトランザクションの監視
+var (
+	// この時間を超えて開いているトランザクションを警告する（0の場合は警告しない）
+	txSoftThreshold = 30 * time.Second
+	// この時間を超えたトランザクションをtxHardCancelが有効な場合に打ち切る（0の場合は打ち切らない）
+	txHardThreshold = 10 * time.Minute
+	// txHardThresholdを超えたトランザクションのcontextをキャンセルしてロールバックさせるかどうか
+	txHardCancel = false
+)
+
+// レポートなど時間のかかる操作を専用の接続で実行し、ListActiveOperationsとCancelOperationで管理するかどうか
+var trackLongOperations = false
+
+// 利用者に表示するメッセージ（messageCatalog）の言語
+var messageLanguage = "ja"
+
+// 処理に失敗した場合にエラーの分類や対処をまとめたレポート（ErrorReport）を出力するかどうか（--verbose）
+var verboseErrors = false
+
+// コマンドラインで指定された日時を解釈するタイムゾーン
+var timeLocation = time.Local
+
+// 在庫数の変更の上限（0の場合は確認しない）。超えた場合はErrSuspiciousChangeになる
+var (
+	// 1回の操作での変更量の上限。新規挿入では挿入する在庫数に適用する
+	maxDeltaPerOperation = 0
+	// 変更前後の在庫数の比率の上限（例: 50なら50倍）
+	maxRelativeChange = 0.0
+	// 上限を超える変更でも適用するかどうか（--force）
+	forceLargeChange = false
+)
+
+// 登録できる商品の種類数の上限（0の場合は制限しない）。新しい商品の追加だけに適用し、超える場合はErrQuotaExceededになる
+var maxItems = 0
+
+// レプリケーション遅延の計測（CheckReplicationLag）の設定
+var (
+	// カナリアの行がレプリカから見えるまで待つ時間。超えた場合はErrReplicationLagTimeoutになる
+	replicationLagTimeout = 5 * time.Second
+	// レプリカにカナリアの行を問い合わせる間隔
+	replicationLagPollInterval = 100 * time.Millisecond
+	// カナリアの行を残しておく期間。これより古い行は計測のたびに削除する
+	canaryRetention = time.Hour
+)
+
+// mainProcess全体の処理時間の上限（--max-duration、0の場合は上限なし）。超えた場合はErrBudgetExceededになる
+var maxDuration time.Duration = 0
+
+// ロックの解放などの後始末に使う時間。処理時間の上限やキャンセルとは独立して与えられる
+var cleanupGracePeriod = 5 * time.Second
+
+// PingDatabasesで同時に接続を確認するデータベースの数
+var pingDatabasesConcurrency = 8
+
+// スキーマの作成とマイグレーションのロックを待つ時間。超えた場合はErrMigrationLockTimeoutになる
+var migrationLockTimeout = 30 * time.Second
+
+// GetStockAmountで同時の呼び出しが共有する問い合わせの期限。共有する問い合わせは呼び出し元のctxの期限を引き継がないため、
+// 応答しない問い合わせが残り続けないようにこの時間で打ち切る（0の場合は設定しない）
+var sharedQueryTimeout = 30 * time.Second
+
+// 実行の終了時にメトリクスを送信するPrometheus PushgatewayのURL（--push-metrics、空文字列の場合は送信しない）
+var pushMetricsURL = ""
+
+// Pushgatewayへの送信を打ち切るまでの時間。送信に失敗しても終了コードには影響しない
+var pushMetricsTimeout = 5 * time.Second
+
+// 処理ごとの再試行の設定（DBConfig.Retry）。ゼロ値の項目は再試行しない
+//
+//	Deadlock: デッドロックなどで失敗したWithTransactionのトランザクションをやり直す
+//	Connect:  メイン処理の接続確認を再試行する
+//	Webhook:  通知の送信を再試行する
+var retryPolicies = RetryConfig{}
+
+// 処理のオーバーヘッドを伴う任意機能の設定。
+// 設定ファイル（DB_MOCK_FEATURES_FILE）、DB_MOCK_*の環境変数、コマンドラインの順に上書きされる
+var features = Features{
+	CacheTTL:           time.Second,
+	BatchMaxSize:       100,
+	RateBurst:          1,
+	SlowQueryThreshold: 100 * time.Millisecond,
+	NPlusOneWindow:     time.Second,
+	NPlusOneThreshold:  10,
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// WithConn はプールから1つの接続を確保してfnを実行し、終了後にプールへ返します。
+// fnの中のクエリはすべて同じ接続で実行されるため、SET @varのようなセッション変数やセッション単位の設定が共有されます。
+// 読み取り関数のcontext対応版（QueryStocksTypedContextなど）はQueryerを受け取るため、connをそのまま渡せます。
+// fnのエラーはそのまま返します。
+func WithConn(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("接続の確保エラー: %w", classifyError(err))
+	}
+	defer conn.Close()
+	return fn(conn)
+}
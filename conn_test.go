@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWithConn は同じ接続で2つのクエリを実行できることをテストします
+func TestWithConn(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec(`SET @report_date = \?;`).WithArgs("2025-03-01").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "apple", 100, "fruit"))
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(130))
+
+	ctx := context.Background()
+	var (
+		stocks []Stock
+		total  int
+	)
+	err := WithConn(ctx, db, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, "SET @report_date = ?;", "2025-03-01"); err != nil {
+			return err
+		}
+		var err error
+		if stocks, err = QueryStocksTypedContext(ctx, conn, "apple"); err != nil {
+			return err
+		}
+		total, err = TotalStockAmountContext(ctx, conn)
+		return err
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Stock{{ID: 1, Name: "apple", Amount: 100, Category: "fruit"}}, stocks)
+	assert.Equal(t, 130, total)
+	assert.Equal(t, 0, db.Stats().InUse, "終了後は接続をプールへ返すべき")
+	verifyExpectations(t, mock)
+}
+
+// TestWithConn_Error はfnのエラーがそのまま返り、接続が返却されることをテストします
+func TestWithConn_Error(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	errCallback := errors.New("callback failed")
+	err := WithConn(context.Background(), db, func(conn *sql.Conn) error {
+		return errCallback
+	})
+
+	assert.True(t, errors.Is(err, errCallback), "fnのエラーが返るべき")
+	assert.Equal(t, 0, db.Stats().InUse)
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+)
+
+// CredentialProvider はDBに接続するユーザー名とパスワードを返します。
+// credentialProviderに設定すると、プールが新しい接続を作るたびに呼ばれ、その時点の値でDSNを組み立てます。
+// 接続中にローテーションされた認証情報も、再接続の時点で反映されます。
+type CredentialProvider func() (user, password string)
+
+// StaticCredentials は常に同じユーザー名とパスワードを返すCredentialProviderです。
+func StaticCredentials(user, password string) CredentialProvider {
+	return func() (string, string) {
+		return user, password
+	}
+}
+
+// credentialConnector は接続のたびにproviderから認証情報を取得してDSNを組み立てるdriver.Connectorです。
+type credentialConnector struct {
+	driver   driver.Driver
+	cfg      DBConfig
+	provider CredentialProvider
+}
+
+// Connect はproviderの認証情報で新しい接続を開きます。
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cfg := c.cfg
+	cfg.User, cfg.Password = c.provider()
+	if d, ok := c.driver.(driver.DriverContext); ok {
+		connector, err := d.OpenConnector(cfg.dsn())
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return c.driver.Open(cfg.dsn())
+}
+
+// Driver は接続に使うドライバを返します。
+func (c *credentialConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openWithCredentials はproviderの認証情報で接続するプールを返します。cfgのUserとPasswordは使いません。
+// sql.Openと同じく、この時点では接続を確立しません。
+func openWithCredentials(driverName string, cfg DBConfig, provider CredentialProvider) (*sql.DB, error) {
+	drv, err := registeredDriver(driverName)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(&credentialConnector{driver: drv, cfg: cfg, provider: provider}), nil
+}
+
+// registeredDriver はdatabase/sqlに登録されているドライバを返します。
+// 登録されていない場合（-tags nomysqlでビルドした場合）はErrDriverNotRegisteredを返します。
+func registeredDriver(driverName string) (driver.Driver, error) {
+	if !slices.Contains(sql.Drivers(), driverName) {
+		return nil, fmt.Errorf("%w: %s", ErrDriverNotRegistered, driverName)
+	}
+	// sql.Openは接続を確立しないため、ドライバを取り出すためだけに使う
+	db, err := sql.Open(driverName, "")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return db.Driver(), nil
+}
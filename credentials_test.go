@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDriverName はDSNを記録するテスト用ドライバの登録名です
+const recordingDriverName = "db_moc-recording"
+
+// recordingDriver は開いた接続のDSNを記録するテスト用のドライバです
+type recordingDriver struct {
+	mu   sync.Mutex
+	dsns []string
+}
+
+var (
+	testRecordingDriver   = &recordingDriver{}
+	registerRecordingOnce sync.Once
+)
+
+// useRecordingDriver はテスト用ドライバを登録し、記録をリセットして返します
+func useRecordingDriver(t *testing.T) *recordingDriver {
+	registerRecordingOnce.Do(func() { sql.Register(recordingDriverName, testRecordingDriver) })
+	testRecordingDriver.mu.Lock()
+	testRecordingDriver.dsns = nil
+	testRecordingDriver.mu.Unlock()
+	return testRecordingDriver
+}
+
+func (d *recordingDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dsns = append(d.dsns, dsn)
+	return recordingConn{}, nil
+}
+
+func (d *recordingDriver) opened() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.dsns...)
+}
+
+// recordingConn は何もしないテスト用の接続です
+type recordingConn struct{}
+
+func (recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (recordingConn) Close() error              { return nil }
+func (recordingConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+// TestOpenWithCredentials は新しい接続のたびにプロバイダが呼ばれ、その時点の認証情報がDSNに使われることをテストします
+func TestOpenWithCredentials(t *testing.T) {
+	drv := useRecordingDriver(t)
+
+	calls := 0
+	provider := func() (string, string) {
+		calls++
+		return "app", fmt.Sprintf("secret-v%d", calls)
+	}
+	cfg := DBConfig{Host: "db.local", Port: 3306, User: "ignored", Password: "ignored", Name: "stocks"}
+
+	db, err := openWithCredentials(recordingDriverName, cfg, provider)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Zero(t, calls, "開いただけでは接続しないべき")
+
+	// アイドル接続を残さないため、Pingのたびに新しい接続を開く
+	db.SetMaxIdleConns(0)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.PingContext(context.Background()))
+	}
+
+	assert.Equal(t, 3, calls, "接続のたびにプロバイダが呼ばれるべき")
+	assert.Equal(t, []string{
+		"app:secret-v1@tcp(db.local:3306)/stocks?parseTime=true",
+		"app:secret-v2@tcp(db.local:3306)/stocks?parseTime=true",
+		"app:secret-v3@tcp(db.local:3306)/stocks?parseTime=true",
+	}, drv.opened(), "ローテーションされた認証情報がDSNに使われるべき")
+}
+
+// TestOpenWithCredentials_Static はStaticCredentialsが常に同じ認証情報で接続することをテストします
+func TestOpenWithCredentials_Static(t *testing.T) {
+	drv := useRecordingDriver(t)
+
+	db, err := openWithCredentials(recordingDriverName, DBConfig{Host: "db.local", Port: 3306, Name: "stocks"}, StaticCredentials("app", "secret"))
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxIdleConns(0)
+
+	require.NoError(t, db.Ping())
+	require.NoError(t, db.Ping())
+
+	assert.Equal(t, []string{
+		"app:secret@tcp(db.local:3306)/stocks?parseTime=true",
+		"app:secret@tcp(db.local:3306)/stocks?parseTime=true",
+	}, drv.opened())
+}
+
+// TestOpenWithCredentials_DriverNotRegistered は登録されていないドライバではErrDriverNotRegisteredを返すことをテストします
+func TestOpenWithCredentials_DriverNotRegistered(t *testing.T) {
+	_, err := openWithCredentials("db_moc-missing", DBConfig{}, StaticCredentials("app", "secret"))
+
+	assert.True(t, errors.Is(err, ErrDriverNotRegistered), "ErrDriverNotRegisteredが返るべき")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+)
+
+// 生成する商品名の素材
+var (
+	genASCIIWords    = []string{"apple", "banana", "cherry", "grape", "lemon", "mango", "melon", "orange", "peach", "plum"}
+	genASCIIKinds    = []string{"juice", "jam", "pie", "tart", "chips", "candy", "cake", "tea"}
+	genJapaneseWords = []string{"りんご", "みかん", "抹茶", "煎餅", "醤油", "味噌", "昆布", "緑茶", "梅干し", "羊羹"}
+	genJapaneseKinds = []string{"セット", "詰め合わせ", "徳用", "小袋", "業務用"}
+	genEmoji         = []string{"🍎", "🍌", "🍒", "🍇", "🍋", "🥭", "🍈", "🍊", "🍑", "🍣"}
+)
+
+// GenOpts はGenerateStocksで生成するデータの傾向です。ゼロ値の場合はASCIIの商品名、0〜1000の一様な在庫数、重複なしです。
+type GenOpts struct {
+	// ASCIIWeight、JapaneseWeight、EmojiWeight は商品名の種類ごとの出現比率です。すべて0の場合はASCIIのみです。
+	// 絵文字の商品名はASCIIまたは日本語の語と組み合わせます。
+	ASCIIWeight    int
+	JapaneseWeight int
+	EmojiWeight    int
+	// MaxAmount は在庫数の上限です。0以下の場合は1000です。
+	MaxAmount int
+	// Skew は在庫数の偏りです。Skew個の一様乱数の最小値を使うため、大きいほど少ない在庫数に偏ります。1以下の場合は一様です。
+	Skew int
+	// DuplicateRate は既に生成した商品名を再び使う割合（0〜1）です。更新の衝突を試す場合に使います。
+	DuplicateRate float64
+}
+
+// GenerateStocks はseedから決定的にn件の在庫変更を生成します。
+// 同じseedとoptsからは、プラットフォームによらず常に同じデータが生成されます。
+// 乱数はPCGから引いた整数に基づき、重複させるかどうかの判定に使うFloat64もその整数から決定的に求まります。
+// 1件ごとに引く乱数の数はDuplicateRateによらず同じため、DuplicateRateだけを変えても重複させなかった行の商品名と在庫数は変わりません。
+func GenerateStocks(seed int64, n int, opts GenOpts) []StockUpdate {
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	maxAmount := opts.MaxAmount
+	if maxAmount <= 0 {
+		maxAmount = 1000
+	}
+
+	items := make([]StockUpdate, 0, n)
+	for i := 0; i < n; i++ {
+		// 乱数の消費順を固定するため、重複させるかどうかに関係なく重複元の位置も含めて同じ順で乱数を引く
+		duplicate := rng.Float64() < opts.DuplicateRate
+		name := generateName(rng, i, opts)
+		source := rng.IntN(max(len(items), 1))
+		if duplicate && len(items) > 0 {
+			name = items[source].Name
+		}
+		items = append(items, StockUpdate{Name: name, Amount: generateAmount(rng, maxAmount, opts.Skew)})
+	}
+	return items
+}
+
+// GenerateStocksSeeded はseedから決定的にn件の商品をStockとして生成します。
+// GenerateStocksの既定の傾向（ASCIIの商品名、0〜1000の一様な在庫数、重複なし）で生成するため、商品名は一意です。
+// IDとCategoryは設定しません。生成した商品はSeedStocksで投入できます。
+func GenerateStocksSeeded(n int, seed int64) []Stock {
+	items := GenerateStocks(seed, n, GenOpts{})
+	stocks := make([]Stock, len(items))
+	for i, item := range items {
+		stocks[i] = Stock{Name: item.Name, Amount: int64(item.Amount)}
+	}
+	return stocks
+}
+
+// generateName はopts の比率に従って商品名を生成します。i番目の商品名は連番により一意になります。
+func generateName(rng *rand.Rand, i int, opts GenOpts) string {
+	ascii, japanese, emoji := opts.ASCIIWeight, opts.JapaneseWeight, opts.EmojiWeight
+	if ascii+japanese+emoji <= 0 {
+		ascii = 1
+	}
+
+	pick := func(words []string) string { return words[rng.IntN(len(words))] }
+	switch r := rng.IntN(ascii + japanese + emoji); {
+	case r < ascii:
+		return fmt.Sprintf("%s-%s-%d", pick(genASCIIWords), pick(genASCIIKinds), i)
+	case r < ascii+japanese:
+		return fmt.Sprintf("%s%s-%d", pick(genJapaneseWords), pick(genJapaneseKinds), i)
+	default:
+		return fmt.Sprintf("%s%s-%d", pick(genEmoji), pick(genJapaneseWords), i)
+	}
+}
+
+// generateAmount は0以上maxAmount以下の在庫数を、skew個の一様乱数の最小値として生成します。
+func generateAmount(rng *rand.Rand, maxAmount, skew int) int {
+	amount := rng.IntN(maxAmount + 1)
+	for k := 1; k < skew; k++ {
+		amount = min(amount, rng.IntN(maxAmount+1))
+	}
+	return amount
+}
+
+// SeedDatabase は生成したデータを一括更新と同じ経路でdefaultImportBatchSize件ずつ投入します。
+// 生成データは変更量の上限による確認の対象外とします。
+func SeedDatabase(ctx context.Context, db *sql.DB, items []StockUpdate) (BulkResult, error) {
+	var total BulkResult
+	for start := 0; start < len(items); start += defaultImportBatchSize {
+		end := min(start+defaultImportBatchSize, len(items))
+		result, err := BulkUpsertStocksContext(ctx, db, items[start:end], ForceLargeChange())
+		if err != nil {
+			return total, fmt.Errorf("%d件目からの投入に失敗しました: %w", start+1, err)
+		}
+		total.merge(result)
+	}
+	return total, nil
+}
+
+// SeedStocks はstocksの商品名と在庫数をSeedDatabaseと同じ経路で投入します。IDとCategoryは使いません。
+func SeedStocks(ctx context.Context, db *sql.DB, stocks []Stock) (BulkResult, error) {
+	items := make([]StockUpdate, len(stocks))
+	for i, s := range stocks {
+		items[i] = StockUpdate{Name: s.Name, Amount: int(s.Amount)}
+	}
+	return SeedDatabase(ctx, db, items)
+}
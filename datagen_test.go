@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// checksumStocks は生成したデータの内容のチェックサムを返します
+func checksumStocks(items []StockUpdate) string {
+	h := sha256.New()
+	for _, item := range items {
+		fmt.Fprintf(h, "%s\t%d\n", item.Name, item.Amount)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// TestGenerateStocks_Deterministic は同じseedから常に同じデータが生成されることをテストします
+func TestGenerateStocks_Deterministic(t *testing.T) {
+	opts := GenOpts{ASCIIWeight: 5, JapaneseWeight: 3, EmojiWeight: 2, MaxAmount: 500, Skew: 2, DuplicateRate: 0.1}
+
+	items := GenerateStocks(42, 1000, opts)
+
+	assert.Len(t, items, 1000)
+	assert.Equal(t, "0f6a1eff7d13dd73a5dfed598d6a6e81502eedbc600303b1d6328dee9ff6eaf3", checksumStocks(items), "固定したseedのデータが変わってはならない")
+	assert.Equal(t, items, GenerateStocks(42, 1000, opts), "同じseedからは同じデータが生成されるべき")
+	assert.NotEqual(t, checksumStocks(items), checksumStocks(GenerateStocks(43, 1000, opts)), "seedが異なればデータも異なるべき")
+}
+
+// TestGenerateStocks_Names は商品名が検証を通り、指定した種類の文字を含むことをテストします
+func TestGenerateStocks_Names(t *testing.T) {
+	items := GenerateStocks(1, 500, GenOpts{ASCIIWeight: 1, JapaneseWeight: 1, EmojiWeight: 1})
+
+	var ascii, multibyte int
+	for _, item := range items {
+		assert.NoError(t, ValidateName(item.Name))
+		if utf8.RuneCountInString(item.Name) == len(item.Name) {
+			ascii++
+		} else {
+			multibyte++
+		}
+	}
+	assert.Greater(t, ascii, 100, "ASCIIの商品名が含まれるべき")
+	assert.Greater(t, multibyte, 250, "日本語や絵文字の商品名が含まれるべき")
+}
+
+// TestGenerateStocks_DuplicateRate は重複率の指定に応じて商品名が重複することをテストします
+func TestGenerateStocks_DuplicateRate(t *testing.T) {
+	distinct := func(items []StockUpdate) int {
+		names := make(map[string]bool)
+		for _, item := range items {
+			names[item.Name] = true
+		}
+		return len(names)
+	}
+
+	assert.Equal(t, 10000, distinct(GenerateStocks(7, 10000, GenOpts{})), "既定では重複しないべき")
+
+	// 重複させた行の割合はおよそ20%になる
+	d := distinct(GenerateStocks(7, 10000, GenOpts{DuplicateRate: 0.2}))
+	assert.InDelta(t, 8000, d, 200, "重複率に応じて商品名が重複するべき")
+}
+
+// TestGenerateStocks_DuplicateRateStable は同じseedでDuplicateRateだけを変えても、重複させなかった行と在庫数が変わらないことをテストします
+func TestGenerateStocks_DuplicateRateStable(t *testing.T) {
+	opts := GenOpts{ASCIIWeight: 2, JapaneseWeight: 1, MaxAmount: 500, Skew: 2}
+	base := GenerateStocks(11, 1000, opts)
+	unique := make(map[string]bool, len(base))
+	for _, item := range base {
+		unique[item.Name] = true
+	}
+
+	for _, rate := range []float64{0.1, 0.5, 0.9} {
+		opts.DuplicateRate = rate
+		items := GenerateStocks(11, 1000, opts)
+		duplicated := 0
+		for i, item := range items {
+			assert.Equal(t, base[i].Amount, item.Amount, "在庫数はDuplicateRateによらず同じであるべき（%d件目）", i)
+			if item.Name != base[i].Name {
+				duplicated++
+				assert.True(t, unique[item.Name], "重複させた行は先に生成した商品名を使うべき")
+			}
+		}
+		assert.InDelta(t, rate*1000, duplicated, 60, "重複率%vに応じて重複させるべき", rate)
+	}
+}
+
+// TestGenerateStocksSeeded は同じseedから同じ商品が生成され、商品名が一意であることをテストします
+func TestGenerateStocksSeeded(t *testing.T) {
+	stocks := GenerateStocksSeeded(2000, 42)
+
+	assert.Len(t, stocks, 2000)
+	assert.Equal(t, stocks, GenerateStocksSeeded(2000, 42), "同じseedからは同じ商品が生成されるべき")
+	assert.NotEqual(t, stocks, GenerateStocksSeeded(2000, 43), "seedが異なれば商品も異なるべき")
+
+	names := make(map[string]bool, len(stocks))
+	for _, s := range stocks {
+		assert.NoError(t, ValidateName(s.Name))
+		assert.False(t, names[s.Name], "商品名は一意であるべき: %s", s.Name)
+		names[s.Name] = true
+		assert.GreaterOrEqual(t, s.Amount, int64(0))
+		assert.LessOrEqual(t, s.Amount, int64(1000))
+	}
+}
+
+// TestGenerateStocks_Skew はSkewが大きいほど在庫数が少ない側に偏ることをテストします
+func TestGenerateStocks_Skew(t *testing.T) {
+	mean := func(items []StockUpdate) float64 {
+		sum := 0
+		for _, item := range items {
+			assert.GreaterOrEqual(t, item.Amount, 0)
+			assert.LessOrEqual(t, item.Amount, 100)
+			sum += item.Amount
+		}
+		return float64(sum) / float64(len(items))
+	}
+
+	uniform := mean(GenerateStocks(3, 5000, GenOpts{MaxAmount: 100}))
+	skewed := mean(GenerateStocks(3, 5000, GenOpts{MaxAmount: 100, Skew: 4}))
+
+	assert.InDelta(t, 50, uniform, 3)
+	assert.Less(t, skewed, uniform/2, "Skewを大きくすると在庫数が少ない側に偏るべき")
+}
+
+// TestSeedDatabase は生成したデータが一括更新の経路で投入されることをテストします
+func TestSeedDatabase(t *testing.T) {
+	withChangeLimits(t, 10, 0)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	items := []StockUpdate{{Name: "apple-jam-0", Amount: 500}, {Name: "apple-jam-0", Amount: 20}}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE`).
+		WithArgs("apple-jam-0").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+		WithArgs("apple-jam-0", 500).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE`).
+		WithArgs("apple-jam-0").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(500))
+	mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+		WithArgs(520, "apple-jam-0").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := SeedDatabase(context.Background(), db, items)
+
+	assert.NoError(t, err)
+	assert.Equal(t, BulkResult{Inserted: 1, Updated: 1}, result, "変更量の上限を超えても投入されるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestSeedStocks は生成した商品の商品名と在庫数が一括更新の経路で投入されることをテストします
+func TestSeedStocks(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	stocks := GenerateStocksSeeded(2, 1)
+	mock.ExpectBegin()
+	for _, s := range stocks {
+		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE`).
+			WithArgs(s.Name).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+			WithArgs(s.Name, int(s.Amount)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	result, err := SeedStocks(context.Background(), db, stocks)
+
+	assert.NoError(t, err)
+	assert.Equal(t, BulkResult{Inserted: 2}, result)
+	verifyExpectations(t, mock)
+}
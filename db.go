@@ -1,21 +1,78 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"db_moc/internal/stmt"
 )
 
 // sql.Open関数をラップした変数。これによりテスト時にモック化が可能になる。
-var openDBFunc = sql.Open
+var openDBFunc = openRegistered
+
+// mysqlDriverName はdatabase/sqlに登録されるMySQLドライバの名前です。
+const mysqlDriverName = "mysql"
+
+// openRegistered はドライバが登録されていることを確認してからsql.Openを呼びます。
+// 登録されていない場合（-tags nomysqlでビルドした場合）はErrDriverNotRegisteredを返します。
+func openRegistered(driverName, dataSourceName string) (*sql.DB, error) {
+	if !slices.Contains(sql.Drivers(), driverName) {
+		return nil, fmt.Errorf("%w: %s", ErrDriverNotRegistered, driverName)
+	}
+	return sql.Open(driverName, dataSourceName)
+}
+
+// stocksTable は在庫データのテーブル名です。
+const stocksTable = "stocks"
+
+// sqlDialect は発行するSQL文の方言です。
+const sqlDialect = stmt.MySQL
+
+// stocksテーブルに対して発行するSQL文。引数は各StatementのParamsの順に渡す
+var (
+	stmtStockAmount  = stmt.SelectAmountByName(stocksTable, sqlDialect)
+	stmtUpdateAmount = stmt.UpdateAmount(stocksTable, sqlDialect)
+	stmtInsertStock  = stmt.InsertStock(stocksTable, sqlDialect)
+	stmtUpsertAmount = stmt.UpsertAmount(stocksTable, sqlDialect)
+
+	stmtUpdateAmountWithCategory = stmt.UpdateAmountAndCategory(stocksTable, sqlDialect)
+	stmtInsertStockWithCategory  = stmt.InsertStockWithCategory(stocksTable, sqlDialect)
+)
 
 // ConnectDB はMySQLデータベースへの接続を確立します。
+// ユーザー名とパスワードの秘密情報の参照は接続のたびにsecretProvidersで解決するため、ローテーションされた値も反映されます。
+// credentialProviderが設定されている場合は、プールが新しい接続を作るたびにそこから認証情報を取得します。
+// -tags nomysqlでビルドしてドライバがリンクされていない場合はErrDriverNotRegisteredを返します。
 func ConnectDB() (*sql.DB, error) {
-	// DSNフォーマット: user:password@tcp(host:port)/dbname?parseTime=true
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		dbUser, dbPassword, dbHost, dbPort, dbName)
-	db, err := openDBFunc("mysql", dsn)
+	if credentialProvider != nil {
+		return openWithCredentials(mysqlDriverName, currentDBConfig(), credentialProvider)
+	}
+	return openDBConfig(currentDBConfig())
+}
+
+// ConnectProfile はdbProfilesに登録したnameの接続先に接続します。
+// credentialProviderは既定の接続先のためのものなので使わず、UserとPasswordの秘密情報の参照はsecretProvidersで解決します。
+func ConnectProfile(name string) (*sql.DB, error) {
+	cfg, err := profileDBConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	return openDBConfig(cfg)
+}
+
+// openDBConfig はcfgの秘密情報の参照を解決し、DSNで接続します。
+func openDBConfig(cfg DBConfig) (*sql.DB, error) {
+	cfg, err := ResolveSecrets(context.Background(), cfg, secretProviders)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openDBFunc(mysqlDriverName, cfg.dsn())
 	if err != nil {
 		return nil, err
 	}
@@ -24,33 +81,65 @@ func ConnectDB() (*sql.DB, error) {
 
 // PingDB はデータベース接続を確認します。
 func PingDB(db *sql.DB) error {
-	return db.Ping()
+	return PingDBContext(context.Background(), db)
+}
+
+// PingDBContext はPingDBのcontext対応版です。
+func PingDBContext(ctx context.Context, db *sql.DB) error {
+	return db.PingContext(ctx)
+}
+
+// PingDatabases はシャードごとの複数のデータベースへの接続を並行して確認し、シャード名ごとの結果を返します。
+// 成功したシャードの値はnilです。同時に確認するのはpingDatabasesConcurrency件までで、
+// 応答しないシャードがあっても他のシャードの確認は待たせません。全体の期限はctxで指定してください。
+func PingDatabases(ctx context.Context, dbs map[string]*sql.DB) map[string]error {
+	workers := min(max(pingDatabasesConcurrency, 1), len(dbs))
+	names := make(chan string)
+	results := make(map[string]error, len(dbs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				err := dbs[name].PingContext(ctx)
+				mu.Lock()
+				results[name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	for name := range dbs {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+	return results
 }
 
 // QueryStocks は名前に一致する全ての行をstocksテーブルから取得するためのSELECTクエリを実行します。
-// 空の名前文字列を渡した場合は、すべての在庫データを返します。
+// 空の名前文字列を渡した場合はWHERE句も引数もないSELECTを実行し、stocksテーブルの全行を返します。
+// 空白だけの名前は空の名前として扱わず、その名前に一致する行を検索します。
 func QueryStocks(db *sql.DB, name string) ([]map[string]interface{}, error) {
-	var (
-		query string
-		rows  *sql.Rows
-		err   error
-	)
-
-	if name == "" {
-		// 名前が空の場合は全レコードを取得
-		query = "SELECT * FROM stocks;"
-		rows, err = db.Query(query)
-	} else {
-		// 特定の名前に一致するレコードを取得
-		query = "SELECT * FROM stocks WHERE name = ?;"
-		rows, err = db.Query(query, name)
-	}
+	return QueryStocksContext(context.Background(), db, name)
+}
 
+// QueryStocksContext はQueryStocksのcontext対応版です。
+func QueryStocksContext(ctx context.Context, q Queryer, name string) ([]map[string]interface{}, error) {
+	rows, obs, err := queryStocksRows(ctx, q, name)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	results, err := scanRowMaps(rows)
+	obs.done(len(results), err)
+	return results, err
+}
+
+// scanRowMaps は行セットの全行を列名をキーとするmapとして読み取ります。
+func scanRowMaps(rows *sql.Rows) ([]map[string]interface{}, error) {
 	columns, err := rows.Columns()
 	if err != nil {
 		return nil, err
@@ -68,12 +157,11 @@ func QueryStocks(db *sql.DB, name string) ([]map[string]interface{}, error) {
 		}
 		rowData := make(map[string]interface{})
 		for i, colName := range columns {
-			val := columnValues[i]
-			if b, ok := val.([]byte); ok {
-				rowData[colName] = string(b)
-			} else {
-				rowData[colName] = val
+			val, err := convertColumnValue(colName, columnValues[i])
+			if err != nil {
+				return nil, err
 			}
+			rowData[colName] = val
 		}
 		results = append(results, rowData)
 	}
@@ -83,15 +171,123 @@ func QueryStocks(db *sql.DB, name string) ([]map[string]interface{}, error) {
 	return results, nil
 }
 
+// convertColumnValue はドライバから受け取った列の値をmapに格納する値に変換します。
+// []byteは文字列に変換し、それ以外の対応している型はそのまま返します。
+// 対応していない型の場合、strictScanが有効であればErrUnsupportedColumnTypeを返し、無効であればそのまま返します。
+func convertColumnValue(column string, val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case nil, int64, float64, bool, string, time.Time:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	default:
+		if strictScan {
+			return nil, fmt.Errorf("%w: 列 %s (%T)", ErrUnsupportedColumnType, column, val)
+		}
+		return v, nil
+	}
+}
+
+// queryStocksRows は名前に応じたSELECTクエリを実行し、結果の行セットを返します。
+// 空の名前文字列を渡した場合は全レコードを取得します。
+// 呼び出し側は行を読み終えたら、読み取った行数を添えて返されたqueryObservationのdoneを呼びます。
+func queryStocksRows(ctx context.Context, q Queryer, name string) (*sql.Rows, queryObservation, error) {
+	// 名前が空の場合は全レコードを取得
+	query, args := queryAllStocks(), []interface{}{}
+	if name != "" {
+		// 特定の名前に一致するレコードを取得
+		query, args = queryStocksByName(), []interface{}{name}
+	}
+	obs := observeQuery(query)
+	rows, err := q.QueryContext(ctx, taggedSQL(ctx, "QueryStocks", query), args...)
+	if err != nil {
+		obs.done(0, err)
+		return nil, obs, newQueryError(ctx, "QueryStocks", query, err)
+	}
+	return rows, obs, nil
+}
+
 // UpsertStock は在庫データを更新または挿入します。
 // nameが既に存在する場合はamountを加算し、存在しない場合は新規レコードを作成します。
-func UpsertStock(db *sql.DB, name string, amount int) error {
+// 変更が上限を超える場合はErrSuspiciousChangeを返します。意図した変更であればForceLargeChangeを指定します。
+func UpsertStock(db *sql.DB, name string, amount int, opts ...UpsertOption) error {
+	return UpsertStockContext(context.Background(), db, name, amount, opts...)
+}
+
+// UpsertStockContext はUpsertStockのcontext対応版です。
+func UpsertStockContext(ctx context.Context, db *sql.DB, name string, amount int, opts ...UpsertOption) error {
+	_, err := upsertStock(ctx, db, name, amount, "", newUpsertOptions(opts))
+	return err
+}
+
+// MutationResult はUpsertStockDetailedで行った変更の内容です。
+type MutationResult struct {
+	// Operation は行った操作で、新規挿入の場合は"insert"、既存の在庫数の更新の場合は"update"です。
+	Operation string
+	// OldAmount は変更前の在庫数です。新規挿入の場合は0です。
+	OldAmount int
+	// NewAmount は変更後の在庫数です。
+	NewAmount int
+	// RowsAffected はUPDATEまたはINSERTが変更した行数です。
+	RowsAffected int64
+	// Duration は在庫数の確認からコミットまでにかかった時間です。
+	Duration time.Duration
+}
+
+// UpsertStockDetailed はUpsertStockと同じく在庫データを更新または挿入し、行った操作、変更前後の在庫数、変更した行数と所要時間を返します。
+func UpsertStockDetailed(db *sql.DB, name string, amount int) (MutationResult, error) {
+	return UpsertStockDetailedContext(context.Background(), db, name, amount)
+}
+
+// UpsertStockDetailedContext はUpsertStockDetailedのcontext対応版です。
+func UpsertStockDetailedContext(ctx context.Context, db *sql.DB, name string, amount int) (MutationResult, error) {
+	return upsertStock(ctx, db, name, amount, "", upsertOptions{})
+}
+
+// UpsertStockWithCategory はUpsertStockと同様に在庫データを更新または挿入し、あわせてカテゴリを設定します。
+// categoryが空の場合はdefaultCategoryを設定します。CheckStockColumnsがcategory列がないと確認している場合はErrColumnDriftを返します。
+func UpsertStockWithCategory(db *sql.DB, name string, amount int, category string, opts ...UpsertOption) error {
+	return UpsertStockWithCategoryContext(context.Background(), db, name, amount, category, opts...)
+}
+
+// UpsertStockWithCategoryContext はUpsertStockWithCategoryのcontext対応版です。
+func UpsertStockWithCategoryContext(ctx context.Context, db *sql.DB, name string, amount int, category string, opts ...UpsertOption) error {
+	if err := requireCategoryColumn(); err != nil {
+		return err
+	}
+	if category == "" {
+		category = defaultCategory
+	}
+	_, err := upsertStock(ctx, db, name, amount, category, newUpsertOptions(opts))
+	return err
+}
+
+// upsertStock はUpsertStockとUpsertStockWithCategoryの共通処理です。
+// categoryが空の場合はcategory列に触れず、新規挿入時はテーブルの既定値が使われます。
+// トランザクションにはWithTransactionと同じくDBConfig.TxTimeoutの期限を設けます。行った変更の内容はMutationResultで返します。
+func upsertStock(ctx context.Context, db *sql.DB, name string, amount int, category string, opts upsertOptions) (result MutationResult, err error) {
+	start := time.Now()
+	if err := checkWritable(); err != nil {
+		return MutationResult{}, err
+	}
+	if err := ValidateName(name); err != nil {
+		return MutationResult{}, err
+	}
+
 	// 最初にnameが存在するか確認
 	var existingAmount int
 	var exists bool
 
-	query := "SELECT amount FROM stocks WHERE name = ?;"
-	err := db.QueryRow(query, name).Scan(&existingAmount)
+	obs := observeQuery(stmtStockAmount.SQL)
+	err = stmtCacheQueryer(ctx, db).QueryRowContext(ctx, taggedSQL(ctx, "UpsertStock", stmtStockAmount.SQL), name).Scan(&existingAmount)
+	switch err {
+	case nil:
+		obs.done(1, nil)
+	case sql.ErrNoRows:
+		obs.done(0, nil)
+	default:
+		obs.done(0, err)
+	}
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -99,40 +295,155 @@ func UpsertStock(db *sql.DB, name string, amount int) error {
 			exists = false
 		} else {
 			// その他のエラーが発生した場合
-			return fmt.Errorf("データ確認中にエラーが発生: %v", err)
+			return MutationResult{}, fmt.Errorf("データ確認中にエラーが発生: %w", newQueryError(ctx, "UpsertStock", stmtStockAmount.SQL, err))
 		}
 	} else {
 		exists = true
 	}
 
-	// トランザクション開始
-	tx, err := db.Begin()
+	// 桁違いの入力などによる想定外の変更を防ぐ
+	if err := checkStockChange(name, existingAmount, existingAmount+amount, exists, opts); err != nil {
+		return MutationResult{}, err
+	}
+
+	// トランザクション開始。期限を超えた場合は実行中の文を打ち切ってロールバックする
+	txCtx, cancel := withTxTimeout(ctx)
+	defer cancel()
+	defer func() {
+		if err != nil {
+			err = txError(txCtx, err)
+		}
+	}()
+	tx, err := db.BeginTx(txCtx, nil)
 	if err != nil {
-		return fmt.Errorf("トランザクション開始エラー: %v", err)
+		return MutationResult{}, fmt.Errorf("トランザクション開始エラー: %w", err)
 	}
 	defer tx.Rollback() // エラー発生時にロールバック
 
+	var execResult sql.Result
 	if exists {
 		// 既存レコードの更新
 		newAmount := existingAmount + amount
-		updateQuery := "UPDATE stocks SET amount = ? WHERE name = ?;"
-		_, err = tx.Exec(updateQuery, newAmount, name)
+		result = MutationResult{Operation: operationUpdate, OldAmount: existingAmount, NewAmount: newAmount}
+		statement := stmtUpdateAmount.SQL
+		if category == "" {
+			execResult, err = execTx(txCtx, tx, taggedSQL(txCtx, "UpsertStock", statement), newAmount, name)
+		} else {
+			statement = stmtUpdateAmountWithCategory.SQL
+			execResult, err = execTx(txCtx, tx, taggedSQL(txCtx, "UpsertStock", statement), newAmount, category, name)
+		}
 		if err != nil {
-			return fmt.Errorf("データ更新エラー: %v", err)
+			return MutationResult{}, fmt.Errorf("データ更新エラー: %w", newQueryError(txCtx, "UpsertStock", statement, err))
+		}
+		if err := recordStockLog(txCtx, tx, name, operationUpdate, amount, newAmount); err != nil {
+			return MutationResult{}, err
+		}
+		if err := recordStockTotal(txCtx, tx, amount); err != nil {
+			return MutationResult{}, err
 		}
 	} else {
 		// 新規レコード挿入
-		insertQuery := "INSERT INTO stocks (name, amount) VALUES (?, ?);"
-		_, err = tx.Exec(insertQuery, name, amount)
+		if err := checkItemQuota(txCtx, tx, name); err != nil {
+			return MutationResult{}, err
+		}
+		result = MutationResult{Operation: operationInsert, NewAmount: amount}
+		statement := stmtInsertStock.SQL
+		if category == "" {
+			execResult, err = execTx(txCtx, tx, taggedSQL(txCtx, "UpsertStock", statement), name, amount)
+		} else {
+			statement = stmtInsertStockWithCategory.SQL
+			execResult, err = execTx(txCtx, tx, taggedSQL(txCtx, "UpsertStock", statement), name, amount, category)
+		}
 		if err != nil {
-			return fmt.Errorf("データ挿入エラー: %v", err)
+			return MutationResult{}, fmt.Errorf("データ挿入エラー: %w", newQueryError(txCtx, "UpsertStock", statement, err))
 		}
+		if err := recordStockLog(txCtx, tx, name, operationInsert, amount, amount); err != nil {
+			return MutationResult{}, err
+		}
+		if err := recordStockTotal(txCtx, tx, amount); err != nil {
+			return MutationResult{}, err
+		}
+	}
+	if result.RowsAffected, err = execResult.RowsAffected(); err != nil {
+		return MutationResult{}, fmt.Errorf("更新件数の取得エラー: %w", err)
+	}
+	if err := updateRowChecksum(txCtx, tx, name); err != nil {
+		return MutationResult{}, err
+	}
+
+	if err := bumpGeneration(txCtx, tx); err != nil {
+		return MutationResult{}, err
 	}
 
 	// トランザクションをコミット
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("トランザクションコミットエラー: %v", err)
+		return MutationResult{}, fmt.Errorf("トランザクションコミットエラー: %w", err)
+	}
+
+	result.Duration = time.Since(start)
+
+	// デバッグ時はコミット後の在庫数を変更履歴と照合する
+	if err := verifyLedger(ctx, db, name); err != nil {
+		return MutationResult{}, err
+	}
+	return result, nil
+}
+
+// ErrAtomicUpsertUnsupported はUpsertStockAtomicと併用できない機能が有効な場合に返されます。
+var ErrAtomicUpsertUnsupported = errors.New("1文での在庫の更新と併用できない機能が有効です")
+
+// UpsertStockAtomic はUpsertStockと同じく在庫数を加算または挿入しますが、INSERT ... ON DUPLICATE KEY UPDATEの1文で行います。
+// 在庫数を読み取らずに書き込むため、変更前後の在庫数を必要とする機能と併用できません。変更履歴（auditLogEnabled）、
+// 行のチェックサム（rowChecksumEnabled）、在庫数の合計のキャッシュ（cachedTotalEnabled）、世代番号（tableGenerationEnabled）、
+// 種類数の上限（maxItems）、変化率の上限（maxRelativeChange）のいずれかが有効な場合は、何もせずに有効な設定の名前を含む
+// ErrAtomicUpsertUnsupportedを返します。変更量の上限（maxDeltaPerOperation）は変更前の在庫数によらないため確認します。
+// メンテナンスモード中はErrMaintenanceModeを返します。
+func UpsertStockAtomic(db *sql.DB, name string, amount int) error {
+	return UpsertStockAtomicContext(context.Background(), db, name, amount)
+}
+
+// UpsertStockAtomicContext はUpsertStockAtomicのcontext対応版です。
+func UpsertStockAtomicContext(ctx context.Context, db *sql.DB, name string, amount int) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if features := atomicUpsertConflicts(); len(features) > 0 {
+		return fmt.Errorf("%w: %s", ErrAtomicUpsertUnsupported, strings.Join(features, ", "))
+	}
+	// 変更量の上限は変更前の在庫数によらないため、amountだけで確認できる
+	if err := checkStockChange(name, 0, amount, false, upsertOptions{}); err != nil {
+		return err
 	}
 
+	obs := observeQuery(stmtUpsertAmount.SQL)
+	_, err := db.ExecContext(ctx, taggedSQL(ctx, "UpsertStockAtomic", stmtUpsertAmount.SQL), name, amount)
+	obs.done(0, err)
+	if err != nil {
+		return fmt.Errorf("データ更新エラー: %w", newQueryError(ctx, "UpsertStockAtomic", stmtUpsertAmount.SQL, err))
+	}
 	return nil
 }
+
+// atomicUpsertConflicts はUpsertStockAtomicと併用できない有効な機能の設定名を返します。
+func atomicUpsertConflicts() []string {
+	var features []string
+	for _, f := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"auditLogEnabled", auditLogEnabled},
+		{"rowChecksumEnabled", rowChecksumEnabled},
+		{"cachedTotalEnabled", cachedTotalEnabled},
+		{"tableGenerationEnabled", tableGenerationEnabled},
+		{"maxItems", maxItems > 0},
+		{"maxRelativeChange", maxRelativeChange > 0},
+	} {
+		if f.enabled {
+			features = append(features, f.name)
+		}
+	}
+	return features
+}
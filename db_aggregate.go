@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// TotalStockAmount はstocksテーブルの在庫数の合計を返します。
+// テーブルが空の場合は0を返します。
+func TotalStockAmount(db *sql.DB) (int, error) {
+	return TotalStockAmountContext(context.Background(), db)
+}
+
+// TotalStockAmountContext はTotalStockAmountのcontext対応版です。
+func TotalStockAmountContext(ctx context.Context, q Queryer) (int, error) {
+	var total int
+	if err := q.QueryRowContext(ctx, SQLTotalAmount).Scan(&total); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// CountStocks はstocksテーブルの商品数と、そのうち在庫切れ（在庫数が0以下）の商品数を返します。
+func CountStocks(db *sql.DB) (products int, outOfStock int, err error) {
+	return CountStocksContext(context.Background(), db)
+}
+
+// CountStocksContext はCountStocksのcontext対応版です。
+func CountStocksContext(ctx context.Context, q Queryer) (products int, outOfStock int, err error) {
+	if err := q.QueryRowContext(ctx, SQLCountStocks).Scan(&products, &outOfStock); err != nil {
+		return 0, 0, err
+	}
+	return products, outOfStock, nil
+}
+
+// TopStocks は在庫数の多い順に最大limit件の在庫データを返します。在庫数が同じ場合は名前順です。
+func TopStocks(db *sql.DB, limit int) ([]Stock, error) {
+	return TopStocksContext(context.Background(), db, limit)
+}
+
+// TopStocksContext はTopStocksのcontext対応版です。
+func TopStocksContext(ctx context.Context, q Queryer, limit int) ([]Stock, error) {
+	query := "SELECT " + stockSelectList() + " FROM stocks ORDER BY amount DESC, name LIMIT ?;"
+	obs := observeQuery(query)
+	rows, err := q.QueryContext(ctx, query, limit)
+	if err != nil {
+		obs.done(0, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanStocks(rows)
+	obs.done(len(results), err)
+	return results, err
+}
+
+// EstimateRowCount はinformation_schema.TABLESのTABLE_ROWSからstocksテーブルの行数の推定値を返します。
+// COUNT(*)と違って全件を走査しないため大きなテーブルでも速いですが、値はあくまで概算です。
+// InnoDBでは統計情報に基づくため、実際の行数と大きく異なることがあります（MySQL固有）。
+// テーブルが存在しない場合はErrSchemaMissingを返します。
+func EstimateRowCount(db *sql.DB) (int64, error) {
+	return EstimateRowCountContext(context.Background(), db)
+}
+
+// EstimateRowCountContext はEstimateRowCountのcontext対応版です。
+func EstimateRowCountContext(ctx context.Context, db *sql.DB) (int64, error) {
+	var rows sql.NullInt64
+	err := db.QueryRowContext(ctx, SQLEstimateRowCount).Scan(&rows)
+	if err == sql.ErrNoRows {
+		return 0, ErrSchemaMissing
+	}
+	if err != nil {
+		return 0, err
+	}
+	// 統計情報がない場合はNULLになるため0として扱う
+	return rows.Int64, nil
+}
+
+// MedianStockAmount はstocksテーブルの在庫数の中央値を返します。
+// 移植性のため在庫数を昇順で読み出してGo側で計算します。行数が偶数の場合は中央2値の平均です。
+// テーブルが空の場合は0とErrNoStocksを返します。
+func MedianStockAmount(db *sql.DB) (float64, error) {
+	return MedianStockAmountContext(context.Background(), db)
+}
+
+// MedianStockAmountContext はMedianStockAmountのcontext対応版です。
+func MedianStockAmountContext(ctx context.Context, db *sql.DB) (float64, error) {
+	// 全件を読み出すクエリなので、読み取った行数を計測する
+	obs := observeQuery(SQLAmountsAscending)
+	amounts, err := scanAmounts(ctx, db, SQLAmountsAscending)
+	obs.done(len(amounts), err)
+	if err != nil {
+		return 0, err
+	}
+
+	n := len(amounts)
+	if n == 0 {
+		return 0, ErrNoStocks
+	}
+	if n%2 == 1 {
+		return float64(amounts[n/2]), nil
+	}
+	return float64(amounts[n/2-1]+amounts[n/2]) / 2, nil
+}
+
+// scanAmounts はqueryの結果の1列目を在庫数として全行読み取ります。
+func scanAmounts(ctx context.Context, db *sql.DB, query string) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var amounts []int64
+	for rows.Next() {
+		var amount int64
+		if err := rows.Scan(&amount); err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, amount)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return amounts, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTotalStockAmount(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupMock   func(mock sqlmock.Sqlmock)
+		expected    int
+		expectError bool
+	}{
+		{
+			name: "合計値を取得",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+					WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(250))
+			},
+			expected: 250,
+		},
+		{
+			name: "空のテーブルは0",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+					WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(0))
+			},
+			expected: 0,
+		},
+		{
+			name: "クエリエラー",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+					WillReturnError(errors.New("query error"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			tc.setupMock(mock)
+
+			total, err := TotalStockAmount(db)
+			if tc.expectError {
+				assert.Error(t, err, "エラーが発生するべき")
+			} else {
+				assert.NoError(t, err, "エラーが発生すべきでない")
+				assert.Equal(t, tc.expected, total, "合計値が期待通りであるべき")
+			}
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+func TestMedianStockAmount(t *testing.T) {
+	tests := []struct {
+		name        string
+		amounts     []int64
+		expected    float64
+		expectedErr error
+	}{
+		{name: "奇数件", amounts: []int64{10, 20, 300}, expected: 20},
+		{name: "偶数件", amounts: []int64{10, 20, 30, 300}, expected: 25},
+		{name: "偶数件で端数あり", amounts: []int64{1, 2}, expected: 1.5},
+		{name: "1件", amounts: []int64{42}, expected: 42},
+		{name: "空のテーブル", amounts: nil, expected: 0, expectedErr: ErrNoStocks},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			rows := sqlmock.NewRows([]string{"amount"})
+			for _, a := range tc.amounts {
+				rows.AddRow(a)
+			}
+			mock.ExpectQuery(sqlPattern(SQLAmountsAscending)).WillReturnRows(rows)
+
+			median, err := MedianStockAmount(db)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr, "期待するエラーであるべき")
+			} else {
+				assert.NoError(t, err, "エラーが発生すべきでない")
+			}
+			assert.Equal(t, tc.expected, median, "中央値が期待通りであるべき")
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+func TestEstimateRowCount(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		setupMock   func(mock sqlmock.Sqlmock)
+		expected    int64
+		expectedErr error
+	}{
+		{
+			name: "推定値を返す",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlPattern(SQLEstimateRowCount)).
+					WillReturnRows(sqlmock.NewRows([]string{"TABLE_ROWS"}).AddRow(1234567))
+			},
+			expected: 1234567,
+		},
+		{
+			name: "統計情報がない場合は0",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlPattern(SQLEstimateRowCount)).
+					WillReturnRows(sqlmock.NewRows([]string{"TABLE_ROWS"}).AddRow(nil))
+			},
+			expected: 0,
+		},
+		{
+			name: "テーブルが存在しない",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlPattern(SQLEstimateRowCount)).
+					WillReturnRows(sqlmock.NewRows([]string{"TABLE_ROWS"}))
+			},
+			expectedErr: ErrSchemaMissing,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+			tc.setupMock(mock)
+
+			count, err := EstimateRowCount(db)
+
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err, "エラーが発生すべきでない")
+				assert.Equal(t, tc.expected, count, "推定値が期待通りであるべき")
+			}
+			verifyExpectations(t, mock)
+		})
+	}
+}
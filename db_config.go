@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DBConfig はDB接続の設定です。UserとPasswordには"provider://ref"形式で秘密情報の参照を指定できます。
+type DBConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+	// ReadTimeout とWriteTimeout はソケットの読み書き1回あたりのタイムアウトです（0の場合は設定しない）。
+	// contextの期限はクエリ全体を打ち切りますが、応答のないソケットはドライバが読み書きを終えるまで検知できません。
+	// これらはネットワーク障害などで止まったソケットをドライバ自身が打ち切るために使います。
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// TxTimeout は書き込みのトランザクション全体の期限です（0の場合は設定しない）。
+	// ソケットのタイムアウトと違い、文を実行し続けているトランザクションも期限で打ち切ってロールバックします。
+	TxTimeout time.Duration
+	// Features はキャッシュやまとめての適用など、任意機能の設定です。
+	Features Features
+	// Retry は再試行する処理ごとの再試行の設定です。
+	Retry RetryConfig
+}
+
+// currentDBConfig はconfig.goの設定からDBConfigを返します。
+func currentDBConfig() DBConfig {
+	return DBConfig{
+		Host:         dbHost,
+		Port:         dbPort,
+		User:         dbUser,
+		Password:     dbPassword,
+		Name:         dbName,
+		ReadTimeout:  dbReadTimeout,
+		WriteTimeout: dbWriteTimeout,
+		TxTimeout:    dbTxTimeout,
+		Features:     features,
+		Retry:        retryPolicies,
+	}
+}
+
+// ErrUnknownProfile はdbProfilesに登録されていない接続先の名前が指定された場合に返されます。
+var ErrUnknownProfile = errors.New("接続先のプロファイルが登録されていません")
+
+// DBProfile はdbProfilesに登録する名前付きの接続先です。空の項目は既定の接続先の値を使います。
+type DBProfile struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+}
+
+// profileDBConfig はdbProfilesのnameの接続先で既定の接続先を上書きしたDBConfigを返します。
+// タイムアウトや任意機能の設定は既定の接続先と同じです。
+func profileDBConfig(name string) (DBConfig, error) {
+	profile, ok := dbProfiles[name]
+	if !ok {
+		return DBConfig{}, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+	cfg := currentDBConfig()
+	if profile.Host != "" {
+		cfg.Host = profile.Host
+	}
+	if profile.Port != 0 {
+		cfg.Port = profile.Port
+	}
+	if profile.User != "" {
+		cfg.User = profile.User
+	}
+	if profile.Password != "" {
+		cfg.Password = profile.Password
+	}
+	if profile.Name != "" {
+		cfg.Name = profile.Name
+	}
+	return cfg, nil
+}
+
+// dsn はMySQLドライバに渡すDSNを返します。
+func (c DBConfig) dsn() string {
+	// DSNフォーマット: user:password@tcp(host:port)/dbname?parseTime=true&readTimeout=30s&writeTimeout=30s
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", c.User, c.Password, c.Host, c.Port, c.Name)
+	if c.ReadTimeout > 0 {
+		dsn += "&readTimeout=" + c.ReadTimeout.String()
+	}
+	if c.WriteTimeout > 0 {
+		dsn += "&writeTimeout=" + c.WriteTimeout.String()
+	}
+	return dsn
+}
+
+// RedactDSN はDSNのパスワードと、sensitiveDSNParamsに含まれるパラメータの値をredactedに置き換えた、ログに出力できる文字列を返します。
+// パラメータ名の比較は大文字小文字を区別しません。DSNの形式はuser:password@net(addr)/dbname?param=valueです。
+func RedactDSN(dsn string) string {
+	// MySQLドライバと同じく、最後の/より前の最後の@までを認証情報として扱う
+	userinfo, rest := "", dsn
+	head := dsn
+	if slash := strings.LastIndex(dsn, "/"); slash >= 0 {
+		head = dsn[:slash]
+	}
+	if at := strings.LastIndex(head, "@"); at >= 0 {
+		userinfo, rest = dsn[:at], dsn[at:]
+		if user, _, hasPassword := strings.Cut(userinfo, ":"); hasPassword {
+			userinfo = user + ":" + redacted
+		}
+	}
+
+	// パラメータの値にエスケープしていない/が含まれていても伏せられるよう、@より後の最初の?からをパラメータとする
+	base, query, hasQuery := strings.Cut(rest, "?")
+	if !hasQuery {
+		return userinfo + rest
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, hasValue := strings.Cut(param, "=")
+		if hasValue && isSensitiveDSNParam(key) {
+			params[i] = key + "=" + redacted
+		}
+	}
+	return userinfo + base + "?" + strings.Join(params, "&")
+}
+
+// isSensitiveDSNParam はkeyがsensitiveDSNParamsに含まれる場合にtrueを返します。
+func isSensitiveDSNParam(key string) bool {
+	for _, sensitive := range sensitiveDSNParams {
+		if strings.EqualFold(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
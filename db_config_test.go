@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBConfigDSN(t *testing.T) {
+	base := DBConfig{Host: "db.local", Port: 3306, User: "app", Password: "secret", Name: "stocks"}
+
+	tests := []struct {
+		name         string
+		readTimeout  time.Duration
+		writeTimeout time.Duration
+		expected     string
+	}{
+		{
+			name:         "両方のタイムアウト",
+			readTimeout:  30 * time.Second,
+			writeTimeout: 10 * time.Second,
+			expected:     "app:secret@tcp(db.local:3306)/stocks?parseTime=true&readTimeout=30s&writeTimeout=10s",
+		},
+		{
+			name:        "読み取りのみ",
+			readTimeout: 1500 * time.Millisecond,
+			expected:    "app:secret@tcp(db.local:3306)/stocks?parseTime=true&readTimeout=1.5s",
+		},
+		{
+			name:     "タイムアウトなし",
+			expected: "app:secret@tcp(db.local:3306)/stocks?parseTime=true",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := base
+			cfg.ReadTimeout, cfg.WriteTimeout = tc.readTimeout, tc.writeTimeout
+
+			assert.Equal(t, tc.expected, cfg.dsn())
+		})
+	}
+}
+
+// TestConnectDB_Timeouts は既定の読み書きのタイムアウトがDSNに含まれることをテストします
+func TestConnectDB_Timeouts(t *testing.T) {
+	var dsn string
+	withMockOpenDBFunc(t, func(driverName, dataSourceName string) (*sql.DB, error) {
+		dsn = dataSourceName
+		db, _, err := sqlmock.New()
+		return db, err
+	}, func() {
+		db, err := ConnectDB()
+		assert.NoError(t, err)
+		db.Close()
+	})
+
+	assert.Contains(t, dsn, "readTimeout="+dbReadTimeout.String())
+	assert.Contains(t, dsn, "writeTimeout="+dbWriteTimeout.String())
+}
+
+// TestRedactDSN はパスワードとsensitiveDSNParamsのパラメータの値が伏せられることをテストします
+func TestRedactDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		dsn      string
+		expected string
+	}{
+		{
+			name:     "パスワードと鍵のパス",
+			dsn:      "app:s3cr3t@tcp(db.local:3306)/stocks?parseTime=true&sslkey=%2Fetc%2Fmysql%2Fclient-key.pem&readTimeout=30s",
+			expected: "app:[REDACTED]@tcp(db.local:3306)/stocks?parseTime=true&sslkey=[REDACTED]&readTimeout=30s",
+		},
+		{
+			name:     "パラメータ名の大文字小文字",
+			dsn:      "app:pw@tcp(db.local:3306)/stocks?SSLCert=cert.pem&serverpubkey=prod",
+			expected: "app:[REDACTED]@tcp(db.local:3306)/stocks?SSLCert=[REDACTED]&serverpubkey=[REDACTED]",
+		},
+		{
+			name:     "エスケープしていないパス",
+			dsn:      "app:pw@tcp(db.local:3306)/stocks?sslkey=/etc/mysql/client-key.pem",
+			expected: "app:[REDACTED]@tcp(db.local:3306)/stocks?sslkey=[REDACTED]",
+		},
+		{
+			name:     "パスワードに@と:を含む",
+			dsn:      "app:p@ss:w0rd@tcp(db.local:3306)/stocks",
+			expected: "app:[REDACTED]@tcp(db.local:3306)/stocks",
+		},
+		{
+			name:     "パスワードなし",
+			dsn:      "app@tcp(db.local:3306)/stocks?parseTime=true",
+			expected: "app@tcp(db.local:3306)/stocks?parseTime=true",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, RedactDSN(tc.dsn))
+		})
+	}
+}
+
+// TestRedactDSN_SensitiveParams はsensitiveDSNParamsを変更すると伏せるパラメータも変わることをテストします
+func TestRedactDSN_SensitiveParams(t *testing.T) {
+	original := sensitiveDSNParams
+	sensitiveDSNParams = []string{"tls"}
+	t.Cleanup(func() { sensitiveDSNParams = original })
+
+	assert.Equal(t, "app:[REDACTED]@tcp(db.local:3306)/stocks?tls=[REDACTED]&sslkey=key.pem",
+		RedactDSN("app:pw@tcp(db.local:3306)/stocks?tls=custom&sslkey=key.pem"))
+}
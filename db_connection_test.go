@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert" // 追加
@@ -31,8 +34,8 @@ func TestConnectDB(t *testing.T) {
 				assert.Equal(t, "mysql", driverName, "ドライバ名はmysqlであるべき")
 				// 簡易的なDSNチェック
 				assert.NotEmpty(t, dataSourceName, "DSNは空であってはならない")
-				// 実際の接続は行わず、sql.Openで作成したDBを返す
-				db, err := sql.Open("mysql", "user:password@tcp(localhost:3306)/testdb")
+				// 実際の接続は行わず、sqlmockで作成したDBを返す
+				db, _, err := sqlmock.New()
 				return db, err
 			},
 			expectError: false,
@@ -110,3 +113,66 @@ func TestPingDB(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet(), "すべての期待されたアクションが実行されるべき")
 	})
 }
+
+// withPingDatabasesConcurrency はテスト中だけPingDatabasesの同時実行数を変更します
+func withPingDatabasesConcurrency(t *testing.T, n int) {
+	original := pingDatabasesConcurrency
+	pingDatabasesConcurrency = n
+	t.Cleanup(func() { pingDatabasesConcurrency = original })
+}
+
+// TestPingDatabases は正常なシャードと失敗したシャードが混在しても、シャードごとの結果を返すことをテストします
+func TestPingDatabases(t *testing.T) {
+	for _, concurrency := range []int{1, 2, 8} {
+		concurrency := concurrency
+		t.Run(fmt.Sprintf("同時実行数%d", concurrency), func(t *testing.T) {
+			withPingDatabasesConcurrency(t, concurrency)
+			shardErr := errors.New("connection refused")
+			dbs := map[string]*sql.DB{}
+			mocks := map[string]sqlmock.Sqlmock{}
+			for _, name := range []string{"shard-1", "shard-2", "shard-3", "shard-4", "shard-5"} {
+				var pingErr error
+				if name == "shard-2" || name == "shard-5" {
+					pingErr = shardErr
+				}
+				dbs[name], mocks[name] = newPingMock(t, pingErr)
+			}
+
+			results := PingDatabases(context.Background(), dbs)
+
+			assert.Len(t, results, len(dbs), "すべてのシャードの結果を返すべき")
+			for name := range dbs {
+				if name == "shard-2" || name == "shard-5" {
+					assert.ErrorIs(t, results[name], shardErr, name)
+				} else {
+					assert.NoError(t, results[name], name)
+				}
+				assert.NoError(t, mocks[name].ExpectationsWereMet(), name)
+			}
+		})
+	}
+}
+
+// TestPingDatabases_SlowShard は応答しないシャードがctxの期限で打ち切られ、他のシャードの結果も返ることをテストします
+func TestPingDatabases_SlowShard(t *testing.T) {
+	withPingDatabasesConcurrency(t, 2)
+	healthy, _ := newPingMock(t, nil)
+	slow, slowMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("モックDBの作成に失敗: %v", err)
+	}
+	defer slow.Close()
+	slowMock.ExpectPing().WillDelayFor(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results := PingDatabases(ctx, map[string]*sql.DB{"healthy": healthy, "slow": slow})
+
+	assert.NoError(t, results["healthy"])
+	assert.Error(t, results["slow"], "期限を過ぎたシャードはエラーになるべき")
+}
+
+// TestPingDatabases_Empty はシャードがない場合に空の結果を返すことをテストします
+func TestPingDatabases_Empty(t *testing.T) {
+	assert.Empty(t, PingDatabases(context.Background(), nil))
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"regexp"
 	"testing"
 
+	"db_moc/internal/stmt"
+
 	"github.com/DATA-DOG/go-sqlmock"
 )
 
@@ -37,3 +41,100 @@ func verifyExpectations(t *testing.T, mock sqlmock.Sqlmock) {
 		t.Errorf("期待されたクエリが実行されませんでした: %v", err)
 	}
 }
+
+// expectEnsureSchema はEnsureSchemaが発行するDDLをすべて、マイグレーションのロックの取得と解放とともに期待値として設定します。
+// stockMigrationsは適用済みとします
+func expectEnsureSchema(mock sqlmock.Sqlmock) {
+	expectMigrationLock(mock)
+	for _, ddl := range schemaStatements {
+		mock.ExpectExec(regexp.QuoteMeta(ddl)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS stock_schema_migrations`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(stockVersionRegex).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(len(stockMigrations)))
+	expectMigrationUnlock(mock)
+}
+
+// expectMigrationLock はマイグレーションのロックの取得を期待値として設定します
+func expectMigrationLock(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\);`).
+		WithArgs(migrationLockName, migrationLockTimeout.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(1))
+}
+
+// expectMigrationUnlock はマイグレーションのロックの解放を期待値として設定します
+func expectMigrationUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT RELEASE_LOCK\(\?\);`).
+		WithArgs(migrationLockName).
+		WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(1))
+}
+
+// withRealSQLOpen はテスト中だけ本来のopenDBFunc（ドライバの確認とsql.Open）を使います。
+// sql.Openは接続を確立しないため、ドライバの登録状況の確認にのみ使います。
+func withRealSQLOpen(t *testing.T) {
+	original := openDBFunc
+	openDBFunc = openRegistered
+	t.Cleanup(func() { openDBFunc = original })
+}
+
+// stmtPattern は生成したSQL文と完全に一致する正規表現を返します。taggedSQLのタグは付いていてもいなくても一致します
+func stmtPattern(s stmt.Statement) string {
+	return sqlPattern(s.SQL)
+}
+
+// sqlPattern は本体と共有するSQL文（SQLTotalAmountなど）から、その文だけに一致するsqlmockの期待値の正規表現を作ります。
+// 先頭に付く発行元のタグは省略できます
+func sqlPattern(query string) string {
+	return "^" + stmt.TagPattern + regexp.QuoteMeta(query) + "$"
+}
+
+// stmtArgs はvaluesをParamsの順に並べてWithArgsに渡せる形にします
+func stmtArgs(s stmt.Statement, values stmt.Values) []driver.Value {
+	bound := s.Bind(values)
+	args := make([]driver.Value, len(bound))
+	for i, v := range bound {
+		args[i] = v
+	}
+	return args
+}
+
+// expectStmtQuery は本番のコードと同じStatementから、SQL文と引数の期待値を設定します
+func expectStmtQuery(mock sqlmock.Sqlmock, s stmt.Statement, values stmt.Values) *sqlmock.ExpectedQuery {
+	return mock.ExpectQuery(stmtPattern(s)).WithArgs(stmtArgs(s, values)...)
+}
+
+// expectStmtExec は本番のコードと同じStatementから、SQL文と引数の期待値を設定します
+func expectStmtExec(mock sqlmock.Sqlmock, s stmt.Statement, values stmt.Values) *sqlmock.ExpectedExec {
+	return mock.ExpectExec(stmtPattern(s)).WithArgs(stmtArgs(s, values)...)
+}
+
+// expectStockAmount は在庫数を確認するSELECTを期待値として設定します
+func expectStockAmount(mock sqlmock.Sqlmock, name string) *sqlmock.ExpectedQuery {
+	return expectStmtQuery(mock, stmtStockAmount, stmt.Values{"name": name})
+}
+
+// expectStockAmountForUpdate は行ロックを取得して在庫数を確認するSELECTを期待値として設定します
+func expectStockAmountForUpdate(mock sqlmock.Sqlmock, name string) *sqlmock.ExpectedQuery {
+	return expectStmtQuery(mock, stmtStockAmountForUpdate, stmt.Values{"name": name})
+}
+
+// expectUpdateAmount は在庫数のUPDATEを期待値として設定します
+func expectUpdateAmount(mock sqlmock.Sqlmock, name string, amount int) *sqlmock.ExpectedExec {
+	return expectStmtExec(mock, stmtUpdateAmount, stmt.Values{"name": name, "amount": amount})
+}
+
+// expectInsertStock は在庫のINSERTを期待値として設定します
+func expectInsertStock(mock sqlmock.Sqlmock, name string, amount int) *sqlmock.ExpectedExec {
+	return expectStmtExec(mock, stmtInsertStock, stmt.Values{"name": name, "amount": amount})
+}
+
+// expectUpdateAmountWithCategory は在庫数とカテゴリのUPDATEを期待値として設定します
+func expectUpdateAmountWithCategory(mock sqlmock.Sqlmock, name string, amount int, category string) *sqlmock.ExpectedExec {
+	return expectStmtExec(mock, stmtUpdateAmountWithCategory, stmt.Values{"name": name, "amount": amount, "category": category})
+}
+
+// expectInsertStockWithCategory はカテゴリを指定した在庫のINSERTを期待値として設定します
+func expectInsertStockWithCategory(mock sqlmock.Sqlmock, name string, amount int, category string) *sqlmock.ExpectedExec {
+	return expectStmtExec(mock, stmtInsertStockWithCategory, stmt.Values{"name": name, "amount": amount, "category": category})
+}
@@ -1,11 +1,15 @@
+//go:build !nomysql
+
 package main
 
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"sync"
 	"testing"
 	"time"
 
@@ -23,15 +27,6 @@ const (
 
 	// コンテナ名
 	containerName = "mysql_integration_test"
-
-	// テスト用テーブル作成SQL
-	createTableSQL = `
-CREATE TABLE IF NOT EXISTS stocks (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    name VARCHAR(255) NOT NULL,
-    amount INT NOT NULL,
-    UNIQUE(name)
-);`
 )
 
 // removeContainer は指定したコンテナを削除します。
@@ -115,7 +110,7 @@ func setupIntegrationTest(t *testing.T) (*sql.DB, func()) {
 	db := waitForMySQL(t, dsn)
 
 	// テーブル作成
-	if _, err := db.Exec(createTableSQL); err != nil {
+	if err := EnsureSchema(db); err != nil {
 		cleanup()
 		t.Fatalf("テーブル作成エラー: %v", err)
 	}
@@ -171,29 +166,298 @@ func TestIntegrationDBConnection(t *testing.T) {
 
 		// 変更を確認
 		fmt.Println("更新後のデータを確認中...")
+		if AssertDBState(t, db, []Stock{
+			{ID: 1, Name: "apple", Amount: 300},
+			{ID: 2, Name: "banana", Amount: 50},
+		}) {
+			fmt.Println("=== 実DBでのUpsertテスト 完了 ===")
+			t.Log("実DBでのUpsertテスト成功")
+		}
+	})
+
+	t.Run("実DBでの全件取得テスト", func(t *testing.T) {
+		// 空の名前はappleとUpsertテストで挿入した行を含む全行を返す
+		results, err := QueryStocks(db, "")
+		assert.NoError(t, err, "空の名前でのQueryStocksは成功すべき")
+		names := make([]interface{}, 0, len(results))
+		for _, row := range results {
+			names = append(names, row["name"])
+		}
+		assert.ElementsMatch(t, []interface{}{"apple", "banana"}, names, "全行が返るべき")
+
+		// 空白だけの名前は全件取得ではなく、その名前の検索になる
+		results, err = QueryStocks(db, " ")
+		assert.NoError(t, err, "空白だけの名前でのQueryStocksは成功すべき")
+		assert.Empty(t, results, "空白だけの名前に一致する行はないべき")
+	})
+}
+
+// TestIntegrationSchemaMissing はstocksテーブルが存在しないDBでのmainProcessの動作を検証します。
+func TestIntegrationSchemaMissing(t *testing.T) {
+	db, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	// テーブルが存在しない状態を作る
+	if _, err := db.Exec("DROP TABLE stocks"); err != nil {
+		t.Fatalf("テーブル削除エラー: %v", err)
+	}
+
+	t.Run("自動作成なしでは案内メッセージを返す", func(t *testing.T) {
+		err := mainProcess(context.Background(), os.Stdout, NewSQLStockRepository(db), "apple", 200)
+		assert.ErrorIs(t, err, ErrSchemaMissing, "ErrSchemaMissingが返るべき")
+		assert.Contains(t, err.Error(), "init-db", "init-dbの案内が含まれるべき")
+	})
+
+	t.Run("自動作成ありではテーブルを作成して再実行する", func(t *testing.T) {
+		original := autoMigrate
+		autoMigrate = true
+		defer func() { autoMigrate = original }()
+
+		assert.NoError(t, mainProcess(context.Background(), os.Stdout, NewSQLStockRepository(db), "apple", 200), "テーブル作成後の再実行は成功すべき")
+
 		results, err := QueryStocks(db, "apple")
-		assert.NoError(t, err, "更新後のQueryStocksは成功すべき")
+		assert.NoError(t, err, "作成後のQueryStocksは成功すべき")
+		if assert.Len(t, results, 1, "appleが1件登録されているべき") {
+			assert.Equal(t, int64(200), results[0]["amount"], "登録した数量が一致すべき")
+		}
+	})
+}
+
+// TestIntegrationMovementReport は投入した変更履歴に対するMovementReportの集計結果を検証します。
+func TestIntegrationMovementReport(t *testing.T) {
+	db, cleanup := setupIntegrationTest(t)
+	defer cleanup()
 
-		if assert.Len(t, results, 1, "更新後も結果は1件のみ存在すべき") {
-			fmt.Printf("確認結果: apple の数量 = %d (期待値: 300)\n", results[0]["amount"])
-			assert.Equal(t, int64(300), results[0]["amount"], "更新後の数量は300になるべき")
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	history := []struct {
+		name      string
+		delta     int
+		amount    int
+		createdAt time.Time
+	}{
+		{"apple", 100, 100, from.Add(-time.Hour)}, // 期間外
+		{"apple", 50, 150, from},
+		{"apple", -30, 120, from.AddDate(0, 0, 10)},
+		{"banana", 100, 100, from.AddDate(0, 0, 1)},
+		{"banana", -90, 10, from.AddDate(0, 0, 20)},
+		{"banana", -5, 5, to.Add(-time.Second)},
+		{"cherry", 10, 10, to}, // 期間外
+	}
+	for _, h := range history {
+		_, err := db.Exec("INSERT INTO stock_log (name, operation, delta, amount, created_at) VALUES (?, ?, ?, ?, ?)",
+			h.name, operationUpdate, h.delta, h.amount, h.createdAt)
+		if err != nil {
+			t.Fatalf("変更履歴の挿入エラー: %v", err)
 		}
+	}
 
-		//全件表示して、appleが300、bananaが50になっていることを確認
-		fmt.Println("全データを表示中...")
-		results, err = QueryStocks(db, "")
-		assert.NoError(t, err, "全データのQueryStocksは成功すべき")
+	movements, err := MovementReport(context.Background(), db, from, to)
+	assert.NoError(t, err, "MovementReportは成功すべき")
+	assert.Equal(t, []Movement{
+		{Name: "apple", NetChange: 20, Changes: 2, MinAmount: 120, MaxAmount: 150},
+		{Name: "banana", NetChange: 5, Changes: 3, MinAmount: 5, MaxAmount: 100},
+	}, movements, "正味の変更量の絶対値が大きい順に集計されるべき")
+}
 
-		if assert.Len(t, results, 2, "全データは2件存在すべき") {
+// TestIntegrationCachedTotal は複数回の更新の後でもキャッシュした合計がSUMと一致することを検証します。
+func TestIntegrationCachedTotal(t *testing.T) {
+	withCachedTotal(t)
+	db, cleanup := setupIntegrationTest(t)
+	defer cleanup()
 
-			for _, r := range results {
-				fmt.Printf("商品名: %s, 数量: %d\n", r["name"], r["amount"])
-				assert.Equal(t, int64(300), results[0]["amount"], "appleの数量は300になるべき")
-				assert.Equal(t, int64(50), results[1]["amount"], "bananaの数量は50になるべき")
+	assert.NoError(t, UpsertStock(db, "apple", 100))
+	assert.NoError(t, UpsertStock(db, "banana", 40))
+	assert.NoError(t, UpsertStock(db, "apple", -30))
+	_, err := BulkUpsertStocks(db, []StockUpdate{{Name: "banana", Amount: 10}, {Name: "cherry", Amount: 5}})
+	assert.NoError(t, err)
+
+	cached, err := GetCachedTotal(db)
+	assert.NoError(t, err)
+	total, err := TotalStockAmount(db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(125), cached, "キャッシュした合計が更新内容と一致すべき")
+	assert.Equal(t, int64(total), cached, "キャッシュした合計がSUMと一致すべき")
+}
+
+// TestIntegrationCancelOperation は実行中の遅いクエリを別の接続からKILL QUERYで中断できることを検証します。
+func TestIntegrationCancelOperation(t *testing.T) {
+	withTrackLongOperations(t)
+	db, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	done := make(chan error, 1)
+	go func() {
+		done <- trackOperation(ctx, db, "SELECT SLEEP(30);", func(ctx context.Context, q Queryer) error {
+			rows, err := q.QueryContext(ctx, "SELECT SLEEP(30);")
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
 			}
+			return rows.Err()
+		})
+	}()
+
+	// 操作が登録されるまで待つ
+	var ops []ActiveOperation
+	for deadline := time.Now().Add(5 * time.Second); len(ops) == 0 && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		ops = ListActiveOperations()
+	}
+	if !assert.Len(t, ops, 1, "遅いクエリが登録されるべき") {
+		return
+	}
 
-			fmt.Println("=== 実DBでのUpsertテスト 完了 ===")
-			t.Log("実DBでのUpsertテスト成功")
+	start := time.Now()
+	assert.NoError(t, CancelOperation(ctx, db, ops[0].ID))
+
+	select {
+	case err := <-done:
+		// SLEEPはKILL QUERYで中断されると1を返すか、中断のエラーになる
+		t.Logf("中断後の結果: %v", err)
+		assert.Less(t, time.Since(start), 10*time.Second, "中断後すぐに終了するべき")
+	case <-time.After(15 * time.Second):
+		t.Fatal("KILL QUERYでクエリが中断されなかった")
+	}
+	assert.Empty(t, ListActiveOperations(), "中断後は登録が解除されるべき")
+}
+
+// TestIntegrationAcquireLock は別の接続が保持しているロックは取得できず、解放後は取得できることを検証します。
+func TestIntegrationAcquireLock(t *testing.T) {
+	db, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	release, err := AcquireLock(db, "integration-job", time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = AcquireLock(db, "integration-job", time.Second)
+	assert.True(t, errors.Is(err, ErrLockTimeout), "保持中のロックは取得できないべき: %v", err)
+
+	assert.NoError(t, release())
+	again, err := AcquireLock(db, "integration-job", time.Second)
+	if assert.NoError(t, err, "解放後は取得できるべき") {
+		assert.NoError(t, again())
+	}
+}
+
+// TestIntegrationConcurrentMigrate は複数のインスタンスが同時にマイグレーションを実行しても、各バージョンが1回だけ適用されることをテストします
+func TestIntegrationConcurrentMigrate(t *testing.T) {
+	db, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+	// EnsureSchemaが適用したstockMigrationsは別のテーブルに記録されるため、testMigrationsはバージョン1から適用される
+
+	const replicas = 3
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, replicas)
+	for i := 0; i < replicas; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- MigrateTo(db, 3, testMigrations)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err, "待たされたインスタンスも適用済みのバージョンを確認して成功するべき")
+	}
+
+	rows, err := db.Query("SELECT version, COUNT(*) FROM schema_migrations GROUP BY version ORDER BY version;")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer rows.Close()
+
+	applied := map[int]int{}
+	for rows.Next() {
+		var version, count int
+		if assert.NoError(t, rows.Scan(&version, &count)) {
+			applied[version] = count
 		}
-	})
+	}
+	assert.NoError(t, rows.Err())
+	assert.Equal(t, map[int]int{1: 1, 2: 1, 3: 1}, applied, "各マイグレーションは1回だけ適用されるべき")
+}
+
+// TestIntegrationConcurrentApplyDeltas は複数のgoroutineから既存の商品に変更量を適用しても、更新が失われず変更履歴も1件ずつ記録されることをテストします
+func TestIntegrationConcurrentApplyDeltas(t *testing.T) {
+	withAuditLog(t)
+	db, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	const workers = 10
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs <- ApplyDeltas(db, map[string]int{"apple": 1})
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err, "FOR UPDATEで待たされた更新も成功するべき")
+	}
+
+	AssertDBState(t, db, []Stock{{ID: 1, Name: "apple", Amount: 100 + workers}})
+
+	updates := make([]string, workers)
+	for i := range updates {
+		updates[i] = operationUpdate
+	}
+	AssertAuditTrail(t, db, "apple", updates)
+}
+
+// TestIntegrationConcurrentQuota は新しい商品の追加が同時に行われても、商品の種類数が上限を超えないことをテストします
+func TestIntegrationConcurrentQuota(t *testing.T) {
+	withMaxItems(t, 5)
+	db, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	const workers = 20
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs <- UpsertStock(db, fmt.Sprintf("item-%02d", i), 1)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	added := 0
+	for err := range errs {
+		if err == nil {
+			added++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrQuotaExceeded, "上限を超える追加だけが拒否されるべき")
+	}
+
+	var count int
+	if assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM stocks;").Scan(&count)) {
+		assert.Equal(t, 5, count, "種類数は上限ちょうどになるべき")
+	}
+	assert.Equal(t, 4, added, "初期データのappleを除いた4件だけが追加されるべき")
+	assert.NoError(t, UpsertStock(db, "apple", 10), "上限に達していても既存の商品は更新できるべき")
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// duplicateGroup は同じ名前を持つ重複行のまとまりです。
+type duplicateGroup struct {
+	name   string
+	keepID int64
+	total  int
+}
+
+// DeduplicateStocks は同じ名前の重複行を1行にまとめます。
+// 名前ごとに在庫数を合計して最小のidの行に集約し、残りの行を削除します。
+// 処理はトランザクション内で行い、削除した行数を返します。
+func DeduplicateStocks(db *sql.DB) (int, error) {
+	return DeduplicateStocksContext(context.Background(), db)
+}
+
+// DeduplicateStocksContext はDeduplicateStocksのcontext対応版です。
+func DeduplicateStocksContext(ctx context.Context, db *sql.DB) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("トランザクション開始エラー: %v", err)
+	}
+	defer tx.Rollback() // エラー発生時にロールバック
+
+	groups, err := findDuplicateGroups(ctx, tx)
+	if err != nil {
+		return 0, fmt.Errorf("重複データ確認中にエラーが発生: %v", err)
+	}
+
+	removed := 0
+	for _, g := range groups {
+		if _, err := tx.ExecContext(ctx, SQLMergeDuplicateAmount, g.total, g.keepID); err != nil {
+			return 0, fmt.Errorf("データ更新エラー: %v", err)
+		}
+		result, err := tx.ExecContext(ctx, SQLDeleteDuplicates, g.name, g.keepID)
+		if err != nil {
+			return 0, fmt.Errorf("データ削除エラー: %v", err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("削除件数の取得エラー: %v", err)
+		}
+		removed += int(affected)
+		if err := updateRowChecksum(ctx, tx, g.name); err != nil {
+			return 0, err
+		}
+	}
+	if err := bumpGeneration(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("トランザクションコミットエラー: %v", err)
+	}
+	return removed, nil
+}
+
+// findDuplicateGroups は重複している名前ごとに、残す行のidと在庫数の合計を取得します。
+func findDuplicateGroups(ctx context.Context, tx *sql.Tx) ([]duplicateGroup, error) {
+	rows, err := tx.QueryContext(ctx, SQLFindDuplicateGroups)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []duplicateGroup
+	for rows.Next() {
+		var g duplicateGroup
+		if err := rows.Scan(&g.name, &g.keepID, &g.total); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// ResetAutoIncrement はstocksテーブルのAUTO_INCREMENTカウンタをリセットします（MySQL固有）。
+// MySQLは既存の最大idより小さい値を指定すると最大id+1に調整するため、既存の行は影響を受けません。
+// テストの再現性のための操作なので、allowDestructiveMaintenanceが有効でない場合はErrMaintenanceDisabledを返します。
+func ResetAutoIncrement(db *sql.DB) error {
+	return ResetAutoIncrementContext(context.Background(), db)
+}
+
+// ResetAutoIncrementContext はResetAutoIncrementのcontext対応版です。
+func ResetAutoIncrementContext(ctx context.Context, db *sql.DB) error {
+	if !allowDestructiveMaintenance {
+		return ErrMaintenanceDisabled
+	}
+	if _, err := db.ExecContext(ctx, SQLResetAutoIncrement); err != nil {
+		return fmt.Errorf("AUTO_INCREMENTのリセットエラー: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicateStocks(t *testing.T) {
+	tests := []struct {
+		name            string
+		setupMock       func(mock sqlmock.Sqlmock)
+		expectedRemoved int
+	}{
+		{
+			name: "重複を集約して削除",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlPattern(SQLFindDuplicateGroups)).
+					WillReturnRows(sqlmock.NewRows([]string{"name", "min_id", "total"}).
+						AddRow("apple", 1, 300).
+						AddRow("banana", 4, 70))
+				// apple: 3行を1行に集約
+				mock.ExpectExec(sqlPattern(SQLMergeDuplicateAmount)).
+					WithArgs(300, 1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(sqlPattern(SQLDeleteDuplicates)).
+					WithArgs("apple", 1).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				// banana: 2行を1行に集約
+				mock.ExpectExec(sqlPattern(SQLMergeDuplicateAmount)).
+					WithArgs(70, 4).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(sqlPattern(SQLDeleteDuplicates)).
+					WithArgs("banana", 4).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedRemoved: 3,
+		},
+		{
+			name: "重複なし",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlPattern(SQLFindDuplicateGroups)).
+					WillReturnRows(sqlmock.NewRows([]string{"name", "min_id", "total"}))
+				mock.ExpectCommit()
+			},
+			expectedRemoved: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			tc.setupMock(mock)
+
+			removed, err := DeduplicateStocks(db)
+
+			assert.NoError(t, err, "エラーが発生すべきでない")
+			assert.Equal(t, tc.expectedRemoved, removed, "削除件数が期待通りであるべき")
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestDeduplicateStocks_DeleteError は削除に失敗した場合にロールバックされることをテストします
+func TestDeduplicateStocks_DeleteError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlPattern(SQLFindDuplicateGroups)).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "min_id", "total"}).AddRow("apple", 1, 300))
+	mock.ExpectExec(sqlPattern(SQLMergeDuplicateAmount)).
+		WithArgs(300, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlPattern(SQLDeleteDuplicates)).
+		WithArgs("apple", 1).
+		WillReturnError(errors.New("delete error"))
+	mock.ExpectRollback()
+
+	removed, err := DeduplicateStocks(db)
+
+	if assert.Error(t, err, "エラーが返るべき") {
+		assert.Contains(t, err.Error(), "データ削除エラー", "削除エラーであることが分かるべき")
+	}
+	assert.Equal(t, 0, removed, "エラー時の削除件数は0であるべき")
+	verifyExpectations(t, mock)
+}
+
+// withDestructiveMaintenance はテスト中だけ破壊的なメンテナンス操作を許可します。
+func withDestructiveMaintenance(t *testing.T) {
+	t.Helper()
+	prev := allowDestructiveMaintenance
+	allowDestructiveMaintenance = true
+	t.Cleanup(func() { allowDestructiveMaintenance = prev })
+}
+
+func TestResetAutoIncrement(t *testing.T) {
+	withDestructiveMaintenance(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec(sqlPattern(SQLResetAutoIncrement)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.NoError(t, ResetAutoIncrement(db))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetAutoIncrement_ExecError(t *testing.T) {
+	withDestructiveMaintenance(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec(sqlPattern(SQLResetAutoIncrement)).
+		WillReturnError(errors.New("alter failed"))
+
+	err := ResetAutoIncrement(db)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "alter failed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetAutoIncrement_Disabled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// 許可されていない場合はSQLを発行しない
+	err := ResetAutoIncrement(db)
+	assert.ErrorIs(t, err, ErrMaintenanceDisabled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"database/sql/driver"
 	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert" // 追加
@@ -21,7 +24,7 @@ func TestQueryStocks(t *testing.T) {
 			name:           "appleが1件返る場合",
 			queryArg:       "apple",
 			mockRows:       sqlmock.NewRows([]string{"id", "name", "amount"}).AddRow(1, "apple", 100),
-			mockQueryRegex: "SELECT \\* FROM stocks WHERE name = \\?;",
+			mockQueryRegex: "SELECT id, name, amount, category FROM stocks WHERE name = \\?;",
 			expectedResult: []map[string]interface{}{
 				{"id": int64(1), "name": "apple", "amount": int64(100)},
 			},
@@ -35,7 +38,7 @@ func TestQueryStocks(t *testing.T) {
 				AddRow(1, "apple", 100).
 				AddRow(2, "banana", 50).
 				AddRow(3, "orange", 75),
-			mockQueryRegex: "SELECT \\* FROM stocks;", // WHERE句のないクエリ
+			mockQueryRegex: "SELECT id, name, amount, category FROM stocks;", // WHERE句のないクエリ
 			expectedResult: []map[string]interface{}{
 				{"id": int64(1), "name": "apple", "amount": int64(100)},
 				{"id": int64(2), "name": "banana", "amount": int64(50)},
@@ -43,6 +46,14 @@ func TestQueryStocks(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name:           "空白だけの名前はそのまま検索",
+			queryArg:       "  ",
+			mockRows:       sqlmock.NewRows([]string{"id", "name", "amount"}),
+			mockQueryRegex: "SELECT id, name, amount, category FROM stocks WHERE name = \\?;",
+			expectedResult: []map[string]interface{}{},
+			expectError:    false,
+		},
 		// ※ 必要に応じて、他のテストケースを追加できます
 	}
 
@@ -55,8 +66,9 @@ func TestQueryStocks(t *testing.T) {
 
 			// 期待するクエリと返却行の設定
 			if tc.queryArg == "" {
-				// 空文字列の場合はWHERE句なしのクエリが期待される
+				// 空文字列の場合はWHERE句も引数もないクエリが期待される
 				mock.ExpectQuery(tc.mockQueryRegex).
+					WithoutArgs().
 					WillReturnRows(tc.mockRows)
 			} else {
 				// 通常のケースはWHERE句ありのクエリが期待される
@@ -102,7 +114,7 @@ func TestQueryStocks_Error(t *testing.T) {
 			name:        "存在しない銘柄でエラー発生",
 			queryArg:    "nonexistent",
 			expectedErr: errors.New("query error"),
-			queryRegex:  "SELECT \\* FROM stocks WHERE name = \\?;",
+			queryRegex:  "SELECT id, name, amount, category FROM stocks WHERE name = \\?;",
 		},
 		// 将来的に別のエラーケースを追加する場合は、ここにケースを追加
 	}
@@ -143,7 +155,7 @@ func TestQueryStocks_ColumnsError(t *testing.T) {
 		RowError(0, errors.New("row error"))
 
 	// ここでColumnsを呼ぶ前にRowsをCloseして、その後のColumnsでエラーになるようにする
-	mock.ExpectQuery("SELECT \\* FROM stocks WHERE name = \\?;").
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks WHERE name = \\?;").
 		WithArgs("apple").
 		WillReturnRows(mockRows)
 
@@ -165,7 +177,7 @@ func TestQueryStocks_ScanError(t *testing.T) {
 		AddRow(1, "apple", 100).
 		RowError(0, errors.New("forced scan error"))
 
-	mock.ExpectQuery("SELECT \\* FROM stocks WHERE name = \\?;").
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks WHERE name = \\?;").
 		WithArgs("apple").
 		WillReturnRows(mockRows)
 
@@ -195,7 +207,7 @@ func TestQueryStocks_RowsError(t *testing.T) {
 	mockRows := sqlmock.NewRows([]string{"id", "name", "amount"}).
 		CloseError(errors.New("rows error after iteration"))
 
-	mock.ExpectQuery("SELECT \\* FROM stocks;").
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks;").
 		WillReturnRows(mockRows)
 
 	// テスト対象関数を実行
@@ -215,7 +227,7 @@ func TestQueryStocks_EmptyResults(t *testing.T) {
 	// 空の結果セット
 	mockRows := sqlmock.NewRows([]string{"id", "name", "amount"})
 
-	mock.ExpectQuery("SELECT \\* FROM stocks WHERE name = \\?;").
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks WHERE name = \\?;").
 		WithArgs("nonexistent_item").
 		WillReturnRows(mockRows)
 
@@ -238,7 +250,7 @@ func TestQueryStocks_NullValues(t *testing.T) {
 		AddRow(1, nil, 100) // nameにNULL値
 
 	// 空文字列の場合はWHERE句なしのクエリが正しい
-	mock.ExpectQuery("SELECT \\* FROM stocks;").
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks;").
 		WillReturnRows(mockRows)
 
 	// テスト対象関数を実行
@@ -263,7 +275,7 @@ func TestQueryStocks_BinaryData(t *testing.T) {
 	mockRows := sqlmock.NewRows([]string{"id", "name", "data"}).
 		AddRow(1, "binary_item", binaryData)
 
-	mock.ExpectQuery("SELECT \\* FROM stocks WHERE name = \\?;").
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks WHERE name = \\?;").
 		WithArgs("binary_item").
 		WillReturnRows(mockRows)
 
@@ -278,3 +290,74 @@ func TestQueryStocks_BinaryData(t *testing.T) {
 	assert.IsType(t, string(""), results[0]["data"], "バイナリデータは文字列に変換されるべき")
 	assert.NoError(t, mock.ExpectationsWereMet(), "すべての期待されるSQLが実行されるべき")
 }
+
+// withStrictScan はテスト中だけ対応していない型の列をエラーにします
+func withStrictScan(t *testing.T) {
+	original := strictScan
+	strictScan = true
+	t.Cleanup(func() { strictScan = original })
+}
+
+// customValue はドライバが独自の型で返す列を表すテスト用のdriver.Valuerです
+type customValue struct {
+	raw string
+}
+
+func (c customValue) Value() (driver.Value, error) {
+	return c.raw, nil
+}
+
+// passThroughConverter は行の値を変換せずにそのまま返すValueConverterです。
+// ドライバが独自の型の値を返す状況を再現するために使います
+type passThroughConverter struct{}
+
+func (passThroughConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	return v, nil
+}
+
+// TestQueryStocks_StrictScan は対応している型の列はstrictScanの有無にかかわらず読み取れ、
+// 対応していない型の列はstrictScanが有効な場合だけエラーになることをテストします
+func TestQueryStocks_StrictScan(t *testing.T) {
+	createdAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		value       driver.Value
+		expected    interface{}
+		strictError bool
+	}{
+		{name: "time.Time", value: createdAt, expected: createdAt},
+		{name: "float64", value: 12.5, expected: 12.5},
+		{name: "[]byte", value: []byte("12.50"), expected: "12.50"},
+		{name: "driver.Valuer", value: customValue{raw: `{"a":1}`}, expected: customValue{raw: `{"a":1}`}, strictError: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		for _, strict := range []bool{false, true} {
+			strict := strict
+			t.Run(fmt.Sprintf("%s/strict=%t", tc.name, strict), func(t *testing.T) {
+				if strict {
+					withStrictScan(t)
+				}
+				db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(passThroughConverter{}))
+				assert.NoError(t, err)
+				defer db.Close()
+
+				mock.ExpectQuery("SELECT id, name, amount, category FROM stocks;").
+					WillReturnRows(mock.NewRows([]string{"id", "name", "extra"}).AddRow(int64(1), "apple", tc.value))
+
+				results, err := QueryStocks(db, "")
+
+				if strict && tc.strictError {
+					assert.True(t, errors.Is(err, ErrUnsupportedColumnType), "ErrUnsupportedColumnTypeを返すべき: %v", err)
+					assert.Contains(t, err.Error(), "extra", "列名を含むべき")
+					assert.Contains(t, err.Error(), "customValue", "ドライバの型を含むべき")
+				} else if assert.NoError(t, err) && assert.Len(t, results, 1) {
+					assert.Equal(t, tc.expected, results[0]["extra"])
+				}
+				verifyExpectations(t, mock)
+			})
+		}
+	}
+}
@@ -15,16 +15,14 @@ func setupUpsertMock(t *testing.T, name string, existingAmount *int, addAmount i
 
 	if existingAmount == nil {
 		// 存在しない商品（INSERT）
-		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
-			WithArgs(name).
+		expectStockAmount(mock, name).
 			WillReturnError(sql.ErrNoRows)
 
 		// トランザクション開始
 		mock.ExpectBegin()
 
 		// ここがポイント：正確なSQLクエリ文字列を指定
-		mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
-			WithArgs(name, addAmount).
+		expectInsertStock(mock, name, addAmount).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		// コミット
@@ -33,16 +31,14 @@ func setupUpsertMock(t *testing.T, name string, existingAmount *int, addAmount i
 		// 既存商品（UPDATE）
 		newAmount := *existingAmount + addAmount
 
-		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
-			WithArgs(name).
+		expectStockAmount(mock, name).
 			WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(*existingAmount))
 
 		// トランザクション開始
 		mock.ExpectBegin()
 
 		// ここもポイント：正確なSQLクエリ文字列を指定
-		mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
-			WithArgs(newAmount, name).
+		expectUpdateAmount(mock, name, newAmount).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
 		// コミット
@@ -83,26 +79,22 @@ func TestUpsertStock(t *testing.T) {
 			// 以下、必要なモック設定...
 			if tc.existing == nil {
 				// 存在しない商品（INSERT）のテストパターン設定
-				mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
-					WithArgs(tc.stockName).
+				expectStockAmount(mock, tc.stockName).
 					WillReturnError(sql.ErrNoRows)
 
 				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
-					WithArgs(tc.stockName, tc.amount).
+				expectInsertStock(mock, tc.stockName, tc.amount).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			} else {
 				// 既存商品（UPDATE）のテストパターン設定
 				newAmount := *tc.existing + tc.amount
 
-				mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
-					WithArgs(tc.stockName).
+				expectStockAmount(mock, tc.stockName).
 					WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(*tc.existing))
 
 				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
-					WithArgs(newAmount, tc.stockName).
+				expectUpdateAmount(mock, tc.stockName, newAmount).
 					WillReturnResult(sqlmock.NewResult(0, 1))
 				mock.ExpectCommit()
 			}
@@ -134,8 +126,7 @@ func TestUpsertStock_TransactionErrors(t *testing.T) {
 			amount:   50,
 			setupMock: func(mock sqlmock.Sqlmock) {
 				// SELECTは成功
-				mock.ExpectQuery(`SELECT\s+amount\s+FROM\s+stocks\s+WHERE\s+name\s*=\s*\?`).
-					WithArgs("apple").
+				expectStockAmount(mock, "apple").
 					WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
 				// Beginでエラー
 				mock.ExpectBegin().WillReturnError(errors.New("begin transaction error"))
@@ -147,13 +138,11 @@ func TestUpsertStock_TransactionErrors(t *testing.T) {
 			itemName: "apple",
 			amount:   50,
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT\s+amount\s+FROM\s+stocks\s+WHERE\s+name\s*=\s*\?`).
-					WithArgs("apple").
+				expectStockAmount(mock, "apple").
 					WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
 				mock.ExpectBegin()
 				// UPDATE実行でエラー
-				mock.ExpectExec(`UPDATE\s+stocks\s+SET\s+amount\s*=\s*\?\s+WHERE\s+name\s*=\s*\?`).
-					WithArgs(150, "apple").
+				expectUpdateAmount(mock, "apple", 150).
 					WillReturnError(errors.New("update execution error"))
 				mock.ExpectRollback()
 			},
@@ -164,13 +153,11 @@ func TestUpsertStock_TransactionErrors(t *testing.T) {
 			itemName: "new_item",
 			amount:   50,
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT\s+amount\s+FROM\s+stocks\s+WHERE\s+name\s*=\s*\?`).
-					WithArgs("new_item").
+				expectStockAmount(mock, "new_item").
 					WillReturnError(sql.ErrNoRows)
 				mock.ExpectBegin()
 				// INSERT実行でエラー
-				mock.ExpectExec(`INSERT INTO stocks\s*\(name,\s*amount\)\s*VALUES\s*\(\?,\s*\?\)`).
-					WithArgs("new_item", 50).
+				expectInsertStock(mock, "new_item", 50).
 					WillReturnError(errors.New("insert execution error"))
 				mock.ExpectRollback()
 			},
@@ -181,12 +168,10 @@ func TestUpsertStock_TransactionErrors(t *testing.T) {
 			itemName: "apple",
 			amount:   50,
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT\s+amount\s+FROM\s+stocks\s+WHERE\s+name\s*=\s*\?`).
-					WithArgs("apple").
+				expectStockAmount(mock, "apple").
 					WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
 				mock.ExpectBegin()
-				mock.ExpectExec(`UPDATE\s+stocks\s+SET\s+amount\s*=\s*\?\s+WHERE\s+name\s*=\s*\?`).
-					WithArgs(150, "apple").
+				expectUpdateAmount(mock, "apple", 150).
 					WillReturnResult(sqlmock.NewResult(0, 1))
 				// コミットでエラー
 				mock.ExpectCommit().WillReturnError(errors.New("commit error"))
@@ -219,3 +204,126 @@ func TestUpsertStock_TransactionErrors(t *testing.T) {
 		})
 	}
 }
+
+// TestUpsertStock_NameRejected は拒否された商品名がDBに触れずにエラーになることをテストします
+func TestUpsertStock_NameRejected(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	rules, err := NewNameRules("", `(?i)^error`, 0)
+	assert.NoError(t, err, "ルールの生成は成功するべき")
+	withNameRules(t, rules)
+
+	err = UpsertStock(db, "ERROR: connection refused", 10)
+
+	assert.True(t, errors.Is(err, ErrNameRejected), "ErrNameRejectedが返るべき")
+	assert.NoError(t, mock.ExpectationsWereMet(), "SQLは実行されないべき")
+}
+
+// TestUpsertStock_InvalidName は空の商品名がDBに触れずにエラーになることをテストします
+func TestUpsertStock_InvalidName(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	err := UpsertStock(db, "", 10)
+
+	assert.True(t, errors.Is(err, ErrInvalidName), "ErrInvalidNameが返るべき")
+	assert.NoError(t, mock.ExpectationsWereMet(), "SQLは実行されないべき")
+}
+
+func TestUpsertStockWithCategory(t *testing.T) {
+	tests := []struct {
+		name             string
+		category         string
+		existing         *int
+		expectedCategory string
+	}{
+		{name: "新規商品にカテゴリを設定", category: "fruit", existing: nil, expectedCategory: "fruit"},
+		{name: "新規商品のカテゴリ未指定は既定値", category: "", existing: nil, expectedCategory: defaultCategory},
+		{name: "既存商品のカテゴリを更新", category: "fruit", existing: func() *int { val := 100; return &val }(), expectedCategory: "fruit"},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			if tc.existing == nil {
+				expectStockAmount(mock, "apple").
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectBegin()
+				expectInsertStockWithCategory(mock, "apple", 50, tc.expectedCategory).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			} else {
+				expectStockAmount(mock, "apple").
+					WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(*tc.existing))
+				mock.ExpectBegin()
+				expectUpdateAmountWithCategory(mock, "apple", *tc.existing+50, tc.expectedCategory).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			err := UpsertStockWithCategory(db, "apple", 50, tc.category)
+
+			assert.NoError(t, err, "UpsertStockWithCategoryはエラーを返すべきではない")
+			assert.NoError(t, mock.ExpectationsWereMet(), "すべての期待されたSQL操作が行われるべき")
+		})
+	}
+}
+
+// TestUpsertStockDetailed はINSERTとUPDATEのそれぞれで、行った操作、変更前後の在庫数、変更した行数と所要時間を返すことをテストします
+func TestUpsertStockDetailed(t *testing.T) {
+	existing := 100
+	tests := []struct {
+		name     string
+		existing *int
+		want     MutationResult
+	}{
+		{
+			name: "存在しない商品 → INSERT",
+			want: MutationResult{Operation: operationInsert, OldAmount: 0, NewAmount: 50, RowsAffected: 1},
+		},
+		{
+			name:     "既存商品 → UPDATE",
+			existing: &existing,
+			want:     MutationResult{Operation: operationUpdate, OldAmount: 100, NewAmount: 150, RowsAffected: 1},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := setupUpsertMock(t, "apple", tc.existing, 50)
+			defer db.Close()
+
+			result, err := UpsertStockDetailed(db, "apple", 50)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want.Operation, result.Operation)
+			assert.Equal(t, tc.want.OldAmount, result.OldAmount)
+			assert.Equal(t, tc.want.NewAmount, result.NewAmount)
+			assert.Equal(t, tc.want.RowsAffected, result.RowsAffected)
+			assert.Positive(t, result.Duration)
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestUpsertStockDetailed_Error は失敗した場合にゼロ値のMutationResultを返すことをテストします
+func TestUpsertStockDetailed_Error(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	expectStockAmount(mock, "apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 150).
+		WillReturnError(errors.New("update execution error"))
+	mock.ExpectRollback()
+
+	result, err := UpsertStockDetailed(db, "apple", 50)
+
+	assert.ErrorContains(t, err, "データ更新エラー")
+	assert.Equal(t, MutationResult{}, result)
+	verifyExpectations(t, mock)
+}
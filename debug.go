@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// defaultQueryRingSize はデバッグ用に保持する直近のクエリ記録の件数です。
+const defaultQueryRingSize = 64
+
+// QueryInfo は実行したSQL文1件の記録です。
+type QueryInfo struct {
+	Query     string
+	StartedAt time.Time
+	Duration  time.Duration
+	// RowsScanned は結果から読み取った行数です。想定外の全件走査の検出に使います。
+	RowsScanned int
+	Err         error
+}
+
+// QueryObserver はクエリの実行が完了するたびに通知を受け取ります。
+type QueryObserver interface {
+	ObserveQuery(info QueryInfo)
+}
+
+// queryRing は直近のQueryInfoを固定長で保持するリングバッファです。
+// 書き込みはアトミックな添字の加算と1スロットの差し替えだけで、ロックを取りません。
+type queryRing struct {
+	slots []atomic.Pointer[QueryInfo]
+	next  atomic.Uint64
+}
+
+// newQueryRing はsize件を保持するqueryRingを返します。
+func newQueryRing(size int) *queryRing {
+	return &queryRing{slots: make([]atomic.Pointer[QueryInfo], size)}
+}
+
+// Record は記録を追加し、保持件数を超えた場合は最も古い記録を上書きします。
+func (r *queryRing) Record(info QueryInfo) {
+	i := r.next.Add(1) - 1
+	r.slots[i%uint64(len(r.slots))].Store(&info)
+}
+
+// Snapshot は保持している記録を古い順に返します。
+// 書き込みと並行して呼ばれた場合、直近の記録が含まれないことがあります。
+func (r *queryRing) Snapshot() []QueryInfo {
+	return r.Since(0)
+}
+
+// Seq はこれまでに追加された記録の数です。Sinceに渡すと、この時点より後に追加された記録を取り出せます。
+func (r *queryRing) Seq() uint64 {
+	return r.next.Load()
+}
+
+// Since はSeqがseqを返した時点より後に追加された記録のうち、保持しているものを古い順に返します。
+func (r *queryRing) Since(seq uint64) []QueryInfo {
+	n := r.next.Load()
+	size := uint64(len(r.slots))
+	start := seq
+	if n > size && n-size > start {
+		start = n - size
+	}
+	if start >= n {
+		return nil
+	}
+
+	records := make([]QueryInfo, 0, n-start)
+	for i := start; i < n; i++ {
+		if info := r.slots[i%size].Load(); info != nil {
+			records = append(records, *info)
+		}
+	}
+	return records
+}
+
+// recentQueries はデバッグダンプに出力する直近のクエリ記録です。
+var recentQueries = newQueryRing(defaultQueryRingSize)
+
+// queryObservation は計測中のクエリです。
+type queryObservation struct {
+	query   string
+	started time.Time
+}
+
+// observeQuery はクエリの計測を開始します。
+func observeQuery(query string) queryObservation {
+	return queryObservation{query: query, started: time.Now()}
+}
+
+// done は読み取った行数とエラーを添えて、開始から現在までを実行時間として記録します。
+// 記録はrecentQueriesに追加され、queryObserverが設定されていれば通知されます。
+func (o queryObservation) done(rows int, err error) {
+	info := QueryInfo{
+		Query:       o.query,
+		StartedAt:   o.started,
+		Duration:    time.Since(o.started),
+		RowsScanned: rows,
+		Err:         err,
+	}
+	recentQueries.Record(info)
+	if queryObserver != nil {
+		queryObserver.ObserveQuery(info)
+	}
+}
+
+// redacted は設定値を出力する際に秘密情報を伏せるための文字列です。
+const redacted = "[REDACTED]"
+
+// writeDebugDump はプール統計、ステートメントキャッシュ、直近のクエリ、設定値をwに書き出します。
+// パスワードなどの秘密情報は伏せて出力します。cacheがnilの場合はキャッシュの項目を省略します。
+func writeDebugDump(w io.Writer, db *sql.DB, cache *StmtCache) {
+	fmt.Fprintf(w, "=== debug dump (%s) ===\n", time.Now().Format(time.RFC3339))
+
+	stats := db.Stats()
+	fmt.Fprintln(w, "-- connection pool --")
+	fmt.Fprintf(w, "open=%d in_use=%d idle=%d max_open=%d wait_count=%d wait_duration=%s\n",
+		stats.OpenConnections, stats.InUse, stats.Idle, stats.MaxOpenConnections, stats.WaitCount, stats.WaitDuration)
+	fmt.Fprintf(w, "max_idle_closed=%d max_idle_time_closed=%d max_lifetime_closed=%d\n",
+		stats.MaxIdleClosed, stats.MaxIdleTimeClosed, stats.MaxLifetimeClosed)
+
+	fmt.Fprintln(w, "-- statement cache --")
+	if cache == nil {
+		fmt.Fprintln(w, "(disabled)")
+	} else {
+		hits, misses := cache.Stats()
+		fmt.Fprintf(w, "hits=%d misses=%d\n", hits, misses)
+		for _, query := range cache.Queries() {
+			fmt.Fprintf(w, "  %s\n", query)
+		}
+	}
+
+	fmt.Fprintln(w, "-- recent queries --")
+	for _, q := range recentQueries.Snapshot() {
+		status := "ok"
+		if q.Err != nil {
+			status = q.Err.Error()
+		}
+		fmt.Fprintf(w, "%s %s rows=%d %s [%s]\n", q.StartedAt.Format(time.RFC3339Nano), q.Duration, q.RowsScanned, q.Query, status)
+	}
+
+	fmt.Fprintln(w, "-- config --")
+	fmt.Fprintf(w, "db=%s@tcp(%s:%d)/%s password=%s read_timeout=%s write_timeout=%s\n",
+		dbUser, dbHost, dbPort, dbName, redacted, dbReadTimeout, dbWriteTimeout)
+	fmt.Fprintf(w, "prepare_on_startup=%t server_side_prepare=%t auto_migrate=%t audit_log=%t cached_total=%t\n",
+		prepareStatementsOnStartup, serverSidePrepare, autoMigrate, auditLogEnabled, cachedTotalEnabled)
+	fmt.Fprintf(w, "time_location=%s optional_columns=%v\n", timeLocation, optionalStockColumns)
+}
+
+// installDebugDump はSIGQUITを受け取るたびにデバッグダンプを標準エラー出力へ書き出すようにします。
+// SIGQUITによる既定の終了（ゴルーチンのダンプ）は行われなくなります。返される関数で登録を解除します。
+func installDebugDump(db *sql.DB, cache *StmtCache) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGQUIT)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				writeDebugDump(os.Stderr, db, cache)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
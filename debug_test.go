@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withRecentQueries はテスト中だけクエリ記録のリングバッファを差し替えます
+func withRecentQueries(t *testing.T, size int) *queryRing {
+	original := recentQueries
+	recentQueries = newQueryRing(size)
+	t.Cleanup(func() { recentQueries = original })
+	return recentQueries
+}
+
+// TestQueryRing_KeepsMostRecent はN+k件記録した後に直近のN件が古い順で残ることをテストします
+func TestQueryRing_KeepsMostRecent(t *testing.T) {
+	const size, extra = 4, 3
+	ring := newQueryRing(size)
+
+	assert.Empty(t, ring.Snapshot(), "記録がなければ空であるべき")
+
+	for i := 0; i < size+extra; i++ {
+		ring.Record(QueryInfo{Query: fmt.Sprintf("q%d", i)})
+	}
+
+	var queries []string
+	for _, info := range ring.Snapshot() {
+		queries = append(queries, info.Query)
+	}
+	assert.Equal(t, []string{"q3", "q4", "q5", "q6"}, queries, "直近の4件が古い順で残るべき")
+}
+
+// TestQueryRing_Concurrent は並行して記録しても保持件数を超えないことをテストします
+func TestQueryRing_Concurrent(t *testing.T) {
+	const size = 8
+	ring := newQueryRing(size)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				ring.Record(QueryInfo{Query: "SELECT 1"})
+				_ = ring.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, ring.Snapshot(), size, "保持件数はリングの大きさであるべき")
+}
+
+// TestWriteDebugDump はダンプに各項目が含まれ、パスワードが伏せられることをテストします
+func TestWriteDebugDump(t *testing.T) {
+	originalPassword := dbPassword
+	dbPassword = "s3cr3t-pass"
+	t.Cleanup(func() { dbPassword = originalPassword })
+	ring := withRecentQueries(t, 2)
+
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// 3件の問い合わせのうち、直近の2件だけがダンプに残る
+	for _, name := range []string{"apple", "banana", "cherry"} {
+		mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+			WithArgs(name).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}))
+	}
+	for _, name := range []string{"apple", "banana", "cherry"} {
+		_, err := QueryStocks(db, name)
+		assert.NoError(t, err)
+	}
+	ring.Record(QueryInfo{Query: "SELECT broken", StartedAt: time.Now(), Err: errors.New("syntax error")})
+
+	mock.ExpectPrepare(regexp.QuoteMeta(stmtStockAmount.SQL))
+	cache := NewStmtCache()
+	_, err := cache.Prepare(context.Background(), db, stmtStockAmount.SQL)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	writeDebugDump(&buf, db, cache)
+	out := buf.String()
+
+	assert.NotContains(t, out, "s3cr3t-pass", "パスワードは出力されないべき")
+	assert.Contains(t, out, "password="+redacted)
+	assert.Contains(t, out, "-- connection pool --")
+	assert.Contains(t, out, "hits=0 misses=1")
+	assert.Contains(t, out, stmtStockAmount.SQL, "キャッシュ済みのSQL文が出力されるべき")
+	assert.Contains(t, out, "[syntax error]", "クエリのエラーが出力されるべき")
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("FROM stocks WHERE name = ?; [ok]")), "直近の2件のみ残るべき")
+	verifyExpectations(t, mock)
+}
+
+// TestWriteDebugDump_NoCache はキャッシュがない場合も出力できることをテストします
+func TestWriteDebugDump_NoCache(t *testing.T) {
+	withRecentQueries(t, 2)
+	db, _, _ := setupMockDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	writeDebugDump(&buf, db, nil)
+	assert.Contains(t, buf.String(), "(disabled)")
+}
+
+// recordingObserver は通知されたQueryInfoを保持するテスト用のQueryObserverです
+type recordingObserver struct {
+	mu    sync.Mutex
+	infos []QueryInfo
+}
+
+func (o *recordingObserver) ObserveQuery(info QueryInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.infos = append(o.infos, info)
+}
+
+// withQueryObserver はテスト中だけクエリの通知先を差し替えます
+func withQueryObserver(t *testing.T) *recordingObserver {
+	original := queryObserver
+	observer := &recordingObserver{}
+	queryObserver = observer
+	t.Cleanup(func() { queryObserver = original })
+	return observer
+}
+
+// TestObserveQuery_RowsScanned は読み取った行数が返した行数と一致して通知されることをテストします
+func TestObserveQuery_RowsScanned(t *testing.T) {
+	threeRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "amount"}).
+			AddRow(1, "apple", 100).
+			AddRow(2, "banana", 50).
+			AddRow(3, "cherry", 10)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		setup func(mock sqlmock.Sqlmock)
+		call  func(db *sql.DB) (int, error)
+	}{
+		{
+			name:  "QueryStocks",
+			query: queryAllStocks(),
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks;`).WillReturnRows(threeRows())
+			},
+			call: func(db *sql.DB) (int, error) {
+				results, err := QueryStocks(db, "")
+				return len(results), err
+			},
+		},
+		{
+			name:  "QueryStocksTyped",
+			query: queryAllStocks(),
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks;`).WillReturnRows(threeRows())
+			},
+			call: func(db *sql.DB) (int, error) {
+				results, err := QueryStocksTyped(db, "")
+				return len(results), err
+			},
+		},
+		{
+			name:  "QueryStocksNullable",
+			query: queryStocksByName(),
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+					WithArgs("apple").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).AddRow(1, "apple", nil))
+			},
+			call: func(db *sql.DB) (int, error) {
+				results, err := QueryStocksNullable(db, "apple")
+				return len(results), err
+			},
+		},
+		{
+			name:  "QueryStocksByCategory",
+			query: "SELECT id, name, amount, category FROM stocks WHERE category = ? ORDER BY name;",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE category = \? ORDER BY name;`).
+					WithArgs("fruit").
+					WillReturnRows(threeRows())
+			},
+			call: func(db *sql.DB) (int, error) {
+				results, err := QueryStocksByCategory(db, "fruit")
+				return len(results), err
+			},
+		},
+		{
+			name:  "MedianStockAmount",
+			query: SQLAmountsAscending,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlPattern(SQLAmountsAscending)).
+					WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(1).AddRow(2).AddRow(3).AddRow(4))
+			},
+			call: func(db *sql.DB) (int, error) {
+				// 中央値の計算には4行すべてを読み取る
+				_, err := MedianStockAmount(db)
+				return 4, err
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			observer := withQueryObserver(t)
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+			tc.setup(mock)
+
+			returned, err := tc.call(db)
+
+			assert.NoError(t, err)
+			if assert.Len(t, observer.infos, 1, "1件のクエリが通知されるべき") {
+				assert.Equal(t, tc.query, observer.infos[0].Query)
+				assert.Equal(t, returned, observer.infos[0].RowsScanned, "読み取った行数は返した行数と一致するべき")
+				assert.NoError(t, observer.infos[0].Err)
+			}
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestObserveQuery_Error はクエリエラーも行数0で通知されることをテストします
+func TestObserveQuery_Error(t *testing.T) {
+	observer := withQueryObserver(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks;`).WillReturnError(errors.New("query failed"))
+
+	_, err := QueryStocks(db, "")
+	assert.Error(t, err)
+	if assert.Len(t, observer.infos, 1) {
+		assert.Equal(t, 0, observer.infos[0].RowsScanned)
+		assert.EqualError(t, observer.infos[0].Err, "query failed")
+	}
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 取り込みの形式
+const (
+	// formatJSONL は1行に1つのJSONオブジェクトを書いた形式です
+	formatJSONL = "jsonl"
+	// formatAuto は内容の先頭から形式を判定し、判定できない場合は拡張子から決めることを表します
+	formatAuto = "auto"
+)
+
+// detectPeekSize はDetectFormatが形式の判定に読む最大のバイト数です。
+const detectPeekSize = 512
+
+// utf8BOM はファイルの先頭に付くことのあるUTF-8のBOMです。
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+var (
+	// ErrFormatUndetected は内容からも拡張子からも取り込みの形式を判定できなかった場合に返されます。
+	ErrFormatUndetected = errors.New("取り込みの形式を判定できません")
+	// ErrUnknownImportFormat は登録されていない取り込みの形式を指定した場合に返されます。
+	ErrUnknownImportFormat = errors.New("不明な取り込みの形式です")
+)
+
+// Decoder は取り込むファイルから在庫の変更を1件ずつ読み出します。
+// 読み終えた場合はio.EOFを返します。内容の誤りは、行番号と何件目かを含むDecodeErrorで返します。
+type Decoder interface {
+	Next() (StockUpdate, error)
+}
+
+// DecoderFactory はrから読み出すDecoderを作成します。rの先頭のBOMは取り除かれています。
+type DecoderFactory func(r io.Reader) Decoder
+
+// DecodeError は取り込むファイルの内容の誤りです。形式によらず同じ形で位置を報告します。
+type DecodeError struct {
+	Format string
+	// Line は誤りのある行の番号（1始まり）です。
+	Line int
+	// Record は誤りのある在庫の変更が何件目か（1始まり、ヘッダーを除く）です。
+	Record int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%d行目（%d件目）: %v", e.Line, e.Record, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// importFormat は登録された取り込みの形式です。
+type importFormat struct {
+	factory    DecoderFactory
+	extensions []string
+}
+
+// importFormats は形式名ごとの取り込みの形式です。RegisterDecoderで追加できます。
+var importFormats = map[string]importFormat{
+	formatCSV:   {factory: NewCSVDecoder, extensions: []string{".csv"}},
+	formatJSONL: {factory: NewJSONLDecoder, extensions: []string{".jsonl", ".ndjson"}},
+	formatJSON:  {factory: NewJSONArrayDecoder, extensions: []string{".json"}},
+}
+
+// RegisterDecoder は取り込みの形式を追加します。同じ名前の形式が登録されている場合は置き換えます。
+// 追加した形式は内容からは判定しないため、--formatで指定するかextensionsの拡張子（".tsv"など）のファイルで使います。
+func RegisterDecoder(format string, factory DecoderFactory, extensions ...string) {
+	importFormats[format] = importFormat{factory: factory, extensions: extensions}
+}
+
+// DetectFormat はrの先頭（最大detectPeekSizeバイト）から取り込みの形式を判定します。
+// BOMと先頭の空白は読み飛ばし、"[{"や"[]"で始まればJSONの配列、"{"のあとに"\""か"}"が続けばJSONL、それ以外はCSVとします。
+// "[セール] apple,10"のように括弧で始まるCSVの行はCSVと判定します。内容が空白だけの場合はErrFormatUndetectedを返します。
+// rから読んだ内容は戻さないため、判定後に読み直す場合は新しいReaderを渡してください。
+func DetectFormat(r io.Reader) (string, error) {
+	head, err := io.ReadAll(io.LimitReader(r, detectPeekSize))
+	if err != nil {
+		return "", fmt.Errorf("形式の判定のための読み込みエラー: %w", err)
+	}
+	head = bytes.TrimLeftFunc(bytes.TrimPrefix(head, utf8BOM), unicode.IsSpace)
+	if len(head) == 0 {
+		return "", ErrFormatUndetected
+	}
+
+	next := bytes.TrimLeftFunc(head[1:], unicode.IsSpace)
+	switch head[0] {
+	case '[':
+		if len(next) == 0 || next[0] == '{' || next[0] == ']' {
+			return formatJSON, nil
+		}
+	case '{':
+		if len(next) == 0 || next[0] == '"' || next[0] == '}' {
+			return formatJSONL, nil
+		}
+	}
+	return formatCSV, nil
+}
+
+// formatFromExtension はpathの拡張子から取り込みの形式を返します。該当する形式がない場合は空文字列を返します。
+func formatFromExtension(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	for name, f := range importFormats {
+		for _, e := range f.extensions {
+			if strings.EqualFold(e, ext) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// newImportDecoder はformatの形式でcontentを読み出すDecoderを返します。
+// formatが空かformatAutoの場合は内容から形式を判定し、判定できなければpathの拡張子から決めます。
+func newImportDecoder(format, path string, content []byte) (Decoder, error) {
+	if format == "" || format == formatAuto {
+		detected, err := DetectFormat(bytes.NewReader(content))
+		if errors.Is(err, ErrFormatUndetected) {
+			detected = formatFromExtension(path)
+			if detected == "" {
+				return nil, fmt.Errorf("%w: %s (--formatで指定してください)", ErrFormatUndetected, path)
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		format = detected
+	}
+
+	f, ok := importFormats[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownImportFormat, format)
+	}
+	return f.factory(bytes.NewReader(bytes.TrimPrefix(content, utf8BOM))), nil
+}
+
+// decodeAll はdecの在庫の変更をすべて読み出します。
+func decodeAll(dec Decoder) ([]StockUpdate, error) {
+	var items []StockUpdate
+	for {
+		item, err := dec.Next()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// parseAmount は数量の文字列を数値に変換します。
+func parseAmount(raw string) (int, error) {
+	amount, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("数量が数値ではありません: %q", raw)
+	}
+	return amount, nil
+}
+
+// csvDecoder は"name,amount"形式のCSVを読み出すDecoderです。
+type csvDecoder struct {
+	reader  *csv.Reader
+	records int
+	started bool
+}
+
+// NewCSVDecoder は"name,amount"形式のCSVを読み出すDecoderを返します。
+// 1行目が"name"で始まる場合はヘッダーとして読み飛ばします。
+func NewCSVDecoder(r io.Reader) Decoder {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	return &csvDecoder{reader: reader}
+}
+
+func (d *csvDecoder) Next() (StockUpdate, error) {
+	for {
+		record, err := d.reader.Read()
+		if err == io.EOF {
+			return StockUpdate{}, io.EOF
+		}
+		first := !d.started
+		d.started = true
+		if err != nil {
+			d.records++
+			line := 0
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line = parseErr.Line
+			}
+			return StockUpdate{}, &DecodeError{Format: formatCSV, Line: line, Record: d.records, Err: fmt.Errorf("CSVの読み込みエラー: %w", err)}
+		}
+		if first && strings.EqualFold(record[0], "name") {
+			continue
+		}
+
+		d.records++
+		line, _ := d.reader.FieldPos(0)
+		amount, err := parseAmount(record[1])
+		if err != nil {
+			return StockUpdate{}, &DecodeError{Format: formatCSV, Line: line, Record: d.records, Err: err}
+		}
+		return StockUpdate{Name: record[0], Amount: amount}, nil
+	}
+}
+
+// jsonStockUpdate はJSONで書かれた1件の在庫の変更です。数量は形式によらず同じ誤りを報告するため、そのままの値で受け取ります。
+type jsonStockUpdate struct {
+	Name   string          `json:"name"`
+	Amount json.RawMessage `json:"amount"`
+}
+
+// toStockUpdate はStockUpdateに変換します。
+func (u jsonStockUpdate) toStockUpdate() (StockUpdate, error) {
+	if u.Amount == nil {
+		return StockUpdate{}, errors.New("数量がありません")
+	}
+	amount, err := parseAmount(string(u.Amount))
+	if err != nil {
+		return StockUpdate{}, err
+	}
+	return StockUpdate{Name: u.Name, Amount: amount}, nil
+}
+
+// jsonlDecoder は1行に1つの{"name": ..., "amount": ...}を書いた形式を読み出すDecoderです。
+type jsonlDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+	records int
+}
+
+// NewJSONLDecoder は1行に1つのJSONオブジェクトを書いた形式を読み出すDecoderを返します。空行は読み飛ばします。
+func NewJSONLDecoder(r io.Reader) Decoder {
+	return &jsonlDecoder{scanner: bufio.NewScanner(r)}
+}
+
+func (d *jsonlDecoder) Next() (StockUpdate, error) {
+	for d.scanner.Scan() {
+		d.line++
+		text := bytes.TrimSpace(d.scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		d.records++
+		var u jsonStockUpdate
+		if err := json.Unmarshal(text, &u); err != nil {
+			return StockUpdate{}, &DecodeError{Format: formatJSONL, Line: d.line, Record: d.records, Err: fmt.Errorf("JSONの読み込みエラー: %w", err)}
+		}
+		item, err := u.toStockUpdate()
+		if err != nil {
+			return StockUpdate{}, &DecodeError{Format: formatJSONL, Line: d.line, Record: d.records, Err: err}
+		}
+		return item, nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return StockUpdate{}, &DecodeError{Format: formatJSONL, Line: d.line + 1, Record: d.records + 1, Err: err}
+	}
+	return StockUpdate{}, io.EOF
+}
+
+// jsonArrayDecoder は[{"name": ..., "amount": ...}, ...]形式を読み出すDecoderです。
+type jsonArrayDecoder struct {
+	r       io.Reader
+	data    []byte
+	dec     *json.Decoder
+	records int
+	// err は配列として読めなかった場合のエラーで、以降のNextでも返します
+	err error
+}
+
+// NewJSONArrayDecoder はJSONオブジェクトの配列を読み出すDecoderを返します。
+// 要素の行番号を報告するため、最初のNextで内容をすべて読み込みます。
+func NewJSONArrayDecoder(r io.Reader) Decoder {
+	return &jsonArrayDecoder{r: r}
+}
+
+func (d *jsonArrayDecoder) Next() (StockUpdate, error) {
+	if d.err != nil {
+		return StockUpdate{}, d.err
+	}
+	if d.dec == nil {
+		data, err := io.ReadAll(d.r)
+		if err != nil {
+			d.err = &DecodeError{Format: formatJSON, Line: 1, Record: 1, Err: err}
+			return StockUpdate{}, d.err
+		}
+		d.data = data
+		d.dec = json.NewDecoder(bytes.NewReader(data))
+		if tok, err := d.dec.Token(); err != nil || tok != json.Delim('[') {
+			d.err = &DecodeError{Format: formatJSON, Line: d.lineAt(int(d.dec.InputOffset())), Record: 1, Err: errors.New("JSONの読み込みエラー: 配列ではありません")}
+			return StockUpdate{}, d.err
+		}
+	}
+	if !d.dec.More() {
+		return StockUpdate{}, io.EOF
+	}
+
+	d.records++
+	start := d.elementOffset()
+	var u jsonStockUpdate
+	if err := d.dec.Decode(&u); err != nil {
+		return StockUpdate{}, &DecodeError{Format: formatJSON, Line: d.lineAt(start), Record: d.records, Err: fmt.Errorf("JSONの読み込みエラー: %w", err)}
+	}
+	item, err := u.toStockUpdate()
+	if err != nil {
+		return StockUpdate{}, &DecodeError{Format: formatJSON, Line: d.lineAt(start), Record: d.records, Err: err}
+	}
+	return item, nil
+}
+
+// elementOffset は次の要素の先頭の位置を返します。InputOffsetは直前のトークンの直後を指すため、区切りと空白を読み飛ばします。
+func (d *jsonArrayDecoder) elementOffset() int {
+	offset := int(d.dec.InputOffset())
+	for offset < len(d.data) && (d.data[offset] == ',' || unicode.IsSpace(rune(d.data[offset]))) {
+		offset++
+	}
+	return offset
+}
+
+// lineAt はoffsetの位置の行番号を返します。
+func (d *jsonArrayDecoder) lineAt(offset int) int {
+	return 1 + bytes.Count(d.data[:min(offset, len(d.data))], []byte("\n"))
+}
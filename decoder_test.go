@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDetectFormat は内容の先頭から取り込みの形式を判定することをテストします
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{name: "CSV", content: "name,amount\napple,10\n", expected: formatCSV},
+		{name: "JSONL", content: `{"name": "apple", "amount": 10}` + "\n", expected: formatJSONL},
+		{name: "JSONの配列", content: `[{"name": "apple", "amount": 10}]`, expected: formatJSON},
+		{name: "空のJSONの配列", content: "[ ]", expected: formatJSON},
+		{name: "BOMと先頭の空白", content: "\xEF\xBB\xBF \n\t[\n  {\"name\": \"apple\"}]", expected: formatJSON},
+		{name: "BOM付きのCSV", content: "\xEF\xBB\xBFapple,10\n", expected: formatCSV},
+		{name: "括弧で始まる商品名のCSV", content: "[sale] apple,10\n", expected: formatCSV},
+		{name: "波括弧で始まる商品名のCSV", content: "{new} apple,10\n", expected: formatCSV},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			format, err := DetectFormat(strings.NewReader(tc.content))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, format)
+		})
+	}
+
+	t.Run("空白だけ", func(t *testing.T) {
+		_, err := DetectFormat(strings.NewReader("\xEF\xBB\xBF \n\n"))
+		assert.ErrorIs(t, err, ErrFormatUndetected)
+	})
+}
+
+// TestNewImportDecoder は形式の指定、内容からの判定、拡張子への切り替えでDecoderを選ぶことをテストします
+func TestNewImportDecoder(t *testing.T) {
+	expected := []StockUpdate{{Name: "apple", Amount: 10}, {Name: "banana", Amount: -3}}
+	tests := []struct {
+		name    string
+		format  string
+		path    string
+		content string
+	}{
+		{name: "CSVを判定", format: formatAuto, path: "stocks.json", content: "name,amount\napple,10\nbanana,-3\n"},
+		{name: "JSONLを判定", format: formatAuto, path: "stocks.csv", content: "{\"name\": \"apple\", \"amount\": 10}\n\n{\"name\": \"banana\", \"amount\": -3}\n"},
+		{name: "JSONの配列を判定", format: "", path: "stocks.csv", content: "\xEF\xBB\xBF[\n  {\"name\": \"apple\", \"amount\": 10},\n  {\"name\": \"banana\", \"amount\": -3}\n]\n"},
+		{name: "形式を指定", format: formatJSONL, path: "stocks.txt", content: "{\"name\": \"apple\", \"amount\": 10}\n{\"name\": \"banana\", \"amount\": -3}"},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			dec, err := newImportDecoder(tc.format, tc.path, []byte(tc.content))
+			require.NoError(t, err)
+			items, err := decodeAll(dec)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, items)
+		})
+	}
+
+	t.Run("空のファイルは拡張子で決める", func(t *testing.T) {
+		dec, err := newImportDecoder(formatAuto, "stocks.JSON", []byte("\n"))
+		require.NoError(t, err)
+		_, err = decodeAll(dec)
+		var decodeErr *DecodeError
+		if assert.ErrorAs(t, err, &decodeErr, "拡張子どおりJSONの配列として読むべき") {
+			assert.Equal(t, formatJSON, decodeErr.Format)
+		}
+	})
+
+	t.Run("判定できない", func(t *testing.T) {
+		_, err := newImportDecoder(formatAuto, "stocks.txt", []byte("  "))
+		assert.ErrorIs(t, err, ErrFormatUndetected)
+		assert.ErrorContains(t, err, "--format")
+	})
+
+	t.Run("不明な形式", func(t *testing.T) {
+		_, err := newImportDecoder("xml", "stocks.xml", []byte("<stocks/>"))
+		assert.ErrorIs(t, err, ErrUnknownImportFormat)
+	})
+}
+
+// TestDecodeError_Parity は形式によらず、誤りのある行番号と何件目かを同じ形で報告することをテストします
+func TestDecodeError_Parity(t *testing.T) {
+	tests := []struct {
+		format  string
+		content string
+		line    int
+	}{
+		{format: formatCSV, content: "name,amount\napple,10\nbanana,many\n", line: 3},
+		{format: formatJSONL, content: "{\"name\": \"apple\", \"amount\": 10}\n\n{\"name\": \"banana\", \"amount\": \"many\"}\n", line: 3},
+		{format: formatJSON, content: "[\n  {\"name\": \"apple\", \"amount\": 10},\n  {\"name\": \"banana\",\n   \"amount\": \"many\"}\n]", line: 3},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.format, func(t *testing.T) {
+			dec, err := newImportDecoder(tc.format, "", []byte(tc.content))
+			require.NoError(t, err)
+
+			_, err = decodeAll(dec)
+
+			var decodeErr *DecodeError
+			require.ErrorAs(t, err, &decodeErr)
+			assert.Equal(t, tc.format, decodeErr.Format)
+			assert.Equal(t, tc.line, decodeErr.Line, "誤りのある要素の先頭の行を報告するべき")
+			assert.Equal(t, 2, decodeErr.Record, "ヘッダーや空行を除いた件数を報告するべき")
+			assert.Contains(t, err.Error(), "数量が数値ではありません", "形式によらず同じ理由を報告するべき")
+		})
+	}
+
+	t.Run("構文の誤り", func(t *testing.T) {
+		for format, content := range map[string]string{
+			formatCSV:   "apple,10\nbanana,1,2\n",
+			formatJSONL: "{\"name\": \"apple\", \"amount\": 10}\n{\"name\": \n",
+			formatJSON:  "[{\"name\": \"apple\", \"amount\": 10},\n {\"name\" 1}]",
+		} {
+			dec, err := newImportDecoder(format, "", []byte(content))
+			require.NoError(t, err)
+
+			_, err = decodeAll(dec)
+
+			var decodeErr *DecodeError
+			if assert.ErrorAs(t, err, &decodeErr, format) {
+				assert.Equal(t, 2, decodeErr.Line, format)
+				assert.Equal(t, 2, decodeErr.Record, format)
+			}
+		}
+	})
+}
+
+// tsvDecoder は埋め込み側が追加する想定の、タブ区切りの形式を読み出すテスト用のDecoderです
+type tsvDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (d *tsvDecoder) Next() (StockUpdate, error) {
+	if !d.scanner.Scan() {
+		return StockUpdate{}, io.EOF
+	}
+	d.line++
+	name, raw, _ := strings.Cut(d.scanner.Text(), "\t")
+	amount, err := parseAmount(raw)
+	if err != nil {
+		return StockUpdate{}, &DecodeError{Format: "tsv", Line: d.line, Record: d.line, Err: err}
+	}
+	return StockUpdate{Name: name, Amount: amount}, nil
+}
+
+// TestRegisterDecoder は追加した形式が--formatの指定と拡張子の両方で使われ、取り込みに使えることをテストします
+func TestRegisterDecoder(t *testing.T) {
+	RegisterDecoder("tsv", func(r io.Reader) Decoder { return &tsvDecoder{scanner: bufio.NewScanner(r)} }, ".tsv")
+	t.Cleanup(func() { delete(importFormats, "tsv") })
+
+	dec, err := newImportDecoder("tsv", "stocks.txt", []byte("apple\t10\n"))
+	require.NoError(t, err)
+	items, err := decodeAll(dec)
+	assert.NoError(t, err)
+	assert.Equal(t, []StockUpdate{{Name: "apple", Amount: 10}}, items)
+
+	// 追加した形式は内容からは判定しないため、拡張子から選ばれる
+	assert.Equal(t, "tsv", formatFromExtension("stocks.TSV"))
+
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	path := filepath.Join(t.TempDir(), "stocks.tsv")
+	require.NoError(t, os.WriteFile(path, []byte("apple\t10\n"), 0o600))
+
+	mock.ExpectQuery(findImportRunRegex).
+		WithArgs(path, importStatusRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "checksum", "next_index"}))
+	mock.ExpectExec(`INSERT INTO import_runs`).
+		WithArgs(path, sqlmock.AnyArg(), importStatusRunning).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectImportBatch(mock, 1, []StockUpdate{{"apple", 10}}, 1)
+	mock.ExpectExec(`UPDATE import_runs SET status = \? WHERE id = \?;`).
+		WithArgs(importStatusCompleted, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, err = ImportStocksFile(db, path, ImportOptions{Format: "tsv"})
+	assert.NoError(t, err)
+	verifyExpectations(t, mock)
+}
+
+// TestImportStocksFile_DecodeError は内容の誤りがDecodeErrorとして返り、DBには何も書き込まないことをテストします
+func TestImportStocksFile_DecodeError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	path := filepath.Join(t.TempDir(), "stocks.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"name\": \"apple\"}\n"), 0o600))
+
+	_, err := ImportStocksFile(db, path, ImportOptions{})
+
+	var decodeErr *DecodeError
+	assert.True(t, errors.As(err, &decodeErr), "DecodeErrorが返るべき")
+	assert.ErrorContains(t, err, "1行目（1件目）: 数量がありません")
+	verifyExpectations(t, mock)
+}
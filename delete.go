@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// defaultDeleteBatchSize は一括削除で1トランザクションあたりに削除する既定の商品名の数です。
+const defaultDeleteBatchSize = 500
+
+// deleteBatchHook はバッチのコミット後に呼ばれるフックです。テストでバッチの間のキャンセルを再現するために使います。
+var deleteBatchHook func(batchIndex int)
+
+// DeleteBatchResult は一括削除の1バッチ分の結果です。
+type DeleteBatchResult struct {
+	// Start はこのバッチの先頭の商品名がnamesの何番目かを表します。
+	Start int
+	// Requested はこのバッチで削除を指定した商品名の数です。
+	Requested int
+	// Deleted はこのバッチで実際に削除した行数です。
+	Deleted int64
+}
+
+// DeleteReport は一括削除の結果です。
+// 途中のバッチで失敗した場合は、それまでにコミットしたバッチの結果だけを含みます。
+type DeleteReport struct {
+	Batches []DeleteBatchResult
+	// Deleted は全バッチで削除した行数の合計です。
+	Deleted int64
+	// NotFound は削除を指定したものの存在しなかった商品名です。
+	NotFound []string
+}
+
+// DeleteStocksByNames は指定した商品名の在庫をbatchSize件ずつ、バッチごとのトランザクションで削除します。
+// batchSizeが0以下の場合はdefaultDeleteBatchSizeを使います。
+// バッチの間でctxのキャンセルを確認し、キャンセルされた場合はそれまでの結果とctxのエラーを返します。
+// 失敗したバッチはロールバックされますが、それより前のバッチはコミット済みのまま残ります。
+func DeleteStocksByNames(ctx context.Context, db *sql.DB, names []string, batchSize int) (DeleteReport, error) {
+	if err := checkWritable(); err != nil {
+		return DeleteReport{}, err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultDeleteBatchSize
+	}
+
+	var report DeleteReport
+	for start := 0; start < len(names); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return report, fmt.Errorf("%d件目からの削除を中断しました: %w", start+1, err)
+		}
+		end := min(start+batchSize, len(names))
+		deleted, notFound, err := deleteStockBatch(ctx, db, names[start:end])
+		if err != nil {
+			return report, fmt.Errorf("%d件目からのバッチの削除に失敗しました: %w", start+1, err)
+		}
+		report.Batches = append(report.Batches, DeleteBatchResult{Start: start, Requested: end - start, Deleted: deleted})
+		report.Deleted += deleted
+		report.NotFound = append(report.NotFound, notFound...)
+
+		if deleteBatchHook != nil {
+			deleteBatchHook(len(report.Batches) - 1)
+		}
+	}
+	return report, nil
+}
+
+// ErrDeleteBlocked は削除前の参照の確認が失敗したため、削除を中止した場合に返されます。
+var ErrDeleteBlocked = errors.New("参照の確認により削除を中止しました")
+
+// DeleteStockChecked は商品を削除します。削除と同じトランザクションで、削除する行をロックした後にrefCheckを呼び、
+// refCheckがエラーを返した場合は削除せずにロールバックし、ErrDeleteBlockedとrefCheckのエラーを返します。
+// 他のテーブルからの参照の確認は呼び出し元に任せるため、外部キーの有無などスキーマに依存しません。
+// 商品の行はロックしたままrefCheckを呼ぶため、外部キーで参照する行の追加は削除が終わるまで待たされます。
+// 商品が存在しない場合はrefCheckを呼ばずにsql.ErrNoRowsを返します。
+func DeleteStockChecked(db *sql.DB, name string, refCheck func(tx *sql.Tx, name string) error) error {
+	return DeleteStockCheckedContext(context.Background(), db, name, refCheck)
+}
+
+// DeleteStockCheckedContext はDeleteStockCheckedのcontext対応版です。
+func DeleteStockCheckedContext(ctx context.Context, db *sql.DB, name string, refCheck func(tx *sql.Tx, name string) error) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return WithTransaction(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, notFound, err := deleteStocksTx(ctx, tx, "DeleteStockChecked", []string{name}, refCheck)
+		if err != nil {
+			return err
+		}
+		if len(notFound) > 0 {
+			return fmt.Errorf("削除する商品が存在しません: %s: %w", name, sql.ErrNoRows)
+		}
+		return nil
+	})
+}
+
+// deleteStockBatch は1バッチ分の商品名を1つのトランザクションで削除し、削除した行数と存在しなかった商品名を返します。
+func deleteStockBatch(ctx context.Context, db *sql.DB, names []string) (int64, []string, error) {
+	var (
+		deleted  int64
+		notFound []string
+	)
+	err := WithTransaction(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		deleted, notFound, err = deleteStocksTx(ctx, tx, "DeleteStocksByNames", names, nil)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return deleted, notFound, nil
+}
+
+// deleteStocksTx はトランザクション内で商品名の在庫を削除し、削除した行数と存在しなかった商品名を返します。
+// 削除前に対象の行をロックして取得し、存在しなかった商品名の判定と変更履歴・合計のキャッシュの更新に使います。
+// refCheckがnilでなければ、存在する商品ごとに削除の前に呼び、エラーを返した場合は削除せずにエラーを返します。
+// 同じ商品名が複数回含まれていても、確認・記録と存在しなかった商品名の報告は1回だけ行います。opはSQL文のタグに付ける操作名です。
+func deleteStocksTx(ctx context.Context, tx *sql.Tx, op string, names []string, refCheck func(tx *sql.Tx, name string) error) (int64, []string, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	if len(names) > 1 {
+		// 商品名の数ごとにSQL文が変わるため、ステートメントをキャッシュするのは1件の削除だけにする
+		ctx = withoutStmtCache(ctx)
+	}
+	existing, err := lockStockAmounts(ctx, tx, op, placeholders, args)
+	if err != nil {
+		return 0, nil, err
+	}
+	unique := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	if refCheck != nil {
+		for _, name := range unique {
+			if _, ok := existing[name]; !ok {
+				continue
+			}
+			if err := refCheck(tx, name); err != nil {
+				return 0, nil, fmt.Errorf("%w: %s: %w", ErrDeleteBlocked, name, err)
+			}
+		}
+	}
+
+	result, err := execTx(ctx, tx, taggedSQL(ctx, op, deleteStocksSQL(placeholders)), args...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("データ削除エラー: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, fmt.Errorf("削除件数の取得エラー: %w", err)
+	}
+
+	var notFound []string
+	for _, name := range unique {
+		amount, ok := existing[name]
+		if !ok {
+			notFound = append(notFound, name)
+			continue
+		}
+		if err := recordStockLog(ctx, tx, name, operationDelete, -amount, 0); err != nil {
+			return 0, nil, err
+		}
+		if err := recordStockTotal(ctx, tx, -amount); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if err := bumpGeneration(ctx, tx); err != nil {
+		return 0, nil, err
+	}
+	return deleted, notFound, nil
+}
+
+// lockStocksSQL はplaceholdersの商品名の行をロックして在庫数を取得するSQL文を返します。
+func lockStocksSQL(placeholders string) string {
+	return "SELECT name, amount FROM stocks WHERE name IN (" + placeholders + ") FOR UPDATE;"
+}
+
+// deleteStocksSQL はplaceholdersの商品名の行を削除するSQL文を返します。
+func deleteStocksSQL(placeholders string) string {
+	return "DELETE FROM stocks WHERE name IN (" + placeholders + ");"
+}
+
+// lockStockAmounts は指定した商品名の行をロックし、商品名ごとの在庫数を返します。
+func lockStockAmounts(ctx context.Context, tx *sql.Tx, op, placeholders string, args []interface{}) (map[string]int, error) {
+	rows, err := queryTx(ctx, tx, taggedSQL(ctx, op, lockStocksSQL(placeholders)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("データ確認中にエラーが発生: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	existing := make(map[string]int)
+	for rows.Next() {
+		var (
+			name   string
+			amount int
+		)
+		if err := rows.Scan(&name, &amount); err != nil {
+			return nil, err
+		}
+		existing[name] = amount
+	}
+	return existing, rows.Err()
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withDeleteBatchHook はテスト中だけdeleteBatchHookを差し替えます
+func withDeleteBatchHook(t *testing.T, hook func(batchIndex int)) {
+	original := deleteBatchHook
+	deleteBatchHook = hook
+	t.Cleanup(func() { deleteBatchHook = original })
+}
+
+// expectDeleteBatch は1バッチ分の削除のモックを設定します。existingは存在する商品名と在庫数です
+func expectDeleteBatch(mock sqlmock.Sqlmock, names []string, existing map[string]int) {
+	placeholders := `\?`
+	for i := 1; i < len(names); i++ {
+		placeholders += `, \?`
+	}
+	args := make([]driver.Value, len(names))
+	rows := sqlmock.NewRows([]string{"name", "amount"})
+	for i, name := range names {
+		args[i] = name
+		if amount, ok := existing[name]; ok {
+			rows.AddRow(name, amount)
+		}
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name, amount FROM stocks WHERE name IN \(` + placeholders + `\) FOR UPDATE;`).
+		WithArgs(args...).
+		WillReturnRows(rows)
+	mock.ExpectExec(`DELETE FROM stocks WHERE name IN \(` + placeholders + `\);`).
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(existing))))
+	mock.ExpectCommit()
+}
+
+// TestDeleteStocksByNames_ChunkBoundaries はバッチの境界で商品名が分割され、存在しない商品名が報告されることをテストします
+func TestDeleteStocksByNames_ChunkBoundaries(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectDeleteBatch(mock, []string{"a", "b"}, map[string]int{"a": 1, "b": 2})
+	expectDeleteBatch(mock, []string{"c", "d"}, map[string]int{"d": 4})
+	expectDeleteBatch(mock, []string{"e"}, map[string]int{})
+
+	report, err := DeleteStocksByNames(context.Background(), db, []string{"a", "b", "c", "d", "e"}, 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, DeleteReport{
+		Batches: []DeleteBatchResult{
+			{Start: 0, Requested: 2, Deleted: 2},
+			{Start: 2, Requested: 2, Deleted: 1},
+			{Start: 4, Requested: 1, Deleted: 0},
+		},
+		Deleted:  3,
+		NotFound: []string{"c", "e"},
+	}, report)
+	verifyExpectations(t, mock)
+}
+
+// TestDeleteStocksByNames_ExactMultiple はnamesの数がbatchSizeの倍数の場合に空のバッチを実行しないことをテストします
+func TestDeleteStocksByNames_ExactMultiple(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectDeleteBatch(mock, []string{"a", "b"}, map[string]int{"a": 1, "b": 2})
+
+	report, err := DeleteStocksByNames(context.Background(), db, []string{"a", "b"}, 2)
+
+	assert.NoError(t, err)
+	assert.Len(t, report.Batches, 1)
+	assert.Empty(t, report.NotFound)
+	verifyExpectations(t, mock)
+}
+
+// TestDeleteStocksByNames_FailingMiddleBatch は途中のバッチが失敗しても、それより前のバッチはコミット済みのまま残ることをテストします
+func TestDeleteStocksByNames_FailingMiddleBatch(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectDeleteBatch(mock, []string{"a", "b"}, map[string]int{"a": 1, "b": 2})
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name, amount FROM stocks WHERE name IN \(\?, \?\) FOR UPDATE;`).
+		WithArgs("c", "d").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "amount"}).AddRow("c", 3))
+	mock.ExpectExec(`DELETE FROM stocks WHERE name IN \(\?, \?\);`).
+		WithArgs("c", "d").
+		WillReturnError(errors.New("lock wait timeout"))
+	mock.ExpectRollback()
+
+	report, err := DeleteStocksByNames(context.Background(), db, []string{"a", "b", "c", "d", "e"}, 2)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "3件目からのバッチの削除に失敗しました")
+	assert.Equal(t, DeleteReport{
+		Batches: []DeleteBatchResult{{Start: 0, Requested: 2, Deleted: 2}},
+		Deleted: 2,
+	}, report, "失敗前にコミットしたバッチの結果が返るべき")
+	verifyExpectations(t, mock)
+}
+
+// TestDeleteStocksByNames_CanceledBetweenBatches はバッチの間でキャンセルされると次のバッチを開始せずに終了することをテストします
+func TestDeleteStocksByNames_CanceledBetweenBatches(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	withDeleteBatchHook(t, func(batchIndex int) {
+		if batchIndex == 0 {
+			cancel()
+		}
+	})
+
+	expectDeleteBatch(mock, []string{"a", "b"}, map[string]int{"a": 1, "b": 2})
+
+	report, err := DeleteStocksByNames(ctx, db, []string{"a", "b", "c", "d"}, 2)
+
+	assert.True(t, errors.Is(err, context.Canceled), "キャンセルのエラーを返すべき: %v", err)
+	assert.Equal(t, int64(2), report.Deleted, "キャンセル前のバッチの結果が返るべき")
+	assert.Len(t, report.Batches, 1)
+	verifyExpectations(t, mock)
+}
+
+// TestDeleteStocksByNames_RecordsLogAndTotal は削除した商品ごとに変更履歴と合計のキャッシュを更新することをテストします
+func TestDeleteStocksByNames_RecordsLogAndTotal(t *testing.T) {
+	withAuditLog(t)
+	withCachedTotal(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name, amount FROM stocks WHERE name IN \(\?, \?\) FOR UPDATE;`).
+		WithArgs("apple", "ghost").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "amount"}).AddRow("apple", 30))
+	mock.ExpectExec(`DELETE FROM stocks WHERE name IN \(\?, \?\);`).
+		WithArgs("apple", "ghost").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stock_log \(name, operation, delta, amount\) VALUES \(\?, \?, \?, \?\);`).
+		WithArgs("apple", operationDelete, -30, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO stock_totals`).
+		WithArgs(-30).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	report, err := DeleteStocksByNames(context.Background(), db, []string{"apple", "ghost"}, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ghost"}, report.NotFound)
+	verifyExpectations(t, mock)
+}
+
+// TestDeleteStocksByNames_DuplicateNames は同じ商品名が複数回含まれていても1回だけ記録し、削除した商品名を存在しなかったと報告しないことをテストします
+func TestDeleteStocksByNames_DuplicateNames(t *testing.T) {
+	withAuditLog(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`/\* app:db_mock op:DeleteStocksByNames .*SELECT name, amount FROM stocks WHERE name IN \(\?, \?, \?\) FOR UPDATE;`).
+		WithArgs("apple", "apple", "pear").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "amount"}).AddRow("apple", 30))
+	mock.ExpectExec(`/\* app:db_mock op:DeleteStocksByNames .*DELETE FROM stocks WHERE name IN \(\?, \?, \?\);`).
+		WithArgs("apple", "apple", "pear").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stock_log \(name, operation, delta, amount\) VALUES \(\?, \?, \?, \?\);`).
+		WithArgs("apple", operationDelete, -30, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	report, err := DeleteStocksByNames(context.Background(), db, []string{"apple", "apple", "pear"}, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), report.Deleted)
+	assert.Equal(t, []string{"pear"}, report.NotFound, "削除したappleの重複は存在しなかったと報告するべきではない")
+	verifyExpectations(t, mock)
+}
+
+// TestDeleteStockChecked は参照の確認が通った場合だけ、同じトランザクションで商品を削除することをテストします
+func TestDeleteStockChecked(t *testing.T) {
+	t.Run("確認が通る", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, amount FROM stocks WHERE name IN \(\?\) FOR UPDATE;`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "amount"}).AddRow("apple", 100))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM order_items WHERE product_name = \?;`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(`DELETE FROM stocks WHERE name IN \(\?\);`).
+			WithArgs("apple").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := DeleteStockChecked(db, "apple", noOrderItems)
+
+		assert.NoError(t, err)
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("参照があれば削除しない", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, amount FROM stocks WHERE name IN \(\?\) FOR UPDATE;`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "amount"}).AddRow("apple", 100))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM order_items WHERE product_name = \?;`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		mock.ExpectRollback()
+
+		err := DeleteStockChecked(db, "apple", noOrderItems)
+
+		assert.ErrorIs(t, err, ErrDeleteBlocked)
+		assert.ErrorIs(t, err, errReferenced, "参照の確認のエラーも保持するべき")
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("存在しない商品", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT name, amount FROM stocks WHERE name IN \(\?\) FOR UPDATE;`).
+			WithArgs("banana").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "amount"}))
+		mock.ExpectExec(`DELETE FROM stocks WHERE name IN \(\?\);`).
+			WithArgs("banana").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := DeleteStockChecked(db, "banana", func(tx *sql.Tx, name string) error {
+			t.Fatal("存在しない商品では参照を確認しないべき")
+			return nil
+		})
+
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		verifyExpectations(t, mock)
+	})
+}
+
+// errReferenced はorder_itemsから参照されている商品を削除しようとした場合のテスト用のエラーです
+var errReferenced = errors.New("注文明細から参照されています")
+
+// noOrderItems は呼び出し元が用意する参照の確認の例で、order_itemsから参照されていればerrReferencedを返します
+func noOrderItems(tx *sql.Tx, name string) error {
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM order_items WHERE product_name = ?;", name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %d件", errReferenced, count)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrInsufficientStock は変更を適用すると在庫数が負になる場合に返されます。
+var ErrInsufficientStock = errors.New("在庫数が不足しています")
+
+// ErrInvalidDeltaPayload は在庫の変更量のJSONを読み込めない場合に返されます。
+var ErrInvalidDeltaPayload = errors.New("在庫の変更量のJSONが正しくありません")
+
+// ErrInvalidFloor は在庫数の下限に負の値が指定された場合に返されます。
+var ErrInvalidFloor = errors.New("在庫数の下限は0以上である必要があります")
+
+// InsufficientStockError は在庫数が不足した商品と変更の内容です。errors.Is(err, ErrInsufficientStock)で判定できます。
+type InsufficientStockError struct {
+	Name   string
+	Amount int
+	Delta  int
+}
+
+func (e *InsufficientStockError) Error() string {
+	return fmt.Sprintf("%v: %s (在庫数 %d, 変更量 %d)", ErrInsufficientStock, e.Name, e.Amount, e.Delta)
+}
+
+func (e *InsufficientStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+// ApplyDeltas は商品名ごとの変更量（正負どちらも可）を1つのトランザクションで適用します。
+// 対象の行はFOR UPDATEでロックしてから読み取り、1件でも在庫数が負になる場合は全体をロールバックして
+// InsufficientStockErrorを返します。存在しない商品は在庫数0として扱い、正の変更量であれば登録します。
+func ApplyDeltas(db *sql.DB, deltas map[string]int) error {
+	return ApplyDeltasContext(context.Background(), db, deltas)
+}
+
+// ApplyDeltasContext はApplyDeltasのcontext対応版です。
+func ApplyDeltasContext(ctx context.Context, db *sql.DB, deltas map[string]int) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	// 同時に実行された場合のデッドロックを避けるため、常に名前順でロックする
+	names := make([]string, 0, len(deltas))
+	for name := range deltas {
+		if err := ValidateName(name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+	defer tx.Rollback() // エラー発生時にロールバック
+
+	for _, name := range names {
+		if err := applyDeltaTx(ctx, tx, name, deltas[name]); err != nil {
+			return err
+		}
+	}
+	if err := bumpGeneration(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("トランザクションコミットエラー: %w", err)
+	}
+	return nil
+}
+
+// jsonDelta はJSONで書かれた1件の在庫の変更量です。数量は取り込みと同じ誤りを報告するため、そのままの値で受け取ります。
+type jsonDelta struct {
+	Name  string          `json:"name"`
+	Delta json.RawMessage `json:"delta"`
+}
+
+// ApplyJSONDeltas は[{"name": ..., "delta": ...}, ...]形式のJSONを読み込み、ApplyDeltasと同じく1つのトランザクションで適用します。
+// 同じ商品が複数回含まれる場合は変更量を合算します。JSONを最後まで読み込んで検証してからDBに問い合わせるため、
+// 形式の誤りはErrInvalidDeltaPayloadとしてトランザクションを開始する前に返します。適用した要素の数を返します。
+func ApplyJSONDeltas(db *sql.DB, r io.Reader) (applied int, err error) {
+	return ApplyJSONDeltasContext(context.Background(), db, r)
+}
+
+// ApplyJSONDeltasContext はApplyJSONDeltasのcontext対応版です。
+func ApplyJSONDeltasContext(ctx context.Context, db *sql.DB, r io.Reader) (applied int, err error) {
+	if err := checkWritable(); err != nil {
+		return 0, err
+	}
+	dec := json.NewDecoder(r)
+	var payload []jsonDelta
+	if err := dec.Decode(&payload); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidDeltaPayload, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return 0, fmt.Errorf("%w: 配列の後に余分な内容があります", ErrInvalidDeltaPayload)
+	}
+	if payload == nil {
+		return 0, fmt.Errorf("%w: 配列ではありません", ErrInvalidDeltaPayload)
+	}
+
+	deltas := make(map[string]int, len(payload))
+	for i, d := range payload {
+		if d.Delta == nil {
+			return 0, fmt.Errorf("%w: %d件目: 変更量がありません", ErrInvalidDeltaPayload, i+1)
+		}
+		delta, err := parseAmount(string(d.Delta))
+		if err != nil {
+			return 0, fmt.Errorf("%w: %d件目: %w", ErrInvalidDeltaPayload, i+1, err)
+		}
+		deltas[d.Name] += delta
+	}
+	if len(deltas) == 0 {
+		return 0, nil
+	}
+
+	if err := ApplyDeltasContext(ctx, db, deltas); err != nil {
+		return 0, err
+	}
+	return len(payload), nil
+}
+
+// applyDeltaTx はトランザクション内で1件の商品に変更量を適用します。
+func applyDeltaTx(ctx context.Context, tx *sql.Tx, name string, delta int) error {
+	var amount int
+	err := tx.QueryRowContext(ctx, taggedSQL(ctx, "ApplyDeltas", stmtStockAmountForUpdate.SQL), name).Scan(&amount)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("データ確認中にエラーが発生: %w", err)
+	}
+
+	if amount+delta < 0 {
+		return &InsufficientStockError{Name: name, Amount: amount, Delta: delta}
+	}
+	return writeDeltaTx(ctx, tx, "ApplyDeltas", name, amount, exists, delta)
+}
+
+// writeDeltaTx はロック済みの在庫数amountに変更量を加えて書き込み、変更履歴・チェックサム・合計を更新します。
+// existsがfalseの場合は商品を新規に登録します。opはSQL文のタグに含める操作名です。
+func writeDeltaTx(ctx context.Context, tx *sql.Tx, op, name string, amount int, exists bool, delta int) error {
+	newAmount := amount + delta
+	operation := operationUpdate
+	var err error
+	if exists {
+		_, err = execTx(ctx, tx, taggedSQL(ctx, op, stmtUpdateAmount.SQL), newAmount, name)
+	} else {
+		if err := checkItemQuota(ctx, tx, name); err != nil {
+			return err
+		}
+		operation = operationInsert
+		_, err = execTx(ctx, tx, taggedSQL(ctx, op, stmtInsertStock.SQL), name, newAmount)
+	}
+	if err != nil {
+		return fmt.Errorf("データ更新エラー: %w", err)
+	}
+	if err := recordStockLog(ctx, tx, name, operation, delta, newAmount); err != nil {
+		return err
+	}
+	if err := updateRowChecksum(ctx, tx, name); err != nil {
+		return err
+	}
+	return recordStockTotal(ctx, tx, delta)
+}
+
+// EnsureMinimumStock は指定商品の在庫数がfloor未満の場合にfloorまで引き上げ、存在しない場合はfloorで登録します。
+// 在庫数がfloor以上であれば何も変更しません。読み取りと更新は1つのトランザクションで行い、変更したかどうかを返します。
+func EnsureMinimumStock(db *sql.DB, name string, floor int) (changed bool, err error) {
+	return EnsureMinimumStockContext(context.Background(), db, name, floor)
+}
+
+// EnsureMinimumStockContext はEnsureMinimumStockのcontext対応版です。
+func EnsureMinimumStockContext(ctx context.Context, db *sql.DB, name string, floor int) (changed bool, err error) {
+	if err := checkWritable(); err != nil {
+		return false, err
+	}
+	if err := ValidateName(name); err != nil {
+		return false, err
+	}
+	if floor < 0 {
+		return false, fmt.Errorf("%w: %d", ErrInvalidFloor, floor)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+	defer tx.Rollback() // エラー発生時にロールバック
+
+	var amount int
+	err = tx.QueryRowContext(ctx, taggedSQL(ctx, "EnsureMinimumStock", stmtStockAmountForUpdate.SQL), name).Scan(&amount)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return false, fmt.Errorf("データ確認中にエラーが発生: %w", err)
+	}
+
+	if !exists || amount < floor {
+		if err := writeDeltaTx(ctx, tx, "EnsureMinimumStock", name, amount, exists, floor-amount); err != nil {
+			return false, err
+		}
+		if err := bumpGeneration(ctx, tx); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("トランザクションコミットエラー: %w", err)
+	}
+	return changed, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyDeltas(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// 名前順にロックして適用する
+	mock.ExpectBegin()
+	expectStockAmountForUpdate(mock, "apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	expectUpdateAmount(mock, "apple", 70).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectStockAmountForUpdate(mock, "banana").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(5))
+	expectUpdateAmount(mock, "banana", 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectStockAmountForUpdate(mock, "cherry").
+		WillReturnError(sql.ErrNoRows)
+	expectInsertStock(mock, "cherry", 20).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	err := ApplyDeltas(db, map[string]int{"cherry": 20, "banana": -5, "apple": -30})
+
+	assert.NoError(t, err)
+	verifyExpectations(t, mock)
+}
+
+// TestApplyDeltas_Underflow は在庫数が負になる商品が1件でもあれば全体をロールバックすることをテストします
+func TestApplyDeltas_Underflow(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectStockAmountForUpdate(mock, "apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	expectUpdateAmount(mock, "apple", 70).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectStockAmountForUpdate(mock, "banana").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(5))
+	mock.ExpectRollback()
+
+	err := ApplyDeltas(db, map[string]int{"apple": -30, "banana": -6})
+
+	var insufficient *InsufficientStockError
+	if assert.True(t, errors.As(err, &insufficient), "InsufficientStockErrorであるべき: %v", err) {
+		assert.True(t, errors.Is(err, ErrInsufficientStock))
+		assert.Equal(t, InsufficientStockError{Name: "banana", Amount: 5, Delta: -6}, *insufficient)
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestApplyDeltas_MissingProductUnderflow は存在しない商品への負の変更量を在庫不足として扱うことをテストします
+func TestApplyDeltas_MissingProductUnderflow(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectStockAmountForUpdate(mock, "ghost").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	err := ApplyDeltas(db, map[string]int{"ghost": -1})
+
+	assert.True(t, errors.Is(err, ErrInsufficientStock))
+	verifyExpectations(t, mock)
+}
+
+// TestApplyDeltas_InvalidName は不正な商品名が含まれる場合にトランザクションを開始しないことをテストします
+func TestApplyDeltas_InvalidName(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	err := ApplyDeltas(db, map[string]int{"apple": 1, "": 2})
+
+	assert.True(t, errors.Is(err, ErrInvalidName))
+	verifyExpectations(t, mock)
+}
+
+// TestEnsureMinimumStock は在庫数が下限未満の場合だけ下限まで引き上げ、存在しない商品は下限で登録することをテストします
+func TestEnsureMinimumStock(t *testing.T) {
+	t.Run("下限未満", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectBegin()
+		expectStockAmountForUpdate(mock, "apple").
+			WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(3))
+		expectUpdateAmount(mock, "apple", 10).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		changed, err := EnsureMinimumStock(db, "apple", 10)
+
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		verifyExpectations(t, mock)
+	})
+
+	for _, amount := range []int{10, 25} {
+		amount := amount
+		t.Run(fmt.Sprintf("在庫数%d", amount), func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+			mock.ExpectBegin()
+			expectStockAmountForUpdate(mock, "apple").
+				WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(amount))
+			mock.ExpectCommit()
+
+			changed, err := EnsureMinimumStock(db, "apple", 10)
+
+			assert.NoError(t, err)
+			assert.False(t, changed, "下限以上の在庫数は変更しないべき")
+			verifyExpectations(t, mock)
+		})
+	}
+
+	t.Run("存在しない商品", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectBegin()
+		expectStockAmountForUpdate(mock, "cherry").
+			WillReturnError(sql.ErrNoRows)
+		expectInsertStock(mock, "cherry", 10).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectCommit()
+
+		changed, err := EnsureMinimumStock(db, "cherry", 10)
+
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("負の下限", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		changed, err := EnsureMinimumStock(db, "apple", -1)
+
+		assert.True(t, errors.Is(err, ErrInvalidFloor))
+		assert.False(t, changed)
+		verifyExpectations(t, mock)
+	})
+}
+
+// TestApplyJSONDeltas はJSONの変更量を同じ商品ごとに合算し、1つのトランザクションで適用することをテストします
+func TestApplyJSONDeltas(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectStockAmountForUpdate(mock, "apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	expectUpdateAmount(mock, "apple", 95).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectStockAmountForUpdate(mock, "cherry").
+		WillReturnError(sql.ErrNoRows)
+	expectInsertStock(mock, "cherry", 20).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	payload := `[{"name": "cherry", "delta": 20}, {"name": "apple", "delta": -10}, {"name": "apple", "delta": 5}]`
+	applied, err := ApplyJSONDeltas(db, strings.NewReader(payload))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, applied)
+	verifyExpectations(t, mock)
+}
+
+// TestApplyJSONDeltas_Malformed は形式の誤ったJSONをトランザクションを開始する前に拒否することをテストします
+func TestApplyJSONDeltas_Malformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{name: "壊れたJSON", payload: `[{"name": "apple", "delta": 1}`},
+		{name: "配列ではない", payload: `{"name": "apple", "delta": 1}`},
+		{name: "null", payload: `null`},
+		{name: "変更量がない", payload: `[{"name": "apple"}]`},
+		{name: "変更量が整数ではない", payload: `[{"name": "apple", "delta": 1.5}]`},
+		{name: "変更量が文字列", payload: `[{"name": "apple", "delta": "1"}]`},
+		{name: "配列の後に余分な内容", payload: `[{"name": "apple", "delta": 1}] []`},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			applied, err := ApplyJSONDeltas(db, strings.NewReader(tc.payload))
+
+			assert.ErrorIs(t, err, ErrInvalidDeltaPayload)
+			assert.Zero(t, applied)
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestApplyJSONDeltas_Underflow は在庫数が負になる商品があれば全体をロールバックすることをテストします
+func TestApplyJSONDeltas_Underflow(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectStockAmountForUpdate(mock, "apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	expectUpdateAmount(mock, "apple", 110).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectStockAmountForUpdate(mock, "banana").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(3))
+	mock.ExpectRollback()
+
+	applied, err := ApplyJSONDeltas(db, strings.NewReader(`[{"name": "apple", "delta": 10}, {"name": "banana", "delta": -4}]`))
+
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+	assert.Zero(t, applied)
+	verifyExpectations(t, mock)
+}
+
+// TestApplyDeltas_MaintenanceMode はメンテナンスモード中はDBに問い合わせずにErrMaintenanceModeを返すことをテストします
+func TestApplyDeltas_MaintenanceMode(t *testing.T) {
+	withMaintenanceMode(t, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	err := ApplyDeltas(db, map[string]int{"apple": 10})
+	assert.True(t, errors.Is(err, ErrMaintenanceMode))
+
+	applied, err := ApplyJSONDeltas(db, strings.NewReader(`[{"name": "apple", "delta": 10}]`))
+	assert.True(t, errors.Is(err, ErrMaintenanceMode))
+	assert.Equal(t, 0, applied)
+	verifyExpectations(t, mock)
+}
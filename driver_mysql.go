@@ -0,0 +1,25 @@
+//go:build !nomysql
+
+package main
+
+// MySQLドライバをリンクしてdatabase/sqlに登録します。
+// インメモリのフェイクやインターフェースだけを使う場合は-tags nomysqlでビルドするとドライバをリンクしません。
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	driverErrorNumber = mysqlErrorNumber
+}
+
+// mysqlErrorNumber はMySQLのエラーであればそのエラー番号を返します。
+func mysqlErrorNumber(err error) (uint16, bool) {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number, true
+	}
+	return 0, false
+}
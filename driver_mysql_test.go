@@ -0,0 +1,26 @@
+//go:build !nomysql
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+// newDriverError は指定したエラー番号のMySQLエラーを生成します
+func newDriverError(number uint16, message string) error {
+	return &mysql.MySQLError{Number: number, Message: message}
+}
+
+// TestConnectDB_DriverRegistered はドライバをリンクしたビルドでは実際のsql.Openで接続を作成できることをテストします
+func TestConnectDB_DriverRegistered(t *testing.T) {
+	withRealSQLOpen(t)
+
+	db, err := ConnectDB()
+	assert.NoError(t, err, "ドライバが登録されていればsql.Openは成功するべき")
+	if assert.NotNil(t, db) {
+		assert.NoError(t, db.Close())
+	}
+}
@@ -0,0 +1,45 @@
+//go:build nomysql
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriverError はMySQLドライバをリンクしないビルドでドライバのエラーを模擬します
+type fakeDriverError struct {
+	number  uint16
+	message string
+}
+
+func (e *fakeDriverError) Error() string {
+	return fmt.Sprintf("Error %d: %s", e.number, e.message)
+}
+
+func init() {
+	driverErrorNumber = func(err error) (uint16, bool) {
+		var fe *fakeDriverError
+		if errors.As(err, &fe) {
+			return fe.number, true
+		}
+		return 0, false
+	}
+}
+
+// newDriverError は指定したエラー番号のドライバのエラーを生成します
+func newDriverError(number uint16, message string) error {
+	return &fakeDriverError{number: number, message: message}
+}
+
+// TestConnectDB_DriverNotRegistered はドライバをリンクしないビルドでErrDriverNotRegisteredが返ることをテストします
+func TestConnectDB_DriverNotRegistered(t *testing.T) {
+	withRealSQLOpen(t)
+
+	db, err := ConnectDB()
+	assert.Nil(t, db)
+	assert.ErrorIs(t, err, ErrDriverNotRegistered)
+}
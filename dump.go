@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dumpBatchSize はDumpAllが1つのINSERT文にまとめる行数です。
+const dumpBatchSize = 100
+
+// dumpDateTimeLayout はDumpAllが日時の値を書き出す形式です。
+const dumpDateTimeLayout = "2006-01-02 15:04:05.999999"
+
+// DumpAll はstocksテーブルの定義と全行を、そのまま実行して復元できるSQLスクリプトとしてwに書き出します。
+// スクリプトは既存のテーブルを削除してSHOW CREATE TABLEの定義で作り直し、行をid順にdumpBatchSize行ずつのINSERT文で挿入します。
+func DumpAll(db *sql.DB, w io.Writer) error {
+	return DumpAllContext(context.Background(), db, w)
+}
+
+// DumpAllContext はDumpAllのcontext対応版です。
+func DumpAllContext(ctx context.Context, db *sql.DB, w io.Writer) error {
+	var table, ddl string
+	if err := db.QueryRowContext(ctx, "SHOW CREATE TABLE stocks;").Scan(&table, &ddl); err != nil {
+		return fmt.Errorf("テーブル定義の取得エラー: %w", classifyError(err))
+	}
+
+	header := "SET NAMES utf8mb4;\n" +
+		"DROP TABLE IF EXISTS " + quoteIdentifier(table) + ";\n" +
+		strings.TrimSuffix(strings.TrimSpace(ddl), ";") + ";\n"
+	if _, err := io.WriteString(w, header); err != nil {
+		return fmt.Errorf("書き出しエラー: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT * FROM stocks ORDER BY id;")
+	if err != nil {
+		return fmt.Errorf("在庫データの取得エラー: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("在庫データの取得エラー: %w", err)
+	}
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = quoteIdentifier(col)
+	}
+	insert := "INSERT INTO " + quoteIdentifier(table) + " (" + strings.Join(quoted, ", ") + ") VALUES\n"
+
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		stmt := insert + strings.Join(batch, ",\n") + ";\n"
+		batch = batch[:0]
+		if _, err := io.WriteString(w, stmt); err != nil {
+			return fmt.Errorf("書き出しエラー: %w", err)
+		}
+		return nil
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("在庫データの読み取りエラー: %w", err)
+		}
+		literals := make([]string, len(columns))
+		for i, col := range columns {
+			val, err := convertColumnValue(col, values[i])
+			if err != nil {
+				return err
+			}
+			if literals[i], err = sqlLiteral(val); err != nil {
+				return fmt.Errorf("%s列: %w", col, err)
+			}
+		}
+		batch = append(batch, "("+strings.Join(literals, ",")+")")
+		if len(batch) >= dumpBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("在庫データの読み取りエラー: %w", err)
+	}
+	return flush()
+}
+
+// quoteIdentifier はテーブル名や列名をバッククォートで囲みます。名前に含まれるバッククォートは二重にします。
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// sqlStringEscaper はMySQLの文字列リテラルでエスケープが必要な文字を置き換えます。
+var sqlStringEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\"", "\\\"",
+	"\x00", "\\0",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x1a", "\\Z",
+)
+
+// sqlLiteral はconvertColumnValueで変換した値をSQLのリテラルにします。
+func sqlLiteral(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "NULL", nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case string:
+		return "'" + sqlStringEscaper.Replace(v) + "'", nil
+	case time.Time:
+		return "'" + v.Format(dumpDateTimeLayout) + "'", nil
+	default:
+		return "", fmt.Errorf("%w: %T", ErrUnsupportedColumnType, val)
+	}
+}
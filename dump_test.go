@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dumpTestDDL = "CREATE TABLE `stocks` (\n  `id` int NOT NULL AUTO_INCREMENT,\n  `name` varchar(255) NOT NULL,\n  PRIMARY KEY (`id`)\n)"
+
+// expectShowCreateTable はstocksテーブルの定義の取得を期待値として設定します
+func expectShowCreateTable(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta("SHOW CREATE TABLE stocks;")).
+		WillReturnRows(sqlmock.NewRows([]string{"Table", "Create Table"}).AddRow("stocks", dumpTestDDL))
+}
+
+// splitScript はDumpAllが書き出したスクリプトを文に分割します。
+// 文字列の改行は\nにエスケープされるため、行末の";"だけが文の区切りになります
+func splitScript(script string) []string {
+	var statements []string
+	for _, stmt := range strings.SplitAfter(script, ";\n") {
+		if stmt != "" {
+			statements = append(statements, strings.TrimSuffix(stmt, "\n"))
+		}
+	}
+	return statements
+}
+
+// TestDumpAll はテーブル定義、行のINSERT文の順に書き出し、書き出した文を別のDBでそのまま実行できることをテストします
+func TestDumpAll(t *testing.T) {
+	source, sourceMock, _ := setupMockDB(t)
+	defer source.Close()
+	created := time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)
+	expectShowCreateTable(sourceMock)
+	sourceMock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM stocks ORDER BY id;")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category", "created_at"}).
+			AddRow(1, "apple", 10, "fruit", created).
+			AddRow(2, "O'Reilly \\ \"本\"\n;", 0, nil, nil))
+
+	var buf bytes.Buffer
+	require.NoError(t, DumpAll(source, &buf))
+	verifyExpectations(t, sourceMock)
+
+	expected := []string{
+		"SET NAMES utf8mb4;",
+		"DROP TABLE IF EXISTS `stocks`;",
+		dumpTestDDL + ";",
+		"INSERT INTO `stocks` (`id`, `name`, `amount`, `category`, `created_at`) VALUES\n" +
+			"(1,'apple',10,'fruit','2025-03-01 09:30:00'),\n" +
+			`(2,'O\'Reilly \\ \"本\"\n;',0,NULL,NULL);`,
+	}
+	statements := splitScript(buf.String())
+	assert.Equal(t, expected, statements)
+
+	// 書き出したスクリプトを別のDBで順に実行する
+	target, targetMock, _ := setupMockDB(t)
+	defer target.Close()
+	for _, stmt := range expected {
+		targetMock.ExpectExec(regexp.QuoteMeta(stmt)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	for _, stmt := range statements {
+		_, err := target.ExecContext(context.Background(), stmt)
+		require.NoError(t, err)
+	}
+	verifyExpectations(t, targetMock)
+}
+
+// TestDumpAll_Batches は行をdumpBatchSize行ずつのINSERT文に分けて書き出すことをテストします
+func TestDumpAll_Batches(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	expectShowCreateTable(mock)
+	rows := sqlmock.NewRows([]string{"id", "name"})
+	for i := 1; i <= dumpBatchSize+1; i++ {
+		rows.AddRow(i, fmt.Sprintf("item-%d", i))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM stocks ORDER BY id;")).WillReturnRows(rows)
+
+	var buf bytes.Buffer
+	require.NoError(t, DumpAll(db, &buf))
+
+	statements := splitScript(buf.String())
+	require.Len(t, statements, 5)
+	assert.Equal(t, dumpBatchSize, strings.Count(statements[3], "'item-"))
+	assert.Equal(t, "INSERT INTO `stocks` (`id`, `name`) VALUES\n"+fmt.Sprintf("(%d,'item-%d');", dumpBatchSize+1, dumpBatchSize+1), statements[4])
+	verifyExpectations(t, mock)
+}
+
+// TestDumpAll_Empty は行がない場合はテーブル定義だけを書き出すことをテストします
+func TestDumpAll_Empty(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	expectShowCreateTable(mock)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM stocks ORDER BY id;")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	var buf bytes.Buffer
+	require.NoError(t, DumpAll(db, &buf))
+
+	assert.Len(t, splitScript(buf.String()), 3)
+	assert.NotContains(t, buf.String(), "INSERT")
+	verifyExpectations(t, mock)
+}
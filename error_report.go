@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ErrorClass はErrorReportが判定するエラーの分類です。
+type ErrorClass string
+
+const (
+	ErrorClassNotFound   ErrorClass = "not-found"
+	ErrorClassConflict   ErrorClass = "conflict"
+	ErrorClassConnection ErrorClass = "connection"
+	ErrorClassSchema     ErrorClass = "schema"
+	ErrorClassValidation ErrorClass = "validation"
+	ErrorClassUnknown    ErrorClass = "unknown"
+)
+
+// Report はエラーの分類と原因、対処をまとめたものです。問い合わせに添付するために使います。
+type Report struct {
+	Class ErrorClass `json:"classification"`
+	// Message はエラー全体のメッセージです。
+	Message string `json:"message,omitempty"`
+	// Operation とStatement はQueryErrorから取り出した、エラーが発生した操作とSQL文です。
+	Operation string `json:"operation,omitempty"`
+	Statement string `json:"statement,omitempty"`
+	// MySQLErrorNumber はMySQLのエラー番号です。MySQLのエラーでない場合は0です。
+	MySQLErrorNumber uint16 `json:"mysql_error_number,omitempty"`
+	// Retryable は同じ操作を再試行すれば成功する可能性がある場合にtrueです。
+	Retryable   bool   `json:"retryable"`
+	OperationID string `json:"operation_id,omitempty"`
+	// Hint は分類ごとの対処方法です。messageCatalogからmessageLanguageのメッセージを使います。
+	Hint string `json:"hint"`
+}
+
+// ErrorReport はerrのラップをたどって分類、操作、MySQLのエラー番号、再試行の可否を調べ、Reportにまとめます。
+// errがnilの場合はゼロ値を返します。
+func ErrorReport(err error) Report {
+	if err == nil {
+		return Report{}
+	}
+	report := Report{Message: err.Error()}
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		report.Operation = queryErr.Operation
+		report.Statement = queryErr.Statement
+		report.OperationID = queryErr.OperationID
+	}
+	if number, ok := driverErrorNumber(err); ok {
+		report.MySQLErrorNumber = number
+	}
+	report.Class, report.Retryable = classifyForReport(err, report.MySQLErrorNumber)
+	report.Hint = message("hint." + string(report.Class))
+	return report
+}
+
+// classifyForReport はエラーの分類と再試行の可否を判定します。numberはMySQLのエラー番号（ない場合は0）です。
+func classifyForReport(err error, number uint16) (ErrorClass, bool) {
+	switch number {
+	case mysqlErrNoSuchTable:
+		return ErrorClassSchema, false
+	case mysqlErrDuplicateEntry:
+		return ErrorClassConflict, false
+	case mysqlErrLockWaitTimeout, mysqlErrDeadlock:
+		return ErrorClassConflict, true
+	case mysqlErrTooManyConnections:
+		return ErrorClassConnection, true
+	case mysqlErrAccessDenied, mysqlErrDatabaseAccessDenied:
+		return ErrorClassConnection, false
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrSchemaMissing), errors.Is(err, ErrColumnDrift),
+		errors.Is(err, ErrMigrationGap), errors.Is(err, ErrMigrationDowngrade):
+		return ErrorClassSchema, false
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, ErrNoStocks), errors.Is(err, ErrOperationNotFound):
+		return ErrorClassNotFound, false
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrNameRejected), errors.Is(err, ErrSuspiciousChange),
+		errors.Is(err, ErrInsufficientStock), errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrInvalidClaimSize),
+		errors.Is(err, ErrInvalidBucket), errors.Is(err, ErrInvalidWindow), errors.Is(err, ErrInvalidPageSize), errors.Is(err, ErrInvalidTimeRange), errors.Is(err, ErrUnknownColumn),
+		errors.Is(err, ErrUnknownFormat), errors.Is(err, ErrInvalidFeatures), errors.Is(err, ErrInvalidOperation),
+		errors.Is(err, ErrUnknownSubcommand):
+		return ErrorClassValidation, false
+	case errors.Is(err, ErrLockTimeout), errors.Is(err, ErrMigrationLockTimeout), errors.Is(err, ErrMaintenanceMode),
+		errors.Is(err, ErrReplicaDiverged):
+		return ErrorClassConflict, true
+	case errors.Is(err, ErrImportChecksumMismatch), errors.Is(err, ErrLedgerMismatch), errors.Is(err, ErrSnapshotDiffers):
+		return ErrorClassConflict, false
+	case errors.Is(err, ErrDriverNotRegistered), errors.Is(err, ErrUnknownSecretProvider), errors.Is(err, ErrUnknownProfile):
+		return ErrorClassConnection, false
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		return ErrorClassConnection, true
+	}
+	return ErrorClassUnknown, false
+}
+
+// Public はHTTPのレスポンスなど外部に返すためのReportです。エラーのメッセージ、操作、SQL文とMySQLのエラー番号は含めません。
+func (r Report) Public() Report {
+	return Report{Class: r.Class, Retryable: r.Retryable, OperationID: r.OperationID, Hint: r.Hint}
+}
+
+// Write はレポートを人が読める形式でwに書き出します。
+func (r Report) Write(w io.Writer) error {
+	retry := message("report.permanent")
+	if r.Retryable {
+		retry = message("report.retryable")
+	}
+	lines := []string{fmt.Sprintf("分類: %s (%s)", r.Class, retry)}
+	if r.Operation != "" {
+		lines = append(lines, "操作: "+r.Operation)
+	}
+	if r.OperationID != "" {
+		lines = append(lines, "操作ID: "+r.OperationID)
+	}
+	if r.Statement != "" {
+		lines = append(lines, "SQL: "+r.Statement)
+	}
+	if r.MySQLErrorNumber != 0 {
+		lines = append(lines, fmt.Sprintf("MySQLのエラー番号: %d", r.MySQLErrorNumber))
+	}
+	lines = append(lines, "エラー: "+r.Message, "対処: "+r.Hint)
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
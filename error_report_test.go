@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withMessageLanguage はテスト中だけメッセージの言語を切り替えます
+func withMessageLanguage(t *testing.T, lang string) {
+	original := messageLanguage
+	messageLanguage = lang
+	t.Cleanup(func() { messageLanguage = original })
+}
+
+// TestErrorReport_Classification は分類ごとの代表的なエラーを、ラップされた状態でも判定できることをテストします
+func TestErrorReport_Classification(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		class     ErrorClass
+		number    uint16
+		retryable bool
+	}{
+		{name: "存在しない商品", err: fmt.Errorf("取得エラー: %w", sql.ErrNoRows), class: ErrorClassNotFound},
+		{name: "在庫データなし", err: ErrNoStocks, class: ErrorClassNotFound},
+		{name: "重複", err: fmt.Errorf("データ挿入エラー: %w", newDriverError(mysqlErrDuplicateEntry, "Duplicate entry")), class: ErrorClassConflict, number: mysqlErrDuplicateEntry},
+		{name: "デッドロック", err: fmt.Errorf("データ更新エラー: %w", newDriverError(mysqlErrDeadlock, "Deadlock found")), class: ErrorClassConflict, number: mysqlErrDeadlock, retryable: true},
+		{name: "メンテナンスモード", err: fmt.Errorf("在庫更新エラー: %w", ErrMaintenanceMode), class: ErrorClassConflict, retryable: true},
+		{name: "接続断", err: fmt.Errorf("DB接続確認に失敗しました: %w", driver.ErrBadConn), class: ErrorClassConnection, retryable: true},
+		{name: "認証エラー", err: newDriverError(mysqlErrAccessDenied, "Access denied"), class: ErrorClassConnection, number: mysqlErrAccessDenied},
+		{name: "ドライバなし", err: ErrDriverNotRegistered, class: ErrorClassConnection},
+		{name: "テーブルなし", err: fmt.Errorf("クエリ実行に失敗しました: %w", classifyError(noSuchTableError())), class: ErrorClassSchema, number: mysqlErrNoSuchTable},
+		{name: "列定義のずれ", err: ErrColumnDrift, class: ErrorClassSchema},
+		{name: "商品名", err: fmt.Errorf("在庫更新エラー: %w", ErrInvalidName), class: ErrorClassValidation},
+		{name: "上限", err: &QuotaExceededError{Name: "durian", Current: 3, Limit: 3}, class: ErrorClassValidation},
+		{name: "不明なサブコマンド", err: fmt.Errorf("%w: imprt", ErrUnknownSubcommand), class: ErrorClassValidation},
+		{name: "その他", err: errors.New("unexpected"), class: ErrorClassUnknown},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			report := ErrorReport(tc.err)
+
+			assert.Equal(t, tc.class, report.Class)
+			assert.Equal(t, tc.number, report.MySQLErrorNumber)
+			assert.Equal(t, tc.retryable, report.Retryable)
+			assert.Equal(t, tc.err.Error(), report.Message)
+			assert.Equal(t, message("hint."+string(tc.class)), report.Hint)
+			assert.NotEmpty(t, report.Hint, "分類ごとの対処があるべき")
+		})
+	}
+}
+
+// TestErrorReport_QueryError はUpsertStockのエラーから操作、SQL文と操作IDを取り出し、出力を固定します
+func TestErrorReport_QueryError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 150).WillReturnError(newDriverError(mysqlErrLockWaitTimeout, "Lock wait timeout exceeded"))
+	mock.ExpectRollback()
+
+	ctx := WithOperationID(context.Background(), "import-42")
+	err := UpsertStockContext(ctx, db, "apple", 50)
+	verifyExpectations(t, mock)
+
+	report := ErrorReport(fmt.Errorf("処理に失敗しました: %w", err))
+	assert.Equal(t, Report{
+		Class:            ErrorClassConflict,
+		Message:          "処理に失敗しました: " + err.Error(),
+		Operation:        "UpsertStock",
+		Statement:        stmtUpdateAmount.SQL,
+		MySQLErrorNumber: mysqlErrLockWaitTimeout,
+		Retryable:        true,
+		OperationID:      "import-42",
+		Hint:             message("hint.conflict"),
+	}, report)
+
+	var buf bytes.Buffer
+	assert.NoError(t, report.Write(&buf))
+	assert.Equal(t, "分類: conflict (再試行できます)\n"+
+		"操作: UpsertStock\n"+
+		"操作ID: import-42\n"+
+		"SQL: UPDATE stocks SET amount = ? WHERE name = ?;\n"+
+		"MySQLのエラー番号: 1205\n"+
+		"エラー: 処理に失敗しました: "+err.Error()+"\n"+
+		"対処: 他の処理と競合しました。しばらく待ってから再実行してください。メンテナンスモード中の場合は終了後に再実行してください。\n",
+		buf.String())
+
+	assert.Equal(t, Report{Class: ErrorClassConflict, Retryable: true, OperationID: "import-42", Hint: report.Hint}, report.Public(),
+		"外部に返すレポートにはメッセージやSQL文を含めないべき")
+}
+
+// TestErrorReport_Localized は対処のメッセージがmessageLanguageの言語になることをテストします
+func TestErrorReport_Localized(t *testing.T) {
+	withMessageLanguage(t, "en")
+	assert.Equal(t, "The requested product or record does not exist. Check the product name or ID.", ErrorReport(sql.ErrNoRows).Hint)
+
+	withMessageLanguage(t, "fr")
+	assert.Equal(t, messageCatalog["ja"]["hint.not-found"], ErrorReport(sql.ErrNoRows).Hint, "未対応の言語ではjaを使うべき")
+	assert.Equal(t, "no.such.key", message("no.such.key"))
+	assert.Equal(t, Report{}, ErrorReport(nil))
+}
+
+// TestMessageCatalog_Complete はすべての言語に同じキーがあることをテストします
+func TestMessageCatalog_Complete(t *testing.T) {
+	for lang, messages := range messageCatalog {
+		for key := range messageCatalog["ja"] {
+			assert.Contains(t, messages, key, "%sに%sがあるべき", lang, key)
+		}
+		assert.Len(t, messages, len(messageCatalog["ja"]), "%sにjaにないキーがあるべきでない", lang)
+	}
+}
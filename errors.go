@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MySQLのエラー番号
+const (
+	mysqlErrNoSuchTable          = 1146
+	mysqlErrDuplicateEntry       = 1062
+	mysqlErrLockWaitTimeout      = 1205
+	mysqlErrDeadlock             = 1213
+	mysqlErrTooManyConnections   = 1040
+	mysqlErrAccessDenied         = 1045
+	mysqlErrDatabaseAccessDenied = 1044
+)
+
+var (
+	// ErrSchemaMissing はstocksテーブルが存在しない場合に返されます。
+	ErrSchemaMissing = errors.New("stocksテーブルが存在しません")
+	// ErrNoStocks は集計対象の在庫データが1件もない場合に返されます。
+	ErrNoStocks = errors.New("在庫データが存在しません")
+	// ErrMaintenanceDisabled は破壊的なメンテナンス操作が許可されていない場合に返されます。
+	ErrMaintenanceDisabled = errors.New("破壊的なメンテナンス操作は許可されていません")
+	// ErrDriverNotRegistered はMySQLドライバをリンクせずにビルドした状態でDBに接続しようとした場合に返されます。
+	ErrDriverNotRegistered = errors.New("DBドライバが登録されていません（-tags nomysqlでビルドされています）")
+	// ErrUnsupportedColumnType はstrictScanが有効な場合に、対応していない型の列を読み取ろうとした場合に返されます。
+	ErrUnsupportedColumnType = errors.New("対応していない型の列です")
+	// ErrMaintenanceMode はメンテナンスモード中に在庫データを書き換えようとした場合に返されます。
+	ErrMaintenanceMode = errors.New("メンテナンスモード中のため書き込みは受け付けていません")
+	// ErrUnknownSubcommand は存在しないサブコマンドが指定された場合に返されます。
+	ErrUnknownSubcommand = errors.New("不明なサブコマンドです")
+)
+
+// driverErrorNumber はドライバのエラーからエラー番号を取り出します。
+// MySQLドライバをリンクするビルドではdriver_mysql.goで差し替えられます。
+var driverErrorNumber = func(err error) (uint16, bool) {
+	return 0, false
+}
+
+// classifyError はドライバのエラーを判定し、対応するエラーがあればそれでラップして返します。
+// 該当しない場合は元のエラーをそのまま返します。
+func classifyError(err error) error {
+	if number, ok := driverErrorNumber(err); ok {
+		switch number {
+		case mysqlErrNoSuchTable:
+			return fmt.Errorf("%w: %w", ErrSchemaMissing, err)
+		}
+	}
+	return err
+}
+
+// QueryError はDBへの問い合わせで発生したエラーに、呼び出した操作と実行したSQL文を添えます。
+// Errorは元のエラーのメッセージをそのまま返すため、ラップしてもメッセージは変わりません。
+// ErrorReportは操作とSQL文をこの型から取り出します。
+type QueryError struct {
+	// Operation はエラーが発生した操作（QueryStocksなど）です。
+	Operation string
+	// Statement は実行したSQL文です。値はプレースホルダのため含まれません。
+	Statement string
+	// OperationID はctxに設定されていた操作IDです（WithOperationID）。
+	OperationID string
+	Err         error
+}
+
+func (e *QueryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// newQueryError はerrをQueryErrorでラップします。errがnilの場合はnilを返します。
+func newQueryError(ctx context.Context, operation, statement string, err error) error {
+	if err == nil {
+		return nil
+	}
+	id, _ := operationIDFrom(ctx)
+	return &QueryError{Operation: operation, Statement: statement, OperationID: id, Err: err}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noSuchTableError はテーブルが存在しない場合のドライバのエラーを生成します
+func noSuchTableError() error {
+	return newDriverError(mysqlErrNoSuchTable, "Table 'test_db.stocks' doesn't exist")
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		schemaMissing bool
+	}{
+		{name: "テーブルなし", err: noSuchTableError(), schemaMissing: true},
+		{name: "ラップされたテーブルなし", err: errors.Join(errors.New("wrapped"), noSuchTableError()), schemaMissing: true},
+		{name: "その他のドライバのエラー", err: newDriverError(1062, "Duplicate entry")},
+		{name: "ドライバ以外のエラー", err: errors.New("other error")},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			classified := classifyError(tc.err)
+
+			assert.Equal(t, tc.schemaMissing, errors.Is(classified, ErrSchemaMissing), "ErrSchemaMissingの判定が期待通りであるべき")
+			assert.True(t, errors.Is(classified, tc.err), "元のエラーを保持しているべき")
+		})
+	}
+}
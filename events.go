@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// StockEventType はmainProcessが行った処理の種類です。
+type StockEventType string
+
+const (
+	// EventQueried は在庫データを検索したことを表します。
+	EventQueried StockEventType = "queried"
+	// EventInserted は新しい商品の在庫を登録したことを表します。
+	EventInserted StockEventType = "inserted"
+	// EventUpdated は既存の商品の在庫を更新したことを表します。
+	EventUpdated StockEventType = "updated"
+	// EventWarning は入力が検証で拒否されたなど、処理の結果に注意が必要なことを表します。
+	EventWarning StockEventType = "warning"
+)
+
+// StockEvent はmainProcessの処理結果を出力の解析なしで扱うための構造化されたイベントです。
+type StockEvent struct {
+	Type StockEventType
+	Name string
+	// Items はEventQueriedで取得した行です。
+	Items []map[string]interface{}
+	// Before とAfter はEventInserted・EventUpdatedでの変更前後の在庫数です。新規登録ではBeforeは0です。
+	Before int64
+	After  int64
+	// Err はEventWarningの原因となったエラーです。
+	Err error
+}
+
+// processOptions はmainProcessのオプションです。
+type processOptions struct {
+	onEvent     func(StockEvent)
+	maxDuration time.Duration
+}
+
+// ProcessOption はmainProcessの動作を変更するオプションです。
+type ProcessOption func(*processOptions)
+
+// OnEvent は処理の進行に合わせてイベントを受け取るコールバックを登録します。
+// コールバックはトランザクションの外で呼ばれるため、コールバック内でDBを参照しても構いません。
+func OnEvent(fn func(StockEvent)) ProcessOption {
+	return func(o *processOptions) {
+		o.onEvent = fn
+	}
+}
+
+// newProcessOptions はオプションを適用したprocessOptionsを返します。
+func newProcessOptions(opts []ProcessOption) processOptions {
+	var o processOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// emit は登録されたコールバックにイベントを渡します。
+func (o processOptions) emit(event StockEvent) {
+	if o.onEvent != nil {
+		o.onEvent(event)
+	}
+}
+
+// rowAmount は検索結果の行からamount列の値を取り出します。
+// ドライバによって整数または文字列で返されるため、どちらも扱います。
+func rowAmount(row map[string]interface{}) (int64, error) {
+	switch v := row["amount"].(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("amount列の値を解釈できません: %v", row["amount"])
+	}
+}
+
+// upsertEvent は更新前の検索結果をもとに、在庫の登録または更新を表すイベントを返します。
+// 更新前の在庫数を解釈できない場合は、更新自体は完了しているため警告のイベントを返します。
+func upsertEvent(name string, amount int, results []map[string]interface{}) StockEvent {
+	if len(results) == 0 {
+		return StockEvent{Type: EventInserted, Name: name, After: int64(amount)}
+	}
+	before, err := rowAmount(results[0])
+	if err != nil {
+		return StockEvent{Type: EventWarning, Name: name, Err: err}
+	}
+	return StockEvent{Type: EventUpdated, Name: name, Before: before, After: before + int64(amount)}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordEvents はmainProcessから受け取ったイベントを順に記録するコールバックを返します
+func recordEvents(events *[]StockEvent) ProcessOption {
+	return OnEvent(func(event StockEvent) {
+		*events = append(*events, event)
+	})
+}
+
+func TestMainProcess_Events(t *testing.T) {
+	tests := []struct {
+		name     string
+		stocks   map[string]int
+		product  string
+		amount   int
+		expected []StockEvent
+	}{
+		{
+			name:    "既存商品の更新",
+			stocks:  map[string]int{"apple": 100},
+			product: "apple",
+			amount:  200,
+			expected: []StockEvent{
+				{Type: EventQueried, Name: "apple", Items: []map[string]interface{}{{"name": "apple", "amount": int64(100)}}},
+				{Type: EventUpdated, Name: "apple", Before: 100, After: 300},
+			},
+		},
+		{
+			name:    "新規商品の登録",
+			stocks:  map[string]int{},
+			product: "banana",
+			amount:  50,
+			expected: []StockEvent{
+				{Type: EventQueried, Name: "banana", Items: []map[string]interface{}{}},
+				{Type: EventInserted, Name: "banana", Before: 0, After: 50},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &fakeStockRepository{stocks: tc.stocks}
+			var events []StockEvent
+
+			err := mainProcess(context.Background(), io.Discard, repo, tc.product, tc.amount, recordEvents(&events))
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, events, "イベントが処理の順に通知されるべき")
+		})
+	}
+}
+
+// TestMainProcess_EventsOnRejection は検証で拒否された場合に警告のイベントが通知されることをテストします
+func TestMainProcess_EventsOnRejection(t *testing.T) {
+	rejected := &NameRejectedError{Name: "apple", Rule: "deny ^apple$"}
+	repo := &fakeStockRepository{stocks: map[string]int{}, upsertErr: rejected}
+	var events []StockEvent
+
+	err := mainProcess(context.Background(), io.Discard, repo, "apple", 200, recordEvents(&events))
+
+	assert.True(t, errors.Is(err, ErrNameRejected))
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, EventQueried, events[0].Type)
+		assert.Equal(t, StockEvent{Type: EventWarning, Name: "apple", Err: rejected}, events[1])
+	}
+}
+
+// TestMainProcess_EventsOutsideTransaction はコールバックがトランザクションのコミット後に呼ばれ、DBを参照できることをテストします
+func TestMainProcess_EventsOutsideTransaction(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectPing()
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("banana").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}))
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("banana").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+		WithArgs("banana", 50).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	// コールバック内からの再検索はコミットの後に行われる
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("banana").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "banana", 50, defaultCategory))
+
+	repo := NewSQLStockRepository(db)
+	var reread []Stock
+	onEvent := OnEvent(func(event StockEvent) {
+		if event.Type != EventInserted {
+			return
+		}
+		stocks, err := QueryStocksTyped(db, event.Name)
+		assert.NoError(t, err, "コールバック内でDBを参照できるべき")
+		reread = stocks
+	})
+
+	err := mainProcess(context.Background(), io.Discard, repo, "banana", 50, onEvent)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Stock{{ID: 1, Name: "banana", Amount: 50, Category: defaultCategory}}, reread)
+	verifyExpectations(t, mock)
+}
+
+func TestUpsertEvent_StringAmount(t *testing.T) {
+	results := []map[string]interface{}{{"name": "apple", "amount": "100"}}
+
+	assert.Equal(t, StockEvent{Type: EventUpdated, Name: "apple", Before: 100, After: 300}, upsertEvent("apple", 200, results))
+
+	event := upsertEvent("apple", 200, []map[string]interface{}{{"name": "apple"}})
+	assert.Equal(t, EventWarning, event.Type, "在庫数を解釈できない場合は警告になるべき")
+	assert.Error(t, event.Err)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrFullTableScan は実行計画にテーブルの全件走査（type=ALL）が含まれる場合に返されます。
+var ErrFullTableScan = errors.New("テーブルを全件走査する実行計画です")
+
+// ExplainAnalyze はqueryをEXPLAIN ANALYZEで実行し、実行計画のツリーと実際の処理時間を文字列で返します。
+// EXPLAIN ANALYZEはMySQL 8.0.18以降でのみ使えます。それより前のバージョンでは構文エラーになります。
+// EXPLAIN ANALYZEは計画を表示するだけでなくqueryを実際に実行するため、UPDATEやDELETEを渡すと変更が適用されます。
+func ExplainAnalyze(db *sql.DB, query string, args ...interface{}) (string, error) {
+	return ExplainAnalyzeContext(context.Background(), db, query, args...)
+}
+
+// ExplainAnalyzeContext はExplainAnalyzeのcontext対応版です。
+func ExplainAnalyzeContext(ctx context.Context, q Queryer, query string, args ...interface{}) (string, error) {
+	explain := "EXPLAIN ANALYZE " + strings.TrimSuffix(strings.TrimSpace(query), ";")
+	rows, err := q.QueryContext(ctx, explain, args...)
+	if err != nil {
+		return "", fmt.Errorf("EXPLAIN ANALYZEの実行エラー: %w", err)
+	}
+	defer rows.Close()
+
+	// 結果は通常1行だが、複数行の場合は改行でつなげる
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", fmt.Errorf("EXPLAIN ANALYZEの結果の読み取りエラー: %w", err)
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("EXPLAIN ANALYZEの結果の読み取りエラー: %w", err)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// AssertUsesIndex はqueryをEXPLAINで調べ、アクセス方法（type列）がALL、つまりテーブルの全件走査の行が1つでもあれば
+// ErrFullTableScanを返します。開発時やテストで索引の付け忘れを早く見つけるためのもので、queryは実行しません。
+// 索引全体の走査（type=index）は全件走査として扱いません。
+func AssertUsesIndex(db *sql.DB, query string, args ...interface{}) error {
+	return AssertUsesIndexContext(context.Background(), db, query, args...)
+}
+
+// AssertUsesIndexContext はAssertUsesIndexのcontext対応版です。
+func AssertUsesIndexContext(ctx context.Context, q Queryer, query string, args ...interface{}) error {
+	explain := "EXPLAIN " + strings.TrimSuffix(strings.TrimSpace(query), ";")
+	rows, err := q.QueryContext(ctx, explain, args...)
+	if err != nil {
+		return fmt.Errorf("EXPLAINの実行エラー: %w", err)
+	}
+	defer rows.Close()
+
+	plan, err := scanRowMaps(rows)
+	if err != nil {
+		return fmt.Errorf("EXPLAINの結果の読み取りエラー: %w", err)
+	}
+
+	var scans []string
+	for _, row := range plan {
+		if accessType, _ := row["type"].(string); strings.EqualFold(accessType, "ALL") {
+			scans = append(scans, fmt.Sprintf("%v (推定 %v 行)", row["table"], row["rows"]))
+		}
+	}
+	if len(scans) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrFullTableScan, strings.Join(scans, ", "), query)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAnalyzeOutput はEXPLAIN ANALYZEが返すツリーの例です
+const fakeAnalyzeOutput = `-> Filter: (stocks.name = 'apple')  (cost=0.35 rows=1) (actual time=0.031..0.034 rows=1 loops=1)
+    -> Table scan on stocks  (cost=0.35 rows=3) (actual time=0.028..0.031 rows=3 loops=1)`
+
+// TestExplainAnalyze はクエリの前にEXPLAIN ANALYZEを付けて実行し、ツリーを文字列で返すことをテストします
+func TestExplainAnalyze(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN ANALYZE SELECT id, name, amount FROM stocks WHERE name = ?")).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"EXPLAIN"}).AddRow(fakeAnalyzeOutput))
+
+	out, err := ExplainAnalyze(db, "SELECT id, name, amount FROM stocks WHERE name = ?;", "apple")
+
+	assert.NoError(t, err)
+	assert.Equal(t, fakeAnalyzeOutput, out)
+	verifyExpectations(t, mock)
+}
+
+// TestExplainAnalyze_Unsupported はEXPLAIN ANALYZEに対応していないバージョンのエラーを返すことをテストします
+func TestExplainAnalyze_Unsupported(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`EXPLAIN ANALYZE SELECT 1`).WillReturnError(errors.New("You have an error in your SQL syntax"))
+
+	_, err := ExplainAnalyze(db, "SELECT 1")
+
+	assert.ErrorContains(t, err, "EXPLAIN ANALYZEの実行エラー")
+	verifyExpectations(t, mock)
+}
+
+// explainColumns はEXPLAINが返す列です
+var explainColumns = []string{"id", "select_type", "table", "partitions", "type", "possible_keys", "key", "key_len", "ref", "rows", "filtered", "Extra"}
+
+// TestAssertUsesIndex は索引を使う実行計画ではnilを、全件走査を含む実行計画ではErrFullTableScanを返すことをテストします
+func TestAssertUsesIndex(t *testing.T) {
+	t.Run("索引による検索", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT amount FROM stocks WHERE name = ?")).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows(explainColumns).
+				AddRow(1, "SIMPLE", "stocks", nil, "const", "name", "name", "1022", "const", 1, 100.0, nil))
+
+		assert.NoError(t, AssertUsesIndex(db, "SELECT amount FROM stocks WHERE name = ?;", "apple"))
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("全件走査", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN SELECT name FROM stocks WHERE amount < ?")).
+			WithArgs(10).
+			WillReturnRows(sqlmock.NewRows(explainColumns).
+				AddRow(1, "SIMPLE", "stocks", nil, "ALL", nil, nil, nil, nil, 1200, 33.33, "Using where"))
+
+		err := AssertUsesIndex(db, "SELECT name FROM stocks WHERE amount < ?", 10)
+
+		assert.ErrorIs(t, err, ErrFullTableScan)
+		assert.ErrorContains(t, err, "stocks (推定 1200 行)")
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("結合の一部だけが全件走査", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectQuery(`EXPLAIN SELECT`).
+			WillReturnRows(sqlmock.NewRows(explainColumns).
+				AddRow(1, "SIMPLE", "l", nil, "ALL", nil, nil, nil, nil, 5000, 100.0, nil).
+				AddRow(1, "SIMPLE", "s", nil, "eq_ref", "name", "name", "1022", "l.name", 1, 100.0, nil))
+
+		err := AssertUsesIndex(db, "SELECT s.amount FROM stock_log l JOIN stocks s ON s.name = l.name")
+
+		assert.ErrorIs(t, err, ErrFullTableScan)
+		assert.ErrorContains(t, err, "l (推定 5000 行)")
+		assert.NotContains(t, err.Error(), "s (推定")
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("EXPLAINのエラー", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		mock.ExpectQuery(`EXPLAIN SELECT 1`).WillReturnError(errors.New("syntax error"))
+
+		err := AssertUsesIndex(db, "SELECT 1")
+
+		assert.ErrorContains(t, err, "EXPLAINの実行エラー")
+		assert.NotErrorIs(t, err, ErrFullTableScan)
+	})
+}
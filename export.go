@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrUnknownColumn は存在しない列が指定された場合に返されます。
+var ErrUnknownColumn = errors.New("不明な列です")
+
+// extendedStockColumns はマイグレーションで追加される場合がある列です。
+// 実際のテーブルに存在する場合だけ指定できます。
+var extendedStockColumns = []string{"created_at", "updated_at", "price"}
+
+// ColumnSet は出力する列とその順序です。エクスポートと表形式の出力で共通に使います。
+// 列の順序はスキーマではなく、指定された順序に従います。
+type ColumnSet struct {
+	names []string
+}
+
+// ResolveColumnSet はrequestedをavailableの列と照合してColumnSetを返します。
+// requestedが空の場合はstockColumnsの列を使います。availableにない列が含まれる場合はErrUnknownColumnを返します。
+func ResolveColumnSet(requested, available []string) (ColumnSet, error) {
+	if len(requested) == 0 {
+		return ColumnSet{names: stockColumns()}, nil
+	}
+
+	names := make([]string, 0, len(requested))
+	for _, col := range requested {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if !slices.Contains(available, col) {
+			return ColumnSet{}, fmt.Errorf("%w: %s (指定できる列: %s)", ErrUnknownColumn, col, strings.Join(available, ", "))
+		}
+		names = append(names, col)
+	}
+	return ColumnSet{names: names}, nil
+}
+
+// Names は列名を出力する順に返します。
+func (c ColumnSet) Names() []string {
+	return c.names
+}
+
+// selectList はSELECT句に使う列のリストを返します。列名はResolveColumnSetで照合済みです。
+func (c ColumnSet) selectList() string {
+	return strings.Join(c.names, ", ")
+}
+
+// Values は行の値を列の順に返します。日時はtimeLocationのタイムゾーンで書式化します。
+func (c ColumnSet) Values(row map[string]interface{}) []interface{} {
+	values := make([]interface{}, len(c.names))
+	for i, col := range c.names {
+		if t, ok := row[col].(time.Time); ok {
+			values[i] = t.In(timeLocation).Format(reportDateTimeLayout)
+		} else {
+			values[i] = row[col]
+		}
+	}
+	return values
+}
+
+// availableStockColumns はエクスポートで指定できる列を返します。
+// stockColumnsの列に加え、extendedStockColumnsのうち実際のテーブルに存在する列を含みます。
+func availableStockColumns(ctx context.Context, db *sql.DB) ([]string, error) {
+	live, err := liveStockColumns(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("列定義の確認エラー: %w", err)
+	}
+	available := stockColumns()
+	for _, col := range extendedStockColumns {
+		if live[col] && !slices.Contains(available, col) {
+			available = append(available, col)
+		}
+	}
+	return available, nil
+}
+
+// ExportStocks はcolumnsで指定した列の在庫データを名前順でformatの形式でwに書き出します。
+// columnsが空の場合はstockColumnsの列を書き出します。
+func ExportStocks(ctx context.Context, w io.Writer, db *sql.DB, columns []string, format string) error {
+	serializer, err := SerializerFor(format)
+	if err != nil {
+		return err
+	}
+	available, err := availableStockColumns(ctx, db)
+	if err != nil {
+		return err
+	}
+	cs, err := ResolveColumnSet(columns, available)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT " + cs.selectList() + " FROM stocks ORDER BY name;"
+	obs := observeQuery(query)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		obs.done(0, err)
+		return fmt.Errorf("在庫データの取得エラー: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	results, err := scanRowMaps(rows)
+	obs.done(len(results), err)
+	if err != nil {
+		return fmt.Errorf("在庫データの取得エラー: %w", err)
+	}
+
+	values := make([][]interface{}, len(results))
+	for i, row := range results {
+		values[i] = cs.Values(row)
+	}
+	return serializer.Serialize(w, cs.Names(), values)
+}
+
+// runExportCommand はexportサブコマンドを実行します。
+// 使い方: export [--columns name,amount] [--format csv|json|table]
+func runExportCommand(ctx context.Context, w io.Writer, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(w)
+	columns := fs.String("columns", "", "出力する列をカンマ区切りで指定（省略時は既定の列）")
+	format := fs.String("format", formatCSV, "出力形式（csv、json または table）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var requested []string
+	if *columns != "" {
+		requested = strings.Split(*columns, ",")
+	}
+	return ExportStocks(ctx, w, db, requested, *format)
+}
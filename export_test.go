@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectLiveColumns はinformation_schemaから返すstocksテーブルの列のモックを設定します
+func expectLiveColumns(mock sqlmock.Sqlmock, columns ...string) {
+	rows := sqlmock.NewRows([]string{"COLUMN_NAME"})
+	for _, col := range columns {
+		rows.AddRow(col)
+	}
+	mock.ExpectQuery(`SELECT COLUMN_NAME FROM information_schema.COLUMNS`).WillReturnRows(rows)
+}
+
+func TestResolveColumnSet(t *testing.T) {
+	available := []string{"id", "name", "amount", "category", "created_at"}
+
+	t.Run("既定の列", func(t *testing.T) {
+		cs, err := ResolveColumnSet(nil, available)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"id", "name", "amount", "category"}, cs.Names())
+	})
+
+	t.Run("指定した順序", func(t *testing.T) {
+		cs, err := ResolveColumnSet([]string{"amount", " Name", "created_at"}, available)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"amount", "name", "created_at"}, cs.Names(), "スキーマではなく指定した順序に従うべき")
+	})
+
+	t.Run("不明な列", func(t *testing.T) {
+		_, err := ResolveColumnSet([]string{"name", "price"}, available)
+		assert.True(t, errors.Is(err, ErrUnknownColumn))
+		assert.Contains(t, err.Error(), "price")
+		assert.Contains(t, err.Error(), "id, name, amount, category, created_at", "指定できる列を含むべき")
+	})
+}
+
+// TestExportStocks_Default は列を指定しない場合に既定の列をCSVで書き出すことをテストします
+func TestExportStocks_Default(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLiveColumns(mock, "id", "name", "amount", "category")
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks ORDER BY name;`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+			AddRow(1, "apple", 100, "fruit").
+			AddRow(2, "banana", 5, defaultCategory))
+
+	var buf bytes.Buffer
+	err := ExportStocks(context.Background(), &buf, db, nil, formatCSV)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "id,name,amount,category\n1,apple,100,fruit\n2,banana,5,uncategorized\n", buf.String())
+	verifyExpectations(t, mock)
+}
+
+// TestExportStocks_CustomOrder は指定した列を指定した順序で書き出すことをテストします
+func TestExportStocks_CustomOrder(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLiveColumns(mock, "id", "name", "amount", "category")
+	mock.ExpectQuery(`SELECT amount, name FROM stocks ORDER BY name;`).
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "name"}).AddRow(100, "apple"))
+
+	var buf bytes.Buffer
+	err := runExportCommand(context.Background(), &buf, db, []string{"--columns", "amount,name", "--format", "json"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n  {\"amount\": 100, \"name\": \"apple\"}\n]\n", buf.String(), "JSONのキーも指定した順序に従うべき")
+	verifyExpectations(t, mock)
+}
+
+// TestExportStocks_UnknownColumn はテーブルに存在しない拡張列を指定した場合にクエリを発行せずエラーにすることをテストします
+func TestExportStocks_UnknownColumn(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// created_atは存在するが、priceを追加するマイグレーションは適用されていない
+	expectLiveColumns(mock, "id", "name", "amount", "category", "created_at")
+
+	var buf bytes.Buffer
+	err := ExportStocks(context.Background(), &buf, db, []string{"name", "price"}, formatCSV)
+
+	assert.True(t, errors.Is(err, ErrUnknownColumn))
+	assert.Contains(t, err.Error(), "指定できる列: id, name, amount, category, created_at")
+	assert.Empty(t, buf.String())
+	verifyExpectations(t, mock)
+}
+
+// TestExportStocks_UnknownFormat は対応していない出力形式の場合にDBへ問い合わせずにエラーにすることをテストします
+func TestExportStocks_UnknownFormat(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	err := ExportStocks(context.Background(), &buf, db, nil, "xml")
+
+	assert.True(t, errors.Is(err, ErrUnknownFormat))
+	assert.Empty(t, buf.String())
+	verifyExpectations(t, mock)
+}
+
+// TestExportStocks_CreatedAt はcreated_atをtimeLocationのタイムゾーンで書き出すことをテストします
+func TestExportStocks_CreatedAt(t *testing.T) {
+	withTimeLocation(t, time.FixedZone("JST", 9*60*60))
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	createdAt := time.Date(2025, 3, 1, 15, 30, 0, 0, time.UTC)
+	expectLiveColumns(mock, "id", "name", "amount", "category", "created_at")
+	mock.ExpectQuery(`SELECT name, created_at FROM stocks ORDER BY name;`).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "created_at"}).
+			AddRow("apple", createdAt).
+			AddRow("banana", nil))
+
+	var buf bytes.Buffer
+	err := ExportStocks(context.Background(), &buf, db, []string{"name", "created_at"}, formatTable)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "name    created_at\napple   2025-03-02 00:30:00\nbanana  \n", buf.String())
+	verifyExpectations(t, mock)
+}
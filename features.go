@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFeatures は任意機能の設定の組み合わせが正しくない場合に返されます。
+var ErrInvalidFeatures = errors.New("機能の設定が正しくありません")
+
+// featuresFileEnv は任意機能の設定ファイルのパスを指定する環境変数です。
+const featuresFileEnv = "DB_MOCK_FEATURES_FILE"
+
+// nPlusOneMaxShapes はN+1検出器が同時に追跡するクエリの形の数です。
+const nPlusOneMaxShapes = 1000
+
+// Features は処理のオーバーヘッドを伴う任意機能の有効/無効と、その調整値です。
+// 既定値はconfig.goのfeaturesで、設定ファイル、環境変数、コマンドラインの順に上書きされます。
+type Features struct {
+	// Cache が有効な場合、QueryStocksの結果をCacheTTLの間キャッシュします
+	Cache    bool
+	CacheTTL time.Duration
+	// Batching が有効な場合、単発のUpsertStockを最大BatchWindow待つかBatchMaxSize件に達するまで溜めて一括で適用します
+	Batching     bool
+	BatchWindow  time.Duration
+	BatchMaxSize int
+	// RateLimit が0より大きい場合、DB操作を1秒あたりRateLimit回（連続してRateBurst回）までに制限します
+	RateLimit float64
+	RateBurst int
+	// SlowQueryLog が有効な場合、SlowQueryThreshold以上かかった操作をログに出力します
+	SlowQueryLog       bool
+	SlowQueryThreshold time.Duration
+	// NPlusOne が有効な場合、NPlusOneWindow内にNPlusOneThreshold回繰り返された1行取得クエリを検出します
+	NPlusOne          bool
+	NPlusOneWindow    time.Duration
+	NPlusOneThreshold int
+}
+
+// featureSetting は1つの設定項目の環境変数名、フラグ名と、値を書き込む先です。
+type featureSetting struct {
+	env    string
+	flag   string
+	usage  string
+	target interface{}
+}
+
+// settings はfの各項目に対応するfeatureSettingを返します。
+// 設定ファイル、環境変数、コマンドラインのいずれもこの一覧から解釈します。
+func (f *Features) settings() []featureSetting {
+	return []featureSetting{
+		{"DB_MOCK_CACHE", "cache", "検索結果をキャッシュする", &f.Cache},
+		{"DB_MOCK_CACHE_TTL", "cache-ttl", "検索結果をキャッシュする時間", &f.CacheTTL},
+		{"DB_MOCK_BATCHING", "batching", "単発の更新をまとめて適用する", &f.Batching},
+		{"DB_MOCK_BATCH_WINDOW", "batch-window", "最初の更新から適用するまでに待つ時間", &f.BatchWindow},
+		{"DB_MOCK_BATCH_MAX_SIZE", "batch-max-size", "この件数に達した時点で待たずに適用する", &f.BatchMaxSize},
+		{"DB_MOCK_RATE_LIMIT", "rate-limit", "1秒あたりに許可するDB操作の数（0の場合は制限しない）", &f.RateLimit},
+		{"DB_MOCK_RATE_BURST", "rate-burst", "連続して許可するDB操作の数の上限", &f.RateBurst},
+		{"DB_MOCK_SLOW_QUERY_LOG", "slow-query-log", "時間のかかった操作をログに出力する", &f.SlowQueryLog},
+		{"DB_MOCK_SLOW_QUERY_THRESHOLD", "slow-query-threshold", "ログに出力する操作の所要時間の下限", &f.SlowQueryThreshold},
+		{"DB_MOCK_NPLUSONE", "nplusone", "1行取得クエリの繰り返し（N+1）を検出する", &f.NPlusOne},
+		{"DB_MOCK_NPLUSONE_WINDOW", "nplusone-window", "N+1として数える期間", &f.NPlusOneWindow},
+		{"DB_MOCK_NPLUSONE_THRESHOLD", "nplusone-threshold", "N+1として報告する繰り返しの回数", &f.NPlusOneThreshold},
+	}
+}
+
+// set は設定項目targetにvalueを解釈して書き込みます。
+func (s featureSetting) set(value string) error {
+	var err error
+	value = strings.TrimSpace(value)
+	switch target := s.target.(type) {
+	case *bool:
+		*target, err = strconv.ParseBool(value)
+	case *int:
+		*target, err = strconv.Atoi(value)
+	case *float64:
+		*target, err = strconv.ParseFloat(value, 64)
+	case *time.Duration:
+		*target, err = time.ParseDuration(value)
+	default:
+		panic(fmt.Sprintf("対応していない設定項目の型です: %T", s.target))
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s=%q: %w", ErrInvalidFeatures, s.env, value, err)
+	}
+	return nil
+}
+
+// LoadEnv はlookupで取得した環境変数の値でfを上書きします。設定されていない項目は変更しません。
+func (f *Features) LoadEnv(lookup func(key string) (string, bool)) error {
+	for _, s := range f.settings() {
+		value, ok := lookup(s.env)
+		if !ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFile は"環境変数名=値"形式の設定ファイルの値でfを上書きします。
+// 空行と"#"で始まる行は読み飛ばします。
+func (f *Features) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("機能の設定ファイルの読み込みエラー: %w", err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%w: %s:%d: \"名前=値\"の形式ではありません", ErrInvalidFeatures, path, line)
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("機能の設定ファイルの読み込みエラー: %w", err)
+	}
+
+	for key := range values {
+		if !f.knownSetting(key) {
+			return fmt.Errorf("%w: %s: 不明な設定項目です: %s", ErrInvalidFeatures, path, key)
+		}
+	}
+	return f.LoadEnv(func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	})
+}
+
+// knownSetting はenvが設定項目の環境変数名であればtrueを返します。
+func (f *Features) knownSetting(env string) bool {
+	for _, s := range f.settings() {
+		if s.env == env {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterFlags はfの各項目をfsのフラグとして登録します。既定値は登録時点のfの値です。
+func (f *Features) RegisterFlags(fs *flag.FlagSet) {
+	for _, s := range f.settings() {
+		switch target := s.target.(type) {
+		case *bool:
+			fs.BoolVar(target, s.flag, *target, s.usage)
+		case *int:
+			fs.IntVar(target, s.flag, *target, s.usage)
+		case *float64:
+			fs.Float64Var(target, s.flag, *target, s.usage)
+		case *time.Duration:
+			fs.DurationVar(target, s.flag, *target, s.usage)
+		}
+	}
+}
+
+// Validate は有効にした機能に必要な設定がそろっていることを確認します。
+func (f Features) Validate() error {
+	var errs []error
+	if f.Cache && f.CacheTTL <= 0 {
+		errs = append(errs, errors.New("キャッシュにはCacheTTLの指定が必要です"))
+	}
+	if f.Batching && f.BatchWindow <= 0 {
+		errs = append(errs, errors.New("まとめて適用するにはBatchWindowの指定が必要です"))
+	}
+	if f.RateLimit < 0 {
+		errs = append(errs, errors.New("RateLimitに負の値は指定できません"))
+	}
+	if f.SlowQueryLog && f.SlowQueryThreshold <= 0 {
+		errs = append(errs, errors.New("遅い操作のログにはSlowQueryThresholdの指定が必要です"))
+	}
+	if f.NPlusOne && (f.NPlusOneWindow <= 0 || f.NPlusOneThreshold < 2) {
+		errs = append(errs, errors.New("N+1の検出にはNPlusOneWindowと2以上のNPlusOneThresholdの指定が必要です"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrInvalidFeatures, errors.Join(errs...))
+	}
+	return nil
+}
+
+// assembleRepository はcfg.Featuresで有効になっている機能のデコレータをbaseに重ねたStockRepositoryを返します。
+// 外側から順に次のように重ねます。
+//
+//	SlowQueryLog → Cache → RateLimit → Batching → base
+//
+// 遅い操作のログは呼び出し元から見た待ち時間をすべて含めるため最も外側に置きます。
+// キャッシュに当たった検索はDBに問い合わせないため、レート制限のトークンを消費しないようその外側に置きます。
+// まとめた更新もDBへの問い合わせは1回ですが、BatchingRepositoryはBulkUpsertStocksを持つbaseを必要とするため最も内側に置きます。
+// 返す関数は溜まっている更新を適用してバッチ処理を終了するもので、終了時に必ず呼び出します。
+func assembleRepository(cfg DBConfig, base StockRepository) (StockRepository, func() error, error) {
+	f := cfg.Features
+	if err := f.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	repo := base
+	closeRepo := func() error { return nil }
+	if f.Batching {
+		bulk, ok := base.(BulkStockRepository)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %Tは一括更新に対応していないためまとめて適用できません", ErrInvalidFeatures, base)
+		}
+		batching := NewBatchingRepository(bulk, f.BatchWindow, f.BatchMaxSize)
+		repo, closeRepo = batching, batching.Close
+	}
+	if limiter := NewRateLimiter(f.RateLimit, f.RateBurst); limiter != nil {
+		repo = NewRateLimitedRepository(repo, limiter)
+	}
+	if f.Cache {
+		repo = NewMemoizedQuery(repo, f.CacheTTL)
+	}
+	if f.SlowQueryLog {
+		repo = NewSlowQueryLogRepository(repo, f.SlowQueryThreshold)
+	}
+	return repo, closeRepo, nil
+}
+
+// newFeatureNPlusOneDetector はfでN+1の検出が有効な場合に検出器を返します。無効な場合はnilを返します。
+func newFeatureNPlusOneDetector(f Features) *NPlusOneDetector {
+	if !f.NPlusOne {
+		return nil
+	}
+	return NewNPlusOneDetector(f.NPlusOneWindow, f.NPlusOneThreshold, nPlusOneMaxShapes, nil)
+}
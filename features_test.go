@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decoratorChain はrepoから委譲先をたどり、外側から順にデコレータの型名を返します
+func decoratorChain(repo StockRepository) []string {
+	var chain []string
+	for repo != nil {
+		chain = append(chain, fmt.Sprintf("%T", repo))
+		switch r := repo.(type) {
+		case *SlowQueryLogRepository:
+			repo = r.repo
+		case *MemoizedQuery:
+			repo = r.repo
+		case *RateLimitedRepository:
+			repo = r.repo
+		case *BatchingRepository:
+			repo = r.repo
+		default:
+			repo = nil
+		}
+	}
+	return chain
+}
+
+// TestAssembleRepository は有効にした機能のデコレータが決められた順に重なることをテストします
+func TestAssembleRepository(t *testing.T) {
+	all := Features{
+		Cache: true, CacheTTL: time.Second,
+		Batching: true, BatchWindow: time.Millisecond, BatchMaxSize: 10,
+		RateLimit: 100, RateBurst: 1,
+		SlowQueryLog: true, SlowQueryThreshold: time.Second,
+	}
+	tests := []struct {
+		name     string
+		features Features
+		chain    []string
+	}{
+		{
+			name:  "すべて無効",
+			chain: []string{"*main.fakeBulkRepository"},
+		},
+		{
+			name:     "すべて有効",
+			features: all,
+			chain: []string{
+				"*main.SlowQueryLogRepository",
+				"*main.MemoizedQuery",
+				"*main.RateLimitedRepository",
+				"*main.BatchingRepository",
+				"*main.fakeBulkRepository",
+			},
+		},
+		{
+			name:     "キャッシュのみ",
+			features: Features{Cache: true, CacheTTL: time.Second},
+			chain:    []string{"*main.MemoizedQuery", "*main.fakeBulkRepository"},
+		},
+		{
+			name:     "遅い操作のログとまとめての適用",
+			features: Features{Batching: true, BatchWindow: time.Millisecond, SlowQueryLog: true, SlowQueryThreshold: time.Second},
+			chain:    []string{"*main.SlowQueryLogRepository", "*main.BatchingRepository", "*main.fakeBulkRepository"},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			repo, closeRepo, err := assembleRepository(DBConfig{Features: tc.features}, newFakeBulkRepository())
+			require.NoError(t, err)
+			defer closeRepo()
+
+			assert.Equal(t, tc.chain, decoratorChain(repo), "デコレータの順序が期待通りであるべき")
+		})
+	}
+}
+
+// TestAssembleRepository_Delegates は重ねたデコレータを通して更新と検索が委譲されることをテストします
+func TestAssembleRepository_Delegates(t *testing.T) {
+	base := newFakeBulkRepository()
+	repo, closeRepo, err := assembleRepository(DBConfig{Features: Features{
+		Cache: true, CacheTTL: time.Minute,
+		Batching: true, BatchWindow: time.Millisecond, BatchMaxSize: 1,
+		SlowQueryLog: true, SlowQueryThreshold: time.Minute,
+	}}, base)
+	require.NoError(t, err)
+	defer closeRepo()
+
+	ctx := context.Background()
+	require.NoError(t, repo.UpsertStock(ctx, "apple", 5))
+
+	rows, err := repo.QueryStocks(ctx, "apple")
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, int64(5), rows[0]["amount"], "委譲先に適用された在庫数が返るべき")
+}
+
+// TestAssembleRepository_Invalid は正しくない設定の組み合わせを拒否することをテストします
+func TestAssembleRepository_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		features Features
+		base     StockRepository
+	}{
+		{name: "待ち時間のないまとめての適用", features: Features{Batching: true, BatchMaxSize: 10}, base: newFakeBulkRepository()},
+		{name: "有効期限のないキャッシュ", features: Features{Cache: true}, base: newFakeBulkRepository()},
+		{name: "下限のない遅い操作のログ", features: Features{SlowQueryLog: true}, base: newFakeBulkRepository()},
+		{name: "負のレート制限", features: Features{RateLimit: -1}, base: newFakeBulkRepository()},
+		{name: "閾値が1のN+1検出", features: Features{NPlusOne: true, NPlusOneWindow: time.Second, NPlusOneThreshold: 1}, base: newFakeBulkRepository()},
+		{
+			name:     "一括更新に対応していない委譲先でのまとめての適用",
+			features: Features{Batching: true, BatchWindow: time.Millisecond},
+			base:     &fakeStockRepository{stocks: map[string]int{}},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			repo, _, err := assembleRepository(DBConfig{Features: tc.features}, tc.base)
+
+			assert.True(t, errors.Is(err, ErrInvalidFeatures), "ErrInvalidFeaturesが返るべき: %v", err)
+			assert.Nil(t, repo)
+		})
+	}
+}
+
+// TestFeatures_LoadEnv は環境変数の値で設定を上書きできることをテストします
+func TestFeatures_LoadEnv(t *testing.T) {
+	env := map[string]string{
+		"DB_MOCK_CACHE":        "true",
+		"DB_MOCK_CACHE_TTL":    "5s",
+		"DB_MOCK_BATCHING":     "1",
+		"DB_MOCK_BATCH_WINDOW": "20ms",
+		"DB_MOCK_RATE_LIMIT":   "2.5",
+	}
+	f := Features{BatchMaxSize: 100, RateBurst: 1}
+
+	err := f.LoadEnv(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, Features{
+		Cache: true, CacheTTL: 5 * time.Second,
+		Batching: true, BatchWindow: 20 * time.Millisecond, BatchMaxSize: 100,
+		RateLimit: 2.5, RateBurst: 1,
+	}, f, "設定された項目だけが上書きされるべき")
+}
+
+// TestFeatures_LoadEnv_Invalid は解釈できない値を拒否することをテストします
+func TestFeatures_LoadEnv_Invalid(t *testing.T) {
+	var f Features
+
+	err := f.LoadEnv(func(key string) (string, bool) {
+		if key == "DB_MOCK_BATCH_WINDOW" {
+			return "soon", true
+		}
+		return "", false
+	})
+
+	assert.True(t, errors.Is(err, ErrInvalidFeatures), "ErrInvalidFeaturesが返るべき")
+	assert.Contains(t, err.Error(), "DB_MOCK_BATCH_WINDOW", "どの項目が正しくないか分かるべき")
+}
+
+// TestFeatures_LoadFile は設定ファイルの値で設定を上書きできることをテストします
+func TestFeatures_LoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "features.env")
+	content := "# 任意機能\nDB_MOCK_SLOW_QUERY_LOG=true\n\nDB_MOCK_SLOW_QUERY_THRESHOLD = 250ms\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	var f Features
+	require.NoError(t, f.LoadFile(path))
+
+	assert.True(t, f.SlowQueryLog)
+	assert.Equal(t, 250*time.Millisecond, f.SlowQueryThreshold)
+}
+
+// TestFeatures_LoadFile_UnknownKey は設定ファイルの不明な項目を拒否することをテストします
+func TestFeatures_LoadFile_UnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "features.env")
+	require.NoError(t, os.WriteFile(path, []byte("DB_MOCK_CACHE=true\nDB_MOCK_CAHCE_TTL=1s\n"), 0o600))
+
+	var f Features
+	err := f.LoadFile(path)
+
+	assert.True(t, errors.Is(err, ErrInvalidFeatures), "ErrInvalidFeaturesが返るべき")
+	assert.False(t, f.Cache, "エラーの場合は設定を変更しないべき")
+}
+
+// TestFeatures_RegisterFlags はコマンドラインの指定が環境変数の値より優先されることをテストします
+func TestFeatures_RegisterFlags(t *testing.T) {
+	f := Features{BatchMaxSize: 100}
+	require.NoError(t, f.LoadEnv(func(key string) (string, bool) {
+		if key == "DB_MOCK_BATCH_MAX_SIZE" {
+			return "50", true
+		}
+		return "", false
+	}))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.RegisterFlags(fs)
+	require.NoError(t, fs.Parse([]string{"--batching", "--batch-window", "10ms"}))
+
+	assert.True(t, f.Batching)
+	assert.Equal(t, 10*time.Millisecond, f.BatchWindow)
+	assert.Equal(t, 50, f.BatchMaxSize, "指定しなかったフラグは環境変数の値のままであるべき")
+}
+
+// TestFeatures_Defaults は既定の設定が正しく、任意機能がすべて無効であることをテストします
+func TestFeatures_Defaults(t *testing.T) {
+	require.NoError(t, features.Validate())
+
+	repo, closeRepo, err := assembleRepository(currentDBConfig(), newFakeBulkRepository())
+	require.NoError(t, err)
+	defer closeRepo()
+
+	assert.Equal(t, []string{"*main.fakeBulkRepository"}, decoratorChain(repo), "既定ではデコレータを重ねないべき")
+	assert.Nil(t, newFeatureNPlusOneDetector(features), "既定ではN+1を検出しないべき")
+}
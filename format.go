@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// レポートの出力形式
+const (
+	formatTable = "table"
+	formatCSV   = "csv"
+	formatJSON  = "json"
+)
+
+// writeRecords はヘッダと行をformatで指定した形式でwに書き出します。
+func writeRecords(w io.Writer, format string, header []string, records [][]string) error {
+	switch format {
+	case formatTable:
+		return writeTable(w, header, records)
+	case formatCSV:
+		return writeCSV(w, header, records)
+	default:
+		return fmt.Errorf("不明な出力形式です: %s (%s または %s を指定してください)", format, formatTable, formatCSV)
+	}
+}
+
+// writeTable は列を揃えた表形式で書き出します。
+func writeTable(w io.Writer, header []string, records [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if _, err := fmt.Fprintln(tw, strings.Join(r, "\t")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// writeCSV はヘッダ行付きのCSVで書き出します。
+func writeCSV(w io.Writer, header []string, records [][]string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(records); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// writeJSONObjects は各行をheaderの順にキーを並べたJSONオブジェクトとし、その配列を書き出します。
+// mapをそのままエンコードするとキーが辞書順になるため、オブジェクトは順に組み立てます。
+func writeJSONObjects(w io.Writer, header []string, rows [][]interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, row := range rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  {")
+		for j, col := range header {
+			if j > 0 {
+				buf.WriteString(", ")
+			}
+			key, err := json.Marshal(col)
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(row[j])
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteString(": ")
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+	}
+	if len(rows) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRecords(t *testing.T) {
+	header := []string{"name", "amount"}
+	records := [][]string{{"apple", "100"}, {"banana, ripe", "5"}}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{format: formatTable, expected: "name          amount\napple         100\nbanana, ripe  5\n"},
+		{format: formatCSV, expected: "name,amount\napple,100\n\"banana, ripe\",5\n"},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		assert.NoError(t, writeRecords(&buf, tc.format, header, records))
+		assert.Equal(t, tc.expected, buf.String(), "形式: %s", tc.format)
+	}
+}
+
+func TestWriteRecords_UnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeRecords(&buf, "xml", []string{"name"}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "xml")
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// stockGenerationTableDDL は在庫データの世代番号を保持する1行だけのstock_generationテーブルを作成するDDLです。
+// 世代番号は常にid = 1の行に保持し、stocksを変更したトランザクションごとに1つ進めます。
+const stockGenerationTableDDL = `
+CREATE TABLE IF NOT EXISTS stock_generation (
+    id TINYINT PRIMARY KEY,
+    generation BIGINT NOT NULL DEFAULT 0
+);`
+
+// bumpGeneration はtableGenerationEnabledが有効な場合に、トランザクション内で世代番号を1つ進めます。
+// 在庫の変更と同じトランザクションで進めるため、コミットされた変更が古い世代番号のまま見えることはありません。
+//
+// 世代番号の行は1行だけなので、進めたトランザクションはコミットまでその行のロックを保持し、
+// 同時に書き込む他のトランザクションはここで待たされます。待つ時間を短くするため、
+// 呼び出しはコミットの直前に1回だけ行い、一括更新でも商品ごとではなくトランザクションごとに1回にします。
+func bumpGeneration(ctx context.Context, tx *sql.Tx) error {
+	if !tableGenerationEnabled {
+		return nil
+	}
+	query := "INSERT INTO stock_generation (id, generation) VALUES (1, 1) ON DUPLICATE KEY UPDATE generation = generation + 1;"
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("世代番号の更新エラー: %w", err)
+	}
+	return nil
+}
+
+// TableGeneration はstocksの現在の世代番号を返します。まだ記録がない場合は0を返します。
+func TableGeneration(db *sql.DB) (int64, error) {
+	return TableGenerationContext(context.Background(), db)
+}
+
+// TableGenerationContext はTableGenerationのcontext対応版です。
+func TableGenerationContext(ctx context.Context, db *sql.DB) (int64, error) {
+	var generation int64
+	err := db.QueryRowContext(ctx, "SELECT generation FROM stock_generation WHERE id = 1;").Scan(&generation)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("世代番号の取得エラー: %w", classifyError(err))
+	}
+	return generation, nil
+}
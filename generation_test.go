@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withTableGeneration はテスト中だけ世代番号の更新を有効にします
+func withTableGeneration(t *testing.T) {
+	original := tableGenerationEnabled
+	tableGenerationEnabled = true
+	t.Cleanup(func() { tableGenerationEnabled = original })
+}
+
+const bumpGenerationRegex = `INSERT INTO stock_generation \(id, generation\) VALUES \(1, 1\) ON DUPLICATE KEY UPDATE generation = generation \+ 1;`
+
+// expectBumpGeneration は世代番号の更新を期待値として設定します
+func expectBumpGeneration(mock sqlmock.Sqlmock) *sqlmock.ExpectedExec {
+	return mock.ExpectExec(bumpGenerationRegex).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// TestUpsertStock_BumpsGeneration は在庫の変更と同じトランザクションで世代番号が進むことをテストします
+func TestUpsertStock_BumpsGeneration(t *testing.T) {
+	withTableGeneration(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 150).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectBumpGeneration(mock)
+	mock.ExpectCommit()
+
+	assert.NoError(t, UpsertStock(db, "apple", 50), "更新は成功するべき")
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_BumpGenerationError は世代番号を進められない場合に在庫の変更もロールバックされることをテストします
+func TestUpsertStock_BumpGenerationError(t *testing.T) {
+	withTableGeneration(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 150).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(bumpGenerationRegex).WillReturnError(errors.New("lock wait timeout"))
+	mock.ExpectRollback()
+
+	err := UpsertStock(db, "apple", 50)
+
+	assert.ErrorContains(t, err, "世代番号の更新エラー")
+	verifyExpectations(t, mock)
+}
+
+// TestBulkUpsertStocks_BumpsGenerationOnce は一括更新では商品の数によらず、コミットの直前に1回だけ世代番号を進めることをテストします。
+// 世代番号の行は全書き込みで共有するため、商品ごとに進めるとトランザクションの間ずっとその行のロックを保持することになります
+func TestBulkUpsertStocks_BumpsGenerationOnce(t *testing.T) {
+	withTableGeneration(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectBulkUpdate(mock, "apple", 100, 50)
+	expectBulkInsert(mock, "banana", 30)
+	expectBulkInsert(mock, "cherry", 10)
+	expectBumpGeneration(mock)
+	mock.ExpectCommit()
+
+	_, err := BulkUpsertStocks(db, []StockUpdate{
+		{Name: "apple", Amount: 50},
+		{Name: "banana", Amount: 30},
+		{Name: "cherry", Amount: 10},
+	})
+
+	assert.NoError(t, err, "一括更新は成功するべき")
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_GenerationDisabled は既定では世代番号を更新しないことをテストします
+func TestUpsertStock_GenerationDisabled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 150).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, UpsertStock(db, "apple", 50), "更新は成功するべき")
+	verifyExpectations(t, mock)
+}
+
+// TestTableGeneration は世代番号を取得し、記録がない場合は0を返すことをテストします
+func TestTableGeneration(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT generation FROM stock_generation WHERE id = 1;`).
+		WillReturnRows(sqlmock.NewRows([]string{"generation"}).AddRow(42))
+	mock.ExpectQuery(`SELECT generation FROM stock_generation WHERE id = 1;`).
+		WillReturnError(sql.ErrNoRows)
+
+	generation, err := TableGeneration(db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), generation)
+
+	generation, err = TableGeneration(db)
+	assert.NoError(t, err, "記録がない場合もエラーにしないべき")
+	assert.Equal(t, int64(0), generation)
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSuspiciousChange は在庫数の変更が設定した上限を超えている場合に返されます。
+var ErrSuspiciousChange = errors.New("在庫数の変更が大きすぎます")
+
+// SuspiciousChangeError は上限を超えた変更の内容です。errors.Is(err, ErrSuspiciousChange)で判定できます。
+type SuspiciousChangeError struct {
+	Name   string
+	Before int
+	After  int
+	Reason string
+}
+
+func (e *SuspiciousChangeError) Error() string {
+	return fmt.Sprintf("%v: %s (%d -> %d, %s)", ErrSuspiciousChange, e.Name, e.Before, e.After, e.Reason)
+}
+
+func (e *SuspiciousChangeError) Unwrap() error {
+	return ErrSuspiciousChange
+}
+
+// upsertOptions はUpsertOptionで指定する在庫更新の設定です。
+type upsertOptions struct {
+	forceLargeChange bool
+}
+
+// UpsertOption は在庫の更新・挿入の動作を変更するオプションです。
+type UpsertOption func(*upsertOptions)
+
+// ForceLargeChange は上限を超える変更であってもErrSuspiciousChangeにせずに適用します。
+func ForceLargeChange() UpsertOption {
+	return func(o *upsertOptions) {
+		o.forceLargeChange = true
+	}
+}
+
+// newUpsertOptions はオプションを順に適用した設定を返します。
+func newUpsertOptions(opts []UpsertOption) upsertOptions {
+	var o upsertOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// checkStockChange は在庫数のbeforeからafterへの変更がmaxDeltaPerOperationとmaxRelativeChangeの範囲内か確認します。
+// 新規挿入（existsがfalse）の場合は比率を計算できないため、変更量の上限のみを確認します。
+func checkStockChange(name string, before, after int, exists bool, opts upsertOptions) error {
+	if opts.forceLargeChange {
+		return nil
+	}
+
+	delta := after - before
+	if delta < 0 {
+		delta = -delta
+	}
+	if maxDeltaPerOperation > 0 && delta > maxDeltaPerOperation {
+		return &SuspiciousChangeError{Name: name, Before: before, After: after,
+			Reason: fmt.Sprintf("変更量 %d が上限 %d を超えています", delta, maxDeltaPerOperation)}
+	}
+
+	if !exists || maxRelativeChange <= 0 || before <= 0 || after <= 0 {
+		return nil
+	}
+	ratio := float64(after) / float64(before)
+	if ratio < 1 {
+		ratio = 1 / ratio
+	}
+	if ratio > maxRelativeChange {
+		return &SuspiciousChangeError{Name: name, Before: before, After: after,
+			Reason: fmt.Sprintf("変化率 %.1f倍 が上限 %.1f倍 を超えています", ratio, maxRelativeChange)}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withChangeLimits はテスト中だけ在庫数の変更の上限を設定します
+func withChangeLimits(t *testing.T, maxDelta int, maxRelative float64) {
+	originalDelta, originalRelative := maxDeltaPerOperation, maxRelativeChange
+	maxDeltaPerOperation, maxRelativeChange = maxDelta, maxRelative
+	t.Cleanup(func() { maxDeltaPerOperation, maxRelativeChange = originalDelta, originalRelative })
+}
+
+func TestCheckStockChange(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxDelta    int
+		maxRelative float64
+		before      int
+		after       int
+		exists      bool
+		force       bool
+		suspicious  bool
+	}{
+		{name: "上限なし", before: 120, after: 9000000, exists: true},
+		{name: "変更量が上限内", maxDelta: 1000, before: 120, after: 1120, exists: true},
+		{name: "変更量が上限超過", maxDelta: 1000, before: 120, after: 9000000, exists: true, suspicious: true},
+		{name: "減少量が上限超過", maxDelta: 1000, before: 5000, after: 100, exists: true, suspicious: true},
+		{name: "変化率が上限内", maxRelative: 50, before: 120, after: 6000, exists: true},
+		{name: "変化率が上限超過", maxRelative: 50, before: 120, after: 9000000, exists: true, suspicious: true},
+		{name: "減少の変化率が上限超過", maxRelative: 50, before: 9000000, after: 120, exists: true, suspicious: true},
+		{name: "在庫0からの変更は変化率を確認しない", maxRelative: 50, before: 0, after: 9000, exists: true},
+		{name: "初回挿入は上限未満なら許可", maxDelta: 1000, maxRelative: 2, after: 999},
+		{name: "初回挿入でも上限超過は拒否", maxDelta: 1000, maxRelative: 2, after: 9000000, suspicious: true},
+		{name: "強制指定", maxDelta: 1000, maxRelative: 50, before: 120, after: 9000000, exists: true, force: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			withChangeLimits(t, tc.maxDelta, tc.maxRelative)
+			var opts []UpsertOption
+			if tc.force {
+				opts = append(opts, ForceLargeChange())
+			}
+
+			err := checkStockChange("apple", tc.before, tc.after, tc.exists, newUpsertOptions(opts))
+
+			if !tc.suspicious {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrSuspiciousChange)
+			var changeErr *SuspiciousChangeError
+			if assert.ErrorAs(t, err, &changeErr) {
+				assert.Equal(t, tc.before, changeErr.Before)
+				assert.Equal(t, tc.after, changeErr.After)
+			}
+		})
+	}
+}
+
+// TestUpsertStock_SuspiciousChange は上限を超える更新がトランザクションを開始せずに拒否され、強制指定では適用されることをテストします
+func TestUpsertStock_SuspiciousChange(t *testing.T) {
+	withChangeLimits(t, 0, 50)
+
+	t.Run("拒否", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?;`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(120))
+
+		err := UpsertStock(db, "apple", 8999880)
+		assert.ErrorIs(t, err, ErrSuspiciousChange)
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("強制指定", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?;`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(120))
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+			WithArgs(9000000, "apple").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, UpsertStock(db, "apple", 8999880, ForceLargeChange()))
+		verifyExpectations(t, mock)
+	})
+}
+
+// TestBulkUpsertStocks_SuspiciousChange は上限を超える行がBulkResultに行単位で記録されることをテストします
+func TestBulkUpsertStocks_SuspiciousChange(t *testing.T) {
+	withChangeLimits(t, 1000, 50)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(120))
+	expectBulkInsert(mock, "banana", 30)
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE;`).
+		WithArgs("cherry").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectCommit()
+
+	result, err := BulkUpsertStocks(db, []StockUpdate{
+		{Name: "apple", Amount: 8999880},
+		{Name: "banana", Amount: 30},
+		{Name: "cherry", Amount: 5000},
+	})
+
+	assert.NoError(t, err, "行単位の拒否は全体のエラーにならないべき")
+	assert.Equal(t, 1, result.Inserted)
+	assert.Equal(t, 2, result.Rejected)
+	if assert.Len(t, result.Failures, 2) {
+		assert.Equal(t, "apple", result.Failures[0].Name)
+		assert.ErrorIs(t, result.Failures[0].Err, ErrSuspiciousChange)
+		assert.Equal(t, "cherry", result.Failures[1].Name)
+		assert.ErrorIs(t, result.Failures[1].Err, ErrSuspiciousChange)
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestMainProcess_SuspiciousChange は上限を超える変更で--forceの案内を含むエラーを返すことをテストします
+func TestMainProcess_SuspiciousChange(t *testing.T) {
+	withChangeLimits(t, 1000, 0)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectPing()
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).AddRow(1, "apple", 120))
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(120))
+
+	err := mainProcess(context.Background(), &bytes.Buffer{}, NewSQLStockRepository(db), "apple", 9000000)
+
+	assert.ErrorIs(t, err, ErrSuspiciousChange)
+	assert.Contains(t, err.Error(), "--force", "--forceの案内を含むべき")
+	assert.False(t, errors.Is(err, ErrSchemaMissing))
+	verifyExpectations(t, mock)
+}
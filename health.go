@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HealthStatus はHealthCheckの結果です。
+type HealthStatus struct {
+	// DatabaseErr はDBへの接続確認に失敗した場合のエラーです。
+	DatabaseErr error
+	// MaintenanceMode はメンテナンスモード中（書き込みを受け付けない）であればtrueです。
+	MaintenanceMode bool
+	// ReplicaConfigured はレプリカが設定され、レプリケーション遅延を計測した場合にtrueです。
+	ReplicaConfigured bool
+	// ReplicationLag は計測したレプリケーション遅延です。
+	ReplicationLag time.Duration
+	// ReplicationErr はレプリケーション遅延を計測できなかった場合のエラーです。
+	ReplicationErr error
+}
+
+// Healthy はDBに接続でき、レプリカが設定されている場合はその遅延を計測できた場合にtrueを返します。
+// メンテナンスモード中でも読み取りはできるため正常として扱います。
+func (s HealthStatus) Healthy() bool {
+	return s.DatabaseErr == nil && s.ReplicationErr == nil
+}
+
+// Err は正常でない場合に、DBへの接続確認とレプリケーション遅延の計測のエラーをまとめて返します。正常であればnilです。
+func (s HealthStatus) Err() error {
+	return errors.Join(s.DatabaseErr, s.ReplicationErr)
+}
+
+// HealthCheck はDBへの接続とメンテナンスモードの状態を確認します。
+func HealthCheck(db *sql.DB) HealthStatus {
+	return HealthCheckContext(context.Background(), db)
+}
+
+// HealthCheckContext はHealthCheckのcontext対応版です。
+func HealthCheckContext(ctx context.Context, db *sql.DB) HealthStatus {
+	return HealthStatus{
+		DatabaseErr:     db.PingContext(ctx),
+		MaintenanceMode: InMaintenanceMode(),
+	}
+}
+
+// HealthCheckReadWrite はHealthCheckに加えて、レプリカが設定されている場合はレプリケーション遅延を計測します。
+func HealthCheckReadWrite(repo *ReadWriteRepo) HealthStatus {
+	return HealthCheckReadWriteContext(context.Background(), repo)
+}
+
+// HealthCheckReadWriteContext はHealthCheckReadWriteのcontext対応版です。
+// プライマリに接続できない場合は遅延を計測しません。
+func HealthCheckReadWriteContext(ctx context.Context, repo *ReadWriteRepo) HealthStatus {
+	status := HealthCheckContext(ctx, repo.primary)
+	if repo.replica == nil || status.DatabaseErr != nil {
+		return status
+	}
+	status.ReplicaConfigured = true
+	status.ReplicationLag, status.ReplicationErr = CheckReplicationLag(ctx, repo.primary, repo.replica)
+	return status
+}
+
+// writeHealth はHealthStatusを1項目1行でwに書き出します。
+func writeHealth(w io.Writer, status HealthStatus) {
+	if status.DatabaseErr != nil {
+		fmt.Fprintf(w, "database: error (%v)\n", status.DatabaseErr)
+	} else {
+		fmt.Fprintln(w, "database: ok")
+	}
+	fmt.Fprintf(w, "maintenance: %s\n", onOff(status.MaintenanceMode))
+	if status.ReplicaConfigured {
+		if status.ReplicationErr != nil {
+			fmt.Fprintf(w, "replication: error (%v)\n", status.ReplicationErr)
+		} else {
+			fmt.Fprintf(w, "replication: lag %s\n", status.ReplicationLag)
+		}
+	}
+}
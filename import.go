@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// import_runsテーブルの状態
+const (
+	importStatusRunning    = "running"
+	importStatusCompleted  = "completed"
+	importStatusSuperseded = "superseded"
+)
+
+// defaultImportBatchSize は取り込み時に1トランザクションで適用する既定の行数です。
+const defaultImportBatchSize = 500
+
+// ErrImportChecksumMismatch は中断された取り込みと同じファイル名で内容が異なる場合に返されます。
+var ErrImportChecksumMismatch = errors.New("前回の取り込みとファイルの内容が一致しません")
+
+// importBatchHook はバッチのコミット後に呼ばれるフックです。テストで処理の中断を再現するために使います。
+var importBatchHook func(batchIndex int) error
+
+// ImportOptions は取り込み処理のオプションです。
+type ImportOptions struct {
+	// BatchSize は1トランザクションで適用する行数です。0以下の場合はdefaultImportBatchSizeを使います。
+	BatchSize int
+	// Restart がtrueの場合、中断された取り込みを破棄して最初からやり直します。
+	Restart bool
+	// ForceLargeChange がtrueの場合、上限を超える在庫数の変更も適用します。
+	ForceLargeChange bool
+	// Format はファイルの形式（csv、jsonl、jsonまたはRegisterDecoderで追加した形式）です。
+	// 空かformatAutoの場合は内容の先頭から判定し、判定できなければ拡張子から決めます。
+	Format string
+}
+
+// ImportSummary は取り込み処理の結果です。
+type ImportSummary struct {
+	RunID int64
+	// Resumed は中断された取り込みを再開した場合にtrueです。
+	Resumed bool
+	// StartIndex は今回の実行で適用を開始した行の位置です。
+	StartIndex int
+	BulkResult
+}
+
+// ParseStockCSV は"name,amount"形式のCSVを読み込みます。
+// 1行目が"name"で始まる場合はヘッダーとして読み飛ばします。
+func ParseStockCSV(r io.Reader) ([]StockUpdate, error) {
+	return decodeAll(NewCSVDecoder(r))
+}
+
+// ImportStocksFile はファイルの在庫データをバッチごとに取り込みます。ファイルの形式はopts.Formatで指定します。
+// 進捗はimport_runsテーブルにバッチと同じトランザクションで記録されるため、
+// 途中で中断されても同じファイルで再実行すれば未適用の行から再開します。
+func ImportStocksFile(db *sql.DB, path string, opts ImportOptions) (ImportSummary, error) {
+	return ImportStocksFileContext(context.Background(), db, path, opts)
+}
+
+// ImportStocksFileContext はImportStocksFileのcontext対応版です。
+func ImportStocksFileContext(ctx context.Context, db *sql.DB, path string, opts ImportOptions) (ImportSummary, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ImportSummary{}, fmt.Errorf("ファイルの読み込みエラー: %w", err)
+	}
+	dec, err := newImportDecoder(opts.Format, path, content)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	items, err := decodeAll(dec)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+	sum := sha256.Sum256(content)
+	return importWithIntentLog(ctx, db, path, hex.EncodeToString(sum[:]), items, opts)
+}
+
+// importWithIntentLog はimport_runsに進捗を記録しながら在庫変更をバッチごとに適用します。
+func importWithIntentLog(ctx context.Context, db *sql.DB, filename, checksum string, items []StockUpdate, opts ImportOptions) (ImportSummary, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultImportBatchSize
+	}
+
+	summary, err := startImportRun(ctx, db, filename, checksum, opts.Restart)
+	if err != nil {
+		return summary, err
+	}
+
+	budget := activeNameRules.NewBudget()
+	var upsertOpts []UpsertOption
+	if opts.ForceLargeChange {
+		upsertOpts = append(upsertOpts, ForceLargeChange())
+	}
+	batchIndex := 0
+	for start := summary.StartIndex; start < len(items); start += batchSize {
+		end := min(start+batchSize, len(items))
+		result, err := applyImportBatch(ctx, db, summary.RunID, items[start:end], end, budget, newUpsertOptions(upsertOpts))
+		if err != nil {
+			return summary, fmt.Errorf("%d行目からのバッチの適用に失敗しました: %w", start+1, err)
+		}
+		summary.merge(result)
+
+		if importBatchHook != nil {
+			if err := importBatchHook(batchIndex); err != nil {
+				return summary, err
+			}
+		}
+		batchIndex++
+	}
+
+	if _, err := db.ExecContext(ctx, "UPDATE import_runs SET status = ? WHERE id = ?;", importStatusCompleted, summary.RunID); err != nil {
+		return summary, fmt.Errorf("取り込み状態の更新エラー: %w", err)
+	}
+	return summary, nil
+}
+
+// startImportRun は同じファイル名の中断された取り込みを探し、再開位置を決めます。
+// 見つからない場合やrestartが指定された場合は新しい取り込みを記録します。
+func startImportRun(ctx context.Context, db *sql.DB, filename, checksum string, restart bool) (ImportSummary, error) {
+	var (
+		summary      ImportSummary
+		prevChecksum string
+	)
+	query := "SELECT id, checksum, next_index FROM import_runs WHERE filename = ? AND status = ? ORDER BY id DESC LIMIT 1;"
+	err := db.QueryRowContext(ctx, query, filename, importStatusRunning).Scan(&summary.RunID, &prevChecksum, &summary.StartIndex)
+	switch {
+	case err == sql.ErrNoRows:
+		// 中断された取り込みはない
+	case err != nil:
+		return summary, fmt.Errorf("取り込み履歴の確認エラー: %w", err)
+	case restart:
+		if _, err := db.ExecContext(ctx, "UPDATE import_runs SET status = ? WHERE id = ?;", importStatusSuperseded, summary.RunID); err != nil {
+			return summary, fmt.Errorf("取り込み状態の更新エラー: %w", err)
+		}
+	case prevChecksum != checksum:
+		return summary, fmt.Errorf("%w: %s (--restartで最初からやり直せます)", ErrImportChecksumMismatch, filename)
+	default:
+		summary.Resumed = true
+		return summary, nil
+	}
+
+	result, err := db.ExecContext(ctx, "INSERT INTO import_runs (filename, checksum, status, next_index) VALUES (?, ?, ?, 0);",
+		filename, checksum, importStatusRunning)
+	if err != nil {
+		return ImportSummary{}, fmt.Errorf("取り込み履歴の記録エラー: %w", err)
+	}
+	runID, err := result.LastInsertId()
+	if err != nil {
+		return ImportSummary{}, fmt.Errorf("取り込み履歴の記録エラー: %w", err)
+	}
+	return ImportSummary{RunID: runID}, nil
+}
+
+// applyImportBatch は1バッチ分の在庫変更と取り込みの進捗を同じトランザクションで記録します。
+func applyImportBatch(ctx context.Context, db *sql.DB, runID int64, items []StockUpdate, nextIndex int, budget *NameBudget, opts upsertOptions) (BulkResult, error) {
+	var result BulkResult
+	err := WithTransaction(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		result, err = applyStockUpdates(ctx, tx, items, budget, opts)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE import_runs SET next_index = ? WHERE id = ?;", nextIndex, runID); err != nil {
+			return fmt.Errorf("取り込み進捗の記録エラー: %w", err)
+		}
+		return bumpGeneration(ctx, tx)
+	})
+	if err != nil {
+		return BulkResult{}, err
+	}
+	return result, nil
+}
+
+// runImportCommand はimportサブコマンドを実行します。
+// 使い方: import [--restart] [--batch-size N] [--force] [--format auto|csv|jsonl|json] <file>
+func runImportCommand(w io.Writer, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(w)
+	restart := fs.Bool("restart", false, "中断された取り込みを破棄して最初からやり直す")
+	batchSize := fs.Int("batch-size", defaultImportBatchSize, "1トランザクションで適用する行数")
+	force := fs.Bool("force", false, "上限を超える在庫数の変更も適用する")
+	format := fs.String("format", formatAuto, "ファイルの形式（auto、csv、jsonl または json）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("取り込むファイルを1つ指定してください")
+	}
+	if err := checkWritable(); err != nil {
+		return err
+	}
+
+	summary, err := ImportStocksFile(db, fs.Arg(0), ImportOptions{BatchSize: *batchSize, Restart: *restart, ForceLargeChange: *force, Format: *format})
+	// 途中で失敗した場合も、それまでに適用したバッチの結果を記録する
+	activeRunMetrics.RecordImport(summary.BulkResult)
+	if err != nil {
+		return err
+	}
+	if summary.Resumed {
+		fmt.Fprintf(w, "中断された取り込みを%d行目から再開しました\n", summary.StartIndex+1)
+	}
+	fmt.Fprintf(w, "取り込み完了: 追加 %d件, 更新 %d件, 拒否 %d件\n", summary.Inserted, summary.Updated, summary.Rejected)
+	for _, f := range summary.Failures {
+		fmt.Fprintf(w, "  拒否: %s (%v)\n", f.Name, f.Err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const findImportRunRegex = `SELECT id, checksum, next_index FROM import_runs WHERE filename = \? AND status = \? ORDER BY id DESC LIMIT 1;`
+
+// expectImportBatch は1バッチ分の新規挿入と進捗記録の期待値を設定します
+func expectImportBatch(mock sqlmock.Sqlmock, runID int64, items []StockUpdate, nextIndex int) {
+	mock.ExpectBegin()
+	for _, item := range items {
+		expectBulkInsert(mock, item.Name, item.Amount)
+	}
+	mock.ExpectExec(`UPDATE import_runs SET next_index = \? WHERE id = \?;`).
+		WithArgs(nextIndex, runID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// withImportBatchHook はテスト中だけimportBatchHookを差し替えます
+func withImportBatchHook(t *testing.T, hook func(batchIndex int) error) {
+	original := importBatchHook
+	importBatchHook = hook
+	t.Cleanup(func() { importBatchHook = original })
+}
+
+func TestParseStockCSV(t *testing.T) {
+	t.Run("ヘッダーあり", func(t *testing.T) {
+		items, err := ParseStockCSV(strings.NewReader("name,amount\napple,100\nbanana, 50\n"))
+		assert.NoError(t, err, "読み込みは成功するべき")
+		assert.Equal(t, []StockUpdate{{Name: "apple", Amount: 100}, {Name: "banana", Amount: 50}}, items)
+	})
+
+	t.Run("ヘッダーなし", func(t *testing.T) {
+		items, err := ParseStockCSV(strings.NewReader("apple,100\n"))
+		assert.NoError(t, err, "読み込みは成功するべき")
+		assert.Equal(t, []StockUpdate{{Name: "apple", Amount: 100}}, items)
+	})
+
+	t.Run("数量が数値でない", func(t *testing.T) {
+		_, err := ParseStockCSV(strings.NewReader("name,amount\napple,many\n"))
+		assert.ErrorContains(t, err, "2行目", "エラーに行番号が含まれるべき")
+	})
+
+	t.Run("列数が不正", func(t *testing.T) {
+		_, err := ParseStockCSV(strings.NewReader("apple,100,extra\n"))
+		assert.Error(t, err, "列数が異なる行はエラーになるべき")
+	})
+}
+
+// TestImportWithIntentLog_Complete は中断なしで全バッチを適用し完了を記録することをテストします
+func TestImportWithIntentLog_Complete(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	items := []StockUpdate{{"a", 1}, {"b", 2}, {"c", 3}}
+
+	mock.ExpectQuery(findImportRunRegex).
+		WithArgs("stocks.csv", importStatusRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "checksum", "next_index"}))
+	mock.ExpectExec(`INSERT INTO import_runs \(filename, checksum, status, next_index\) VALUES \(\?, \?, \?, 0\);`).
+		WithArgs("stocks.csv", "sum1", importStatusRunning).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	expectImportBatch(mock, 7, items[0:2], 2)
+	expectImportBatch(mock, 7, items[2:3], 3)
+	mock.ExpectExec(`UPDATE import_runs SET status = \? WHERE id = \?;`).
+		WithArgs(importStatusCompleted, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	summary, err := importWithIntentLog(context.Background(), db, "stocks.csv", "sum1", items, ImportOptions{BatchSize: 2})
+
+	assert.NoError(t, err, "取り込みは成功するべき")
+	assert.Equal(t, int64(7), summary.RunID, "取り込みIDが記録されるべき")
+	assert.False(t, summary.Resumed, "新規の取り込みであるべき")
+	assert.Equal(t, 3, summary.Inserted, "全行が適用されるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestImportWithIntentLog_CrashAndResume はバッチ適用後の中断から残りの行だけを再開することをテストします
+func TestImportWithIntentLog_CrashAndResume(t *testing.T) {
+	items := []StockUpdate{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 4}, {"e", 5}}
+
+	t.Run("1バッチ目の後で中断", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		crash := errors.New("simulated crash")
+		withImportBatchHook(t, func(batchIndex int) error {
+			if batchIndex == 0 {
+				return crash
+			}
+			return nil
+		})
+
+		mock.ExpectQuery(findImportRunRegex).
+			WithArgs("stocks.csv", importStatusRunning).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "checksum", "next_index"}))
+		mock.ExpectExec(`INSERT INTO import_runs`).
+			WithArgs("stocks.csv", "sum1", importStatusRunning).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		expectImportBatch(mock, 3, items[0:2], 2)
+
+		summary, err := importWithIntentLog(context.Background(), db, "stocks.csv", "sum1", items, ImportOptions{BatchSize: 2})
+
+		assert.ErrorIs(t, err, crash, "中断エラーが返るべき")
+		assert.Equal(t, 2, summary.Inserted, "中断前のバッチだけが適用されるべき")
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("記録された位置から再開", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectQuery(findImportRunRegex).
+			WithArgs("stocks.csv", importStatusRunning).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "checksum", "next_index"}).AddRow(3, "sum1", 2))
+		expectImportBatch(mock, 3, items[2:4], 4)
+		expectImportBatch(mock, 3, items[4:5], 5)
+		mock.ExpectExec(`UPDATE import_runs SET status = \? WHERE id = \?;`).
+			WithArgs(importStatusCompleted, 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		summary, err := importWithIntentLog(context.Background(), db, "stocks.csv", "sum1", items, ImportOptions{BatchSize: 2})
+
+		assert.NoError(t, err, "再開した取り込みは成功するべき")
+		assert.True(t, summary.Resumed, "再開したことが分かるべき")
+		assert.Equal(t, 2, summary.StartIndex, "記録された位置から再開するべき")
+		assert.Equal(t, 3, summary.Inserted, "残りの行だけが適用されるべき")
+		verifyExpectations(t, mock)
+	})
+}
+
+// TestImportWithIntentLog_ChecksumMismatch はファイル内容が変わった場合に再開しないことをテストします
+func TestImportWithIntentLog_ChecksumMismatch(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(findImportRunRegex).
+		WithArgs("stocks.csv", importStatusRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "checksum", "next_index"}).AddRow(3, "old", 2))
+
+	_, err := importWithIntentLog(context.Background(), db, "stocks.csv", "new", []StockUpdate{{"a", 1}}, ImportOptions{})
+
+	assert.ErrorIs(t, err, ErrImportChecksumMismatch, "チェックサム不一致エラーが返るべき")
+	assert.ErrorContains(t, err, "--restart", "やり直し方法が案内されるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestImportWithIntentLog_Restart は中断された取り込みを破棄して最初からやり直すことをテストします
+func TestImportWithIntentLog_Restart(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	items := []StockUpdate{{"a", 1}, {"b", 2}}
+
+	mock.ExpectQuery(findImportRunRegex).
+		WithArgs("stocks.csv", importStatusRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "checksum", "next_index"}).AddRow(3, "old", 1))
+	mock.ExpectExec(`UPDATE import_runs SET status = \? WHERE id = \?;`).
+		WithArgs(importStatusSuperseded, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO import_runs`).
+		WithArgs("stocks.csv", "new", importStatusRunning).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	expectImportBatch(mock, 4, items, 2)
+	mock.ExpectExec(`UPDATE import_runs SET status = \? WHERE id = \?;`).
+		WithArgs(importStatusCompleted, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	summary, err := importWithIntentLog(context.Background(), db, "stocks.csv", "new", items, ImportOptions{Restart: true})
+
+	assert.NoError(t, err, "やり直した取り込みは成功するべき")
+	assert.Equal(t, int64(4), summary.RunID, "新しい取り込みIDであるべき")
+	assert.Equal(t, 0, summary.StartIndex, "最初から適用するべき")
+	assert.Equal(t, 2, summary.Inserted, "全行が適用されるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestRunImportCommand はimportサブコマンドがファイルを取り込んで結果を出力することをテストします
+func TestRunImportCommand(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	path := filepath.Join(t.TempDir(), "stocks.csv")
+	assert.NoError(t, os.WriteFile(path, []byte("name,amount\napple,10\n"), 0o600))
+
+	mock.ExpectQuery(findImportRunRegex).
+		WithArgs(path, importStatusRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "checksum", "next_index"}))
+	mock.ExpectExec(`INSERT INTO import_runs`).
+		WithArgs(path, sqlmock.AnyArg(), importStatusRunning).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectImportBatch(mock, 1, []StockUpdate{{"apple", 10}}, 1)
+	mock.ExpectExec(`UPDATE import_runs SET status = \? WHERE id = \?;`).
+		WithArgs(importStatusCompleted, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var buf bytes.Buffer
+	err := runImportCommand(&buf, db, []string{"--batch-size", "10", path})
+
+	assert.NoError(t, err, "importサブコマンドは成功するべき")
+	assert.Contains(t, buf.String(), "追加 1件", "取り込み結果が出力されるべき")
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,186 @@
+// Package stmt は在庫テーブルに発行するSQL文を生成します。
+// 本番のコードとテストの期待値を同じ関数から作ることで、空白や末尾のセミコロンの違いによるずれを防ぎます。
+package stmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect はSQL文の方言です。
+type Dialect string
+
+// MySQL はMySQLの方言です。プレースホルダは?です。
+const MySQL Dialect = "mysql"
+
+// Values はSQL文の引数を名前で指定します。
+type Values map[string]interface{}
+
+// Statement は生成したSQL文と、プレースホルダに渡す引数の名前を順に並べたものです。
+// 呼び出し側はParamsの順に引数を渡します。
+type Statement struct {
+	SQL    string
+	Params []string
+}
+
+// Bind はvaluesの値をParamsの順に並べて返します。
+// Paramsの名前がvaluesにない場合はプログラムの誤りとしてpanicします。
+func (s Statement) Bind(values Values) []interface{} {
+	args := make([]interface{}, len(s.Params))
+	for i, name := range s.Params {
+		v, ok := values[name]
+		if !ok {
+			panic(fmt.Sprintf("stmt: 引数 %q が指定されていません: %s", name, s.SQL))
+		}
+		args[i] = v
+	}
+	return args
+}
+
+// placeholders はn個のプレースホルダを", "で区切って返します。
+func (d Dialect) placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// build はSQL文の末尾にセミコロンを付けてStatementを返します。
+func build(sql string, params ...string) Statement {
+	return Statement{SQL: sql + ";", Params: params}
+}
+
+// SelectAll はtableの全行からcolumnsを取得するSQL文を返します。
+func SelectAll(table string, d Dialect, columns []string) Statement {
+	return build("SELECT " + strings.Join(columns, ", ") + " FROM " + table)
+}
+
+// SelectByName はtableから名前に一致する行のcolumnsを取得するSQL文を返します。
+func SelectByName(table string, d Dialect, columns []string) Statement {
+	return build("SELECT "+strings.Join(columns, ", ")+" FROM "+table+" WHERE name = "+d.placeholders(1), "name")
+}
+
+// SelectAmountByName はtableから名前に一致する行の在庫数を取得するSQL文を返します。
+func SelectAmountByName(table string, d Dialect) Statement {
+	return build("SELECT amount FROM "+table+" WHERE name = "+d.placeholders(1), "name")
+}
+
+// SelectAmountByNameForUpdate はSelectAmountByNameと同じ行を、行ロックを取得して読み取るSQL文を返します。
+func SelectAmountByNameForUpdate(table string, d Dialect) Statement {
+	return build("SELECT amount FROM "+table+" WHERE name = "+d.placeholders(1)+" FOR UPDATE", "name")
+}
+
+// UpdateAmount は名前に一致する行の在庫数を更新するSQL文を返します。
+func UpdateAmount(table string, d Dialect) Statement {
+	return build("UPDATE "+table+" SET amount = "+d.placeholders(1)+" WHERE name = "+d.placeholders(1), "amount", "name")
+}
+
+// UpdateAmountAndCategory は名前に一致する行の在庫数とカテゴリを更新するSQL文を返します。
+func UpdateAmountAndCategory(table string, d Dialect) Statement {
+	return build("UPDATE "+table+" SET amount = "+d.placeholders(1)+", category = "+d.placeholders(1)+" WHERE name = "+d.placeholders(1),
+		"amount", "category", "name")
+}
+
+// InsertStock は名前と在庫数を指定して行を挿入するSQL文を返します。
+func InsertStock(table string, d Dialect) Statement {
+	return build("INSERT INTO "+table+" (name, amount) VALUES ("+d.placeholders(2)+")", "name", "amount")
+}
+
+// InsertStockWithCategory は名前と在庫数、カテゴリを指定して行を挿入するSQL文を返します。
+func InsertStockWithCategory(table string, d Dialect) Statement {
+	return build("INSERT INTO "+table+" (name, amount, category) VALUES ("+d.placeholders(3)+")", "name", "amount", "category")
+}
+
+// UpsertAmount は名前と在庫数を指定して行を挿入し、同じ名前の行が既にあれば在庫数を加算するSQL文を返します。
+// 名前の一意制約に依存し、1文で挿入と加算のどちらかを行います。
+func UpsertAmount(table string, d Dialect) Statement {
+	return build("INSERT INTO "+table+" (name, amount) VALUES ("+d.placeholders(2)+") ON DUPLICATE KEY UPDATE amount = amount + VALUES(amount)",
+		"name", "amount")
+}
+
+// maxTagValueLength はタグの1つの値に含める最大の文字数です。
+const maxTagValueLength = 64
+
+// TagPattern はTag.Prefixが付けるコメントに一致する正規表現です。タグは付かない場合もあるため、テストの期待値では省略可能として使います。
+const TagPattern = `(?:/\* [A-Za-z0-9_.: -]* \*/ )?`
+
+// Tag はSQL文の先頭にコメントとして付ける、SQL文を発行したアプリケーションと操作の情報です。
+// MySQLのスローログなどでSQL文を発行したコードを特定するために使います。空の項目はコメントに含めません。
+type Tag struct {
+	App string
+	Op  string
+	ID  string
+}
+
+// sanitizeTagValue はタグの値のうち英数字と"_"、"."、"-"以外の文字を"_"に置き換え、maxTagValueLength文字に切り詰めます。
+// 利用者の入力を含む値でもコメントを閉じたり、区切りの空白や":"を紛れ込ませたりできないようにします。
+func sanitizeTagValue(v string) string {
+	var b strings.Builder
+	for i, r := range v {
+		if i >= maxTagValueLength {
+			break
+		}
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// Comment はタグを"/* app:... op:... id:... */"の形のコメントにして返します。すべての項目が空の場合は空文字列を返します。
+func (t Tag) Comment() string {
+	var fields []string
+	for _, f := range []struct{ key, value string }{{"app", t.App}, {"op", t.Op}, {"id", t.ID}} {
+		if f.value != "" {
+			fields = append(fields, f.key+":"+sanitizeTagValue(f.value))
+		}
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return "/* " + strings.Join(fields, " ") + " */"
+}
+
+// Prefix はSQL文sqlの先頭にタグのコメントを付けて返します。
+func (t Tag) Prefix(sql string) string {
+	comment := t.Comment()
+	if comment == "" {
+		return sql
+	}
+	return comment + " " + sql
+}
+
+// Tagged はSQL文の先頭にタグのコメントを付けて返します。
+func (s Statement) Tagged(t Tag) string {
+	return t.Prefix(s.SQL)
+}
+
+// SplitTag はTag.Prefixで付けたコメントをSQL文から取り除き、タグとタグのないSQL文を返します。
+// 先頭にタグのコメントがない場合は空のTagとsqlをそのまま返します。
+func SplitTag(sql string) (Tag, string) {
+	if !strings.HasPrefix(sql, "/* ") {
+		return Tag{}, sql
+	}
+	comment, rest, ok := strings.Cut(sql[len("/* "):], " */ ")
+	if !ok {
+		return Tag{}, sql
+	}
+	var t Tag
+	for _, field := range strings.Fields(comment) {
+		key, value, ok := strings.Cut(field, ":")
+		if !ok {
+			return Tag{}, sql
+		}
+		switch key {
+		case "app":
+			t.App = value
+		case "op":
+			t.Op = value
+		case "id":
+			t.ID = value
+		default:
+			return Tag{}, sql
+		}
+	}
+	return t, rest
+}
@@ -0,0 +1,117 @@
+package stmt
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "testdata以下のgoldenファイルを更新する")
+
+// statements は方言ごとに生成内容を固定するSQL文です
+func statements(d Dialect) []struct {
+	name string
+	stmt Statement
+} {
+	columns := []string{"id", "name", "amount", "category"}
+	return []struct {
+		name string
+		stmt Statement
+	}{
+		{"SelectAll", SelectAll("stocks", d, columns)},
+		{"SelectByName", SelectByName("stocks", d, columns)},
+		{"SelectAmountByName", SelectAmountByName("stocks", d)},
+		{"SelectAmountByNameForUpdate", SelectAmountByNameForUpdate("stocks", d)},
+		{"UpdateAmount", UpdateAmount("stocks", d)},
+		{"UpdateAmountAndCategory", UpdateAmountAndCategory("stocks", d)},
+		{"InsertStock", InsertStock("stocks", d)},
+		{"InsertStockWithCategory", InsertStockWithCategory("stocks", d)},
+		{"UpsertAmount", UpsertAmount("stocks", d)},
+	}
+}
+
+// TestGolden は方言ごとに生成するSQL文と引数の順序がgoldenファイルと一致することを確認します。
+// 意図して変更した場合は go test ./internal/stmt -update で更新します。
+func TestGolden(t *testing.T) {
+	for _, d := range []Dialect{MySQL} {
+		var b strings.Builder
+		for _, s := range statements(d) {
+			fmt.Fprintf(&b, "%s: %s [%s]\n", s.name, s.stmt.SQL, strings.Join(s.stmt.Params, ", "))
+			assert.Equal(t, strings.Count(s.stmt.SQL, "?"), len(s.stmt.Params), "%s: プレースホルダと引数の数が一致するべき", s.name)
+		}
+
+		path := filepath.Join("testdata", string(d)+".golden")
+		if *update {
+			if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		golden, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, string(golden), b.String(), "%sの生成結果がgoldenファイルと一致するべき", d)
+	}
+}
+
+func TestBind(t *testing.T) {
+	s := UpdateAmountAndCategory("stocks", MySQL)
+
+	assert.Equal(t, []interface{}{150, "fruit", "apple"}, s.Bind(Values{"name": "apple", "category": "fruit", "amount": 150}))
+	assert.Panics(t, func() { s.Bind(Values{"name": "apple"}) }, "引数が不足している場合はpanicするべき")
+}
+
+func TestTag(t *testing.T) {
+	s := SelectAmountByName("stocks", MySQL)
+
+	assert.Equal(t, "/* app:db_mock op:UpsertStock id:req-42 */ SELECT amount FROM stocks WHERE name = ?;",
+		s.Tagged(Tag{App: "db_mock", Op: "UpsertStock", ID: "req-42"}))
+	assert.Equal(t, "/* app:db_mock */ "+s.SQL, s.Tagged(Tag{App: "db_mock"}), "空の項目は含めないべき")
+	assert.Equal(t, s.SQL, s.Tagged(Tag{}), "すべて空ならコメントを付けないべき")
+}
+
+func TestTag_Escaping(t *testing.T) {
+	s := SelectAmountByName("stocks", MySQL)
+	malicious := []string{
+		"x */ DROP TABLE stocks; /*",
+		"a*/b",
+		"id:evil op:Other",
+		"改行\nと\x00制御文字",
+		strings.Repeat("a", 200),
+	}
+	for _, id := range malicious {
+		tagged := s.Tagged(Tag{App: "db_mock", Op: "GetStock", ID: id})
+		comment, rest, ok := strings.Cut(tagged, " */ ")
+		assert.True(t, ok)
+		assert.Equal(t, s.SQL, rest, "コメントの外にSQL文以外を含めないべき: %q", id)
+		assert.NotContains(t, comment[2:], "*/", "コメントを閉じられないべき")
+		assert.NotContains(t, comment[2:], "/*")
+		assert.Regexp(t, "^"+TagPattern+`SELECT`, tagged)
+
+		tag, untagged := SplitTag(tagged)
+		assert.Equal(t, s.SQL, untagged)
+		assert.Equal(t, "GetStock", tag.Op, "操作名を上書きできないべき")
+		assert.LessOrEqual(t, len(tag.ID), maxTagValueLength)
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	s := UpdateAmount("stocks", MySQL)
+	tag := Tag{App: "db_mock", Op: "UpsertStock", ID: "abc"}
+
+	got, sql := SplitTag(s.Tagged(tag))
+	assert.Equal(t, tag, got)
+	assert.Equal(t, s.SQL, sql)
+
+	got, sql = SplitTag(s.SQL)
+	assert.Equal(t, Tag{}, got)
+	assert.Equal(t, s.SQL, sql, "タグのないSQL文はそのまま返すべき")
+
+	_, sql = SplitTag("/* 通常のコメント */ " + s.SQL)
+	assert.Equal(t, "/* 通常のコメント */ "+s.SQL, sql, "タグ以外のコメントは取り除かないべき")
+}
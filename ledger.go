@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// stock_logに記録する操作の種類
+const (
+	operationInsert = "insert"
+	operationUpdate = "update"
+	operationDelete = "delete"
+	// operationInitial はBackfillHistoryで記録する、履歴の起点となる在庫数です（変更量は0）。
+	operationInitial = "initial"
+	// operationCompacted はCompactLogで古い変更履歴をまとめた行です（変更量はまとめた行の合計）。
+	operationCompacted = "compacted"
+)
+
+// stockLogTableDDL は在庫の変更履歴（台帳）を記録するstock_logテーブルを作成するDDLです。
+// deltaは変更量、amountは変更後の在庫数です。
+const stockLogTableDDL = `
+CREATE TABLE IF NOT EXISTS stock_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    operation VARCHAR(16) NOT NULL,
+    delta INT NOT NULL,
+    amount INT NOT NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_stock_log_name_created_at (name, created_at)
+);`
+
+// recordStockLog はauditLogEnabledが有効な場合に、在庫の変更をトランザクション内でstock_logへ記録します。
+func recordStockLog(ctx context.Context, tx *sql.Tx, name, operation string, delta, amount int) error {
+	if !auditLogEnabled {
+		return nil
+	}
+	query := "INSERT INTO stock_log (name, operation, delta, amount) VALUES (?, ?, ?, ?);"
+	if _, err := tx.ExecContext(ctx, query, name, operation, delta, amount); err != nil {
+		return fmt.Errorf("変更履歴の記録エラー: %w", err)
+	}
+	return nil
+}
+
+// StockTurnover はsince以降の指定商品の出庫量（負の変更量の合計）を正の値で返します。
+// 該当する変更がない場合は0を返します。
+func StockTurnover(db *sql.DB, name string, since time.Time) (int, error) {
+	return StockTurnoverContext(context.Background(), db, name, since)
+}
+
+// StockTurnoverContext はStockTurnoverのcontext対応版です。
+func StockTurnoverContext(ctx context.Context, db *sql.DB, name string, since time.Time) (int, error) {
+	query := "SELECT delta FROM stock_log WHERE name = ? AND created_at >= ? ORDER BY id;"
+	rows, err := db.QueryContext(ctx, query, name, since)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	outflow := 0
+	for rows.Next() {
+		var delta int
+		if err := rows.Scan(&delta); err != nil {
+			return 0, err
+		}
+		if delta < 0 {
+			outflow -= delta
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return outflow, nil
+}
+
+// ErrInvalidWindow は在庫の変化の速さを求める期間に0以下が指定された場合に返されます。
+var ErrInvalidWindow = errors.New("集計の期間は0より大きくする必要があります")
+
+// StockVelocity は直近windowの間の指定商品の在庫数の変化の速さを、1日あたりの変更量の平均で返します。
+// stock_logの変更量の合計をwindowの日数（1日未満の端数を含む）で割った値で、出庫が多ければ負になります。
+// 期間内に変更がない場合は0を返します。windowが0以下の場合はErrInvalidWindowを返します。
+func StockVelocity(db *sql.DB, name string, window time.Duration) (float64, error) {
+	return StockVelocityContext(context.Background(), db, name, window)
+}
+
+// StockVelocityContext はStockVelocityのcontext対応版です。
+func StockVelocityContext(ctx context.Context, db *sql.DB, name string, window time.Duration) (float64, error) {
+	if window <= 0 {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidWindow, window)
+	}
+	var net int64
+	since := time.Now().Add(-window)
+	if err := db.QueryRowContext(ctx, SQLNetDeltaSince, name, since).Scan(&net); err != nil {
+		return 0, err
+	}
+	days := window.Hours() / 24
+	return float64(net) / days, nil
+}
+
+// ErrInvalidOperation はstock_logに記録されない操作の種類が指定された場合に返されます。
+var ErrInvalidOperation = errors.New("不明な操作の種類です")
+
+// StockChange は商品名を含むstock_logの1行です。
+type StockChange struct {
+	ID        int64
+	Name      string
+	Operation string
+	Delta     int
+	Amount    int
+	CreatedAt time.Time
+}
+
+// ChangesByType は指定した種類（insert、update、delete）の操作による変更を新しい順に最大limit件返します。
+func ChangesByType(db *sql.DB, opType string, limit int) ([]StockChange, error) {
+	return ChangesByTypeContext(context.Background(), db, opType, limit)
+}
+
+// ChangesByTypeContext はChangesByTypeのcontext対応版です。
+func ChangesByTypeContext(ctx context.Context, db *sql.DB, opType string, limit int) ([]StockChange, error) {
+	switch opType {
+	case operationInsert, operationUpdate, operationDelete, operationInitial, operationCompacted:
+	default:
+		return nil, fmt.Errorf("%w: %q (%s, %s, %s, %s, %s のいずれかを指定してください)", ErrInvalidOperation, opType,
+			operationInsert, operationUpdate, operationDelete, operationInitial, operationCompacted)
+	}
+
+	query := "SELECT id, name, operation, delta, amount, created_at FROM stock_log WHERE operation = ? ORDER BY created_at DESC, id DESC LIMIT ?;"
+	rows, err := db.QueryContext(ctx, query, opType, limit)
+	if err != nil {
+		return nil, fmt.Errorf("変更履歴の取得エラー: %w", classifyError(err))
+	}
+	return scanStockChanges(rows)
+}
+
+// ErrInvalidPageSize はページの件数に0以下が指定された場合に返されます。
+var ErrInvalidPageSize = errors.New("ページの件数は1以上である必要があります")
+
+// ListChangesAfter はidがafterIDより大きい変更をid順に最大limit件返します。最初のページはafterIDに0を指定します。
+// 次のページは返した最後の変更のIDをafterIDに指定して取得し、空のスライスが返れば最後のページです。
+// OFFSETと違って読み飛ばす行を走査しないため、変更履歴が大きくても後ろのページを同じ速さで取得できます。
+func ListChangesAfter(db *sql.DB, afterID int64, limit int) ([]StockChange, error) {
+	return ListChangesAfterContext(context.Background(), db, afterID, limit)
+}
+
+// ListChangesAfterContext はListChangesAfterのcontext対応版です。
+func ListChangesAfterContext(ctx context.Context, db *sql.DB, afterID int64, limit int) ([]StockChange, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPageSize, limit)
+	}
+	rows, err := db.QueryContext(ctx, SQLChangesAfterID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("変更履歴の取得エラー: %w", classifyError(err))
+	}
+	return scanStockChanges(rows)
+}
+
+// scanStockChanges はrowsのすべての行をStockChangeとして読み取り、rowsを閉じます。
+func scanStockChanges(rows *sql.Rows) ([]StockChange, error) {
+	defer rows.Close()
+
+	changes := []StockChange{}
+	for rows.Next() {
+		var c StockChange
+		if err := rows.Scan(&c.ID, &c.Name, &c.Operation, &c.Delta, &c.Amount, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("変更履歴の取得エラー: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("変更履歴の取得エラー: %w", err)
+	}
+	return changes, nil
+}
+
+// StockLogEntry はstock_logの1行です。
+type StockLogEntry struct {
+	ID        int64     `json:"id"`
+	Operation string    `json:"operation"`
+	Delta     int       `json:"delta"`
+	Amount    int       `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProductHistory は1つの商品の変更履歴と現在の在庫数です。
+// 商品がstocksに存在しない場合、CurrentAmountはnilです。
+type ProductHistory struct {
+	Name          string          `json:"name"`
+	CurrentAmount *int            `json:"current_amount"`
+	History       []StockLogEntry `json:"history"`
+}
+
+// LoadProductHistory は指定商品の変更履歴を時刻順に読み込み、stocksの現在の在庫数とあわせて返します。
+func LoadProductHistory(ctx context.Context, db *sql.DB, name string) (ProductHistory, error) {
+	history := ProductHistory{Name: name, History: []StockLogEntry{}}
+
+	var current int
+	err := db.QueryRowContext(ctx, taggedSQL(ctx, "ProductHistory", stmtStockAmount.SQL), name).Scan(&current)
+	switch {
+	case err == sql.ErrNoRows:
+		// 削除済みなどで現在の在庫がない
+	case err != nil:
+		return ProductHistory{}, fmt.Errorf("在庫数の取得エラー: %w", err)
+	default:
+		history.CurrentAmount = &current
+	}
+
+	query := "SELECT id, operation, delta, amount, created_at FROM stock_log WHERE name = ? ORDER BY created_at, id;"
+	rows, err := db.QueryContext(ctx, query, name)
+	if err != nil {
+		return ProductHistory{}, fmt.Errorf("変更履歴の取得エラー: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e StockLogEntry
+		if err := rows.Scan(&e.ID, &e.Operation, &e.Delta, &e.Amount, &e.CreatedAt); err != nil {
+			return ProductHistory{}, fmt.Errorf("変更履歴の取得エラー: %w", err)
+		}
+		history.History = append(history.History, e)
+	}
+	if err := rows.Err(); err != nil {
+		return ProductHistory{}, fmt.Errorf("変更履歴の取得エラー: %w", err)
+	}
+	return history, nil
+}
+
+// ProductHistoryJSON は指定商品の変更履歴をJSONでwに書き出します。
+// 変更履歴はhistoryの配列に時刻順で並び、current_amountに現在の在庫数が入ります。
+func ProductHistoryJSON(db *sql.DB, name string, w io.Writer) error {
+	return ProductHistoryJSONContext(context.Background(), db, name, w)
+}
+
+// ProductHistoryJSONContext はProductHistoryJSONのcontext対応版です。
+func ProductHistoryJSONContext(ctx context.Context, db *sql.DB, name string, w io.Writer) error {
+	history, err := LoadProductHistory(ctx, db, name)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		return fmt.Errorf("JSONの書き出しエラー: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrLedgerMismatch はstocksの在庫数がstock_logの変更量の合計と一致しない場合に返されます。
+var ErrLedgerMismatch = errors.New("在庫数が変更履歴と一致しません")
+
+// LedgerMismatchError は一致しなかった在庫数と変更履歴の合計です。errors.Is(err, ErrLedgerMismatch)で判定できます。
+type LedgerMismatchError struct {
+	Name      string
+	Amount    int
+	LedgerSum int
+}
+
+func (e *LedgerMismatchError) Error() string {
+	return fmt.Sprintf("%v: %s (stocks: %d, stock_log: %d)", ErrLedgerMismatch, e.Name, e.Amount, e.LedgerSum)
+}
+
+func (e *LedgerMismatchError) Unwrap() error {
+	return ErrLedgerMismatch
+}
+
+// RebuildAmount はstock_logの変更履歴から求めた指定商品の在庫数を返します。
+// BackfillHistoryが記録したinitialの行があればその在庫数を起点とし、以降の変更量を加えます。
+// 変更履歴がない場合は0を返します。stocksテーブルは変更しません。
+func RebuildAmount(db *sql.DB, name string) (int, error) {
+	return RebuildAmountContext(context.Background(), db, name)
+}
+
+// RebuildAmountContext はRebuildAmountのcontext対応版です。
+func RebuildAmountContext(ctx context.Context, db *sql.DB, name string) (int, error) {
+	query := "SELECT name, operation, delta, amount FROM stock_log WHERE name = ? ORDER BY created_at, id;"
+	totals, err := queryLedgerTotals(ctx, db, query, name)
+	if err != nil {
+		return 0, err
+	}
+	return totals[name].amount, nil
+}
+
+// ledgerTotal は変更履歴から求めた1商品の在庫数と、その元になった行数です。
+type ledgerTotal struct {
+	amount int
+	rows   int
+}
+
+// addLedgerRow は変更履歴の1行をtotalに反映した在庫数を返します。
+// initialの行（変更量は0）は記録した在庫数を起点とし、それ以外の行は変更量を加えます。ExportTimeSeriesと同じ求め方です。
+func addLedgerRow(total int, operation string, delta, amount int) int {
+	if operation == operationInitial {
+		return amount
+	}
+	return total + delta
+}
+
+// queryLedgerTotals は商品名、操作、変更量、記録後の在庫数の4列を商品ごとに記録順で返すクエリを実行し、
+// 商品ごとに変更履歴から求めた在庫数を返します。
+func queryLedgerTotals(ctx context.Context, q Queryer, query string, args ...interface{}) (map[string]ledgerTotal, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("変更履歴の集計エラー: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	totals := make(map[string]ledgerTotal)
+	for rows.Next() {
+		var (
+			name, operation string
+			delta, amount   int
+		)
+		if err := rows.Scan(&name, &operation, &delta, &amount); err != nil {
+			return nil, fmt.Errorf("変更履歴の集計エラー: %w", err)
+		}
+		t := totals[name]
+		t.amount = addLedgerRow(t.amount, operation, delta, amount)
+		t.rows++
+		totals[name] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("変更履歴の集計エラー: %w", err)
+	}
+	return totals, nil
+}
+
+// verifyLedger はverifyLedgerAfterWriteが有効な場合に、コミット後の在庫数が変更履歴の合計と一致するかを確認します。
+// 一致しない場合はログに記録してLedgerMismatchErrorを返します。書き込み自体はコミット済みです。
+// テストやデバッグ時の安全確認のためのもので、auditLogEnabledと組み合わせて使います。
+func verifyLedger(ctx context.Context, db *sql.DB, name string) error {
+	if !verifyLedgerAfterWrite {
+		return nil
+	}
+
+	var amount int
+	if err := db.QueryRowContext(ctx, taggedSQL(ctx, "VerifyLedger", stmtStockAmount.SQL), name).Scan(&amount); err != nil {
+		return fmt.Errorf("在庫数の取得エラー: %w", err)
+	}
+	sum, err := RebuildAmountContext(ctx, db, name)
+	if err != nil {
+		return err
+	}
+	if amount != sum {
+		mismatch := &LedgerMismatchError{Name: name, Amount: amount, LedgerSum: sum}
+		log.Printf("変更履歴の確認: %v", mismatch)
+		return mismatch
+	}
+	return nil
+}
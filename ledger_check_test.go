@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withLedgerVerification はテスト中だけ更新後の変更履歴との照合を有効にします
+func withLedgerVerification(t *testing.T) {
+	withAuditLog(t)
+	original := verifyLedgerAfterWrite
+	verifyLedgerAfterWrite = true
+	t.Cleanup(func() { verifyLedgerAfterWrite = original })
+}
+
+// expectLoggedUpdate は変更履歴を記録する在庫の更新のモックを設定します
+func expectLoggedUpdate(mock sqlmock.Sqlmock, before, amount int) {
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(before))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+		WithArgs(before+amount, "apple").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stock_log`).
+		WithArgs("apple", operationUpdate, amount, before+amount).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+const ledgerRowsByNameRegex = `SELECT name, operation, delta, amount FROM stock_log WHERE name = \? ORDER BY created_at, id;`
+
+// ledgerRows は変更履歴の行を返します。各行は操作、変更量、記録後の在庫数の順です
+func ledgerRows(name string, rows ...[]interface{}) *sqlmock.Rows {
+	result := sqlmock.NewRows([]string{"name", "operation", "delta", "amount"})
+	for _, r := range rows {
+		result.AddRow(name, r[0], r[1], r[2])
+	}
+	return result
+}
+
+func TestRebuildAmount(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(ledgerRowsByNameRegex).
+		WithArgs("apple").
+		WillReturnRows(ledgerRows("apple",
+			[]interface{}{operationInsert, 100, 100},
+			[]interface{}{operationUpdate, 20, 120}))
+
+	amount, err := RebuildAmount(db, "apple")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 120, amount)
+	verifyExpectations(t, mock)
+}
+
+// TestRebuildAmount_Backfilled はBackfillHistoryが記録したinitialの行の在庫数を起点に、以降の変更量だけを加えることをテストします
+func TestRebuildAmount_Backfilled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(ledgerRowsByNameRegex).
+		WithArgs("apple").
+		WillReturnRows(ledgerRows("apple",
+			[]interface{}{operationInitial, 0, 100},
+			[]interface{}{operationUpdate, 20, 120},
+			[]interface{}{operationUpdate, -5, 115}))
+
+	amount, err := RebuildAmount(db, "apple")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 115, amount)
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_LedgerMatches は在庫数が変更履歴の合計と一致する場合に成功することをテストします
+func TestUpsertStock_LedgerMatches(t *testing.T) {
+	withLedgerVerification(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLoggedUpdate(mock, 100, 20)
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(120))
+	mock.ExpectQuery(ledgerRowsByNameRegex).
+		WithArgs("apple").
+		WillReturnRows(ledgerRows("apple",
+			[]interface{}{operationInsert, 100, 100},
+			[]interface{}{operationUpdate, 20, 120}))
+
+	assert.NoError(t, UpsertStock(db, "apple", 20))
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_LedgerMismatch は在庫数が変更履歴の合計と一致しない場合にエラーになることをテストします
+func TestUpsertStock_LedgerMismatch(t *testing.T) {
+	withLedgerVerification(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLoggedUpdate(mock, 100, 20)
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(120))
+	// 変更履歴を記録せずに在庫数を変更した行がある
+	mock.ExpectQuery(ledgerRowsByNameRegex).
+		WithArgs("apple").
+		WillReturnRows(ledgerRows("apple", []interface{}{operationUpdate, 20, 120}))
+
+	err := UpsertStock(db, "apple", 20)
+
+	var mismatch *LedgerMismatchError
+	if assert.True(t, errors.As(err, &mismatch), "LedgerMismatchErrorであるべき: %v", err) {
+		assert.True(t, errors.Is(err, ErrLedgerMismatch))
+		assert.Equal(t, LedgerMismatchError{Name: "apple", Amount: 120, LedgerSum: 20}, *mismatch)
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_LedgerVerificationDisabled は既定では照合を行わないことをテストします
+func TestUpsertStock_LedgerVerificationDisabled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("apple").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+		WithArgs("apple", 10).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, UpsertStock(db, "apple", 10))
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withAuditLog はテスト中だけ変更履歴の記録を有効にします
+func withAuditLog(t *testing.T) {
+	original := auditLogEnabled
+	auditLogEnabled = true
+	t.Cleanup(func() { auditLogEnabled = original })
+}
+
+func TestStockTurnover(t *testing.T) {
+	since := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		deltas   []int
+		expected int
+	}{
+		{name: "入庫と出庫が混在", deltas: []int{100, -30, 50, -20, -5}, expected: 55},
+		{name: "入庫のみ", deltas: []int{100, 20}, expected: 0},
+		{name: "変更なし", deltas: nil, expected: 0},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			rows := sqlmock.NewRows([]string{"delta"})
+			for _, d := range tc.deltas {
+				rows.AddRow(d)
+			}
+			mock.ExpectQuery(`SELECT delta FROM stock_log WHERE name = \? AND created_at >= \? ORDER BY id;`).
+				WithArgs("apple", since).
+				WillReturnRows(rows)
+
+			outflow, err := StockTurnover(db, "apple", since)
+
+			assert.NoError(t, err, "エラーが発生すべきでない")
+			assert.Equal(t, tc.expected, outflow, "出庫量が期待通りであるべき")
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestStockTurnover_QueryError はクエリエラーが返ることをテストします
+func TestStockTurnover_QueryError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT delta FROM stock_log`).
+		WillReturnError(errors.New("query error"))
+
+	_, err := StockTurnover(db, "apple", time.Now())
+
+	assert.Error(t, err, "エラーが返るべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStockVelocity は期間内の変更量の合計を日数で割った値を返すことをテストします
+func TestStockVelocity(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   time.Duration
+		net      int
+		expected float64
+	}{
+		// 7日間に+100, -20, -10の変更があった商品は1日あたり+10
+		{name: "7日間", window: 7 * 24 * time.Hour, net: 70, expected: 10},
+		{name: "1日未満の期間", window: 12 * time.Hour, net: -6, expected: -12},
+		{name: "変更なし", window: 30 * 24 * time.Hour, net: 0, expected: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+			mock.ExpectQuery(sqlPattern(SQLNetDeltaSince)).
+				WithArgs("apple", aroundTime{want: time.Now().Add(-tc.window)}).
+				WillReturnRows(sqlmock.NewRows([]string{"net"}).AddRow(tc.net))
+
+			velocity, err := StockVelocity(db, "apple", tc.window)
+
+			assert.NoError(t, err)
+			assert.InDelta(t, tc.expected, velocity, 1e-9)
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestStockVelocity_InvalidWindow は期間が0以下の場合にクエリを実行せずエラーを返すことをテストします
+func TestStockVelocity_InvalidWindow(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	_, err := StockVelocity(db, "apple", 0)
+
+	assert.ErrorIs(t, err, ErrInvalidWindow)
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_AuditLog は変更履歴が有効な場合に同じトランザクションで記録されることをテストします
+func TestUpsertStock_AuditLog(t *testing.T) {
+	withAuditLog(t)
+
+	t.Run("新規挿入", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+			WithArgs("banana").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+			WithArgs("banana", 50).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO stock_log \(name, operation, delta, amount\) VALUES \(\?, \?, \?, \?\);`).
+			WithArgs("banana", operationInsert, 50, 50).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, UpsertStock(db, "banana", 50), "UpsertStockは成功するべき")
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("更新", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+			WithArgs(70, "apple").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO stock_log`).
+			WithArgs("apple", operationUpdate, -30, 70).
+			WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, UpsertStock(db, "apple", -30), "UpsertStockは成功するべき")
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("記録エラーでロールバック", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+			WithArgs(110, "apple").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO stock_log`).
+			WillReturnError(errors.New("log error"))
+		mock.ExpectRollback()
+
+		err := UpsertStock(db, "apple", 10)
+		assert.ErrorContains(t, err, "変更履歴の記録エラー", "記録エラーが返るべき")
+		verifyExpectations(t, mock)
+	})
+}
+
+const productHistoryRegex = `SELECT id, operation, delta, amount, created_at FROM stock_log WHERE name = \? ORDER BY created_at, id;`
+
+// TestProductHistoryJSON は変更履歴と現在の在庫数がJSONで書き出されることをテストします
+func TestProductHistoryJSON(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	t1 := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	t2 := time.Date(2025, 3, 2, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(70))
+	mock.ExpectQuery(productHistoryRegex).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "operation", "delta", "amount", "created_at"}).
+			AddRow(1, operationInsert, 100, 100, t1).
+			AddRow(2, operationUpdate, -30, 70, t2))
+
+	var buf bytes.Buffer
+	err := ProductHistoryJSON(db, "apple", &buf)
+	assert.NoError(t, err, "エラーが発生すべきでない")
+	verifyExpectations(t, mock)
+
+	var got struct {
+		Name          string `json:"name"`
+		CurrentAmount *int   `json:"current_amount"`
+		History       []struct {
+			ID        int64     `json:"id"`
+			Operation string    `json:"operation"`
+			Delta     int       `json:"delta"`
+			Amount    int       `json:"amount"`
+			CreatedAt time.Time `json:"created_at"`
+		} `json:"history"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &got), "出力は正しいJSONであるべき")
+	assert.Equal(t, "apple", got.Name)
+	if assert.NotNil(t, got.CurrentAmount, "現在の在庫数を含むべき") {
+		assert.Equal(t, 70, *got.CurrentAmount)
+	}
+	if assert.Len(t, got.History, 2, "履歴は2件であるべき") {
+		assert.Equal(t, operationInsert, got.History[0].Operation)
+		assert.Equal(t, -30, got.History[1].Delta)
+		assert.True(t, got.History[1].CreatedAt.Equal(t2), "時刻が保持されるべき")
+	}
+}
+
+// TestProductHistoryJSON_NoStock は在庫も履歴もない商品でnullと空配列が書き出されることをテストします
+func TestProductHistoryJSON_NoStock(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?;`).
+		WithArgs("ghost").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(productHistoryRegex).
+		WithArgs("ghost").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "operation", "delta", "amount", "created_at"}))
+
+	var buf bytes.Buffer
+	assert.NoError(t, ProductHistoryJSON(db, "ghost", &buf))
+	assert.JSONEq(t, `{"name":"ghost","current_amount":null,"history":[]}`, buf.String())
+	verifyExpectations(t, mock)
+}
+
+// TestProductHistoryJSON_QueryError は履歴の取得エラーが返り、何も書き出されないことをテストします
+func TestProductHistoryJSON_QueryError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(70))
+	mock.ExpectQuery(productHistoryRegex).
+		WithArgs("apple").
+		WillReturnError(errors.New("table locked"))
+
+	var buf bytes.Buffer
+	err := ProductHistoryJSON(db, "apple", &buf)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "table locked")
+	assert.Empty(t, buf.String(), "エラー時は何も書き出さないべき")
+	verifyExpectations(t, mock)
+}
+
+func TestChangesByType(t *testing.T) {
+	createdAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		opType string
+		delta  int
+		amount int
+	}{
+		{opType: operationInsert, delta: 50, amount: 50},
+		{opType: operationUpdate, delta: -20, amount: 30},
+		{opType: operationDelete, delta: -30, amount: 0},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.opType, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectQuery(`SELECT id, name, operation, delta, amount, created_at FROM stock_log WHERE operation = \? ORDER BY created_at DESC, id DESC LIMIT \?;`).
+				WithArgs(tc.opType, 10).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "operation", "delta", "amount", "created_at"}).
+					AddRow(3, "apple", tc.opType, tc.delta, tc.amount, createdAt))
+
+			changes, err := ChangesByType(db, tc.opType, 10)
+
+			assert.NoError(t, err)
+			assert.Equal(t, []StockChange{
+				{ID: 3, Name: "apple", Operation: tc.opType, Delta: tc.delta, Amount: tc.amount, CreatedAt: createdAt},
+			}, changes)
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestChangesByType_InvalidOperation は許可されていない操作の種類ではクエリを発行しないことをテストします
+func TestChangesByType_InvalidOperation(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	for _, opType := range []string{"", "INSERT", "truncate", "update' OR '1'='1"} {
+		changes, err := ChangesByType(db, opType, 10)
+
+		assert.True(t, errors.Is(err, ErrInvalidOperation), "%q はErrInvalidOperationになるべき", opType)
+		assert.Nil(t, changes)
+	}
+	verifyExpectations(t, mock)
+}
+
+// changeRows はstock_logの行を返すsqlmockの行セットを作ります
+func changeRows(createdAt time.Time, ids ...int64) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "name", "operation", "delta", "amount", "created_at"})
+	for _, id := range ids {
+		rows.AddRow(id, "apple", operationUpdate, 1, int(id), createdAt)
+	}
+	return rows
+}
+
+// TestListChangesAfter は返した最後のidを次のページのカーソルにして最後まで読み進め、最後は空のページになることをテストします
+func TestListChangesAfter(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	createdAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(sqlPattern(SQLChangesAfterID)).WithArgs(0, 2).WillReturnRows(changeRows(createdAt, 1, 2))
+	mock.ExpectQuery(sqlPattern(SQLChangesAfterID)).WithArgs(2, 2).WillReturnRows(changeRows(createdAt, 5))
+	mock.ExpectQuery(sqlPattern(SQLChangesAfterID)).WithArgs(5, 2).WillReturnRows(changeRows(createdAt))
+
+	var ids []int64
+	var pages int
+	cursor := int64(0)
+	for {
+		page, err := ListChangesAfter(db, cursor, 2)
+		require.NoError(t, err)
+		pages++
+		if len(page) == 0 {
+			break
+		}
+		for _, c := range page {
+			ids = append(ids, c.ID)
+		}
+		cursor = page[len(page)-1].ID
+	}
+
+	assert.Equal(t, []int64{1, 2, 5}, ids)
+	assert.Equal(t, 3, pages, "最後は空のページになるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestListChangesAfter_InvalidLimit はページの件数が0以下の場合にクエリを発行しないことをテストします
+func TestListChangesAfter_InvalidLimit(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	_, err := ListChangesAfter(db, 0, 0)
+
+	assert.ErrorIs(t, err, ErrInvalidPageSize)
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLockTimeout はAcquireLockで指定した時間内にロックを取得できなかった場合に返されます。
+	ErrLockTimeout = errors.New("ロックを取得できませんでした（タイムアウト）")
+	// ErrLockNotHeld はRELEASE_LOCKの時点で、ロックをこの接続が保持していなかった場合に返されます。
+	ErrLockNotHeld = errors.New("ロックを保持していません")
+)
+
+// AcquireLock はMySQLのGET_LOCKで名前付きのロックを取得します。複数のインスタンスで1つだけ実行したい処理の排他に使います。
+// timeout以内に取得できなかった場合はErrLockTimeoutを返します。
+// GET_LOCKのロックは接続に結び付くため、取得に使った接続は戻り値のreleaseを呼ぶまでプールに返しません。
+// releaseはRELEASE_LOCKでロックを解放して接続をプールに返します。2回目以降の呼び出しは解放せずに最初の結果を返します。
+func AcquireLock(db *sql.DB, name string, timeout time.Duration) (release func() error, err error) {
+	return AcquireLockContext(context.Background(), db, name, timeout)
+}
+
+// AcquireLockContext はAcquireLockのcontext対応版です。
+func AcquireLockContext(ctx context.Context, db *sql.DB, name string, timeout time.Duration) (release func() error, err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("接続の取得エラー: %w", err)
+	}
+
+	// GET_LOCKは取得できれば1、タイムアウトすれば0、エラーの場合はNULLを返す
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?);", name, timeout.Seconds()).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ロック %s の取得エラー: %w", name, err)
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s (%s)", ErrLockTimeout, name, timeout)
+	}
+
+	var (
+		once       sync.Once
+		releaseErr error
+	)
+	return func() error {
+		once.Do(func() {
+			releaseErr = releaseLock(conn, name)
+		})
+		return releaseErr
+	}, nil
+}
+
+// releaseLock はRELEASE_LOCKでロックを解放し、接続をプールに返します。
+// conn.Closeは接続をプールに返すだけでセッションは残るため、RELEASE_LOCKに失敗した場合は
+// ロックを保持したままの接続が再利用されないよう、接続を破棄してMySQLにセッションのロックを解放させます。
+func releaseLock(conn *sql.Conn, name string) error {
+	defer conn.Close()
+
+	// 呼び出し元のctxがキャンセルされていても、独立したcontextでロックは解放する
+	ctx, cancel := cleanupContext()
+	defer cancel()
+	var released sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?);", name).Scan(&released); err != nil {
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+		return fmt.Errorf("ロック %s の解放エラー: %w", name, err)
+	}
+	if !released.Valid || released.Int64 != 1 {
+		return fmt.Errorf("%w: %s", ErrLockNotHeld, name)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAcquireLock はロックを取得し、releaseでRELEASE_LOCKを発行することをテストします
+func TestAcquireLock(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\);`).
+		WithArgs("nightly-job", 5.0).
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(1))
+	mock.ExpectQuery(`SELECT RELEASE_LOCK\(\?\);`).
+		WithArgs("nightly-job").
+		WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(1))
+
+	release, err := AcquireLock(db, "nightly-job", 5*time.Second)
+	assert.NoError(t, err)
+	if assert.NotNil(t, release) {
+		assert.NoError(t, release())
+		assert.NoError(t, release(), "2回目の呼び出しは解放しないべき")
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestAcquireLock_Timeout は時間内に取得できなかった場合にErrLockTimeoutを返すことをテストします
+func TestAcquireLock_Timeout(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\);`).
+		WithArgs("nightly-job", 0.5).
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(0))
+
+	release, err := AcquireLock(db, "nightly-job", 500*time.Millisecond)
+
+	assert.True(t, errors.Is(err, ErrLockTimeout), "ErrLockTimeoutを返すべき: %v", err)
+	assert.Nil(t, release)
+	verifyExpectations(t, mock)
+}
+
+// TestAcquireLock_Error はGET_LOCKがNULLを返した場合やクエリに失敗した場合にエラーを返すことをテストします
+func TestAcquireLock_Error(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\);`).
+		WithArgs("nightly-job", 1.0).
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(nil))
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\);`).
+		WithArgs("nightly-job", 1.0).
+		WillReturnError(errors.New("connection lost"))
+
+	_, err := AcquireLock(db, "nightly-job", time.Second)
+	assert.True(t, errors.Is(err, ErrLockTimeout), "NULLは取得できなかったものとして扱うべき: %v", err)
+
+	_, err = AcquireLock(db, "nightly-job", time.Second)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrLockTimeout))
+	verifyExpectations(t, mock)
+}
+
+// TestAcquireLock_ReleaseNotHeld はRELEASE_LOCKの時点でロックを保持していなかった場合にErrLockNotHeldを返すことをテストします
+func TestAcquireLock_ReleaseNotHeld(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\);`).
+		WithArgs("nightly-job", 1.0).
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(1))
+	mock.ExpectQuery(`SELECT RELEASE_LOCK\(\?\);`).
+		WithArgs("nightly-job").
+		WillReturnRows(sqlmock.NewRows([]string{"RELEASE_LOCK"}).AddRow(0))
+
+	release, err := AcquireLock(db, "nightly-job", time.Second)
+	assert.NoError(t, err)
+
+	err = release()
+	assert.True(t, errors.Is(err, ErrLockNotHeld), "ErrLockNotHeldを返すべき: %v", err)
+	verifyExpectations(t, mock)
+}
+
+// TestAcquireLock_ReleaseError はRELEASE_LOCKに失敗した場合に、ロックを保持したままの接続をプールに返さず破棄することをテストします
+func TestAcquireLock_ReleaseError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\);`).
+		WithArgs("nightly-job", 1.0).
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(1))
+	mock.ExpectQuery(`SELECT RELEASE_LOCK\(\?\);`).
+		WithArgs("nightly-job").
+		WillReturnError(errors.New("read timeout"))
+	mock.ExpectClose()
+
+	release, err := AcquireLock(db, "nightly-job", time.Second)
+	assert.NoError(t, err)
+
+	err = release()
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrLockNotHeld))
+	assert.Equal(t, 0, db.Stats().OpenConnections, "解放に失敗した接続は破棄されるべき")
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UpsertStockLWW は書き込み側が指定した時刻tsによる後勝ち（last-write-wins）で在庫数をamountにします。
+// tsが保存されているupdated_atより新しい場合だけ在庫数を書き込み、updated_atをtsにします。
+// tsがupdated_at以前の古い書き込みは何も変更せずに無視し、エラーも返しません。
+// updated_atがNULLの行と存在しない商品にはそのまま書き込みます。
+// stocksにupdated_at列が必要です（stockAgingMigrationで追加します）。
+func UpsertStockLWW(db *sql.DB, name string, amount int, ts time.Time) error {
+	return UpsertStockLWWContext(context.Background(), db, name, amount, ts)
+}
+
+// UpsertStockLWWContext はUpsertStockLWWのcontext対応版です。
+func UpsertStockLWWContext(ctx context.Context, db *sql.DB, name string, amount int, ts time.Time) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+	defer tx.Rollback() // エラー発生時にロールバック
+
+	var current int
+	var updatedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, taggedSQL(ctx, "UpsertStockLWW", SQLLastWriteForUpdate), name).Scan(&current, &updatedAt)
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("データ確認中にエラーが発生: %w", newQueryError(ctx, "UpsertStockLWW", SQLLastWriteForUpdate, err))
+	}
+	if exists && updatedAt.Valid && !ts.After(updatedAt.Time) {
+		// 保存されている書き込みの方が新しい
+		return nil
+	}
+
+	if err := writeDeltaTx(ctx, tx, "UpsertStockLWW", name, current, exists, amount-current); err != nil {
+		return err
+	}
+	// ON UPDATE CURRENT_TIMESTAMPによる現在時刻ではなく、書き込み側の時刻を保存する
+	if _, err := tx.ExecContext(ctx, taggedSQL(ctx, "UpsertStockLWW", SQLSetUpdatedAt), ts, name); err != nil {
+		return fmt.Errorf("データ更新エラー: %w", newQueryError(ctx, "UpsertStockLWW", SQLSetUpdatedAt, err))
+	}
+	if err := bumpGeneration(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("トランザクションコミットエラー: %w", err)
+	}
+	return nil
+}
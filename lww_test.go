@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectLastWrite は行ロックを取得して在庫数と最終更新日時を確認するSELECTを期待値として設定します
+func expectLastWrite(mock sqlmock.Sqlmock, name string) *sqlmock.ExpectedQuery {
+	return mock.ExpectQuery(sqlPattern(SQLLastWriteForUpdate)).WithArgs(name)
+}
+
+// TestUpsertStockLWW_NewerWrite は保存されている最終更新日時より新しい書き込みが在庫数と最終更新日時を書き換えることをテストします
+func TestUpsertStockLWW_NewerWrite(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	stored := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	ts := stored.Add(time.Minute)
+
+	mock.ExpectBegin()
+	expectLastWrite(mock, "apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount", "updated_at"}).AddRow(10, stored))
+	expectUpdateAmount(mock, "apple", 25).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(sqlPattern(SQLSetUpdatedAt)).WithArgs(ts, "apple").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, UpsertStockLWW(db, "apple", 25, ts))
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStockLWW_StaleWrite は最終更新日時以前の書き込みを何も変更せずに無視することをテストします
+func TestUpsertStockLWW_StaleWrite(t *testing.T) {
+	stored := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for _, ts := range []time.Time{stored.Add(-time.Minute), stored} {
+		db, mock, _ := setupMockDB(t)
+		mock.ExpectBegin()
+		expectLastWrite(mock, "apple").
+			WillReturnRows(sqlmock.NewRows([]string{"amount", "updated_at"}).AddRow(10, stored))
+		mock.ExpectRollback()
+
+		assert.NoError(t, UpsertStockLWW(db, "apple", 25, ts))
+		verifyExpectations(t, mock)
+		db.Close()
+	}
+}
+
+// TestUpsertStockLWW_Insert は存在しない商品をそのまま登録し、最終更新日時を書き込み側の時刻にすることをテストします
+func TestUpsertStockLWW_Insert(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	ts := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	expectLastWrite(mock, "apple").WillReturnError(sql.ErrNoRows)
+	expectInsertStock(mock, "apple", 25).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(sqlPattern(SQLSetUpdatedAt)).WithArgs(ts, "apple").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, UpsertStockLWW(db, "apple", 25, ts))
+	verifyExpectations(t, mock)
+}
@@ -1,58 +1,271 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
 )
 
 // mainProcessは、商品名と数量を受け取って処理を行います。
 // main()からの呼び出し時にはハードコードした値を渡し、
 // テスト時には任意の値をモックできるようになります。
-func mainProcess(db *sql.DB, productName string, amount int) error {
+// DB操作はrepo、出力はwを通して行うため、テストでは任意の実装とバッファを渡せます。
+// ctxはrepoの全操作に渡され、キャンセルや期限はDBへの問い合わせにも反映されます。
+// stocksテーブルが存在しない場合、autoMigrateが有効であればスキーマを作成して一度だけ再実行します。
+// OnEventを指定すると、検索や更新の結果をStockEventとして受け取れます。
+// WithMaxDurationを指定すると全体の処理時間に上限を設け、超えた場合はその時点の段階（ping/query/upsert/schema）を含む
+// BudgetExceededErrorを返します。
+func mainProcess(ctx context.Context, w io.Writer, repo StockRepository, productName string, amount int, opts ...ProcessOption) error {
+	options := newProcessOptions(opts)
+	if options.maxDuration <= 0 {
+		return runMainProcess(ctx, w, repo, productName, amount, options)
+	}
+
+	parent := ctx
+	ctx, marker := withPhaseMarker(ctx)
+	ctx, cancel := context.WithTimeout(ctx, options.maxDuration)
+	defer cancel()
+	err := runMainProcess(ctx, w, repo, productName, amount, options)
+	// 呼び出し元の期限やキャンセルではなく、上限を超えた場合だけ区別する
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+		return &BudgetExceededError{Phase: marker.current(), Budget: options.maxDuration, Err: err}
+	}
+	return err
+}
+
+// runMainProcess はmainProcessの本体です。
+func runMainProcess(ctx context.Context, w io.Writer, repo StockRepository, productName string, amount int, options processOptions) error {
+	err := processStock(ctx, w, repo, productName, amount, options)
+	if errors.Is(err, ErrSuspiciousChange) {
+		return fmt.Errorf("%w: 意図した変更であれば--forceを付けて再実行してください", err)
+	}
+	if !errors.Is(err, ErrSchemaMissing) {
+		return err
+	}
+
+	if !autoMigrate {
+		return fmt.Errorf("%w: init-dbサブコマンドでテーブルを作成するか、--auto-migrateを指定して再実行してください", err)
+	}
+
+	fmt.Fprintln(w, "stocksテーブルが存在しないため、テーブルを作成して再実行します")
+	markPhase(ctx, phaseSchema)
+	if err := repo.EnsureSchema(ctx); err != nil {
+		return fmt.Errorf("スキーマの自動作成に失敗しました: %w", err)
+	}
+	// 再実行は一度だけ行い、再びスキーマエラーになってもループしない
+	return processStock(ctx, w, repo, productName, amount, options)
+}
+
+// processStock は接続確認・在庫の検索・在庫の更新を順に行います。
+func processStock(ctx context.Context, w io.Writer, repo StockRepository, productName string, amount int, options processOptions) error {
 	// 接続確認
-	if err := PingDB(db); err != nil {
-		return fmt.Errorf("DB接続確認に失敗しました: %v", err)
+	markPhase(ctx, phasePing)
+	if err := Do(ctx, retryPolicy(retryConnect), repo.Ping); err != nil {
+		return fmt.Errorf("DB接続確認に失敗しました: %w", err)
 	}
 
 	// stocksテーブルから"name"が"apple"のレコードを取得
-	results, err := QueryStocks(db, productName)
+	markPhase(ctx, phaseQuery)
+	results, err := repo.QueryStocks(ctx, productName)
 	if err != nil {
-		return fmt.Errorf("クエリ実行に失敗しました: %v", err)
+		return fmt.Errorf("クエリ実行に失敗しました: %w", classifyError(err))
 	}
+	options.emit(StockEvent{Type: EventQueried, Name: productName, Items: results})
 
 	// 取得結果の表示
 	if len(results) == 0 {
-		fmt.Println("結果が見つかりませんでした。")
+		fmt.Fprintln(w, "結果が見つかりませんでした。")
 	} else {
-		fmt.Printf("全ての行: %v\n", results)
+		fmt.Fprintf(w, "全ての行: %v\n", results)
 	}
 
-	fmt.Println("クエリの実行が完了しました。")
+	fmt.Fprintln(w, "クエリの実行が完了しました。")
 
 	// 例: "apple"の在庫を200追加
-	err = UpsertStock(db, productName, amount)
+	var opts []UpsertOption
+	if forceLargeChange {
+		opts = append(opts, ForceLargeChange())
+	}
+	markPhase(ctx, phaseUpsert)
+	err = repo.UpsertStock(ctx, productName, amount, opts...)
 	if err != nil {
-		return fmt.Errorf("在庫更新エラー: %v", err)
+		if isRejection(err) {
+			options.emit(StockEvent{Type: EventWarning, Name: productName, Err: err})
+		}
+		return fmt.Errorf("在庫更新エラー: %w", classifyError(err))
 	}
-	fmt.Println("在庫データが更新されました")
+	fmt.Fprintln(w, "在庫データが更新されました")
+
+	// UpsertStockが返った時点でトランザクションは終了しているため、コールバック内でDBを参照できる
+	options.emit(upsertEvent(productName, amount, results))
 	return nil
 }
 
 func main() {
+	// 任意機能の設定は設定ファイル、環境変数の順に読み込み、コマンドラインの指定で上書きする
+	if path, ok := os.LookupEnv(featuresFileEnv); ok {
+		if err := features.LoadFile(path); err != nil {
+			log.Fatalf("設定の読み込みに失敗しました: %v", err)
+		}
+	}
+	if err := features.LoadEnv(os.LookupEnv); err != nil {
+		log.Fatalf("設定の読み込みに失敗しました: %v", err)
+	}
+	features.RegisterFlags(flag.CommandLine)
+	flag.BoolVar(&autoMigrate, "auto-migrate", autoMigrate, "stocksテーブルが存在しない場合に自動で作成する")
+	flag.BoolVar(&forceLargeChange, "force", forceLargeChange, "上限を超える在庫数の変更も適用する")
+	flag.BoolVar(&maintenanceModeOnStart, "maintenance", maintenanceModeOnStart, "メンテナンスモード（読み取りのみ許可）で起動する")
+	flag.DurationVar(&maxDuration, "max-duration", maxDuration, "処理全体の時間の上限（例: 30s、0の場合は上限なし）")
+	flag.BoolVar(&verboseErrors, "verbose", verboseErrors, "失敗した場合にエラーの分類と対処をまとめたレポートを出力する")
+	flag.BoolVar(&queryTaggingEnabled, "query-tags", queryTaggingEnabled, "SQL文の先頭に操作名と操作IDのコメントを付ける")
+	flag.StringVar(&pushMetricsURL, "push-metrics", pushMetricsURL, "終了時にメトリクスを送信するPushgatewayのURL")
+	flag.Parse()
+	SetMaintenanceMode(maintenanceModeOnStart)
+
+	// 以降の失敗はexitWithErrorが送信するため、正常に終了した場合だけ送信する。
+	// log.Fatalfやos.Exitで終了すると送信されないため、ここから先はexitWithErrorで終了すること
+	if pushMetricsURL != "" {
+		activeRunMetrics = NewRunMetrics(flag.Arg(0))
+		defer finishRun(nil)
+	}
+
 	// 固定値はここで定義
 	productName := "apple"
 	amount := 200
 
+	// 商品名ルールは起動時に一度だけコンパイルする
+	if err := LoadNameRules(); err != nil {
+		exitWithError("設定の読み込みに失敗しました", err)
+	}
+	if err := features.Validate(); err != nil {
+		exitWithError("設定の読み込みに失敗しました", err)
+	}
+	nPlusOneDetector = newFeatureNPlusOneDetector(features)
+
 	db, err := ConnectDB()
 	if err != nil {
-		log.Fatalf("DB接続に失敗しました: %v", err)
+		exitWithError("DB接続に失敗しました", err)
 	}
 	defer db.Close()
 
+	// シグナルのハンドラはserveなどのサブコマンドでも使えるよう、サブコマンドの処理より前に登録する。
+	// ステートメントはCheckStockColumnsで列を確認した後に準備するため、ここではキャッシュだけを作る
+	var stmtCache *StmtCache
+	if prepareStatementsOnStartup {
+		stmtCache = NewStmtCache()
+		defer stmtCache.Close()
+	}
+
+	// SIGQUITで内部状態を標準エラー出力へダンプする
+	stopDebugDump := installDebugDump(db, stmtCache)
+	defer stopDebugDump()
+
+	// SIGUSR2でメンテナンスモードを切り替える
+	stopMaintenanceToggle := installMaintenanceToggle()
+	defer stopMaintenanceToggle()
+
+	// サブコマンドの処理
+	switch flag.Arg(0) {
+	case "init-db":
+		if err := EnsureSchema(db); err != nil {
+			exitWithError("テーブル作成に失敗しました", err)
+		}
+		fmt.Println("テーブルを作成し、マイグレーションを適用しました")
+		return
+	case "import":
+		if err := runImportCommand(os.Stdout, db, flag.Args()[1:]); err != nil {
+			exitWithError("取り込みに失敗しました", err)
+		}
+		return
+	case "backfill-history":
+		if err := runBackfillHistoryCommand(context.Background(), os.Stdout, db, flag.Args()[1:]); err != nil {
+			exitWithError("変更履歴の補完に失敗しました", err)
+		}
+		return
+	case "serve":
+		if err := runServeCommand(context.Background(), os.Stdout, db, flag.Args()[1:]); err != nil {
+			exitWithError("サーバーの実行に失敗しました", err)
+		}
+		return
+	case "health":
+		status := HealthCheck(db)
+		writeHealth(os.Stdout, status)
+		if err := status.Err(); err != nil {
+			exitWithError("ヘルスチェックに失敗しました", err)
+		}
+		return
+	case "export":
+		if err := runExportCommand(context.Background(), os.Stdout, db, flag.Args()[1:]); err != nil {
+			exitWithError("エクスポートに失敗しました", err)
+		}
+		return
+	case "report":
+		if err := runReportCommand(context.Background(), os.Stdout, db, flag.Args()[1:]); err != nil {
+			exitWithError("レポートの作成に失敗しました", err)
+		}
+		return
+	case "stale":
+		if err := runStaleCommand(context.Background(), os.Stdout, db, flag.Args()[1:]); err != nil {
+			exitWithError("滞留在庫の取得に失敗しました", err)
+		}
+		return
+	case "compare":
+		if err := runCompareCommand(context.Background(), os.Stdout, flag.Args()[1:]); err != nil {
+			exitWithError("在庫データの比較に失敗しました", err)
+		}
+		return
+	case "":
+	default:
+		exitWithError("サブコマンドを実行できません", fmt.Errorf("%w: %s", ErrUnknownSubcommand, flag.Arg(0)))
+	}
+
+	// 列定義とテーブル定義のずれを起動時に検出する
+	if err := CheckStockColumns(db); err != nil {
+		exitWithError("スキーマの確認に失敗しました", err)
+	}
+	if categoryColumnMissing.Load() {
+		log.Printf("stocksテーブルにcategory列がないため、カテゴリを扱わずに実行します（init-dbでマイグレーションを適用してください）")
+	}
+
+	// 初回利用時のPrepareによる遅延を避けるため、設定されていればステートメントを事前準備し、リポジトリの読み取りで使う
+	if stmtCache != nil {
+		if err := stmtCache.PrepareAll(context.Background(), db); err != nil {
+			log.Printf("一部のステートメント準備に失敗しました: %v", err)
+		}
+	}
+
+	// 有効になっている任意機能のデコレータを重ねる
+	base := NewSQLStockRepository(db)
+	if stmtCache != nil {
+		base = NewCachedSQLStockRepository(db, stmtCache)
+	}
+	repo, closeRepo, err := assembleRepository(currentDBConfig(), base)
+	if err != nil {
+		exitWithError("設定の読み込みに失敗しました", err)
+	}
+	defer closeRepo()
+
 	// 処理を委譲
-	err = mainProcess(db, productName, amount)
+	err = mainProcess(context.Background(), os.Stdout, repo, productName, amount, WithMaxDuration(maxDuration))
 	if err != nil {
-		log.Fatalf("処理に失敗しました: %v", err)
+		exitWithError("処理に失敗しました", err)
+	}
+}
+
+// exitWithError はエラーを出力し、メトリクスを送信して終了します。verboseErrorsが有効な場合はErrorReportも標準エラー出力へ書き出します。
+// メンテナンスモードによる拒否は他の失敗と区別できるよう、exitCodeMaintenanceで終了します。
+func exitWithError(message string, err error) {
+	if verboseErrors {
+		ErrorReport(err).Write(os.Stderr)
+	}
+	if errors.Is(err, ErrMaintenanceMode) {
+		log.Printf("%s: %v（メンテナンスの終了後に再実行してください）", message, err)
+	} else {
+		log.Printf("%s: %v", message, err)
 	}
+	os.Exit(finishRun(err))
 }
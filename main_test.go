@@ -2,49 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
-	"os"
+	"io"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 )
 
-// captureOutput は標準出力をリダイレクトして、関数実行中の出力をキャプチャします。
-func captureOutput(f func()) string {
-	// 標準出力のバックアップ
-	oldStdout := os.Stdout
-
-	// os.Pipe() の作成
-	r, w, err := os.Pipe()
-	if err != nil {
-		panic("os.Pipe() エラー: " + err.Error())
-	}
-
-	// 標準出力をパイプの書き込み側に変更
-	os.Stdout = w
-	defer func() {
-		os.Stdout = oldStdout
-	}()
-
-	// 指定関数の実行
-	f()
-
-	// 書き込み側を閉じる
-	w.Close()
-
-	// パイプから出力を読み取る
-	var buf bytes.Buffer
-	_, err = buf.ReadFrom(r)
-	if err != nil {
-		panic("buf.ReadFrom() エラー: " + err.Error())
-	}
-	r.Close()
-
-	return buf.String()
-}
-
 /* =============================
    テストケース：mainProcessの動作
    ============================= */
@@ -59,7 +26,7 @@ func TestMainFunctionWithMock(t *testing.T) {
 	mock.ExpectPing()
 
 	// 「apple」検索クエリと結果のモック設定
-	mock.ExpectQuery(`SELECT \* FROM stocks WHERE name = \?;`).
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
 		WithArgs("apple").
 		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).
 			AddRow(1, "apple", 100))
@@ -74,11 +41,11 @@ func TestMainFunctionWithMock(t *testing.T) {
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
-	// mainProcessの実行と出力キャプチャ
-	output := captureOutput(func() {
-		err := mainProcess(db, "apple", 200)
-		assert.NoError(t, err, "mainProcessは成功するべき")
-	})
+	// mainProcessの実行と出力の取得
+	var buf bytes.Buffer
+	err = mainProcess(context.Background(), &buf, NewSQLStockRepository(db), "apple", 200)
+	assert.NoError(t, err, "mainProcessは成功するべき")
+	output := buf.String()
 
 	// モックの期待通りにクエリが実行されたか確認
 	assert.NoError(t, mock.ExpectationsWereMet(), "期待されたすべてのクエリが実行されるべき")
@@ -107,7 +74,7 @@ func TestMainProcess_ConnectionError(t *testing.T) {
 	mock.ExpectPing().WillReturnError(errors.New("接続エラー"))
 
 	// mainProcessの実行
-	err = mainProcess(db, "apple", 200)
+	err = mainProcess(context.Background(), io.Discard, NewSQLStockRepository(db), "apple", 200)
 	assert.Error(t, err, "DB接続確認エラーが発生するべき")
 	assert.Contains(t, err.Error(), "DB接続確認に失敗", "適切なエラーメッセージを含むべき")
 	assert.NoError(t, mock.ExpectationsWereMet(), "期待されたすべてのクエリが実行されるべき")
@@ -122,11 +89,11 @@ func TestMainProcess_QueryError(t *testing.T) {
 	mock.ExpectPing()
 
 	// 「apple」検索クエリでエラーを返す設定
-	mock.ExpectQuery(`SELECT \* FROM stocks WHERE name = \?;`).
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
 		WithArgs("apple").
 		WillReturnError(errors.New("クエリエラー"))
 
-	err = mainProcess(db, "apple", 200)
+	err = mainProcess(context.Background(), io.Discard, NewSQLStockRepository(db), "apple", 200)
 	assert.Error(t, err, "クエリエラーが発生するべき")
 	assert.Contains(t, err.Error(), "クエリ実行に失敗", "適切なエラーメッセージを含むべき")
 	assert.NoError(t, mock.ExpectationsWereMet(), "期待されたすべてのクエリが実行されるべき")
@@ -141,7 +108,7 @@ func TestMainProcess_UpsertError(t *testing.T) {
 	mock.ExpectPing()
 
 	// 「apple」検索クエリで結果取得
-	mock.ExpectQuery(`SELECT \* FROM stocks WHERE name = \?;`).
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
 		WithArgs("apple").
 		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).
 			AddRow(1, "apple", 100))
@@ -151,7 +118,7 @@ func TestMainProcess_UpsertError(t *testing.T) {
 		WithArgs("apple").
 		WillReturnError(errors.New("データ取得エラー"))
 
-	err = mainProcess(db, "apple", 200)
+	err = mainProcess(context.Background(), io.Discard, NewSQLStockRepository(db), "apple", 200)
 	assert.Error(t, err, "データ更新エラーが発生するべき")
 	assert.Contains(t, err.Error(), "在庫更新エラー", "適切なエラーメッセージを含むべき")
 	assert.NoError(t, mock.ExpectationsWereMet(), "期待されたすべてのクエリが実行されるべき")
@@ -166,7 +133,7 @@ func TestMainProcess_EmptyResult(t *testing.T) {
 	mock.ExpectPing()
 
 	// 空の検索結果を返すモック設定
-	mock.ExpectQuery(`SELECT \* FROM stocks WHERE name = \?;`).
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
 		WithArgs("nonexistent").
 		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}))
 
@@ -180,10 +147,10 @@ func TestMainProcess_EmptyResult(t *testing.T) {
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
-	output := captureOutput(func() {
-		err := mainProcess(db, "nonexistent", 50)
-		assert.NoError(t, err, "mainProcessは成功するべき")
-	})
+	var buf bytes.Buffer
+	err = mainProcess(context.Background(), &buf, NewSQLStockRepository(db), "nonexistent", 50)
+	assert.NoError(t, err, "mainProcessは成功するべき")
+	output := buf.String()
 
 	assert.NoError(t, mock.ExpectationsWereMet(), "期待されたすべてのクエリが実行されるべき")
 	assert.Contains(t, output, "結果が見つかりませんでした", "該当メッセージが出力されるべき")
@@ -199,7 +166,7 @@ func TestMainProcess_NewItemInsert(t *testing.T) {
 	mock.ExpectPing()
 
 	// 「banana」の検索クエリでデータが存在しない状態
-	mock.ExpectQuery(`SELECT \* FROM stocks WHERE name = \?;`).
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
 		WithArgs("banana").
 		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}))
 
@@ -213,10 +180,10 @@ func TestMainProcess_NewItemInsert(t *testing.T) {
 		WillReturnResult(sqlmock.NewResult(2, 1))
 	mock.ExpectCommit()
 
-	output := captureOutput(func() {
-		err := mainProcess(db, "banana", 50)
-		assert.NoError(t, err, "mainProcessは成功するべき")
-	})
+	var buf bytes.Buffer
+	err = mainProcess(context.Background(), &buf, NewSQLStockRepository(db), "banana", 50)
+	assert.NoError(t, err, "mainProcessは成功するべき")
+	output := buf.String()
 
 	assert.NoError(t, mock.ExpectationsWereMet(), "期待されたすべてのクエリが実行されるべき")
 	assert.Contains(t, output, "結果が見つかりませんでした", "該当メッセージが出力されるべき")
@@ -263,3 +230,181 @@ func TestConnectDBWithMock(t *testing.T) {
 		assert.Contains(t, err.Error(), "接続エラー", "エラーメッセージは '接続エラー' を含むべき")
 	})
 }
+
+/* =============================
+   テストケース：テーブルが存在しない場合
+   ============================= */
+
+// TestMainProcess_SchemaMissing はテーブルが存在しない場合に案内メッセージを返すことをテストします
+func TestMainProcess_SchemaMissing(t *testing.T) {
+	db, mock, err := setupMockDB(t)
+	assert.NoError(t, err, "モックDBのセットアップに成功するべき")
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnError(noSuchTableError())
+
+	err = mainProcess(context.Background(), io.Discard, NewSQLStockRepository(db), "apple", 200)
+
+	assert.ErrorIs(t, err, ErrSchemaMissing, "ErrSchemaMissingが返るべき")
+	assert.Contains(t, err.Error(), "init-db", "init-dbサブコマンドの案内が含まれるべき")
+	assert.NoError(t, mock.ExpectationsWereMet(), "期待されたすべてのクエリが実行されるべき")
+}
+
+// TestMainProcess_SchemaMissingAutoMigrate は自動作成が有効な場合にテーブルを作成して一度だけ再実行することをテストします
+func TestMainProcess_SchemaMissingAutoMigrate(t *testing.T) {
+	original := autoMigrate
+	autoMigrate = true
+	t.Cleanup(func() { autoMigrate = original })
+
+	t.Run("作成後の再実行に成功", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+			WithArgs("apple").
+			WillReturnError(noSuchTableError())
+		expectEnsureSchema(mock)
+
+		// 再実行
+		mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+			WithArgs("apple").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}))
+		mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+			WithArgs("apple").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+			WithArgs("apple", 200).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		var buf bytes.Buffer
+		err := mainProcess(context.Background(), &buf, NewSQLStockRepository(db), "apple", 200)
+		assert.NoError(t, err, "テーブル作成後の再実行は成功するべき")
+		output := buf.String()
+
+		assert.Contains(t, output, "テーブルを作成して再実行します", "自動作成のメッセージが出力されるべき")
+		assert.NoError(t, mock.ExpectationsWereMet(), "期待されたすべてのクエリが実行されるべき")
+	})
+
+	t.Run("再実行でも失敗した場合はループしない", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+			WithArgs("apple").
+			WillReturnError(noSuchTableError())
+		expectEnsureSchema(mock)
+		mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+			WithArgs("apple").
+			WillReturnError(noSuchTableError())
+
+		err := mainProcess(context.Background(), io.Discard, NewSQLStockRepository(db), "apple", 200)
+
+		assert.ErrorIs(t, err, ErrSchemaMissing, "再実行のエラーがそのまま返るべき")
+		assert.NoError(t, mock.ExpectationsWereMet(), "CREATE TABLEは一度だけ実行されるべき")
+	})
+}
+
+/* =============================
+   テストケース：任意のリポジトリを渡した場合
+   ============================= */
+
+// fakeStockRepository はメモリ上のmapで在庫を保持するテスト用のStockRepositoryです
+type fakeStockRepository struct {
+	stocks    map[string]int
+	pingErr   error
+	upsertErr error
+}
+
+func (f *fakeStockRepository) Ping(ctx context.Context) error {
+	return f.pingErr
+}
+
+func (f *fakeStockRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	results := []map[string]interface{}{}
+	if amount, ok := f.stocks[name]; ok {
+		results = append(results, map[string]interface{}{"name": name, "amount": int64(amount)})
+	}
+	return results, nil
+}
+
+func (f *fakeStockRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	if f.upsertErr != nil {
+		return f.upsertErr
+	}
+	f.stocks[name] += amount
+	return nil
+}
+
+func (f *fakeStockRepository) EnsureSchema(ctx context.Context) error {
+	return nil
+}
+
+// TestMainProcess_FakeRepository はフェイクのリポジトリとバッファでmainProcessをテストします
+func TestMainProcess_FakeRepository(t *testing.T) {
+	tests := []struct {
+		name            string
+		stocks          map[string]int
+		productName     string
+		amount          int
+		expectedAmount  int
+		expectedOutputs []string
+	}{
+		{
+			name:           "既存商品の更新",
+			stocks:         map[string]int{"apple": 100},
+			productName:    "apple",
+			amount:         200,
+			expectedAmount: 300,
+			expectedOutputs: []string{
+				"全ての行:", "apple", "100",
+				"在庫データが更新されました",
+			},
+		},
+		{
+			name:           "新規商品の登録",
+			stocks:         map[string]int{},
+			productName:    "banana",
+			amount:         50,
+			expectedAmount: 50,
+			expectedOutputs: []string{
+				"結果が見つかりませんでした",
+				"在庫データが更新されました",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &fakeStockRepository{stocks: tc.stocks}
+			var buf bytes.Buffer
+
+			err := mainProcess(context.Background(), &buf, repo, tc.productName, tc.amount)
+
+			assert.NoError(t, err, "mainProcessは成功するべき")
+			assert.Equal(t, tc.expectedAmount, repo.stocks[tc.productName], "在庫数が更新されるべき")
+			for _, expected := range tc.expectedOutputs {
+				assert.Contains(t, buf.String(), expected, "出力に '%s' が含まれるべき", expected)
+			}
+		})
+	}
+}
+
+// TestMainProcess_FakeRepositoryErrors はリポジトリのエラーが伝播することをテストします
+func TestMainProcess_FakeRepositoryErrors(t *testing.T) {
+	t.Run("Pingエラー", func(t *testing.T) {
+		repo := &fakeStockRepository{stocks: map[string]int{}, pingErr: errors.New("接続エラー")}
+		err := mainProcess(context.Background(), io.Discard, repo, "apple", 200)
+		assert.ErrorContains(t, err, "DB接続確認に失敗", "適切なエラーメッセージを含むべき")
+	})
+
+	t.Run("更新エラー", func(t *testing.T) {
+		repo := &fakeStockRepository{stocks: map[string]int{}, upsertErr: errors.New("更新失敗")}
+		err := mainProcess(context.Background(), io.Discard, repo, "apple", 200)
+		assert.ErrorContains(t, err, "在庫更新エラー", "適切なエラーメッセージを含むべき")
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// exitCodeMaintenance はメンテナンスモードにより書き込みが拒否された場合の終了コードです。
+const exitCodeMaintenance = 3
+
+// maintenanceMode はメンテナンスモード中かどうかです。シグナルのハンドラと並行して読み書きされます。
+var maintenanceMode atomic.Bool
+
+// SetMaintenanceMode はメンテナンスモードを切り替えます。
+// メンテナンスモード中は在庫データを書き換える関数（SQLStockRepositoryのメソッドを含む）がDBに問い合わせずにErrMaintenanceModeを返し、読み取りはそのまま実行されます。
+func SetMaintenanceMode(enabled bool) {
+	maintenanceMode.Store(enabled)
+}
+
+// InMaintenanceMode はメンテナンスモード中であればtrueを返します。
+func InMaintenanceMode() bool {
+	return maintenanceMode.Load()
+}
+
+// toggleMaintenanceMode はメンテナンスモードを反転し、切り替え後の状態を返します。
+func toggleMaintenanceMode() bool {
+	for {
+		current := maintenanceMode.Load()
+		if maintenanceMode.CompareAndSwap(current, !current) {
+			return !current
+		}
+	}
+}
+
+// checkWritable はメンテナンスモード中であればErrMaintenanceModeを返します。
+func checkWritable() error {
+	if maintenanceMode.Load() {
+		return ErrMaintenanceMode
+	}
+	return nil
+}
+
+// installMaintenanceToggle はSIGUSR2を受け取るたびにメンテナンスモードを切り替えるハンドラを登録します。
+// 戻り値の関数を呼ぶとハンドラを解除します。
+func installMaintenanceToggle() (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR2)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				enabled := toggleMaintenanceMode()
+				log.Printf("メンテナンスモードを切り替えました: %s", onOff(enabled))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// onOff は真偽値をon/offで表します。
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
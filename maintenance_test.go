@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withMaintenanceMode はテスト中だけメンテナンスモードを切り替えます
+func withMaintenanceMode(t *testing.T, enabled bool) {
+	original := InMaintenanceMode()
+	SetMaintenanceMode(enabled)
+	t.Cleanup(func() { SetMaintenanceMode(original) })
+}
+
+// TestMaintenanceMode_RejectsWrites はメンテナンスモード中の書き込みがDBに問い合わせずに拒否されることをテストします
+func TestMaintenanceMode_RejectsWrites(t *testing.T) {
+	withMaintenanceMode(t, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	repo := NewSQLStockRepository(db)
+	ctx := context.Background()
+
+	writes := map[string]func() error{
+		"UpsertStock": func() error { return repo.UpsertStock(ctx, "apple", 1) },
+		"UpsertStockWithCategory": func() error {
+			return repo.UpsertStockWithCategory(ctx, "apple", 1, "fruit")
+		},
+		"BulkUpsertStocks": func() error {
+			_, err := repo.BulkUpsertStocks(ctx, []StockUpdate{{Name: "apple", Amount: 1}})
+			return err
+		},
+		"DeleteStocksByNames": func() error {
+			_, err := repo.DeleteStocksByNames(ctx, []string{"apple"}, 0)
+			return err
+		},
+		// リポジトリを経由しないパッケージの関数も同じく拒否される
+		"パッケージのUpsertStock":         func() error { return UpsertStock(db, "apple", 1) },
+		"パッケージのUpsertStockDetailed": func() error { _, err := UpsertStockDetailed(db, "apple", 1); return err },
+		"パッケージのBulkUpsertStocks": func() error {
+			_, err := BulkUpsertStocks(db, []StockUpdate{{Name: "apple", Amount: 1}})
+			return err
+		},
+		"パッケージのDeleteStocksByNames": func() error {
+			_, err := DeleteStocksByNames(ctx, db, []string{"apple"}, 0)
+			return err
+		},
+	}
+	for name, write := range writes {
+		assert.True(t, errors.Is(write(), ErrMaintenanceMode), "%sはErrMaintenanceModeを返すべき", name)
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestMaintenanceMode_ToggleMidRun は実行中に切り替えた場合に、実行中の読み取りは成功し、
+// 以降の書き込みは拒否され、HealthCheckに状態が反映されることをテストします
+func TestMaintenanceMode_ToggleMidRun(t *testing.T) {
+	withMaintenanceMode(t, false)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	repo := NewSQLStockRepository(db)
+	ctx := context.Background()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "apple", 100, "fruit"))
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "apple", 100, "fruit"))
+
+	assert.False(t, HealthCheck(db).MaintenanceMode)
+
+	inFlight := make(chan error, 1)
+	go func() {
+		_, err := repo.GetStock(ctx, "apple")
+		inFlight <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	toggleMaintenanceMode()
+
+	assert.NoError(t, <-inFlight, "実行中の読み取りは成功するべき")
+	assert.True(t, errors.Is(repo.UpsertStock(ctx, "apple", 1), ErrMaintenanceMode), "切り替え後の書き込みは拒否されるべき")
+	_, err := repo.GetStock(ctx, "apple")
+	assert.NoError(t, err, "切り替え後も読み取りは実行されるべき")
+
+	status := HealthCheck(db)
+	assert.True(t, status.Healthy())
+	assert.True(t, status.MaintenanceMode, "HealthCheckにメンテナンスモードが反映されるべき")
+	verifyExpectations(t, mock)
+
+	toggleMaintenanceMode()
+	assert.False(t, HealthCheck(db).MaintenanceMode, "解除後はHealthCheckに反映されるべき")
+}
+
+// TestMainProcess_MaintenanceMode はメンテナンスモード中のmainProcessがErrMaintenanceModeを返すことをテストします
+func TestMainProcess_MaintenanceMode(t *testing.T) {
+	withMaintenanceMode(t, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "apple", 100, "fruit"))
+
+	var buf bytes.Buffer
+	err := mainProcess(context.Background(), &buf, NewSQLStockRepository(db), "apple", 1)
+
+	assert.True(t, errors.Is(err, ErrMaintenanceMode), "ErrMaintenanceModeを返すべき: %v", err)
+	verifyExpectations(t, mock)
+}
+
+// TestWriteHealth はHealthStatusの書き出しをテストします
+func TestWriteHealth(t *testing.T) {
+	var buf bytes.Buffer
+	writeHealth(&buf, HealthStatus{MaintenanceMode: true})
+	assert.Equal(t, "database: ok\nmaintenance: on\n", buf.String())
+	assert.NoError(t, HealthStatus{MaintenanceMode: true}.Err(), "メンテナンスモード中でも正常として扱うべき")
+
+	buf.Reset()
+	refused := errors.New("connection refused")
+	status := HealthStatus{DatabaseErr: refused}
+	writeHealth(&buf, status)
+	assert.False(t, status.Healthy())
+	assert.ErrorIs(t, status.Err(), refused, "終了時のメトリクスに接続確認のエラーを記録できるべき")
+	assert.Equal(t, "database: error (connection refused)\nmaintenance: off\n", buf.String())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// stockShardCount はMemoryStockRepositoryとMemoizedQueryが商品名を振り分けるシャードの数です。
+const stockShardCount = 32
+
+// shardIndex は商品名をfnvでハッシュし、n個のシャードのどれに属するかを返します。
+func shardIndex(name string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(n))
+}
+
+// stockShard は商品名で振り分けた在庫データの1区画です。
+type stockShard struct {
+	mu     sync.RWMutex
+	stocks map[string]Stock
+}
+
+// MemoryStockRepository は在庫データをメモリに保持するStockRepositoryです。負荷試験やテストでDBの代わりに使います。
+// ロックは商品名ごとのシャード単位で取るため、異なる商品への操作は並行して進みます。
+//
+// 1商品の値（Stock）は常にシャードのロックの下でまとめて置き換えるため、途中まで更新された値が見えることはありません。
+// GetAllは全体の書き込みを一瞬だけ止めて（書き込みはbarrierの読み取りロックを持つ）全シャードを写すため、
+// 返す一覧はある1時点の状態で、その時点より後の書き込みは一部の商品にだけ反映されることがありません。
+type MemoryStockRepository struct {
+	// barrier は書き込みが読み取りロック、GetAllが書き込みロックを取ります
+	barrier sync.RWMutex
+	shards  []stockShard
+	nextID  atomic.Int64
+}
+
+// NewMemoryStockRepository は空のMemoryStockRepositoryを返します。
+func NewMemoryStockRepository() *MemoryStockRepository {
+	return newMemoryStockRepository(stockShardCount)
+}
+
+// newMemoryStockRepository はshards個のシャードに振り分けるMemoryStockRepositoryを返します。
+// shardsが1の場合はすべての操作が1つのロックで直列化されます。
+func newMemoryStockRepository(shards int) *MemoryStockRepository {
+	r := &MemoryStockRepository{shards: make([]stockShard, shards)}
+	for i := range r.shards {
+		r.shards[i].stocks = make(map[string]Stock)
+	}
+	return r
+}
+
+// shard はnameが属するシャードを返します。
+func (r *MemoryStockRepository) shard(name string) *stockShard {
+	return &r.shards[shardIndex(name, len(r.shards))]
+}
+
+// Ping は常に成功します。
+func (r *MemoryStockRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+// EnsureSchema は何もしません。
+func (r *MemoryStockRepository) EnsureSchema(ctx context.Context) error {
+	return nil
+}
+
+// QueryStocks は名前に一致する在庫データを返します。名前が空の場合はGetAllと同じ時点の全件をidの順に返します。
+func (r *MemoryStockRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	var stocks []Stock
+	if name == "" {
+		all, err := r.GetAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stocks = all
+	} else {
+		s := r.shard(name)
+		s.mu.RLock()
+		stock, ok := s.stocks[name]
+		s.mu.RUnlock()
+		if ok {
+			stocks = append(stocks, stock)
+		}
+	}
+
+	results := make([]map[string]interface{}, 0, len(stocks))
+	for _, stock := range stocks {
+		results = append(results, map[string]interface{}{
+			"id": stock.ID, "name": stock.Name, "amount": stock.Amount, "category": stock.Category,
+		})
+	}
+	return results, nil
+}
+
+// UpsertStock は在庫数を加算し、商品が存在しない場合は新しく追加します。
+// SQLStockRepositoryと同じく、商品名の検証、変更量の上限の確認とメンテナンスモードの確認を行います。
+func (r *MemoryStockRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	r.barrier.RLock()
+	defer r.barrier.RUnlock()
+	s := r.shard(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stock, exists := s.stocks[name]
+	before := int(stock.Amount)
+	if err := checkStockChange(name, before, before+amount, exists, newUpsertOptions(opts)); err != nil {
+		return err
+	}
+	if !exists {
+		stock = Stock{ID: r.nextID.Add(1), Name: name, Category: defaultCategory}
+	}
+	stock.Amount += int64(amount)
+	s.stocks[name] = stock
+	return nil
+}
+
+// DeleteStock は商品を削除します。商品が存在しなかった場合はfalseを返します。
+func (r *MemoryStockRepository) DeleteStock(ctx context.Context, name string) (bool, error) {
+	if err := checkWritable(); err != nil {
+		return false, err
+	}
+
+	r.barrier.RLock()
+	defer r.barrier.RUnlock()
+	s := r.shard(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.stocks[name]
+	delete(s.stocks, name)
+	return ok, nil
+}
+
+// GetAll は全商品の在庫データをidの順に返します。返す一覧はある1時点の状態です。
+func (r *MemoryStockRepository) GetAll(ctx context.Context) ([]Stock, error) {
+	r.barrier.Lock()
+	var stocks []Stock
+	for i := range r.shards {
+		// 書き込みはbarrierで止まっているため、シャードのロックは不要
+		for _, stock := range r.shards[i].stocks {
+			stocks = append(stocks, stock)
+		}
+	}
+	r.barrier.Unlock()
+
+	sort.Slice(stocks, func(i, j int) bool { return stocks[i].ID < stocks[j].ID })
+	return stocks, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ StockRepository = (*MemoryStockRepository)(nil)
+
+// TestMemoryStockRepository は追加、加算、検索と削除をテストします
+func TestMemoryStockRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryStockRepository()
+
+	require.NoError(t, repo.UpsertStock(ctx, "apple", 100))
+	require.NoError(t, repo.UpsertStock(ctx, "banana", 30))
+	require.NoError(t, repo.UpsertStock(ctx, "apple", 50))
+
+	results, err := repo.QueryStocks(ctx, "apple")
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"id": int64(1), "name": "apple", "amount": int64(150), "category": defaultCategory},
+	}, results, "加算された在庫数が返るべき")
+
+	all, err := repo.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Stock{
+		{ID: 1, Name: "apple", Amount: 150, Category: defaultCategory},
+		{ID: 2, Name: "banana", Amount: 30, Category: defaultCategory},
+	}, all, "idの順に返るべき")
+
+	deleted, err := repo.DeleteStock(ctx, "apple")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = repo.DeleteStock(ctx, "apple")
+	require.NoError(t, err)
+	assert.False(t, deleted, "存在しない商品の削除はfalseを返すべき")
+
+	results, err = repo.QueryStocks(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, results, 1, "名前が空の場合は全件を返すべき")
+}
+
+// TestMemoryStockRepository_Validation はSQLStockRepositoryと同じ検証を行うことをテストします
+func TestMemoryStockRepository_Validation(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryStockRepository()
+
+	assert.Error(t, repo.UpsertStock(ctx, "", 10), "空の商品名は拒否されるべき")
+
+	withMaintenanceMode(t, true)
+	assert.True(t, errors.Is(repo.UpsertStock(ctx, "apple", 1), ErrMaintenanceMode))
+}
+
+// TestMemoryStockRepository_Concurrent は数百のgoroutineから検索、加算、削除と一覧を同時に行ってもデータが壊れないことをテストします。
+// -raceで実行することを前提としています
+func TestMemoryStockRepository_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryStockRepository()
+
+	const workers = 200
+	const iterations = 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			name := fmt.Sprintf("item-%d", w%20)
+			for i := 0; i < iterations; i++ {
+				switch (w + i) % 4 {
+				case 0, 1:
+					assert.NoError(t, repo.UpsertStock(ctx, name, 1))
+				case 2:
+					_, err := repo.QueryStocks(ctx, name)
+					assert.NoError(t, err)
+				case 3:
+					_, err := repo.GetAll(ctx)
+					assert.NoError(t, err)
+				}
+			}
+			// 削除専用の商品は他の商品の在庫数に影響しない
+			_, err := repo.DeleteStock(ctx, fmt.Sprintf("item-%d", 20+w%5))
+			assert.NoError(t, err)
+		}(w)
+	}
+	wg.Wait()
+
+	all, err := repo.GetAll(ctx)
+	require.NoError(t, err)
+	var total int64
+	for _, stock := range all {
+		total += stock.Amount
+	}
+	assert.Equal(t, int64(workers*iterations/2), total, "すべての加算が反映されるべき")
+}
+
+// TestMemoryStockRepository_GetAllSnapshot はGetAllが1時点の状態を返すことをテストします。
+// 書き込み側はaを加算してからbを加算するため、どの時点でもaはbと等しいかbより1多い状態です
+func TestMemoryStockRepository_GetAllSnapshot(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryStockRepository()
+	require.NotEqual(t, shardIndex("a", stockShardCount), shardIndex("b", stockShardCount), "別のシャードの商品で確認する")
+	require.NoError(t, repo.UpsertStock(ctx, "a", 0))
+	require.NoError(t, repo.UpsertStock(ctx, "b", 0))
+
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for !stop.Load() {
+			assert.NoError(t, repo.UpsertStock(ctx, "a", 1))
+			assert.NoError(t, repo.UpsertStock(ctx, "b", 1))
+		}
+	}()
+
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		all, err := repo.GetAll(ctx)
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		diff := all[0].Amount - all[1].Amount
+		if diff != 0 && diff != 1 {
+			t.Fatalf("1時点の状態ではない一覧が返った: a=%d b=%d", all[0].Amount, all[1].Amount)
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+// BenchmarkMemoryStockRepository はシャードに分けたロックと1つのロックの性能を比較します
+func BenchmarkMemoryStockRepository(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		shards int
+	}{
+		{"SingleMutex", 1},
+		{"Sharded", stockShardCount},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			ctx := context.Background()
+			repo := newMemoryStockRepository(bench.shards)
+			names := make([]string, 256)
+			for i := range names {
+				names[i] = fmt.Sprintf("item-%d", i)
+				if err := repo.UpsertStock(ctx, names[i], 1000000); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1))
+				for pb.Next() {
+					name := names[i%len(names)]
+					if i%4 == 0 {
+						_ = repo.UpsertStock(ctx, name, 1)
+					} else {
+						_, _ = repo.QueryStocks(ctx, name)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+// messageCatalog は利用者に表示するメッセージを言語ごとに保持します。
+// キーが見つからない言語ではjaのメッセージを使います。新しいキーは全言語に追加してください。
+var messageCatalog = map[string]map[string]string{
+	"ja": {
+		"hint.not-found":          "指定した商品やデータが存在しません。商品名やIDを確認してください。",
+		"hint.conflict":           "他の処理と競合しました。しばらく待ってから再実行してください。メンテナンスモード中の場合は終了後に再実行してください。",
+		"hint.connection":         "DBに接続できませんでした。DBの起動状態、接続先（DB_HOST/DB_PORT）と認証情報を確認してください。",
+		"hint.schema":             "テーブルの定義が想定と異なります。init-dbサブコマンドでテーブルを作成するか、マイグレーションを適用してください。",
+		"hint.validation":         "入力が条件を満たしていません。商品名、数量や指定した値を確認してください。",
+		"hint.unknown":            "原因を特定できませんでした。このレポートを添えて問い合わせてください。",
+		"error.listing":           "在庫一覧を取得できませんでした",
+		"error.operation-id":      "操作のIDが正しくありません",
+		"error.cancel":            "操作を中断できませんでした",
+		"error.maintenance-value": "enabledにはtrueかfalseを指定してください",
+		"report.retryable":        "再試行できます",
+		"report.permanent":        "再試行しても解決しません",
+	},
+	"en": {
+		"hint.not-found":          "The requested product or record does not exist. Check the product name or ID.",
+		"hint.conflict":           "The request conflicted with another operation. Wait a moment and retry. If maintenance mode is on, retry after it ends.",
+		"hint.connection":         "Could not connect to the database. Check that it is running, the host and port (DB_HOST/DB_PORT), and the credentials.",
+		"hint.schema":             "The table definition does not match. Create the tables with the init-db subcommand or apply the migrations.",
+		"hint.validation":         "The input was rejected. Check the product name, amount and other values.",
+		"hint.unknown":            "The cause could not be determined. Please attach this report when contacting support.",
+		"error.listing":           "Could not fetch the stock listing",
+		"error.operation-id":      "The operation ID is invalid",
+		"error.cancel":            "Could not cancel the operation",
+		"error.maintenance-value": "Set enabled to true or false",
+		"report.retryable":        "retryable",
+		"report.permanent":        "not retryable",
+	},
+}
+
+// message はmessageLanguageのメッセージを返します。その言語にない場合はja、どちらにもない場合はキーを返します。
+func message(key string) string {
+	if m, ok := messageCatalog[messageLanguage][key]; ok {
+		return m
+	}
+	if m, ok := messageCatalog["ja"][key]; ok {
+		return m
+	}
+	return key
+}
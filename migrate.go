@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// 適用済みのマイグレーションを記録するテーブル。バージョン番号はテーブルごとに独立しているため、
+// EnsureSchemaが適用するstockMigrationsと、利用者がMigrateToで適用するマイグレーションは互いに影響しない
+const (
+	// schemaMigrationsTable はMigrateToで適用したマイグレーションを記録するテーブルです。
+	schemaMigrationsTable = "schema_migrations"
+	// stockMigrationsTable はEnsureSchemaで適用したstockMigrationsを記録するテーブルです。
+	stockMigrationsTable = "stock_schema_migrations"
+)
+
+// migrationsTableDDL は適用済みのマイグレーションを記録するtableを作成するDDLを返します。
+func migrationsTableDDL(table string) string {
+	return `
+CREATE TABLE IF NOT EXISTS ` + table + ` (
+    version INT PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`
+}
+
+var (
+	// ErrMigrationGap は適用すべきバージョンのマイグレーションが存在しない場合に返されます。
+	ErrMigrationGap = errors.New("マイグレーションのバージョンが連続していません")
+	// ErrMigrationDowngrade は現在のバージョンより古いバージョンを指定した場合に返されます。
+	ErrMigrationDowngrade = errors.New("現在のバージョンより古いバージョンには戻せません")
+	// ErrMigrationLockTimeout はmigrationLockTimeout以内にマイグレーションのロックを取得できなかった場合に返されます。
+	ErrMigrationLockTimeout = errors.New("マイグレーションのロックを取得できませんでした")
+)
+
+// migrationLockName はスキーマの作成とマイグレーションを複数のインスタンスで排他するGET_LOCKのロック名です。
+const migrationLockName = "db_mock:migrate"
+
+// withMigrationLock はマイグレーションのロックを取得してfnを実行し、終了後にロックを解放します。
+// 同時に起動した他のインスタンスはロックが解放されるまで待ち、その後に適用済みの状態を確認してから処理を進めます。
+func withMigrationLock(ctx context.Context, db *sql.DB, fn func() error) (err error) {
+	release, err := AcquireLockContext(ctx, db, migrationLockName, migrationLockTimeout)
+	if errors.Is(err, ErrLockTimeout) {
+		return fmt.Errorf("%w: %w", ErrMigrationLockTimeout, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := release(); releaseErr != nil && err == nil {
+			err = fmt.Errorf("マイグレーションのロックの解放エラー: %w", releaseErr)
+		}
+	}()
+	return fn()
+}
+
+// MigrateTo は現在のスキーマバージョンの次からtargetまでのマイグレーションを順に適用し、schema_migrationsに記録します。
+// 既にtargetに達している場合は何もしません。途中のバージョンが欠けている場合は何も適用せずにErrMigrationGapを返します。
+// 適用はマイグレーションのロックを保持して行うため、複数のインスタンスが同時に実行しても各バージョンは1回だけ適用されます。
+// EnsureSchemaが適用するstockMigrationsは別のテーブルに記録するため、バージョン番号は1から自由に使えます。
+func MigrateTo(db *sql.DB, target int, migrations map[int]string) error {
+	return MigrateToContext(context.Background(), db, target, migrations)
+}
+
+// MigrateToContext はMigrateToのcontext対応版です。ロックの取得とマイグレーションの適用はctxのキャンセルや期限に従います。
+func MigrateToContext(ctx context.Context, db *sql.DB, target int, migrations map[int]string) error {
+	return withMigrationLock(ctx, db, func() error {
+		return migrateTo(ctx, db, schemaMigrationsTable, target, migrations)
+	})
+}
+
+// migrateTo はマイグレーションのロックを保持した状態でMigrateToの処理を行い、適用したバージョンをtableに記録します。
+func migrateTo(ctx context.Context, db *sql.DB, table string, target int, migrations map[int]string) error {
+	if _, err := db.ExecContext(ctx, migrationsTableDDL(table)); err != nil {
+		return fmt.Errorf("%sの作成エラー: %w", table, err)
+	}
+
+	current, err := currentSchemaVersion(ctx, db, table)
+	if err != nil {
+		return err
+	}
+	if current == target {
+		return nil
+	}
+	if current > target {
+		return fmt.Errorf("%w: 現在 %d, 指定 %d", ErrMigrationDowngrade, current, target)
+	}
+
+	// 適用を始める前に、欠けているバージョンがないことを確認する
+	for v := current + 1; v <= target; v++ {
+		if _, ok := migrations[v]; !ok {
+			return fmt.Errorf("%w: バージョン %d がありません", ErrMigrationGap, v)
+		}
+	}
+
+	for v := current + 1; v <= target; v++ {
+		if err := applyMigration(ctx, db, table, v, migrations[v]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentSchemaVersion はtableに記録された適用済みの最大のバージョンを返します。未適用の場合は0です。
+func currentSchemaVersion(ctx context.Context, db *sql.DB, table string) (int, error) {
+	var version int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM "+table+";").Scan(&version); err != nil {
+		return 0, fmt.Errorf("スキーマバージョンの取得エラー: %w", err)
+	}
+	return version, nil
+}
+
+// applyMigration は1つのマイグレーションを適用し、同じトランザクションでtableに記録します。
+// MySQLではDDLが暗黙的にコミットされるため、DDLの失敗時は記録だけがロールバックされます。
+func applyMigration(ctx context.Context, db *sql.DB, table string, version int, statement string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+	defer tx.Rollback() // エラー発生時にロールバック
+
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		return fmt.Errorf("マイグレーション %d の適用エラー: %w", version, err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO "+table+" (version) VALUES (?);", version); err != nil {
+		return fmt.Errorf("マイグレーション %d の記録エラー: %w", version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("トランザクションコミットエラー: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	currentVersionRegex = `SELECT COALESCE\(MAX\(version\), 0\) FROM schema_migrations;`
+	// stockVersionRegex はEnsureSchemaが適用するstockMigrationsの現在のバージョンの取得です
+	stockVersionRegex = `SELECT COALESCE\(MAX\(version\), 0\) FROM stock_schema_migrations;`
+)
+
+var testMigrations = map[int]string{
+	1: "CREATE TABLE widgets (id INT);",
+	2: "ALTER TABLE widgets ADD COLUMN name VARCHAR(64);",
+	3: "CREATE INDEX idx_widgets_name ON widgets (name);",
+}
+
+// expectMigrationsTable はマイグレーションのロックの取得、schema_migrationsの作成と現在のバージョンの取得を期待値に設定します
+func expectMigrationsTable(mock sqlmock.Sqlmock, current int) {
+	expectMigrationLock(mock)
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(currentVersionRegex).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(current))
+}
+
+// expectMigration は1つのマイグレーションの適用と記録を期待値に設定します
+func expectMigration(mock sqlmock.Sqlmock, version int) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testMigrations[version])).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations \(version\) VALUES \(\?\);`).
+		WithArgs(version).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// TestMigrateTo_Fresh は未適用のDBで最初から順にマイグレーションが適用されることをテストします
+func TestMigrateTo_Fresh(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationsTable(mock, 0)
+	expectMigration(mock, 1)
+	expectMigration(mock, 2)
+	expectMigration(mock, 3)
+	expectMigrationUnlock(mock)
+
+	assert.NoError(t, MigrateTo(db, 3, testMigrations), "マイグレーションは成功するべき")
+	verifyExpectations(t, mock)
+}
+
+// TestMigrateTo_Partial は適用済みのバージョンの次から適用されることをテストします
+func TestMigrateTo_Partial(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationsTable(mock, 1)
+	expectMigration(mock, 2)
+	expectMigrationUnlock(mock)
+
+	assert.NoError(t, MigrateTo(db, 2, testMigrations), "マイグレーションは成功するべき")
+	verifyExpectations(t, mock)
+}
+
+// TestMigrateTo_AlreadyAtTarget は既にtargetに達している場合に何も適用しないことをテストします
+func TestMigrateTo_AlreadyAtTarget(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationsTable(mock, 3)
+	expectMigrationUnlock(mock)
+
+	assert.NoError(t, MigrateTo(db, 3, testMigrations), "再実行は何もせず成功するべき")
+	verifyExpectations(t, mock)
+}
+
+// TestMigrateTo_Gap はバージョンが欠けている場合に何も適用せずにエラーを返すことをテストします
+func TestMigrateTo_Gap(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationsTable(mock, 0)
+	expectMigrationUnlock(mock)
+
+	migrations := map[int]string{1: testMigrations[1], 3: testMigrations[3]}
+	err := MigrateTo(db, 3, migrations)
+
+	assert.ErrorIs(t, err, ErrMigrationGap)
+	assert.Contains(t, err.Error(), "2", "欠けているバージョンがエラーに含まれるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestMigrateTo_Downgrade は現在より古いバージョンを指定した場合にエラーを返すことをテストします
+func TestMigrateTo_Downgrade(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationsTable(mock, 3)
+	expectMigrationUnlock(mock)
+
+	assert.ErrorIs(t, MigrateTo(db, 1, testMigrations), ErrMigrationDowngrade)
+	verifyExpectations(t, mock)
+}
+
+// TestMigrateTo_ApplyError は適用に失敗した時点で止まり、以降のマイグレーションを適用しないことをテストします
+func TestMigrateTo_ApplyError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationsTable(mock, 0)
+	expectMigration(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(`ALTER TABLE widgets`).WillReturnError(errors.New("duplicate column"))
+	mock.ExpectRollback()
+	expectMigrationUnlock(mock)
+
+	err := MigrateTo(db, 3, testMigrations)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "マイグレーション 2")
+	verifyExpectations(t, mock)
+}
+
+// TestMigrateTo_LockTimeout は他のインスタンスがロックを保持し続けている場合に、何も適用せずにErrMigrationLockTimeoutを返すことをテストします
+func TestMigrateTo_LockTimeout(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT GET_LOCK\(\?, \?\);`).
+		WithArgs(migrationLockName, migrationLockTimeout.Seconds()).
+		WillReturnRows(sqlmock.NewRows([]string{"GET_LOCK"}).AddRow(0))
+
+	err := MigrateTo(db, 3, testMigrations)
+
+	assert.ErrorIs(t, err, ErrMigrationLockTimeout)
+	assert.ErrorIs(t, err, ErrLockTimeout, "元のエラーを保持しているべき")
+	verifyExpectations(t, mock)
+}
+
+// TestMigrateToContext_Canceled はctxの期限を過ぎたマイグレーションを打ち切り、以降のマイグレーションを適用しないことをテストします
+func TestMigrateToContext_Canceled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationsTable(mock, 0)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testMigrations[1])).
+		WillDelayFor(time.Second).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectMigrationUnlock(mock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := MigrateToContext(ctx, db, 3, testMigrations)
+
+	assert.ErrorIs(t, err, sqlmock.ErrCancelled, "ctxの期限でマイグレーションを打ち切るべき")
+	assert.Contains(t, err.Error(), "マイグレーション 1")
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// nPlusOneTopNames は助言に含める、呼び出し回数の多い商品名の件数です。
+const nPlusOneTopNames = 5
+
+// operationIDKey は操作IDをcontextに保持するためのキーです。
+type operationIDKey struct{}
+
+// WithOperationID はN+1の検出で同じ操作として数えるための操作IDをctxに設定します。
+// 1回のリクエストやバッチ処理などの単位で設定します。
+func WithOperationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, operationIDKey{}, id)
+}
+
+// operationIDFrom はctxに設定された操作IDを返します。
+func operationIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(operationIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// NPlusOneAdvisory は1つの操作で同じ形の1行取得クエリが繰り返されたことの助言です。
+type NPlusOneAdvisory struct {
+	OperationID string
+	Query       string
+	// Count は助言した時点までの、期間内の実行回数です。
+	Count int
+	// TopNames は実行回数の多い商品名です。
+	TopNames []string
+}
+
+// nPlusOneKey は操作IDとクエリの形の組です。
+type nPlusOneKey struct {
+	operationID string
+	query       string
+}
+
+// nPlusOneEntry は1つの操作IDとクエリの形について数えている実行回数です。
+type nPlusOneEntry struct {
+	key     nPlusOneKey
+	started time.Time
+	count   int
+	names   map[string]int
+	advised bool
+}
+
+// NPlusOneDetector は操作IDごとに同じ形の1行取得クエリの実行回数を数え、
+// Window内にThreshold回に達した時点で一括取得のAPIを使うよう1回だけ助言します。
+// 数えている組はMaxShapes件までで、超えた場合は最も長く使われていない組から破棄します。
+type NPlusOneDetector struct {
+	window    time.Duration
+	threshold int
+	maxShapes int
+	// onAdvisory は助言の通知先です。nilの場合はログに出力します。
+	onAdvisory func(NPlusOneAdvisory)
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[nPlusOneKey]*list.Element
+	lru     *list.List
+}
+
+// NewNPlusOneDetector はwindow内にthreshold回の繰り返しを検出するNPlusOneDetectorを返します。
+// onAdvisoryがnilの場合、助言はログに出力されます。
+func NewNPlusOneDetector(window time.Duration, threshold, maxShapes int, onAdvisory func(NPlusOneAdvisory)) *NPlusOneDetector {
+	return &NPlusOneDetector{
+		window:     window,
+		threshold:  threshold,
+		maxShapes:  maxShapes,
+		onAdvisory: onAdvisory,
+		now:        time.Now,
+		entries:    make(map[nPlusOneKey]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Observe は1行取得クエリの実行を数えます。dがnilの場合やctxに操作IDがない場合は何もしません。
+func (d *NPlusOneDetector) Observe(ctx context.Context, query, name string) {
+	if d == nil {
+		return
+	}
+	id, ok := operationIDFrom(ctx)
+	if !ok {
+		return
+	}
+
+	if advisory, ok := d.record(nPlusOneKey{operationID: id, query: query}, name); ok {
+		if d.onAdvisory != nil {
+			d.onAdvisory(advisory)
+		} else {
+			log.Printf("N+1の可能性: 操作 %s で同じクエリが%d回実行されました。一括取得のAPIを使ってください (%s) 商品名: %v",
+				advisory.OperationID, advisory.Count, advisory.Query, advisory.TopNames)
+		}
+	}
+}
+
+// record は実行回数を加算し、しきい値に達した場合は助言を返します。
+func (d *NPlusOneDetector) record(key nPlusOneKey, name string) (NPlusOneAdvisory, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	entry := d.entry(key, now)
+	entry.count++
+	if entry.advised {
+		return NPlusOneAdvisory{}, false
+	}
+	entry.names[name]++
+	if entry.count < d.threshold {
+		return NPlusOneAdvisory{}, false
+	}
+
+	entry.advised = true
+	advisory := NPlusOneAdvisory{OperationID: key.operationID, Query: key.query, Count: entry.count, TopNames: topNames(entry.names, nPlusOneTopNames)}
+	// 助言後は商品名を数える必要がないため解放する
+	entry.names = nil
+	return advisory, true
+}
+
+// entry はkeyの数えている状態を返します。ない場合や期間を過ぎている場合は新しく数え始めます。
+func (d *NPlusOneDetector) entry(key nPlusOneKey, now time.Time) *nPlusOneEntry {
+	if elem, ok := d.entries[key]; ok {
+		d.lru.MoveToFront(elem)
+		entry := elem.Value.(*nPlusOneEntry)
+		if now.Sub(entry.started) < d.window {
+			return entry
+		}
+		*entry = nPlusOneEntry{key: key, started: now, names: make(map[string]int)}
+		return entry
+	}
+
+	entry := &nPlusOneEntry{key: key, started: now, names: make(map[string]int)}
+	d.entries[key] = d.lru.PushFront(entry)
+	for d.maxShapes > 0 && d.lru.Len() > d.maxShapes {
+		oldest := d.lru.Back()
+		d.lru.Remove(oldest)
+		delete(d.entries, oldest.Value.(*nPlusOneEntry).key)
+	}
+	return entry
+}
+
+// topNames は回数の多い順（同数の場合は名前順）に最大n件の商品名を返します。
+func topNames(counts map[string]int, n int) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names[:min(n, len(names))]
+}
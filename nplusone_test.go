@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withNPlusOneDetector はテスト中だけN+1の検出器を設定し、通知された助言を返すスライスへのポインタを返します
+func withNPlusOneDetector(t *testing.T, window time.Duration, threshold, maxShapes int) (*NPlusOneDetector, *[]NPlusOneAdvisory) {
+	t.Helper()
+	var advisories []NPlusOneAdvisory
+	detector := NewNPlusOneDetector(window, threshold, maxShapes, func(a NPlusOneAdvisory) {
+		advisories = append(advisories, a)
+	})
+	original := nPlusOneDetector
+	nPlusOneDetector = detector
+	t.Cleanup(func() { nPlusOneDetector = original })
+	return detector, &advisories
+}
+
+// TestNPlusOneDetector_GetStockLoop は1つの操作内でGetStockを繰り返すと1回だけ助言することをテストします
+func TestNPlusOneDetector_GetStockLoop(t *testing.T) {
+	_, advisories := withNPlusOneDetector(t, time.Minute, 10, 16)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	ctx := WithOperationID(context.Background(), "op-1")
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("item-%d", i%4)
+		mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+			WithArgs(name).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(i%4+1, name, 1, "fruit"))
+		_, err := GetStockContext(ctx, db, name)
+		assert.NoError(t, err)
+	}
+
+	if assert.Len(t, *advisories, 1, "助言は1回だけであるべき") {
+		advisory := (*advisories)[0]
+		assert.Equal(t, "op-1", advisory.OperationID)
+		assert.Equal(t, "SELECT id, name, amount, category FROM stocks WHERE name = ?;", advisory.Query)
+		assert.Equal(t, 10, advisory.Count, "しきい値に達した時点の回数であるべき")
+		assert.Equal(t, []string{"item-0", "item-1", "item-2", "item-3"}, advisory.TopNames, "回数の多い順であるべき")
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestNPlusOneDetector_BatchAPI は一括取得のAPIでは助言しないことをテストします
+func TestNPlusOneDetector_BatchAPI(t *testing.T) {
+	_, advisories := withNPlusOneDetector(t, time.Minute, 10, 16)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	names := make([]string, 50)
+	for i := range names {
+		names[i] = fmt.Sprintf("item-%02d", i)
+	}
+	ctx := WithOperationID(context.Background(), "op-1")
+	for i := 0; i < 20; i++ {
+		mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name IN \(.+\) ORDER BY name;`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "item-00", 1, "fruit"))
+		_, err := GetStocksByNamesContext(ctx, db, names)
+		assert.NoError(t, err)
+	}
+
+	assert.Empty(t, *advisories, "一括取得では助言しないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestNPlusOneDetector_WithoutOperationID は操作IDのないcontextでは数えないことをテストします
+func TestNPlusOneDetector_WithoutOperationID(t *testing.T) {
+	detector, advisories := withNPlusOneDetector(t, time.Minute, 2, 16)
+
+	for i := 0; i < 5; i++ {
+		detector.Observe(context.Background(), "SELECT 1;", "apple")
+	}
+
+	assert.Empty(t, *advisories)
+	assert.Equal(t, 0, detector.lru.Len(), "操作IDのない実行は記録しないべき")
+}
+
+// TestNPlusOneDetector_Window は期間を過ぎると数え直し、操作IDごとに別々に数えることをテストします
+func TestNPlusOneDetector_Window(t *testing.T) {
+	detector, advisories := withNPlusOneDetector(t, time.Second, 3, 16)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	detector.now = func() time.Time { return now }
+
+	op1 := WithOperationID(context.Background(), "op-1")
+	op2 := WithOperationID(context.Background(), "op-2")
+	detector.Observe(op1, "SELECT 1;", "apple")
+	detector.Observe(op1, "SELECT 1;", "apple")
+	detector.Observe(op2, "SELECT 1;", "apple")
+	now = now.Add(time.Second)
+	detector.Observe(op1, "SELECT 1;", "apple")
+	detector.Observe(op1, "SELECT 1;", "apple")
+	assert.Empty(t, *advisories, "期間を過ぎた実行や別の操作の実行は合算しないべき")
+
+	detector.Observe(op1, "SELECT 1;", "apple")
+	assert.Len(t, *advisories, 1)
+}
+
+// TestNPlusOneDetector_BoundedShapes は数えている組の数がmaxShapesを超えないことをテストします
+func TestNPlusOneDetector_BoundedShapes(t *testing.T) {
+	detector, _ := withNPlusOneDetector(t, time.Minute, 10, 4)
+
+	for i := 0; i < 100; i++ {
+		detector.Observe(WithOperationID(context.Background(), fmt.Sprintf("op-%d", i)), "SELECT 1;", "apple")
+	}
+
+	assert.Equal(t, 4, detector.lru.Len())
+	assert.Len(t, detector.entries, 4)
+	_, ok := detector.entries[nPlusOneKey{operationID: "op-99", query: "SELECT 1;"}]
+	assert.True(t, ok, "最近使われた組は残るべき")
+}
+
+// TestNPlusOneDetector_Disabled は検出器が設定されていない場合に何もしないことをテストします
+func TestNPlusOneDetector_Disabled(t *testing.T) {
+	var detector *NPlusOneDetector
+	assert.NotPanics(t, func() {
+		detector.Observe(WithOperationID(context.Background(), "op-1"), "SELECT 1;", "apple")
+	})
+}
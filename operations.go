@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOperationNotFound は指定したIDの実行中の操作が存在しない場合に返されます。
+var ErrOperationNotFound = errors.New("実行中の操作が見つかりません")
+
+// Queryer は読み取りのクエリを実行する*sql.DB、*sql.Connと*sql.Txの共通部分です。
+// 読み取り関数のcontext対応版はQueryerを受け取るため、WithConnで確保した接続やトランザクションでも実行できます。
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ActiveOperation は実行中の時間のかかる操作です。
+type ActiveOperation struct {
+	ID        int64
+	Statement string
+	// ConnectionID は操作を実行しているMySQLの接続ID（CONNECTION_ID()）です。
+	ConnectionID int64
+	StartedAt    time.Time
+	Elapsed      time.Duration
+}
+
+// operationRegistry は実行中の操作を接続IDとあわせて保持します。
+type operationRegistry struct {
+	mu     sync.Mutex
+	nextID int64
+	ops    map[int64]*operationEntry
+}
+
+// operationEntry は登録された操作です。muはKILL QUERYの発行中に保持し、
+// 登録の解除（と、その後の接続の返却）がKILL QUERYの完了を待つようにします。
+type operationEntry struct {
+	op   ActiveOperation
+	mu   sync.Mutex
+	done bool
+}
+
+// activeOperations はtrackOperationで実行中の操作の一覧です。
+var activeOperations = &operationRegistry{ops: make(map[int64]*operationEntry)}
+
+// register は操作を登録し、割り当てたIDを返します。
+func (r *operationRegistry) register(statement string, connID int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	r.ops[r.nextID] = &operationEntry{op: ActiveOperation{ID: r.nextID, Statement: statement, ConnectionID: connID, StartedAt: time.Now()}}
+	return r.nextID
+}
+
+// unregister は操作の登録を解除します。whileRegisteredで実行中の処理があれば、その終了を待ちます。
+func (r *operationRegistry) unregister(id int64) {
+	r.mu.Lock()
+	e, ok := r.ops[id]
+	delete(r.ops, id)
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	e.done = true
+	e.mu.Unlock()
+}
+
+// whileRegistered は指定したIDの操作が登録されている間にfnを実行します。
+// fnの実行中は登録の解除が待たされるため、操作の接続IDが別の処理に再利用されることはありません。
+func (r *operationRegistry) whileRegistered(id int64, fn func(op ActiveOperation) error) error {
+	r.mu.Lock()
+	e, ok := r.ops[id]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrOperationNotFound, id)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// 取得してからロックするまでの間に登録が解除されていれば、接続はもう操作のものではない
+	if e.done {
+		return fmt.Errorf("%w: %d", ErrOperationNotFound, id)
+	}
+	return fn(e.op)
+}
+
+// list は実行中の操作を開始順に返します。
+func (r *operationRegistry) list() []ActiveOperation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	ops := make([]ActiveOperation, 0, len(r.ops))
+	for _, e := range r.ops {
+		op := e.op
+		op.Elapsed = now.Sub(op.StartedAt)
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].ID < ops[j].ID })
+	return ops
+}
+
+// ListActiveOperations は実行中の時間のかかる操作を開始順に返します。
+// trackLongOperationsが無効な場合は常に空です。
+func ListActiveOperations() []ActiveOperation {
+	return activeOperations.list()
+}
+
+// CancelOperation は別の接続adminDBからKILL QUERYを発行し、指定したIDの操作のクエリを中断します。
+// 中断された操作は呼び出し元にドライバのエラーを返します。
+// KILL QUERYは操作の登録を保持したまま発行するため、操作が終わって接続が別の処理に使われていても、その処理を中断することはありません。
+func CancelOperation(ctx context.Context, adminDB *sql.DB, opID int64) error {
+	return activeOperations.whileRegistered(opID, func(op ActiveOperation) error {
+		// KILLはプレースホルダを受け付けないため、サーバーから取得した数値の接続IDを埋め込む
+		if _, err := adminDB.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d;", op.ConnectionID)); err != nil {
+			return fmt.Errorf("操作 %d の中断に失敗しました: %w", opID, err)
+		}
+		return nil
+	})
+}
+
+// trackOperation はtrackLongOperationsが有効な場合に、専用の接続でfnを実行し、その間ListActiveOperationsに登録します。
+// 登録はfnが終了すると、パニックした場合も含めて必ず解除されます。
+// 無効な場合はdbでそのままfnを実行します。
+func trackOperation(ctx context.Context, db *sql.DB, statement string, fn func(ctx context.Context, q Queryer) error) error {
+	if !trackLongOperations {
+		return fn(ctx, db)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("接続の取得エラー: %w", err)
+	}
+	defer conn.Close()
+
+	var connID int64
+	if err := conn.QueryRowContext(ctx, "SELECT CONNECTION_ID();").Scan(&connID); err != nil {
+		return fmt.Errorf("接続IDの取得エラー: %w", err)
+	}
+	id := activeOperations.register(statement, connID)
+	defer activeOperations.unregister(id)
+
+	return fn(ctx, conn)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withTrackLongOperations はテスト中だけ時間のかかる操作の登録を有効にします
+func withTrackLongOperations(t *testing.T) {
+	original := trackLongOperations
+	trackLongOperations = true
+	t.Cleanup(func() { trackLongOperations = original })
+}
+
+// expectConnectionID は専用の接続で接続IDを取得するモックを設定します
+func expectConnectionID(mock sqlmock.Sqlmock, connID int64) {
+	mock.ExpectQuery(`SELECT CONNECTION_ID\(\);`).
+		WillReturnRows(sqlmock.NewRows([]string{"CONNECTION_ID()"}).AddRow(connID))
+}
+
+// TestTrackOperation_Lifecycle は実行中だけ操作が登録され、終了後に解除されることをテストします
+func TestTrackOperation_Lifecycle(t *testing.T) {
+	withTrackLongOperations(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectConnectionID(mock, 42)
+
+	var during []ActiveOperation
+	err := trackOperation(context.Background(), db, "SELECT SLEEP(1);", func(ctx context.Context, q Queryer) error {
+		during = ListActiveOperations()
+		return nil
+	})
+
+	assert.NoError(t, err)
+	if assert.Len(t, during, 1, "実行中は登録されているべき") {
+		assert.Equal(t, "SELECT SLEEP(1);", during[0].Statement)
+		assert.Equal(t, int64(42), during[0].ConnectionID)
+		assert.GreaterOrEqual(t, during[0].Elapsed, time.Duration(0))
+	}
+	assert.Empty(t, ListActiveOperations(), "終了後は解除されるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestTrackOperation_PanicUnregisters はパニックした場合も登録が解除されることをテストします
+func TestTrackOperation_PanicUnregisters(t *testing.T) {
+	withTrackLongOperations(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectConnectionID(mock, 7)
+
+	assert.Panics(t, func() {
+		_ = trackOperation(context.Background(), db, "SELECT 1;", func(ctx context.Context, q Queryer) error {
+			panic("boom")
+		})
+	})
+	assert.Empty(t, ListActiveOperations(), "パニックした場合も解除されるべき")
+}
+
+// TestTrackOperation_Disabled は無効な場合は接続IDを取得せず、登録もしないことをテストします
+func TestTrackOperation_Disabled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	called := false
+	err := trackOperation(context.Background(), db, "SELECT 1;", func(ctx context.Context, q Queryer) error {
+		called = true
+		assert.Empty(t, ListActiveOperations())
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.True(t, called)
+	verifyExpectations(t, mock)
+}
+
+// TestCancelOperation は別の接続から対象の接続IDにKILL QUERYを発行することをテストします
+func TestCancelOperation(t *testing.T) {
+	withTrackLongOperations(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	adminDB, adminMock, _ := setupMockDB(t)
+	defer adminDB.Close()
+
+	expectConnectionID(mock, 42)
+	adminMock.ExpectExec(`KILL QUERY 42;`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := trackOperation(context.Background(), db, "SELECT SLEEP(10);", func(ctx context.Context, q Queryer) error {
+		ops := ListActiveOperations()
+		if !assert.Len(t, ops, 1) {
+			return nil
+		}
+		return CancelOperation(ctx, adminDB, ops[0].ID)
+	})
+
+	assert.NoError(t, err)
+	verifyExpectations(t, mock)
+	verifyExpectations(t, adminMock)
+}
+
+func TestCancelOperation_NotFound(t *testing.T) {
+	adminDB, adminMock, _ := setupMockDB(t)
+	defer adminDB.Close()
+
+	err := CancelOperation(context.Background(), adminDB, 999)
+
+	assert.True(t, errors.Is(err, ErrOperationNotFound))
+	verifyExpectations(t, adminMock)
+}
+
+// TestCancelOperation_HoldsRegistration はKILL QUERYの発行中は登録の解除が待たされ、
+// 解除の後は同じIDの操作を中断しないことをテストします
+func TestCancelOperation_HoldsRegistration(t *testing.T) {
+	registry := &operationRegistry{ops: make(map[int64]*operationEntry)}
+	id := registry.register("SELECT SLEEP(10);", 42)
+
+	killing := make(chan struct{})
+	release := make(chan struct{})
+	cancelled := make(chan error, 1)
+	go func() {
+		cancelled <- registry.whileRegistered(id, func(op ActiveOperation) error {
+			close(killing)
+			<-release
+			return nil
+		})
+	}()
+	<-killing
+
+	unregistered := make(chan struct{})
+	go func() {
+		registry.unregister(id)
+		close(unregistered)
+	}()
+	select {
+	case <-unregistered:
+		t.Fatal("KILL QUERYの発行中に登録が解除されるべきではない")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-cancelled)
+	<-unregistered
+
+	err := registry.whileRegistered(id, func(op ActiveOperation) error {
+		t.Fatal("解除された操作を中断するべきではない")
+		return nil
+	})
+	assert.True(t, errors.Is(err, ErrOperationNotFound))
+}
+
+// TestMovementReport_Tracked は登録が有効な場合にMovementReportが専用の接続で実行されることをテストします
+func TestMovementReport_Tracked(t *testing.T) {
+	withTrackLongOperations(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	expectConnectionID(mock, 42)
+	mock.ExpectQuery(`SELECT name, SUM\(delta\), COUNT\(\*\), MIN\(amount\), MAX\(amount\) FROM stock_log`).
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "sum", "count", "min", "max"}).AddRow("apple", 20, 2, 120, 150))
+
+	movements, err := MovementReport(context.Background(), db, from, to)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Movement{{Name: "apple", NetChange: 20, Changes: 2, MinAmount: 120, MaxAmount: 150}}, movements)
+	assert.Empty(t, ListActiveOperations())
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// CallUpsertProc はストアドプロシージャupsert_stock(name, amount)を呼び出して在庫数を加算または挿入します。
+// 処理の内容はプロシージャ側で決まるため、変更履歴や行のチェックサムなどのGo側のフックは実行しません。
+// CALLはプロシージャがSELECTしなくても空の結果セットを返すことがあるため、返された結果セットはすべて読み捨てます。
+func CallUpsertProc(db *sql.DB, name string, amount int) error {
+	return CallUpsertProcContext(context.Background(), db, name, amount)
+}
+
+// CallUpsertProcContext はCallUpsertProcのcontext対応版です。
+func CallUpsertProcContext(ctx context.Context, db *sql.DB, name string, amount int) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	obs := observeQuery(SQLCallUpsertProc)
+	rows, err := db.QueryContext(ctx, SQLCallUpsertProc, name, amount)
+	if err != nil {
+		obs.done(0, err)
+		return fmt.Errorf("プロシージャの呼び出しエラー: %w", newQueryError(ctx, "CallUpsertProc", SQLCallUpsertProc, err))
+	}
+	defer rows.Close()
+
+	read, err := drainResultSets(rows)
+	obs.done(read, err)
+	if err != nil {
+		return fmt.Errorf("プロシージャの結果の読み取りエラー: %w", newQueryError(ctx, "CallUpsertProc", SQLCallUpsertProc, err))
+	}
+	return nil
+}
+
+// drainResultSets はrowsのすべての結果セットを最後まで読み捨て、読んだ行数を返します。
+// 結果セットを読み切らないと、接続に未読の結果が残ってプールに戻せなくなります。
+func drainResultSets(rows *sql.Rows) (int, error) {
+	read := 0
+	for {
+		for rows.Next() {
+			read++
+		}
+		if err := rows.Err(); err != nil {
+			return read, err
+		}
+		if !rows.NextResultSet() {
+			return read, rows.Err()
+		}
+	}
+}
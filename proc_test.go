@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCallUpsertProc はCALL文を発行し、ドライバが返す空の結果セットを読み切ることをテストします
+func TestCallUpsertProc(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mock.ExpectQuery(sqlPattern(SQLCallUpsertProc)).WithArgs("apple", 5).
+		WillReturnRows(sqlmock.NewRows(nil))
+
+	require.NoError(t, CallUpsertProc(db, "apple", 5))
+	verifyExpectations(t, mock)
+}
+
+// TestCallUpsertProc_ResultSets はプロシージャが返した複数の結果セットをすべて読み捨てることをテストします
+func TestCallUpsertProc_ResultSets(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	observer := withQueryObserver(t)
+	mock.ExpectQuery(sqlPattern(SQLCallUpsertProc)).WithArgs("apple", 5).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"name", "amount"}).AddRow("apple", 15),
+			sqlmock.NewRows([]string{"affected"}).AddRow(1),
+		)
+
+	require.NoError(t, CallUpsertProc(db, "apple", 5))
+	verifyExpectations(t, mock)
+	require.Len(t, observer.infos, 1)
+	assert.Equal(t, 2, observer.infos[0].RowsScanned)
+}
+
+// TestCallUpsertProc_Error はプロシージャのエラーを操作名とSQL文を付けて返すことをテストします
+func TestCallUpsertProc_Error(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mock.ExpectQuery(sqlPattern(SQLCallUpsertProc)).WithArgs("apple", 5).
+		WillReturnError(errors.New("PROCEDURE upsert_stock does not exist"))
+
+	err := CallUpsertProc(db, "apple", 5)
+
+	var queryErr *QueryError
+	require.ErrorAs(t, err, &queryErr)
+	assert.Equal(t, "CallUpsertProc", queryErr.Operation)
+	assert.Equal(t, SQLCallUpsertProc, queryErr.Statement)
+	verifyExpectations(t, mock)
+}
+
+// TestCallUpsertProc_InvalidName は名前が不正な場合にプロシージャを呼び出さないことをテストします
+func TestCallUpsertProc_InvalidName(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	assert.ErrorIs(t, CallUpsertProc(db, "", 5), ErrInvalidName)
+	verifyExpectations(t, mock)
+}
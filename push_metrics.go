@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runDurationBuckets は実行時間のヒストグラムのバケットの上限（秒）です。
+var runDurationBuckets = []float64{1, 5, 15, 60, 300, 900, 3600}
+
+// activeRunMetrics はこの実行のメトリクスです。--push-metricsが指定されていない場合はnilで、何も記録しません。
+var activeRunMetrics *RunMetrics
+
+// RunMetrics は1回の実行で集計したメトリクスです。CLIはスクレイプされる前に終了するため、
+// 実行の終了時にPushRunMetricsでPushgatewayへ送信します。nilのRunMetricsへの記録は何もしません。
+type RunMetrics struct {
+	// Job はPushgatewayのjobラベルで、サブコマンドから決めます。
+	Job string
+	// Instance はinstanceラベルで、ホスト名です。
+	Instance string
+	// RunID は実行ごとに異なる識別子です。同じホストで同時に実行したジョブのメトリクスが上書きし合わないよう、グループのキーに含めます。
+	RunID string
+
+	start time.Time
+
+	mu           sync.Mutex
+	rowsImported int64
+	failures     map[ErrorClass]int64
+	duration     time.Duration
+	finished     bool
+}
+
+// NewRunMetrics はサブコマンドsubcommandの実行のRunMetricsを、現在時刻を開始時刻として返します。
+func NewRunMetrics(subcommand string) *RunMetrics {
+	job := "db_mock"
+	if subcommand != "" {
+		job += "_" + strings.ReplaceAll(subcommand, "-", "_")
+	}
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "unknown"
+	}
+	return &RunMetrics{
+		Job:      job,
+		Instance: instance,
+		RunID:    newRunID(),
+		start:    time.Now(),
+		failures: make(map[ErrorClass]int64),
+	}
+}
+
+// newRunID は実行を識別するランダムな文字列を返します。
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// RecordImport は取り込みの結果（適用した行数と行ごとの失敗）を記録します。
+func (m *RunMetrics) RecordImport(result BulkResult) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.rowsImported += int64(result.Inserted + result.Updated)
+	m.mu.Unlock()
+	for _, f := range result.Failures {
+		m.RecordFailure(f.Err)
+	}
+}
+
+// RecordFailure はerrをErrorReportの分類ごとの失敗として数えます。
+func (m *RunMetrics) RecordFailure(err error) {
+	if m == nil || err == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[ErrorReport(err).Class]++
+}
+
+// Finish は実行時間を確定し、errがnilでなければ失敗として数えます。2回目以降の呼び出しは何もしません。
+func (m *RunMetrics) Finish(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if m.finished {
+		m.mu.Unlock()
+		return
+	}
+	m.finished = true
+	m.duration = time.Since(m.start)
+	m.mu.Unlock()
+	m.RecordFailure(err)
+}
+
+// WriteTo はメトリクスをPrometheusのテキスト形式でwに書き出します。再試行の回数はRetryMetricsから取得します。
+func (m *RunMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# TYPE db_mock_rows_imported_total counter")
+	fmt.Fprintf(&buf, "db_mock_rows_imported_total %d\n", m.rowsImported)
+
+	fmt.Fprintln(&buf, "# TYPE db_mock_failures_total counter")
+	classes := make([]string, 0, len(m.failures))
+	for class := range m.failures {
+		classes = append(classes, string(class))
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(&buf, "db_mock_failures_total{class=%s} %d\n", strconv.Quote(class), m.failures[ErrorClass(class)])
+	}
+
+	retries := RetryMetrics()
+	concerns := make([]string, 0, len(retries))
+	for concern := range retries {
+		concerns = append(concerns, concern)
+	}
+	sort.Strings(concerns)
+	fmt.Fprintln(&buf, "# TYPE db_mock_retries_total counter")
+	for _, concern := range concerns {
+		counts := retries[concern]
+		fmt.Fprintf(&buf, "db_mock_retries_total{concern=%s} %d\n", strconv.Quote(concern), counts.Attempts-counts.Calls)
+	}
+	fmt.Fprintln(&buf, "# TYPE db_mock_retries_exhausted_total counter")
+	for _, concern := range concerns {
+		fmt.Fprintf(&buf, "db_mock_retries_exhausted_total{concern=%s} %d\n", strconv.Quote(concern), retries[concern].Exhausted)
+	}
+
+	seconds := m.duration.Seconds()
+	fmt.Fprintln(&buf, "# TYPE db_mock_run_duration_seconds histogram")
+	for _, le := range runDurationBuckets {
+		count := 0
+		if seconds <= le {
+			count = 1
+		}
+		fmt.Fprintf(&buf, "db_mock_run_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), count)
+	}
+	fmt.Fprintln(&buf, "db_mock_run_duration_seconds_bucket{le=\"+Inf\"} 1")
+	fmt.Fprintf(&buf, "db_mock_run_duration_seconds_sum %s\n", strconv.FormatFloat(seconds, 'g', -1, 64))
+	fmt.Fprintln(&buf, "db_mock_run_duration_seconds_count 1")
+
+	return buf.WriteTo(w)
+}
+
+// pushURL はPushgatewayのbaseURLに、job、instanceとrun_idをグループのキーとするパスを付けたURLを返します。
+func (m *RunMetrics) pushURL(baseURL string) string {
+	return strings.TrimSuffix(baseURL, "/") +
+		"/metrics/job/" + url.PathEscape(m.Job) +
+		"/instance/" + url.PathEscape(m.Instance) +
+		"/run_id/" + url.PathEscape(m.RunID)
+}
+
+// PushRunMetrics はmをbaseURLのPushgatewayへ送信します。送信はpushMetricsTimeoutで打ち切ります。
+func PushRunMetrics(ctx context.Context, baseURL string, m *RunMetrics) error {
+	var body bytes.Buffer
+	if _, err := m.WriteTo(&body); err != nil {
+		return fmt.Errorf("メトリクスの作成エラー: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pushMetricsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, m.pushURL(baseURL), &body)
+	if err != nil {
+		return fmt.Errorf("メトリクスの送信エラー: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("メトリクスの送信エラー: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("メトリクスの送信エラー: Pushgatewayがステータス %d を返しました", resp.StatusCode)
+	}
+	return nil
+}
+
+// finishRun は実行の結果をactiveRunMetricsに記録してpushMetricsURLへ送信し、errに対応する終了コードを返します。
+// 送信に失敗した場合はログに出力するだけで、終了コードには影響しません。
+func finishRun(err error) int {
+	if activeRunMetrics != nil && pushMetricsURL != "" {
+		activeRunMetrics.Finish(err)
+		if pushErr := PushRunMetrics(context.Background(), pushMetricsURL, activeRunMetrics); pushErr != nil {
+			log.Printf("メトリクスを送信できませんでした: %v", pushErr)
+		}
+	}
+	return exitCode(err)
+}
+
+// exitCode はerrで終了する場合の終了コードを返します。
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrMaintenanceMode):
+		return exitCodeMaintenance
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pushedRequest はPushgatewayのモックが受け取ったリクエストです
+type pushedRequest struct {
+	method      string
+	path        string
+	contentType string
+	body        string
+}
+
+// newPushgateway はリクエストを記録してstatusを返すPushgatewayのモックを起動します
+func newPushgateway(t *testing.T, status int) (*httptest.Server, <-chan pushedRequest) {
+	requests := make(chan pushedRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- pushedRequest{method: r.Method, path: r.URL.EscapedPath(), contentType: r.Header.Get("Content-Type"), body: string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+// withPushMetrics はテスト中だけメトリクスの送信先と、この実行のメトリクスを差し替えます
+func withPushMetrics(t *testing.T, url string, m *RunMetrics) {
+	originalURL, originalMetrics := pushMetricsURL, activeRunMetrics
+	pushMetricsURL, activeRunMetrics = url, m
+	t.Cleanup(func() { pushMetricsURL, activeRunMetrics = originalURL, originalMetrics })
+}
+
+// withPushMetricsTimeout はテスト中だけPushgatewayへの送信のタイムアウトを差し替えます
+func withPushMetricsTimeout(t *testing.T, timeout time.Duration) {
+	original := pushMetricsTimeout
+	pushMetricsTimeout = timeout
+	t.Cleanup(func() { pushMetricsTimeout = original })
+}
+
+// TestPushRunMetrics は集計したメトリクスをjob、instanceとrun_idのグループに送信することをテストします
+func TestPushRunMetrics(t *testing.T) {
+	server, requests := newPushgateway(t, http.StatusOK)
+	m := NewRunMetrics("backfill-history")
+	m.Instance, m.RunID = "host-1", "abc123"
+	m.start = time.Now().Add(-2 * time.Second)
+	m.RecordImport(BulkResult{Inserted: 3, Updated: 4, Rejected: 2, Failures: []ItemFailure{
+		{Name: "", Err: ErrInvalidName},
+		{Name: "x", Err: &NameRejectedError{Name: "x", Rule: "deny x"}},
+	}})
+	m.Finish(newDriverError(mysqlErrDeadlock, "Deadlock found"))
+
+	require.NoError(t, PushRunMetrics(context.Background(), server.URL+"/", m))
+
+	req := <-requests
+	assert.Equal(t, http.MethodPut, req.method)
+	assert.Equal(t, "/metrics/job/db_mock_backfill_history/instance/host-1/run_id/abc123", req.path)
+	assert.Contains(t, req.contentType, "text/plain")
+	assert.Contains(t, req.body, "db_mock_rows_imported_total 7\n")
+	assert.Contains(t, req.body, `db_mock_failures_total{class="validation"} 2`+"\n")
+	assert.Contains(t, req.body, `db_mock_failures_total{class="conflict"} 1`+"\n")
+	assert.Contains(t, req.body, "# TYPE db_mock_retries_total counter\n")
+	assert.Contains(t, req.body, `db_mock_run_duration_seconds_bucket{le="1"} 0`+"\n")
+	assert.Contains(t, req.body, `db_mock_run_duration_seconds_bucket{le="5"} 1`+"\n")
+	assert.Contains(t, req.body, "db_mock_run_duration_seconds_count 1\n")
+}
+
+// TestPushRunMetrics_RunID は同時に実行したジョブが別のグループに送信し、互いのメトリクスを上書きしないことをテストします
+func TestPushRunMetrics_RunID(t *testing.T) {
+	server, requests := newPushgateway(t, http.StatusOK)
+	first, second := NewRunMetrics("import"), NewRunMetrics("import")
+	first.Finish(nil)
+	second.Finish(nil)
+
+	require.NoError(t, PushRunMetrics(context.Background(), server.URL, first))
+	require.NoError(t, PushRunMetrics(context.Background(), server.URL, second))
+
+	assert.NotEqual(t, first.RunID, second.RunID)
+	assert.NotEqual(t, (<-requests).path, (<-requests).path, "グループのキーは実行ごとに異なるべき")
+}
+
+// TestPushRunMetrics_Timeout は応答しないPushgatewayへの送信をpushMetricsTimeoutで打ち切ることをテストします
+func TestPushRunMetrics_Timeout(t *testing.T) {
+	withPushMetricsTimeout(t, 50*time.Millisecond)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	m := NewRunMetrics("import")
+	m.Finish(nil)
+
+	start := time.Now()
+	err := PushRunMetrics(context.Background(), server.URL, m)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second, "タイムアウトで打ち切るべき")
+}
+
+// TestFinishRun_ExitCode は送信の成否によらず、処理の結果から終了コードを決めることをテストします
+func TestFinishRun_ExitCode(t *testing.T) {
+	withPushMetricsTimeout(t, 50*time.Millisecond)
+	failing, _ := newPushgateway(t, http.StatusInternalServerError)
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	results := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{name: "成功", err: nil, code: 0},
+		{name: "失敗", err: errors.New("boom"), code: 1},
+		{name: "メンテナンスモード", err: fmt.Errorf("取り込み: %w", ErrMaintenanceMode), code: exitCodeMaintenance},
+	}
+	for _, url := range []string{"", failing.URL, unreachable.URL} {
+		for _, tc := range results {
+			t.Run(fmt.Sprintf("%s/%q", tc.name, url), func(t *testing.T) {
+				withPushMetrics(t, url, NewRunMetrics("import"))
+				assert.Equal(t, tc.code, finishRun(tc.err))
+			})
+		}
+	}
+}
+
+// TestRunMetrics_Nil は--push-metricsが指定されていない場合に記録が何もしないことをテストします
+func TestRunMetrics_Nil(t *testing.T) {
+	var m *RunMetrics
+	assert.NotPanics(t, func() {
+		m.RecordImport(BulkResult{Inserted: 1})
+		m.RecordFailure(ErrInvalidName)
+		m.Finish(nil)
+	})
+}
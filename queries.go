@@ -0,0 +1,42 @@
+package main
+
+// stocksテーブルに対して発行する、引数以外に組み立てる部分のない固定のSQL文。
+// 本体とテストのモックの期待値の両方がこの定数を参照するため、SQL文を変更してもテストの期待値がずれません。
+// テストではsqlPatternでこの定数からsqlmockの期待値の正規表現を作ります。
+const (
+	// SQLTotalAmount は在庫数の合計を取得します（TotalStockAmount）。
+	SQLTotalAmount = "SELECT COALESCE(SUM(amount), 0) FROM stocks;"
+	// SQLCountStocks は商品数と在庫切れの商品数を取得します（CountStocks）。
+	SQLCountStocks = "SELECT COUNT(*), COALESCE(SUM(amount <= 0), 0) FROM stocks;"
+	// SQLEstimateRowCount はinformation_schemaから行数の推定値を取得します（EstimateRowCount）。
+	SQLEstimateRowCount = "SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'stocks';"
+	// SQLAmountsAscending は在庫数を昇順で全件取得します（MedianStockAmount）。
+	SQLAmountsAscending = "SELECT amount FROM stocks ORDER BY amount;"
+
+	// SQLFindDuplicateGroups は重複している名前ごとに残す行のidと在庫数の合計を取得します（DeduplicateStocks）。
+	SQLFindDuplicateGroups = "SELECT name, MIN(id), SUM(amount) FROM stocks GROUP BY name HAVING COUNT(*) > 1;"
+	// SQLMergeDuplicateAmount は残す行の在庫数を合計で更新します。引数は在庫数、idの順です（DeduplicateStocks）。
+	SQLMergeDuplicateAmount = "UPDATE stocks SET amount = ? WHERE id = ?;"
+	// SQLDeleteDuplicates は残す行以外の同じ名前の行を削除します。引数は名前、残す行のidの順です（DeduplicateStocks）。
+	SQLDeleteDuplicates = "DELETE FROM stocks WHERE name = ? AND id <> ?;"
+	// SQLResetAutoIncrement はAUTO_INCREMENTカウンタをリセットします（ResetAutoIncrement）。
+	SQLResetAutoIncrement = "ALTER TABLE stocks AUTO_INCREMENT = 1;"
+
+	// SQLNetDeltaSince は指定時刻以降の商品の変更量の合計を取得します。引数は名前、時刻の順です（StockVelocity）。
+	SQLNetDeltaSince = "SELECT COALESCE(SUM(delta), 0) FROM stock_log WHERE name = ? AND created_at >= ?;"
+
+	// SQLChangesAfterID はidが指定した値より大きい変更履歴をid順に取得します。引数はid、件数の順です（ListChangesAfter）。
+	SQLChangesAfterID = "SELECT id, name, operation, delta, amount, created_at FROM stock_log WHERE id > ? ORDER BY id LIMIT ?;"
+
+	// SQLStocksFingerprint は行数と全行のチェックサムを取得します（CheckReplicaConsistency）。
+	// チェックサムは行ごとのCRC32のXORなので、行の順序によらず全件を1回走査するだけで求まります。
+	SQLStocksFingerprint = "SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', id, name, amount))), 0) FROM stocks;"
+
+	// SQLCallUpsertProc はストアドプロシージャupsert_stockを呼び出します。引数は名前、在庫数の順です（CallUpsertProc）。
+	SQLCallUpsertProc = "CALL upsert_stock(?, ?);"
+
+	// SQLLastWriteForUpdate は行ロックを取得して在庫数と最終更新日時を取得します（UpsertStockLWW）。
+	SQLLastWriteForUpdate = "SELECT amount, updated_at FROM stocks WHERE name = ? FOR UPDATE;"
+	// SQLSetUpdatedAt は最終更新日時を指定した時刻にします。引数は時刻、名前の順です（UpsertStockLWW）。
+	SQLSetUpdatedAt = "UPDATE stocks SET updated_at = ? WHERE name = ?;"
+)
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSQLPattern は共有するSQL文から作った期待値が、本体が実行したSQL文に一致することをテストします
+func TestSQLPattern(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(42))
+
+	total, err := TotalStockAmount(db)
+
+	require.NoError(t, err)
+	assert.Equal(t, 42, total)
+	verifyExpectations(t, mock)
+}
+
+// TestSQLPattern_Exact は期待値がSQL文の全体にだけ一致し、タグの有無は問わないことをテストします
+func TestSQLPattern_Exact(t *testing.T) {
+	pattern := regexp.MustCompile(sqlPattern(SQLTotalAmount))
+
+	assert.True(t, pattern.MatchString(SQLTotalAmount))
+	assert.True(t, pattern.MatchString(taggedSQL(WithOperationID(context.Background(), "op-1"), "TotalStockAmount", SQLTotalAmount)))
+	assert.False(t, pattern.MatchString("SELECT COALESCE(SUM(amount), 0) FROM stocks FOR UPDATE;"), "別のSQL文には一致しないべき")
+	assert.False(t, pattern.MatchString("SELECT COALESCE(SUM(amount), 0) FROM stocks"), "末尾がずれたSQL文には一致しないべき")
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+
+	"db_moc/internal/stmt"
+)
+
+// queryTagApp はSQL文のタグのappに設定するアプリケーション名です。
+const queryTagApp = "db_mock"
+
+// taggedSQL はqueryの先頭に、アプリケーション名、操作名opとctxの操作ID（WithOperationID）を含むタグのコメントを付けて返します。
+// MySQLのスローログからSQL文を発行した操作をたどるためのもので、queryTaggingEnabledがfalseの場合はqueryをそのまま返します。
+// タグの値はstmt.Tagがエスケープするため、操作IDに利用者の入力が含まれていてもコメントの外には出ません。
+// SQL文を発行する直前に呼ぶため、ctxにRoundTripCounterが設定されていればopの往復の回数もここで数えます。
+func taggedSQL(ctx context.Context, op, query string) string {
+	countRoundTrip(ctx, op)
+	if !queryTaggingEnabled {
+		return query
+	}
+	id, _ := operationIDFrom(ctx)
+	return stmt.Tag{App: queryTagApp, Op: op, ID: id}.Prefix(query)
+}
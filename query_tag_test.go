@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withQueryTagging はテスト中だけSQL文のタグの有効/無効を切り替えます
+func withQueryTagging(t *testing.T, enabled bool) {
+	original := queryTaggingEnabled
+	queryTaggingEnabled = enabled
+	t.Cleanup(func() { queryTaggingEnabled = original })
+}
+
+// TestTaggedSQL は操作名とctxの操作IDをタグに含めることをテストします
+func TestTaggedSQL(t *testing.T) {
+	withQueryTagging(t, true)
+	ctx := WithOperationID(context.Background(), "req-42")
+
+	assert.Equal(t, "/* app:db_mock op:UpsertStock id:req-42 */ "+stmtStockAmount.SQL, taggedSQL(ctx, "UpsertStock", stmtStockAmount.SQL))
+	assert.Equal(t, "/* app:db_mock op:GetStock */ "+stmtStockAmount.SQL, taggedSQL(context.Background(), "GetStock", stmtStockAmount.SQL),
+		"操作IDがなければidを含めないべき")
+}
+
+// TestTaggedSQL_Escaping は操作IDに利用者の入力が含まれていても、コメントの外にSQL文を紛れ込ませられないことをテストします
+func TestTaggedSQL_Escaping(t *testing.T) {
+	withQueryTagging(t, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	ctx := WithOperationID(context.Background(), "x */ DELETE FROM stocks; /* ")
+
+	// タグまで含めて完全に一致することを確認する
+	expected := "/* app:db_mock op:UpsertStock id:x____DELETE_FROM_stocks_____ */ " + stmtStockAmount.SQL
+	mock.ExpectQuery("^" + regexp.QuoteMeta(expected) + "$").WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 101).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, UpsertStockContext(ctx, db, "apple", 1))
+	verifyExpectations(t, mock)
+}
+
+// TestTaggedSQL_Disabled はタグを無効にした場合にSQL文をそのまま発行することをテストします
+func TestTaggedSQL_Disabled(t *testing.T) {
+	withQueryTagging(t, false)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	ctx := WithOperationID(context.Background(), "req-42")
+
+	mock.ExpectQuery("^" + regexp.QuoteMeta(stmtStockAmount.SQL) + "$").WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	mock.ExpectExec("^"+regexp.QuoteMeta(stmtUpdateAmount.SQL)+"$").WithArgs(101, "apple").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.Equal(t, stmtStockAmount.SQL, taggedSQL(ctx, "UpsertStock", stmtStockAmount.SQL))
+	require.NoError(t, UpsertStockContext(ctx, db, "apple", 1))
+	verifyExpectations(t, mock)
+}
+
+// TestStmtCache_TaggedQuery はタグの操作IDが異なっても同じプリペアドステートメントを使い、タグを含めずにPrepareすることをテストします
+func TestStmtCache_TaggedQuery(t *testing.T) {
+	withQueryTagging(t, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// 最初の呼び出し元の操作名をほかの操作の実行に残さないよう、タグを付けずに準備する
+	mock.ExpectPrepare("^" + regexp.QuoteMeta(stmtStockAmount.SQL) + "$")
+
+	cache := NewStmtCache()
+	first, err := cache.Prepare(context.Background(), db, taggedSQL(WithOperationID(context.Background(), "req-1"), "UpsertStock", stmtStockAmount.SQL))
+	require.NoError(t, err)
+	second, err := cache.Prepare(context.Background(), db, taggedSQL(WithOperationID(context.Background(), "req-2"), "UpsertStock", stmtStockAmount.SQL))
+	require.NoError(t, err)
+	untagged, err := cache.Prepare(context.Background(), db, stmtStockAmount.SQL)
+	require.NoError(t, err)
+
+	assert.Same(t, first, second, "操作IDの違いで別のステートメントを準備しないべき")
+	assert.Same(t, first, untagged)
+	assert.Equal(t, []string{stmtStockAmount.SQL}, cache.Queries(), "キャッシュのキーはタグを除いたSQL文であるべき")
+	hits, misses := cache.Stats()
+	assert.Equal(t, int64(2), hits)
+	assert.Equal(t, int64(1), misses)
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded は商品の種類数がmaxItemsに達しているため新しい商品を追加できない場合に返されます。
+var ErrQuotaExceeded = errors.New("商品の種類数が上限に達しています")
+
+// QuotaExceededError は追加しようとした商品と、現在の種類数と上限です。errors.Is(err, ErrQuotaExceeded)で判定できます。
+type QuotaExceededError struct {
+	Name    string
+	Current int
+	Limit   int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%v: %s (現在 %d種類, 上限 %d種類)", ErrQuotaExceeded, e.Name, e.Current, e.Limit)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// stockQuotaTableDDL は商品の追加を直列化するためのロック用の行を保持するstock_quotaテーブルを作成するDDLです。
+// 行はid = 1の1行だけで、値は持ちません。
+const stockQuotaTableDDL = `
+CREATE TABLE IF NOT EXISTS stock_quota (
+    id TINYINT PRIMARY KEY
+);`
+
+// checkItemQuota はmaxItemsが設定されている場合に、トランザクション内で新しい商品を追加できるか確認します。
+// 商品を追加するトランザクションはstock_quotaの行をロックしてからCOUNT(*)で種類数を数えるため、
+// 同時に追加しても数えた後に他のトランザクションの追加が割り込むことはなく、上限を超えません。
+// ロックはコミットまで保持されるため、追加同士は直列化されます。既存の商品の更新では呼ばないでください。
+func checkItemQuota(ctx context.Context, tx *sql.Tx, name string) error {
+	if maxItems <= 0 {
+		return nil
+	}
+
+	// 行がまだない場合も作成と同時にロックする
+	lock := "INSERT INTO stock_quota (id) VALUES (1) ON DUPLICATE KEY UPDATE id = id;"
+	if _, err := tx.ExecContext(ctx, lock); err != nil {
+		return fmt.Errorf("商品数の上限の確認エラー: %w", err)
+	}
+	var current int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM stocks;").Scan(&current); err != nil {
+		return fmt.Errorf("商品数の上限の確認エラー: %w", err)
+	}
+	if current >= maxItems {
+		return &QuotaExceededError{Name: name, Current: current, Limit: maxItems}
+	}
+	return nil
+}
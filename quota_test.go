@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withMaxItems はテスト中だけ商品の種類数の上限を設定します
+func withMaxItems(t *testing.T, limit int) {
+	original := maxItems
+	maxItems = limit
+	t.Cleanup(func() { maxItems = original })
+}
+
+// expectItemQuota はロック用の行のロックと商品数の取得を期待値として設定します
+func expectItemQuota(mock sqlmock.Sqlmock, current int) {
+	mock.ExpectExec(`INSERT INTO stock_quota \(id\) VALUES \(1\) ON DUPLICATE KEY UPDATE id = id;`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM stocks;`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(current))
+}
+
+// TestUpsertStock_QuotaExceeded は種類数が上限に達している場合に新しい商品の追加を拒否することをテストします
+func TestUpsertStock_QuotaExceeded(t *testing.T) {
+	withMaxItems(t, 3)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "durian").WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	expectItemQuota(mock, 3)
+	mock.ExpectRollback()
+
+	err := UpsertStock(db, "durian", 10)
+
+	var quotaErr *QuotaExceededError
+	if assert.True(t, errors.As(err, &quotaErr), "QuotaExceededErrorが返るべき: %v", err) {
+		assert.Equal(t, QuotaExceededError{Name: "durian", Current: 3, Limit: 3}, *quotaErr)
+	}
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_QuotaBelowLimit は上限未満であればロック用の行をロックしてから追加することをテストします
+func TestUpsertStock_QuotaBelowLimit(t *testing.T) {
+	withMaxItems(t, 3)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "durian").WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	expectItemQuota(mock, 2)
+	expectInsertStock(mock, "durian", 10).WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, UpsertStock(db, "durian", 10))
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_UpdateAtLimit は上限に達していても既存の商品の更新は確認せずに適用することをテストします
+func TestUpsertStock_UpdateAtLimit(t *testing.T) {
+	withMaxItems(t, 1)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 150).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, UpsertStock(db, "apple", 50), "既存の商品の更新は上限の影響を受けないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestBulkUpsertStocks_Quota は一括更新で上限を超える追加だけを拒否し、更新と上限内の追加は適用することをテストします
+func TestBulkUpsertStocks_Quota(t *testing.T) {
+	withMaxItems(t, 2)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectBulkUpdate(mock, "apple", 100, 50)
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE;`).WithArgs("banana").WillReturnError(sql.ErrNoRows)
+	expectItemQuota(mock, 1)
+	mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).WithArgs("banana", 30).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE;`).WithArgs("cherry").WillReturnError(sql.ErrNoRows)
+	expectItemQuota(mock, 2)
+	mock.ExpectCommit()
+
+	result, err := BulkUpsertStocks(db, []StockUpdate{
+		{Name: "apple", Amount: 50},
+		{Name: "banana", Amount: 30},
+		{Name: "cherry", Amount: 10},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Inserted)
+	assert.Equal(t, 1, result.Updated)
+	assert.Equal(t, 1, result.Rejected)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, "cherry", result.Failures[0].Name)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrQuotaExceeded))
+	}
+	verifyExpectations(t, mock)
+}
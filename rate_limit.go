@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter はトークンバケット方式でDB操作の頻度を制限します。
+// 1秒あたりperSecond個のトークンが補充され、最大burst個まで貯められます。
+// nilのRateLimiterは制限なしとして扱われます。
+type RateLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+// NewRateLimiter は1秒あたりperSecond回、最大burst回まで連続して操作を許可するRateLimiterを返します。
+// perSecondが0以下の場合はnil（制限なし）を返します。burstが1未満の場合は1として扱います。
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// reserve はトークンを1つ予約し、利用できるまでの待ち時間を返します。
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.perSecond)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.perSecond * float64(time.Second))
+}
+
+// cancel は待機を中断した予約のトークンを返却します。
+func (l *RateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = min(l.burst, l.tokens+1)
+}
+
+// Wait はトークンが利用できるまで待ちます。
+// 待機中にctxがキャンセルされた場合は予約を取り消し、ctxのエラーを返します。
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// RateLimitedRepository はDB操作の前にRateLimiterでトークンを待つStockRepositoryです。
+// 小さなDBを一時的な操作の集中から守るために使います。
+type RateLimitedRepository struct {
+	repo    StockRepository
+	limiter *RateLimiter
+}
+
+// NewRateLimitedRepository はrepoへの操作をlimiterで制限するRateLimitedRepositoryを返します。
+// limiterがnilの場合は制限せずにそのまま委譲します。
+func NewRateLimitedRepository(repo StockRepository, limiter *RateLimiter) *RateLimitedRepository {
+	return &RateLimitedRepository{repo: repo, limiter: limiter}
+}
+
+// wait はトークンが利用できるまで待ちます。
+func (r *RateLimitedRepository) wait(ctx context.Context) error {
+	if err := r.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("レート制限の待機が中断されました: %w", err)
+	}
+	return nil
+}
+
+// Ping はトークンを待ってからDBへの接続を確認します。
+func (r *RateLimitedRepository) Ping(ctx context.Context) error {
+	if err := r.wait(ctx); err != nil {
+		return err
+	}
+	return r.repo.Ping(ctx)
+}
+
+// QueryStocks はトークンを待ってから在庫データを取得します。
+func (r *RateLimitedRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.repo.QueryStocks(ctx, name)
+}
+
+// UpsertStock はトークンを待ってから在庫データを更新または挿入します。
+func (r *RateLimitedRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	if err := r.wait(ctx); err != nil {
+		return err
+	}
+	return r.repo.UpsertStock(ctx, name, amount, opts...)
+}
+
+// EnsureSchema はトークンを待ってからテーブルを作成します。
+func (r *RateLimitedRepository) EnsureSchema(ctx context.Context) error {
+	if err := r.wait(ctx); err != nil {
+		return err
+	}
+	return r.repo.EnsureSchema(ctx)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRateLimitedRepository_DelaysOperations は設定した頻度を超える操作が待たされることをテストします
+func TestRateLimitedRepository_DelaysOperations(t *testing.T) {
+	fake := &fakeStockRepository{stocks: map[string]int{}}
+	// 1秒あたり20回（50msごと）、連続1回まで
+	repo := NewRateLimitedRepository(fake, NewRateLimiter(20, 1))
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, repo.UpsertStock(ctx, "apple", 1))
+	}
+	elapsed := time.Since(start)
+
+	// 1回目はすぐに実行され、残りの3回はそれぞれ約50ms待たされる
+	assert.GreaterOrEqual(t, elapsed, 140*time.Millisecond, "設定した頻度を超える操作は待たされるべき")
+	assert.Equal(t, 4, fake.stocks["apple"], "すべての操作が委譲されるべき")
+}
+
+// TestRateLimitedRepository_Burst はburstの回数までは待たずに実行されることをテストします
+func TestRateLimitedRepository_Burst(t *testing.T) {
+	fake := &fakeStockRepository{stocks: map[string]int{"apple": 1}}
+	repo := NewRateLimitedRepository(fake, NewRateLimiter(1, 3))
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := repo.QueryStocks(ctx, "apple")
+		assert.NoError(t, err)
+	}
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "burstの範囲内では待たされないべき")
+}
+
+// TestRateLimitedRepository_Unlimited は既定の設定では制限されないことをテストします
+func TestRateLimitedRepository_Unlimited(t *testing.T) {
+	assert.Nil(t, NewRateLimiter(features.RateLimit, features.RateBurst), "既定では制限なしであるべき")
+
+	fake := &fakeStockRepository{stocks: map[string]int{}}
+	repo := NewRateLimitedRepository(fake, nil)
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, repo.Ping(ctx))
+	}
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "制限なしでは待たされないべき")
+}
+
+// TestRateLimitedRepository_ContextCanceled は待機中にcontextが期限切れになると操作を行わずに中断することをテストします
+func TestRateLimitedRepository_ContextCanceled(t *testing.T) {
+	fake := &fakeStockRepository{stocks: map[string]int{}}
+	limiter := NewRateLimiter(1, 1)
+	repo := NewRateLimitedRepository(fake, limiter)
+
+	assert.NoError(t, repo.UpsertStock(context.Background(), "apple", 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := repo.UpsertStock(ctx, "apple", 1)
+
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "contextのエラーを返すべき: %v", err)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "トークンの補充を待たずに中断するべき")
+	assert.Equal(t, 1, fake.stocks["apple"], "中断した操作は委譲されないべき")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DiscrepancyKind は突き合わせで見つかった差異の種類です。
+type DiscrepancyKind string
+
+const (
+	// DiscrepancyAmount は両方に存在するが在庫数が異なることを表します。
+	DiscrepancyAmount DiscrepancyKind = "amount"
+	// DiscrepancyMissingInDB はCSV（またはスナップショット）にのみ存在することを表します。
+	DiscrepancyMissingInDB DiscrepancyKind = "missing_in_db"
+	// DiscrepancyMissingInCSV はstocksテーブルにのみ存在することを表します。
+	DiscrepancyMissingInCSV DiscrepancyKind = "missing_in_csv"
+	// DiscrepancyMissingInSnapshot はスナップショットの保存後にstocksテーブルに追加されたことを表します。
+	DiscrepancyMissingInSnapshot DiscrepancyKind = "missing_in_snapshot"
+)
+
+// Discrepancy は1商品の差異です。存在しない側の在庫数は0です。
+type Discrepancy struct {
+	Name     string
+	Kind     DiscrepancyKind
+	Expected int
+	Actual   int
+}
+
+// ReconcileCSV は"name,amount"形式のCSVを期待する在庫数として、stocksテーブルの現在の在庫数と突き合わせます。
+// 在庫数の不一致と、どちらか一方にしか存在しない商品を名前順に返します。差異がない場合は空のスライスを返します。
+// CSVの形式はParseStockCSVと同じで、同じ商品名が複数回現れる場合はエラーを返します。
+func ReconcileCSV(db *sql.DB, r io.Reader) ([]Discrepancy, error) {
+	return ReconcileCSVContext(context.Background(), db, r)
+}
+
+// ReconcileCSVContext はReconcileCSVのcontext対応版です。
+func ReconcileCSVContext(ctx context.Context, db *sql.DB, r io.Reader) ([]Discrepancy, error) {
+	items, err := ParseStockCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	expected := make(map[string]int, len(items))
+	for _, item := range items {
+		if _, ok := expected[item.Name]; ok {
+			return nil, fmt.Errorf("CSVに商品名が重複しています: %s", item.Name)
+		}
+		expected[item.Name] = item.Amount
+	}
+	return reconcileAmounts(ctx, db, expected, DiscrepancyMissingInCSV)
+}
+
+// reconcileAmounts はexpectedを期待する在庫数として、stocksテーブルの現在の在庫数と突き合わせた差異を名前順に返します。
+// stocksテーブルにのみ存在する商品はextraKind、expectedにのみ存在する商品はDiscrepancyMissingInDBとして返します。
+func reconcileAmounts(ctx context.Context, db *sql.DB, expected map[string]int, extraKind DiscrepancyKind) ([]Discrepancy, error) {
+	rows, err := db.QueryContext(ctx, queryStocksOrderedByName)
+	if err != nil {
+		return nil, fmt.Errorf("在庫データの取得エラー: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	discrepancies := []Discrepancy{}
+	for rows.Next() {
+		var s Stock
+		if err := rows.Scan(&s.ID, &s.Name, &s.Amount); err != nil {
+			return nil, fmt.Errorf("在庫データの読み込みエラー: %w", err)
+		}
+		want, ok := expected[s.Name]
+		switch {
+		case !ok:
+			discrepancies = append(discrepancies, Discrepancy{Name: s.Name, Kind: extraKind, Actual: int(s.Amount)})
+		case want != int(s.Amount):
+			discrepancies = append(discrepancies, Discrepancy{Name: s.Name, Kind: DiscrepancyAmount, Expected: want, Actual: int(s.Amount)})
+		}
+		delete(expected, s.Name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("在庫データの読み込みエラー: %w", err)
+	}
+
+	for name, want := range expected {
+		discrepancies = append(discrepancies, Discrepancy{Name: name, Kind: DiscrepancyMissingInDB, Expected: want})
+	}
+	sort.Slice(discrepancies, func(i, j int) bool { return discrepancies[i].Name < discrepancies[j].Name })
+	return discrepancies, nil
+}
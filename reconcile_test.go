@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectOrderedStocks は名前順の在庫データの取得を期待値として設定します
+func expectOrderedStocks(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(regexp.QuoteMeta(queryStocksOrderedByName)).WillReturnRows(rows)
+}
+
+// TestReconcileCSV_Match はCSVとstocksテーブルが一致する場合に差異を返さないことをテストします
+func TestReconcileCSV_Match(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectOrderedStocks(mock, sqlmock.NewRows([]string{"id", "name", "amount"}).
+		AddRow(1, "apple", 100).
+		AddRow(2, "banana", 30))
+
+	discrepancies, err := ReconcileCSV(db, strings.NewReader("name,amount\nbanana,30\napple,100\n"))
+
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies, "差異はないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestReconcileCSV_Discrepancies は在庫数の不一致と、どちらか一方にしかない商品を名前順に返すことをテストします
+func TestReconcileCSV_Discrepancies(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectOrderedStocks(mock, sqlmock.NewRows([]string{"id", "name", "amount"}).
+		AddRow(1, "apple", 100).
+		AddRow(2, "banana", 25).
+		AddRow(3, "cherry", 10).
+		AddRow(4, "durian", 0))
+
+	csv := "apple,100\nbanana,30\ndurian,5\nelderberry,7\n"
+	discrepancies, err := ReconcileCSV(db, strings.NewReader(csv))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Discrepancy{
+		{Name: "banana", Kind: DiscrepancyAmount, Expected: 30, Actual: 25},
+		{Name: "cherry", Kind: DiscrepancyMissingInCSV, Actual: 10},
+		{Name: "durian", Kind: DiscrepancyAmount, Expected: 5, Actual: 0},
+		{Name: "elderberry", Kind: DiscrepancyMissingInDB, Expected: 7},
+	}, discrepancies)
+	verifyExpectations(t, mock)
+}
+
+// TestReconcileCSV_Errors は不正なCSVと取得エラーをテストします
+func TestReconcileCSV_Errors(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	_, err := ReconcileCSV(db, strings.NewReader("apple,ten\n"))
+	assert.ErrorContains(t, err, "数量が数値ではありません")
+
+	_, err = ReconcileCSV(db, strings.NewReader("apple,1\napple,2\n"))
+	assert.ErrorContains(t, err, "商品名が重複しています", "重複した商品名は拒否されるべき")
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryStocksOrderedByName)).WillReturnError(errors.New("connection refused"))
+	_, err = ReconcileCSV(db, strings.NewReader("apple,1\n"))
+	assert.ErrorContains(t, err, "在庫データの取得エラー")
+	verifyExpectations(t, mock)
+}
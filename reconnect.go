@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// reconnectCall は実行中の再接続です。doneが閉じられた後はdbとerrが確定します。
+type reconnectCall struct {
+	done chan struct{}
+	db   *sql.DB
+	err  error
+}
+
+// Reconnector は接続先のプールを保持し、接続が切れた場合にプールを開き直します。
+// 多数のgoroutineが同時に接続断を検出しても、開き直すのは1回だけで、他の呼び出しはその結果を待って共有します。
+type Reconnector struct {
+	connect func() (*sql.DB, error)
+
+	mu     sync.Mutex
+	db     *sql.DB
+	flight *reconnectCall
+}
+
+// NewReconnector はconnectでプールを開くReconnectorを返します。最初のプールは最初に使う時点で開きます。
+func NewReconnector(connect func() (*sql.DB, error)) *Reconnector {
+	return &Reconnector{connect: connect}
+}
+
+// processReconnector はWithReconnectが使う、プロセスで共有するReconnectorです。ConnectDBでプールを開きます。
+var processReconnector = NewReconnector(func() (*sql.DB, error) { return ConnectDB() })
+
+// WithReconnect はプロセスで共有するプールでfnを実行します。fnが接続断で失敗した場合は、プールを開き直してfnを1回だけ再実行します。
+func WithReconnect(ctx context.Context, fn func(ctx context.Context, db *sql.DB) error) error {
+	return processReconnector.Do(ctx, fn)
+}
+
+// Do は現在のプールでfnを実行します。fnが接続断で失敗した場合は、プールを開き直してfnを1回だけ再実行します。
+// 開き直すのに失敗した場合は、再接続のエラーとfnのエラーの両方を含むエラーを返します。
+func (r *Reconnector) Do(ctx context.Context, fn func(ctx context.Context, db *sql.DB) error) error {
+	db, err := r.current(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(ctx, db)
+	if !isBadConn(err) {
+		return err
+	}
+	fresh, reconnectErr := r.reconnect(ctx, db)
+	if reconnectErr != nil {
+		return fmt.Errorf("再接続エラー: %w（元のエラー: %w）", reconnectErr, err)
+	}
+	return fn(ctx, fresh)
+}
+
+// current は現在のプールを返します。まだ開いていなければ開きます。
+func (r *Reconnector) current(ctx context.Context) (*sql.DB, error) {
+	r.mu.Lock()
+	db := r.db
+	r.mu.Unlock()
+	if db != nil {
+		return db, nil
+	}
+	return r.reconnect(ctx, nil)
+}
+
+// reconnect はstaleで接続断を検出した呼び出しのためにプールを開き直し、新しいプールを返します。
+// 他の呼び出しがすでにstaleを開き直していればそのプールを返し、開き直している途中であればその結果を待ちます。
+// 開き直す処理は呼び出し元のどれか1つのキャンセルで他の呼び出しが失敗しないよう、キャンセルを外したctxで実行します。
+// 各呼び出しは自分のctxが終了した時点で待つのをやめ、ctxのエラーを返します。
+func (r *Reconnector) reconnect(ctx context.Context, stale *sql.DB) (*sql.DB, error) {
+	r.mu.Lock()
+	if r.db != stale {
+		db := r.db
+		r.mu.Unlock()
+		return db, nil
+	}
+	call := r.flight
+	if call == nil {
+		call = &reconnectCall{done: make(chan struct{})}
+		r.flight = call
+		go r.open(context.WithoutCancel(ctx), call, stale)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.db, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// open は新しいプールを開いて接続を確認し、成功した場合はstaleと置き換えてstaleを閉じます。
+func (r *Reconnector) open(ctx context.Context, call *reconnectCall, stale *sql.DB) {
+	db, err := r.connect()
+	if err == nil {
+		if err = db.PingContext(ctx); err != nil {
+			db.Close()
+			db = nil
+		}
+	}
+
+	r.mu.Lock()
+	r.flight = nil
+	if err == nil {
+		r.db = db
+	}
+	r.mu.Unlock()
+
+	call.db, call.err = db, err
+	close(call.done)
+	if err == nil && stale != nil {
+		// 実行中のクエリの終了を待つため、待っている呼び出しに結果を返してから閉じる
+		stale.Close()
+	}
+}
+
+// isBadConn はerrが接続断によるエラーかどうかを返します。
+// 接続数の上限やタイムアウトは開き直しても解消しないうえ、再接続でDBの負荷を増やすため含めません。
+func isBadConn(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		(errors.As(err, &netErr) && !netErr.Timeout())
+}
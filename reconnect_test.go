@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingConnect は呼ばれるたびに新しいモックのプールを開き、呼ばれた回数を数えるconnectを返します。
+// releaseがnilでなければ、閉じられるまでプールを開くのを待ちます
+func countingConnect(release <-chan struct{}) (func() (*sql.DB, error), *atomic.Int32) {
+	var calls atomic.Int32
+	return func() (*sql.DB, error) {
+		calls.Add(1)
+		if release != nil {
+			<-release
+		}
+		db, _, err := sqlmock.New()
+		return db, err
+	}, &calls
+}
+
+// TestReconnector_SingleReconnect は多数のgoroutineが同時に接続断を検出しても、再接続は1回だけ行われることをテストします
+func TestReconnector_SingleReconnect(t *testing.T) {
+	release := make(chan struct{})
+	connect, calls := countingConnect(nil)
+	r := NewReconnector(connect)
+	first, err := r.current(context.Background())
+	require.NoError(t, err)
+
+	// 再接続を止めておき、全員が接続断を検出してから再開する
+	connect, calls = countingConnect(release)
+	r.connect = connect
+
+	const workers = 50
+	var failed sync.WaitGroup
+	failed.Add(workers)
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	used := make([]*sql.DB, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = r.Do(context.Background(), func(ctx context.Context, db *sql.DB) error {
+				if db == first {
+					failed.Done()
+					return driver.ErrBadConn
+				}
+				used[i] = db
+				return nil
+			})
+		}()
+	}
+	failed.Wait()
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load(), "再接続は1回だけ行うべき")
+	for i := 0; i < workers; i++ {
+		require.NoError(t, errs[i])
+		assert.Same(t, used[0], used[i], "全員が同じ新しいプールを使うべき")
+	}
+	assert.NotSame(t, first, used[0])
+}
+
+// TestReconnector_SharedFailure は再接続の失敗を待っていた全員に返し、次の接続断で改めて再接続することをテストします
+func TestReconnector_SharedFailure(t *testing.T) {
+	connect, _ := countingConnect(nil)
+	r := NewReconnector(connect)
+	first, err := r.current(context.Background())
+	require.NoError(t, err)
+
+	refused := errors.New("connection refused")
+	var calls atomic.Int32
+	r.connect = func() (*sql.DB, error) {
+		if calls.Add(1) == 1 {
+			return nil, refused
+		}
+		return connect()
+	}
+	badConn := func(ctx context.Context, db *sql.DB) error {
+		if db == first {
+			return driver.ErrBadConn
+		}
+		return nil
+	}
+
+	err = r.Do(context.Background(), badConn)
+	assert.ErrorIs(t, err, refused)
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+
+	require.NoError(t, r.Do(context.Background(), badConn))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+// TestReconnector_OtherErrors は接続断以外のエラーでは再接続しないことをテストします
+func TestReconnector_OtherErrors(t *testing.T) {
+	connect, calls := countingConnect(nil)
+	r := NewReconnector(connect)
+
+	for _, err := range []error{sql.ErrNoRows, newDriverError(mysqlErrTooManyConnections, "Too many connections"), context.DeadlineExceeded} {
+		assert.ErrorIs(t, r.Do(context.Background(), func(ctx context.Context, db *sql.DB) error { return err }), err)
+	}
+	assert.Equal(t, int32(1), calls.Load(), "最初の接続だけを開くべき")
+}
+
+// TestReconnector_WaiterCancel は再接続を待っている呼び出しが自分のctxの終了で待つのをやめることをテストします
+func TestReconnector_WaiterCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	connect, _ := countingConnect(nil)
+	r := NewReconnector(connect)
+	first, err := r.current(context.Background())
+	require.NoError(t, err)
+	r.connect, _ = countingConnect(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.reconnect(ctx, first)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrReplicaDiverged はレプリカのstocksテーブルの内容がプライマリと一致しない場合に返されます。
+var ErrReplicaDiverged = errors.New("レプリカの在庫データがプライマリと一致しません")
+
+// stocksFingerprint はstocksテーブルの行数とチェックサムです。
+type stocksFingerprint struct {
+	rows     int
+	checksum uint64
+}
+
+// CheckReplicaConsistency はプライマリとレプリカのstocksテーブルの行数とチェックサムを比べ、レプリカの読み取りを信用できるか確認します。
+// 一致しない場合は行数の差をlagRowsとして、ErrReplicaDivergedを含むエラーとともに返します。
+// 行数が同じで在庫数だけが異なる場合、lagRowsは0です。一致する場合は0とnilを返します。
+// CheckReplicationLagが書き込みの反映にかかる時間を計るのに対し、こちらは書き込みをせずにその時点の内容の差を確認します。
+// プライマリを先に読むため、その間にレプリカが追いついた分は差として報告しません。
+func CheckReplicaConsistency(primary, replica *sql.DB) (lagRows int, err error) {
+	return CheckReplicaConsistencyContext(context.Background(), primary, replica)
+}
+
+// CheckReplicaConsistencyContext はCheckReplicaConsistencyのcontext対応版です。
+func CheckReplicaConsistencyContext(ctx context.Context, primary, replica Queryer) (lagRows int, err error) {
+	want, err := queryStocksFingerprint(ctx, primary)
+	if err != nil {
+		return 0, fmt.Errorf("プライマリのチェックサムの取得エラー: %w", err)
+	}
+	got, err := queryStocksFingerprint(ctx, replica)
+	if err != nil {
+		return 0, fmt.Errorf("レプリカのチェックサムの取得エラー: %w", err)
+	}
+	if got == want {
+		return 0, nil
+	}
+	lagRows = want.rows - got.rows
+	if lagRows < 0 {
+		lagRows = -lagRows
+	}
+	return lagRows, fmt.Errorf("%w: 行数 プライマリ %d / レプリカ %d、チェックサム プライマリ %d / レプリカ %d",
+		ErrReplicaDiverged, want.rows, got.rows, want.checksum, got.checksum)
+}
+
+// queryStocksFingerprint はqのstocksテーブルの行数とチェックサムを取得します。
+func queryStocksFingerprint(ctx context.Context, q Queryer) (stocksFingerprint, error) {
+	var f stocksFingerprint
+	if err := q.QueryRowContext(ctx, SQLStocksFingerprint).Scan(&f.rows, &f.checksum); err != nil {
+		return stocksFingerprint{}, classifyError(err)
+	}
+	return f, nil
+}
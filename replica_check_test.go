@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectFingerprint はstocksテーブルの行数とチェックサムの取得を期待値として設定します
+func expectFingerprint(mock sqlmock.Sqlmock, rows int, checksum uint64) {
+	mock.ExpectQuery(sqlPattern(SQLStocksFingerprint)).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "checksum"}).AddRow(rows, checksum))
+}
+
+// TestCheckReplicaConsistency はプライマリとレプリカの行数とチェックサムを比べ、差を報告することをテストします
+func TestCheckReplicaConsistency(t *testing.T) {
+	tests := []struct {
+		name            string
+		primaryRows     int
+		primaryChecksum uint64
+		replicaRows     int
+		replicaChecksum uint64
+		lagRows         int
+		diverged        bool
+	}{
+		{name: "一致", primaryRows: 3, primaryChecksum: 0xdeadbeef, replicaRows: 3, replicaChecksum: 0xdeadbeef},
+		{name: "レプリカが2行遅れている", primaryRows: 5, primaryChecksum: 0xdeadbeef, replicaRows: 3, replicaChecksum: 0x1234, lagRows: 2, diverged: true},
+		{name: "在庫数だけが異なる", primaryRows: 3, primaryChecksum: 0xdeadbeef, replicaRows: 3, replicaChecksum: 0x1234, lagRows: 0, diverged: true},
+		{name: "レプリカにだけ行がある", primaryRows: 2, primaryChecksum: 0x1234, replicaRows: 3, replicaChecksum: 0xdeadbeef, lagRows: 1, diverged: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			primary, primaryMock, replica, replicaMock := newReplicaMocks(t)
+			expectFingerprint(primaryMock, tc.primaryRows, tc.primaryChecksum)
+			expectFingerprint(replicaMock, tc.replicaRows, tc.replicaChecksum)
+
+			lagRows, err := CheckReplicaConsistency(primary, replica)
+
+			assert.Equal(t, tc.lagRows, lagRows)
+			if tc.diverged {
+				assert.ErrorIs(t, err, ErrReplicaDiverged)
+			} else {
+				assert.NoError(t, err)
+			}
+			verifyExpectations(t, primaryMock)
+			verifyExpectations(t, replicaMock)
+		})
+	}
+}
+
+// TestCheckReplicaConsistency_QueryError はプライマリの取得に失敗した場合にレプリカを読まないことをテストします
+func TestCheckReplicaConsistency_QueryError(t *testing.T) {
+	primary, primaryMock, replica, replicaMock := newReplicaMocks(t)
+	primaryMock.ExpectQuery(sqlPattern(SQLStocksFingerprint)).WillReturnError(assert.AnError)
+
+	_, err := CheckReplicaConsistency(primary, replica)
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.NotErrorIs(t, err, ErrReplicaDiverged)
+	verifyExpectations(t, primaryMock)
+	verifyExpectations(t, replicaMock)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidTimeRange は集計期間の開始が終了より前でない場合に返されます。
+var ErrInvalidTimeRange = errors.New("開始日時は終了日時より前である必要があります")
+
+// コマンドラインで受け付ける日時の書式
+const (
+	reportDateLayout     = "2006-01-02"
+	reportDateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// Movement は期間内の1商品分の在庫の動きです。
+type Movement struct {
+	Name string
+	// NetChange は期間内の変更量の合計です。
+	NetChange int
+	// Changes は期間内の変更回数です。
+	Changes int
+	// MinAmount とMaxAmount は期間内の変更後の在庫数の最小値と最大値です。
+	MinAmount int
+	MaxAmount int
+}
+
+// MovementReport はfrom以上to未満に記録された変更履歴を商品ごとに集計します。
+// 結果は正味の変更量の絶対値が大きい順で、同じ場合は名前順です。
+func MovementReport(ctx context.Context, db *sql.DB, from, to time.Time) ([]Movement, error) {
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: %s - %s", ErrInvalidTimeRange, from.Format(reportDateTimeLayout), to.Format(reportDateTimeLayout))
+	}
+
+	query := "SELECT name, SUM(delta), COUNT(*), MIN(amount), MAX(amount) FROM stock_log " +
+		"WHERE created_at >= ? AND created_at < ? GROUP BY name ORDER BY ABS(SUM(delta)) DESC, name;"
+	var movements []Movement
+	err := trackOperation(ctx, db, query, func(ctx context.Context, q Queryer) error {
+		rows, err := q.QueryContext(ctx, query, from, to)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		movements, err = scanMovements(rows)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("在庫の動きの集計エラー: %w", err)
+	}
+	return movements, nil
+}
+
+// scanMovements は行セットの全行をMovementとして読み取ります。
+func scanMovements(rows *sql.Rows) ([]Movement, error) {
+	movements := []Movement{}
+	for rows.Next() {
+		var m Movement
+		if err := rows.Scan(&m.Name, &m.NetChange, &m.Changes, &m.MinAmount, &m.MaxAmount); err != nil {
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return movements, nil
+}
+
+// parseReportTime はtimeLocationで日時を解釈します。
+// 日付のみの場合、endがtrueならその日の終わり（翌日0時）を返すため、終了日を含めた期間になります。
+func parseReportTime(value string, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(reportDateTimeLayout, value, timeLocation); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(reportDateLayout, value, timeLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("日時の形式が正しくありません: %s (%s または %s)", value, reportDateLayout, reportDateTimeLayout)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// runReportCommand はreportサブコマンドを実行します。
+// 使い方: report --from 2025-03-01 --to 2025-03-31 [--format table|csv]
+func runReportCommand(ctx context.Context, w io.Writer, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	fs.SetOutput(w)
+	fromArg := fs.String("from", "", "集計の開始日時（この日時を含む）")
+	toArg := fs.String("to", "", "集計の終了日時（日付のみの場合はその日を含む）")
+	format := fs.String("format", formatTable, "出力形式（table または csv）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *fromArg == "" || *toArg == "" {
+		return errors.New("--fromと--toを指定してください")
+	}
+
+	from, err := parseReportTime(*fromArg, false)
+	if err != nil {
+		return err
+	}
+	to, err := parseReportTime(*toArg, true)
+	if err != nil {
+		return err
+	}
+
+	movements, err := MovementReport(ctx, db, from, to)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"name", "net_change", "changes", "min_amount", "max_amount"}
+	records := make([][]string, 0, len(movements))
+	for _, m := range movements {
+		records = append(records, []string{
+			m.Name,
+			strconv.Itoa(m.NetChange),
+			strconv.Itoa(m.Changes),
+			strconv.Itoa(m.MinAmount),
+			strconv.Itoa(m.MaxAmount),
+		})
+	}
+	return writeRecords(w, *format, header, records)
+}
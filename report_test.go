@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const movementReportRegex = `SELECT name, SUM\(delta\), COUNT\(\*\), MIN\(amount\), MAX\(amount\) FROM stock_log ` +
+	`WHERE created_at >= \? AND created_at < \? GROUP BY name ORDER BY ABS\(SUM\(delta\)\) DESC, name;`
+
+// withTimeLocation はテスト中だけ日時を解釈するタイムゾーンを差し替えます
+func withTimeLocation(t *testing.T, loc *time.Location) {
+	original := timeLocation
+	timeLocation = loc
+	t.Cleanup(func() { timeLocation = original })
+}
+
+func newMovementRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name", "net_change", "changes", "min_amount", "max_amount"})
+}
+
+// TestMovementReport は期間内の変更履歴が商品ごとに集計されることをテストします
+func TestMovementReport(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(movementReportRegex).
+		WithArgs(from, to).
+		WillReturnRows(newMovementRows().
+			AddRow("banana", -80, 4, 20, 100).
+			AddRow("apple", 50, 2, 100, 150))
+
+	movements, err := MovementReport(context.Background(), db, from, to)
+
+	assert.NoError(t, err, "エラーが発生すべきでない")
+	assert.Equal(t, []Movement{
+		{Name: "banana", NetChange: -80, Changes: 4, MinAmount: 20, MaxAmount: 100},
+		{Name: "apple", NetChange: 50, Changes: 2, MinAmount: 100, MaxAmount: 150},
+	}, movements)
+	verifyExpectations(t, mock)
+}
+
+// TestMovementReport_InvalidRange は開始が終了より前でない場合にSQLを発行せずエラーになることをテストします
+func TestMovementReport_InvalidRange(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	at := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, to := range []time.Time{at, at.Add(-time.Hour)} {
+		_, err := MovementReport(context.Background(), db, at, to)
+		assert.ErrorIs(t, err, ErrInvalidTimeRange)
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestMovementReport_QueryError はクエリエラーが返ることをテストします
+func TestMovementReport_QueryError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(movementReportRegex).WillReturnError(errors.New("query failed"))
+
+	_, err := MovementReport(context.Background(), db, time.Unix(0, 0), time.Unix(60, 0))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "query failed")
+	verifyExpectations(t, mock)
+}
+
+// TestParseReportTime は日時が設定したタイムゾーンで解釈されることをテストします
+func TestParseReportTime(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	withTimeLocation(t, tokyo)
+
+	tests := []struct {
+		name     string
+		value    string
+		end      bool
+		expected time.Time
+	}{
+		{name: "開始日", value: "2025-03-01", expected: time.Date(2025, 3, 1, 0, 0, 0, 0, tokyo)},
+		{name: "終了日はその日を含む", value: "2025-03-31", end: true, expected: time.Date(2025, 4, 1, 0, 0, 0, 0, tokyo)},
+		{name: "日時指定", value: "2025-03-31 12:30:00", end: true, expected: time.Date(2025, 3, 31, 12, 30, 0, 0, tokyo)},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseReportTime(tc.value, tc.end)
+			assert.NoError(t, err)
+			assert.True(t, tc.expected.Equal(got), "期待: %v, 実際: %v", tc.expected, got)
+			assert.Equal(t, tokyo, got.Location(), "設定したタイムゾーンで解釈されるべき")
+		})
+	}
+
+	_, err := parseReportTime("03/01/2025", false)
+	assert.Error(t, err, "不正な形式はエラーになるべき")
+}
+
+// TestRunReportCommand はreportサブコマンドが期間を解釈して集計結果を出力することをテストします
+func TestRunReportCommand(t *testing.T) {
+	withTimeLocation(t, time.UTC)
+
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{
+			name:   "表形式",
+			format: formatTable,
+			expected: "name    net_change  changes  min_amount  max_amount\n" +
+				"banana  -80         4        20          100\n" +
+				"apple   50          2        100         150\n",
+		},
+		{
+			name:   "CSV形式",
+			format: formatCSV,
+			expected: "name,net_change,changes,min_amount,max_amount\n" +
+				"banana,-80,4,20,100\n" +
+				"apple,50,2,100,150\n",
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectQuery(movementReportRegex).
+				WithArgs(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)).
+				WillReturnRows(newMovementRows().
+					AddRow("banana", -80, 4, 20, 100).
+					AddRow("apple", 50, 2, 100, 150))
+
+			var buf bytes.Buffer
+			err := runReportCommand(context.Background(), &buf, db,
+				[]string{"--from", "2025-03-01", "--to", "2025-03-31", "--format", tc.format})
+
+			assert.NoError(t, err, "reportサブコマンドは成功するべき")
+			assert.Equal(t, tc.expected, buf.String())
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestRunReportCommand_InvalidArgs は引数の誤りでSQLを発行せずエラーになることをテストします
+func TestRunReportCommand_InvalidArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "期間の指定なし", args: []string{"--from", "2025-03-01"}},
+		{name: "不正な日付", args: []string{"--from", "2025-13-01", "--to", "2025-03-31"}},
+		{name: "開始が終了より後", args: []string{"--from", "2025-04-01", "--to", "2025-03-01"}},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			err := runReportCommand(context.Background(), &bytes.Buffer{}, db, tc.args)
+			assert.Error(t, err)
+			verifyExpectations(t, mock)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"time"
+)
+
+// StockRepository はmainProcessが利用する在庫データへの操作をまとめたインターフェースです。
+// 本番ではSQLStockRepository、テストでは任意の実装を渡すことができます。
+// いずれのメソッドもctxのキャンセルや期限に従います。
+type StockRepository interface {
+	Ping(ctx context.Context) error
+	QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error)
+	UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error
+	EnsureSchema(ctx context.Context) error
+}
+
+// SQLStockRepository はdatabase/sqlを使ってStockRepositoryを実装します。
+// StockRepositoryに含まれない集計や一括更新もcontext対応版として提供します。
+// メンテナンスモード中は在庫データを書き換えるメソッドがDBに問い合わせずにErrMaintenanceModeを返します。
+type SQLStockRepository struct {
+	db *sql.DB
+	// stmts はQueryStocks、QueryStocksTypedとGetStockの読み取りと、在庫データの書き込みが使うプリペアドステートメントのキャッシュです（nilの場合は使わない）。
+	stmts *StmtCache
+}
+
+// NewSQLStockRepository は指定したDBを使うSQLStockRepositoryを返します。
+func NewSQLStockRepository(db *sql.DB) *SQLStockRepository {
+	return &SQLStockRepository{db: db}
+}
+
+// NewCachedSQLStockRepository はQueryStocks、QueryStocksTypedとGetStockの読み取りと、UpsertStock、BulkUpsertStocks、
+// DeleteStockCheckedなどの書き込みをcacheのプリペアドステートメントで実行するSQLStockRepositoryを返します。
+// 複数の商品名の削除などSQL文が呼び出しごとに変わるものはキャッシュを使いません。
+func NewCachedSQLStockRepository(db *sql.DB, cache *StmtCache) *SQLStockRepository {
+	return &SQLStockRepository{db: db, stmts: cache}
+}
+
+// reader はSQL文が固定の読み取りに使うQueryerを返します。
+func (r *SQLStockRepository) reader() Queryer {
+	if r.stmts == nil {
+		return r.db
+	}
+	return r.stmts.Queryer(r.db)
+}
+
+// writer は書き込みがキャッシュしたステートメントを使うctxを返します。
+func (r *SQLStockRepository) writer(ctx context.Context) context.Context {
+	if r.stmts == nil {
+		return ctx
+	}
+	return withStmtCache(ctx, r.stmts, r.db)
+}
+
+// Ping はデータベース接続を確認します。
+func (r *SQLStockRepository) Ping(ctx context.Context) error {
+	return PingDBContext(ctx, r.db)
+}
+
+// QueryStocks は名前に一致する在庫データを取得します。
+func (r *SQLStockRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	return QueryStocksContext(ctx, r.reader(), name)
+}
+
+// QueryStocksTyped は名前に一致する在庫データをStockのスライスで取得します。
+func (r *SQLStockRepository) QueryStocksTyped(ctx context.Context, name string) ([]Stock, error) {
+	return QueryStocksTypedContext(ctx, r.reader(), name)
+}
+
+// GetStock は指定した商品の行を取得します。該当する行がない場合はsql.ErrNoRowsを返します。
+func (r *SQLStockRepository) GetStock(ctx context.Context, name string) (Stock, error) {
+	return GetStockContext(ctx, r.reader(), name)
+}
+
+// GetStocksByNames は指定した商品の行を1回のクエリで名前順に取得します。
+func (r *SQLStockRepository) GetStocksByNames(ctx context.Context, names []string) ([]Stock, error) {
+	return GetStocksByNamesContext(ctx, r.db, names)
+}
+
+// OldestStock はcreated_atが最も古い商品の行を取得します。
+func (r *SQLStockRepository) OldestStock(ctx context.Context) (Stock, error) {
+	return OldestStockContext(ctx, r.db)
+}
+
+// NewestStock はcreated_atが最も新しい商品の行を取得します。
+func (r *SQLStockRepository) NewestStock(ctx context.Context) (Stock, error) {
+	return NewestStockContext(ctx, r.db)
+}
+
+// QueryStocksByCategory は指定したカテゴリの在庫データを名前順で取得します。
+func (r *SQLStockRepository) QueryStocksByCategory(ctx context.Context, category string) ([]Stock, error) {
+	return QueryStocksByCategoryContext(ctx, r.db, category)
+}
+
+// UpsertStock は在庫データを更新または挿入します。
+func (r *SQLStockRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	return UpsertStockContext(r.writer(ctx), r.db, name, amount, opts...)
+}
+
+// UpsertStockWithCategory は在庫データを更新または挿入し、カテゴリを設定します。
+func (r *SQLStockRepository) UpsertStockWithCategory(ctx context.Context, name string, amount int, category string, opts ...UpsertOption) error {
+	return UpsertStockWithCategoryContext(r.writer(ctx), r.db, name, amount, category, opts...)
+}
+
+// BulkUpsertStocks は複数の在庫変更を1つのトランザクションで適用します。
+func (r *SQLStockRepository) BulkUpsertStocks(ctx context.Context, items []StockUpdate, opts ...UpsertOption) (BulkResult, error) {
+	return BulkUpsertStocksContext(r.writer(ctx), r.db, items, opts...)
+}
+
+// TotalStockAmount は在庫数の合計を返します。
+func (r *SQLStockRepository) TotalStockAmount(ctx context.Context) (int, error) {
+	return TotalStockAmountContext(ctx, r.db)
+}
+
+// DeleteStocksByNames は指定した商品名の在庫をバッチごとに削除します。
+func (r *SQLStockRepository) DeleteStocksByNames(ctx context.Context, names []string, batchSize int) (DeleteReport, error) {
+	return DeleteStocksByNames(r.writer(ctx), r.db, names, batchSize)
+}
+
+// DeleteStockChecked は削除と同じトランザクションでrefCheckによる参照の確認を行い、確認が通った場合だけ商品を削除します。
+func (r *SQLStockRepository) DeleteStockChecked(ctx context.Context, name string, refCheck func(tx *sql.Tx, name string) error) error {
+	return DeleteStockCheckedContext(r.writer(ctx), r.db, name, refCheck)
+}
+
+// CachedTotal はstock_totalsにキャッシュされた在庫数の合計を返します。
+func (r *SQLStockRepository) CachedTotal(ctx context.Context) (int64, error) {
+	return GetCachedTotalContext(ctx, r.db)
+}
+
+// EstimateRowCount は在庫データの行数の推定値を返します。
+func (r *SQLStockRepository) EstimateRowCount(ctx context.Context) (int64, error) {
+	return EstimateRowCountContext(ctx, r.db)
+}
+
+// MedianStockAmount は在庫数の中央値を返します。
+func (r *SQLStockRepository) MedianStockAmount(ctx context.Context) (float64, error) {
+	return MedianStockAmountContext(ctx, r.db)
+}
+
+// StockTurnover はsince以降の指定商品の出庫量を返します。
+func (r *SQLStockRepository) StockTurnover(ctx context.Context, name string, since time.Time) (int, error) {
+	return StockTurnoverContext(ctx, r.db, name, since)
+}
+
+// CompactLog はbeforeより前の変更履歴を商品ごとに1行にまとめ、削除した行数を返します。
+func (r *SQLStockRepository) CompactLog(ctx context.Context, before time.Time) (int64, error) {
+	return CompactLogContext(ctx, r.db, before)
+}
+
+// ReconcileCSV はCSVの期待する在庫数とstocksテーブルを突き合わせ、差異を返します。
+func (r *SQLStockRepository) ReconcileCSV(ctx context.Context, csv io.Reader) ([]Discrepancy, error) {
+	return ReconcileCSVContext(ctx, r.db, csv)
+}
+
+// ClaimLowStock は在庫数の少ない順に最大n件の商品を行ロックして確保し、開いたままのトランザクションと一緒に返します。
+func (r *SQLStockRepository) ClaimLowStock(ctx context.Context, n int) (*sql.Tx, []Stock, error) {
+	return ClaimLowStock(ctx, r.db, n)
+}
+
+// WithConn はプールから1つの接続を確保してfnを実行します。fnの中のクエリはすべて同じ接続で実行されます。
+func (r *SQLStockRepository) WithConn(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	return WithConn(ctx, r.db, fn)
+}
+
+// StreamStocksWithAck は在庫データを1行ずつsendに渡します。sendがエラーを返すと読み取りを止めます。
+func (r *SQLStockRepository) StreamStocksWithAck(ctx context.Context, name string, send func(Stock) error) error {
+	return StreamStocksWithAck(ctx, r.db, name, send)
+}
+
+// StockTimeSeries はsince以降の指定商品の在庫数をbucketごとの時系列で返します。
+func (r *SQLStockRepository) StockTimeSeries(ctx context.Context, name string, since time.Time, bucket time.Duration) ([]TimePoint, error) {
+	return StockTimeSeriesContext(ctx, r.db, name, since, bucket)
+}
+
+// ProductHistory は指定商品の変更履歴と現在の在庫数を返します。
+func (r *SQLStockRepository) ProductHistory(ctx context.Context, name string) (ProductHistory, error) {
+	return LoadProductHistory(ctx, r.db, name)
+}
+
+// MovementReport はfrom以上to未満の在庫の動きを商品ごとに集計します。
+func (r *SQLStockRepository) MovementReport(ctx context.Context, from, to time.Time) ([]Movement, error) {
+	return MovementReport(ctx, r.db, from, to)
+}
+
+// EnsureSchema はstocksテーブルが存在しない場合に作成します。
+func (r *SQLStockRepository) EnsureSchema(ctx context.Context) error {
+	return EnsureSchemaContext(ctx, r.db)
+}
+
+// Close は内部のDB接続を閉じます。
+func (r *SQLStockRepository) Close() error {
+	return r.db.Close()
+}
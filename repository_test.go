@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// contextExemptMethods はcontextを受け取らなくてよいSQLStockRepositoryのメソッドと、その理由です。
+var contextExemptMethods = map[string]string{
+	"Close": "接続を閉じるだけでSQLを発行しない",
+}
+
+// sampleArg はメソッド呼び出しに使う、検証を通過する引数の値を作ります。
+// 空文字列のような値だとDBに到達する前に検証エラーになり、contextの扱いを確認できないためです。
+// 日時は期間の検証を通過するよう、後ろの引数ほど後の時刻にします。
+func sampleArg(t reflect.Type, position int) reflect.Value {
+	if t == reflect.TypeOf(time.Time{}) {
+		base := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+		return reflect.ValueOf(base.Add(time.Duration(position) * time.Hour))
+	}
+	if t == reflect.TypeOf((*io.Reader)(nil)).Elem() {
+		return reflect.ValueOf(strings.NewReader("apple,1\n"))
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return reflect.ValueOf("apple").Convert(t)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.ValueOf(1).Convert(t)
+	case reflect.Slice:
+		return reflect.Append(reflect.MakeSlice(t, 0, 1), sampleArg(t.Elem(), 0))
+	case reflect.Struct:
+		v := reflect.New(t).Elem()
+		for i := 0; i < t.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				v.Field(i).Set(sampleArg(t.Field(i).Type, i))
+			}
+		}
+		return v
+	default:
+		return reflect.Zero(t)
+	}
+}
+
+// TestSQLStockRepository_CancelledContext はリポジトリの全メソッドがcontextを受け取り、
+// キャンセル済みのcontextではSQLを1件も発行せずにすぐcontextのエラーを返すことを確認します。
+// db.QueryContextではなくdb.Queryを呼ぶようなメソッドが追加されると、このテストが失敗します。
+func TestSQLStockRepository_CancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	repoType := reflect.TypeOf(&SQLStockRepository{})
+	ctxType := reflect.TypeOf((*context.Context)(nil)).Elem()
+	errType := reflect.TypeOf((*error)(nil)).Elem()
+
+	for i := 0; i < repoType.NumMethod(); i++ {
+		method := repoType.Method(i)
+		if _, ok := contextExemptMethods[method.Name]; ok {
+			continue
+		}
+		t.Run(method.Name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			// method.Typeの最初の引数はレシーバ
+			mt := method.Type
+			if mt.NumIn() < 2 || mt.In(1) != ctxType {
+				t.Fatalf("%sは最初の引数にcontext.Contextを受け取るべき", method.Name)
+			}
+			if mt.NumOut() == 0 || mt.Out(mt.NumOut()-1) != errType {
+				t.Fatalf("%sは最後の戻り値にerrorを返すべき", method.Name)
+			}
+
+			args := []reflect.Value{reflect.ValueOf(NewSQLStockRepository(db)), reflect.ValueOf(ctx)}
+			for j := 2; j < mt.NumIn(); j++ {
+				// 可変長引数のオプションは指定しない
+				if mt.IsVariadic() && j == mt.NumIn()-1 {
+					break
+				}
+				args = append(args, sampleArg(mt.In(j), j))
+			}
+
+			done := make(chan []reflect.Value, 1)
+			go func() { done <- method.Func.Call(args) }()
+
+			select {
+			case out := <-done:
+				err, _ := out[len(out)-1].Interface().(error)
+				assert.ErrorIs(t, err, context.Canceled, "contextのエラーを返すべき")
+			case <-time.After(time.Second):
+				t.Fatalf("%sはキャンセル済みのcontextですぐに戻るべき", method.Name)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet(), "SQLは発行されないべき")
+		})
+	}
+}
+
+// TestContextExemptMethodsExist は除外リストに存在しないメソッドが残っていないことを確認します。
+func TestContextExemptMethodsExist(t *testing.T) {
+	repoType := reflect.TypeOf(&SQLStockRepository{})
+	for name := range contextExemptMethods {
+		_, ok := repoType.MethodByName(name)
+		assert.True(t, ok, "除外リストの%sはSQLStockRepositoryのメソッドであるべき", name)
+	}
+}
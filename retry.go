@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 再試行の設定の対象。DBConfig.Retryの各項目と、RetryMetricsのキーに対応します
+const (
+	// retryDeadlock はデッドロックやロック待ちのタイムアウトで失敗したトランザクションの再実行です（WithTransaction）。
+	retryDeadlock = "deadlock"
+	// retryConnect は接続確認の再試行です（メイン処理の接続確認）。
+	retryConnect = "connect"
+	// retryWebhook は通知の送信の再試行です。
+	retryWebhook = "webhook"
+)
+
+// RetryPolicy は再試行の回数、待ち時間と、再試行するエラーの分類です。ゼロ値は再試行しないことを表します。
+//
+// n回目の再試行の前にはInitialBackoff×Multiplier^(n-1)（MaxBackoffが上限）待ちます。
+// Jitterが0より大きい場合は待ち時間を±Jitterの割合でばらつかせ、同時に失敗した処理の再試行が重ならないようにします。
+type RetryPolicy struct {
+	// Name は再試行の対象の名前（retryDeadlockなど）で、RetryMetricsに回数を記録するキーです。RetryConfigから取り出すと設定されます。
+	Name string
+	// MaxAttempts は最初の試行を含めた試行回数の上限です。1以下の場合は再試行しません。
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	// MaxBackoff は待ち時間の上限です（ばらつかせる前の値に適用します）。0の場合は上限なしです。
+	MaxBackoff time.Duration
+	// Multiplier は再試行のたびに待ち時間を増やす倍率です。1未満の場合は1として扱います。
+	Multiplier float64
+	// Jitter は待ち時間をばらつかせる割合（0〜1）です。
+	Jitter float64
+	// Retryable は再試行するエラーの分類です。ErrorReportで再試行できると判定され、かつ分類が含まれるエラーだけを再試行します。
+	Retryable []ErrorClass
+}
+
+// RetryConfig は再試行する処理ごとのRetryPolicyです。ある処理の設定を変えても他の処理には影響しません。
+type RetryConfig struct {
+	Deadlock RetryPolicy
+	Connect  RetryPolicy
+	Webhook  RetryPolicy
+}
+
+// policy は対象の名前（retryDeadlockなど）に対応するRetryPolicyを、Nameを設定して返します。
+func (c RetryConfig) policy(concern string) RetryPolicy {
+	var p RetryPolicy
+	switch concern {
+	case retryDeadlock:
+		p = c.Deadlock
+	case retryConnect:
+		p = c.Connect
+	case retryWebhook:
+		p = c.Webhook
+	}
+	p.Name = concern
+	return p
+}
+
+// backoff はn回目（1から数える）の再試行の前に待つ時間を返します。
+func (p RetryPolicy) backoff(n int) time.Duration {
+	multiplier := max(p.Multiplier, 1)
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(n-1))
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*retryRand()-1)
+	}
+	return time.Duration(d)
+}
+
+// retries はerrを再試行するかどうかを返します。
+func (p RetryPolicy) retries(err error) bool {
+	report := ErrorReport(err)
+	return report.Retryable && slices.Contains(p.Retryable, report.Class)
+}
+
+// RetryCounts は1つの対象の再試行の回数です。
+type RetryCounts struct {
+	// Calls はDoを呼び出した回数です。
+	Calls int64
+	// Attempts はfnを実行した回数（最初の試行を含む）です。
+	Attempts int64
+	// Exhausted は試行回数の上限まで再試行しても失敗した回数です。
+	Exhausted int64
+}
+
+var (
+	retryMetricsMu sync.Mutex
+	retryMetrics   = map[string]*RetryCounts{}
+)
+
+// RetryMetrics は対象ごとの再試行の回数の写しを返します。
+func RetryMetrics() map[string]RetryCounts {
+	retryMetricsMu.Lock()
+	defer retryMetricsMu.Unlock()
+	snapshot := make(map[string]RetryCounts, len(retryMetrics))
+	for concern, counts := range retryMetrics {
+		snapshot[concern] = *counts
+	}
+	return snapshot
+}
+
+// recordRetry はconcernの再試行の回数を記録します。
+func recordRetry(concern string, attempts int, exhausted bool) {
+	retryMetricsMu.Lock()
+	defer retryMetricsMu.Unlock()
+	counts, ok := retryMetrics[concern]
+	if !ok {
+		counts = &RetryCounts{}
+		retryMetrics[concern] = counts
+	}
+	counts.Calls++
+	counts.Attempts += int64(attempts)
+	if exhausted {
+		counts.Exhausted++
+	}
+}
+
+// retrySleep はctxがキャンセルされるまでの間、d待ちます。テストでは待たずに待ち時間を記録する実装に差し替えます。
+var retrySleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryRand は待ち時間をばらつかせるための[0, 1)の乱数を返します。
+var retryRand = rand.Float64
+
+// Do はfnを実行し、policyで再試行する分類のエラーで失敗した場合は待ち時間をおいて再実行します。
+// 試行回数の上限に達した場合や、再試行しないエラーの場合は最後のエラーを返します。待っている間にctxが終了した場合も最後のエラーを返します。
+// 試行回数はpolicy.Nameごとに記録し、RetryMetricsで取得できます。
+func Do(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	attempts := 0
+	for {
+		attempts++
+		err := fn(ctx)
+		if err == nil || attempts >= policy.MaxAttempts || !policy.retries(err) {
+			recordRetry(policy.Name, attempts, err != nil && attempts >= policy.MaxAttempts && policy.MaxAttempts > 1)
+			return err
+		}
+		if sleepErr := retrySleep(ctx, policy.backoff(attempts)); sleepErr != nil {
+			recordRetry(policy.Name, attempts, false)
+			return err
+		}
+	}
+}
+
+// retryPolicy はcurrentDBConfigからconcernのRetryPolicyを返します。
+func retryPolicy(concern string) RetryPolicy {
+	return currentDBConfig().Retry.policy(concern)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withRetrySleep はテスト中だけ再試行の待ちを行わずに待ち時間を記録します
+func withRetrySleep(t *testing.T) *[]time.Duration {
+	original := retrySleep
+	var waits []time.Duration
+	retrySleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { retrySleep = original })
+	return &waits
+}
+
+// withRetryRand はテスト中だけ待ち時間をばらつかせる乱数をvalueに固定します
+func withRetryRand(t *testing.T, value float64) {
+	original := retryRand
+	retryRand = func() float64 { return value }
+	t.Cleanup(func() { retryRand = original })
+}
+
+// withRetryPolicies はテスト中だけ処理ごとの再試行の設定を差し替えます
+func withRetryPolicies(t *testing.T, config RetryConfig) {
+	original := retryPolicies
+	retryPolicies = config
+	t.Cleanup(func() { retryPolicies = original })
+}
+
+// failingN は最初のn回はerrを返し、その後は成功する関数と、呼び出し回数を返します
+func failingN(n int, err error) (func(ctx context.Context) error, *int) {
+	calls := 0
+	return func(ctx context.Context) error {
+		calls++
+		if calls <= n {
+			return err
+		}
+		return nil
+	}, &calls
+}
+
+// TestRetryPolicy_Backoff は待ち時間が倍率で増え、上限で頭打ちになることをテストします
+func TestRetryPolicy_Backoff(t *testing.T) {
+	waits := withRetrySleep(t)
+	policy := RetryPolicy{Name: "test", MaxAttempts: 7, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second,
+		Multiplier: 2, Retryable: []ErrorClass{ErrorClassConnection}}
+	fn, calls := failingN(6, driver.ErrBadConn)
+
+	err := Do(context.Background(), policy, fn)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 7, *calls)
+	ms := time.Millisecond
+	assert.Equal(t, []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, time.Second, time.Second}, *waits)
+}
+
+// TestRetryPolicy_Jitter は待ち時間のばらつきが±Jitterの範囲に収まることをテストします
+func TestRetryPolicy_Jitter(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, Multiplier: 2, Jitter: 0.2}
+
+	withRetryRand(t, 0)
+	assert.Equal(t, 800*time.Millisecond, policy.backoff(1), "下限は1-Jitter倍")
+	withRetryRand(t, 0.5)
+	assert.Equal(t, 2*time.Second, policy.backoff(2), "乱数が中央ならばらつかない")
+	withRetryRand(t, 0.9999999)
+	assert.InDelta(t, float64(4800*time.Millisecond), float64(policy.backoff(3)), float64(time.Millisecond), "上限は1+Jitter倍")
+	withRetryRand(t, 0.25)
+	assert.Equal(t, 900*time.Millisecond, policy.backoff(1))
+}
+
+// TestDo_Classification はpolicyの分類に含まれ、再試行できると判定されたエラーだけを再試行することをテストします
+func TestDo_Classification(t *testing.T) {
+	withRetrySleep(t)
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Retryable: []ErrorClass{ErrorClassConnection}}
+
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{name: "接続エラーは再試行する", err: driver.ErrBadConn, calls: 3},
+		{name: "分類に含まれないデッドロック", err: newDriverError(mysqlErrDeadlock, "Deadlock found"), calls: 1},
+		{name: "再試行できない接続エラー", err: newDriverError(mysqlErrAccessDenied, "Access denied"), calls: 1},
+		{name: "検証エラー", err: ErrInvalidName, calls: 1},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			fn, calls := failingN(10, tc.err)
+			err := Do(context.Background(), policy, fn)
+			assert.ErrorIs(t, err, tc.err, "最後のエラーを返すべき")
+			assert.Equal(t, tc.calls, *calls)
+		})
+	}
+}
+
+// TestDo_ZeroPolicy はゼロ値のRetryPolicyでは再試行しないことをテストします
+func TestDo_ZeroPolicy(t *testing.T) {
+	waits := withRetrySleep(t)
+	fn, calls := failingN(1, driver.ErrBadConn)
+
+	err := Do(context.Background(), RetryPolicy{}, fn)
+
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+	assert.Equal(t, 1, *calls)
+	assert.Empty(t, *waits)
+}
+
+// TestDo_Cancelled は待っている間にctxが終了した場合、再試行せずに最後のエラーを返すことをテストします
+func TestDo_Cancelled(t *testing.T) {
+	withRetrySleep(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fn, calls := failingN(10, driver.ErrBadConn)
+
+	err := Do(ctx, RetryPolicy{MaxAttempts: 5, Retryable: []ErrorClass{ErrorClassConnection}}, fn)
+
+	assert.ErrorIs(t, err, driver.ErrBadConn)
+	assert.Equal(t, 1, *calls)
+}
+
+// TestDo_Metrics は対象ごとに呼び出し回数、試行回数と再試行しても失敗した回数を記録することをテストします
+func TestDo_Metrics(t *testing.T) {
+	withRetrySleep(t)
+	policy := RetryPolicy{Name: "metrics-test", MaxAttempts: 3, Retryable: []ErrorClass{ErrorClassConnection}}
+	before := RetryMetrics()["metrics-test"]
+
+	fn, _ := failingN(1, driver.ErrBadConn)
+	require.NoError(t, Do(context.Background(), policy, fn))
+	fn, _ = failingN(10, driver.ErrBadConn)
+	require.Error(t, Do(context.Background(), policy, fn))
+
+	after := RetryMetrics()["metrics-test"]
+	assert.Equal(t, int64(2), after.Calls-before.Calls)
+	assert.Equal(t, int64(5), after.Attempts-before.Attempts)
+	assert.Equal(t, int64(1), after.Exhausted-before.Exhausted)
+}
+
+// flakyPingRepository は最初のfailures回の接続確認が失敗するStockRepositoryです
+type flakyPingRepository struct {
+	*fakeStockRepository
+	failures int
+	pings    int
+}
+
+func (r *flakyPingRepository) Ping(ctx context.Context) error {
+	r.pings++
+	if r.pings <= r.failures {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// expectDeadlockedTransaction はデッドロックで失敗してロールバックされるトランザクションを期待値として設定します
+func expectDeadlockedTransaction(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE stocks SET amount = 0;`).WillReturnError(newDriverError(mysqlErrDeadlock, "Deadlock found"))
+	mock.ExpectRollback()
+}
+
+// TestRetryConsumers は再試行する処理がそれぞれ自分の設定だけを読み、他の処理の設定に影響されないことをテストします
+func TestRetryConsumers(t *testing.T) {
+	withRetrySleep(t)
+	retryOnce := func(classes ...ErrorClass) RetryPolicy {
+		return RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, Retryable: classes}
+	}
+	resetStocks := func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE stocks SET amount = 0;")
+		return err
+	}
+	ping := func() int {
+		repo := &flakyPingRepository{fakeStockRepository: &fakeStockRepository{stocks: map[string]int{}}, failures: 1}
+		processStock(context.Background(), &bytes.Buffer{}, repo, "apple", 1, processOptions{})
+		return repo.pings
+	}
+
+	t.Run("deadlockはWithTransactionだけが読む", func(t *testing.T) {
+		withRetryPolicies(t, RetryConfig{Deadlock: retryOnce(ErrorClassConflict, ErrorClassConnection)})
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		expectDeadlockedTransaction(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE stocks SET amount = 0;`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		assert.NoError(t, WithTransaction(context.Background(), db, resetStocks), "デッドロックしたトランザクションはやり直すべき")
+		verifyExpectations(t, mock)
+		assert.Equal(t, 1, ping(), "接続確認はdeadlockの設定で再試行しないべき")
+	})
+
+	t.Run("connectは接続確認だけが読む", func(t *testing.T) {
+		withRetryPolicies(t, RetryConfig{Connect: retryOnce(ErrorClassConflict, ErrorClassConnection)})
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		expectDeadlockedTransaction(mock)
+
+		err := WithTransaction(context.Background(), db, resetStocks)
+		assert.Equal(t, ErrorClassConflict, ErrorReport(err).Class, "トランザクションはconnectの設定でやり直さないべき")
+		verifyExpectations(t, mock)
+		assert.Equal(t, 2, ping(), "接続確認はconnectの設定で再試行するべき")
+	})
+
+	t.Run("webhookの設定は他の処理に影響しない", func(t *testing.T) {
+		withRetryPolicies(t, RetryConfig{Webhook: retryOnce(ErrorClassConflict, ErrorClassConnection)})
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+		expectDeadlockedTransaction(mock)
+
+		assert.Error(t, WithTransaction(context.Background(), db, resetStocks))
+		verifyExpectations(t, mock)
+		assert.Equal(t, 1, ping())
+		assert.Equal(t, retryWebhook, retryPolicy(retryWebhook).Name)
+		assert.Equal(t, 2, retryPolicy(retryWebhook).MaxAttempts)
+	})
+}
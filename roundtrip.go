@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// roundTripCounterKey はRoundTripCounterをcontextに保持するためのキーです。
+type roundTripCounterKey struct{}
+
+// RoundTripCounter は1つの論理的な操作がDBに発行したSQL文の数（往復の回数）を操作名ごとに数えます。
+// 数えるのはtaggedSQLを通して発行するstocksテーブルのSQL文で、BEGINやCOMMIT、変更履歴などの付随する書き込みは含みません。
+type RoundTripCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// WithRoundTripCounter はctxに新しいRoundTripCounterを設定して返します。返したctxで実行した操作の往復の回数を数えます。
+func WithRoundTripCounter(ctx context.Context) (context.Context, *RoundTripCounter) {
+	c := &RoundTripCounter{counts: make(map[string]int)}
+	return context.WithValue(ctx, roundTripCounterKey{}, c), c
+}
+
+// Total は数えた往復の回数の合計を返します。
+func (c *RoundTripCounter) Total() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0
+	for _, n := range c.counts {
+		total += n
+	}
+	return total
+}
+
+// ByOperation は操作名ごとの往復の回数の写しを返します。
+func (c *RoundTripCounter) ByOperation() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(map[string]int, len(c.counts))
+	for op, n := range c.counts {
+		snapshot[op] = n
+	}
+	return snapshot
+}
+
+// countRoundTrip はctxにRoundTripCounterが設定されていれば、opの往復を1回数えます。
+func countRoundTrip(ctx context.Context, op string) {
+	c, ok := ctx.Value(roundTripCounterKey{}).(*RoundTripCounter)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[op]++
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"db_moc/internal/stmt"
+)
+
+// TestRoundTripCounter_Upsert はUpsertStockが2往復、UpsertStockAtomicが1往復として数えられることをテストします
+func TestRoundTripCounter_Upsert(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(10))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 15).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectStmtExec(mock, stmtUpsertAmount, stmt.Values{"name": "apple", "amount": 5}).WillReturnResult(sqlmock.NewResult(0, 2))
+
+	ctx, counter := WithRoundTripCounter(context.Background())
+	require.NoError(t, UpsertStockContext(ctx, db, "apple", 5))
+	require.NoError(t, UpsertStockAtomicContext(ctx, db, "apple", 5))
+
+	assert.Equal(t, map[string]int{"UpsertStock": 2, "UpsertStockAtomic": 1}, counter.ByOperation())
+	assert.Equal(t, 3, counter.Total())
+	verifyExpectations(t, mock)
+}
+
+// TestRoundTripCounter_NotSet はctxにカウンタが設定されていなければ何も数えないことをテストします
+func TestRoundTripCounter_NotSet(t *testing.T) {
+	_, counter := WithRoundTripCounter(context.Background())
+	countRoundTrip(context.Background(), "UpsertStock")
+	assert.Zero(t, counter.Total())
+}
+
+// TestUpsertStockAtomic_Unsupported は変更前の在庫数を必要とする機能が有効な場合にSQL文を発行しないことをテストします
+func TestUpsertStockAtomic_Unsupported(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	withAuditLog(t)
+
+	err := UpsertStockAtomic(db, "apple", 5)
+
+	assert.ErrorIs(t, err, ErrAtomicUpsertUnsupported)
+	assert.Contains(t, err.Error(), "auditLogEnabled")
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStockAtomic_MaxDelta は変更量の上限を在庫数を読み取らずに確認することをテストします
+func TestUpsertStockAtomic_MaxDelta(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	withChangeLimits(t, 100, 0)
+
+	var suspicious *SuspiciousChangeError
+	assert.ErrorAs(t, UpsertStockAtomic(db, "apple", 500), &suspicious)
+	verifyExpectations(t, mock)
+}
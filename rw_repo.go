@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ReadWriteRepo は書き込み用のプライマリと、読み取り用のレプリカの接続をまとめたものです。
+// レプリカはnilでもよく、その場合は読み取りもプライマリで行います。
+type ReadWriteRepo struct {
+	primary *sql.DB
+	replica *sql.DB
+}
+
+// NewReadWriteRepo はプライマリとレプリカ（nil可）を使うReadWriteRepoを返します。
+func NewReadWriteRepo(primary, replica *sql.DB) *ReadWriteRepo {
+	return &ReadWriteRepo{primary: primary, replica: replica}
+}
+
+// Writer は書き込みに使う接続を返します。
+func (r *ReadWriteRepo) Writer() *sql.DB {
+	return r.primary
+}
+
+// Reader は読み取りに使う接続を返します。レプリカがなければプライマリを返します。
+func (r *ReadWriteRepo) Reader() *sql.DB {
+	if r.replica != nil {
+		return r.replica
+	}
+	return r.primary
+}
+
+// PingAll はプライマリとレプリカ（設定されている場合）の両方に接続を確認します。
+// 片方が失敗してももう片方の確認を行い、失敗した接続名を含めたエラーをまとめて返します。
+func PingAll(ctx context.Context, repo *ReadWriteRepo) error {
+	var errs []error
+	if err := repo.primary.PingContext(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("primaryへの接続確認に失敗: %w", err))
+	}
+	if repo.replica != nil {
+		if err := repo.replica.PingContext(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("replicaへの接続確認に失敗: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
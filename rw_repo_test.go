@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPingMock はPingを監視するモックDBを作成します
+func newPingMock(t *testing.T, pingErr error) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("モックDBの作成に失敗: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mock.ExpectPing().WillReturnError(pingErr)
+	return db, mock
+}
+
+func TestPingAll(t *testing.T) {
+	tests := []struct {
+		name          string
+		primaryErr    error
+		replicaErr    error
+		expectedInErr []string
+		notInErr      []string
+	}{
+		{name: "両方正常"},
+		{
+			name:          "レプリカ停止",
+			replicaErr:    errors.New("replica down"),
+			expectedInErr: []string{"replica", "replica down"},
+			notInErr:      []string{"primary"},
+		},
+		{
+			name:          "プライマリ停止",
+			primaryErr:    errors.New("primary down"),
+			expectedInErr: []string{"primary", "primary down"},
+			notInErr:      []string{"replica"},
+		},
+		{
+			name:          "両方停止",
+			primaryErr:    errors.New("primary down"),
+			replicaErr:    errors.New("replica down"),
+			expectedInErr: []string{"primary down", "replica down"},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			primary, primaryMock := newPingMock(t, tc.primaryErr)
+			replica, replicaMock := newPingMock(t, tc.replicaErr)
+
+			err := PingAll(context.Background(), NewReadWriteRepo(primary, replica))
+
+			if len(tc.expectedInErr) == 0 {
+				assert.NoError(t, err, "エラーが発生すべきでない")
+			} else {
+				assert.Error(t, err, "エラーが発生すべき")
+				for _, s := range tc.expectedInErr {
+					assert.Contains(t, err.Error(), s)
+				}
+				for _, s := range tc.notInErr {
+					assert.NotContains(t, err.Error(), s)
+				}
+			}
+			if tc.primaryErr != nil {
+				assert.ErrorIs(t, err, tc.primaryErr)
+			}
+			if tc.replicaErr != nil {
+				assert.ErrorIs(t, err, tc.replicaErr)
+			}
+			// 片方が失敗してももう片方の確認は行われる
+			assert.NoError(t, primaryMock.ExpectationsWereMet())
+			assert.NoError(t, replicaMock.ExpectationsWereMet())
+		})
+	}
+}
+
+// TestPingAll_NoReplica はレプリカがない場合にプライマリのみ確認することをテストします
+func TestPingAll_NoReplica(t *testing.T) {
+	primary, mock := newPingMock(t, nil)
+	repo := NewReadWriteRepo(primary, nil)
+
+	assert.NoError(t, PingAll(context.Background(), repo))
+	assert.Same(t, primary, repo.Reader(), "レプリカがなければプライマリから読み取るべき")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// defaultCategory はカテゴリ未指定の商品に設定されるカテゴリです。
+const defaultCategory = "uncategorized"
+
+// stocksTableDDL はstocksテーブルを作成するDDLです。
+// 後から追加した列はstockMigrationsで追加するため、作成済みのテーブルと新しく作成したテーブルで列が揃います。
+const stocksTableDDL = `
+CREATE TABLE IF NOT EXISTS stocks (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    amount INT NOT NULL,
+    UNIQUE(name)
+);`
+
+// categoryMigration はstocksにカテゴリのcategory列と、カテゴリでの検索用の索引を追加するマイグレーションです。
+// 追加前からある行のカテゴリはdefaultCategoryになります。
+const categoryMigration = `
+ALTER TABLE stocks
+    ADD COLUMN category VARCHAR(64) NOT NULL DEFAULT '` + defaultCategory + `',
+    ADD INDEX idx_stocks_category (category);`
+
+// importRunsTableDDL は一括取り込みの進捗を記録するimport_runsテーブルを作成するDDLです。
+const importRunsTableDDL = `
+CREATE TABLE IF NOT EXISTS import_runs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    filename VARCHAR(1024) NOT NULL,
+    checksum CHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    next_index INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_import_runs_filename (filename(255), status)
+);`
+
+// schemaStatements はEnsureSchemaが順に実行するDDLです。
+var schemaStatements = []string{
+	stocksTableDDL,
+	importRunsTableDDL,
+	stockLogTableDDL,
+	stockTotalsTableDDL,
+	stockGenerationTableDDL,
+	stockQuotaTableDDL,
+	canaryTableDDL,
+}
+
+// EnsureSchema はアプリケーションが使うテーブルが存在しない場合に作成し、stockMigrationsを最新のバージョンまで適用します。
+// 既に存在するテーブルは作り直さず、未適用のマイグレーションだけを適用します。
+func EnsureSchema(db *sql.DB) error {
+	return EnsureSchemaContext(context.Background(), db)
+}
+
+// EnsureSchemaContext はEnsureSchemaのcontext対応版です。
+// DDLはマイグレーションのロックを保持して実行するため、複数のインスタンスが同時に起動しても競合しません。
+func EnsureSchemaContext(ctx context.Context, db *sql.DB) error {
+	return withMigrationLock(ctx, db, func() error {
+		for _, ddl := range schemaStatements {
+			if _, err := db.ExecContext(ctx, ddl); err != nil {
+				return fmt.Errorf("テーブル作成エラー: %w", err)
+			}
+		}
+		// 同じロックを保持したまま適用するため、作成とマイグレーションの間に他のインスタンスが割り込まない
+		return migrateTo(ctx, db, stockMigrationsTable, len(stockMigrations), stockMigrations)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsureSchema(t *testing.T) {
+	t.Run("テーブル作成成功", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		expectEnsureSchema(mock)
+
+		assert.NoError(t, EnsureSchema(db), "テーブル作成は成功するべき")
+		verifyExpectations(t, mock)
+	})
+
+	t.Run("テーブル作成エラー", func(t *testing.T) {
+		db, mock, _ := setupMockDB(t)
+		defer db.Close()
+
+		expectMigrationLock(mock)
+		mock.ExpectExec(regexp.QuoteMeta(stocksTableDDL)).
+			WillReturnError(errors.New("ddl error"))
+		expectMigrationUnlock(mock)
+
+		err := EnsureSchema(db)
+		if assert.Error(t, err, "エラーが返るべき") {
+			assert.Contains(t, err.Error(), "テーブル作成エラー", "テーブル作成エラーであることが分かるべき")
+		}
+		verifyExpectations(t, mock)
+	})
+}
+
+// TestEnsureSchema_Migrations はテーブルの作成に続けて、同じロックを保持したままstockMigrationsを順に適用することをテストします
+func TestEnsureSchema_Migrations(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectMigrationLock(mock)
+	for _, ddl := range schemaStatements {
+		mock.ExpectExec(regexp.QuoteMeta(ddl)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS stock_schema_migrations`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(stockVersionRegex).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	for v := 1; v <= len(stockMigrations); v++ {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(stockMigrations[v])).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO stock_schema_migrations \(version\) VALUES \(\?\);`).
+			WithArgs(v).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	expectMigrationUnlock(mock)
+
+	assert.NoError(t, EnsureSchema(db))
+	verifyExpectations(t, mock)
+}
+
+// TestStocksTableDDL はカテゴリ列が既定値と索引付きでマイグレーションから追加されることをテストします
+func TestStocksTableDDL(t *testing.T) {
+	assert.NotContains(t, stocksTableDDL, "category", "カテゴリ列は作成済みのテーブルにも追加できるようマイグレーションで追加するべき")
+	assert.Contains(t, stocksTableDDL, "UNIQUE(name)", "名前の一意制約は維持されるべき")
+	assert.Equal(t, categoryMigration, stockMigrations[3], "カテゴリ列のマイグレーションが登録されるべき")
+	assert.Contains(t, categoryMigration, "ADD COLUMN category VARCHAR(64) NOT NULL DEFAULT 'uncategorized'", "カテゴリ列が既定値付きで追加されるべき")
+	assert.Contains(t, categoryMigration, "ADD INDEX idx_stocks_category (category)", "カテゴリでの検索用インデックスが追加されるべき")
+	assert.Equal(t, stockCreatedAtMigration, stockMigrations[4], "作成日時の列のマイグレーションが登録されるべき")
+	assert.Contains(t, stockCreatedAtMigration, "ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP", "登録時に作成日時が入るべき")
+}
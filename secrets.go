@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ErrUnknownSecretProvider は秘密情報の参照に登録されていないプロバイダが指定された場合に返されます。
+var ErrUnknownSecretProvider = errors.New("秘密情報のプロバイダが登録されていません")
+
+// secretReferencePattern は"provider://ref"形式の秘密情報の参照に一致します。
+var secretReferencePattern = regexp.MustCompile(`^([a-z][a-z0-9+.-]*)://(.+)$`)
+
+// SecretProvider は参照から秘密情報を取得します。
+// VaultやAWS Secrets Managerなどの外部サービスはこのインターフェースを実装して登録します。
+type SecretProvider interface {
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// EnvSecretProvider は環境変数から秘密情報を取得します。refは環境変数名です。
+type EnvSecretProvider struct{}
+
+// Fetch は環境変数refの値を返します。設定されていない場合はエラーを返します。
+func (EnvSecretProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", errors.New("環境変数が設定されていません")
+	}
+	return value, nil
+}
+
+// FileSecretProvider はファイルから秘密情報を取得します。refはファイルのパスです。
+// Kubernetesのシークレットのように末尾に改行が付いたファイルに対応するため、末尾の改行は取り除きます。
+type FileSecretProvider struct{}
+
+// Fetch はファイルrefの内容を返します。
+func (FileSecretProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	content, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// StaticSecretProvider はあらかじめ与えた値を返すプロバイダです。テストやローカル環境で使います。
+type StaticSecretProvider map[string]string
+
+// Fetch はrefに対応する値を返します。存在しない場合はエラーを返します。
+func (p StaticSecretProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	value, ok := p[ref]
+	if !ok {
+		return "", errors.New("値が登録されていません")
+	}
+	return value, nil
+}
+
+// ResolveSecret はvalueが"provider://ref"形式の参照であればprovidersで解決した値を返し、そうでなければvalueをそのまま返します。
+// エラーには参照のみを含め、プロバイダが返した値は含めません。
+func ResolveSecret(ctx context.Context, value string, providers map[string]SecretProvider) (string, error) {
+	m := secretReferencePattern.FindStringSubmatch(value)
+	if m == nil {
+		return value, nil
+	}
+	provider, ok := providers[m[1]]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownSecretProvider, value)
+	}
+	secret, err := provider.Fetch(ctx, m[2])
+	if err != nil {
+		return "", fmt.Errorf("秘密情報 %s の取得に失敗しました: %w", value, err)
+	}
+	return secret, nil
+}
+
+// ResolveSecrets はcfgのUserとPasswordに含まれる秘密情報の参照を解決したDBConfigを返します。
+func ResolveSecrets(ctx context.Context, cfg DBConfig, providers map[string]SecretProvider) (DBConfig, error) {
+	user, err := ResolveSecret(ctx, cfg.User, providers)
+	if err != nil {
+		return DBConfig{}, err
+	}
+	password, err := ResolveSecret(ctx, cfg.Password, providers)
+	if err != nil {
+		return DBConfig{}, err
+	}
+	cfg.User, cfg.Password = user, password
+	return cfg, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSecretProvider は呼び出し回数ごとに異なる値を返すテスト用のSecretProviderです
+type countingSecretProvider struct {
+	calls int
+}
+
+func (p *countingSecretProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	p.calls++
+	return fmt.Sprintf("%s-v%d", ref, p.calls), nil
+}
+
+// failingSecretProvider は取得途中の値とともにエラーを返すテスト用のSecretProviderです
+type failingSecretProvider struct{}
+
+func (failingSecretProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	return "partial-secret", errors.New("permission denied")
+}
+
+// withDBCredentials はテスト中だけDBのユーザー名・パスワードと秘密情報のプロバイダを差し替えます
+func withDBCredentials(t *testing.T, user, password string, providers map[string]SecretProvider) {
+	originalUser, originalPassword, originalProviders := dbUser, dbPassword, secretProviders
+	dbUser, dbPassword, secretProviders = user, password, providers
+	t.Cleanup(func() {
+		dbUser, dbPassword, secretProviders = originalUser, originalPassword, originalProviders
+	})
+}
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("TEST_DB_PASSWORD", "from-env")
+	path := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0o600))
+
+	providers := map[string]SecretProvider{
+		"env":    EnvSecretProvider{},
+		"file":   FileSecretProvider{},
+		"static": StaticSecretProvider{"db": "from-static"},
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "環境変数", value: "env://TEST_DB_PASSWORD", expected: "from-env"},
+		{name: "ファイル", value: "file://" + path, expected: "from-file"},
+		{name: "固定値", value: "static://db", expected: "from-static"},
+		{name: "参照でない値", value: "plain-password", expected: "plain-password"},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			secret, err := ResolveSecret(context.Background(), tc.value, providers)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, secret)
+		})
+	}
+}
+
+func TestResolveSecret_Errors(t *testing.T) {
+	providers := map[string]SecretProvider{
+		"env":    EnvSecretProvider{},
+		"file":   FileSecretProvider{},
+		"static": StaticSecretProvider{},
+		"vault":  failingSecretProvider{},
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		unknown bool
+	}{
+		{name: "未登録のプロバイダ", value: "aws-sm://prod/db", unknown: true},
+		{name: "未設定の環境変数", value: "env://TEST_DB_PASSWORD_UNSET"},
+		{name: "存在しないファイル", value: "file:///nonexistent/password"},
+		{name: "未登録の固定値", value: "static://db"},
+		{name: "取得失敗", value: "vault://secret/db"},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ResolveSecret(context.Background(), tc.value, providers)
+
+			if assert.Error(t, err) {
+				assert.Equal(t, tc.unknown, errors.Is(err, ErrUnknownSecretProvider))
+				assert.Contains(t, err.Error(), tc.value, "エラーには参照を含むべき")
+				assert.NotContains(t, err.Error(), "partial-secret", "エラーには取得途中の値を含まないべき")
+			}
+		})
+	}
+}
+
+// TestConnectDB_ResolvesSecretsOnEachConnect は接続のたびに秘密情報を解決し、ローテーションされた値を使うことをテストします
+func TestConnectDB_ResolvesSecretsOnEachConnect(t *testing.T) {
+	provider := &countingSecretProvider{}
+	withDBCredentials(t, "app", "vault://db", map[string]SecretProvider{"vault": provider})
+
+	var dsns []string
+	withMockOpenDBFunc(t, func(driverName, dataSourceName string) (*sql.DB, error) {
+		dsns = append(dsns, dataSourceName)
+		db, _, err := sqlmock.New()
+		return db, err
+	}, func() {
+		for i := 0; i < 2; i++ {
+			db, err := ConnectDB()
+			assert.NoError(t, err)
+			db.Close()
+		}
+	})
+
+	assert.Equal(t, 2, provider.calls, "接続のたびに解決するべき")
+	if assert.Len(t, dsns, 2) {
+		assert.Contains(t, dsns[0], "app:db-v1@tcp(")
+		assert.Contains(t, dsns[1], "app:db-v2@tcp(", "ローテーションされたパスワードを使うべき")
+	}
+}
+
+// TestConnectDB_SecretError は秘密情報を解決できない場合に接続しないことをテストします
+func TestConnectDB_SecretError(t *testing.T) {
+	withDBCredentials(t, "app", "vault://db", map[string]SecretProvider{})
+
+	withMockOpenDBFunc(t, func(driverName, dataSourceName string) (*sql.DB, error) {
+		t.Fatal("秘密情報を解決できない場合は接続すべきでない")
+		return nil, nil
+	}, func() {
+		db, err := ConnectDB()
+
+		assert.True(t, errors.Is(err, ErrUnknownSecretProvider))
+		assert.Nil(t, db)
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnknownFormat は対応していない出力形式を指定した場合に返されます。
+var ErrUnknownFormat = errors.New("不明な出力形式です")
+
+// stockHeader はSerializeStocksで書き出す列です。
+var stockHeader = []string{"id", "name", "amount", "category"}
+
+// ResultSerializer は列名と行の値を特定の形式でwに書き出します。
+// CLIではフラグで指定された形式名からSerializerForで実装を選びます。
+type ResultSerializer interface {
+	Serialize(w io.Writer, header []string, rows [][]interface{}) error
+}
+
+// TableSerializer は列を揃えた表形式で書き出すResultSerializerです。
+type TableSerializer struct{}
+
+// Serialize は値を文字列にして表形式で書き出します。NULLは空文字列です。
+func (TableSerializer) Serialize(w io.Writer, header []string, rows [][]interface{}) error {
+	return writeTable(w, header, stringifyRows(rows))
+}
+
+// CSVSerializer はヘッダ行付きのCSVで書き出すResultSerializerです。
+type CSVSerializer struct{}
+
+// Serialize は値を文字列にしてCSVで書き出します。NULLは空文字列です。
+func (CSVSerializer) Serialize(w io.Writer, header []string, rows [][]interface{}) error {
+	return writeCSV(w, header, stringifyRows(rows))
+}
+
+// JSONSerializer は各行をJSONオブジェクトとした配列で書き出すResultSerializerです。
+type JSONSerializer struct{}
+
+// Serialize は値の型を保ったままJSONで書き出します。NULLはnullです。
+func (JSONSerializer) Serialize(w io.Writer, header []string, rows [][]interface{}) error {
+	return writeJSONObjects(w, header, rows)
+}
+
+// resultSerializers は形式名ごとのResultSerializerです。
+var resultSerializers = map[string]ResultSerializer{
+	formatTable: TableSerializer{},
+	formatCSV:   CSVSerializer{},
+	formatJSON:  JSONSerializer{},
+}
+
+// SerializerFor は形式名に対応するResultSerializerを返します。
+// 対応していない形式の場合はErrUnknownFormatを返します。
+func SerializerFor(format string) (ResultSerializer, error) {
+	serializer, ok := resultSerializers[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (%s、%s または %s を指定してください)", ErrUnknownFormat, format, formatTable, formatCSV, formatJSON)
+	}
+	return serializer, nil
+}
+
+// SerializeStocks は在庫データをformatで指定した形式でwに書き出します。
+func SerializeStocks(format string, w io.Writer, rows []Stock) error {
+	serializer, err := SerializerFor(format)
+	if err != nil {
+		return err
+	}
+	values := make([][]interface{}, len(rows))
+	for i, s := range rows {
+		values[i] = []interface{}{s.ID, s.Name, s.Amount, s.Category}
+	}
+	return serializer.Serialize(w, stockHeader, values)
+}
+
+// stringifyRows は行の値を文字列に変換します。NULLは空文字列です。
+func stringifyRows(rows [][]interface{}) [][]string {
+	records := make([][]string, len(rows))
+	for i, row := range rows {
+		records[i] = stringifyValues(row)
+	}
+	return records
+}
+
+// stringifyValues は値を文字列に変換します。NULLは空文字列です。
+func stringifyValues(values []interface{}) []string {
+	record := make([]string, len(values))
+	for i, v := range values {
+		if v != nil {
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return record
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSerializeStocks は各形式のResultSerializerで在庫データを書き出せることをテストします
+func TestSerializeStocks(t *testing.T) {
+	rows := []Stock{
+		{ID: 1, Name: "apple", Amount: 100, Category: "fruit"},
+		{ID: 2, Name: "banana, ripe", Amount: 5, Category: "fruit"},
+	}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format:   formatTable,
+			expected: "id  name          amount  category\n1   apple         100     fruit\n2   banana, ripe  5       fruit\n",
+		},
+		{
+			format:   formatCSV,
+			expected: "id,name,amount,category\n1,apple,100,fruit\n2,\"banana, ripe\",5,fruit\n",
+		},
+		{
+			format: formatJSON,
+			expected: "[\n" +
+				"  {\"id\": 1, \"name\": \"apple\", \"amount\": 100, \"category\": \"fruit\"},\n" +
+				"  {\"id\": 2, \"name\": \"banana, ripe\", \"amount\": 5, \"category\": \"fruit\"}\n" +
+				"]\n",
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, SerializeStocks(tc.format, &buf, rows))
+			assert.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
+
+// TestSerializeStocks_Empty は行がない場合もヘッダや空の配列を書き出すことをテストします
+func TestSerializeStocks_Empty(t *testing.T) {
+	expected := map[string]string{
+		formatTable: "id  name  amount  category\n",
+		formatCSV:   "id,name,amount,category\n",
+		formatJSON:  "[]\n",
+	}
+	for format, want := range expected {
+		var buf bytes.Buffer
+		assert.NoError(t, SerializeStocks(format, &buf, nil))
+		assert.Equal(t, want, buf.String(), "形式: %s", format)
+	}
+}
+
+// TestSerializeStocks_UnknownFormat は対応していない形式の場合に何も書き出さずErrUnknownFormatを返すことをテストします
+func TestSerializeStocks_UnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	err := SerializeStocks("xml", &buf, []Stock{{ID: 1, Name: "apple"}})
+
+	assert.True(t, errors.Is(err, ErrUnknownFormat), "ErrUnknownFormatを返すべき: %v", err)
+	assert.Contains(t, err.Error(), "xml")
+	assert.Empty(t, buf.String())
+}
+
+// TestStringifyValues はNULLを空文字列に、それ以外の値を文字列に変換することをテストします
+func TestStringifyValues(t *testing.T) {
+	assert.Equal(t, []string{"", "apple", "100", "1.5"}, stringifyValues([]interface{}{nil, "apple", int64(100), 1.5}))
+}
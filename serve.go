@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serveShutdownTimeout はserveサブコマンドの終了時に処理中のリクエストを待つ時間です。
+const serveShutdownTimeout = 10 * time.Second
+
+// ListingCache は在庫一覧をJSONに変換した結果を世代番号ごとにキャッシュします。
+// 世代番号は在庫を変更したトランザクションと同じトランザクションで進むため、世代番号が同じであれば一覧も同じです。
+type ListingCache struct {
+	db *sql.DB
+
+	mu         sync.Mutex
+	valid      bool
+	generation int64
+	body       []byte
+}
+
+// NewListingCache はdbの在庫一覧をキャッシュするListingCacheを返します。
+func NewListingCache(db *sql.DB) *ListingCache {
+	return &ListingCache{db: db}
+}
+
+// Body はgenerationの世代の在庫一覧を返します。キャッシュがその世代のものでなければ読み直してキャッシュします。
+// generationは一覧を読む前に取得した値を渡します。一覧が世代番号より新しいことはあっても古いことはないため、
+// 間に書き込みがあっても、次の世代番号で読み直されるまで古い一覧を返し続けることはありません。
+func (c *ListingCache) Body(ctx context.Context, generation int64) ([]byte, error) {
+	c.mu.Lock()
+	if c.valid && c.generation == generation {
+		body := c.body
+		c.mu.Unlock()
+		return body, nil
+	}
+	c.mu.Unlock()
+
+	body, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 並行して新しい世代を読み込んだ場合は、そちらを残す
+	if !c.valid || generation >= c.generation {
+		c.valid, c.generation, c.body = true, generation, body
+	}
+	return body, nil
+}
+
+// load は在庫一覧を読み込んでJSONに変換します。
+func (c *ListingCache) load(ctx context.Context) ([]byte, error) {
+	stocks, err := QueryStocksTypedContext(ctx, c.db, "")
+	if err != nil {
+		return nil, fmt.Errorf("在庫一覧の取得エラー: %w", classifyError(err))
+	}
+	var buf bytes.Buffer
+	if err := SerializeStocks(formatJSON, &buf, stocks); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// listingETag は世代番号に対応するETagです。
+func listingETag(generation int64) string {
+	return fmt.Sprintf(`"stocks-%d"`, generation)
+}
+
+// etagMatches はIf-None-Matchの値がetagに一致する場合にtrueを返します。弱いETagや複数の指定にも対応します。
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// StocksHandler はGET /stocksで在庫一覧をJSONで返すハンドラです。
+// tableGenerationEnabledが有効な場合は世代番号をETagとして返し、If-None-Matchが一致すれば
+// 一覧を読まずに304 Not Modifiedを返します。無効な場合は一覧の変更を検知できないため、毎回読み直してETagを付けません。
+func StocksHandler(cache *ListingCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var (
+			body []byte
+			err  error
+		)
+		if tableGenerationEnabled {
+			var generation int64
+			generation, err = TableGenerationContext(r.Context(), cache.db)
+			if err == nil {
+				etag := listingETag(generation)
+				w.Header().Set("ETag", etag)
+				w.Header().Set("Cache-Control", "no-cache")
+				if etagMatches(r.Header.Get("If-None-Match"), etag) {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				body, err = cache.Body(r.Context(), generation)
+			}
+		} else {
+			body, err = cache.load(r.Context())
+		}
+		if err != nil {
+			log.Printf("在庫一覧の取得に失敗しました: %v", err)
+			writeErrorReport(w, http.StatusInternalServerError, message("error.listing"), err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
+
+// operationView はGET /admin/operationsで返す実行中の操作です。
+type operationView struct {
+	ID             int64     `json:"id"`
+	Statement      string    `json:"statement"`
+	ConnectionID   int64     `json:"connection_id"`
+	StartedAt      time.Time `json:"started_at"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+}
+
+// OperationsHandler はGET /admin/operationsで実行中の時間のかかる操作（ListActiveOperations）をJSONで返すハンドラです。
+func OperationsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ops := ListActiveOperations()
+		views := make([]operationView, 0, len(ops))
+		for _, op := range ops {
+			views = append(views, operationView{
+				ID:             op.ID,
+				Statement:      op.Statement,
+				ConnectionID:   op.ConnectionID,
+				StartedAt:      op.StartedAt,
+				ElapsedSeconds: op.Elapsed.Seconds(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(views)
+	})
+}
+
+// CancelOperationHandler はPOST /admin/operations/cancel?id=Nで指定したIDの操作をCancelOperationで中断するハンドラです。
+// 中断できた場合は204 No Contentを、操作が見つからない場合は404を返します。
+func CancelOperationHandler(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeErrorReport(w, http.StatusBadRequest, message("error.operation-id"), err)
+			return
+		}
+		if err := CancelOperation(r.Context(), db, id); err != nil {
+			if errors.Is(err, ErrOperationNotFound) {
+				writeErrorReport(w, http.StatusNotFound, message("error.cancel"), err)
+				return
+			}
+			log.Printf("操作 %d の中断に失敗しました: %v", id, err)
+			writeErrorReport(w, http.StatusInternalServerError, message("error.cancel"), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// maintenanceView はGET/POST /admin/maintenanceで返すメンテナンスモードの状態です。
+type maintenanceView struct {
+	Maintenance bool `json:"maintenance"`
+}
+
+// MaintenanceHandler は/admin/maintenanceでメンテナンスモードを確認・切り替えるハンドラです。
+// GETは現在の状態を、POST ?enabled=true|falseはSetMaintenanceModeで切り替えた後の状態をJSONで返します。
+func MaintenanceHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				writeErrorReport(w, http.StatusBadRequest, message("error.maintenance-value"), err)
+				return
+			}
+			SetMaintenanceMode(enabled)
+			log.Printf("メンテナンスモードを切り替えました: %s", onOff(enabled))
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "許可されていないメソッドです", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(maintenanceView{Maintenance: InMaintenanceMode()})
+	})
+}
+
+// errorBody はエラー時のレスポンスの本文です。
+type errorBody struct {
+	Error  string `json:"error"`
+	Report Report `json:"report"`
+}
+
+// writeErrorReport はErrorReportのうち外部に返してよい項目（Report.Public）をJSONの本文にしてstatusで返します。
+func writeErrorReport(w http.ResponseWriter, status int, msg string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: msg, Report: ErrorReport(err).Public()})
+}
+
+// newServeMux はserveサブコマンドが公開するハンドラを登録したServeMuxを返します。
+// adminが有効な場合は、実行中の操作の一覧と中断のための/admin/operationsと、メンテナンスモードを切り替える/admin/maintenanceも登録します。
+// これらは認証を行わないため、信頼できるネットワークでのみ有効にしてください。
+func newServeMux(db *sql.DB, admin bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/stocks", StocksHandler(NewListingCache(db)))
+	if admin {
+		mux.Handle("/admin/operations", OperationsHandler())
+		mux.Handle("/admin/operations/cancel", CancelOperationHandler(db))
+		mux.Handle("/admin/maintenance", MaintenanceHandler())
+	}
+	return mux
+}
+
+// runServeCommand はserveサブコマンドを実行します。SIGINTかSIGTERMを受け取るまでHTTPで在庫一覧を提供します。
+// 使い方: serve [--addr :8080] [--admin]
+func runServeCommand(ctx context.Context, w io.Writer, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(w)
+	addr := fs.String("addr", ":8080", "待ち受けるアドレス")
+	admin := fs.Bool("admin", false, "実行中の操作の一覧と中断の/admin/operations、メンテナンスモードの/admin/maintenanceを公開する（認証なし）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: *addr, Handler: newServeMux(db, *admin)}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	fmt.Fprintf(w, "%s で待ち受けています\n", *addr)
+	if !tableGenerationEnabled {
+		fmt.Fprintln(w, "tableGenerationEnabledが無効のため、一覧はキャッシュせずに毎回読み込みます")
+	}
+	if *admin && !trackLongOperations {
+		fmt.Fprintln(w, "trackLongOperationsが無効のため、/admin/operationsの一覧は常に空です")
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), serveShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("サーバーの終了エラー: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectGeneration は世代番号の取得を期待値として設定します
+func expectGeneration(mock sqlmock.Sqlmock, generation int64) {
+	mock.ExpectQuery(`SELECT generation FROM stock_generation WHERE id = 1;`).
+		WillReturnRows(sqlmock.NewRows([]string{"generation"}).AddRow(generation))
+}
+
+// expectListing は在庫一覧の取得を期待値として設定します
+func expectListing(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(regexp.QuoteMeta(queryAllStocks())).WillReturnRows(rows)
+}
+
+// listingRows はappleだけを含む在庫一覧の行を返します
+func listingRows(amount int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "apple", amount, defaultCategory)
+}
+
+// getStocks はハンドラにGET /stocksを送り、レスポンスを返します
+func getStocks(handler http.Handler, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/stocks", nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestStocksHandler_ETag は世代番号が変わらなければ一覧を読み直さず、If-None-Matchが一致すれば304を返すことをテストします
+func TestStocksHandler_ETag(t *testing.T) {
+	withTableGeneration(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	handler := StocksHandler(NewListingCache(db))
+
+	// 初回は一覧を読み込む
+	expectGeneration(mock, 7)
+	expectListing(mock, listingRows(100))
+	first := getStocks(handler, "")
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, `"stocks-7"`, first.Header().Get("ETag"), "世代番号のETagが返るべき")
+	assert.Contains(t, first.Body.String(), `"apple"`)
+
+	// 同じ世代ではキャッシュを返す
+	expectGeneration(mock, 7)
+	second := getStocks(handler, "")
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String(), "キャッシュした一覧が返るべき")
+
+	// ETagが一致すれば304
+	expectGeneration(mock, 7)
+	notModified := getStocks(handler, `"stocks-7"`)
+	assert.Equal(t, http.StatusNotModified, notModified.Code)
+	assert.Empty(t, notModified.Body.String(), "304では本文を返さないべき")
+
+	// 弱いETagや複数の指定でも一致を判定する
+	expectGeneration(mock, 7)
+	assert.Equal(t, http.StatusNotModified, getStocks(handler, `"stocks-6", W/"stocks-7"`).Code)
+	verifyExpectations(t, mock)
+}
+
+// TestStocksHandler_InvalidatedByWrite は書き込みで世代番号が進むと一覧を読み直し、古いETagでは304を返さないことをテストします
+func TestStocksHandler_InvalidatedByWrite(t *testing.T) {
+	withTableGeneration(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	handler := StocksHandler(NewListingCache(db))
+
+	expectGeneration(mock, 7)
+	expectListing(mock, listingRows(100))
+	before := getStocks(handler, "")
+
+	// 書き込みは同じトランザクションで世代番号を進める
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 150).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectBumpGeneration(mock)
+	mock.ExpectCommit()
+	assert.NoError(t, UpsertStock(db, "apple", 50))
+
+	expectGeneration(mock, 8)
+	expectListing(mock, listingRows(150))
+	after := getStocks(handler, before.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusOK, after.Code, "古いETagでは304を返さないべき")
+	assert.Equal(t, `"stocks-8"`, after.Header().Get("ETag"))
+	assert.Contains(t, after.Body.String(), "150", "書き込み後の一覧が返るべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStocksHandler_GenerationDisabled は世代番号を使わない設定では毎回一覧を読み、ETagを付けないことをテストします
+func TestStocksHandler_GenerationDisabled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	handler := StocksHandler(NewListingCache(db))
+
+	expectListing(mock, listingRows(100))
+	expectListing(mock, listingRows(100))
+
+	for i := 0; i < 2; i++ {
+		rec := getStocks(handler, `"stocks-0"`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("ETag"), "変更を検知できないためETagを付けないべき")
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestStocksHandler_Errors はメソッドの制限と、取得エラーで500を返すことをテストします
+func TestStocksHandler_Errors(t *testing.T) {
+	withTableGeneration(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	handler := StocksHandler(NewListingCache(db))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/stocks", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	mock.ExpectQuery(`SELECT generation FROM stock_generation`).WillReturnError(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	failed := getStocks(handler, "")
+	assert.Equal(t, http.StatusInternalServerError, failed.Code)
+	assert.Equal(t, "application/json", failed.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error": "在庫一覧を取得できませんでした", "report": {
+		"classification": "connection", "retryable": true, "hint": "`+message("hint.connection")+`"}}`,
+		failed.Body.String(), "エラーのレポートは内部の情報を除いて返すべき")
+	verifyExpectations(t, mock)
+}
+
+// TestAdminOperations は/admin/operationsが実行中の操作を返し、cancelで中断することをテストします
+func TestAdminOperations(t *testing.T) {
+	withTrackLongOperations(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mux := newServeMux(db, true)
+
+	expectConnectionID(mock, 42)
+	mock.ExpectExec(`KILL QUERY 42;`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := trackOperation(context.Background(), db, "SELECT SLEEP(10);", func(ctx context.Context, q Queryer) error {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/operations", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var views []operationView
+		if !assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &views)) || !assert.Len(t, views, 1) {
+			return nil
+		}
+		assert.Equal(t, "SELECT SLEEP(10);", views[0].Statement)
+		assert.Equal(t, int64(42), views[0].ConnectionID)
+
+		cancel := httptest.NewRecorder()
+		mux.ServeHTTP(cancel, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/admin/operations/cancel?id=%d", views[0].ID), nil))
+		assert.Equal(t, http.StatusNoContent, cancel.Code)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	verifyExpectations(t, mock)
+}
+
+// TestAdminOperations_Errors はcancelのメソッドの制限、不正なIDと存在しない操作、adminが無効な場合をテストします
+func TestAdminOperations_Errors(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mux := newServeMux(db, true)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"GETは許可しない", http.MethodGet, "/admin/operations/cancel?id=1", http.StatusMethodNotAllowed},
+		{"数値でないID", http.MethodPost, "/admin/operations/cancel?id=abc", http.StatusBadRequest},
+		{"存在しない操作", http.MethodPost, "/admin/operations/cancel?id=999", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	newServeMux(db, false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/operations", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "adminが無効な場合は登録しないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestAdminMaintenance は/admin/maintenanceでメンテナンスモードを確認・切り替えられることをテストします
+func TestAdminMaintenance(t *testing.T) {
+	withMaintenanceMode(t, false)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mux := newServeMux(db, true)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/admin/maintenance")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"maintenance": false}`, rec.Body.String())
+
+	rec = serve(http.MethodPost, "/admin/maintenance?enabled=true")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"maintenance": true}`, rec.Body.String())
+	assert.True(t, InMaintenanceMode(), "POSTでメンテナンスモードに切り替わるべき")
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/admin/maintenance?enabled=maybe").Code)
+	assert.True(t, InMaintenanceMode(), "不正な値では切り替えないべき")
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodDelete, "/admin/maintenance").Code)
+
+	rec = serve(http.MethodPost, "/admin/maintenance?enabled=false")
+	assert.JSONEq(t, `{"maintenance": false}`, rec.Body.String())
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultShadowQueueSize はシャドウへの反映を待つ書き込みの既定の上限です。
+const defaultShadowQueueSize = 1024
+
+var (
+	// ErrCompareUnavailable は比較方法が設定されていないShadowRepositoryでCompareNowを呼んだ場合に返されます。
+	ErrCompareUnavailable = errors.New("シャドウとの比較方法が設定されていません")
+	// ErrShadowClosed はClose後のShadowRepositoryに書き込みを依頼した場合に返されます。
+	ErrShadowClosed = errors.New("シャドウへの二重書き込みは終了しています")
+)
+
+// ShadowStats はシャドウへの反映状況の累計です。
+type ShadowStats struct {
+	// Replayed はシャドウへの反映に成功した書き込みの数です。
+	Replayed int64
+	// Failed はシャドウへの反映に失敗した書き込みの数です。
+	Failed int64
+	// Dropped はキューが満杯だったか、プライマリへの書き込み中にCloseされたためシャドウへ反映しなかった書き込みの数です。
+	Dropped int64
+	// Mismatches はCompareNowで見つかった差分の件数の累計です。
+	Mismatches int64
+}
+
+// shadowWrite はシャドウへ反映する1件の書き込みです。
+type shadowWrite struct {
+	ctx   context.Context
+	apply func(ctx context.Context, repo StockRepository) error
+}
+
+// ShadowRepository は書き込みをプライマリに適用したうえで、シャドウにも非同期で反映するStockRepositoryです。
+// 新しいテーブルやクラスタへ無停止で移行する間の二重書き込みに使います。
+// 読み取りは常にプライマリから行い、シャドウでの失敗がプライマリの結果に影響することはありません。
+type ShadowRepository struct {
+	primary StockRepository
+	shadow  StockRepository
+	compare func(ctx context.Context) (SnapshotDiff, error)
+
+	queue chan shadowWrite
+	done  sync.WaitGroup
+	// mu はclosedと、queueへの追加とqueueを閉じる操作を守ります。
+	mu     sync.Mutex
+	closed bool
+
+	replayed   atomic.Int64
+	failed     atomic.Int64
+	dropped    atomic.Int64
+	mismatches atomic.Int64
+}
+
+// NewShadowRepository はprimaryとshadowに二重書き込みするShadowRepositoryを返します。
+// queueSizeは反映待ちの書き込みの上限で、0以下の場合はdefaultShadowQueueSizeです。
+// compareはCompareNowで使う比較方法で、nilの場合CompareNowはErrCompareUnavailableを返します。
+// 使い終わったらCloseで反映待ちの書き込みを処理して停止します。
+func NewShadowRepository(primary, shadow StockRepository, queueSize int, compare func(ctx context.Context) (SnapshotDiff, error)) *ShadowRepository {
+	if queueSize <= 0 {
+		queueSize = defaultShadowQueueSize
+	}
+	r := &ShadowRepository{
+		primary: primary,
+		shadow:  shadow,
+		compare: compare,
+		queue:   make(chan shadowWrite, queueSize),
+	}
+	r.done.Add(1)
+	go r.run()
+	return r
+}
+
+// run はキューが閉じられるまで書き込みをシャドウへ順に反映します。
+func (r *ShadowRepository) run() {
+	defer r.done.Done()
+	for w := range r.queue {
+		if err := w.apply(w.ctx, r.shadow); err != nil {
+			r.failed.Add(1)
+			continue
+		}
+		r.replayed.Add(1)
+	}
+}
+
+// checkOpen はCloseされていればErrShadowClosedを返します。
+func (r *ShadowRepository) checkOpen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrShadowClosed
+	}
+	return nil
+}
+
+// enqueue は書き込みをシャドウへの反映待ちに追加します。キューが満杯の場合と、Close後の場合は破棄して数えます。
+func (r *ShadowRepository) enqueue(ctx context.Context, apply func(ctx context.Context, repo StockRepository) error) {
+	// プライマリの処理が終わった後でキャンセルされても、シャドウへの反映は続ける
+	w := shadowWrite{ctx: context.WithoutCancel(ctx), apply: apply}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		r.dropped.Add(1)
+		return
+	}
+	select {
+	case r.queue <- w:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Ping はプライマリへの接続を確認します。
+func (r *ShadowRepository) Ping(ctx context.Context) error {
+	return r.primary.Ping(ctx)
+}
+
+// QueryStocks はプライマリから在庫データを取得します。
+func (r *ShadowRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	return r.primary.QueryStocks(ctx, name)
+}
+
+// UpsertStock はプライマリの在庫データを更新し、成功した場合はシャドウにも反映します。
+// Close後はプライマリにも書き込まずにErrShadowClosedを返します。
+func (r *ShadowRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+	if err := r.primary.UpsertStock(ctx, name, amount, opts...); err != nil {
+		return err
+	}
+	r.enqueue(ctx, func(ctx context.Context, repo StockRepository) error {
+		return repo.UpsertStock(ctx, name, amount, opts...)
+	})
+	return nil
+}
+
+// EnsureSchema はプライマリにテーブルを作成し、成功した場合はシャドウにも反映します。
+// Close後はプライマリにも作成せずにErrShadowClosedを返します。
+func (r *ShadowRepository) EnsureSchema(ctx context.Context) error {
+	if err := r.checkOpen(); err != nil {
+		return err
+	}
+	if err := r.primary.EnsureSchema(ctx); err != nil {
+		return err
+	}
+	r.enqueue(ctx, func(ctx context.Context, repo StockRepository) error {
+		return repo.EnsureSchema(ctx)
+	})
+	return nil
+}
+
+// CompareNow はプライマリとシャドウの内容を比較し、見つかった差分の件数をMismatchesに加算します。
+func (r *ShadowRepository) CompareNow(ctx context.Context) (SnapshotDiff, error) {
+	if r.compare == nil {
+		return SnapshotDiff{}, ErrCompareUnavailable
+	}
+	diff, err := r.compare(ctx)
+	if err != nil {
+		return diff, err
+	}
+	count := len(diff.Missing) + len(diff.Extra) + len(diff.AmountMismatches) + len(diff.IDDrift)
+	r.mismatches.Add(int64(count))
+	return diff, nil
+}
+
+// Stats はシャドウへの反映状況の累計を返します。
+func (r *ShadowRepository) Stats() ShadowStats {
+	return ShadowStats{
+		Replayed:   r.replayed.Load(),
+		Failed:     r.failed.Load(),
+		Dropped:    r.dropped.Load(),
+		Mismatches: r.mismatches.Load(),
+	}
+}
+
+// Close は反映待ちの書き込みをすべてシャドウへ反映してから停止します。
+// Close後の書き込みはErrShadowClosedを返し、読み取りは引き続きプライマリから行います。
+func (r *ShadowRepository) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	r.done.Wait()
+}
+
+// NewSQLShadowRepository はプライマリとシャドウのDBに二重書き込みするShadowRepositoryを返します。
+// CompareNowはCompareDatabasesで両方のstocksテーブルを比較します。
+func NewSQLShadowRepository(primary, shadow *sql.DB, queueSize int) *ShadowRepository {
+	compare := func(ctx context.Context) (SnapshotDiff, error) {
+		return CompareDatabases(ctx, primary, shadow)
+	}
+	return NewShadowRepository(NewSQLStockRepository(primary), NewSQLStockRepository(shadow), queueSize, compare)
+}
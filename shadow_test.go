@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingStockRepository はreleaseが閉じられるまで書き込みを止めるテスト用のStockRepositoryです
+type blockingStockRepository struct {
+	fakeStockRepository
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStockRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakeStockRepository.UpsertStock(ctx, name, amount, opts...)
+}
+
+// compareFakes は2つのフェイクの在庫数を比較するCompareNow用の関数を返します
+func compareFakes(primary, shadow *fakeStockRepository) func(ctx context.Context) (SnapshotDiff, error) {
+	return func(ctx context.Context) (SnapshotDiff, error) {
+		var diff SnapshotDiff
+		for name, amount := range primary.stocks {
+			other, ok := shadow.stocks[name]
+			switch {
+			case !ok:
+				diff.Missing = append(diff.Missing, Stock{Name: name, Amount: int64(amount)})
+			case other != amount:
+				diff.AmountMismatches = append(diff.AmountMismatches, StockMismatch{
+					Name:   name,
+					Source: Stock{Name: name, Amount: int64(amount)},
+					Target: Stock{Name: name, Amount: int64(other)},
+				})
+			}
+		}
+		return diff, nil
+	}
+}
+
+// TestShadowRepository_ReplaysWrites は書き込みがシャドウに反映され、読み取りはプライマリから行われることをテストします
+func TestShadowRepository_ReplaysWrites(t *testing.T) {
+	primary := &fakeStockRepository{stocks: map[string]int{"apple": 100}}
+	shadow := &fakeStockRepository{stocks: map[string]int{"apple": 100, "ghost": 1}}
+	repo := NewShadowRepository(primary, shadow, 10, nil)
+
+	ctx := context.Background()
+	assert.NoError(t, repo.UpsertStock(ctx, "apple", 50))
+	assert.NoError(t, repo.UpsertStock(ctx, "banana", 20))
+
+	results, err := repo.QueryStocks(ctx, "ghost")
+	assert.NoError(t, err)
+	assert.Empty(t, results, "読み取りはプライマリから行うべき")
+
+	repo.Close()
+	assert.Equal(t, map[string]int{"apple": 150, "banana": 20}, primary.stocks)
+	assert.Equal(t, map[string]int{"apple": 150, "banana": 20, "ghost": 1}, shadow.stocks, "シャドウにも反映されるべき")
+	assert.Equal(t, ShadowStats{Replayed: 2}, repo.Stats())
+}
+
+// TestShadowRepository_ShadowFailure はシャドウの失敗がプライマリの結果に影響せず、失敗数が増えることをテストします
+func TestShadowRepository_ShadowFailure(t *testing.T) {
+	primary := &fakeStockRepository{stocks: map[string]int{}}
+	shadow := &fakeStockRepository{stocks: map[string]int{}, upsertErr: errors.New("shadow down")}
+	repo := NewShadowRepository(primary, shadow, 10, compareFakes(primary, shadow))
+
+	ctx := context.Background()
+	assert.NoError(t, repo.UpsertStock(ctx, "apple", 10), "シャドウの失敗はプライマリの結果に影響しないべき")
+	assert.NoError(t, repo.UpsertStock(ctx, "banana", 5))
+	repo.Close()
+
+	assert.Equal(t, map[string]int{"apple": 10, "banana": 5}, primary.stocks)
+	assert.Equal(t, int64(2), repo.Stats().Failed, "シャドウの失敗数が増えるべき")
+
+	diff, err := repo.CompareNow(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, diff.Missing, 2, "シャドウに反映されなかった行が差分になるべき")
+	assert.Equal(t, int64(2), repo.Stats().Mismatches)
+}
+
+// TestShadowRepository_PrimaryFailure はプライマリが失敗した書き込みをシャドウへ反映しないことをテストします
+func TestShadowRepository_PrimaryFailure(t *testing.T) {
+	primary := &fakeStockRepository{stocks: map[string]int{}, upsertErr: errors.New("primary down")}
+	shadow := &fakeStockRepository{stocks: map[string]int{}}
+	repo := NewShadowRepository(primary, shadow, 10, nil)
+
+	err := repo.UpsertStock(context.Background(), "apple", 10)
+	repo.Close()
+
+	assert.EqualError(t, err, "primary down")
+	assert.Empty(t, shadow.stocks, "失敗した書き込みはシャドウへ反映しないべき")
+	assert.Equal(t, ShadowStats{}, repo.Stats())
+}
+
+// TestShadowRepository_Overflow はキューが満杯の場合に書き込みを破棄して数えることをテストします
+func TestShadowRepository_Overflow(t *testing.T) {
+	primary := &fakeStockRepository{stocks: map[string]int{}}
+	shadow := &blockingStockRepository{
+		fakeStockRepository: fakeStockRepository{stocks: map[string]int{}},
+		started:             make(chan struct{}, 10),
+		release:             make(chan struct{}),
+	}
+	repo := NewShadowRepository(primary, shadow, 1, nil)
+
+	ctx := context.Background()
+	// 1件目はシャドウへの反映中で止まり、2件目がキューに入り、それ以降は破棄される
+	assert.NoError(t, repo.UpsertStock(ctx, "a", 1))
+	<-shadow.started
+	for _, name := range []string{"b", "c", "d"} {
+		assert.NoError(t, repo.UpsertStock(ctx, name, 1), "破棄されてもプライマリの結果は成功するべき")
+	}
+
+	close(shadow.release)
+	repo.Close()
+
+	assert.Len(t, primary.stocks, 4, "プライマリにはすべて書き込まれるべき")
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, shadow.stocks)
+	assert.Equal(t, ShadowStats{Replayed: 2, Dropped: 2}, repo.Stats())
+}
+
+// TestShadowRepository_WriteAfterClose はClose後の書き込みがパニックせずにErrShadowClosedを返し、読み取りは続けられることをテストします
+func TestShadowRepository_WriteAfterClose(t *testing.T) {
+	primary := &fakeStockRepository{stocks: map[string]int{"apple": 100}}
+	shadow := &fakeStockRepository{stocks: map[string]int{}}
+	repo := NewShadowRepository(primary, shadow, 0, nil)
+	repo.Close()
+
+	ctx := context.Background()
+	assert.ErrorIs(t, repo.UpsertStock(ctx, "apple", 10), ErrShadowClosed)
+	assert.ErrorIs(t, repo.EnsureSchema(ctx), ErrShadowClosed)
+	results, err := repo.QueryStocks(ctx, "apple")
+	assert.NoError(t, err, "Close後も読み取りはプライマリから行うべき")
+	assert.Len(t, results, 1)
+	assert.Equal(t, map[string]int{"apple": 100}, primary.stocks, "Close後の書き込みはプライマリにも適用しないべき")
+	assert.NotPanics(t, repo.Close, "2回目のCloseはパニックしないべき")
+}
+
+// TestShadowRepository_CloseDuringPrimaryWrite はプライマリへの書き込み中にCloseされた場合、シャドウへの反映を破棄して数えることをテストします
+func TestShadowRepository_CloseDuringPrimaryWrite(t *testing.T) {
+	primary := &blockingStockRepository{
+		fakeStockRepository: fakeStockRepository{stocks: map[string]int{}},
+		started:             make(chan struct{}, 1),
+		release:             make(chan struct{}),
+	}
+	shadow := &fakeStockRepository{stocks: map[string]int{}}
+	repo := NewShadowRepository(primary, shadow, 0, nil)
+
+	written := make(chan error)
+	go func() { written <- repo.UpsertStock(context.Background(), "apple", 1) }()
+	<-primary.started
+	repo.Close()
+	close(primary.release)
+
+	assert.NoError(t, <-written, "プライマリへの書き込みは成功するべき")
+	assert.Equal(t, map[string]int{"apple": 1}, primary.stocks)
+	assert.Empty(t, shadow.stocks)
+	assert.Equal(t, ShadowStats{Dropped: 1}, repo.Stats())
+}
+
+// TestShadowRepository_CompareUnavailable は比較方法がない場合にエラーを返すことをテストします
+func TestShadowRepository_CompareUnavailable(t *testing.T) {
+	repo := NewShadowRepository(&fakeStockRepository{}, &fakeStockRepository{}, 0, nil)
+	defer repo.Close()
+
+	_, err := repo.CompareNow(context.Background())
+	assert.ErrorIs(t, err, ErrCompareUnavailable)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrNoShards はシャードの一覧が空の場合に返されます。
+var ErrNoShards = errors.New("シャードが指定されていません")
+
+// ShardFor は商品名のFNV-1aハッシュをシャード数で割った余りで（shardIndex）、nameを格納するシャードを返します。
+// ハッシュは実行環境やプロセスによらず一定のため、同じ名前は同じ並びのdbsに対して常に同じシャードに割り当てられます。
+// シャードの数や順序を変えると割り当ても変わるため、変更する場合はデータの移動が必要です。dbsが空の場合はnilを返します。
+func ShardFor(name string, dbs []*sql.DB) *sql.DB {
+	if len(dbs) == 0 {
+		return nil
+	}
+	return dbs[shardIndex(name, len(dbs))]
+}
+
+// UpsertStockSharded はnameを割り当てたシャードでUpsertStockを実行します。
+func UpsertStockSharded(dbs []*sql.DB, name string, amount int, opts ...UpsertOption) error {
+	return UpsertStockShardedContext(context.Background(), dbs, name, amount, opts...)
+}
+
+// UpsertStockShardedContext はUpsertStockShardedのcontext対応版です。
+func UpsertStockShardedContext(ctx context.Context, dbs []*sql.DB, name string, amount int, opts ...UpsertOption) error {
+	db := ShardFor(name, dbs)
+	if db == nil {
+		return ErrNoShards
+	}
+	return UpsertStockContext(ctx, db, name, amount, opts...)
+}
+
+// QueryStocksSharded はnameを割り当てたシャードでQueryStocksを実行します。
+// nameが空の場合はすべてのシャードを順に検索し、シャードの順に結果を連結して返します。
+func QueryStocksSharded(dbs []*sql.DB, name string) ([]map[string]interface{}, error) {
+	return QueryStocksShardedContext(context.Background(), dbs, name)
+}
+
+// QueryStocksShardedContext はQueryStocksShardedのcontext対応版です。
+func QueryStocksShardedContext(ctx context.Context, dbs []*sql.DB, name string) ([]map[string]interface{}, error) {
+	if len(dbs) == 0 {
+		return nil, ErrNoShards
+	}
+	if name != "" {
+		return QueryStocksContext(ctx, ShardFor(name, dbs), name)
+	}
+
+	results := []map[string]interface{}{}
+	for i, db := range dbs {
+		rows, err := QueryStocksContext(ctx, db, "")
+		if err != nil {
+			return nil, fmt.Errorf("シャード %d の検索エラー: %w", i, err)
+		}
+		results = append(results, rows...)
+	}
+	return results, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newShards はn個のシャードのモックDBを作成します
+func newShards(t *testing.T, n int) ([]*sql.DB, []sqlmock.Sqlmock) {
+	dbs := make([]*sql.DB, n)
+	mocks := make([]sqlmock.Sqlmock, n)
+	for i := range dbs {
+		db, mock, _ := setupMockDB(t)
+		t.Cleanup(func() { db.Close() })
+		dbs[i], mocks[i] = db, mock
+	}
+	return dbs, mocks
+}
+
+// TestShardFor は同じ名前が常に同じシャードに割り当てられ、割り当てが実行ごとに変わらないことをテストします
+func TestShardFor(t *testing.T) {
+	dbs, _ := newShards(t, 3)
+
+	// FNV-1aの値から決まる割り当て。実行やプロセスをまたいで変わってはならない
+	expected := map[string]int{"apple": 2, "banana": 1, "cherry": 1, "りんご": 0}
+	for name, index := range expected {
+		assert.Same(t, dbs[index], ShardFor(name, dbs), name)
+		for i := 0; i < 10; i++ {
+			assert.Same(t, ShardFor(name, dbs), ShardFor(name, dbs), "同じ名前は同じシャードに割り当てるべき")
+		}
+	}
+
+	assert.Equal(t, 3, shardIndex("apple", 4), "シャード数が変われば割り当ても変わる")
+	assert.Same(t, dbs[0], ShardFor("apple", dbs[:1]), "シャードが1つならすべてそのシャードに割り当てるべき")
+	assert.Nil(t, ShardFor("apple", nil))
+}
+
+// TestUpsertStockSharded は名前を割り当てたシャードだけに書き込むことをテストします
+func TestUpsertStockSharded(t *testing.T) {
+	dbs, mocks := newShards(t, 3)
+
+	expectStockAmount(mocks[2], "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mocks[2].ExpectBegin()
+	expectUpdateAmount(mocks[2], "apple", 150).WillReturnResult(sqlmock.NewResult(0, 1))
+	mocks[2].ExpectCommit()
+
+	assert.NoError(t, UpsertStockSharded(dbs, "apple", 50))
+	for _, mock := range mocks {
+		verifyExpectations(t, mock)
+	}
+
+	assert.ErrorIs(t, UpsertStockSharded(nil, "apple", 50), ErrNoShards)
+}
+
+// TestQueryStocksSharded は名前を指定した場合は割り当てたシャードだけを、空の場合はすべてのシャードを検索することをテストします
+func TestQueryStocksSharded(t *testing.T) {
+	t.Run("名前を指定", func(t *testing.T) {
+		dbs, mocks := newShards(t, 3)
+		mocks[1].ExpectQuery(regexp.QuoteMeta(queryStocksByName())).WithArgs("banana").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).AddRow(1, "banana", 5))
+
+		results, err := QueryStocksSharded(dbs, "banana")
+
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, "banana", results[0]["name"])
+		for _, mock := range mocks {
+			verifyExpectations(t, mock)
+		}
+	})
+
+	t.Run("すべてのシャード", func(t *testing.T) {
+		dbs, mocks := newShards(t, 2)
+		mocks[0].ExpectQuery(regexp.QuoteMeta(queryAllStocks())).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).AddRow(1, "apple", 10))
+		mocks[1].ExpectQuery(regexp.QuoteMeta(queryAllStocks())).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).AddRow(1, "banana", 5))
+
+		results, err := QueryStocksSharded(dbs, "")
+
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.Equal(t, "apple", results[0]["name"])
+		assert.Equal(t, "banana", results[1]["name"])
+		for _, mock := range mocks {
+			verifyExpectations(t, mock)
+		}
+	})
+
+	t.Run("シャードのエラー", func(t *testing.T) {
+		dbs, mocks := newShards(t, 2)
+		mocks[0].ExpectQuery(regexp.QuoteMeta(queryAllStocks())).WillReturnError(errors.New("connection refused"))
+
+		_, err := QueryStocksSharded(dbs, "")
+
+		assert.ErrorContains(t, err, "シャード 0")
+	})
+
+	t.Run("シャードなし", func(t *testing.T) {
+		_, err := QueryStocksSharded(nil, "apple")
+		assert.ErrorIs(t, err, ErrNoShards)
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// flightKey は同じ問い合わせとしてまとめるキーです。
+// sourceは問い合わせ先（*sql.DBなど）、generationは問い合わせ先が書き込みの前後の問い合わせを区別するための番号です。
+type flightKey struct {
+	source     interface{}
+	name       string
+	generation uint64
+}
+
+// flightCall は実行中の問い合わせです。doneが閉じられた後はvalueとerrが確定します。
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+	// dups は実行中の問い合わせに相乗りした呼び出しの数です。
+	dups int
+}
+
+// queryFlight は同じキーの同時の問い合わせを1回にまとめます（singleflight）。
+type queryFlight struct {
+	mu    sync.Mutex
+	calls map[flightKey]*flightCall
+}
+
+// newQueryFlight は空のqueryFlightを返します。
+func newQueryFlight() *queryFlight {
+	return &queryFlight{calls: make(map[flightKey]*flightCall)}
+}
+
+// stockAmountFlight はGetStockAmountが共有する問い合わせのまとめ役です。
+var stockAmountFlight = newQueryFlight()
+
+// do はkeyの問い合わせが実行中であればその結果を待ち、なければfnを実行します。
+// fnは呼び出し元のどれか1つのキャンセルで他の呼び出しが失敗しないよう、キャンセルと期限を外したctxで実行します。
+// 代わりにsharedQueryTimeoutの期限を設定するため、応答しない問い合わせが残り続けることはありません。
+// 各呼び出しは自分のctxが終了した時点で待つのをやめ、ctxのエラーを返します。
+func (f *queryFlight) do(ctx context.Context, key flightKey, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	f.mu.Lock()
+	call, ok := f.calls[key]
+	if ok {
+		call.dups++
+	} else {
+		call = &flightCall{done: make(chan struct{})}
+		f.calls[key] = call
+		go func() {
+			sharedCtx, cancel := sharedQueryContext(ctx)
+			call.value, call.err = fn(sharedCtx)
+			cancel()
+			f.mu.Lock()
+			delete(f.calls, key)
+			f.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sharedQueryContext はctxの値を引き継ぎ、キャンセルと期限を外したうえでsharedQueryTimeoutの期限を設けたctxを返します。
+func sharedQueryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	shared := context.WithoutCancel(ctx)
+	if sharedQueryTimeout <= 0 {
+		return shared, func() {}
+	}
+	return context.WithTimeout(shared, sharedQueryTimeout)
+}
+
+// GetStockAmount は指定した商品の在庫数を返します。該当する行がない場合はsql.ErrNoRowsを返します。
+// 同じ商品の在庫数を同時に問い合わせた呼び出しは、実行中の1回の問い合わせの結果を共有します。
+func GetStockAmount(db *sql.DB, name string) (int, error) {
+	return GetStockAmountContext(context.Background(), db, name)
+}
+
+// GetStockAmountContext はGetStockAmountのcontext対応版です。
+func GetStockAmountContext(ctx context.Context, db *sql.DB, name string) (int, error) {
+	value, err := stockAmountFlight.do(ctx, flightKey{source: db, name: name}, func(ctx context.Context) (interface{}, error) {
+		var amount int
+		obs := observeQuery(stmtStockAmount.SQL)
+		err := db.QueryRowContext(ctx, taggedSQL(ctx, "GetStockAmount", stmtStockAmount.SQL), name).Scan(&amount)
+		switch err {
+		case nil:
+			obs.done(1, nil)
+		case sql.ErrNoRows:
+			obs.done(0, nil)
+			return 0, err
+		default:
+			obs.done(0, err)
+			return 0, newQueryError(ctx, "GetStockAmount", stmtStockAmount.SQL, err)
+		}
+		return amount, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value.(int), nil
+}
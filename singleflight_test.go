@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForDups はkeyの実行中の問い合わせにn件の呼び出しが相乗りするまで待ちます
+func waitForDups(t *testing.T, f *queryFlight, key flightKey, n int) {
+	require.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		call, ok := f.calls[key]
+		return ok && call.dups == n
+	}, 5*time.Second, time.Millisecond, "%d件の呼び出しが相乗りするべき", n)
+}
+
+// TestGetStockAmount_Coalesce は同じ商品への同時の問い合わせが1回のクエリにまとめられることをテストします
+func TestGetStockAmount_Coalesce(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	const callers = 20
+
+	// 期待値は1回だけ。2回目のクエリが発行されればsqlmockがエラーを返す
+	expectStockAmount(mock, "apple").
+		WillDelayFor(500 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(42))
+
+	var wg sync.WaitGroup
+	amounts := make([]int, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			amounts[i], errs[i] = GetStockAmount(db, "apple")
+		}(i)
+	}
+	waitForDups(t, stockAmountFlight, flightKey{source: db, name: "apple"}, callers-1)
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, 42, amounts[i])
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestGetStockAmount_NotFound は該当する行がない場合にsql.ErrNoRowsを返し、次の呼び出しでは改めて問い合わせることをテストします
+func TestGetStockAmount_NotFound(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	expectStockAmount(mock, "ghost").WillReturnError(sql.ErrNoRows)
+	expectStockAmount(mock, "ghost").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(1))
+
+	_, err := GetStockAmount(db, "ghost")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	amount, err := GetStockAmount(db, "ghost")
+	assert.NoError(t, err, "終わった問い合わせの結果は使い回さないべき")
+	assert.Equal(t, 1, amount)
+	verifyExpectations(t, mock)
+}
+
+// TestQueryFlight は実行中の問い合わせの結果を共有し、異なるキーや待っている呼び出しのキャンセルは互いに影響しないことをテストします
+func TestQueryFlight(t *testing.T) {
+	f := newQueryFlight()
+	apple, banana := flightKey{name: "apple"}, flightKey{name: "banana"}
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fn := func(amount int) func(ctx context.Context) (interface{}, error) {
+		return func(ctx context.Context) (interface{}, error) {
+			calls.Add(1)
+			<-release
+			return amount, nil
+		}
+	}
+
+	results := make(chan int, 3)
+	go func() { a, _ := f.do(context.Background(), apple, fn(10)); results <- a.(int) }()
+	waitForDups(t, f, apple, 0)
+	go func() { a, _ := f.do(context.Background(), apple, fn(99)); results <- a.(int) }()
+	waitForDups(t, f, apple, 1)
+	go func() { a, _ := f.do(context.Background(), banana, fn(20)); results <- a.(int) }()
+	waitForDups(t, f, banana, 0)
+
+	// 待っている呼び出しは自分のctxで待つのをやめる
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := f.do(ctx, apple, fn(99))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+	got := []int{<-results, <-results, <-results}
+	assert.ElementsMatch(t, []int{10, 10, 20}, got, "同じキーの呼び出しは最初の問い合わせの結果を共有するべき")
+	assert.Equal(t, int32(2), calls.Load(), "問い合わせはキーごとに1回だけ実行するべき")
+}
+
+// TestQueryFlight_SharedTimeout は共有する問い合わせが呼び出し元のキャンセルを引き継がず、sharedQueryTimeoutで打ち切られることをテストします
+func TestQueryFlight_SharedTimeout(t *testing.T) {
+	original := sharedQueryTimeout
+	sharedQueryTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { sharedQueryTimeout = original })
+
+	f := newQueryFlight()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	shared := make(chan error, 1)
+	_, err := f.do(ctx, flightKey{name: "apple"}, func(ctx context.Context) (interface{}, error) {
+		_, hasDeadline := ctx.Deadline()
+		assert.True(t, hasDeadline, "共有する問い合わせには期限を設けるべき")
+		<-ctx.Done()
+		shared <- ctx.Err()
+		return 0, ctx.Err()
+	})
+
+	assert.ErrorIs(t, err, context.Canceled, "呼び出し元は自分のctxで待つのをやめるべき")
+	select {
+	case err := <-shared:
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "呼び出し元のキャンセルではなく期限で打ち切られるべき")
+	case <-time.After(time.Second):
+		t.Fatal("共有する問い合わせがsharedQueryTimeoutで打ち切られなかった")
+	}
+}
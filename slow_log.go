@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SlowQueryLogRepository は委譲先の操作にかかった時間を計り、threshold以上かかった操作をログに出力するStockRepositoryです。
+type SlowQueryLogRepository struct {
+	repo      StockRepository
+	threshold time.Duration
+	now       func() time.Time
+	logf      func(format string, args ...interface{})
+}
+
+// NewSlowQueryLogRepository はrepoへの操作のうちthreshold以上かかったものを記録するSlowQueryLogRepositoryを返します。
+func NewSlowQueryLogRepository(repo StockRepository, threshold time.Duration) *SlowQueryLogRepository {
+	return &SlowQueryLogRepository{repo: repo, threshold: threshold, now: time.Now, logf: log.Printf}
+}
+
+// observe はstartからの経過時間がthreshold以上であればログに出力します。
+func (r *SlowQueryLogRepository) observe(start time.Time, operation, name string, err error) {
+	elapsed := r.now().Sub(start)
+	if elapsed < r.threshold {
+		return
+	}
+	if err != nil {
+		r.logf("遅い操作: %s name=%q %v (エラー: %v)", operation, name, elapsed, err)
+		return
+	}
+	r.logf("遅い操作: %s name=%q %v", operation, name, elapsed)
+}
+
+// Ping はDBへの接続を確認します。
+func (r *SlowQueryLogRepository) Ping(ctx context.Context) error {
+	start := r.now()
+	err := r.repo.Ping(ctx)
+	r.observe(start, "Ping", "", err)
+	return err
+}
+
+// QueryStocks は在庫データを取得します。
+func (r *SlowQueryLogRepository) QueryStocks(ctx context.Context, name string) ([]map[string]interface{}, error) {
+	start := r.now()
+	rows, err := r.repo.QueryStocks(ctx, name)
+	r.observe(start, "QueryStocks", name, err)
+	return rows, err
+}
+
+// UpsertStock は在庫を更新または挿入します。
+func (r *SlowQueryLogRepository) UpsertStock(ctx context.Context, name string, amount int, opts ...UpsertOption) error {
+	start := r.now()
+	err := r.repo.UpsertStock(ctx, name, amount, opts...)
+	r.observe(start, "UpsertStock", name, err)
+	return err
+}
+
+// EnsureSchema はテーブルを作成します。
+func (r *SlowQueryLogRepository) EnsureSchema(ctx context.Context) error {
+	start := r.now()
+	err := r.repo.EnsureSchema(ctx)
+	r.observe(start, "EnsureSchema", "", err)
+	return err
+}
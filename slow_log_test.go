@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSlowQueryLogRepository は1回の操作ごとにelapsedだけ時刻が進むSlowQueryLogRepositoryと、出力されたログを返します
+func newTestSlowQueryLogRepository(repo StockRepository, threshold, elapsed time.Duration) (*SlowQueryLogRepository, *[]string) {
+	var logs []string
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewSlowQueryLogRepository(repo, threshold)
+	r.now = func() time.Time {
+		t := now
+		now = now.Add(elapsed)
+		return t
+	}
+	r.logf = func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	return r, &logs
+}
+
+// TestSlowQueryLogRepository は閾値以上かかった操作だけがログに出力されることをテストします
+func TestSlowQueryLogRepository(t *testing.T) {
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		logged  bool
+	}{
+		{name: "閾値未満", elapsed: 50 * time.Millisecond},
+		{name: "閾値ちょうど", elapsed: 100 * time.Millisecond, logged: true},
+		{name: "閾値超過", elapsed: time.Second, logged: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeStockRepository{stocks: map[string]int{}}
+			repo, logs := newTestSlowQueryLogRepository(fake, 100*time.Millisecond, tc.elapsed)
+
+			require.NoError(t, repo.UpsertStock(context.Background(), "apple", 5))
+
+			assert.Equal(t, 5, fake.stocks["apple"], "委譲先に更新が適用されるべき")
+			if !tc.logged {
+				assert.Empty(t, *logs, "ログに出力されないべき")
+				return
+			}
+			require.Len(t, *logs, 1)
+			assert.Contains(t, (*logs)[0], `UpsertStock name="apple"`, "操作と商品名が出力されるべき")
+		})
+	}
+}
+
+// TestSlowQueryLogRepository_Error は失敗した遅い操作のエラーもログに出力されることをテストします
+func TestSlowQueryLogRepository_Error(t *testing.T) {
+	upsertErr := errors.New("deadlock")
+	fake := &fakeStockRepository{stocks: map[string]int{}, upsertErr: upsertErr}
+	repo, logs := newTestSlowQueryLogRepository(fake, 100*time.Millisecond, time.Second)
+
+	err := repo.UpsertStock(context.Background(), "apple", 5)
+
+	assert.ErrorIs(t, err, upsertErr, "委譲先のエラーをそのまま返すべき")
+	require.Len(t, *logs, 1)
+	assert.Contains(t, (*logs)[0], "deadlock", "エラーが出力されるべき")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stockSnapshot はSaveSnapshotが保存する、ある時点の在庫数の一覧です。
+type stockSnapshot struct {
+	TakenAt time.Time           `json:"taken_at"`
+	Stocks  []stockSnapshotItem `json:"stocks"`
+}
+
+// stockSnapshotItem はスナップショットの1商品です。
+type stockSnapshotItem struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+}
+
+// SaveSnapshot はstocksテーブルの現在の在庫数を名前順のJSONとしてpathに保存します。
+// 次回の実行でDiffAgainstSnapshotに同じpathを渡すと、その間に変わった商品を確認できます。
+// 書き込みは同じディレクトリの一時ファイルに行ってから置き換えるため、途中で失敗しても前回のスナップショットは壊れません。
+func SaveSnapshot(db *sql.DB, path string) error {
+	return SaveSnapshotContext(context.Background(), db, path)
+}
+
+// SaveSnapshotContext はSaveSnapshotのcontext対応版です。
+func SaveSnapshotContext(ctx context.Context, db *sql.DB, path string) error {
+	snapshot := stockSnapshot{TakenAt: time.Now().UTC(), Stocks: []stockSnapshotItem{}}
+	rows, err := db.QueryContext(ctx, queryStocksOrderedByName)
+	if err != nil {
+		return fmt.Errorf("在庫データの取得エラー: %w", classifyError(err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Stock
+		if err := rows.Scan(&s.ID, &s.Name, &s.Amount); err != nil {
+			return fmt.Errorf("在庫データの読み込みエラー: %w", err)
+		}
+		snapshot.Stocks = append(snapshot.Stocks, stockSnapshotItem{Name: s.Name, Amount: int(s.Amount)})
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("在庫データの読み込みエラー: %w", err)
+	}
+
+	content, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("スナップショットの作成エラー: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("スナップショットの書き込みエラー: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(content, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("スナップショットの書き込みエラー: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("スナップショットの書き込みエラー: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("スナップショットの書き込みエラー: %w", err)
+	}
+	return nil
+}
+
+// DiffAgainstSnapshot はpathに保存したスナップショットとstocksテーブルの現在の在庫数を突き合わせ、
+// 在庫数が変わった商品（DiscrepancyAmount）、削除された商品（DiscrepancyMissingInDB）と
+// 追加された商品（DiscrepancyMissingInSnapshot）を名前順に返します。Expectedはスナップショットの在庫数、Actualは現在の在庫数です。
+// 変わった商品がない場合は空のスライスを返します。スナップショットが存在しない場合はfs.ErrNotExistを含むエラーを返します。
+func DiffAgainstSnapshot(db *sql.DB, path string) ([]Discrepancy, error) {
+	return DiffAgainstSnapshotContext(context.Background(), db, path)
+}
+
+// DiffAgainstSnapshotContext はDiffAgainstSnapshotのcontext対応版です。
+func DiffAgainstSnapshotContext(ctx context.Context, db *sql.DB, path string) ([]Discrepancy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("スナップショットの読み込みエラー: %w", err)
+	}
+	var snapshot stockSnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("スナップショットの読み込みエラー: %s: %w", path, err)
+	}
+	expected := make(map[string]int, len(snapshot.Stocks))
+	for _, item := range snapshot.Stocks {
+		if _, ok := expected[item.Name]; ok {
+			return nil, fmt.Errorf("スナップショットに商品名が重複しています: %s", item.Name)
+		}
+		expected[item.Name] = item.Amount
+	}
+	return reconcileAmounts(ctx, db, expected, DiscrepancyMissingInSnapshot)
+}
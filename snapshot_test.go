@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSnapshot_RoundTrip は保存した直後のスナップショットと突き合わせても差異がないことをテストします
+func TestSnapshot_RoundTrip(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	stocks := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "amount"}).
+			AddRow(1, "apple", 100).
+			AddRow(2, "banana", 30)
+	}
+	expectOrderedStocks(mock, stocks())
+	expectOrderedStocks(mock, stocks())
+
+	require.NoError(t, SaveSnapshot(db, path))
+	discrepancies, err := DiffAgainstSnapshot(db, path)
+
+	require.NoError(t, err)
+	assert.Empty(t, discrepancies, "差異はないべき")
+	verifyExpectations(t, mock)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var saved stockSnapshot
+	require.NoError(t, json.Unmarshal(content, &saved))
+	assert.Equal(t, []stockSnapshotItem{{Name: "apple", Amount: 100}, {Name: "banana", Amount: 30}}, saved.Stocks)
+	assert.False(t, saved.TakenAt.IsZero())
+}
+
+// TestDiffAgainstSnapshot_Changes はスナップショットの保存後に変わった商品を名前順に返すことをテストします
+func TestDiffAgainstSnapshot_Changes(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	expectOrderedStocks(mock, sqlmock.NewRows([]string{"id", "name", "amount"}).
+		AddRow(1, "apple", 100).
+		AddRow(2, "banana", 30).
+		AddRow(3, "cherry", 10))
+	require.NoError(t, SaveSnapshot(db, path))
+
+	// 次の実行までにbananaが出庫され、cherryが削除され、durianが追加された
+	expectOrderedStocks(mock, sqlmock.NewRows([]string{"id", "name", "amount"}).
+		AddRow(1, "apple", 100).
+		AddRow(2, "banana", 25).
+		AddRow(4, "durian", 7))
+	discrepancies, err := DiffAgainstSnapshot(db, path)
+
+	require.NoError(t, err)
+	assert.Equal(t, []Discrepancy{
+		{Name: "banana", Kind: DiscrepancyAmount, Expected: 30, Actual: 25},
+		{Name: "cherry", Kind: DiscrepancyMissingInDB, Expected: 10},
+		{Name: "durian", Kind: DiscrepancyMissingInSnapshot, Actual: 7},
+	}, discrepancies)
+	verifyExpectations(t, mock)
+}
+
+// TestDiffAgainstSnapshot_Errors はスナップショットがない場合と壊れている場合にクエリを実行せずエラーを返すことをテストします
+func TestDiffAgainstSnapshot_Errors(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	_, err := DiffAgainstSnapshot(db, filepath.Join(dir, "missing.json"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	broken := filepath.Join(dir, "broken.json")
+	require.NoError(t, os.WriteFile(broken, []byte(`{"stocks": [`), 0o644))
+	_, err = DiffAgainstSnapshot(db, broken)
+	assert.Error(t, err)
+
+	duplicated := filepath.Join(dir, "duplicated.json")
+	require.NoError(t, os.WriteFile(duplicated, []byte(`{"stocks": [{"name": "apple", "amount": 1}, {"name": "apple", "amount": 2}]}`), 0o644))
+	_, err = DiffAgainstSnapshot(db, duplicated)
+	assert.ErrorContains(t, err, "apple")
+
+	verifyExpectations(t, mock)
+}
+
+// TestSaveSnapshot_QueryError は取得に失敗した場合に前回のスナップショットを残すことをテストします
+func TestSaveSnapshot_QueryError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"stocks": []}`), 0o644))
+	mock.ExpectQuery(sqlPattern(queryStocksOrderedByName)).WillReturnError(assert.AnError)
+
+	assert.ErrorIs(t, SaveSnapshot(db, path), assert.AnError)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"stocks": []}`, string(content))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "一時ファイルを残さないべき")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// stockAgingMigration はstocksに最終更新日時のupdated_at列と、その索引を追加するマイグレーションです。
+// 在庫数などの値が変わった行だけON UPDATEで更新されます。追加前からある行はNULLのままで、次に更新されるまで作成日時で判断します。
+const stockAgingMigration = `
+ALTER TABLE stocks
+    ADD COLUMN updated_at TIMESTAMP NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+    ADD INDEX idx_stocks_updated_at (updated_at);`
+
+// stockCreatedAtMigration はstocksに作成日時のcreated_at列と、その索引を追加するマイグレーションです。
+// updated_atは登録時にNULLのため、一度も更新されていない行はこの列で滞留期間を判断します。
+// 追加前からある行にはマイグレーションを適用した日時が入ります。
+const stockCreatedAtMigration = `
+ALTER TABLE stocks
+    ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD INDEX idx_stocks_created_at (created_at);`
+
+// stockMigrations はEnsureSchemaが適用するstocksテーブルのマイグレーションです。適用したバージョンはstock_schema_migrationsに記録します。
+var stockMigrations = map[int]string{
+	1: stockAgingMigration,
+	2: rowChecksumMigration,
+	3: categoryMigration,
+	4: stockCreatedAtMigration,
+}
+
+// 滞留在庫の最終変動日時の出どころ
+const (
+	// staleSourceUpdated は最終更新日時（updated_at）です。
+	staleSourceUpdated = "updated_at"
+	// staleSourceCreated は一度も更新されていない商品の作成日時（created_at）です。
+	staleSourceCreated = "created_at"
+	// staleSourceUnknown は日時が記録されておらず、滞留期間がわからないことを表します。
+	staleSourceUnknown = "unknown"
+)
+
+// StaleStock はolderThanより長く在庫数が動いていない商品です。
+type StaleStock struct {
+	Name   string
+	Amount int
+	// LastMovement は最後に在庫が動いた日時です。滞留期間がわからない場合はゼロ値です。
+	LastMovement time.Time
+	// Source はLastMovementをどの列から求めたかを表します。
+	Source string
+}
+
+// AgeKnown は最終変動日時がわかっている場合にtrueを返します。
+func (s StaleStock) AgeKnown() bool {
+	return s.Source != staleSourceUnknown
+}
+
+// ListStaleStocks はolderThanより長く在庫数が動いていない商品を、最終変動日時の古い順に返します。
+// 一度も更新されていない商品は作成日時（created_at列がある場合）で判断し、どちらの日時もない商品は
+// 滞留期間のわからない商品として末尾に名前順で含めます。stocksにupdated_at列がない場合はErrColumnDriftを返します。
+func ListStaleStocks(ctx context.Context, db *sql.DB, olderThan time.Duration) ([]StaleStock, error) {
+	live, err := liveStockColumns(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("列定義の確認エラー: %w", err)
+	}
+	if !live["updated_at"] {
+		return nil, fmt.Errorf("%w: 存在しない列 updated_at（init-dbでマイグレーションを適用してください）", ErrColumnDrift)
+	}
+	createdAt := "NULL"
+	if live["created_at"] {
+		createdAt = "created_at"
+	}
+
+	query := staleStocksQuery(createdAt)
+	cutoff := time.Now().Add(-olderThan)
+	var stale []StaleStock
+	err = trackOperation(ctx, db, query, func(ctx context.Context, q Queryer) error {
+		rows, err := q.QueryContext(ctx, query, cutoff, cutoff)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		stale, err = scanStaleStocks(rows)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("滞留在庫の取得エラー: %w", err)
+	}
+	return stale, nil
+}
+
+// staleStocksQuery は滞留在庫を取得するクエリを返します。createdAtは作成日時の列名、またはNULLです。
+// 更新済みの行と未更新の行を別々のSELECTで取得してUNION ALLでまとめ、どちらもidx_stocks_updated_atで絞り込めるようにします。
+func staleStocksQuery(createdAt string) string {
+	columns := "SELECT name, amount, updated_at, " + createdAt + " AS created_at FROM stocks "
+	lastMovement := "COALESCE(updated_at, created_at)"
+	return "SELECT name, amount, updated_at, created_at FROM (" +
+		columns + "WHERE updated_at < ? UNION ALL " +
+		columns + "WHERE updated_at IS NULL AND (" + createdAt + " < ? OR " + createdAt + " IS NULL)" +
+		") AS stale ORDER BY " + lastMovement + " IS NULL, " + lastMovement + ", name;"
+}
+
+// scanStaleStocks は行セットの全行をStaleStockとして読み取ります。
+func scanStaleStocks(rows *sql.Rows) ([]StaleStock, error) {
+	stale := []StaleStock{}
+	for rows.Next() {
+		var s StaleStock
+		var updatedAt, createdAt sql.NullTime
+		if err := rows.Scan(&s.Name, &s.Amount, &updatedAt, &createdAt); err != nil {
+			return nil, err
+		}
+		switch {
+		case updatedAt.Valid:
+			s.LastMovement, s.Source = updatedAt.Time, staleSourceUpdated
+		case createdAt.Valid:
+			s.LastMovement, s.Source = createdAt.Time, staleSourceCreated
+		default:
+			s.Source = staleSourceUnknown
+		}
+		stale = append(stale, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return stale, nil
+}
+
+// runStaleCommand はstaleサブコマンドを実行します。
+// 使い方: stale [--days 90] [--format table|csv]
+func runStaleCommand(ctx context.Context, w io.Writer, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("stale", flag.ContinueOnError)
+	fs.SetOutput(w)
+	days := fs.Int("days", 90, "在庫が動いていない日数")
+	format := fs.String("format", formatTable, "出力形式（table または csv）")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *days <= 0 {
+		return errors.New("--daysには1以上を指定してください")
+	}
+
+	stale, err := ListStaleStocks(ctx, db, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"name", "amount", "last_movement", "source"}
+	records := make([][]string, 0, len(stale))
+	for _, s := range stale {
+		lastMovement := ""
+		if s.AgeKnown() {
+			lastMovement = s.LastMovement.In(timeLocation).Format(reportDateTimeLayout)
+		}
+		records = append(records, []string{s.Name, strconv.Itoa(s.Amount), lastMovement, s.Source})
+	}
+	return writeRecords(w, *format, header, records)
+}
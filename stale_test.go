@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staleCutoff はolderThan前の時刻の前後1分以内の引数に一致します
+type staleCutoff struct {
+	olderThan time.Duration
+}
+
+func (c staleCutoff) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	expected := time.Now().Add(-c.olderThan)
+	return t.After(expected.Add(-time.Minute)) && t.Before(expected.Add(time.Minute))
+}
+
+func newStaleRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name", "amount", "updated_at", "created_at"})
+}
+
+// TestListStaleStocks は最終更新日時、作成日時、日時不明の3つの区分で滞留在庫を返すことをテストします
+func TestListStaleStocks(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	updated := time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC)
+	created := time.Date(2024, 12, 1, 9, 0, 0, 0, time.UTC)
+	expectLiveColumns(mock, "id", "name", "amount", "category", "created_at", "updated_at")
+	mock.ExpectQuery(regexp.QuoteMeta(staleStocksQuery("created_at"))).
+		WithArgs(staleCutoff{90 * 24 * time.Hour}, staleCutoff{90 * 24 * time.Hour}).
+		WillReturnRows(newStaleRows().
+			AddRow("banana", 5, nil, created).
+			AddRow("apple", 10, updated, created).
+			AddRow("cherry", 0, nil, nil))
+
+	stale, err := ListStaleStocks(context.Background(), db, 90*24*time.Hour)
+
+	require.NoError(t, err)
+	assert.Equal(t, []StaleStock{
+		{Name: "banana", Amount: 5, LastMovement: created, Source: staleSourceCreated},
+		{Name: "apple", Amount: 10, LastMovement: updated, Source: staleSourceUpdated},
+		{Name: "cherry", Amount: 0, Source: staleSourceUnknown},
+	}, stale)
+	assert.False(t, stale[2].AgeKnown(), "日時のない商品は滞留期間不明として返すべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStaleStocksQuery は更新済みの行と未更新の行をupdated_atで絞り込む2つのSELECTに分け、
+// updated_atを優先して日時不明の行を末尾に並べるクエリであることをテストします
+func TestStaleStocksQuery(t *testing.T) {
+	assert.Equal(t, "SELECT name, amount, updated_at, created_at FROM ("+
+		"SELECT name, amount, updated_at, created_at AS created_at FROM stocks WHERE updated_at < ? UNION ALL "+
+		"SELECT name, amount, updated_at, created_at AS created_at FROM stocks WHERE updated_at IS NULL AND (created_at < ? OR created_at IS NULL)"+
+		") AS stale ORDER BY COALESCE(updated_at, created_at) IS NULL, COALESCE(updated_at, created_at), name;",
+		staleStocksQuery("created_at"))
+	assert.Equal(t, "SELECT name, amount, updated_at, created_at FROM ("+
+		"SELECT name, amount, updated_at, NULL AS created_at FROM stocks WHERE updated_at < ? UNION ALL "+
+		"SELECT name, amount, updated_at, NULL AS created_at FROM stocks WHERE updated_at IS NULL AND (NULL < ? OR NULL IS NULL)"+
+		") AS stale ORDER BY COALESCE(updated_at, created_at) IS NULL, COALESCE(updated_at, created_at), name;",
+		staleStocksQuery("NULL"), "created_at列がなければ未更新の行はすべて日時不明になるべき")
+	assert.NotContains(t, staleStocksQuery("created_at"), " OR (updated_at IS NULL",
+		"updated_atの範囲条件をORでつなぐと索引の範囲検索が使われないため、SELECTを分けるべき")
+}
+
+// TestListStaleStocks_MissingColumn はupdated_at列がない場合にクエリを発行せずErrColumnDriftを返すことをテストします
+func TestListStaleStocks_MissingColumn(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLiveColumns(mock, "id", "name", "amount", "category", "created_at")
+
+	_, err := ListStaleStocks(context.Background(), db, time.Hour)
+
+	assert.ErrorIs(t, err, ErrColumnDrift)
+	assert.ErrorContains(t, err, "updated_at")
+	verifyExpectations(t, mock)
+}
+
+// TestStockAgingMigration はマイグレーションでupdated_at列と索引を追加することをテストします
+func TestStockAgingMigration(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS stock_schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(stockVersionRegex).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`ALTER TABLE stocks\s+ADD COLUMN updated_at TIMESTAMP NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,\s+` +
+		`ADD INDEX idx_stocks_updated_at \(updated_at\);`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO stock_schema_migrations`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, migrateTo(context.Background(), db, stockMigrationsTable, 1, stockMigrations))
+	verifyExpectations(t, mock)
+}
+
+// TestRunStaleCommand は滞留在庫を表形式で出力し、日時不明の商品は日時を空欄にすることをテストします
+func TestRunStaleCommand(t *testing.T) {
+	withTimeLocation(t, time.UTC)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectLiveColumns(mock, "id", "name", "amount", "category", "updated_at")
+	mock.ExpectQuery(regexp.QuoteMeta(staleStocksQuery("NULL"))).
+		WithArgs(staleCutoff{30 * 24 * time.Hour}, staleCutoff{30 * 24 * time.Hour}).
+		WillReturnRows(newStaleRows().
+			AddRow("apple", 10, time.Date(2025, 1, 10, 9, 0, 0, 0, time.UTC), nil).
+			AddRow("cherry", 0, nil, nil))
+
+	var buf bytes.Buffer
+	err := runStaleCommand(context.Background(), &buf, db, []string{"--days", "30", "--format", "csv"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "name,amount,last_movement,source\napple,10,2025-01-10 09:00:00,updated_at\ncherry,0,,unknown\n", buf.String())
+	verifyExpectations(t, mock)
+
+	assert.Error(t, runStaleCommand(context.Background(), &buf, db, []string{"--days", "0"}), "日数が0以下ならエラーになるべき")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"db_moc/internal/stmt"
+)
+
+// preparedStatements は起動時に事前準備するSQL文の一覧を返します。
+// StmtCache.Queryerを通して実行されるSQLStockRepositoryの読み取り（QueryStocks、QueryStocksTypedとGetStock）と、
+// withStmtCacheのctxで実行される書き込み（UpsertStock、BulkUpsertStocksと1件の削除）の文です。
+func preparedStatements() []string {
+	queries := []string{
+		queryAllStocks(),
+		queryStocksByName(),
+		stmtStockAmount.SQL,
+		stmtStockAmountForUpdate.SQL,
+		stmtUpdateAmount.SQL,
+		stmtInsertStock.SQL,
+		lockStocksSQL("?"),
+		deleteStocksSQL("?"),
+	}
+	if !categoryColumnMissing.Load() {
+		queries = append(queries, stmtUpdateAmountWithCategory.SQL, stmtInsertStockWithCategory.SQL)
+	}
+	if rowChecksumEnabled {
+		queries = append(queries, queryStockByNameWithChecksum())
+	}
+	return queries
+}
+
+// StmtCache はSQL文ごとにプリペアドステートメントを保持するキャッシュです。
+// NewCachedSQLStockRepositoryに渡すと、リポジトリの読み取りはQueryerを通して、書き込みはwithStmtCacheのctxを通して
+// キャッシュしたステートメントで実行されます。
+// 初回利用時のPrepareによるレイテンシを避けるため、PrepareAllで事前に準備できます。
+type StmtCache struct {
+	mu     sync.Mutex
+	stmts  map[string]*sql.Stmt
+	hits   int64
+	misses int64
+}
+
+// NewStmtCache は空のStmtCacheを返します。
+func NewStmtCache() *StmtCache {
+	return &StmtCache{stmts: make(map[string]*sql.Stmt)}
+}
+
+// Prepare はキャッシュ済みのステートメントを返します。未準備の場合はPrepareしてキャッシュします。
+// queryにtaggedSQLのタグが付いている場合は、タグを除いたSQL文をキーにしてPrepareします。
+// タグは最初の呼び出し元の操作を表すため、共有するステートメントには含めません。
+// Prepareの間はロックを保持せず、同じSQL文を同時に準備した場合は先にキャッシュされたものを使い、もう一方は閉じます。
+func (c *StmtCache) Prepare(ctx context.Context, db *sql.DB, query string) (*sql.Stmt, error) {
+	_, key := stmt.SplitTag(query)
+
+	c.mu.Lock()
+	if prepared, ok := c.stmts[key]; ok {
+		c.hits++
+		c.mu.Unlock()
+		return prepared, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	prepared, err := db.PrepareContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.stmts[key]; ok {
+		prepared.Close()
+		return existing, nil
+	}
+	c.stmts[key] = prepared
+	return prepared, nil
+}
+
+// PrepareAll は既知のSQL文をすべて事前にPrepareしてキャッシュします。
+// 一部の準備に失敗しても残りの準備は継続し、失敗したSQL文ごとのエラーをまとめて返します。
+// serverSidePrepareがfalseの場合（サーバー側プリペアを使わない構成）は何もしません。
+func (c *StmtCache) PrepareAll(ctx context.Context, db *sql.DB) error {
+	if !serverSidePrepare {
+		return nil
+	}
+
+	var errs []error
+	for _, query := range preparedStatements() {
+		if _, err := c.Prepare(ctx, db, query); err != nil {
+			errs = append(errs, fmt.Errorf("ステートメント準備エラー (%s): %w", query, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Queryer はキャッシュしたプリペアドステートメントでクエリを実行するQueryerを返します。
+// serverSidePrepareがfalseの場合はdbでそのまま実行します。
+func (c *StmtCache) Queryer(db *sql.DB) Queryer {
+	if !serverSidePrepare {
+		return db
+	}
+	return cachedQueryer{cache: c, db: db}
+}
+
+// cachedQueryer はStmtCacheでPrepareしたステートメントでクエリを実行するQueryerです。
+type cachedQueryer struct {
+	cache *StmtCache
+	db    *sql.DB
+}
+
+func (q cachedQueryer) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	prepared, err := q.cache.Prepare(ctx, q.db, query)
+	if err != nil {
+		return nil, err
+	}
+	return prepared.QueryContext(ctx, args...)
+}
+
+// QueryRowContext は*sql.Rowにエラーを持たせられないため、Prepareに失敗した場合はdbでそのまま実行します。
+func (q cachedQueryer) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	prepared, err := q.cache.Prepare(ctx, q.db, query)
+	if err != nil {
+		return q.db.QueryRowContext(ctx, query, args...)
+	}
+	return prepared.QueryRowContext(ctx, args...)
+}
+
+// stmtCacheKey はwithStmtCacheでctxに持たせるcachedQueryerのキーです。
+type stmtCacheKey struct{}
+
+// withStmtCache はexecTxとqueryRowTxがcacheのステートメントで実行するctxを返します。
+// serverSidePrepareがfalseの場合はctxをそのまま返します。
+func withStmtCache(ctx context.Context, cache *StmtCache, db *sql.DB) context.Context {
+	q, ok := cache.Queryer(db).(cachedQueryer)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, stmtCacheKey{}, q)
+}
+
+// withoutStmtCache はwithStmtCacheのキャッシュを使わないctxを返します。
+// 引数の数によってSQL文が変わる場合など、ステートメントをキャッシュしたくない文に使います。
+func withoutStmtCache(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(stmtCacheKey{}).(cachedQueryer); !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, stmtCacheKey{}, nil)
+}
+
+// stmtCacheQueryer はトランザクションの外の読み取りに使うQueryerを返します。
+// ctxにwithStmtCacheのキャッシュがあればキャッシュしたステートメントで、なければdbでそのまま実行します。
+func stmtCacheQueryer(ctx context.Context, db *sql.DB) Queryer {
+	if q, ok := ctx.Value(stmtCacheKey{}).(cachedQueryer); ok {
+		return q
+	}
+	return db
+}
+
+// txStmt はctxにwithStmtCacheのキャッシュがあれば、queryのステートメントをtxで使えるようにして返します。
+// キャッシュがない場合やPrepareに失敗した場合はnilを返し、呼び出し側はtxでそのまま実行します。
+func txStmt(ctx context.Context, tx *sql.Tx, query string) *sql.Stmt {
+	q, ok := ctx.Value(stmtCacheKey{}).(cachedQueryer)
+	if !ok {
+		return nil
+	}
+	prepared, err := q.cache.Prepare(ctx, q.db, query)
+	if err != nil {
+		return nil
+	}
+	return tx.StmtContext(ctx, prepared)
+}
+
+// execTx はtxでqueryを実行します。ctxにwithStmtCacheのキャッシュがあればキャッシュしたステートメントを使います。
+func execTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	prepared := txStmt(ctx, tx, query)
+	if prepared == nil {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	defer prepared.Close()
+	return prepared.ExecContext(ctx, args...)
+}
+
+// queryTx はtxでqueryを実行して行セットを返します。ctxにwithStmtCacheのキャッシュがあればキャッシュしたステートメントを使います。
+func queryTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (*sql.Rows, error) {
+	prepared := txStmt(ctx, tx, query)
+	if prepared == nil {
+		return tx.QueryContext(ctx, query, args...)
+	}
+	// txのステートメントはトランザクションの終了時に閉じられるため、行セットを読み終える前に閉じない
+	return prepared.QueryContext(ctx, args...)
+}
+
+// queryRowTx はtxでqueryを実行して1行を返します。ctxにwithStmtCacheのキャッシュがあればキャッシュしたステートメントを使います。
+func queryRowTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) *sql.Row {
+	prepared := txStmt(ctx, tx, query)
+	if prepared == nil {
+		return tx.QueryRowContext(ctx, query, args...)
+	}
+	return prepared.QueryRowContext(ctx, args...)
+}
+
+// Len はキャッシュされているステートメントの数を返します。
+func (c *StmtCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.stmts)
+}
+
+// Stats はPrepareでキャッシュが使われた回数と、使われなかった回数を返します。
+func (c *StmtCache) Stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Queries はキャッシュされているSQL文を辞書順で返します。
+func (c *StmtCache) Queries() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	queries := make([]string, 0, len(c.stmts))
+	for query := range c.stmts {
+		queries = append(queries, query)
+	}
+	sort.Strings(queries)
+	return queries
+}
+
+// Close はキャッシュしているすべてのステートメントを閉じます。
+func (c *StmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for query, prepared := range c.stmts {
+		if err := prepared.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.stmts, query)
+	}
+	return errors.Join(errs...)
+}
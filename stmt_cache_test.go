@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStmtCache_PrepareAll は既知のSQL文がすべて事前準備されることをテストします
+func TestStmtCache_PrepareAll(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	for _, query := range preparedStatements() {
+		mock.ExpectPrepare(regexp.QuoteMeta(query))
+	}
+
+	cache := NewStmtCache()
+	err := cache.PrepareAll(context.Background(), db)
+
+	assert.NoError(t, err, "すべてのステートメント準備に成功するべき")
+	assert.Equal(t, len(preparedStatements()), cache.Len(), "すべてのステートメントがキャッシュされるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStmtCache_PrepareAll_PartialFailure は一部の準備に失敗しても残りが準備されることをテストします
+func TestStmtCache_PrepareAll_PartialFailure(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	failing := queryStocksByName()
+	for _, query := range preparedStatements() {
+		expectation := mock.ExpectPrepare(regexp.QuoteMeta(query))
+		if query == failing {
+			expectation.WillReturnError(errors.New("prepare error"))
+		}
+	}
+
+	cache := NewStmtCache()
+	err := cache.PrepareAll(context.Background(), db)
+
+	if assert.Error(t, err, "準備エラーが返るべき") {
+		assert.Contains(t, err.Error(), failing, "失敗したSQL文がエラーに含まれるべき")
+		assert.Contains(t, err.Error(), "prepare error", "元のエラーが含まれるべき")
+	}
+	assert.Equal(t, len(preparedStatements())-1, cache.Len(), "失敗したもの以外はキャッシュされるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStmtCache_PrepareAll_Disabled はサーバー側プリペアが無効な場合に何もしないことをテストします
+func TestStmtCache_PrepareAll_Disabled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	original := serverSidePrepare
+	serverSidePrepare = false
+	t.Cleanup(func() { serverSidePrepare = original })
+
+	cache := NewStmtCache()
+	err := cache.PrepareAll(context.Background(), db)
+
+	assert.NoError(t, err, "無効時はエラーにならないべき")
+	assert.Equal(t, 0, cache.Len(), "無効時は何もキャッシュされないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStmtCache_Prepare はキャッシュ済みのステートメントが再利用されることをテストします
+func TestStmtCache_Prepare(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectPrepare(regexp.QuoteMeta(queryStocksByName())).WillBeClosed()
+
+	cache := NewStmtCache()
+	first, err := cache.Prepare(context.Background(), db, queryStocksByName())
+	assert.NoError(t, err, "初回の準備は成功するべき")
+	second, err := cache.Prepare(context.Background(), db, queryStocksByName())
+	assert.NoError(t, err, "2回目はキャッシュから返るべき")
+	assert.Same(t, first, second, "同じステートメントが返るべき")
+	hits, misses := cache.Stats()
+	assert.Equal(t, int64(1), hits, "2回目はキャッシュヒットとして数えるべき")
+	assert.Equal(t, int64(1), misses, "初回はキャッシュミスとして数えるべき")
+	assert.Equal(t, []string{queryStocksByName()}, cache.Queries())
+
+	assert.NoError(t, cache.Close(), "Closeは成功するべき")
+	assert.Equal(t, 0, cache.Len(), "Close後はキャッシュが空になるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestCachedSQLStockRepository はリポジトリの読み取りがキャッシュしたステートメントで実行され、2回目はPrepareしないことをテストします
+func TestCachedSQLStockRepository(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	query := regexp.QuoteMeta(queryStocksByName())
+	prepared := mock.ExpectPrepare(query)
+	prepared.ExpectQuery().WithArgs("apple").WillReturnRows(listingRows(100))
+	mock.ExpectQuery(query).WithArgs("apple").WillReturnRows(listingRows(120))
+
+	cache := NewStmtCache()
+	repo := NewCachedSQLStockRepository(db, cache)
+	first, err := repo.QueryStocksTyped(context.Background(), "apple")
+	assert.NoError(t, err)
+	second, err := repo.QueryStocksTyped(context.Background(), "apple")
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(100), first[0].Amount)
+	assert.Equal(t, int64(120), second[0].Amount)
+	hits, misses := cache.Stats()
+	assert.Equal(t, int64(1), hits, "2回目はキャッシュしたステートメントを使うべき")
+	assert.Equal(t, int64(1), misses)
+	verifyExpectations(t, mock)
+}
+
+// TestCachedSQLStockRepository_ServerSidePrepareDisabled はサーバー側プリペアが無効な場合にPrepareせずに実行することをテストします
+func TestCachedSQLStockRepository_ServerSidePrepareDisabled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	original := serverSidePrepare
+	serverSidePrepare = false
+	t.Cleanup(func() { serverSidePrepare = original })
+
+	mock.ExpectQuery(regexp.QuoteMeta(queryStocksByName())).WithArgs("apple").WillReturnRows(listingRows(100))
+
+	cache := NewStmtCache()
+	_, err := NewCachedSQLStockRepository(db, cache).QueryStocksTyped(context.Background(), "apple")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cache.Len(), "無効時はキャッシュしないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestCachedSQLStockRepository_UpsertStock はリポジトリの書き込みがキャッシュしたステートメントで実行され、2回目はPrepareしないことをテストします
+func TestCachedSQLStockRepository_UpsertStock(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	amount := regexp.QuoteMeta(stmtStockAmount.SQL)
+	update := regexp.QuoteMeta(stmtUpdateAmount.SQL)
+	mock.ExpectPrepare(amount).ExpectQuery().WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	// トランザクションが使用中の接続とは別の接続でPrepareし、トランザクションの接続でも準備される
+	mock.ExpectPrepare(update)
+	mock.ExpectPrepare(update).ExpectExec().WithArgs(110, "apple").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(amount).WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(110))
+	mock.ExpectBegin()
+	mock.ExpectExec(update).WithArgs(120, "apple").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	cache := NewStmtCache()
+	repo := NewCachedSQLStockRepository(db, cache)
+	assert.NoError(t, repo.UpsertStock(context.Background(), "apple", 10))
+	assert.NoError(t, repo.UpsertStock(context.Background(), "apple", 10))
+
+	assert.Equal(t, []string{stmtStockAmount.SQL, stmtUpdateAmount.SQL}, cache.Queries())
+	hits, misses := cache.Stats()
+	assert.Equal(t, int64(2), hits, "2回目の読み取りと更新はキャッシュしたステートメントを使うべき")
+	assert.Equal(t, int64(2), misses)
+	verifyExpectations(t, mock)
+}
+
+// TestDeleteStocksTx_MultipleNamesNotCached は商品名の数ごとに変わる削除の文をキャッシュしないことをテストします
+func TestDeleteStocksTx_MultipleNamesNotCached(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(lockStocksSQL("?, ?"))).WithArgs("apple", "pear").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "amount"}))
+	mock.ExpectExec(regexp.QuoteMeta(deleteStocksSQL("?, ?"))).WithArgs("apple", "pear").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	cache := NewStmtCache()
+	_, err := NewCachedSQLStockRepository(db, cache).DeleteStocksByNames(context.Background(), []string{"apple", "pear"}, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cache.Len(), "複数の商品名の削除はキャッシュしないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStmtCache_Prepare_Concurrent はPrepareの間ロックを保持せず、同時に準備した場合は1つだけをキャッシュすることをテストします
+func TestStmtCache_Prepare_Concurrent(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < 2; i++ {
+		mock.ExpectPrepare(regexp.QuoteMeta(queryStocksByName())).WillDelayFor(200 * time.Millisecond).WillBeClosed()
+	}
+
+	cache := NewStmtCache()
+	var wg sync.WaitGroup
+	stmts := make([]*sql.Stmt, 2)
+	for i := range stmts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			prepared, err := cache.Prepare(context.Background(), db, queryStocksByName())
+			assert.NoError(t, err)
+			stmts[i] = prepared
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	assert.Equal(t, 0, cache.Len(), "準備中のステートメントはまだキャッシュされないべき")
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "Prepareの間はロックを保持しないべき")
+	wg.Wait()
+
+	assert.Same(t, stmts[0], stmts[1], "同時に準備した場合も同じステートメントを返すべき")
+	assert.Equal(t, 1, cache.Len())
+	assert.NoError(t, cache.Close(), "キャッシュしなかったステートメントは閉じられているべき")
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Stock はstocksテーブルの1行を表す型です。
+type Stock struct {
+	ID       int64
+	Name     string
+	Amount   int64
+	Category string
+}
+
+// NullableStock はamountがNULLになり得る行を表す型です。
+// amountが存在するかどうかはHasAmountで確認できます。
+type NullableStock struct {
+	ID     int64
+	Name   string
+	Amount sql.NullInt64
+}
+
+// HasAmount はamountがNULLでない場合にtrueを返します。
+func (s NullableStock) HasAmount() bool {
+	return s.Amount.Valid
+}
+
+// QueryStocksTyped はQueryStocksと同じクエリを実行し、結果をStockのスライスで返します。
+// 列は位置ではなく列名で対応付けるため、未知の列は読み捨てられます。
+func QueryStocksTyped(db *sql.DB, name string) ([]Stock, error) {
+	return QueryStocksTypedContext(context.Background(), db, name)
+}
+
+// QueryStocksTypedContext はQueryStocksTypedのcontext対応版です。
+func QueryStocksTypedContext(ctx context.Context, q Queryer, name string) ([]Stock, error) {
+	rows, obs, err := queryStocksRows(ctx, q, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanStocks(rows)
+	obs.done(len(results), err)
+	return results, err
+}
+
+// QueryStocksByCategory は指定したカテゴリの在庫データを名前順で返します。
+// categoryが空の場合はdefaultCategoryの商品を返します。CheckStockColumnsがcategory列がないと確認している場合はErrColumnDriftを返します。
+func QueryStocksByCategory(db *sql.DB, category string) ([]Stock, error) {
+	return QueryStocksByCategoryContext(context.Background(), db, category)
+}
+
+// QueryStocksByCategoryContext はQueryStocksByCategoryのcontext対応版です。
+func QueryStocksByCategoryContext(ctx context.Context, q Queryer, category string) ([]Stock, error) {
+	if err := requireCategoryColumn(); err != nil {
+		return nil, err
+	}
+	if category == "" {
+		category = defaultCategory
+	}
+	query := "SELECT " + stockSelectList() + " FROM stocks WHERE category = ? ORDER BY name;"
+	obs := observeQuery(query)
+	rows, err := q.QueryContext(ctx, query, category)
+	if err != nil {
+		obs.done(0, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanStocks(rows)
+	obs.done(len(results), err)
+	return results, err
+}
+
+// GetStock は指定した商品の行を返します。該当する行がない場合はsql.ErrNoRowsを返します。
+// 複数の商品を読み取る場合は、1件ずつ呼び出さずにGetStocksByNamesを使ってください。
+func GetStock(db *sql.DB, name string) (Stock, error) {
+	return GetStockContext(context.Background(), db, name)
+}
+
+// GetStockContext はGetStockのcontext対応版です。
+// ctxに操作IDが設定されていれば、nPlusOneDetectorで同じ形のクエリの繰り返しを検出します。
+// rowChecksumEnabledが有効な場合は行のチェックサムを検証し、一致しなければErrRowCorruptedを返します。
+func GetStockContext(ctx context.Context, q Queryer, name string) (Stock, error) {
+	if rowChecksumEnabled {
+		nPlusOneDetector.Observe(ctx, queryStockByNameWithChecksum(), name)
+		return getStockVerified(ctx, q, name)
+	}
+	query := queryStocksByName()
+	nPlusOneDetector.Observe(ctx, query, name)
+	return queryOneStock(ctx, q, "GetStock", query, name)
+}
+
+// GetStocksByNames は指定した商品の行を1回のクエリで名前順に返します。存在しない商品は結果に含まれません。
+func GetStocksByNames(db *sql.DB, names []string) ([]Stock, error) {
+	return GetStocksByNamesContext(context.Background(), db, names)
+}
+
+// GetStocksByNamesContext はGetStocksByNamesのcontext対応版です。
+func GetStocksByNamesContext(ctx context.Context, q Queryer, names []string) ([]Stock, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+
+	query := "SELECT " + stockSelectList() + " FROM stocks WHERE name IN (" + placeholders + ") ORDER BY name;"
+	obs := observeQuery(query)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		obs.done(0, err)
+		return nil, classifyError(err)
+	}
+	defer rows.Close()
+
+	results, err := scanStocks(rows)
+	obs.done(len(results), err)
+	return results, err
+}
+
+// OldestStock はcreated_atが最も古い（最初に登録された）商品の行を返します。
+// created_at列はEnsureSchemaがstockCreatedAtMigrationで追加します。在庫データが1件もない場合はErrNoStocksを返します。
+func OldestStock(db *sql.DB) (Stock, error) {
+	return OldestStockContext(context.Background(), db)
+}
+
+// OldestStockContext はOldestStockのcontext対応版です。
+func OldestStockContext(ctx context.Context, db *sql.DB) (Stock, error) {
+	return queryEdgeStock(ctx, db, "OldestStock", "ORDER BY created_at, id")
+}
+
+// NewestStock はcreated_atが最も新しい（最後に登録された）商品の行を返します。
+// created_at列はEnsureSchemaがstockCreatedAtMigrationで追加します。在庫データが1件もない場合はErrNoStocksを返します。
+func NewestStock(db *sql.DB) (Stock, error) {
+	return NewestStockContext(context.Background(), db)
+}
+
+// NewestStockContext はNewestStockのcontext対応版です。
+func NewestStockContext(ctx context.Context, db *sql.DB) (Stock, error) {
+	return queryEdgeStock(ctx, db, "NewestStock", "ORDER BY created_at DESC, id DESC")
+}
+
+// queryEdgeStock はorderByで並べた先頭の1行を返します。created_atが同じ場合はidで順序を決めます。
+// created_atの索引（idx_stocks_created_at）により、全行を並べ替えずに先頭の1行を読み取ります。
+func queryEdgeStock(ctx context.Context, db *sql.DB, op, orderBy string) (Stock, error) {
+	stock, err := queryOneStock(ctx, db, op, "SELECT "+stockSelectList()+" FROM stocks "+orderBy+" LIMIT 1;")
+	if errors.Is(err, sql.ErrNoRows) {
+		return Stock{}, ErrNoStocks
+	}
+	return stock, err
+}
+
+// GetStockForShare は呼び出し側のトランザクション内で、指定した商品の行を共有ロックを取得して読み取ります。
+// FOR UPDATEと異なり他の読み取りはブロックせず、トランザクションが終わるまで行の更新だけを待たせます。
+// MySQL 5.7でも使えるようLOCK IN SHARE MODEを使います。該当する行がない場合はsql.ErrNoRowsを返します。
+func GetStockForShare(ctx context.Context, tx *sql.Tx, name string) (Stock, error) {
+	return queryOneStock(ctx, tx, "GetStockForShare", "SELECT "+stockSelectList()+" FROM stocks WHERE name = ? LOCK IN SHARE MODE;", name)
+}
+
+// queryOneStock は1行を返すクエリを実行します。該当する行がない場合はsql.ErrNoRowsを返します。
+func queryOneStock(ctx context.Context, q Queryer, op, query string, args ...interface{}) (Stock, error) {
+	obs := observeQuery(query)
+	rows, err := q.QueryContext(ctx, taggedSQL(ctx, op, query), args...)
+	if err != nil {
+		obs.done(0, err)
+		return Stock{}, classifyError(err)
+	}
+	defer rows.Close()
+
+	results, err := scanStocks(rows)
+	obs.done(len(results), err)
+	if err != nil {
+		return Stock{}, err
+	}
+	if len(results) == 0 {
+		return Stock{}, sql.ErrNoRows
+	}
+	return results[0], nil
+}
+
+// QueryStocksNullable はQueryStocksTypedと同様ですが、NULLのamountを保持したまま返します。
+func QueryStocksNullable(db *sql.DB, name string) ([]NullableStock, error) {
+	return QueryStocksNullableContext(context.Background(), db, name)
+}
+
+// QueryStocksNullableContext はQueryStocksNullableのcontext対応版です。
+func QueryStocksNullableContext(ctx context.Context, q Queryer, name string) ([]NullableStock, error) {
+	rows, obs, err := queryStocksRows(ctx, q, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results, err := scanNullableStocks(rows)
+	obs.done(len(results), err)
+	return results, err
+}
+
+// scanNullableStocks は行セットの全行をNullableStockとして読み取ります。
+func scanNullableStocks(rows *sql.Rows) ([]NullableStock, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []NullableStock{}
+	for rows.Next() {
+		var s NullableStock
+		dest := scanDestinations(columns, map[string]interface{}{
+			"id":     &s.ID,
+			"name":   &s.Name,
+			"amount": &s.Amount,
+		})
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		results = append(results, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// scanStocks は行セットの全行をStockとして読み取ります。
+func scanStocks(rows *sql.Rows) ([]Stock, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Stock{}
+	for rows.Next() {
+		s, err := scanStock(rows, columns)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// scanStock は行セットの現在の行を、列名で各フィールドに対応付けてStockとして読み取ります。
+// nameとcategoryのNULLはsql.NullStringで受け取り、空文字列にします。
+func scanStock(rows *sql.Rows, columns []string) (Stock, error) {
+	var s Stock
+	var name, category sql.NullString
+	err := rows.Scan(scanDestinations(columns, map[string]interface{}{
+		"id":       &s.ID,
+		"name":     &name,
+		"amount":   &s.Amount,
+		"category": &category,
+	})...)
+	s.Name, s.Category = name.String, category.String
+	return s, err
+}
+
+// scanDestinations は列名に対応するScan先のポインタを並べて返します。
+// targetsに存在しない列は読み捨て用の変数に割り当てます。
+func scanDestinations(columns []string, targets map[string]interface{}) []interface{} {
+	dest := make([]interface{}, len(columns))
+	for i, col := range columns {
+		if target, ok := targets[col]; ok {
+			dest[i] = target
+		} else {
+			dest[i] = new(interface{})
+		}
+	}
+	return dest
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// StockPB はprotobufのメッセージに対応付けやすい形の在庫データです。
+// フィールドはスカラー型のみで、名前はprotoのフィールド（id, name, amount, category）から生成されるGoの名前に合わせています。
+// 生成コードには依存しないため、gRPC層でこの型から生成されたメッセージへ詰め替えます。
+type StockPB struct {
+	Id       int64
+	Name     string
+	Amount   int64
+	Category string
+}
+
+// toStockPB はStockをStockPBに変換します。
+func toStockPB(s Stock) StockPB {
+	return StockPB{
+		Id:       s.ID,
+		Name:     s.Name,
+		Amount:   s.Amount,
+		Category: s.Category,
+	}
+}
+
+// QueryStocksPB はQueryStocksTypedと同じクエリを実行し、結果をStockPBのスライスで返します。
+func QueryStocksPB(db *sql.DB, name string) ([]StockPB, error) {
+	return QueryStocksPBContext(context.Background(), db, name)
+}
+
+// QueryStocksPBContext はQueryStocksPBのcontext対応版です。
+func QueryStocksPBContext(ctx context.Context, db *sql.DB, name string) ([]StockPB, error) {
+	stocks, err := QueryStocksTypedContext(ctx, db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]StockPB, 0, len(stocks))
+	for _, s := range stocks {
+		results = append(results, toStockPB(s))
+	}
+	return results, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryStocksPB(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks;`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+			AddRow(1, "apple", 100, "fruit").
+			AddRow(2, "banana", 50, defaultCategory))
+
+	results, err := QueryStocksPB(db, "")
+
+	assert.NoError(t, err, "エラーが発生すべきでない")
+	assert.Equal(t, []StockPB{
+		{Id: 1, Name: "apple", Amount: 100, Category: "fruit"},
+		{Id: 2, Name: "banana", Amount: 50, Category: defaultCategory},
+	}, results, "行の値がそのままStockPBに対応付けられるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestQueryStocksPB_Empty は該当なしの場合にnilではなく空のスライスを返すことをテストします
+func TestQueryStocksPB_Empty(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("ghost").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}))
+
+	results, err := QueryStocksPB(db, "ghost")
+
+	assert.NoError(t, err)
+	assert.NotNil(t, results, "空のスライスを返すべき")
+	assert.Empty(t, results)
+	verifyExpectations(t, mock)
+}
+
+func TestQueryStocksPB_Error(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks;`).
+		WillReturnError(errors.New("query failed"))
+
+	results, err := QueryStocksPB(db, "")
+
+	assert.Error(t, err)
+	assert.Nil(t, results)
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryStocksTyped(t *testing.T) {
+	tests := []struct {
+		name           string
+		queryArg       string
+		mockRows       *sqlmock.Rows
+		mockQueryRegex string
+		expectedResult []Stock
+	}{
+		{
+			name:           "appleが1件返る場合",
+			queryArg:       "apple",
+			mockRows:       sqlmock.NewRows([]string{"id", "name", "amount"}).AddRow(1, "apple", 100),
+			mockQueryRegex: "SELECT id, name, amount, category FROM stocks WHERE name = \\?;",
+			expectedResult: []Stock{{ID: 1, Name: "apple", Amount: 100}},
+		},
+		{
+			name:     "空の名前で全件表示",
+			queryArg: "",
+			mockRows: sqlmock.NewRows([]string{"id", "name", "amount"}).
+				AddRow(1, "apple", 100).
+				AddRow(2, "banana", 50),
+			mockQueryRegex: "SELECT id, name, amount, category FROM stocks;",
+			expectedResult: []Stock{
+				{ID: 1, Name: "apple", Amount: 100},
+				{ID: 2, Name: "banana", Amount: 50},
+			},
+		},
+		{
+			name:     "列順が異なり未知の列を含む場合",
+			queryArg: "apple",
+			mockRows: sqlmock.NewRows([]string{"amount", "description", "name", "id"}).
+				AddRow(100, "red fruit", "apple", 1),
+			mockQueryRegex: "SELECT id, name, amount, category FROM stocks WHERE name = \\?;",
+			expectedResult: []Stock{{ID: 1, Name: "apple", Amount: 100}},
+		},
+		{
+			name:     "nameとcategoryがNULLの行",
+			queryArg: "",
+			mockRows: sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+				AddRow(1, nil, 100, nil).
+				AddRow(2, "banana", 50, "fruit"),
+			mockQueryRegex: "SELECT id, name, amount, category FROM stocks;",
+			expectedResult: []Stock{
+				{ID: 1, Name: "", Amount: 100},
+				{ID: 2, Name: "banana", Amount: 50, Category: "fruit"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			expectation := mock.ExpectQuery(tc.mockQueryRegex)
+			if tc.queryArg != "" {
+				expectation = expectation.WithArgs(tc.queryArg)
+			}
+			expectation.WillReturnRows(tc.mockRows)
+
+			results, err := QueryStocksTyped(db, tc.queryArg)
+
+			assert.NoError(t, err, "エラーが発生すべきでない")
+			assert.Equal(t, tc.expectedResult, results, "結果が期待通りであるべき")
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestQueryStocksTyped_Error はクエリエラーがそのまま返ることをテストします
+func TestQueryStocksTyped_Error(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks WHERE name = \\?;").
+		WithArgs("apple").
+		WillReturnError(errors.New("query error"))
+
+	results, err := QueryStocksTyped(db, "apple")
+
+	assert.Error(t, err, "エラーが発生するべき")
+	assert.Nil(t, results, "エラー時は結果がnilであるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestQueryStocksNullable_NullAmount はamountがNULLの行を保持できることをテストします
+func TestQueryStocksNullable_NullAmount(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks;").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).
+			AddRow(1, "apple", 100).
+			AddRow(2, "banana", nil))
+
+	results, err := QueryStocksNullable(db, "")
+
+	assert.NoError(t, err, "NULLを含んでもエラーにならないべき")
+	if assert.Len(t, results, 2, "2件返るべき") {
+		assert.True(t, results[0].HasAmount(), "appleのamountは存在するべき")
+		assert.Equal(t, int64(100), results[0].Amount.Int64, "appleのamountが正しいべき")
+		assert.Equal(t, "banana", results[1].Name, "bananaの名前が正しいべき")
+		assert.False(t, results[1].HasAmount(), "bananaのamountはNULLであるべき")
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestQueryStocksTyped_NullAmount は既定の型付きAPIがNULLのamountをエラーにすることをテストします
+func TestQueryStocksTyped_NullAmount(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, amount, category FROM stocks;").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount"}).
+			AddRow(2, "banana", nil))
+
+	_, err := QueryStocksTyped(db, "")
+
+	assert.Error(t, err, "NULLのamountはint64に変換できずエラーになるべき")
+	verifyExpectations(t, mock)
+}
+
+func TestQueryStocksByCategory(t *testing.T) {
+	tests := []struct {
+		name             string
+		category         string
+		expectedCategory string
+		rows             *sqlmock.Rows
+		expected         []Stock
+	}{
+		{
+			name:             "指定カテゴリの商品",
+			category:         "fruit",
+			expectedCategory: "fruit",
+			rows: sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+				AddRow(1, "apple", 100, "fruit").
+				AddRow(2, "banana", 50, "fruit"),
+			expected: []Stock{
+				{ID: 1, Name: "apple", Amount: 100, Category: "fruit"},
+				{ID: 2, Name: "banana", Amount: 50, Category: "fruit"},
+			},
+		},
+		{
+			name:             "カテゴリ未指定は既定カテゴリ",
+			category:         "",
+			expectedCategory: defaultCategory,
+			rows: sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+				AddRow(3, "misc", 5, defaultCategory),
+			expected: []Stock{{ID: 3, Name: "misc", Amount: 5, Category: defaultCategory}},
+		},
+		{
+			name:             "該当なし",
+			category:         "vegetable",
+			expectedCategory: "vegetable",
+			rows:             sqlmock.NewRows([]string{"id", "name", "amount", "category"}),
+			expected:         []Stock{},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE category = \? ORDER BY name;`).
+				WithArgs(tc.expectedCategory).
+				WillReturnRows(tc.rows)
+
+			results, err := QueryStocksByCategory(db, tc.category)
+
+			assert.NoError(t, err, "エラーが発生すべきでない")
+			assert.Equal(t, tc.expected, results, "結果が期待通りであるべき")
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestGetStockForShare は呼び出し側のトランザクション内で共有ロック付きのSELECTが発行されることをテストします
+func TestGetStockForShare(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \? LOCK IN SHARE MODE;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "apple", 100, "fruit"))
+	mock.ExpectCommit()
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	stock, err := GetStockForShare(context.Background(), tx, "apple")
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	assert.Equal(t, Stock{ID: 1, Name: "apple", Amount: 100, Category: "fruit"}, stock)
+	verifyExpectations(t, mock)
+}
+
+// TestGetStockForShare_NotFound は該当する行がない場合にsql.ErrNoRowsを返すことをテストします
+func TestGetStockForShare_NotFound(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \? LOCK IN SHARE MODE;`).
+		WithArgs("ghost").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}))
+	mock.ExpectRollback()
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	_, err = GetStockForShare(context.Background(), tx, "ghost")
+	assert.NoError(t, tx.Rollback())
+
+	assert.True(t, errors.Is(err, sql.ErrNoRows), "sql.ErrNoRowsを返すべき: %v", err)
+	verifyExpectations(t, mock)
+}
+
+// TestGetStock は指定した商品の行を返すことをテストします
+func TestGetStock(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).AddRow(1, "apple", 100, "fruit"))
+
+	stock, err := GetStock(db, "apple")
+
+	assert.NoError(t, err)
+	assert.Equal(t, Stock{ID: 1, Name: "apple", Amount: 100, Category: "fruit"}, stock)
+	verifyExpectations(t, mock)
+}
+
+// TestGetStock_NotFound は該当する行がない場合にsql.ErrNoRowsを返すことをテストします
+func TestGetStock_NotFound(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name = \?;`).
+		WithArgs("ghost").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}))
+
+	_, err := GetStock(db, "ghost")
+
+	assert.True(t, errors.Is(err, sql.ErrNoRows), "sql.ErrNoRowsを返すべき: %v", err)
+	verifyExpectations(t, mock)
+}
+
+// TestGetStocksByNames は複数の商品を1回のクエリで取得することをテストします
+func TestGetStocksByNames(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks WHERE name IN \(\?, \?, \?\) ORDER BY name;`).
+		WithArgs("banana", "apple", "ghost").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+			AddRow(1, "apple", 100, "fruit").
+			AddRow(2, "banana", 50, "fruit"))
+
+	stocks, err := GetStocksByNames(db, []string{"banana", "apple", "ghost"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Stock{
+		{ID: 1, Name: "apple", Amount: 100, Category: "fruit"},
+		{ID: 2, Name: "banana", Amount: 50, Category: "fruit"},
+	}, stocks)
+	verifyExpectations(t, mock)
+}
+
+// TestGetStocksByNames_Empty は商品名が空の場合にクエリを実行しないことをテストします
+func TestGetStocksByNames_Empty(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	stocks, err := GetStocksByNames(db, nil)
+
+	assert.NoError(t, err)
+	assert.Empty(t, stocks)
+	verifyExpectations(t, mock)
+}
+
+// TestOldestAndNewestStock はcreated_atの順で最初と最後に登録された商品を返すことをテストします
+func TestOldestAndNewestStock(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		fn       func(db *sql.DB) (Stock, error)
+		expected Stock
+	}{
+		{
+			name:     "最も古い商品",
+			query:    `SELECT id, name, amount, category FROM stocks ORDER BY created_at, id LIMIT 1;`,
+			fn:       OldestStock,
+			expected: Stock{ID: 1, Name: "apple", Amount: 100, Category: "fruit"},
+		},
+		{
+			name:     "最も新しい商品",
+			query:    `SELECT id, name, amount, category FROM stocks ORDER BY created_at DESC, id DESC LIMIT 1;`,
+			fn:       NewestStock,
+			expected: Stock{ID: 9, Name: "cherry", Amount: 5, Category: "fruit"},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectQuery(regexp.QuoteMeta(tc.query)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+					AddRow(tc.expected.ID, tc.expected.Name, tc.expected.Amount, tc.expected.Category))
+
+			stock, err := tc.fn(db)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, stock)
+			verifyExpectations(t, mock)
+		})
+	}
+}
+
+// TestOldestAndNewestStock_CreatedAtMigration は並べ替えに使うcreated_at列と索引をEnsureSchemaが追加することをテストします
+func TestOldestAndNewestStock_CreatedAtMigration(t *testing.T) {
+	var migration string
+	for _, m := range stockMigrations {
+		if strings.Contains(m, "ADD COLUMN created_at") {
+			migration = m
+		}
+	}
+	assert.NotEmpty(t, migration, "created_at列を追加するマイグレーションがstockMigrationsに登録されるべき")
+	assert.Contains(t, migration, "ADD INDEX idx_stocks_created_at (created_at)", "並べ替え用の索引が追加されるべき")
+}
+
+// TestOldestAndNewestStock_Empty は在庫データが1件もない場合にErrNoStocksを返すことをテストします
+func TestOldestAndNewestStock_Empty(t *testing.T) {
+	for name, fn := range map[string]func(db *sql.DB) (Stock, error){"OldestStock": OldestStock, "NewestStock": NewestStock} {
+		fn := fn
+		t.Run(name, func(t *testing.T) {
+			db, mock, _ := setupMockDB(t)
+			defer db.Close()
+
+			mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks ORDER BY created_at`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "amount", "category"}))
+
+			_, err := fn(db)
+
+			assert.True(t, errors.Is(err, ErrNoStocks), "ErrNoStocksを返すべき: %v", err)
+			verifyExpectations(t, mock)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+)
+
+// StreamStocksWithAck はQueryStocksと同じクエリを実行し、結果を1行ずつsendに渡します。
+// sendは受け手が行を受け取るまで戻らないことで流量を制御でき、エラーを返すと読み取りを止めてそのエラーを返します。
+// 行と行の間でctxを確認し、キャンセルや期限切れの場合はctx.Err()を返します。
+// 全件をメモリに載せないため、サーバーストリーミングのRPCで結果を順に送る用途に使います。
+func StreamStocksWithAck(ctx context.Context, q Queryer, name string, send func(Stock) error) error {
+	rows, obs, err := queryStocksRows(ctx, q, name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		obs.done(0, err)
+		return err
+	}
+
+	sent := 0
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			obs.done(sent, err)
+			return err
+		}
+		s, err := scanStock(rows, columns)
+		if err != nil {
+			obs.done(sent, err)
+			return err
+		}
+		if err := send(s); err != nil {
+			obs.done(sent, nil)
+			return err
+		}
+		sent++
+	}
+	err = rows.Err()
+	obs.done(sent, err)
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// streamRows は3件の在庫データの行を返します
+func streamRows() *sqlmock.Rows {
+	return newStockRows(
+		Stock{ID: 1, Name: "apple", Amount: 100, Category: defaultCategory},
+		Stock{ID: 2, Name: "banana", Amount: 50, Category: defaultCategory},
+		Stock{ID: 3, Name: "cherry", Amount: 10, Category: defaultCategory},
+	)
+}
+
+// TestStreamStocksWithAck は全件が順にsendに渡されることをテストします
+func TestStreamStocksWithAck(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mock.ExpectQuery(regexp.QuoteMeta(queryAllStocks())).WillReturnRows(streamRows())
+
+	var names []string
+	err := StreamStocksWithAck(context.Background(), db, "", func(s Stock) error {
+		names = append(names, s.Name)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apple", "banana", "cherry"}, names)
+	verifyExpectations(t, mock)
+}
+
+// TestStreamStocksWithAck_SendError はsendがエラーを返すと読み取りを止め、そのエラーを返すことをテストします
+func TestStreamStocksWithAck_SendError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mock.ExpectQuery(regexp.QuoteMeta(queryAllStocks())).WillReturnRows(streamRows()).RowsWillBeClosed()
+
+	errStop := errors.New("stream closed by client")
+	var names []string
+	err := StreamStocksWithAck(context.Background(), db, "", func(s Stock) error {
+		names = append(names, s.Name)
+		if s.Name == "banana" {
+			return errStop
+		}
+		return nil
+	})
+
+	assert.ErrorIs(t, err, errStop, "sendのエラーがそのまま返るべき")
+	assert.Equal(t, []string{"apple", "banana"}, names, "sendがエラーを返した後の行は渡さないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStreamStocksWithAck_ContextCancelled は行の間でキャンセルを確認し、それ以降の行を渡さないことをテストします
+func TestStreamStocksWithAck_ContextCancelled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+	mock.ExpectQuery(regexp.QuoteMeta(queryAllStocks())).WillReturnRows(streamRows())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var names []string
+	err := StreamStocksWithAck(ctx, db, "", func(s Stock) error {
+		names = append(names, s.Name)
+		// 受け手が1件目を受け取った後に切断した
+		cancel()
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"apple"}, names, "キャンセル後の行は渡さないべき")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// summaryTopN はサマリーレポートに載せる在庫数上位の商品の数です。
+const summaryTopN = 5
+
+// SummaryReport は商品数、在庫数の合計、在庫切れの商品数、在庫数の上位商品をテキストでwに書き出します。
+// 日次のメールなど、人が読むための要約に使います。
+func SummaryReport(db *sql.DB, w io.Writer) error {
+	return SummaryReportContext(context.Background(), db, w)
+}
+
+// SummaryReportContext はSummaryReportのcontext対応版です。
+func SummaryReportContext(ctx context.Context, db *sql.DB, w io.Writer) error {
+	products, outOfStock, err := CountStocksContext(ctx, db)
+	if err != nil {
+		return fmt.Errorf("商品数の集計エラー: %w", classifyError(err))
+	}
+	total, err := TotalStockAmountContext(ctx, db)
+	if err != nil {
+		return fmt.Errorf("在庫数の集計エラー: %w", classifyError(err))
+	}
+	top, err := TopStocksContext(ctx, db, summaryTopN)
+	if err != nil {
+		return fmt.Errorf("上位商品の取得エラー: %w", classifyError(err))
+	}
+
+	fmt.Fprintln(w, "在庫サマリー")
+	fmt.Fprintf(w, "商品数: %d\n", products)
+	fmt.Fprintf(w, "在庫数の合計: %d\n", total)
+	fmt.Fprintf(w, "在庫切れの商品数: %d\n", outOfStock)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "在庫数の上位%d商品:\n", summaryTopN)
+	if len(top) == 0 {
+		_, err := fmt.Fprintln(w, "（在庫データがありません）")
+		return err
+	}
+
+	records := make([][]string, 0, len(top))
+	for i, s := range top {
+		records = append(records, []string{strconv.Itoa(i + 1), s.Name, strconv.FormatInt(s.Amount, 10)})
+	}
+	return writeTable(w, []string{"順位", "商品名", "在庫数"}, records)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectSummaryQueries はSummaryReportが発行する集計クエリのモックを設定します
+func expectSummaryQueries(mock sqlmock.Sqlmock, products, outOfStock, total int, top *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT COUNT\(\*\), COALESCE\(SUM\(amount <= 0\), 0\) FROM stocks;`).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "zero"}).AddRow(products, outOfStock))
+	mock.ExpectQuery(sqlPattern(SQLTotalAmount)).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(total))
+	mock.ExpectQuery(`SELECT id, name, amount, category FROM stocks ORDER BY amount DESC, name LIMIT \?;`).
+		WithArgs(summaryTopN).
+		WillReturnRows(top)
+}
+
+func TestSummaryReport(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	top := sqlmock.NewRows([]string{"id", "name", "amount", "category"}).
+		AddRow(3, "cherry", 500, "fruit").
+		AddRow(1, "apple", 200, "fruit").
+		AddRow(2, "banana", 50, "fruit")
+	expectSummaryQueries(mock, 4, 1, 750, top)
+
+	var buf bytes.Buffer
+	err := SummaryReport(db, &buf)
+
+	assert.NoError(t, err)
+	output := buf.String()
+	for _, expected := range []string{
+		"商品数: 4",
+		"在庫数の合計: 750",
+		"在庫切れの商品数: 1",
+		"在庫数の上位5商品:",
+	} {
+		assert.Contains(t, output, expected)
+	}
+	assert.Regexp(t, `1\s+cherry\s+500\n2\s+apple\s+200\n3\s+banana\s+50\n`, output, "上位商品が在庫数の多い順に並ぶべき")
+	verifyExpectations(t, mock)
+}
+
+// TestSummaryReport_Empty は在庫データがない場合もレポートを出力することをテストします
+func TestSummaryReport_Empty(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectSummaryQueries(mock, 0, 0, 0, sqlmock.NewRows([]string{"id", "name", "amount", "category"}))
+
+	var buf bytes.Buffer
+	err := SummaryReport(db, &buf)
+
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "商品数: 0")
+	assert.Contains(t, buf.String(), "在庫データがありません")
+	verifyExpectations(t, mock)
+}
+
+// TestSummaryReport_SchemaMissing はテーブルがない場合にErrSchemaMissingを返すことをテストします
+func TestSummaryReport_SchemaMissing(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\)`).WillReturnError(noSuchTableError())
+
+	var buf bytes.Buffer
+	err := SummaryReport(db, &buf)
+
+	assert.True(t, errors.Is(err, ErrSchemaMissing), "ErrSchemaMissingを返すべき: %v", err)
+	assert.Empty(t, buf.String(), "失敗した場合は何も出力しないべき")
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,209 @@
+//go:build !nomysql
+
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	// テスト用データベース名の接頭辞。後続に作成時刻(UNIX秒)と乱数が続く
+	testDatabasePrefix = "dbmock_test_"
+	// この時間より古いテスト用データベースはクラッシュ時の残骸として削除する
+	testDatabaseMaxAge = time.Hour
+)
+
+// rootTestDSN はテスト用コンテナにrootで接続するDSNを返します。
+func rootTestDSN() string {
+	return fmt.Sprintf("root:root@tcp(%s:%s)/?parseTime=true&timeout=10s", testDBHost, testDBPort)
+}
+
+// newTestDatabaseName は作成時刻と乱数を含む一意なテスト用データベース名を生成します。
+func newTestDatabaseName(now time.Time) (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d_%s", testDatabasePrefix, now.Unix(), hex.EncodeToString(buf)), nil
+}
+
+// staleTestDatabases はデータベース名の一覧から、maxAgeより古いテスト用データベースを抽出します。
+// 名前の形式が想定と異なるものは削除対象にしません。
+func staleTestDatabases(names []string, now time.Time, maxAge time.Duration) []string {
+	var stale []string
+	for _, name := range names {
+		rest, ok := strings.CutPrefix(name, testDatabasePrefix)
+		if !ok {
+			continue
+		}
+		created, _, ok := strings.Cut(rest, "_")
+		if !ok {
+			continue
+		}
+		sec, err := strconv.ParseInt(created, 10, 64)
+		if err != nil {
+			continue
+		}
+		if now.Sub(time.Unix(sec, 0)) > maxAge {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
+
+// sweepTestDatabases は過去の実行で残ったテスト用データベースをベストエフォートで削除します。
+func sweepTestDatabases(t *testing.T, root *sql.DB) {
+	rows, err := root.Query("SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME LIKE 'dbmock\\_test\\_%'")
+	if err != nil {
+		t.Logf("テスト用データベースの一覧取得に失敗: %v", err)
+		return
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	for _, name := range staleTestDatabases(names, time.Now(), testDatabaseMaxAge) {
+		if _, err := root.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", name)); err != nil {
+			t.Logf("古いテスト用データベース %s の削除に失敗: %v", name, err)
+		}
+	}
+}
+
+// CreateTestDatabase は一意な名前のテスト用データベースを作成し、接続可能なDSNを返します。
+// テストユーザーへの権限付与とEnsureSchemaまで行い、データベースはt.Cleanupで（パニック時も）削除されます。
+func CreateTestDatabase(t *testing.T, rootDSN string) string {
+	t.Helper()
+
+	root, err := sql.Open("mysql", rootDSN)
+	if err != nil {
+		t.Fatalf("root接続エラー: %v", err)
+	}
+	sweepTestDatabases(t, root)
+
+	name, err := newTestDatabaseName(time.Now())
+	if err != nil {
+		root.Close()
+		t.Fatalf("データベース名の生成エラー: %v", err)
+	}
+	if _, err := root.Exec(fmt.Sprintf("CREATE DATABASE `%s`", name)); err != nil {
+		root.Close()
+		t.Fatalf("テスト用データベース作成エラー: %v", err)
+	}
+	t.Cleanup(func() {
+		defer root.Close()
+		if _, err := root.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", name)); err != nil {
+			t.Logf("テスト用データベース %s の削除に失敗: %v", name, err)
+		}
+	})
+
+	if _, err := root.Exec(fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO '%s'@'%%'", name, testDBUser)); err != nil {
+		t.Fatalf("権限付与エラー: %v", err)
+	}
+
+	cfg := mysql.NewConfig()
+	cfg.User = testDBUser
+	cfg.Passwd = testDBPassword
+	cfg.Net = "tcp"
+	cfg.Addr = testDBHost + ":" + testDBPort
+	cfg.DBName = name
+	cfg.ParseTime = true
+	dsn := cfg.FormatDSN()
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatalf("テスト用データベース接続エラー: %v", err)
+	}
+	defer db.Close()
+	if err := EnsureSchema(db); err != nil {
+		t.Fatalf("スキーマ作成エラー: %v", err)
+	}
+	return dsn
+}
+
+// TestStaleTestDatabases は古いテスト用データベースの判定をテストします
+func TestStaleTestDatabases(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	names := []string{
+		fmt.Sprintf("dbmock_test_%d_aaaa", now.Add(-2*time.Hour).Unix()),
+		fmt.Sprintf("dbmock_test_%d_bbbb", now.Add(-10*time.Minute).Unix()),
+		"dbmock_test_invalid_cccc",
+		"dbmock_test_",
+		"test_db",
+	}
+
+	stale := staleTestDatabases(names, now, time.Hour)
+
+	assert.Equal(t, []string{names[0]}, stale, "1時間より古いものだけが削除対象になるべき")
+}
+
+// TestNewTestDatabaseName は生成された名前が一意で判定可能な形式であることをテストします
+func TestNewTestDatabaseName(t *testing.T) {
+	now := time.Now()
+	first, err := newTestDatabaseName(now)
+	assert.NoError(t, err, "名前の生成は成功するべき")
+	second, err := newTestDatabaseName(now)
+	assert.NoError(t, err, "名前の生成は成功するべき")
+
+	assert.NotEqual(t, first, second, "同時刻でも名前は一意であるべき")
+	assert.True(t, strings.HasPrefix(first, testDatabasePrefix), "接頭辞が付くべき")
+	assert.Empty(t, staleTestDatabases([]string{first}, now, time.Hour), "作成直後は削除対象にならないべき")
+	assert.Equal(t, []string{first}, staleTestDatabases([]string{first}, now.Add(2*time.Hour), time.Hour),
+		"時間が経過すれば削除対象になるべき")
+}
+
+// TestIntegrationCreateTestDatabase はテスト用データベースの作成・削除とスイープをコンテナで検証します
+func TestIntegrationCreateTestDatabase(t *testing.T) {
+	_, cleanup := setupIntegrationTest(t)
+	defer cleanup()
+
+	root, err := sql.Open("mysql", rootTestDSN())
+	if err != nil {
+		t.Fatalf("root接続エラー: %v", err)
+	}
+	defer root.Close()
+
+	schemaExists := func(name string) bool {
+		var count int
+		err := root.QueryRow("SELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?", name).Scan(&count)
+		assert.NoError(t, err, "スキーマ確認は成功すべき")
+		return count > 0
+	}
+
+	// クラッシュした過去の実行の残骸を用意
+	leftover := fmt.Sprintf("dbmock_test_%d_dead", time.Now().Add(-2*time.Hour).Unix())
+	_, err = root.Exec(fmt.Sprintf("CREATE DATABASE `%s`", leftover))
+	assert.NoError(t, err, "残骸データベースの作成は成功すべき")
+
+	var created string
+	t.Run("作成して利用できる", func(t *testing.T) {
+		dsn := CreateTestDatabase(t, rootTestDSN())
+		cfg, err := mysql.ParseDSN(dsn)
+		assert.NoError(t, err, "DSNは解析可能であるべき")
+		created = cfg.DBName
+
+		db, err := sql.Open("mysql", dsn)
+		assert.NoError(t, err, "テスト用データベースに接続できるべき")
+		defer db.Close()
+
+		assert.NoError(t, UpsertStock(db, "apple", 10), "スキーマ作成済みで書き込めるべき")
+		assert.True(t, schemaExists(created), "テスト中はデータベースが存在すべき")
+	})
+
+	assert.False(t, schemaExists(created), "サブテスト終了後にデータベースは削除されるべき")
+	assert.False(t, schemaExists(leftover), "古い残骸はスイープで削除されるべき")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidBucket は時系列の集計間隔に0以下が指定された場合に返されます。
+var ErrInvalidBucket = errors.New("集計の間隔は0より大きくする必要があります")
+
+// TimePoint は時系列の1点です。Timeは区間の開始時刻、Amountはその区間の終わりの在庫数です。
+type TimePoint struct {
+	Time   time.Time
+	Amount int
+}
+
+// StockTimeSeries はstock_logの変更量を積み上げ、since以降の指定商品の在庫数をbucketごとの時系列で返します。
+// 最初の点はsinceから始まり、最後の点はsince以降の最後の変更を含む区間です。変更のない区間は直前の在庫数を引き継ぎます。
+// since以前の在庫数はその時点の最後の変更後の在庫数を起点とし、BackfillHistoryで記録した起点の行はその在庫数から数え直します。
+// 商品の変更履歴が1件もない場合は空のスライスを返します。
+func StockTimeSeries(db *sql.DB, name string, since time.Time, bucket time.Duration) ([]TimePoint, error) {
+	return StockTimeSeriesContext(context.Background(), db, name, since, bucket)
+}
+
+// StockTimeSeriesContext はStockTimeSeriesのcontext対応版です。
+func StockTimeSeriesContext(ctx context.Context, db *sql.DB, name string, since time.Time, bucket time.Duration) ([]TimePoint, error) {
+	if bucket <= 0 {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBucket, bucket)
+	}
+
+	total, hasBaseline, err := stockAmountBefore(ctx, db, name, since)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT operation, delta, amount, created_at FROM stock_log WHERE name = ? AND created_at >= ? ORDER BY created_at, id;"
+	rows, err := db.QueryContext(ctx, query, name, since)
+	if err != nil {
+		return nil, fmt.Errorf("変更履歴の取得エラー: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	points := []TimePoint{}
+	if hasBaseline {
+		points = append(points, TimePoint{Time: since, Amount: total})
+	}
+	for rows.Next() {
+		var (
+			operation string
+			delta     int
+			amount    int
+			createdAt time.Time
+		)
+		if err := rows.Scan(&operation, &delta, &amount, &createdAt); err != nil {
+			return nil, fmt.Errorf("変更履歴の取得エラー: %w", err)
+		}
+		total = addLedgerRow(total, operation, delta, amount)
+
+		// 変更のあった区間まで、直前の在庫数で空の区間を埋める
+		index := int(createdAt.Sub(since) / bucket)
+		for len(points) <= index {
+			carried := 0
+			if len(points) > 0 {
+				carried = points[len(points)-1].Amount
+			}
+			points = append(points, TimePoint{Time: since.Add(time.Duration(len(points)) * bucket), Amount: carried})
+		}
+		points[index].Amount = total
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("変更履歴の取得エラー: %w", err)
+	}
+	return points, nil
+}
+
+// stockAmountBefore はsinceより前の最後の変更後の在庫数を返します。変更がない場合はfalseを返します。
+func stockAmountBefore(ctx context.Context, db *sql.DB, name string, since time.Time) (int, bool, error) {
+	var amount int
+	query := "SELECT amount FROM stock_log WHERE name = ? AND created_at < ? ORDER BY created_at DESC, id DESC LIMIT 1;"
+	err := db.QueryRowContext(ctx, query, name, since).Scan(&amount)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, fmt.Errorf("変更履歴の取得エラー: %w", classifyError(err))
+	}
+	return amount, true, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syntheticLedgerRows はsinceから一定間隔で一定量ずつ入庫する台帳の行を返します
+func syntheticLedgerRows(since time.Time, interval time.Duration, delta, count int) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"operation", "delta", "amount", "created_at"})
+	amount := 0
+	for i := 0; i < count; i++ {
+		amount += delta
+		rows.AddRow(operationUpdate, delta, amount, since.Add(time.Duration(i)*interval))
+	}
+	return rows
+}
+
+// expectAmountBefore はsinceより前の在庫数の問い合わせを登録します。amountがnilの場合は変更がない結果を返します
+func expectAmountBefore(mock sqlmock.Sqlmock, since time.Time, amount *int) {
+	rows := sqlmock.NewRows([]string{"amount"})
+	if amount != nil {
+		rows.AddRow(*amount)
+	}
+	mock.ExpectQuery(`SELECT amount FROM stock_log WHERE name = \? AND created_at < \? ORDER BY created_at DESC, id DESC LIMIT 1;`).
+		WithArgs("apple", since).
+		WillReturnRows(rows)
+}
+
+const timeSeriesQuery = `SELECT operation, delta, amount, created_at FROM stock_log WHERE name = \? AND created_at >= \? ORDER BY created_at, id;`
+
+// TestStockTimeSeries_Monotonic は一定量ずつ入庫する台帳から単調増加の時系列が得られることをテストします
+func TestStockTimeSeries_Monotonic(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	since := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	expectAmountBefore(mock, since, nil)
+	mock.ExpectQuery(timeSeriesQuery).
+		WithArgs("apple", since).
+		WillReturnRows(syntheticLedgerRows(since, 15*time.Minute, 10, 12))
+
+	points, err := StockTimeSeries(db, "apple", since, time.Hour)
+
+	require.NoError(t, err)
+	assert.Equal(t, []TimePoint{
+		{Time: since, Amount: 40},
+		{Time: since.Add(time.Hour), Amount: 80},
+		{Time: since.Add(2 * time.Hour), Amount: 120},
+	}, points, "1時間ごとの在庫数が期待通りであるべき")
+	for i := 1; i < len(points); i++ {
+		assert.Greater(t, points[i].Amount, points[i-1].Amount, "入庫のみの台帳では単調増加になるべき")
+	}
+	verifyExpectations(t, mock)
+}
+
+// TestStockTimeSeries_Baseline はsince以前の在庫数を起点に積み上げ、変更のない区間を埋めることをテストします
+func TestStockTimeSeries_Baseline(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	since := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	baseline := 100
+	expectAmountBefore(mock, since, &baseline)
+	mock.ExpectQuery(timeSeriesQuery).
+		WithArgs("apple", since).
+		WillReturnRows(sqlmock.NewRows([]string{"operation", "delta", "amount", "created_at"}).
+			AddRow(operationUpdate, -30, 70, since.Add(90*time.Minute)).
+			AddRow(operationUpdate, 5, 75, since.Add(3*time.Hour)))
+
+	points, err := StockTimeSeries(db, "apple", since, time.Hour)
+
+	require.NoError(t, err)
+	assert.Equal(t, []TimePoint{
+		{Time: since, Amount: 100},
+		{Time: since.Add(time.Hour), Amount: 70},
+		{Time: since.Add(2 * time.Hour), Amount: 70},
+		{Time: since.Add(3 * time.Hour), Amount: 75},
+	}, points, "変更のない区間は直前の在庫数を引き継ぐべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStockTimeSeries_InitialRow はBackfillHistoryで記録した起点の行から数え直すことをテストします
+func TestStockTimeSeries_InitialRow(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	since := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	expectAmountBefore(mock, since, nil)
+	mock.ExpectQuery(timeSeriesQuery).
+		WithArgs("apple", since).
+		WillReturnRows(sqlmock.NewRows([]string{"operation", "delta", "amount", "created_at"}).
+			AddRow(operationInitial, 0, 500, since.Add(10*time.Minute)).
+			AddRow(operationUpdate, 20, 520, since.Add(70*time.Minute)))
+
+	points, err := StockTimeSeries(db, "apple", since, time.Hour)
+
+	require.NoError(t, err)
+	assert.Equal(t, []TimePoint{
+		{Time: since, Amount: 500},
+		{Time: since.Add(time.Hour), Amount: 520},
+	}, points, "起点の行の在庫数から積み上げるべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStockTimeSeries_NoHistory は変更履歴がない場合に空の時系列を返すことをテストします
+func TestStockTimeSeries_NoHistory(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	since := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	expectAmountBefore(mock, since, nil)
+	mock.ExpectQuery(timeSeriesQuery).
+		WithArgs("apple", since).
+		WillReturnRows(sqlmock.NewRows([]string{"operation", "delta", "amount", "created_at"}))
+
+	points, err := StockTimeSeries(db, "apple", since, time.Hour)
+
+	require.NoError(t, err)
+	assert.NotNil(t, points, "nilではなく空のスライスを返すべき")
+	assert.Empty(t, points)
+	verifyExpectations(t, mock)
+}
+
+// TestStockTimeSeries_InvalidBucket は集計間隔が0以下の場合にDBへ問い合わせずにエラーを返すことをテストします
+func TestStockTimeSeries_InvalidBucket(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	_, err := StockTimeSeries(db, "apple", time.Now(), 0)
+
+	assert.True(t, errors.Is(err, ErrInvalidBucket), "ErrInvalidBucketが返るべき")
+	verifyExpectations(t, mock)
+}
+
+// TestStockTimeSeries_QueryError はクエリエラーが返ることをテストします
+func TestStockTimeSeries_QueryError(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	since := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT amount FROM stock_log`).
+		WillReturnError(sql.ErrConnDone)
+
+	_, err := StockTimeSeries(db, "apple", since, time.Hour)
+
+	assert.True(t, errors.Is(err, sql.ErrConnDone), "元のエラーを保持しているべき")
+	verifyExpectations(t, mock)
+}
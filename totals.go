@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// stockTotalsTableDDL は在庫数の合計をキャッシュする1行だけのstock_totalsテーブルを作成するDDLです。
+// 合計は常にid = 1の行に保持します。
+const stockTotalsTableDDL = `
+CREATE TABLE IF NOT EXISTS stock_totals (
+    id TINYINT PRIMARY KEY,
+    total BIGINT NOT NULL DEFAULT 0
+);`
+
+// recordStockTotal はcachedTotalEnabledが有効な場合に、在庫の変更量をトランザクション内でstock_totalsへ加算します。
+// 在庫の変更と同じトランザクションで更新するため、キャッシュした合計がstocksとずれることはありません。
+func recordStockTotal(ctx context.Context, tx *sql.Tx, delta int) error {
+	if !cachedTotalEnabled {
+		return nil
+	}
+	query := "INSERT INTO stock_totals (id, total) VALUES (1, ?) ON DUPLICATE KEY UPDATE total = total + VALUES(total);"
+	if _, err := tx.ExecContext(ctx, query, delta); err != nil {
+		return fmt.Errorf("在庫数の合計の更新エラー: %w", err)
+	}
+	return nil
+}
+
+// GetCachedTotal はstock_totalsにキャッシュされた在庫数の合計を返します。
+// TotalStockAmountと異なりstocksテーブルを走査しません。まだ記録がない場合は0を返します。
+func GetCachedTotal(db *sql.DB) (int64, error) {
+	return GetCachedTotalContext(context.Background(), db)
+}
+
+// GetCachedTotalContext はGetCachedTotalのcontext対応版です。
+func GetCachedTotalContext(ctx context.Context, db *sql.DB) (int64, error) {
+	var total int64
+	err := db.QueryRowContext(ctx, "SELECT total FROM stock_totals WHERE id = 1;").Scan(&total)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("在庫数の合計の取得エラー: %w", classifyError(err))
+	}
+	return total, nil
+}
+
+// RebuildCachedTotal はstocksテーブルの在庫数を集計し直してstock_totalsを置き換えます。
+// cachedTotalEnabledを有効にする前から存在するデータを反映する場合に使います。
+func RebuildCachedTotal(db *sql.DB) (int64, error) {
+	return RebuildCachedTotalContext(context.Background(), db)
+}
+
+// RebuildCachedTotalContext はRebuildCachedTotalのcontext対応版です。
+func RebuildCachedTotalContext(ctx context.Context, db *sql.DB) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+	defer tx.Rollback() // エラー発生時にロールバック
+
+	// 集計中の変更と競合しないよう、stocksの行をロックして集計する
+	var total int64
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM stocks FOR UPDATE;").Scan(&total); err != nil {
+		return 0, fmt.Errorf("在庫数の集計エラー: %w", classifyError(err))
+	}
+	query := "INSERT INTO stock_totals (id, total) VALUES (1, ?) ON DUPLICATE KEY UPDATE total = VALUES(total);"
+	if _, err := tx.ExecContext(ctx, query, total); err != nil {
+		return 0, fmt.Errorf("在庫数の合計の更新エラー: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("トランザクションコミットエラー: %w", err)
+	}
+	return total, nil
+}
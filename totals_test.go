@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// withCachedTotal はテスト中だけ在庫数の合計のキャッシュを有効にします
+func withCachedTotal(t *testing.T) {
+	original := cachedTotalEnabled
+	cachedTotalEnabled = true
+	t.Cleanup(func() { cachedTotalEnabled = original })
+}
+
+// TestUpsertStock_UpdatesCachedTotal は在庫の変更と同じトランザクションでstock_totalsが更新されることをテストします
+func TestUpsertStock_UpdatesCachedTotal(t *testing.T) {
+	withCachedTotal(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	// 新規登録
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("apple").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+		WithArgs("apple", 100).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO stock_totals \(id, total\) VALUES \(1, \?\) ON DUPLICATE KEY UPDATE total = total \+ VALUES\(total\);`).
+		WithArgs(100).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// 既存商品の更新（出庫）
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+		WithArgs(70, "apple").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stock_totals`).
+		WithArgs(-30).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	mock.ExpectQuery(`SELECT total FROM stock_totals WHERE id = 1;`).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(70))
+
+	assert.NoError(t, UpsertStock(db, "apple", 100))
+	assert.NoError(t, UpsertStock(db, "apple", -30))
+	total, err := GetCachedTotal(db)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(70), total)
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_CachedTotalErrorRollsBack はstock_totalsの更新に失敗した場合に在庫の変更もロールバックされることをテストします
+func TestUpsertStock_CachedTotalErrorRollsBack(t *testing.T) {
+	withCachedTotal(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(100))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+		WithArgs(150, "apple").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stock_totals`).
+		WithArgs(50).
+		WillReturnError(errors.New("lock wait timeout"))
+	mock.ExpectRollback()
+
+	err := UpsertStock(db, "apple", 50)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "在庫数の合計の更新エラー")
+	verifyExpectations(t, mock)
+}
+
+// TestBulkUpsertStocks_UpdatesCachedTotal は一括更新でも商品ごとにstock_totalsが更新されることをテストします
+func TestBulkUpsertStocks_UpdatesCachedTotal(t *testing.T) {
+	withCachedTotal(t)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE`).
+		WithArgs("apple").
+		WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(10))
+	mock.ExpectExec(`UPDATE stocks SET amount = \? WHERE name = \?;`).
+		WithArgs(15, "apple").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO stock_totals`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \? FOR UPDATE`).
+		WithArgs("banana").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+		WithArgs("banana", 20).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec(`INSERT INTO stock_totals`).
+		WithArgs(20).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	_, err := BulkUpsertStocks(db, []StockUpdate{{Name: "apple", Amount: 5}, {Name: "banana", Amount: 20}})
+
+	assert.NoError(t, err)
+	verifyExpectations(t, mock)
+}
+
+// TestUpsertStock_CachedTotalDisabled は既定ではstock_totalsを更新しないことをテストします
+func TestUpsertStock_CachedTotalDisabled(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT amount FROM stocks WHERE name = \?`).
+		WithArgs("apple").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO stocks \(name, amount\) VALUES \(\?, \?\);`).
+		WithArgs("apple", 100).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, UpsertStock(db, "apple", 100))
+	verifyExpectations(t, mock)
+}
+
+// TestGetCachedTotal_NoRow はまだ記録がない場合に0を返すことをテストします
+func TestGetCachedTotal_NoRow(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT total FROM stock_totals WHERE id = 1;`).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}))
+
+	total, err := GetCachedTotal(db)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+	verifyExpectations(t, mock)
+}
+
+func TestRebuildCachedTotal(t *testing.T) {
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\) FROM stocks FOR UPDATE;`).
+		WillReturnRows(sqlmock.NewRows([]string{"total"}).AddRow(350))
+	mock.ExpectExec(`INSERT INTO stock_totals \(id, total\) VALUES \(1, \?\) ON DUPLICATE KEY UPDATE total = VALUES\(total\);`).
+		WithArgs(int64(350)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	total, err := RebuildCachedTotal(db)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(350), total)
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrTransactionTooLong はトランザクションがtxHardThresholdを超えて開いていたため打ち切られた場合に返されます。
+var ErrTransactionTooLong = errors.New("トランザクションが長時間開いていたため打ち切りました")
+
+// TxWatchdogWarning は長時間開いているトランザクションの警告です。
+type TxWatchdogWarning struct {
+	// OperationID はctxに設定されていた操作IDです（WithOperationID）。
+	OperationID string
+	// Elapsed はトランザクションを開始してからの経過時間です。
+	Elapsed time.Duration
+	// Statements はトランザクションの開始以降にrecentQueriesに記録されたクエリです。
+	// 記録は全体で共有しているため、同時に実行された他の処理のクエリが含まれることがあります。
+	Statements []QueryInfo
+	// Cancelled はtxHardThresholdを超えてトランザクションを打ち切った場合にtrueです。
+	Cancelled bool
+}
+
+// txWatchdogClock はトランザクションの監視に使うタイマーを作成します。テストでは時刻を進められる実装に差し替えます。
+var txWatchdogClock batchClock = realBatchClock{}
+
+// txWatchdogNotify は警告の通知先です。nilの場合はログに出力します。
+var txWatchdogNotify func(TxWatchdogWarning)
+
+// WithTransaction はトランザクションを開始してfnを実行し、fnが成功すればコミット、エラーを返せばロールバックします。
+// fnにはトランザクションのcontextを渡すため、fnの中のクエリはこのctxで実行してください。
+//
+// DBConfig.TxTimeoutが設定されている場合は、BeginTxのcontextにその期限を設けます。期限を超えると実行中の文を打ち切ってロールバックし、
+// context.DeadlineExceededを含むエラーを返します。
+//
+// トランザクションが開いている間は監視し、txSoftThresholdを超えると操作ID、それまでに実行したクエリと経過時間を警告します。
+// txHardCancelが有効であれば、txHardThresholdを超えた時点でcontextをキャンセルしてロールバックさせ、ErrTransactionTooLongを返します。
+// 行ロックを保持したまま止まったトランザクションが、他の処理（夜間の取り込みなど）を待たせ続けることを防ぎます。
+//
+// デッドロックなどで失敗した場合は、DBConfig.Retry.Deadlockの設定に従ってトランザクション全体をやり直します。
+// やり直す場合はfnを再び呼び出すため、fnはトランザクションの外に結果を残す場合も繰り返し実行できるようにしてください。
+func WithTransaction(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return Do(ctx, retryPolicy(retryDeadlock), func(ctx context.Context) error {
+		return runTransaction(ctx, db, fn)
+	})
+}
+
+// runTransaction はWithTransactionの1回分のトランザクションを実行します。
+func runTransaction(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancelTimeout := withTxTimeout(ctx)
+	defer cancelTimeout()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("トランザクション開始エラー: %w", err)
+	}
+	stop := watchTransaction(ctx, cancel)
+	defer stop()
+
+	if err := fn(ctx, tx); err != nil {
+		tx.Rollback()
+		return txError(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return txError(ctx, fmt.Errorf("トランザクションコミットエラー: %w", err))
+	}
+	return nil
+}
+
+// withTxTimeout はDBConfig.TxTimeoutが設定されていれば、その期限を設けたctxを返します。
+func withTxTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := currentDBConfig().TxTimeout
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w: トランザクションの期限 %s を超えました", context.DeadlineExceeded, timeout))
+}
+
+// txError はトランザクションが打ち切られていた場合に、errに打ち切った理由（ErrTransactionTooLongまたはcontext.DeadlineExceeded）を加えます。
+// ドライバは打ち切られた文のエラーをctxのエラーとは別の値で返すことがあるため、理由はctxから取り出します。
+func txError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, ErrTransactionTooLong) || errors.Is(cause, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", cause, err)
+	}
+	return err
+}
+
+// watchTransaction はトランザクションの監視を開始し、監視を止める関数を返します。
+// タイマーは期限まで待つゴルーチンを持たないため、止めた後に残るものはありません。
+func watchTransaction(ctx context.Context, cancel context.CancelCauseFunc) (stop func()) {
+	seq := recentQueries.Seq()
+	warn := func(elapsed time.Duration, cancelled bool) {
+		warning := TxWatchdogWarning{Elapsed: elapsed, Statements: recentQueries.Since(seq), Cancelled: cancelled}
+		warning.OperationID, _ = operationIDFrom(ctx)
+		notifyTxWatchdog(warning)
+	}
+
+	var timers []batchTimer
+	if txSoftThreshold > 0 {
+		timers = append(timers, txWatchdogClock.AfterFunc(txSoftThreshold, func() {
+			warn(txSoftThreshold, false)
+		}))
+	}
+	if txHardCancel && txHardThreshold > 0 {
+		timers = append(timers, txWatchdogClock.AfterFunc(txHardThreshold, func() {
+			warn(txHardThreshold, true)
+			cancel(fmt.Errorf("%w: %s", ErrTransactionTooLong, txHardThreshold))
+		}))
+	}
+	return func() {
+		for _, timer := range timers {
+			timer.Stop()
+		}
+	}
+}
+
+// notifyTxWatchdog は警告をtxWatchdogNotifyに渡し、設定されていなければログに出力します。
+func notifyTxWatchdog(warning TxWatchdogWarning) {
+	if txWatchdogNotify != nil {
+		txWatchdogNotify(warning)
+		return
+	}
+	queries := make([]string, len(warning.Statements))
+	for i, s := range warning.Statements {
+		queries[i] = s.Query
+	}
+	action := "開いたままです"
+	if warning.Cancelled {
+		action = "打ち切ります"
+	}
+	log.Printf("長時間のトランザクション: 操作 %s が%s以上%s 実行したクエリ: [%s]",
+		warning.OperationID, warning.Elapsed, action, strings.Join(queries, "; "))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// txWatchdogRecorder はトランザクションの監視の警告を記録します
+type txWatchdogRecorder struct {
+	mu       sync.Mutex
+	warnings []TxWatchdogWarning
+}
+
+func (r *txWatchdogRecorder) record(w TxWatchdogWarning) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = append(r.warnings, w)
+}
+
+func (r *txWatchdogRecorder) recorded() []TxWatchdogWarning {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]TxWatchdogWarning(nil), r.warnings...)
+}
+
+// withTxWatchdog はテスト中だけ監視のしきい値を変更し、fakeBatchClockと警告の記録先を設定します
+func withTxWatchdog(t *testing.T, soft, hard time.Duration, cancel bool) (*fakeBatchClock, *txWatchdogRecorder) {
+	originalSoft, originalHard, originalCancel := txSoftThreshold, txHardThreshold, txHardCancel
+	originalClock, originalNotify := txWatchdogClock, txWatchdogNotify
+	clock, recorder := &fakeBatchClock{}, &txWatchdogRecorder{}
+	txSoftThreshold, txHardThreshold, txHardCancel = soft, hard, cancel
+	txWatchdogClock, txWatchdogNotify = clock, recorder.record
+	t.Cleanup(func() {
+		txSoftThreshold, txHardThreshold, txHardCancel = originalSoft, originalHard, originalCancel
+		txWatchdogClock, txWatchdogNotify = originalClock, originalNotify
+	})
+	return clock, recorder
+}
+
+// TestWithTransaction_SoftWarning はしきい値を超えたトランザクションを警告し、打ち切りが無効であればそのままコミットすることをテストします
+func TestWithTransaction_SoftWarning(t *testing.T) {
+	clock, recorder := withTxWatchdog(t, 30*time.Second, 10*time.Minute, false)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(queryStocksByName())).WithArgs("apple").
+		WillReturnRows(newStockRows(Stock{ID: 1, Name: "apple", Amount: 100, Category: defaultCategory}))
+	mock.ExpectCommit()
+
+	ctx := WithOperationID(context.Background(), "nightly-import")
+	err := WithTransaction(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := QueryStocksTypedContext(ctx, tx, "apple"); err != nil {
+			return err
+		}
+		// トランザクションを開いたまま時間が経過した
+		clock.Advance(time.Hour)
+		return ctx.Err()
+	})
+
+	assert.NoError(t, err, "打ち切りが無効であればコミットするべき")
+	warnings := recorder.recorded()
+	require.Len(t, warnings, 1, "しきい値を超えたら1回だけ警告するべき")
+	assert.Equal(t, "nightly-import", warnings[0].OperationID)
+	assert.Equal(t, 30*time.Second, warnings[0].Elapsed)
+	assert.False(t, warnings[0].Cancelled)
+	require.NotEmpty(t, warnings[0].Statements, "実行したクエリが含まれるべき")
+	assert.Equal(t, queryStocksByName(), warnings[0].Statements[len(warnings[0].Statements)-1].Query)
+	verifyExpectations(t, mock)
+}
+
+// TestWithTransaction_HardCancel は打ち切りが有効な場合、txHardThresholdを超えたトランザクションをロールバックさせることをテストします
+func TestWithTransaction_HardCancel(t *testing.T) {
+	clock, recorder := withTxWatchdog(t, 30*time.Second, 10*time.Minute, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	err := WithTransaction(context.Background(), db, func(ctx context.Context, tx *sql.Tx) error {
+		clock.Advance(10 * time.Minute)
+		// 打ち切られた後のクエリはcontextのエラーになる
+		_, err := tx.ExecContext(ctx, "UPDATE stocks SET amount = 0;")
+		return err
+	})
+
+	assert.ErrorIs(t, err, ErrTransactionTooLong)
+	assert.ErrorIs(t, err, context.Canceled, "fnのエラーも保持するべき")
+	warnings := recorder.recorded()
+	require.Len(t, warnings, 2, "警告と打ち切りの両方を通知するべき")
+	assert.False(t, warnings[0].Cancelled)
+	assert.True(t, warnings[1].Cancelled)
+	assert.Equal(t, 10*time.Minute, warnings[1].Elapsed)
+	// database/sqlはcontextのキャンセル後に非同期でロールバックするため、完了を待って確認する
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond,
+		"打ち切られたトランザクションはロールバックされるべき")
+}
+
+// TestWithTransaction_Rollback はfnがエラーを返した場合にロールバックし、そのエラーを返すことをテストします
+func TestWithTransaction_Rollback(t *testing.T) {
+	_, recorder := withTxWatchdog(t, 30*time.Second, 10*time.Minute, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	errFailed := errors.New("failed")
+	err := WithTransaction(context.Background(), db, func(ctx context.Context, tx *sql.Tx) error {
+		return errFailed
+	})
+
+	assert.ErrorIs(t, err, errFailed)
+	assert.NotErrorIs(t, err, ErrTransactionTooLong)
+	assert.Empty(t, recorder.recorded(), "しきい値の前に終わったトランザクションは警告しないべき")
+	verifyExpectations(t, mock)
+}
+
+// TestWithTransaction_TimersStopped は正常に終わったトランザクションの監視のタイマーが止められ、
+// ゴルーチンが残らないことをテストします
+func TestWithTransaction_TimersStopped(t *testing.T) {
+	clock, recorder := withTxWatchdog(t, 30*time.Second, 10*time.Minute, true)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	const n = 50
+	for i := 0; i <= n; i++ {
+		mock.ExpectBegin()
+		mock.ExpectCommit()
+	}
+	run := func() error {
+		return WithTransaction(context.Background(), db, func(ctx context.Context, tx *sql.Tx) error {
+			return nil
+		})
+	}
+
+	// 接続の確立で増えるゴルーチンを数えないよう、1回実行してから数える
+	require.NoError(t, run())
+	before := runtime.NumGoroutine()
+	for i := 0; i < n; i++ {
+		require.NoError(t, run())
+	}
+
+	clock.mu.Lock()
+	for _, timer := range clock.timers {
+		assert.True(t, timer.stopped, "終了したトランザクションのタイマーは止められるべき")
+	}
+	clock.mu.Unlock()
+	clock.Advance(time.Hour)
+	assert.Empty(t, recorder.recorded(), "終了したトランザクションは警告しないべき")
+	assert.Eventually(t, func() bool { return runtime.NumGoroutine() <= before }, time.Second, 10*time.Millisecond,
+		"トランザクションごとのゴルーチンが残らないべき")
+	verifyExpectations(t, mock)
+}
+
+// withDBTxTimeout はテスト中だけトランザクション全体の期限を変更します
+func withDBTxTimeout(t *testing.T, timeout time.Duration) {
+	original := dbTxTimeout
+	dbTxTimeout = timeout
+	t.Cleanup(func() { dbTxTimeout = original })
+}
+
+// TestWithTransaction_TxTimeout は期限を超えて実行中の文を打ち切ってロールバックし、期限切れのエラーを返すことをテストします
+func TestWithTransaction_TxTimeout(t *testing.T) {
+	withDBTxTimeout(t, 50*time.Millisecond)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE stocks SET amount = 0;")).WillDelayFor(time.Second).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	start := time.Now()
+	err := WithTransaction(context.Background(), db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE stocks SET amount = 0;")
+		return err
+	})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "期限で文を打ち切るべき")
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond,
+		"期限を超えたトランザクションはロールバックされるべき")
+}
+
+// TestUpsertStock_TxTimeout はUpsertStockContextのトランザクションにも期限を設けることをテストします
+func TestUpsertStock_TxTimeout(t *testing.T) {
+	withDBTxTimeout(t, 50*time.Millisecond)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	expectStockAmount(mock, "apple").WillReturnRows(sqlmock.NewRows([]string{"amount"}).AddRow(10))
+	mock.ExpectBegin()
+	expectUpdateAmount(mock, "apple", 15).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	err := UpsertStockContext(context.Background(), db, "apple", 5)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond,
+		"期限を超えたトランザクションはロールバックされるべき")
+}
+
+// TestWithTransaction_NoTxTimeout は期限を設定しない場合は時間のかかる文もそのまま完了させることをテストします
+func TestWithTransaction_NoTxTimeout(t *testing.T) {
+	withDBTxTimeout(t, 0)
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE stocks SET amount = 0;")).WillDelayFor(50 * time.Millisecond).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := WithTransaction(context.Background(), db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE stocks SET amount = 0;")
+		return err
+	})
+
+	assert.NoError(t, err)
+	verifyExpectations(t, mock)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxNameLength はstocks.name列（VARCHAR(255)）に格納できる最大文字数です。
+const maxNameLength = 255
+
+var (
+	// ErrInvalidName は商品名が空、または長すぎる場合に返されます。
+	ErrInvalidName = errors.New("商品名が不正です")
+	// ErrNameRejected は商品名が許可・拒否ルールによって拒否された場合に返されます。
+	ErrNameRejected = errors.New("商品名がルールにより拒否されました")
+)
+
+// NameRejectedError は拒否された商品名と、一致したルールを保持するエラーです。
+// errors.Is(err, ErrNameRejected) で判定できます。
+type NameRejectedError struct {
+	Name string
+	Rule string
+}
+
+func (e *NameRejectedError) Error() string {
+	return fmt.Sprintf("%v: %q (ルール: %s)", ErrNameRejected, e.Name, e.Rule)
+}
+
+func (e *NameRejectedError) Unwrap() error {
+	return ErrNameRejected
+}
+
+// NameRules は書き込み時に商品名へ適用する許可・拒否ルールです。
+// 正規表現は設定読み込み時に一度だけコンパイルされます。
+type NameRules struct {
+	allow       *regexp.Regexp
+	deny        *regexp.Regexp
+	maxNewNames int
+}
+
+// activeNameRules はLoadNameRulesで読み込まれた、書き込み時に適用するルールです。nilの場合はルールなし。
+var activeNameRules *NameRules
+
+// NewNameRules は許可パターン・拒否パターン・1回の取り込みあたりの新規商品名の上限からNameRulesを生成します。
+// パターンが空の場合はそのルールを適用せず、maxNewNamesが0以下の場合は上限なしとします。
+func NewNameRules(allowPattern, denyPattern string, maxNewNames int) (*NameRules, error) {
+	rules := &NameRules{maxNewNames: maxNewNames}
+	if allowPattern != "" {
+		re, err := regexp.Compile(allowPattern)
+		if err != nil {
+			return nil, fmt.Errorf("許可パターンが不正です (%s): %w", allowPattern, err)
+		}
+		rules.allow = re
+	}
+	if denyPattern != "" {
+		re, err := regexp.Compile(denyPattern)
+		if err != nil {
+			return nil, fmt.Errorf("拒否パターンが不正です (%s): %w", denyPattern, err)
+		}
+		rules.deny = re
+	}
+	return rules, nil
+}
+
+// LoadNameRules は設定値からNameRulesを生成し、書き込み時に適用されるよう登録します。
+func LoadNameRules() error {
+	rules, err := NewNameRules(nameAllowPattern, nameDenyPattern, maxNewNamesPerImport)
+	if err != nil {
+		return err
+	}
+	activeNameRules = rules
+	return nil
+}
+
+// Check は商品名が拒否パターンに一致せず、許可パターンに一致することを確認します。
+func (r *NameRules) Check(name string) error {
+	if r == nil {
+		return nil
+	}
+	if r.deny != nil && r.deny.MatchString(name) {
+		return &NameRejectedError{Name: name, Rule: "deny " + r.deny.String()}
+	}
+	if r.allow != nil && !r.allow.MatchString(name) {
+		return &NameRejectedError{Name: name, Rule: "allow " + r.allow.String()}
+	}
+	return nil
+}
+
+// NewBudget は1回の取り込みで使う新規商品名の予算を返します。
+func (r *NameRules) NewBudget() *NameBudget {
+	budget := &NameBudget{seen: make(map[string]struct{})}
+	if r != nil {
+		budget.limit = r.maxNewNames
+	}
+	return budget
+}
+
+// NameBudget は1回の取り込みで作成される新規商品名の種類数を制限し、拒否した件数を数えます。
+type NameBudget struct {
+	mu       sync.Mutex
+	limit    int
+	seen     map[string]struct{}
+	rejected int
+}
+
+// AdmitNew は新規商品名を予算に計上します。上限を超える場合はNameRejectedErrorを返します。
+// 同じ名前は一度だけ計上されます。
+func (b *NameBudget) AdmitNew(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.seen[name]; ok {
+		return nil
+	}
+	if b.limit > 0 && len(b.seen) >= b.limit {
+		b.rejected++
+		return &NameRejectedError{Name: name, Rule: fmt.Sprintf("max_new_names %d", b.limit)}
+	}
+	b.seen[name] = struct{}{}
+	return nil
+}
+
+// Reject は取り込み中にルールで拒否された行を計上します。
+func (b *NameBudget) Reject() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rejected++
+}
+
+// Rejected は拒否された行数を返します。取り込みサマリーの集計に使います。
+func (b *NameBudget) Rejected() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rejected
+}
+
+// ValidateName は書き込み前に商品名を検証します。
+// 空白のみ・長すぎる名前はErrInvalidName、NamePatternに一致しない名前や設定されたルールに反する名前はErrNameRejectedを返します。
+func ValidateName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%w: 空の商品名は登録できません", ErrInvalidName)
+	}
+	if len([]rune(name)) > maxNameLength {
+		return fmt.Errorf("%w: 商品名は%d文字以内である必要があります", ErrInvalidName, maxNameLength)
+	}
+	if NamePattern != nil && !NamePattern.MatchString(name) {
+		return &NameRejectedError{Name: name, Rule: "pattern " + NamePattern.String()}
+	}
+	return activeNameRules.Check(name)
+}
+
+// NameError はValidateNamesで検証に失敗した1件分の商品名と理由です。
+type NameError struct {
+	// Index は渡されたnamesでの位置です。同じ名前が複数回現れる場合もそれぞれを区別できます。
+	Index int
+	Name  string
+	// Err はValidateNameが返したエラーです。errors.Is(ne.Err, ErrInvalidName)などで理由を判定できます。
+	Err error
+}
+
+func (e NameError) Error() string {
+	return fmt.Sprintf("%d件目 %q: %v", e.Index+1, e.Name, e.Err)
+}
+
+func (e NameError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateNames はnamesのすべての商品名をValidateNameで検証し、失敗したものを最初の1件で止めずにnamesの順ですべて返します。
+// 大きな取り込みの前に、拒否される名前をまとめて確認するために使います。すべて有効な場合はnilを返します。
+// 新規商品名の上限（maxNewNamesPerImport）は書き込む時点の既存の商品によって決まるため、ここでは確認しません。
+func ValidateNames(names []string) []NameError {
+	var failures []NameError
+	for i, name := range names {
+		if err := ValidateName(name); err != nil {
+			failures = append(failures, NameError{Index: i, Name: name, Err: err})
+		}
+	}
+	return failures
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withNamePattern はテスト中だけNamePatternを差し替えます
+func withNamePattern(t *testing.T, pattern *regexp.Regexp) {
+	original := NamePattern
+	NamePattern = pattern
+	t.Cleanup(func() { NamePattern = original })
+}
+
+// withNameRules はテスト中だけactiveNameRulesを差し替えます
+func withNameRules(t *testing.T, rules *NameRules) {
+	original := activeNameRules
+	activeNameRules = rules
+	t.Cleanup(func() { activeNameRules = original })
+}
+
+func TestNameRules_Check(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow       string
+		deny        string
+		target      string
+		expectError bool
+		rule        string
+	}{
+		{name: "許可のみ: 一致", allow: `^[a-z]+$`, target: "apple"},
+		{name: "許可のみ: 不一致", allow: `^[a-z]+$`, target: "Apple 1", expectError: true, rule: "allow"},
+		{name: "拒否のみ: 一致しない", deny: `(?i)error`, target: "apple"},
+		{name: "拒否のみ: 一致", deny: `(?i)error`, target: "ERROR: connection refused", expectError: true, rule: "deny"},
+		{name: "両方: 許可され拒否されない", allow: `^[a-z_]+$`, deny: `^tmp_`, target: "apple"},
+		{name: "両方: 拒否が優先", allow: `^[a-z_]+$`, deny: `^tmp_`, target: "tmp_apple", expectError: true, rule: "deny"},
+		{name: "ルールなし", target: "なんでも"},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := NewNameRules(tc.allow, tc.deny, 0)
+			assert.NoError(t, err, "ルールの生成は成功するべき")
+
+			err = rules.Check(tc.target)
+			if !tc.expectError {
+				assert.NoError(t, err, "許可されるべき")
+				return
+			}
+			assert.True(t, errors.Is(err, ErrNameRejected), "ErrNameRejectedであるべき")
+			var rejected *NameRejectedError
+			if assert.True(t, errors.As(err, &rejected), "NameRejectedErrorであるべき") {
+				assert.Equal(t, tc.target, rejected.Name, "拒否された名前が保持されるべき")
+				assert.True(t, strings.HasPrefix(rejected.Rule, tc.rule), "一致したルールが保持されるべき")
+			}
+		})
+	}
+}
+
+// TestNewNameRules_InvalidPattern は不正な正規表現が設定エラーになることをテストします
+func TestNewNameRules_InvalidPattern(t *testing.T) {
+	_, err := NewNameRules(`^[a-z`, "", 0)
+	if assert.Error(t, err, "不正な許可パターンはエラーになるべき") {
+		assert.Contains(t, err.Error(), "許可パターンが不正です", "どのパターンが不正かが分かるべき")
+	}
+
+	_, err = NewNameRules("", `(`, 0)
+	if assert.Error(t, err, "不正な拒否パターンはエラーになるべき") {
+		assert.Contains(t, err.Error(), "拒否パターンが不正です", "どのパターンが不正かが分かるべき")
+	}
+}
+
+// TestNameBudget は新規商品名の上限と拒否件数をテストします
+func TestNameBudget(t *testing.T) {
+	rules, err := NewNameRules("", "", 2)
+	assert.NoError(t, err, "ルールの生成は成功するべき")
+
+	budget := rules.NewBudget()
+	assert.NoError(t, budget.AdmitNew("apple"), "1件目は許可されるべき")
+	assert.NoError(t, budget.AdmitNew("banana"), "2件目は許可されるべき")
+	assert.NoError(t, budget.AdmitNew("apple"), "同じ名前は再計上されないべき")
+
+	err = budget.AdmitNew("cherry")
+	assert.True(t, errors.Is(err, ErrNameRejected), "上限超過はErrNameRejectedであるべき")
+	assert.Contains(t, err.Error(), "max_new_names", "上限ルールが示されるべき")
+
+	budget.Reject()
+	assert.Equal(t, 2, budget.Rejected(), "拒否件数が計上されるべき")
+}
+
+// TestNameBudget_Unlimited は上限なしの場合に拒否されないことをテストします
+func TestNameBudget_Unlimited(t *testing.T) {
+	var rules *NameRules
+	budget := rules.NewBudget()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, budget.AdmitNew(name), "上限なしでは拒否されないべき")
+	}
+	assert.Equal(t, 0, budget.Rejected(), "拒否件数は0であるべき")
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		expected error
+	}{
+		{name: "通常の名前", target: "apple", expected: nil},
+		{name: "空文字", target: "", expected: ErrInvalidName},
+		{name: "空白のみ", target: "   ", expected: ErrInvalidName},
+		{name: "255文字", target: strings.Repeat("あ", 255), expected: nil},
+		{name: "256文字", target: strings.Repeat("あ", 256), expected: ErrInvalidName},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateName(tc.target)
+			if tc.expected == nil {
+				assert.NoError(t, err, "エラーが発生すべきでない")
+			} else {
+				assert.True(t, errors.Is(err, tc.expected), "期待するエラーであるべき: %v", err)
+			}
+		})
+	}
+}
+
+// TestValidateName_WithRules は登録されたルールが適用されることをテストします
+func TestValidateName_WithRules(t *testing.T) {
+	rules, err := NewNameRules("", `(?i)^error`, 0)
+	assert.NoError(t, err, "ルールの生成は成功するべき")
+	withNameRules(t, rules)
+
+	assert.NoError(t, ValidateName("apple"), "拒否パターンに一致しない名前は許可されるべき")
+	assert.True(t, errors.Is(ValidateName("ERROR: connection refused"), ErrNameRejected),
+		"拒否パターンに一致する名前は拒否されるべき")
+}
+
+// TestValidateName_NamePattern はNamePatternが設定されている場合に一致しない商品名を拒否することをテストします
+func TestValidateName_NamePattern(t *testing.T) {
+	t.Run("既定ではパターンを適用しない", func(t *testing.T) {
+		assert.Nil(t, NamePattern)
+		assert.NoError(t, ValidateName("apple"))
+	})
+
+	t.Run("パターンに一致する名前", func(t *testing.T) {
+		withNamePattern(t, regexp.MustCompile(`^[A-Z]{3}-\d{4}$`))
+		assert.NoError(t, ValidateName("ABC-1234"))
+	})
+
+	t.Run("パターンに一致しない名前", func(t *testing.T) {
+		withNamePattern(t, regexp.MustCompile(`^[A-Z]{3}-\d{4}$`))
+		err := ValidateName("apple")
+
+		var rejected *NameRejectedError
+		if assert.True(t, errors.As(err, &rejected), "NameRejectedErrorが返るべき: %v", err) {
+			assert.Equal(t, `pattern ^[A-Z]{3}-\d{4}$`, rejected.Rule)
+		}
+		assert.True(t, errors.Is(err, ErrNameRejected))
+	})
+}
+
+// TestValidateNames は最初の失敗で止めずに、検証に失敗したすべての名前を順に返すことをテストします
+func TestValidateNames(t *testing.T) {
+	withNamePattern(t, regexp.MustCompile(`^[A-Z]{3}-\d{4}$`))
+	rules, err := NewNameRules("", `^TMP-`, 0)
+	assert.NoError(t, err)
+	withNameRules(t, rules)
+
+	failures := ValidateNames([]string{"ABC-1234", "", "apple", "XYZ-0001", "TMP-0001", "apple", strings.Repeat("A", 256)})
+
+	if assert.Len(t, failures, 5) {
+		assert.Equal(t, []int{1, 2, 4, 5, 6}, []int{failures[0].Index, failures[1].Index, failures[2].Index, failures[3].Index, failures[4].Index})
+		assert.ErrorIs(t, failures[0], ErrInvalidName, "空の名前")
+		assert.ErrorIs(t, failures[1], ErrNameRejected, "パターンに一致しない名前")
+		assert.ErrorContains(t, failures[2], "deny ^TMP-", "拒否ルールに一致した名前")
+		assert.Equal(t, "apple", failures[3].Name, "同じ名前もそれぞれ報告するべき")
+		assert.ErrorIs(t, failures[4], ErrInvalidName, "長すぎる名前")
+	}
+	assert.Equal(t, `3件目 "apple": `+failures[1].Err.Error(), failures[1].Error())
+}
+
+// TestValidateNames_AllValid はすべての名前が有効な場合にnilを返すことをテストします
+func TestValidateNames_AllValid(t *testing.T) {
+	assert.Nil(t, ValidateNames([]string{"apple", "banana"}))
+	assert.Nil(t, ValidateNames(nil))
+}
+
+// TestUpsertStock_NamePattern はパターンに一致しない商品名ではDBに問い合わせずに拒否することをテストします
+func TestUpsertStock_NamePattern(t *testing.T) {
+	withNamePattern(t, regexp.MustCompile(`^[A-Z]{3}-\d{4}$`))
+	db, mock, _ := setupMockDB(t)
+	defer db.Close()
+
+	assert.True(t, errors.Is(UpsertStock(db, "apple", 10), ErrNameRejected))
+	verifyExpectations(t, mock)
+}